@@ -0,0 +1,68 @@
+package antivirus
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// clamAVChunkBytes is the size of the chunks the content is streamed in, below the default StreamMaxLength of clamd.
+const clamAVChunkBytes = 64 << 10
+
+// ClamAV scans the files with a clamd daemon, streaming them with the INSTREAM command. It implements the Scanner interface.
+type ClamAV struct {
+	address string
+	timeout time.Duration
+}
+
+// Scan streams the content to the daemon and reads its verdict, ie: "stream: OK" or "stream: Eicar-Signature FOUND".
+func (clamAV *ClamAV) Scan(name string, content []byte) (string, error) {
+	conn, err := net.DialTimeout("tcp", clamAV.address, clamAV.timeout)
+	if err != nil {
+		return "", fmt.Errorf("unable to connect to ClamAV: %s", err.Error())
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(clamAV.timeout)); err != nil {
+		return "", fmt.Errorf("unable to connect to ClamAV: %s", err.Error())
+	}
+
+	writer := bufio.NewWriter(conn)
+	writer.WriteString("zINSTREAM\x00")
+	size := make([]byte, 4)
+	for start := 0; start < len(content); start += clamAVChunkBytes {
+		end := start + clamAVChunkBytes
+		if end > len(content) {
+			end = len(content)
+		}
+		binary.BigEndian.PutUint32(size, uint32(end-start))
+		writer.Write(size)
+		writer.Write(content[start:end])
+	}
+	binary.BigEndian.PutUint32(size, 0)
+	writer.Write(size)
+	if err := writer.Flush(); err != nil {
+		return "", fmt.Errorf("unable to send %q to ClamAV: %s", name, err.Error())
+	}
+
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil {
+		return "", fmt.Errorf("unable to read ClamAV verdict of %q: %s", name, err.Error())
+	}
+	verdict := strings.TrimPrefix(strings.TrimSuffix(reply, "\x00"), "stream: ")
+	switch {
+	case verdict == "OK":
+		return "", nil
+	case strings.HasSuffix(verdict, " FOUND"):
+		return strings.TrimSuffix(verdict, " FOUND"), nil
+	default:
+		return "", fmt.Errorf("unable to scan %q with ClamAV: %s", name, verdict)
+	}
+}
+
+// NewClamAV instanciates a ClamAV scanner using the daemon listening on the address, ie: clamav:3310, each scan being bounded by the timeout.
+func NewClamAV(address string, timeout time.Duration) *ClamAV {
+	return &ClamAV{address: address, timeout: timeout}
+}
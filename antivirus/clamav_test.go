@@ -0,0 +1,152 @@
+package antivirus
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClamd is a clamd daemon reading an INSTREAM command and answering it with its reply.
+type fakeClamd struct {
+	listener net.Listener
+	reply    string
+	mu       sync.Mutex
+	command  string
+	chunks   []int
+	content  []byte
+}
+
+func newFakeClamd(t *testing.T, reply string) *fakeClamd {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to listen: %s", err.Error())
+	}
+	daemon := &fakeClamd{listener: listener, reply: reply}
+	go daemon.serve()
+
+	return daemon
+}
+
+func (daemon *fakeClamd) serve() {
+	for {
+		conn, err := daemon.listener.Accept()
+		if err != nil {
+			return
+		}
+		daemon.handle(conn)
+	}
+}
+
+func (daemon *fakeClamd) handle(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	command, err := reader.ReadString(0)
+	if err != nil {
+		return
+	}
+
+	daemon.mu.Lock()
+	defer daemon.mu.Unlock()
+	daemon.command = command
+	size := make([]byte, 4)
+	for {
+		if _, err := io.ReadFull(reader, size); err != nil {
+			return
+		}
+		length := binary.BigEndian.Uint32(size)
+		if length == 0 {
+			break
+		}
+		chunk := make([]byte, length)
+		if _, err := io.ReadFull(reader, chunk); err != nil {
+			return
+		}
+		daemon.chunks = append(daemon.chunks, int(length))
+		daemon.content = append(daemon.content, chunk...)
+	}
+	if daemon.reply != "" {
+		conn.Write([]byte(daemon.reply))
+	}
+}
+
+func (daemon *fakeClamd) address() string {
+	return daemon.listener.Addr().String()
+}
+
+func (daemon *fakeClamd) received() (string, []int, []byte) {
+	daemon.mu.Lock()
+	defer daemon.mu.Unlock()
+
+	return daemon.command, daemon.chunks, daemon.content
+}
+
+func TestClamAVScan(t *testing.T) {
+	tests := []struct {
+		name    string
+		reply   string
+		content []byte
+		chunks  []int
+		virus   string
+		err     string
+	}{
+		{name: "reports a clean file", reply: "stream: OK\x00", content: []byte("hello"), chunks: []int{5}},
+		{name: "reports a virus", reply: "stream: Eicar-Test-Signature FOUND\x00", content: []byte(`X5O!P%@AP[4\PZX54(P^)7CC)7}$EICAR`), chunks: []int{33}, virus: "Eicar-Test-Signature"},
+		{name: "streams a large file in chunks", reply: "stream: OK\x00", content: bytes.Repeat([]byte("a"), 2*clamAVChunkBytes+10), chunks: []int{clamAVChunkBytes, clamAVChunkBytes, 10}},
+		{name: "streams an empty file", reply: "stream: OK\x00", content: []byte{}},
+		{name: "fails on a daemon error", reply: "INSTREAM size limit exceeded. ERROR\x00", content: []byte("hello"), chunks: []int{5}, err: `unable to scan "invoice.pdf" with ClamAV: INSTREAM size limit exceeded. ERROR`},
+		{name: "fails without verdict", content: []byte("hello"), chunks: []int{5}, err: `unable to read ClamAV verdict of "invoice.pdf"`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			daemon := newFakeClamd(t, test.reply)
+			defer daemon.listener.Close()
+
+			virus, err := NewClamAV(daemon.address(), time.Second).Scan("invoice.pdf", test.content)
+			if test.err != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), test.err) {
+					t.Errorf("expected error %q, got %v", test.err, err)
+				}
+			} else if err != nil || virus != test.virus {
+				t.Errorf("expected virus %q, got %q, %v", test.virus, virus, err)
+			}
+
+			command, chunks, content := daemon.received()
+			if command != "zINSTREAM\x00" {
+				t.Errorf("expected the INSTREAM command, got %q", command)
+			}
+			if len(chunks) != len(test.chunks) {
+				t.Fatalf("expected chunks of %v bytes, got %v", test.chunks, chunks)
+			}
+			for i := range chunks {
+				if chunks[i] != test.chunks[i] {
+					t.Errorf("expected chunks of %v bytes, got %v", test.chunks, chunks)
+				}
+			}
+			if !bytes.Equal(content, test.content) {
+				t.Errorf("expected the daemon to receive the %d bytes, got %d", len(test.content), len(content))
+			}
+		})
+	}
+}
+
+func TestClamAVUnavailable(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to listen: %s", err.Error())
+	}
+	address := listener.Addr().String()
+	listener.Close()
+
+	if _, err := NewClamAV(address, time.Second).Scan("invoice.pdf", []byte("hello")); err == nil || !strings.HasPrefix(err.Error(), "unable to connect to ClamAV") {
+		t.Errorf("expected the connection to fail, got %v", err)
+	}
+}
@@ -0,0 +1,7 @@
+package antivirus
+
+// Scanner interface should be implemented by any service able to tell if a file is infected (ClamAV daemon, ICAP server... etc).
+type Scanner interface {
+	// Scan should return the name of the threat found in the content, or an empty string when it is clean.
+	Scan(name string, content []byte) (string, error)
+}
@@ -0,0 +1,7 @@
+package deadletter
+
+// Writer interface should be implemented by any service responsible to keep the messages which can't be sent (SQS queue, S3 bucket... etc).
+type Writer interface {
+	// Write should store the original body of the message, with the error which made it fail.
+	Write(messageID string, body string, cause error) error
+}
@@ -0,0 +1,110 @@
+package deadletter
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"path"
+	"time"
+)
+
+// FailedMessage is the archived document of a failed message.
+type FailedMessage struct {
+	MessageID string    `json:"message_id"`
+	FailedAt  time.Time `json:"failed_at"`
+	Error     string    `json:"error"`
+	Body      string    `json:"body"`
+}
+
+// S3 archives the failed messages as JSON objects of a bucket, under a prefix and the failure date. It implements the Writer interface.
+type S3 struct {
+	bucket   string
+	prefix   string
+	s3Client *s3.S3
+}
+
+// Write puts the message body and its error under prefix/YYYY/MM/DD/message-id.json.
+func (s3Writer *S3) Write(messageID string, body string, cause error) error {
+	now := time.Now().UTC()
+	document, err := json.Marshal(FailedMessage{MessageID: messageID, FailedAt: now, Error: cause.Error(), Body: body})
+	if err != nil {
+		return fmt.Errorf("unable to marshal failed message: %s", err.Error())
+	}
+
+	key := path.Join(s3Writer.prefix, now.Format("2006/01/02"), messageID+".json")
+	_, err = s3Writer.s3Client.PutObject(&s3.PutObjectInput{
+		Bucket:      aws.String(s3Writer.bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(document),
+		ContentType: aws.String("application/json"),
+	})
+	if err != nil {
+		return fmt.Errorf("unable to put failed message %s in bucket %q: %s", messageID, s3Writer.bucket, err.Error())
+	}
+
+	return nil
+}
+
+// Walk reads the messages archived under the days from since to until, in key order, and calls visit with those which failed between them.
+// It stops at the first error of visit.
+func (s3Writer *S3) Walk(since time.Time, until time.Time, visit func(message FailedMessage) error) error {
+	since, until = since.UTC(), until.UTC()
+	for day := since.Truncate(24 * time.Hour); !day.After(until); day = day.AddDate(0, 0, 1) {
+		var walkErr error
+		err := s3Writer.s3Client.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+			Bucket: aws.String(s3Writer.bucket),
+			Prefix: aws.String(path.Join(s3Writer.prefix, day.Format("2006/01/02")) + "/"),
+		}, func(page *s3.ListObjectsV2Output, _ bool) bool {
+			for _, object := range page.Contents {
+				message, err := s3Writer.read(aws.StringValue(object.Key))
+				if err == nil && (message.FailedAt.Before(since) || message.FailedAt.After(until)) {
+					continue
+				}
+				if err == nil {
+					err = visit(message)
+				}
+				if err != nil {
+					walkErr = err
+					return false
+				}
+			}
+			return true
+		})
+		if err != nil {
+			return fmt.Errorf("unable to list failed messages of bucket %q: %s", s3Writer.bucket, err.Error())
+		}
+		if walkErr != nil {
+			return walkErr
+		}
+	}
+
+	return nil
+}
+
+// read gets and decodes an archived message.
+func (s3Writer *S3) read(key string) (FailedMessage, error) {
+	var message FailedMessage
+	output, err := s3Writer.s3Client.GetObject(&s3.GetObjectInput{Bucket: aws.String(s3Writer.bucket), Key: aws.String(key)})
+	if err != nil {
+		return message, fmt.Errorf("unable to get failed message %q from bucket %q: %s", key, s3Writer.bucket, err.Error())
+	}
+	defer output.Body.Close()
+	if err := json.NewDecoder(output.Body).Decode(&message); err != nil {
+		return message, fmt.Errorf("unable to decode failed message %q: %s", key, err.Error())
+	}
+
+	return message, nil
+}
+
+// NewS3 instanciates an S3 writer archiving under the prefix of the bucket.
+func NewS3(bucket string, prefix string, region string) (*S3, error) {
+	sess, err := session.NewSession(&aws.Config{Region: aws.String(region)})
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to AWS: %s", err.Error())
+	}
+
+	return &S3{bucket: bucket, prefix: prefix, s3Client: s3.New(sess)}, nil
+}
@@ -0,0 +1,49 @@
+package deadletter
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// maxAttributeBytes bounds the error attribute, as an SQS message with its attributes can't exceed 256 KiB.
+const maxAttributeBytes = 4096
+
+// SQS sends the rejected messages to a dead-letter queue, the error and original message id as attributes. It implements the Writer interface.
+type SQS struct {
+	queueURL  string
+	sqsClient *sqs.SQS
+}
+
+// Write sends the original body to the queue.
+func (sqsWriter *SQS) Write(messageID string, body string, cause error) error {
+	reason := cause.Error()
+	if len(reason) > maxAttributeBytes {
+		reason = reason[:maxAttributeBytes]
+	}
+
+	_, err := sqsWriter.sqsClient.SendMessage(&sqs.SendMessageInput{
+		QueueUrl:    aws.String(sqsWriter.queueURL),
+		MessageBody: aws.String(body),
+		MessageAttributes: map[string]*sqs.MessageAttributeValue{
+			"error":             {DataType: aws.String("String"), StringValue: aws.String(reason)},
+			"source_message_id": {DataType: aws.String("String"), StringValue: aws.String(messageID)},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("unable to send message %s to queue %q: %s", messageID, sqsWriter.queueURL, err.Error())
+	}
+
+	return nil
+}
+
+// NewSQS instanciates an SQS writer sending to the queue.
+func NewSQS(queueURL string, region string) (*SQS, error) {
+	sess, err := session.NewSession(&aws.Config{Region: aws.String(region)})
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to AWS: %s", err.Error())
+	}
+
+	return &SQS{queueURL: queueURL, sqsClient: sqs.New(sess)}, nil
+}
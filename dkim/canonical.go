@@ -0,0 +1,87 @@
+package dkim
+
+import (
+	"bytes"
+	"strings"
+)
+
+// headerField is a raw header field of a message, with its folding kept.
+type headerField struct {
+	name string
+	raw  string
+}
+
+// splitMessage separates the header fields of a CRLF message from its body.
+func splitMessage(message []byte) ([]headerField, []byte) {
+	headerEnd := bytes.Index(message, []byte("\r\n\r\n"))
+	var headerBlock string
+	var body []byte
+	if headerEnd < 0 {
+		headerBlock = string(message)
+	} else {
+		headerBlock = string(message[:headerEnd+2])
+		body = message[headerEnd+4:]
+	}
+
+	var fields []headerField
+	for _, line := range strings.SplitAfter(headerBlock, "\r\n") {
+		if line == "" {
+			continue
+		}
+		if (line[0] == ' ' || line[0] == '\t') && len(fields) > 0 {
+			fields[len(fields)-1].raw += line
+			continue
+		}
+		name := line
+		if colon := strings.Index(line, ":"); colon >= 0 {
+			name = line[:colon]
+		}
+		fields = append(fields, headerField{name: strings.TrimSpace(name), raw: line})
+	}
+
+	return fields, body
+}
+
+// collapseWhitespace reduces every sequence of spaces and tabs to a single space.
+func collapseWhitespace(value string) string {
+	var builder strings.Builder
+	inWhitespace := false
+	for _, r := range value {
+		if r == ' ' || r == '\t' {
+			if !inWhitespace {
+				builder.WriteByte(' ')
+			}
+			inWhitespace = true
+			continue
+		}
+		inWhitespace = false
+		builder.WriteRune(r)
+	}
+
+	return builder.String()
+}
+
+// canonicalHeader applies the "relaxed" header canonicalization of RFC 6376 section 3.4.2, without trailing CRLF.
+func canonicalHeader(raw string) string {
+	colon := strings.Index(raw, ":")
+	name := strings.ToLower(strings.TrimSpace(raw[:colon]))
+	value := strings.NewReplacer("\r\n", "", "\n", "").Replace(raw[colon+1:])
+
+	return name + ":" + strings.TrimSpace(collapseWhitespace(value))
+}
+
+// canonicalBody applies the "relaxed" body canonicalization of RFC 6376 section 3.4.4.
+func canonicalBody(body []byte) []byte {
+	lines := strings.Split(string(body), "\r\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(collapseWhitespace(line), " ")
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+
+	return []byte(strings.Join(lines, "\r\n") + "\r\n")
+}
@@ -0,0 +1,71 @@
+package dkim
+
+import (
+	"testing"
+)
+
+func TestCanonicalHeader(t *testing.T) {
+	tests := []struct {
+		raw      string
+		expected string
+	}{
+		// The examples of RFC 6376 section 3.4.5.
+		{raw: "A: X\r\n", expected: "a:X"},
+		{raw: "B : Y\t\r\n\tZ  \r\n", expected: "b:Y Z"},
+		{raw: "Subject:   Order   shipped \r\n", expected: "subject:Order shipped"},
+		{raw: "To: jane@example.org,\r\n john@example.org\r\n", expected: "to:jane@example.org, john@example.org"},
+		{raw: "X-Empty:\r\n", expected: "x-empty:"},
+	}
+
+	for _, test := range tests {
+		if canonical := canonicalHeader(test.raw); canonical != test.expected {
+			t.Errorf("expected %q to be canonicalized to %q, got %q", test.raw, test.expected, canonical)
+		}
+	}
+}
+
+func TestCanonicalBody(t *testing.T) {
+	tests := []struct {
+		body     string
+		expected string
+	}{
+		// The example of RFC 6376 section 3.4.5.
+		{body: " C \r\nD \t E\r\n\r\n\r\n", expected: " C\r\nD E\r\n"},
+		{body: "Hello", expected: "Hello\r\n"},
+		{body: "Hello\r\n", expected: "Hello\r\n"},
+		{body: "Hello\r\n\r\nBye \r\n", expected: "Hello\r\n\r\nBye\r\n"},
+		{body: "", expected: ""},
+		{body: "\r\n\r\n", expected: ""},
+	}
+
+	for _, test := range tests {
+		if canonical := string(canonicalBody([]byte(test.body))); canonical != test.expected {
+			t.Errorf("expected %q to be canonicalized to %q, got %q", test.body, test.expected, canonical)
+		}
+	}
+}
+
+func TestSplitMessage(t *testing.T) {
+	fields, body := splitMessage([]byte("From: jane@example.org\r\nSubject: Order\r\n shipped\r\nTo: john@example.org\r\n\r\nHello\r\n\r\nBye\r\n"))
+
+	expected := []headerField{
+		{name: "From", raw: "From: jane@example.org\r\n"},
+		{name: "Subject", raw: "Subject: Order\r\n shipped\r\n"},
+		{name: "To", raw: "To: john@example.org\r\n"},
+	}
+	if len(fields) != len(expected) {
+		t.Fatalf("expected %d fields, got %+v", len(expected), fields)
+	}
+	for i := range expected {
+		if fields[i] != expected[i] {
+			t.Errorf("expected field %d to be %+v, got %+v", i, expected[i], fields[i])
+		}
+	}
+	if string(body) != "Hello\r\n\r\nBye\r\n" {
+		t.Errorf("expected the body to follow the first empty line, got %q", body)
+	}
+
+	if fields, body := splitMessage([]byte("From: jane@example.org\r\n")); len(fields) != 1 || body != nil {
+		t.Errorf("expected a message without body to only have fields, got %+v and %q", fields, body)
+	}
+}
@@ -0,0 +1,78 @@
+package dkim
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// KeyConfig is the configuration of one signing identity. The private key is either given as is, or read from the AWS Secrets Manager secret
+// named by PrivateKeySecret before the identities are built.
+type KeyConfig struct {
+	Domain           string `json:"domain"`
+	Selector         string `json:"selector"`
+	PrivateKey       string `json:"private_key,omitempty"`
+	PrivateKeySecret string `json:"private_key_secret,omitempty"`
+}
+
+// Keys maps identity names, ie: brand names, to their signing key configuration.
+type Keys map[string]KeyConfig
+
+// UnmarshalText decodes keys from their JSON representation, so they can be read from an environment variable.
+func (keys *Keys) UnmarshalText(text []byte) error {
+	return json.Unmarshal(text, (*map[string]KeyConfig)(keys))
+}
+
+// Identities holds the signers of every configured identity.
+type Identities struct {
+	signers map[string]*Signer
+}
+
+// NewIdentities parses the private key of every identity.
+func NewIdentities(keys Keys) (*Identities, error) {
+	identities := &Identities{signers: make(map[string]*Signer, len(keys))}
+	for name, key := range keys {
+		signer, err := NewSigner(key.Domain, key.Selector, key.PrivateKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DKIM identity %q: %s", name, err.Error())
+		}
+		identities.signers[name] = signer
+	}
+
+	return identities, nil
+}
+
+// isAligned tells if the From domain is the signing domain or one of its subdomains, as required by DMARC relaxed alignment.
+func isAligned(fromDomain string, signingDomain string) bool {
+	fromDomain = strings.ToLower(fromDomain)
+	signingDomain = strings.ToLower(signingDomain)
+
+	return fromDomain == signingDomain || strings.HasSuffix(fromDomain, "."+signingDomain)
+}
+
+// Select returns the signer of the named identity, or when no name is given the one whose domain is the closest to the From domain.
+// It fails when the identity is unknown or when no signer is aligned with the From domain.
+func (identities *Identities) Select(name string, fromDomain string) (*Signer, error) {
+	if name != "" {
+		signer, ok := identities.signers[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown DKIM identity %q", name)
+		}
+		if !isAligned(fromDomain, signer.Domain) {
+			return nil, fmt.Errorf("DKIM identity %q signs for %s, which does not match the From domain %s", name, signer.Domain, fromDomain)
+		}
+		return signer, nil
+	}
+
+	var selected *Signer
+	for _, signer := range identities.signers {
+		if isAligned(fromDomain, signer.Domain) && (selected == nil || len(signer.Domain) > len(selected.Domain)) {
+			selected = signer
+		}
+	}
+	if selected == nil {
+		return nil, fmt.Errorf("no DKIM key matches the From domain %s", fromDomain)
+	}
+
+	return selected, nil
+}
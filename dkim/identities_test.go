@@ -0,0 +1,70 @@
+package dkim
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestIdentitiesSelect(t *testing.T) {
+	key := pkcs1PEM(testKey)
+	identities, err := NewIdentities(Keys{
+		"brand1":   {Domain: "brand1.com", Selector: "s1", PrivateKey: key},
+		"brand2":   {Domain: "brand2.com", Selector: "s2", PrivateKey: key},
+		"mail":     {Domain: "mail.brand2.com", Selector: "s3", PrivateKey: key},
+		"fallback": {Domain: "Example.com", Selector: "s4", PrivateKey: key},
+	})
+	if err != nil {
+		t.Fatalf("unable to instantiate identities: %s", err.Error())
+	}
+
+	tests := []struct {
+		name       string
+		identity   string
+		fromDomain string
+		selector   string
+		err        string
+	}{
+		{name: "selects the identity of the From domain", fromDomain: "brand1.com", selector: "s1"},
+		{name: "selects the identity of a parent domain", fromDomain: "news.brand1.com", selector: "s1"},
+		{name: "prefers the closest domain", fromDomain: "mail.brand2.com", selector: "s3"},
+		{name: "falls back to the parent domain", fromDomain: "billing.brand2.com", selector: "s2"},
+		{name: "ignores the case of the domains", fromDomain: "EXAMPLE.COM", selector: "s4"},
+		{name: "selects the named identity", identity: "brand2", fromDomain: "mail.brand2.com", selector: "s2"},
+		{name: "fails without identity of the From domain", fromDomain: "brand3.com", err: "no DKIM key matches the From domain brand3.com"},
+		{name: "fails on a domain only ending like the signing one", fromDomain: "notbrand1.com", err: "no DKIM key matches the From domain notbrand1.com"},
+		{name: "fails on an unknown named identity", identity: "brand3", fromDomain: "brand1.com", err: `unknown DKIM identity "brand3"`},
+		{name: "fails on a named identity of another domain", identity: "brand1", fromDomain: "brand2.com", err: `DKIM identity "brand1" signs for brand1.com, which does not match the From domain brand2.com`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			signer, err := identities.Select(test.identity, test.fromDomain)
+			if test.err != "" {
+				if err == nil || err.Error() != test.err {
+					t.Fatalf("expected error %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+			if signer.Selector != test.selector {
+				t.Errorf("expected selector %s, got %s", test.selector, signer.Selector)
+			}
+		})
+	}
+}
+
+func TestNewIdentities(t *testing.T) {
+	if _, err := NewIdentities(Keys{"brand1": {Domain: "brand1.com", Selector: "s1", PrivateKey: "not a key"}}); err == nil || !strings.HasPrefix(err.Error(), `invalid DKIM identity "brand1"`) {
+		t.Errorf("expected an invalid key to name its identity, got %v", err)
+	}
+
+	var keys Keys
+	if err := keys.UnmarshalText([]byte(`{"brand1": {"domain": "brand1.com", "selector": "s1", "private_key_secret": "arn:dkim"}}`)); err != nil {
+		t.Fatalf("unable to decode keys: %s", err.Error())
+	}
+	if key := keys["brand1"]; key.Domain != "brand1.com" || key.Selector != "s1" || key.PrivateKeySecret != "arn:dkim" {
+		t.Errorf("expected the brand1 key to be decoded, got %+v", keys)
+	}
+}
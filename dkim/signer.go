@@ -0,0 +1,124 @@
+package dkim
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// signedHeaders are the header fields covered by the signature, when present in the message.
+var signedHeaders = []string{"From", "Reply-To", "Subject", "Date", "To", "Cc", "Message-Id", "Mime-Version", "Content-Type", "List-Unsubscribe", "List-Unsubscribe-Post"}
+
+// Signer signs messages with the rsa-sha256 algorithm and relaxed/relaxed canonicalization.
+type Signer struct {
+	Domain   string
+	Selector string
+	key      *rsa.PrivateKey
+}
+
+// parsePrivateKey reads an RSA private key from its PKCS #1 or PKCS #8 PEM encoding.
+func parsePrivateKey(pemKey string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(pemKey))
+	if block == nil {
+		return nil, fmt.Errorf("private key is not PEM encoded")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse private key: %s", err.Error())
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("private key is not an RSA key")
+	}
+
+	return key, nil
+}
+
+// NewSigner instanciates a Signer for the domain and selector, with the PEM encoded RSA private key published under selector._domainkey.domain.
+func NewSigner(domain string, selector string, pemKey string) (*Signer, error) {
+	if domain == "" || selector == "" {
+		return nil, fmt.Errorf("DKIM signing requires a domain and a selector")
+	}
+	key, err := parsePrivateKey(pemKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Signer{Domain: domain, Selector: selector, key: key}, nil
+}
+
+// lastFields indexes the last instance of each header field, which is the one signed first per RFC 6376 section 5.4.2.
+func lastFields(fields []headerField) map[string]headerField {
+	indexed := make(map[string]headerField, len(fields))
+	for _, field := range fields {
+		indexed[strings.ToLower(field.name)] = field
+	}
+
+	return indexed
+}
+
+// Sign returns the raw CRLF message prepended with its DKIM-Signature header field.
+func (signer *Signer) Sign(message []byte) ([]byte, error) {
+	fields, body := splitMessage(message)
+	bodyHash := sha256.Sum256(canonicalBody(body))
+
+	indexed := lastFields(fields)
+	var names []string
+	hash := sha256.New()
+	for _, name := range signedHeaders {
+		field, ok := indexed[strings.ToLower(name)]
+		if !ok {
+			continue
+		}
+		names = append(names, strings.ToLower(name))
+		_, _ = io.WriteString(hash, canonicalHeader(field.raw)+"\r\n")
+	}
+
+	tags := fmt.Sprintf("v=1; a=rsa-sha256; c=relaxed/relaxed; d=%s; s=%s;\r\n t=%d; h=%s;\r\n bh=%s;\r\n b=",
+		signer.Domain, signer.Selector, time.Now().Unix(), strings.Join(names, ":"), base64.StdEncoding.EncodeToString(bodyHash[:]))
+	_, _ = io.WriteString(hash, canonicalHeader("DKIM-Signature: "+tags))
+
+	signature, err := rsa.SignPKCS1v15(rand.Reader, signer.key, crypto.SHA256, hash.Sum(nil))
+	if err != nil {
+		return nil, fmt.Errorf("unable to compute DKIM signature: %s", err.Error())
+	}
+
+	var signed bytes.Buffer
+	signed.WriteString("DKIM-Signature: " + tags + base64.StdEncoding.EncodeToString(signature) + "\r\n")
+	signed.Write(message)
+
+	return signed.Bytes(), nil
+}
+
+// SignedMessage writes a message prepended with its DKIM signature.
+type SignedMessage struct {
+	Message io.WriterTo
+	Signer  *Signer
+}
+
+// WriteTo renders the message once, signs it and dumps the signed message into w.
+func (msg *SignedMessage) WriteTo(w io.Writer) (int64, error) {
+	var rawMessage bytes.Buffer
+	if _, err := msg.Message.WriteTo(&rawMessage); err != nil {
+		return 0, err
+	}
+	signed, err := msg.Signer.Sign(rawMessage.Bytes())
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.Write(signed)
+
+	return int64(n), err
+}
@@ -0,0 +1,61 @@
+package enrichment
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// DynamoDB gives the attributes of the item of a DynamoDB table whose partition key is the key attribute, ie: the "id" string of a users table.
+// It implements the Source interface.
+type DynamoDB struct {
+	table          string
+	keyAttribute   string
+	dynamoDBClient dynamodbiface.DynamoDBAPI
+}
+
+// Lookup returns the attributes of the item of the key, or nil when there is none. The item is read with a strongly consistent read,
+// so a profile updated right before the message was queued is rendered as updated.
+func (dynamoDBSource *DynamoDB) Lookup(ctx context.Context, key string) (map[string]interface{}, error) {
+	output, err := dynamoDBSource.dynamoDBClient.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(dynamoDBSource.table),
+		Key:            map[string]*dynamodb.AttributeValue{dynamoDBSource.keyAttribute: {S: aws.String(key)}},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to get item %q from table %q: %s", key, dynamoDBSource.table, err.Error())
+	}
+	if len(output.Item) == 0 {
+		return nil, nil
+	}
+
+	var item map[string]interface{}
+	if err := dynamodbattribute.UnmarshalMap(output.Item, &item); err != nil {
+		return nil, fmt.Errorf("unable to decode item %q of table %q: %s", key, dynamoDBSource.table, err.Error())
+	}
+	// The item is decoded again from JSON, so its numbers are json.Number as those of the template context.
+	content, err := json.Marshal(item)
+	if err != nil {
+		return nil, fmt.Errorf("unable to encode item %q of table %q: %s", key, dynamoDBSource.table, err.Error())
+	}
+
+	return decodeFields(content)
+}
+
+// NewDynamoDB instanciates a DynamoDB source reading the items of the table by their key attribute, "id" when empty.
+func NewDynamoDB(table string, keyAttribute string, region string) (*DynamoDB, error) {
+	sess, err := session.NewSession(&aws.Config{Region: aws.String(region)})
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to AWS: %s", err.Error())
+	}
+	if keyAttribute == "" {
+		keyAttribute = "id"
+	}
+
+	return &DynamoDB{table: table, keyAttribute: keyAttribute, dynamoDBClient: dynamodb.New(sess)}, nil
+}
@@ -0,0 +1,84 @@
+package enrichment
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// maxResponseBytes bounds the size of a response, so a misbehaving endpoint can't exhaust the lambda memory.
+const maxResponseBytes = 1 << 20
+
+// keyPlaceholder is replaced by the escaped key in the URL of the endpoint.
+const keyPlaceholder = "{key}"
+
+// HTTP gives the fields of the JSON object an HTTP endpoint answers for the key, ie: https://users.internal/profiles/{key}.
+// It implements the Source interface.
+type HTTP struct {
+	urlTemplate string
+	headerName  string
+	headerValue string
+	httpClient  *http.Client
+}
+
+// Lookup gets the object of the key, a 404 answer meaning there is none.
+func (httpSource *HTTP) Lookup(ctx context.Context, key string) (map[string]interface{}, error) {
+	lookupURL := strings.Replace(httpSource.urlTemplate, keyPlaceholder, url.PathEscape(key), -1)
+	request, err := http.NewRequest(http.MethodGet, lookupURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to build context request: %s", err.Error())
+	}
+	request = request.WithContext(ctx)
+	request.Header.Set("Accept", "application/json")
+	if httpSource.headerName != "" {
+		request.Header.Set(httpSource.headerName, httpSource.headerValue)
+	}
+
+	response, err := httpSource.httpClient.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get context of %q: %s", key, err.Error())
+	}
+	defer response.Body.Close()
+	if response.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return nil, fmt.Errorf("unable to get context of %q: %s", key, response.Status)
+	}
+
+	content, err := ioutil.ReadAll(io.LimitReader(response.Body, maxResponseBytes))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read context of %q: %s", key, err.Error())
+	}
+
+	return decodeFields(content)
+}
+
+// NewHTTP instanciates an HTTP source getting the context from the URL, whose {key} placeholder is replaced by the key. The optional auth header,
+// ie: "Authorization: Bearer ...", is sent with every request.
+func NewHTTP(urlTemplate string, authHeader string, timeout time.Duration) (*HTTP, error) {
+	parsedURL, err := url.Parse(strings.Replace(urlTemplate, keyPlaceholder, "key", -1))
+	if err != nil || (parsedURL.Scheme != "http" && parsedURL.Scheme != "https") || parsedURL.Host == "" {
+		return nil, fmt.Errorf("%q is not a valid HTTP URL", urlTemplate)
+	}
+	if !strings.Contains(urlTemplate, keyPlaceholder) {
+		return nil, fmt.Errorf("URL %q has no %s placeholder", urlTemplate, keyPlaceholder)
+	}
+
+	httpSource := &HTTP{urlTemplate: urlTemplate, httpClient: &http.Client{Timeout: timeout}}
+	if authHeader != "" {
+		separator := strings.Index(authHeader, ":")
+		if separator <= 0 {
+			return nil, fmt.Errorf("auth header %q is invalid, expecting \"Name: value\"", authHeader)
+		}
+		httpSource.headerName = strings.TrimSpace(authHeader[:separator])
+		httpSource.headerValue = strings.TrimSpace(authHeader[separator+1:])
+	}
+
+	return httpSource, nil
+}
@@ -0,0 +1,26 @@
+package enrichment
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// Source interface should be implemented by any service able to give extra template context by key, ie: a user profile by user id (DynamoDB, HTTP... etc).
+type Source interface {
+	// Lookup should return the context fields stored for the key, nil meaning there is none.
+	Lookup(ctx context.Context, key string) (map[string]interface{}, error)
+}
+
+// decodeFields decodes a JSON object as the template context is decoded, keeping its numbers as json.Number.
+func decodeFields(content []byte) (map[string]interface{}, error) {
+	var fields map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.UseNumber()
+	if err := decoder.Decode(&fields); err != nil {
+		return nil, fmt.Errorf("unable to decode context fields: %s", err.Error())
+	}
+
+	return fields, nil
+}
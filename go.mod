@@ -7,7 +7,9 @@ require (
 	github.com/aws/aws-sdk-go v1.35.7
 	github.com/caarlos0/env/v6 v6.3.0
 	github.com/forsam-education/redriver v1.0.0
-	golang.org/x/text v0.3.2 // indirect
+	github.com/segmentio/kafka-go v0.4.8
+	golang.org/x/net v0.0.0-20200202094626-16171245cfb2
+	golang.org/x/text v0.3.2
 	gopkg.in/alexcesaro/quotedprintable.v3 v3.0.0-20150716171945-2caba252f4dc // indirect
 	gopkg.in/gomail.v2 v2.0.0-20160411212932-81ebce5c23df
 )
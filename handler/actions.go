@@ -0,0 +1,49 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/forsam-education/hermes/mailmessage"
+	"github.com/forsam-education/hermes/storage"
+)
+
+// managementAction is a direct invocation payload asking for an operational task instead of sending queued messages.
+type managementAction struct {
+	Action          string          `json:"action"`
+	Key             string          `json:"key"`
+	RedirectTo      []string        `json:"redirect_to,omitempty"`
+	Template        string          `json:"template_name"`
+	Locale          string          `json:"locale,omitempty"`
+	Subject         string          `json:"subject"`
+	TemplateContext json.RawMessage `json:"template_context"`
+}
+
+// handleAction runs the management action, returning its response if it has one.
+func (h *Handler) handleAction(action managementAction) (interface{}, error) {
+	switch action.Action {
+	case "replay":
+		if action.Key == "" {
+			return nil, fmt.Errorf("replay action requires the archived message key")
+		}
+		redirectTo := action.RedirectTo
+		if h.cfg.RecipientInbox != "" {
+			// SendGrid delivers to the headers of the archived message, which can't be redirected.
+			if h.cfg.MailTransport == "sendgrid" {
+				return nil, fmt.Errorf("replay action can't be used with RECIPIENT_OVERRIDE through the sendgrid transport")
+			}
+			redirectTo = []string{h.cfg.RecipientInbox}
+		}
+		archiveConnector, err := storage.NewS3(h.cfg.ArchiveBucket, h.cfg.AWSRegion)
+		if err != nil {
+			return nil, fmt.Errorf("unable to instantiate archive connector: %s", err.Error())
+		}
+		return nil, mailmessage.ReplayMail(archiveConnector, h.mailTransport, action.Key, redirectTo)
+	case "preview":
+		if action.Template == "" {
+			return nil, fmt.Errorf("preview action requires the template name")
+		}
+		return h.mailer.Preview(action.Template, action.Locale, action.Subject, action.TemplateContext)
+	default:
+		return nil, fmt.Errorf("unknown management action %q", action.Action)
+	}
+}
@@ -0,0 +1,89 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/forsam-education/hermes/storage"
+	"github.com/forsam-education/hermes/transport"
+	"strings"
+	"testing"
+)
+
+func TestHandleRequestPreviewAction(t *testing.T) {
+	templates := storage.NewMemory()
+	templates.Set("order.html.template", []byte(`<p>Hello {{.name}}, your order {{.order.id}} is {{.order.status}}</p>`))
+	templates.Set("order.txt.template", []byte("Hello {{.name}}, your order {{.order.id}} is {{.order.status}}"))
+	templates.Set("order.fr.txt.template", []byte("Bonjour {{.name}}, votre commande {{.order.id}} est {{.order.status}}"))
+	templates.Set("order.fr.html.template", []byte(`<p>Bonjour {{.name}}</p>`))
+	deliveries := transport.NewFake()
+	h, err := New(validConfig(t), Services{Templates: templates, Transport: deliveries})
+	if err != nil {
+		t.Fatalf("unable to instantiate handler: %s", err.Error())
+	}
+
+	tests := []struct {
+		name     string
+		payload  string
+		expected map[string]string
+		err      string
+	}{
+		{
+			name:     "renders the template and subject",
+			payload:  `{"action":"preview","template_name":"order","subject":"Order {{.order.id}}","template_context":{"name":"<b>Jane</b>","order":{"id":12345678901234567,"status":"shipped"}}}`,
+			expected: map[string]string{"subject": "Order 12345678901234567", "html": "<p>Hello &lt;b&gt;Jane&lt;/b&gt;, your order 12345678901234567 is shipped</p>", "text": "Hello <b>Jane</b>, your order 12345678901234567 is shipped"},
+		},
+		{
+			name:     "renders the localized template",
+			payload:  `{"action":"preview","template_name":"order","locale":"fr","subject":"Commande","template_context":{"name":"Jane","order":{"id":42,"status":"expédiée"}}}`,
+			expected: map[string]string{"subject": "Commande", "html": "<p>Bonjour Jane</p>", "text": "Bonjour Jane, votre commande 42 est expédiée"},
+		},
+		{
+			name:    "requires the template name",
+			payload: `{"action":"preview","template_context":{}}`,
+			err:     "preview action requires the template name",
+		},
+		{
+			name:    "fails on an unknown template",
+			payload: `{"action":"preview","template_name":"invoice","template_context":{}}`,
+			err:     "invoice",
+		},
+		{
+			name:    "fails on an invalid context",
+			payload: `{"action":"preview","template_name":"order","template_context":["jane"]}`,
+			err:     "unable to unmarshal template context",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			response, err := h.HandleRequest(context.Background(), json.RawMessage(test.payload))
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected error %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+
+			// The response is returned as JSON by the lambda runtime.
+			encoded, err := json.Marshal(response)
+			if err != nil {
+				t.Fatalf("unable to marshal response: %s", err.Error())
+			}
+			var preview map[string]string
+			if err := json.Unmarshal(encoded, &preview); err != nil {
+				t.Fatalf("expected a JSON object, got %s", encoded)
+			}
+			for field, value := range test.expected {
+				if preview[field] != value {
+					t.Errorf("expected %s %q, got %q", field, value, preview[field])
+				}
+			}
+		})
+	}
+	if sent := len(deliveries.Messages()); sent != 0 {
+		t.Errorf("expected nothing sent by a preview, got %d messages", sent)
+	}
+}
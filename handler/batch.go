@@ -0,0 +1,77 @@
+package handler
+
+import (
+	"fmt"
+	"github.com/aws/aws-lambda-go/events"
+	"sync"
+)
+
+// sqsBatchItemFailure identifies a record to return to the queue, when the event source mapping reports batch item failures.
+type sqsBatchItemFailure struct {
+	ItemIdentifier string `json:"itemIdentifier"`
+}
+
+// sqsBatchResponse is the partial batch response of an SQS event, only the listed records being retried by the Lambda service.
+type sqsBatchResponse struct {
+	BatchItemFailures []sqsBatchItemFailure `json:"batchItemFailures"`
+}
+
+// messageGroupAttribute is the record attribute naming its message group, ie: the group of a FIFO queue message or the partition of a Kafka record.
+const messageGroupAttribute = "MessageGroupId"
+
+// processRecords processes the records concurrently, trying each of them up to retries times, and returns the partial batch response listing those that failed.
+// The records of a message group are processed one after the other in their order, those following a failed record failing without being processed,
+// so the order of the group holds when they are delivered again.
+func processRecords(records []events.SQSMessage, retries int, processor func(events.SQSMessage) error) *sqsBatchResponse {
+	var groupOrder []string
+	groups := make(map[string][]int)
+	for i, record := range records {
+		group := record.Attributes[messageGroupAttribute]
+		if group == "" {
+			// A record without group is processed on its own.
+			group = fmt.Sprintf("\x00%d", i)
+		}
+		if _, ok := groups[group]; !ok {
+			groupOrder = append(groupOrder, group)
+		}
+		groups[group] = append(groups[group], i)
+	}
+
+	failed := make([]bool, len(records))
+	var wg sync.WaitGroup
+	for _, group := range groupOrder {
+		wg.Add(1)
+		go func(indexes []int) {
+			defer wg.Done()
+			for n, i := range indexes {
+				if !processRecord(records[i], retries, processor) {
+					for _, following := range indexes[n:] {
+						failed[following] = true
+					}
+					return
+				}
+			}
+		}(groups[group])
+	}
+	wg.Wait()
+
+	response := &sqsBatchResponse{BatchItemFailures: []sqsBatchItemFailure{}}
+	for i, record := range records {
+		if failed[i] {
+			response.BatchItemFailures = append(response.BatchItemFailures, sqsBatchItemFailure{ItemIdentifier: record.MessageId})
+		}
+	}
+
+	return response
+}
+
+// processRecord tries to process the record up to retries times, telling if it succeeded.
+func processRecord(record events.SQSMessage, retries int, processor func(events.SQSMessage) error) bool {
+	for attempt := 0; attempt < retries; attempt++ {
+		if err := processor(record); err == nil {
+			return true
+		}
+	}
+
+	return false
+}
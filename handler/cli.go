@@ -0,0 +1,220 @@
+package handler
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/forsam-education/hermes/logging"
+	"github.com/forsam-education/hermes/mailer"
+	"github.com/forsam-education/hermes/mailmessage"
+	"github.com/forsam-education/hermes/storage"
+	"github.com/forsam-education/hermes/templating"
+	"github.com/forsam-education/hermes/transport"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// sendFile is the send and render subcommands, sending the message of a local JSON file with the configuration of the environment, ie:
+// hermes send --file message.json --template-dir ./templates. The render subcommand writes the built message to the standard output instead of sending it.
+func sendFile(cfg Config, command string, args []string) error {
+	flags := flag.NewFlagSet(command, flag.ContinueOnError)
+	file := flags.String("file", "", "JSON file holding the message to send")
+	templateDir := flags.String("template-dir", "", "directory to read the templates from, instead of TEMPLATE_SOURCE")
+	attachmentDir := flags.String("attachment-dir", ".", "directory to read the attachment keys of the message from")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *file == "" {
+		return fmt.Errorf("--file is required")
+	}
+
+	messageBody, err := ioutil.ReadFile(*file)
+	if err != nil {
+		return fmt.Errorf("unable to read message: %s", err.Error())
+	}
+	if *templateDir != "" {
+		cfg.TemplateSource = "fs"
+		cfg.TemplateDir = *templateDir
+	}
+
+	fileMailer, err := newFileMailer(cfg, *attachmentDir, command == "render")
+	if err != nil {
+		return fmt.Errorf("unable to initialize hermes: %s", err.Error())
+	}
+
+	start := time.Now()
+	providerID, err := fileMailer.Send(context.Background(), string(messageBody))
+	if err != nil {
+		return err
+	}
+	if command == "send" {
+		log.Printf("Sent %s in %s, provider id %q", *file, time.Since(start), providerID)
+	}
+
+	return nil
+}
+
+// newFileMailer instanciates the mailer of the send and render subcommands, which only needs the templates and the mail transport, so a message
+// can be sent without the AWS region and the queue the handler requires. The attachments are read from the directory, and rendering doesn't dial.
+func newFileMailer(cfg Config, attachmentDir string, render bool) (*mailer.Mailer, error) {
+	if err := cfg.validateTemplateSource(); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %s", err.Error())
+	}
+	templateConnector, err := newTemplateConnector(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("unable to instantiate template connector: %s", err.Error())
+	}
+	attachments, err := storage.NewFileSystem(attachmentDir)
+	if err != nil {
+		return nil, fmt.Errorf("unable to instantiate attachment reader: %s", err.Error())
+	}
+	var mailTransport transport.Dialer
+	if !render {
+		if err := cfg.validateTransport(); err != nil {
+			return nil, fmt.Errorf("invalid configuration: %s", err.Error())
+		}
+		if mailTransport, err = newMailTransport(cfg); err != nil {
+			return nil, fmt.Errorf("unable to instantiate mail transport: %s", err.Error())
+		}
+	}
+
+	fileMailer := mailer.New(storage.NewVersionedConnector(templateConnector), attachments, mailTransport)
+	fileMailer.Options = mailmessage.Options{
+		DryRun:             render,
+		Aliases:            cfg.Aliases,
+		TextSignature:      cfg.TextSignature,
+		TextCharset:        cfg.TextCharset,
+		HTMLCharset:        cfg.HTMLCharset,
+		MinifyHTML:         cfg.MinifyHTML,
+		SanitizeHTML:       cfg.SanitizeHTML,
+		ContextMarkup:      cfg.ContextMarkup,
+		BodyContentTypes:   cfg.BodyTypes,
+		DefaultFromAddress: cfg.DefaultFrom,
+		DefaultFromName:    cfg.DefaultFromName,
+		DefaultReplyTo:     cfg.DefaultReplyTo,
+		HeaderEncoding:     cfg.HeaderEncoding,
+		SMTPUTF8:           cfg.SMTPUTF8,
+		StrictTemplates:    cfg.StrictTemplates,
+		TextFromHTML:       cfg.TextFromHTML,
+		MissingTemplates:   cfg.MissingTemplates,
+		MarkdownTemplates:  cfg.MarkdownEnabled,
+		MarkdownLayout:     cfg.MarkdownLayout,
+		Logger:             logging.NewLogger(cfg.LogLevel, cfg.LogFormat == "json", os.Stderr),
+	}
+	if cfg.MustacheEnabled {
+		fileMailer.Options.TemplateEngines = append(fileMailer.Options.TemplateEngines, templating.NewMustache())
+	}
+	if cfg.TemplateEngine == "mustache" {
+		fileMailer.Options.DefaultEngine = templating.NewMustache()
+	}
+
+	return fileMailer, nil
+}
+
+// snapshotsPrefix returns the key prefix of the snapshots, under the previews one.
+func snapshotsPrefix(cfg Config) string {
+	return path.Join(cfg.PreviewPrefix, "snapshots")
+}
+
+// snapshotTemplate is the snapshot subcommand, rendering a template against the context of a local JSON file and writing its snapshot to
+// PREVIEW_BUCKET, ie: hermes snapshot --template welcome --context context.json --template-dir ./templates, so QA can review a template change.
+func snapshotTemplate(cfg Config, args []string) error {
+	flags := flag.NewFlagSet("snapshot", flag.ContinueOnError)
+	templateName := flags.String("template", "", "name of the template to snapshot")
+	contextFile := flags.String("context", "", "JSON file holding the template context")
+	locale := flags.String("locale", "", "locale of the snapshot versions of the template")
+	subject := flags.String("subject", "", "subject template rendered against the context")
+	templateDir := flags.String("template-dir", "", "directory to read the templates from, instead of TEMPLATE_SOURCE")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *templateName == "" {
+		return fmt.Errorf("--template is required")
+	}
+	if cfg.PreviewBucket == "" {
+		return fmt.Errorf("PREVIEW_BUCKET is required to write snapshots")
+	}
+
+	var rawContext []byte
+	if *contextFile != "" {
+		var err error
+		if rawContext, err = ioutil.ReadFile(*contextFile); err != nil {
+			return fmt.Errorf("unable to read template context: %s", err.Error())
+		}
+	}
+	if *templateDir != "" {
+		cfg.TemplateSource = "fs"
+		cfg.TemplateDir = *templateDir
+	}
+	cfg.BatchFailures = true
+
+	h, err := newHandler(cfg)
+	if err != nil {
+		return fmt.Errorf("unable to initialize hermes: %s", err.Error())
+	}
+	defer h.connectionPools.CloseIdle()
+	if h.mailer.Options.Snapshots == nil {
+		h.mailer.Options.Snapshots = mailmessage.NewSnapshots(h.mailer.Options.Previews, snapshotsPrefix(cfg))
+	}
+
+	key, err := h.mailer.Snapshot(*templateName, *locale, *subject, rawContext)
+	if err != nil {
+		return err
+	}
+	log.Printf("Wrote snapshot of template %s to s3://%s/%s/", *templateName, cfg.PreviewBucket, key)
+
+	return nil
+}
+
+// validateTemplates is the validate-templates subcommand, parsing every template of TEMPLATE_SOURCE or of the given directory, ie:
+// hermes validate-templates --template-dir ./templates. Every invalid template is logged, the command failing if there is any.
+func validateTemplates(cfg Config, args []string) error {
+	flags := flag.NewFlagSet("validate-templates", flag.ContinueOnError)
+	templateDir := flags.String("template-dir", "", "directory to read the templates from, instead of TEMPLATE_SOURCE")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *templateDir != "" {
+		cfg.TemplateSource = "fs"
+		cfg.TemplateDir = *templateDir
+	}
+
+	templateConnector, err := newTemplateConnector(cfg)
+	if err != nil {
+		return fmt.Errorf("unable to instantiate template connector: %s", err.Error())
+	}
+	lister, ok := templateConnector.(storage.TemplateLister)
+	if !ok {
+		return fmt.Errorf("templates of TEMPLATE_SOURCE %q can't be listed", cfg.TemplateSource)
+	}
+	keys, err := lister.List()
+	if err != nil {
+		return err
+	}
+
+	var invalid int
+	for _, key := range keys {
+		validate := mailmessage.ValidateTemplate
+		// The AMP versions are always Go templates, whatever the engine.
+		isAMP := strings.HasSuffix(key, ".amp.html.template")
+		if cfg.TemplateEngine == "mustache" && !isAMP && (strings.HasSuffix(key, ".html.template") || strings.HasSuffix(key, ".txt.template")) {
+			validate = func(templateConnector storage.TemplateFetcher, key string) error {
+				return mailmessage.ValidateEngineTemplate(templateConnector, templating.NewMustache(), key)
+			}
+		}
+		if err := validate(templateConnector, key); err != nil {
+			log.Printf("Invalid template %s: %s", key, err.Error())
+			invalid++
+		}
+	}
+	if invalid > 0 {
+		return fmt.Errorf("%d of the %d templates are invalid", invalid, len(keys))
+	}
+	log.Printf("All %d templates are valid", len(keys))
+
+	return nil
+}
@@ -0,0 +1,124 @@
+package handler
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeFileTestFiles writes a template directory and a message file in a temporary directory, returning their paths.
+func writeFileTestFiles(t *testing.T) (string, string, func()) {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "hermes-cli")
+	if err != nil {
+		t.Fatalf("unable to create directory: %s", err.Error())
+	}
+	files := map[string]string{
+		"templates/welcome.html.template": "<p>Hello {{.name}}</p>",
+		"templates/welcome.txt.template":  "Hello {{.name}}",
+		"attachments/terms.txt":           "Terms of service",
+		"message.json":                    `{"from_address": "noreply@example.com", "to": ["jane@example.org"], "subject": "Welcome", "template_name": "welcome", "template_context": {"name": "Jane"}, "attachments": ["terms.txt"]}`,
+	}
+	for name, content := range files {
+		filePath := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+			t.Fatalf("unable to create directory: %s", err.Error())
+		}
+		if err := ioutil.WriteFile(filePath, []byte(content), 0644); err != nil {
+			t.Fatalf("unable to write %s: %s", name, err.Error())
+		}
+	}
+
+	return filepath.Join(dir, "templates"), filepath.Join(dir, "message.json"), func() { os.RemoveAll(dir) }
+}
+
+// fileTestConfig returns the configuration of the environment, without AWS region nor queue.
+func fileTestConfig(t *testing.T) Config {
+	t.Helper()
+
+	cfg, err := ParseConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.AWSRegion = ""
+	cfg.QueueURL = ""
+	cfg.MailTransport = "smtp"
+	cfg.SMTPHost = ""
+
+	return cfg
+}
+
+func TestRenderFileWithoutAWS(t *testing.T) {
+	templateDir, messageFile, cleanup := writeFileTestFiles(t)
+	defer cleanup()
+
+	output, err := ioutil.TempFile("", "hermes-render")
+	if err != nil {
+		t.Fatalf("unable to create output: %s", err.Error())
+	}
+	defer os.Remove(output.Name())
+	defer func(stdout *os.File) { os.Stdout = stdout }(os.Stdout)
+	os.Stdout = output
+
+	args := []string{"--file", messageFile, "--template-dir", templateDir, "--attachment-dir", filepath.Join(filepath.Dir(templateDir), "attachments")}
+	if err := sendFile(fileTestConfig(t), "render", args); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	output.Close()
+
+	rendered, err := ioutil.ReadFile(output.Name())
+	if err != nil {
+		t.Fatalf("unable to read output: %s", err.Error())
+	}
+	for _, expected := range []string{"Subject: Welcome", "Hello Jane", `filename="terms.txt"`} {
+		if !strings.Contains(string(rendered), expected) {
+			t.Errorf("expected the rendered message to contain %q, got %s", expected, rendered)
+		}
+	}
+}
+
+func TestSendFileWithoutAWS(t *testing.T) {
+	templateDir, messageFile, cleanup := writeFileTestFiles(t)
+	defer cleanup()
+
+	// Nothing listens on the port once the listener is closed, so the send fails to connect once the mailer is built.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to listen: %s", err.Error())
+	}
+	address := listener.Addr().(*net.TCPAddr)
+	listener.Close()
+
+	tests := []struct {
+		name   string
+		change func(cfg *Config)
+		err    string
+	}{
+		{name: "requires the SMTP host", change: func(cfg *Config) {}, err: "invalid configuration: SMTP_HOST is required when MAIL_TRANSPORT is smtp"},
+		{name: "sends through the SMTP server", change: func(cfg *Config) {
+			cfg.SMTPHost = "127.0.0.1"
+			cfg.SMTPPort.Number = address.Port
+		}, err: "unable to connect to mail transport"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := fileTestConfig(t)
+			test.change(&cfg)
+			err := sendFile(cfg, "send", []string{"--file", messageFile, "--template-dir", templateDir})
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("expected error %q, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestSendFileRequiresFile(t *testing.T) {
+	if err := sendFile(fileTestConfig(t), "send", nil); err == nil || err.Error() != "--file is required" {
+		t.Errorf("expected the file to be required, got %v", err)
+	}
+}
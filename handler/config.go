@@ -0,0 +1,519 @@
+package handler
+
+import (
+	"fmt"
+	"github.com/forsam-education/hermes/dkim"
+	"github.com/forsam-education/hermes/logging"
+	"github.com/forsam-education/hermes/mailmessage"
+	"github.com/forsam-education/hermes/ratelimit"
+	"github.com/forsam-education/hermes/transport"
+	"github.com/forsam-education/hermes/warmup"
+	"golang.org/x/text/encoding/ianaindex"
+	"mime"
+	"net/mail"
+	"strings"
+	"time"
+)
+
+// Config is the configuration of hermes, read from the environment variables.
+type Config struct {
+	TemplateSource   string                            `env:"TEMPLATE_SOURCE" envDefault:"s3"`
+	TemplateDir      string                            `env:"TEMPLATE_DIR"`
+	TemplateURL      string                            `env:"TEMPLATE_URL"`
+	TemplateAuth     string                            `env:"TEMPLATE_AUTH_HEADER"`
+	TemplateTimeout  time.Duration                     `env:"TEMPLATE_HTTP_TIMEOUT" envDefault:"10s"`
+	GCSCredentials   string                            `env:"GOOGLE_APPLICATION_CREDENTIALS"`
+	AzureAccount     string                            `env:"AZURE_STORAGE_ACCOUNT"`
+	AzureKey         string                            `env:"AZURE_STORAGE_KEY"`
+	AzureSASToken    string                            `env:"AZURE_STORAGE_SAS_TOKEN"`
+	TemplateBucket   string                            `env:"TEMPLATE_BUCKET"`
+	FallbackBuckets  []string                          `env:"TEMPLATE_FALLBACK_BUCKETS"`
+	DeniedAsMissing  bool                              `env:"TEMPLATE_DENIED_AS_MISSING" envDefault:"false"`
+	AttachmentBucket string                            `env:"ATTACHMENT_BUCKET"`
+	AttachBuckets    []string                          `env:"ATTACHMENT_EXTRA_BUCKETS"`
+	PayloadBuckets   []string                          `env:"PAYLOAD_BUCKETS"`
+	ArchiveBucket    string                            `env:"ARCHIVE_BUCKET"`
+	ArchivePrefix    string                            `env:"ARCHIVE_PREFIX" envDefault:"archive"`
+	ArchiveSent      bool                              `env:"ARCHIVE_SENT_MESSAGES" envDefault:"false"`
+	PreviewBucket    string                            `env:"PREVIEW_BUCKET"`
+	PreviewPrefix    string                            `env:"PREVIEW_PREFIX" envDefault:"previews"`
+	PreviewSnapshots bool                              `env:"PREVIEW_SNAPSHOTS" envDefault:"false"`
+	MailTransport    string                            `env:"MAIL_TRANSPORT" envDefault:"smtp"`
+	SMTPHost         string                            `env:"SMTP_HOST"`
+	SMTPPort         transport.Port                    `env:"SMTP_PORT" envDefault:"465"`
+	SMTPTLS          string                            `env:"SMTP_TLS"`
+	SMTPTLSMin       string                            `env:"SMTP_TLS_MIN_VERSION" envDefault:"1.2"`
+	SMTPCABundle     string                            `env:"SMTP_CA_BUNDLE"`
+	SMTPInsecureTLS  bool                              `env:"SMTP_TLS_INSECURE_SKIP_VERIFY" envDefault:"false"`
+	SMTPUserName     string                            `env:"SMTP_USER"`
+	SMTPPassword     string                            `env:"SMTP_PASS"`
+	SMTPSecret       string                            `env:"SMTP_CREDENTIALS_SECRET_ARN"`
+	SMTPParameter    string                            `env:"SMTP_CREDENTIALS_PARAMETER"`
+	SMTPAuth         string                            `env:"SMTP_AUTH"`
+	SMTPTokenURL     string                            `env:"SMTP_OAUTH_TOKEN_URL"`
+	SMTPClientID     string                            `env:"SMTP_OAUTH_CLIENT_ID"`
+	SMTPClientSecret string                            `env:"SMTP_OAUTH_CLIENT_SECRET"`
+	SMTPScope        string                            `env:"SMTP_OAUTH_SCOPE"`
+	SMIMESecret      string                            `env:"SMIME_SECRET"`
+	SMIMERecipients  string                            `env:"SMIME_RECIPIENTS_SECRET"`
+	SMTPSecretTTL    time.Duration                     `env:"SMTP_CREDENTIALS_REFRESH" envDefault:"15m"`
+	SMTPGreetRetries int                               `env:"SMTP_GREETING_RETRIES" envDefault:"0"`
+	SMTPGreetBackoff time.Duration                     `env:"SMTP_GREETING_BACKOFF" envDefault:"1s"`
+	SMTPPipelining   bool                              `env:"SMTP_PIPELINING" envDefault:"false"`
+	SMTPChunking     bool                              `env:"SMTP_CHUNKING" envDefault:"false"`
+	SMTPReuseConns   bool                              `env:"SMTP_REUSE_CONNECTION" envDefault:"false"`
+	SMTPPoolMaxIdle  int                               `env:"SMTP_POOL_MAX_IDLE" envDefault:"0"`
+	SMTPPoolMaxMsgs  int                               `env:"SMTP_POOL_MAX_MESSAGES" envDefault:"0"`
+	SMTPPoolIdleTTL  time.Duration                     `env:"SMTP_POOL_IDLE_TIMEOUT" envDefault:"1m"`
+	SMTPPoolCheck    time.Duration                     `env:"SMTP_POOL_CHECK_AFTER" envDefault:"10s"`
+	SMTPSendRetries  int                               `env:"SMTP_SEND_RETRIES" envDefault:"1"`
+	SMTPSendBackoff  time.Duration                     `env:"SMTP_SEND_BACKOFF" envDefault:"500ms"`
+	SMTPRetryBudget  time.Duration                     `env:"SMTP_RETRY_BUDGET" envDefault:"10s"`
+	AWSRegion        string                            `env:"AWS_REGION_CODE"`
+	SESConfigSet     string                            `env:"SES_CONFIGURATION_SET"`
+	SendGridKey      string                            `env:"SENDGRID_API_KEY"`
+	MailgunDomain    string                            `env:"MAILGUN_DOMAIN"`
+	MailgunKey       string                            `env:"MAILGUN_API_KEY"`
+	MailgunAPIBase   string                            `env:"MAILGUN_API_BASE" envDefault:"https://api.mailgun.net"`
+	Transports       transportProfiles                 `env:"TRANSPORT_PROFILES"`
+	QueueURL         string                            `env:"SQS_QUEUE"`
+	KafkaBrokers     []string                          `env:"KAFKA_BROKERS"`
+	KafkaTopic       string                            `env:"KAFKA_TOPIC"`
+	KafkaGroup       string                            `env:"KAFKA_GROUP"`
+	KafkaTLS         bool                              `env:"KAFKA_TLS" envDefault:"false"`
+	KafkaCABundle    string                            `env:"KAFKA_CA_BUNDLE"`
+	KafkaSASL        string                            `env:"KAFKA_SASL_MECHANISM"`
+	KafkaUserName    string                            `env:"KAFKA_SASL_USER"`
+	KafkaPassword    string                            `env:"KAFKA_SASL_PASS"`
+	BatchFailures    bool                              `env:"BATCH_ITEM_FAILURES" envDefault:"false"`
+	RecordWorkers    int                               `env:"RECORD_CONCURRENCY" envDefault:"0"`
+	RejectedQueue    string                            `env:"INVALID_MESSAGES_QUEUE"`
+	HTTPAPIKey       string                            `env:"HTTP_API_KEY"`
+	HTTPAnonymous    bool                              `env:"HTTP_ALLOW_ANONYMOUS" envDefault:"false"`
+	FailedBucket     string                            `env:"FAILED_MESSAGES_BUCKET"`
+	FailedPrefix     string                            `env:"FAILED_MESSAGES_PREFIX"`
+	Aliases          mailmessage.Aliases               `env:"ALIASES"`
+	MaxContextBytes  int                               `env:"MAX_CONTEXT_BYTES" envDefault:"0"`
+	MaxFanOut        int                               `env:"MAX_FAN_OUT" envDefault:"1000"`
+	UndisclosedTo    bool                              `env:"UNDISCLOSED_RECIPIENTS" envDefault:"false"`
+	TextSignature    string                            `env:"TEXT_SIGNATURE"`
+	MaxAttachment    int64                             `env:"MAX_ATTACHMENT_BYTES" envDefault:"0"`
+	MaxAttachTotal   int64                             `env:"MAX_TOTAL_ATTACHMENT_BYTES" envDefault:"0"`
+	MaxBodyBytes     int                               `env:"MAX_BODY_BYTES" envDefault:"0"`
+	AttachmentLinks  bool                              `env:"ATTACHMENT_LINK_FALLBACK" envDefault:"false"`
+	AttachmentExpiry time.Duration                     `env:"ATTACHMENT_LINK_EXPIRY" envDefault:"168h"`
+	TemplateRates    ratelimit.Rates                   `env:"TEMPLATE_RATE_LIMITS"`
+	RateLimitMaxWait time.Duration                     `env:"RATE_LIMIT_MAX_WAIT" envDefault:"10s"`
+	RenderTimeout    time.Duration                     `env:"RENDER_TIMEOUT" envDefault:"0s"`
+	SendTimeout      time.Duration                     `env:"SEND_TIMEOUT" envDefault:"0s"`
+	MessageTimeout   time.Duration                     `env:"MESSAGE_TIMEOUT" envDefault:"0s"`
+	DeadlineHeadroom time.Duration                     `env:"DEADLINE_HEADROOM" envDefault:"5s"`
+	WarmupSchedule   warmup.Schedule                   `env:"WARMUP_SCHEDULE"`
+	WarmupTable      string                            `env:"WARMUP_TABLE"`
+	IdempotencyTable string                            `env:"IDEMPOTENCY_TABLE"`
+	IdempotencyTTL   time.Duration                     `env:"IDEMPOTENCY_TTL" envDefault:"24h"`
+	SuppressionTable string                            `env:"SUPPRESSION_TABLE"`
+	SuppressBucket   string                            `env:"SUPPRESSION_BUCKET"`
+	EnrichKey        string                            `env:"ENRICH_CONTEXT_KEY"`
+	EnrichInto       string                            `env:"ENRICH_CONTEXT_INTO"`
+	EnrichTable      string                            `env:"ENRICH_TABLE"`
+	EnrichTableKey   string                            `env:"ENRICH_TABLE_KEY" envDefault:"id"`
+	EnrichURL        string                            `env:"ENRICH_URL"`
+	EnrichAuth       string                            `env:"ENRICH_AUTH_HEADER"`
+	EnrichTimeout    time.Duration                     `env:"ENRICH_TIMEOUT" envDefault:"2s"`
+	SuppressKey      string                            `env:"SUPPRESSION_KEY"`
+	FeedbackMode     bool                              `env:"FEEDBACK_PROCESSOR" envDefault:"false"`
+	TenantsBucket    string                            `env:"TENANTS_BUCKET"`
+	TenantsKey       string                            `env:"TENANTS_KEY"`
+	TenantsParameter string                            `env:"TENANTS_PARAMETER"`
+	MaxConcurrency   int                               `env:"MAX_CONCURRENT_SENDS" envDefault:"0"`
+	DomainSlots      int                               `env:"DOMAIN_CONCURRENCY" envDefault:"0"`
+	DomainRules      mailmessage.DomainRules           `env:"DOMAIN_RULES"`
+	ProviderRate     float64                           `env:"PROVIDER_RATE_LIMIT" envDefault:"0"`
+	ProviderBurst    int                               `env:"PROVIDER_RATE_BURST" envDefault:"1"`
+	PriorityOrder    bool                              `env:"PRIORITY_ORDERING" envDefault:"false"`
+	RetryBudget      int                               `env:"RETRY_BUDGET" envDefault:"0"`
+	Preprocessors    mailmessage.TemplatePreprocessors `env:"TEMPLATE_PREPROCESSORS"`
+	Variants         mailmessage.TemplateVariants      `env:"TEMPLATE_VARIANTS"`
+	ShadowPercent    int                               `env:"SHADOW_PERCENT" envDefault:"0"`
+	ShadowTemplates  []string                          `env:"SHADOW_TEMPLATES"`
+	ShadowTransports []string                          `env:"SHADOW_TRANSPORTS"`
+	ShadowInbox      string                            `env:"SHADOW_INBOX"`
+	QuietHours       mailmessage.DailyWindow           `env:"QUIET_HOURS"`
+	QuietCategories  []string                          `env:"QUIET_HOURS_CATEGORIES" envDefault:"bulk"`
+	QuietTimezone    string                            `env:"QUIET_HOURS_TIMEZONE" envDefault:"UTC"`
+	RecipientInbox   string                            `env:"RECIPIENT_OVERRIDE"`
+	MetricsNamespace string                            `env:"METRICS_NAMESPACE"`
+	MetricsAddress   string                            `env:"METRICS_ADDRESS"`
+	HealthAddress    string                            `env:"HEALTH_ADDRESS"`
+	LogLevel         logging.Level                     `env:"LOG_LEVEL" envDefault:"info"`
+	LogFormat        string                            `env:"LOG_FORMAT" envDefault:"text"`
+	ResultsStream    string                            `env:"RESULTS_STREAM"`
+	ResultLambda     string                            `env:"RESULT_LAMBDA_ARN"`
+	ResultsEventBus  string                            `env:"RESULTS_EVENT_BUS"`
+	ResultsTopic     string                            `env:"RESULTS_TOPIC_ARN"`
+	TextCharset      string                            `env:"TEXT_CHARSET" envDefault:"UTF-8"`
+	HTMLCharset      string                            `env:"HTML_CHARSET" envDefault:"UTF-8"`
+	AttachDigests    bool                              `env:"ATTACHMENT_DIGESTS" envDefault:"false"`
+	DedupeAttach     bool                              `env:"DEDUPE_ATTACHMENTS" envDefault:"false"`
+	AttachmentTypes  []string                          `env:"ALLOWED_ATTACHMENT_TYPES"`
+	AttachmentPolicy string                            `env:"ATTACHMENT_POLICY" envDefault:"reject"`
+	ClamAVAddress    string                            `env:"CLAMAV_ADDRESS"`
+	ClamAVTimeout    time.Duration                     `env:"CLAMAV_TIMEOUT" envDefault:"10s"`
+	SigningSecret    string                            `env:"SIGNING_KEYS_SECRET"`
+	SigningRefresh   time.Duration                     `env:"SIGNING_KEYS_REFRESH" envDefault:"5m"`
+	MinifyHTML       bool                              `env:"MINIFY_HTML" envDefault:"false"`
+	SanitizeHTML     bool                              `env:"SANITIZE_HTML" envDefault:"false"`
+	ContextMarkup    string                            `env:"CONTEXT_MARKUP" envDefault:"escape"`
+	InvalidRcpts     string                            `env:"INVALID_RECIPIENTS" envDefault:"reject"`
+	MXCheck          bool                              `env:"MX_CHECK" envDefault:"false"`
+	MXTimeout        time.Duration                     `env:"MX_TIMEOUT" envDefault:"2s"`
+	MXCacheTTL       time.Duration                     `env:"MX_CACHE_TTL" envDefault:"1h"`
+	BodyTypes        []string                          `env:"BODY_CONTENT_TYPES" envDefault:"text/plain,text/markdown"`
+	ForceFromName    string                            `env:"FORCE_FROM_NAME"`
+	DefaultFrom      string                            `env:"DEFAULT_FROM_ADDRESS"`
+	DefaultFromName  string                            `env:"DEFAULT_FROM_NAME"`
+	DefaultReplyTo   string                            `env:"DEFAULT_REPLY_TO"`
+	SenderDomains    []string                          `env:"SENDER_DOMAINS"`
+	RejectSpoofy     bool                              `env:"REJECT_SPOOFY_FROM" envDefault:"false"`
+	ReturnPath       string                            `env:"RETURN_PATH"`
+	HeaderEncoding   string                            `env:"HEADER_ENCODING" envDefault:"Q"`
+	SMTPUTF8         bool                              `env:"SMTPUTF8" envDefault:"false"`
+	UnsubscribeURL   string                            `env:"UNSUBSCRIBE_URL"`
+	UnsubscribeKey   string                            `env:"UNSUBSCRIBE_SECRET"`
+	TrackingClickURL string                            `env:"TRACKING_CLICK_URL"`
+	TrackingOpenURL  string                            `env:"TRACKING_OPEN_URL"`
+	TrackingKey      string                            `env:"TRACKING_SECRET"`
+	DKIMKeys         dkim.Keys                         `env:"DKIM_KEYS"`
+	OTLPEndpoint     string                            `env:"OTEL_EXPORTER_OTLP_ENDPOINT"`
+	XRayTracing      bool                              `env:"XRAY_TRACING" envDefault:"false"`
+	StrictJSON       bool                              `env:"STRICT_JSON" envDefault:"false"`
+	StrictTemplates  bool                              `env:"STRICT_TEMPLATES" envDefault:"false"`
+	TextFromHTML     bool                              `env:"TEXT_FROM_HTML" envDefault:"false"`
+	MissingTemplates string                            `env:"MISSING_TEMPLATES" envDefault:"fail"`
+	MarkdownEnabled  bool                              `env:"MARKDOWN_TEMPLATES" envDefault:"false"`
+	MarkdownLayout   string                            `env:"MARKDOWN_LAYOUT"`
+	MJMLURL          string                            `env:"MJML_COMPILE_URL"`
+	MJMLAppID        string                            `env:"MJML_APP_ID"`
+	MJMLSecretKey    string                            `env:"MJML_SECRET_KEY"`
+	MJMLTimeout      time.Duration                     `env:"MJML_TIMEOUT" envDefault:"10s"`
+	TemplateEngine   string                            `env:"TEMPLATE_ENGINE" envDefault:"go"`
+	MustacheEnabled  bool                              `env:"MUSTACHE_TEMPLATES" envDefault:"false"`
+	TemplateCacheTTL time.Duration                     `env:"TEMPLATE_CACHE_TTL" envDefault:"0"`
+	TemplateCacheMax int                               `env:"TEMPLATE_CACHE_SIZE" envDefault:"0"`
+	NotFoundRetries  int                               `env:"TEMPLATE_NOTFOUND_RETRY" envDefault:"0"`
+	NotFoundBackoff  time.Duration                     `env:"TEMPLATE_NOTFOUND_BACKOFF" envDefault:"200ms"`
+}
+
+// shadow returns the shadow mode of the configuration.
+func (cfg Config) shadow() mailmessage.Shadow {
+	return mailmessage.Shadow{Percent: cfg.ShadowPercent, Templates: cfg.ShadowTemplates, Transports: cfg.ShadowTransports, Inbox: cfg.ShadowInbox}
+}
+
+// quietHours returns the quiet hours of the configuration, whose timezone is checked by validate.
+func (cfg Config) quietHours() mailmessage.QuietHours {
+	location, _ := time.LoadLocation(cfg.QuietTimezone)
+
+	return mailmessage.QuietHours{Window: cfg.QuietHours, Categories: cfg.QuietCategories, Location: location}
+}
+
+// validate checks the configuration is complete and coherent, so a misconfigured lambda fails at cold start instead of on every message.
+func (cfg Config) validate() error {
+	if cfg.AWSRegion == "" {
+		return fmt.Errorf("AWS_REGION_CODE is required")
+	}
+	if err := cfg.validateTemplateSource(); err != nil {
+		return err
+	}
+	if cfg.QueueURL == "" && !cfg.BatchFailures && len(cfg.KafkaBrokers) == 0 {
+		return fmt.Errorf("SQS_QUEUE is required to delete the processed messages, unless BATCH_ITEM_FAILURES is enabled")
+	}
+	if err := cfg.validateKafka(); err != nil {
+		return err
+	}
+	if cfg.HTTPAPIKey != "" && cfg.HTTPAnonymous {
+		return fmt.Errorf("HTTP_API_KEY and HTTP_ALLOW_ANONYMOUS are mutually exclusive")
+	}
+
+	if err := cfg.validateTransport(); err != nil {
+		return err
+	}
+	for name, profile := range cfg.Transports {
+		if err := cfg.withProfile(profile).validateTransport(); err != nil {
+			return fmt.Errorf("TRANSPORT_PROFILES profile %q is invalid: %s", name, err.Error())
+		}
+	}
+	for domain, rule := range cfg.DomainRules {
+		if _, ok := cfg.Transports[rule.Transport]; rule.Transport != "" && !ok {
+			return fmt.Errorf("DOMAIN_RULES transport %q of domain %s is not a configured transport profile", rule.Transport, domain)
+		}
+		if rule.Rate < 0 {
+			return fmt.Errorf("DOMAIN_RULES rate of domain %s must not be negative", domain)
+		}
+	}
+
+	if cfg.PreviewSnapshots && cfg.PreviewBucket == "" {
+		return fmt.Errorf("PREVIEW_SNAPSHOTS requires PREVIEW_BUCKET to be set")
+	}
+	if cfg.AttachmentLinks && cfg.MaxAttachment <= 0 {
+		return fmt.Errorf("ATTACHMENT_LINK_FALLBACK requires MAX_ATTACHMENT_BYTES to be set")
+	}
+	if cfg.UnsubscribeURL != "" && cfg.UnsubscribeKey == "" {
+		return fmt.Errorf("UNSUBSCRIBE_SECRET is required when UNSUBSCRIBE_URL is set")
+	}
+	if (cfg.TrackingClickURL != "" || cfg.TrackingOpenURL != "") && cfg.TrackingKey == "" {
+		return fmt.Errorf("TRACKING_SECRET is required when TRACKING_CLICK_URL or TRACKING_OPEN_URL is set")
+	}
+	for variable, charset := range map[string]string{"TEXT_CHARSET": cfg.TextCharset, "HTML_CHARSET": cfg.HTMLCharset} {
+		if enc, err := ianaindex.MIME.Encoding(charset); err != nil || enc == nil {
+			return fmt.Errorf("%s %q is not a known charset", variable, charset)
+		}
+	}
+	for _, contentType := range cfg.BodyTypes {
+		// The charset parameter is added when the part is written.
+		if mediaType, params, err := mime.ParseMediaType(contentType); err != nil || len(params) > 0 || !strings.Contains(mediaType, "/") {
+			return fmt.Errorf("BODY_CONTENT_TYPES %q is not a content type without parameters, ie: text/markdown", contentType)
+		}
+	}
+	if cfg.LogFormat != "text" && cfg.LogFormat != "json" {
+		return fmt.Errorf("LOG_FORMAT %q is unknown, expecting text or json", cfg.LogFormat)
+	}
+	for name, key := range cfg.DKIMKeys {
+		if (key.PrivateKey == "") == (key.PrivateKeySecret == "") {
+			return fmt.Errorf("DKIM_KEYS identity %q requires either a private_key or a private_key_secret", name)
+		}
+	}
+	switch cfg.HeaderEncoding {
+	case mailmessage.HeaderEncodingQ, mailmessage.HeaderEncodingB, mailmessage.HeaderEncodingASCII:
+	default:
+		return fmt.Errorf("HEADER_ENCODING %q is unknown, expecting Q, B or ASCII", cfg.HeaderEncoding)
+	}
+	if cfg.ReturnPath != "" {
+		if err := mailmessage.ValidateReturnPath(cfg.ReturnPath); err != nil {
+			return fmt.Errorf("RETURN_PATH %s", err.Error())
+		}
+	}
+	if cfg.SMTPPoolMaxIdle < 0 || cfg.SMTPPoolMaxMsgs < 0 {
+		return fmt.Errorf("SMTP_POOL_MAX_IDLE and SMTP_POOL_MAX_MESSAGES must not be negative")
+	}
+	if cfg.RenderTimeout < 0 || cfg.SendTimeout < 0 || cfg.MessageTimeout < 0 || cfg.DeadlineHeadroom < 0 {
+		return fmt.Errorf("RENDER_TIMEOUT, SEND_TIMEOUT, MESSAGE_TIMEOUT and DEADLINE_HEADROOM must not be negative")
+	}
+	if cfg.MXCheck && cfg.MXTimeout <= 0 {
+		return fmt.Errorf("MX_TIMEOUT must be positive when MX_CHECK is enabled")
+	}
+	switch cfg.ContextMarkup {
+	case mailmessage.ContextMarkupEscape, mailmessage.ContextMarkupStrip, mailmessage.ContextMarkupReject:
+	default:
+		return fmt.Errorf("CONTEXT_MARKUP %q is unknown, expecting escape, strip or reject", cfg.ContextMarkup)
+	}
+	if cfg.InvalidRcpts != mailmessage.InvalidRecipientsReject && cfg.InvalidRcpts != mailmessage.InvalidRecipientsDrop {
+		return fmt.Errorf("INVALID_RECIPIENTS %q is unknown, expecting reject or drop", cfg.InvalidRcpts)
+	}
+	if cfg.AttachmentPolicy != mailmessage.NonCompliantAttachmentsReject && cfg.AttachmentPolicy != mailmessage.NonCompliantAttachmentsStrip {
+		return fmt.Errorf("ATTACHMENT_POLICY %q is unknown, expecting reject or strip", cfg.AttachmentPolicy)
+	}
+	switch cfg.MissingTemplates {
+	case mailmessage.MissingTemplatesFail, mailmessage.MissingTemplatesText, mailmessage.MissingTemplatesHTML, mailmessage.MissingTemplatesAny:
+	default:
+		return fmt.Errorf("MISSING_TEMPLATES %q is unknown, expecting fail, text, html or any", cfg.MissingTemplates)
+	}
+	for _, category := range cfg.QuietCategories {
+		if category != mailmessage.CategoryTransactional && category != mailmessage.CategoryBulk {
+			return fmt.Errorf("QUIET_HOURS_CATEGORIES category %q is unknown, expecting %s or %s", category, mailmessage.CategoryTransactional, mailmessage.CategoryBulk)
+		}
+	}
+	if _, err := time.LoadLocation(cfg.QuietTimezone); err != nil {
+		return fmt.Errorf("QUIET_HOURS_TIMEZONE %q is unknown: %s", cfg.QuietTimezone, err.Error())
+	}
+	if cfg.SigningSecret != "" && cfg.SigningRefresh <= 0 {
+		return fmt.Errorf("SIGNING_KEYS_REFRESH must be positive when SIGNING_KEYS_SECRET is set")
+	}
+	if cfg.ClamAVAddress != "" && cfg.ClamAVTimeout <= 0 {
+		return fmt.Errorf("CLAMAV_TIMEOUT must be positive when CLAMAV_ADDRESS is set")
+	}
+	if cfg.TemplateEngine != "go" && cfg.TemplateEngine != "mustache" {
+		return fmt.Errorf("TEMPLATE_ENGINE %q is unknown, expecting go or mustache", cfg.TemplateEngine)
+	}
+	if err := cfg.Preprocessors.Validate(); err != nil {
+		return fmt.Errorf("TEMPLATE_PREPROCESSORS is invalid: %s", err.Error())
+	}
+	if err := cfg.Variants.Validate(); err != nil {
+		return fmt.Errorf("TEMPLATE_VARIANTS is invalid: %s", err.Error())
+	}
+	if cfg.RecipientInbox != "" {
+		if parsed, err := mail.ParseAddress(cfg.RecipientInbox); err != nil || parsed.Address != cfg.RecipientInbox {
+			return fmt.Errorf("RECIPIENT_OVERRIDE %q is not a valid address", cfg.RecipientInbox)
+		}
+	}
+	if err := cfg.shadow().Validate(); err != nil {
+		return fmt.Errorf("SHADOW_PERCENT or SHADOW_INBOX is invalid: %s", err.Error())
+	}
+	if len(cfg.WarmupSchedule) > 0 && cfg.WarmupTable == "" {
+		return fmt.Errorf("WARMUP_TABLE is required when WARMUP_SCHEDULE is set")
+	}
+	for day, limit := range cfg.WarmupSchedule {
+		if limit <= 0 {
+			return fmt.Errorf("WARMUP_SCHEDULE cap of day %d must be positive", day+1)
+		}
+	}
+	for variable, address := range map[string]string{"DEFAULT_FROM_ADDRESS": cfg.DefaultFrom, "DEFAULT_REPLY_TO": cfg.DefaultReplyTo} {
+		if parsed, err := mail.ParseAddress(address); address != "" && (err != nil || parsed.Address != address) {
+			return fmt.Errorf("%s %q is not a valid address", variable, address)
+		}
+	}
+	if cfg.IdempotencyTable != "" && cfg.IdempotencyTTL <= 0 {
+		return fmt.Errorf("IDEMPOTENCY_TTL must be positive when IDEMPOTENCY_TABLE is set")
+	}
+	if cfg.SuppressionTable != "" && cfg.SuppressBucket != "" {
+		return fmt.Errorf("SUPPRESSION_TABLE and SUPPRESSION_BUCKET can't be used together")
+	}
+	if cfg.EnrichTable != "" && cfg.EnrichURL != "" {
+		return fmt.Errorf("ENRICH_TABLE and ENRICH_URL can't be used together")
+	}
+	if (cfg.EnrichTable != "" || cfg.EnrichURL != "") && cfg.EnrichKey == "" {
+		return fmt.Errorf("ENRICH_CONTEXT_KEY is required when ENRICH_TABLE or ENRICH_URL is set")
+	}
+	if cfg.EnrichURL != "" && cfg.EnrichTimeout <= 0 {
+		return fmt.Errorf("ENRICH_TIMEOUT must be positive when ENRICH_URL is set")
+	}
+	if cfg.SuppressBucket != "" && cfg.SuppressKey == "" {
+		return fmt.Errorf("SUPPRESSION_KEY is required when SUPPRESSION_BUCKET is set")
+	}
+	if cfg.MaxAttachTotal < 0 || cfg.MaxBodyBytes < 0 {
+		return fmt.Errorf("MAX_TOTAL_ATTACHMENT_BYTES and MAX_BODY_BYTES can't be negative")
+	}
+	if cfg.TenantsBucket != "" && cfg.TenantsParameter != "" {
+		return fmt.Errorf("TENANTS_BUCKET and TENANTS_PARAMETER can't be used together")
+	}
+	if cfg.TenantsBucket != "" && cfg.TenantsKey == "" {
+		return fmt.Errorf("TENANTS_KEY is required when TENANTS_BUCKET is set")
+	}
+	if cfg.ArchiveSent && cfg.ArchiveBucket == "" {
+		return fmt.Errorf("ARCHIVE_BUCKET is required when ARCHIVE_SENT_MESSAGES is enabled")
+	}
+	if cfg.ProviderBurst < 1 {
+		return fmt.Errorf("PROVIDER_RATE_BURST must be at least 1")
+	}
+	for template, rate := range cfg.TemplateRates {
+		if rate <= 0 {
+			return fmt.Errorf("TEMPLATE_RATE_LIMITS rate of template %q must be positive", template)
+		}
+	}
+
+	return nil
+}
+
+// validateTemplateSource checks the settings required by the template source.
+func (cfg Config) validateTemplateSource() error {
+	switch cfg.TemplateSource {
+	case "s3":
+		if cfg.TemplateBucket == "" {
+			return fmt.Errorf("TEMPLATE_BUCKET is required when TEMPLATE_SOURCE is s3")
+		}
+	case "fs":
+		if cfg.TemplateDir == "" {
+			return fmt.Errorf("TEMPLATE_DIR is required when TEMPLATE_SOURCE is fs")
+		}
+	case "http":
+		if cfg.TemplateURL == "" {
+			return fmt.Errorf("TEMPLATE_URL is required when TEMPLATE_SOURCE is http")
+		}
+	case "gcs":
+		if cfg.TemplateBucket == "" {
+			return fmt.Errorf("TEMPLATE_BUCKET is required when TEMPLATE_SOURCE is gcs")
+		}
+	case "azure":
+		if cfg.TemplateBucket == "" || cfg.AzureAccount == "" {
+			return fmt.Errorf("TEMPLATE_BUCKET and AZURE_STORAGE_ACCOUNT are required when TEMPLATE_SOURCE is azure")
+		}
+		if cfg.AzureKey == "" && cfg.AzureSASToken == "" {
+			return fmt.Errorf("either AZURE_STORAGE_KEY or AZURE_STORAGE_SAS_TOKEN is required when TEMPLATE_SOURCE is azure")
+		}
+	default:
+		return fmt.Errorf("TEMPLATE_SOURCE %q is unknown, expecting s3, fs, http, gcs or azure", cfg.TemplateSource)
+	}
+
+	return nil
+}
+
+// validateKafka checks the settings of the Kafka consumer mode, enabled by KAFKA_BROKERS.
+func (cfg Config) validateKafka() error {
+	if len(cfg.KafkaBrokers) == 0 {
+		return nil
+	}
+	if cfg.KafkaTopic == "" || cfg.KafkaGroup == "" {
+		return fmt.Errorf("KAFKA_TOPIC and KAFKA_GROUP are required when KAFKA_BROKERS is set")
+	}
+	switch strings.ToLower(cfg.KafkaSASL) {
+	case "":
+		return nil
+	case kafkaSASLPlain:
+		// PLAIN sends the password as is.
+		if !cfg.KafkaTLS {
+			return fmt.Errorf("KAFKA_TLS is required when KAFKA_SASL_MECHANISM is plain")
+		}
+	case kafkaSASLSCRAMSHA256, kafkaSASLSCRAMSHA512:
+	default:
+		return fmt.Errorf("KAFKA_SASL_MECHANISM %q is unknown, expecting plain, scram-sha-256 or scram-sha-512", cfg.KafkaSASL)
+	}
+	if cfg.KafkaUserName == "" || cfg.KafkaPassword == "" {
+		return fmt.Errorf("KAFKA_SASL_USER and KAFKA_SASL_PASS are required when KAFKA_SASL_MECHANISM is set")
+	}
+
+	return nil
+}
+
+// validateTransport checks the settings required by the mail transport.
+func (cfg Config) validateTransport() error {
+	switch cfg.MailTransport {
+	case "smtp":
+		if cfg.SMTPHost == "" {
+			return fmt.Errorf("SMTP_HOST is required when MAIL_TRANSPORT is smtp")
+		}
+		if cfg.SMTPSecret != "" && cfg.SMTPParameter != "" {
+			return fmt.Errorf("SMTP_CREDENTIALS_SECRET_ARN and SMTP_CREDENTIALS_PARAMETER are mutually exclusive")
+		}
+		if cfg.SMTPUserName != "" && cfg.SMTPAuth == "" && cfg.SMTPPassword == "" && cfg.SMTPSecret == "" && cfg.SMTPParameter == "" {
+			return fmt.Errorf("SMTP_PASS, SMTP_CREDENTIALS_SECRET_ARN or SMTP_CREDENTIALS_PARAMETER is required when SMTP_USER is set")
+		}
+		switch cfg.SMTPAuth {
+		case "":
+		case "xoauth2":
+			if cfg.SMTPUserName == "" || cfg.SMTPTokenURL == "" || cfg.SMTPClientID == "" || cfg.SMTPClientSecret == "" {
+				return fmt.Errorf("SMTP_USER, SMTP_OAUTH_TOKEN_URL, SMTP_OAUTH_CLIENT_ID and SMTP_OAUTH_CLIENT_SECRET are required when SMTP_AUTH is xoauth2")
+			}
+		default:
+			return fmt.Errorf("SMTP_AUTH %q is unknown, expecting xoauth2 or nothing", cfg.SMTPAuth)
+		}
+		if cfg.SMTPTLS != "" {
+			if _, err := transport.ParseTLSMode(cfg.SMTPTLS); err != nil {
+				return fmt.Errorf("SMTP_TLS is invalid: %s", err.Error())
+			}
+		}
+		if _, err := transport.NewTLSConfig(cfg.SMTPHost, transport.TLSSettings{MinVersion: cfg.SMTPTLSMin}); err != nil {
+			return fmt.Errorf("SMTP_TLS_MIN_VERSION is invalid: %s", err.Error())
+		}
+	case "ses":
+	case "sendgrid":
+		if cfg.SendGridKey == "" {
+			return fmt.Errorf("SENDGRID_API_KEY is required when MAIL_TRANSPORT is sendgrid")
+		}
+	case "mailgun":
+		if cfg.MailgunDomain == "" || cfg.MailgunKey == "" {
+			return fmt.Errorf("MAILGUN_DOMAIN and MAILGUN_API_KEY are required when MAIL_TRANSPORT is mailgun")
+		}
+	default:
+		return fmt.Errorf("MAIL_TRANSPORT %q is unknown, expecting smtp, ses, sendgrid or mailgun", cfg.MailTransport)
+	}
+
+	if cfg.SMIMERecipients != "" && cfg.SMIMESecret == "" {
+		return fmt.Errorf("SMIME_SECRET is required when SMIME_RECIPIENTS_SECRET is set")
+	}
+	// SendGrid rebuilds the messages from their parts, which would break their signature.
+	if cfg.SMIMESecret != "" && cfg.MailTransport == "sendgrid" {
+		return fmt.Errorf("SMIME_SECRET can't be used when MAIL_TRANSPORT is sendgrid")
+	}
+
+	return nil
+}
@@ -0,0 +1,170 @@
+package handler
+
+import (
+	"github.com/forsam-education/hermes/transport"
+	"os"
+	"strings"
+	"testing"
+)
+
+// setEnv sets the environment variable, and returns the function restoring its previous value.
+func setEnv(name string, value string) func() {
+	previous, ok := os.LookupEnv(name)
+	os.Setenv(name, value)
+
+	return func() {
+		if ok {
+			os.Setenv(name, previous)
+		} else {
+			os.Unsetenv(name)
+		}
+	}
+}
+
+func TestParseConfigSMTPPort(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected transport.Port
+		err      bool
+	}{
+		{value: "submission", expected: transport.Port{Number: 587, TLS: transport.TLSStartTLS}},
+		{value: "smtps", expected: transport.Port{Number: 465, TLS: transport.TLSImplicit}},
+		{value: "smtp", expected: transport.Port{Number: 25, TLS: transport.TLSStartTLS}},
+		{value: "2525", expected: transport.Port{Number: 2525, TLS: transport.TLSStartTLS}},
+		{value: "submissions", err: true},
+	}
+
+	for _, test := range tests {
+		restore := setEnv("SMTP_PORT", test.value)
+		cfg, err := ParseConfig()
+		restore()
+		if test.err {
+			if err == nil || !strings.Contains(err.Error(), "invalid SMTP port \"submissions\"") {
+				t.Errorf("expected SMTP_PORT %q to fail, got %v", test.value, err)
+			}
+			continue
+		}
+		if err != nil || cfg.SMTPPort != test.expected {
+			t.Errorf("expected SMTP_PORT %q to be %+v, got %+v, %v", test.value, test.expected, cfg.SMTPPort, err)
+		}
+	}
+
+	cfg, err := ParseConfig()
+	if err != nil || cfg.SMTPPort != (transport.Port{Number: 465, TLS: transport.TLSImplicit}) {
+		t.Errorf("expected SMTP_PORT to default to 465 with implicit TLS, got %+v, %v", cfg.SMTPPort, err)
+	}
+}
+
+// validConfig returns the default configuration completed with the settings it requires.
+func validConfig(t *testing.T) Config {
+	t.Helper()
+
+	cfg, err := ParseConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.AWSRegion = "eu-west-1"
+	cfg.QueueURL = "https://sqs.eu-west-1.amazonaws.com/123456789012/hermes"
+	cfg.TemplateSource = "s3"
+	cfg.TemplateBucket = "templates"
+	cfg.MailTransport = "smtp"
+	cfg.SMTPHost = "smtp.example.com"
+
+	return cfg
+}
+
+func TestConfigValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(cfg *Config)
+		err    string
+	}{
+		{name: "accepts a complete configuration", change: func(cfg *Config) {}},
+		{name: "requires the region", change: func(cfg *Config) { cfg.AWSRegion = "" }, err: "AWS_REGION_CODE is required"},
+		{name: "requires the SMTP host of the smtp transport", change: func(cfg *Config) { cfg.SMTPHost = "" }, err: "SMTP_HOST is required when MAIL_TRANSPORT is smtp"},
+		{name: "accepts the ses transport without SMTP host", change: func(cfg *Config) { cfg.MailTransport = "ses"; cfg.SMTPHost = "" }},
+		{name: "rejects an unknown transport", change: func(cfg *Config) { cfg.MailTransport = "pigeon" }, err: `MAIL_TRANSPORT "pigeon" is unknown`},
+		{name: "requires the key of the sendgrid transport", change: func(cfg *Config) { cfg.MailTransport = "sendgrid" }, err: "SENDGRID_API_KEY is required when MAIL_TRANSPORT is sendgrid"},
+		{name: "requires the domain and key of the mailgun transport", change: func(cfg *Config) { cfg.MailTransport = "mailgun"; cfg.MailgunKey = "key" }, err: "MAILGUN_DOMAIN and MAILGUN_API_KEY are required"},
+		{name: "requires the bucket of s3 templates", change: func(cfg *Config) { cfg.TemplateBucket = "" }, err: "TEMPLATE_BUCKET is required when TEMPLATE_SOURCE is s3"},
+		{name: "requires the directory of fs templates", change: func(cfg *Config) { cfg.TemplateSource = "fs" }, err: "TEMPLATE_DIR is required when TEMPLATE_SOURCE is fs"},
+		{name: "rejects an unknown template source", change: func(cfg *Config) { cfg.TemplateSource = "ftp" }, err: `TEMPLATE_SOURCE "ftp" is unknown`},
+		{name: "requires the queue without batch item failures", change: func(cfg *Config) { cfg.QueueURL = "" }, err: "SQS_QUEUE is required"},
+		{name: "accepts batch item failures without queue", change: func(cfg *Config) { cfg.QueueURL = ""; cfg.BatchFailures = true }},
+		{name: "requires the SMTP password with a user", change: func(cfg *Config) { cfg.SMTPUserName = "hermes" }, err: "SMTP_PASS, SMTP_CREDENTIALS_SECRET_ARN or SMTP_CREDENTIALS_PARAMETER is required when SMTP_USER is set"},
+		{name: "rejects both SMTP credentials sources", change: func(cfg *Config) { cfg.SMTPSecret = "arn"; cfg.SMTPParameter = "/hermes/smtp" }, err: "mutually exclusive"},
+		{name: "rejects an unknown log format", change: func(cfg *Config) { cfg.LogFormat = "xml" }, err: `LOG_FORMAT "xml" is unknown`},
+		{name: "rejects an unknown charset", change: func(cfg *Config) { cfg.TextCharset = "klingon" }, err: `TEXT_CHARSET "klingon" is not a known charset`},
+		{name: "accepts the B header encoding", change: func(cfg *Config) { cfg.HeaderEncoding = "B" }},
+		{name: "rejects an unknown header encoding", change: func(cfg *Config) { cfg.HeaderEncoding = "base64" }, err: `HEADER_ENCODING "base64" is unknown, expecting Q, B or ASCII`},
+		{name: "accepts the body content types", change: func(cfg *Config) { cfg.BodyTypes = []string{"text/plain", " text/markdown"} }},
+		{name: "accepts the Kafka consumer mode without queue", change: func(cfg *Config) {
+			cfg.QueueURL = ""
+			cfg.KafkaBrokers = []string{"localhost:9092"}
+			cfg.KafkaTopic = "mail"
+			cfg.KafkaGroup = "hermes"
+		}},
+		{name: "requires the Kafka topic and group", change: func(cfg *Config) { cfg.KafkaBrokers = []string{"localhost:9092"}; cfg.KafkaTopic = "mail" }, err: "KAFKA_TOPIC and KAFKA_GROUP are required when KAFKA_BROKERS is set"},
+		{name: "accepts the Kafka SCRAM authentication", change: func(cfg *Config) {
+			cfg.KafkaBrokers = []string{"localhost:9096"}
+			cfg.KafkaTopic = "mail"
+			cfg.KafkaGroup = "hermes"
+			cfg.KafkaSASL = "SCRAM-SHA-512"
+			cfg.KafkaUserName = "hermes"
+			cfg.KafkaPassword = "secret"
+		}},
+		{name: "rejects an unknown Kafka SASL mechanism", change: func(cfg *Config) {
+			cfg.KafkaBrokers = []string{"localhost:9096"}
+			cfg.KafkaTopic = "mail"
+			cfg.KafkaGroup = "hermes"
+			cfg.KafkaSASL = "gssapi"
+		}, err: `KAFKA_SASL_MECHANISM "gssapi" is unknown`},
+		{name: "requires TLS for the Kafka PLAIN authentication", change: func(cfg *Config) {
+			cfg.KafkaBrokers = []string{"localhost:9096"}
+			cfg.KafkaTopic = "mail"
+			cfg.KafkaGroup = "hermes"
+			cfg.KafkaSASL = "plain"
+			cfg.KafkaUserName = "hermes"
+			cfg.KafkaPassword = "secret"
+		}, err: "KAFKA_TLS is required when KAFKA_SASL_MECHANISM is plain"},
+		{name: "requires the Kafka SASL credentials", change: func(cfg *Config) {
+			cfg.KafkaBrokers = []string{"localhost:9096"}
+			cfg.KafkaTopic = "mail"
+			cfg.KafkaGroup = "hermes"
+			cfg.KafkaSASL = "scram-sha-256"
+		}, err: "KAFKA_SASL_USER and KAFKA_SASL_PASS are required"},
+		{name: "accepts the required STARTTLS mode", change: func(cfg *Config) { cfg.SMTPTLS = "starttls-required" }},
+		{name: "rejects an unknown TLS mode", change: func(cfg *Config) { cfg.SMTPTLS = "opportunistic" }, err: "SMTP_TLS is invalid: invalid TLS mode \"opportunistic\", expecting starttls, starttls-required or implicit"},
+		{name: "rejects a body content type with parameters", change: func(cfg *Config) { cfg.BodyTypes = []string{"text/markdown; charset=UTF-8"} }, err: `BODY_CONTENT_TYPES "text/markdown; charset=UTF-8" is not a content type without parameters`},
+		{name: "rejects an invalid body content type", change: func(cfg *Config) { cfg.BodyTypes = []string{"markdown"} }, err: `BODY_CONTENT_TYPES "markdown" is not a content type`},
+		{name: "requires the unsubscribe secret", change: func(cfg *Config) { cfg.UnsubscribeURL = "https://example.com/u?t={{.Token}}" }, err: "UNSUBSCRIBE_SECRET is required"},
+		{name: "requires the archive bucket", change: func(cfg *Config) { cfg.ArchiveSent = true; cfg.ArchiveBucket = "" }, err: "ARCHIVE_BUCKET is required"},
+		{name: "rejects both API key and anonymous access", change: func(cfg *Config) { cfg.HTTPAPIKey = "key"; cfg.HTTPAnonymous = true }, err: "mutually exclusive"},
+		{name: "points at the invalid transport profile", change: func(cfg *Config) { cfg.Transports = transportProfiles{"bulk": {MailTransport: "smtp"}} }, err: `TRANSPORT_PROFILES profile "bulk" is invalid: SMTP_HOST is required`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := validConfig(t)
+			test.change(&cfg)
+			err := cfg.validate()
+			if test.err == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %s", err.Error())
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("expected error %q, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestNewFailsOnInvalidConfig(t *testing.T) {
+	cfg := validConfig(t)
+	cfg.SMTPHost = ""
+	if _, err := New(cfg, Services{}); err == nil || err.Error() != "invalid configuration: SMTP_HOST is required when MAIL_TRANSPORT is smtp" {
+		t.Errorf("expected the handler instantiation to fail, got %v", err)
+	}
+}
@@ -0,0 +1,26 @@
+package handler
+
+import (
+	"context"
+	"time"
+)
+
+// withHeadroom returns a context whose deadline is the given headroom before the one of the invocation, if it has one, leaving the time to
+// report the failed records before the lambda is killed.
+func withHeadroom(ctx context.Context, headroom time.Duration) (context.Context, context.CancelFunc) {
+	deadline, ok := ctx.Deadline()
+	if !ok || headroom <= 0 {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithDeadline(ctx, deadline.Add(-headroom))
+}
+
+// withTimeout returns a context bounded by the timeout, 0 meaning it is only bounded by its parent.
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, timeout)
+}
@@ -0,0 +1,40 @@
+package handler
+
+import (
+	"encoding/json"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/forsam-education/hermes/results"
+	"log"
+	"strconv"
+	"time"
+)
+
+// isEventBridgeEvent tells if the payload is an event delivered by an EventBridge rule, which has a detail-type and a detail.
+func isEventBridgeEvent(payload []byte) bool {
+	var event struct {
+		DetailType *string          `json:"detail-type"`
+		Source     string           `json:"source"`
+		Detail     *json.RawMessage `json:"detail"`
+	}
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return false
+	}
+
+	return event.DetailType != nil && event.Source != "" && event.Detail != nil
+}
+
+// eventBridgeRecords converts the EventBridge event to a record keyed by its event id, whose body is the message of its detail,
+// so it is sent the same way as the queued messages. The outcome events published by hermes are ignored, so a rule matching them can't loop.
+func eventBridgeRecords(event events.CloudWatchEvent) []events.SQSMessage {
+	if event.Source == results.EventSource {
+		log.Printf("Ignoring EventBridge event %s published by hermes", event.ID)
+		return nil
+	}
+
+	return []events.SQSMessage{{
+		MessageId:   event.ID,
+		Body:        string(event.Detail),
+		EventSource: "aws:events",
+		Attributes:  map[string]string{"SentTimestamp": strconv.FormatInt(event.Time.UnixNano()/int64(time.Millisecond), 10)},
+	}}
+}
@@ -0,0 +1,81 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/forsam-education/hermes/suppression"
+	"log"
+	"time"
+)
+
+// feedbackHandler consumes the SES bounce and complaint notifications, from an SNS topic or from the SQS queue subscribed to it,
+// and suppresses the hard-bounced and complaining addresses so they are not mailed anymore.
+type feedbackHandler struct {
+	cfg      Config
+	recorder suppression.Recorder
+}
+
+// newFeedbackHandler instanciates the feedback handler recording the suppressed addresses in SUPPRESSION_TABLE.
+func newFeedbackHandler(cfg Config) (*feedbackHandler, error) {
+	if cfg.SuppressionTable == "" {
+		return nil, fmt.Errorf("invalid configuration: SUPPRESSION_TABLE is required when FEEDBACK_PROCESSOR is enabled")
+	}
+
+	recorder, err := suppression.NewDynamoDB(cfg.SuppressionTable, cfg.AWSRegion)
+	if err != nil {
+		return nil, fmt.Errorf("unable to instantiate suppression list: %s", err.Error())
+	}
+
+	return &feedbackHandler{cfg: cfg, recorder: recorder}, nil
+}
+
+// processFeedback suppresses the addresses of the SES notification held by the record.
+func (fh *feedbackHandler) processFeedback(record events.SQSMessage) error {
+	feedback, err := suppression.ParseSESNotification(record.Body)
+	if err != nil {
+		// A message which is not a notification will never be one, so it is dropped rather than retried.
+		log.Printf("Ignoring message %s: %s", record.MessageId, err.Error())
+		return nil
+	}
+
+	for _, address := range feedback.Addresses {
+		if err := fh.recorder.Suppress(address, feedback.Reason, time.Now()); err != nil {
+			return fmt.Errorf("message %s: %s", record.MessageId, err.Error())
+		}
+		log.Printf("Suppressed %s after %s", address, feedback.Reason)
+	}
+
+	return nil
+}
+
+// HandleRequest processes the SNS or SQS event of SES notifications. With BATCH_ITEM_FAILURES, only the failed records of an SQS event are retried.
+func (fh *feedbackHandler) HandleRequest(ctx context.Context, payload json.RawMessage) (interface{}, error) {
+	var records []events.SQSMessage
+	queued := !isSNSEvent(payload)
+	if queued {
+		var event events.SQSEvent
+		if err := json.Unmarshal(payload, &event); err != nil {
+			return nil, fmt.Errorf("unable to unmarshal SQS event: %s", err.Error())
+		}
+		records = event.Records
+		unwrapSNSEnvelopes(records)
+	} else {
+		var event events.SNSEvent
+		if err := json.Unmarshal(payload, &event); err != nil {
+			return nil, fmt.Errorf("unable to unmarshal SNS event: %s", err.Error())
+		}
+		records = snsRecords(event)
+	}
+
+	response := processRecords(records, recordRetries, fh.processFeedback)
+	if queued && fh.cfg.BatchFailures {
+		return response, nil
+	}
+	if len(response.BatchItemFailures) > 0 {
+		return nil, fmt.Errorf("unable to process %d of the %d notifications", len(response.BatchItemFailures), len(records))
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,1029 @@
+// Package handler is the hermes lambda handler, with its commands, built from the configuration read from the environment variables.
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	"github.com/caarlos0/env/v6"
+	"github.com/forsam-education/hermes/antivirus"
+	"github.com/forsam-education/hermes/deadletter"
+	"github.com/forsam-education/hermes/dkim"
+	"github.com/forsam-education/hermes/enrichment"
+	"github.com/forsam-education/hermes/idempotency"
+	"github.com/forsam-education/hermes/logging"
+	"github.com/forsam-education/hermes/mailer"
+	"github.com/forsam-education/hermes/mailmessage"
+	"github.com/forsam-education/hermes/metrics"
+	"github.com/forsam-education/hermes/mjml"
+	"github.com/forsam-education/hermes/mxlookup"
+	"github.com/forsam-education/hermes/ratelimit"
+	"github.com/forsam-education/hermes/results"
+	"github.com/forsam-education/hermes/secrets"
+	"github.com/forsam-education/hermes/signing"
+	"github.com/forsam-education/hermes/smime"
+	"github.com/forsam-education/hermes/storage"
+	"github.com/forsam-education/hermes/suppression"
+	"github.com/forsam-education/hermes/templating"
+	"github.com/forsam-education/hermes/tracing"
+	"github.com/forsam-education/hermes/tracking"
+	"github.com/forsam-education/hermes/transport"
+	"github.com/forsam-education/hermes/unsubscribe"
+	"github.com/forsam-education/hermes/warmup"
+	"github.com/forsam-education/redriver"
+	"log"
+	"os"
+	"time"
+)
+
+// Handler holds the configuration and the services built at cold start, reused by every invocation of a warm lambda.
+type Handler struct {
+	cfg               Config
+	mailTransport     transport.Dialer
+	connectionPools   transport.Pools
+	keepConnections   bool
+	templateConnector storage.TemplateFetcher
+	attachmentWriter  storage.AttachmentCopier
+	payloadStorage    storage.BucketSwitcher
+	scheduleQueue     sqsiface.SQSAPI
+	resultsWriter     results.Writer
+	metrics           metrics.Recorder
+	prometheus        *metrics.Prometheus
+	logger            *logging.Logger
+	rejectedWriter    deadletter.Writer
+	failedWriter      deadletter.Writer
+	sentKeys          idempotency.Store
+	recordWorkers     *ratelimit.Semaphore
+	mailer            *mailer.Mailer
+}
+
+// newSMTPCredentials reads the SMTP credentials from Secrets Manager or Parameter Store, failing at cold start when they can't be read.
+func newSMTPCredentials(cfg Config) (*secrets.Credentials, error) {
+	var getter secrets.Getter
+	secretName := cfg.SMTPSecret
+	var err error
+	if secretName != "" {
+		getter, err = secrets.NewSecretsManager(cfg.AWSRegion)
+	} else {
+		secretName = cfg.SMTPParameter
+		getter, err = secrets.NewSSM(cfg.AWSRegion)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to instantiate secrets connector: %s", err.Error())
+	}
+
+	credentials := secrets.NewCredentials(getter, secretName, cfg.SMTPUserName, cfg.SMTPSecretTTL)
+	if _, _, err := credentials.Credentials(); err != nil {
+		return nil, fmt.Errorf("unable to read SMTP credentials: %s", err.Error())
+	}
+
+	return credentials, nil
+}
+
+// newSMIMEIdentity reads the S/MIME keys and recipient certificates of the transport from Secrets Manager, returning nil when messages are not signed.
+func newSMIMEIdentity(cfg Config) (*smime.Identity, error) {
+	if cfg.SMIMESecret == "" {
+		return nil, nil
+	}
+
+	secretsConnector, err := secrets.NewSecretsManager(cfg.AWSRegion)
+	if err != nil {
+		return nil, fmt.Errorf("unable to instantiate secrets connector: %s", err.Error())
+	}
+	keys, err := secretsConnector.Get(cfg.SMIMESecret)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read S/MIME keys: %s", err.Error())
+	}
+	var recipients string
+	if cfg.SMIMERecipients != "" {
+		if recipients, err = secretsConnector.Get(cfg.SMIMERecipients); err != nil {
+			return nil, fmt.Errorf("unable to read S/MIME recipient certificates: %s", err.Error())
+		}
+	}
+
+	return smime.NewIdentity(keys, recipients)
+}
+
+func newMailTransport(cfg Config) (transport.Dialer, error) {
+	switch cfg.MailTransport {
+	case "smtp":
+		port := cfg.SMTPPort
+		if cfg.SMTPTLS != "" {
+			port.TLS, _ = transport.ParseTLSMode(cfg.SMTPTLS)
+		}
+		tlsConfig, err := transport.NewTLSConfig(cfg.SMTPHost, transport.TLSSettings{MinVersion: cfg.SMTPTLSMin, CABundle: cfg.SMTPCABundle, InsecureSkipVerify: cfg.SMTPInsecureTLS})
+		if err != nil {
+			return nil, err
+		}
+		smtpTransport := transport.NewSMTP(cfg.SMTPHost, port, cfg.SMTPUserName, cfg.SMTPPassword)
+		smtpTransport.TLSConfig = tlsConfig
+		smtpTransport.GreetingRetries = cfg.SMTPGreetRetries
+		smtpTransport.GreetingBackoff = cfg.SMTPGreetBackoff
+		smtpTransport.Pipelining = cfg.SMTPPipelining
+		smtpTransport.Chunking = cfg.SMTPChunking
+		// The connections are bounded by the deadline of each message, so a slow relay can't outlive the invocation.
+		smtpTransport.Deadlines = true
+		if cfg.SMTPAuth == "xoauth2" {
+			smtpTransport.Tokens = transport.NewOAuth2ClientCredentials(cfg.SMTPTokenURL, cfg.SMTPClientID, cfg.SMTPClientSecret, cfg.SMTPScope)
+		}
+		if cfg.SMTPSecret != "" || cfg.SMTPParameter != "" {
+			credentials, err := newSMTPCredentials(cfg)
+			if err != nil {
+				return nil, err
+			}
+			smtpTransport.Credentials = credentials
+		}
+		return smtpTransport, nil
+	case "ses":
+		sesTransport, err := transport.NewSES(cfg.AWSRegion)
+		if err != nil {
+			return nil, err
+		}
+		sesTransport.ConfigurationSet = cfg.SESConfigSet
+		return sesTransport, nil
+	case "sendgrid":
+		return transport.NewSendGrid(cfg.SendGridKey), nil
+	case "mailgun":
+		return transport.NewMailgun(cfg.MailgunAPIBase, cfg.MailgunDomain, cfg.MailgunKey), nil
+	default:
+		return nil, fmt.Errorf("unknown mail transport %q", cfg.MailTransport)
+	}
+}
+
+// newTransportChain instanciates the mail transport of the configuration, wrapped to retry the transient SMTP failures and to reuse the
+// connections when enabled. The returned pool is nil unless the connections are reused.
+func newTransportChain(cfg Config) (transport.Dialer, *transport.Pool, error) {
+	mailTransport, err := newMailTransport(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	if cfg.MailTransport == "smtp" && cfg.SMTPSendRetries > 0 {
+		retry := transport.NewRetry(mailTransport)
+		retry.Retries = cfg.SMTPSendRetries
+		retry.Backoff = cfg.SMTPSendBackoff
+		retry.MaxElapsed = cfg.SMTPRetryBudget
+		mailTransport = retry
+	}
+	if cfg.SMTPReuseConns && cfg.MailTransport == "smtp" {
+		connectionPool := transport.NewPool(mailTransport)
+		connectionPool.MaxIdle = cfg.SMTPPoolMaxIdle
+		connectionPool.MaxMessages = cfg.SMTPPoolMaxMsgs
+		connectionPool.IdleTimeout = cfg.SMTPPoolIdleTTL
+		connectionPool.CheckAfter = cfg.SMTPPoolCheck
+		return connectionPool, connectionPool, nil
+	}
+
+	return mailTransport, nil, nil
+}
+
+// newS3Templates instanciates the S3 connector of a template bucket.
+func newS3Templates(cfg Config, bucket string) (*storage.S3, error) {
+	s3Connector, err := storage.NewS3(bucket, cfg.AWSRegion)
+	if err != nil {
+		return nil, err
+	}
+	s3Connector.DeniedAsNotFound = cfg.DeniedAsMissing
+
+	return s3Connector, nil
+}
+
+func newTemplateConnector(cfg Config) (storage.TemplateFetcher, error) {
+	switch cfg.TemplateSource {
+	case "s3":
+		return newS3Templates(cfg, cfg.TemplateBucket)
+	case "fs":
+		return storage.NewFileSystem(cfg.TemplateDir)
+	case "http":
+		return storage.NewHTTP(cfg.TemplateURL, cfg.TemplateAuth, cfg.TemplateTimeout)
+	case "gcs":
+		return storage.NewGCS(cfg.TemplateBucket, cfg.GCSCredentials)
+	case "azure":
+		return storage.NewAzureBlob(cfg.AzureAccount, cfg.TemplateBucket, cfg.AzureKey, cfg.AzureSASToken)
+	default:
+		return nil, fmt.Errorf("unknown template source %q", cfg.TemplateSource)
+	}
+}
+
+// resolveDKIMSecrets reads the private keys stored in AWS Secrets Manager, so they don't have to be written in the environment.
+func resolveDKIMSecrets(keys dkim.Keys, region string) error {
+	var secretsConnector *secrets.SecretsManager
+	for name, key := range keys {
+		if key.PrivateKeySecret == "" {
+			continue
+		}
+		if secretsConnector == nil {
+			var err error
+			if secretsConnector, err = secrets.NewSecretsManager(region); err != nil {
+				return fmt.Errorf("unable to instantiate secrets connector: %s", err.Error())
+			}
+		}
+		privateKey, err := secretsConnector.Get(key.PrivateKeySecret)
+		if err != nil {
+			return fmt.Errorf("unable to read private key of DKIM identity %q: %s", name, err.Error())
+		}
+		key.PrivateKey = privateKey
+		keys[name] = key
+	}
+
+	return nil
+}
+
+// Services are the services a Handler uses instead of those built from its configuration, ie: in-memory ones for integration tests.
+// The nil services are built from the configuration.
+type Services struct {
+	// Templates reads the templates instead of the TEMPLATE_SOURCE connector, still being cached as configured.
+	Templates storage.TemplateFetcher
+	// Attachments reads the attachments instead of the ATTACHMENT_BUCKET bucket.
+	Attachments storage.AttachmentCopier
+	// Transport sends the messages instead of the MAIL_TRANSPORT transport, being used as is.
+	Transport transport.Dialer
+	// Queue enqueues the messages scheduled later instead of the SQS client.
+	Queue sqsiface.SQSAPI
+	// SentKeys remembers the sent messages instead of the IDEMPOTENCY_TABLE table.
+	SentKeys idempotency.Store
+	// Rejected receives the invalid messages instead of the INVALID_MESSAGES_QUEUE queue.
+	Rejected deadletter.Writer
+	// Failed receives the messages which failed their last attempt instead of the FAILED_MESSAGES_BUCKET archive.
+	Failed deadletter.Writer
+	// Results receives the outcomes of the messages instead of the results destinations of the configuration.
+	Results results.Writer
+}
+
+// newHandler instanciates the Handler of the configuration, building every service from it.
+func newHandler(cfg Config) (*Handler, error) {
+	return New(cfg, Services{})
+}
+
+// New instanciates a Handler running the same pipeline as the lambda, with the given services instead of those of the configuration.
+func New(cfg Config, services Services) (*Handler, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %s", err.Error())
+	}
+
+	h := &Handler{cfg: cfg, logger: logging.NewLogger(cfg.LogLevel, cfg.LogFormat == "json", os.Stderr), recordWorkers: ratelimit.NewSemaphore(cfg.RecordWorkers)}
+	var err error
+	var connectionPool *transport.Pool
+	if h.mailTransport = services.Transport; h.mailTransport == nil {
+		if h.mailTransport, connectionPool, err = newTransportChain(cfg); err != nil {
+			return nil, fmt.Errorf("unable to instantiate mail transport: %s", err.Error())
+		}
+		h.connectionPools = append(h.connectionPools, connectionPool)
+	}
+	// The S/MIME identities are keyed by transport profile, the default transport being the empty name.
+	smimeIdentities := make(map[string]*smime.Identity)
+	if smimeIdentities[""], err = newSMIMEIdentity(cfg); err != nil {
+		return nil, err
+	}
+	profileTransports := make(map[string]transport.Dialer, len(cfg.Transports))
+	for name, profile := range cfg.Transports {
+		profileCfg := cfg.withProfile(profile)
+		if profileTransports[name], connectionPool, err = newTransportChain(profileCfg); err != nil {
+			return nil, fmt.Errorf("unable to instantiate mail transport of profile %q: %s", name, err.Error())
+		}
+		h.connectionPools = append(h.connectionPools, connectionPool)
+		if smimeIdentities[name], err = newSMIMEIdentity(profileCfg); err != nil {
+			return nil, fmt.Errorf("unable to instantiate S/MIME identity of profile %q: %s", name, err.Error())
+		}
+	}
+	if h.templateConnector = services.Templates; h.templateConnector == nil {
+		if h.templateConnector, err = newTemplateConnector(cfg); err != nil {
+			return nil, fmt.Errorf("unable to instantiate template connector: %s", err.Error())
+		}
+	}
+	if len(cfg.FallbackBuckets) > 0 {
+		templateConnectors := []storage.TemplateFetcher{h.templateConnector}
+		for _, bucket := range cfg.FallbackBuckets {
+			fallbackConnector, err := newS3Templates(cfg, bucket)
+			if err != nil {
+				return nil, fmt.Errorf("unable to instantiate fallback template connector: %s", err.Error())
+			}
+			templateConnectors = append(templateConnectors, fallbackConnector)
+		}
+		h.templateConnector = storage.NewChainConnector(templateConnectors...)
+	}
+	h.templateConnector = storage.NewVersionedConnector(h.templateConnector)
+	tenants, err := newTenants(cfg, h.templateConnector)
+	if err != nil {
+		return nil, fmt.Errorf("unable to instantiate tenants: %s", err.Error())
+	}
+	if cfg.TemplateCacheTTL > 0 {
+		templateCache := storage.NewCache(h.templateConnector, cfg.TemplateCacheTTL)
+		templateCache.MaxEntries = cfg.TemplateCacheMax
+		h.templateConnector = templateCache
+	}
+	if h.attachmentWriter = services.Attachments; h.attachmentWriter == nil {
+		if h.attachmentWriter, err = storage.NewS3(cfg.AttachmentBucket, cfg.AWSRegion); err != nil {
+			return nil, fmt.Errorf("unable to instantiate attachment writer: %s", err.Error())
+		}
+	}
+	if len(cfg.PayloadBuckets) > 0 {
+		if h.payloadStorage, err = storage.NewS3(cfg.PayloadBuckets[0], cfg.AWSRegion); err != nil {
+			return nil, fmt.Errorf("unable to instantiate payload reader: %s", err.Error())
+		}
+	}
+	if h.scheduleQueue = services.Queue; h.scheduleQueue == nil {
+		if h.scheduleQueue, err = newScheduleQueue(cfg.AWSRegion); err != nil {
+			return nil, fmt.Errorf("unable to instantiate schedule queue: %s", err.Error())
+		}
+	}
+	var resultsWriters results.Writers
+	if cfg.ResultsStream != "" {
+		streamWriter, err := results.NewStreamWriter(cfg.ResultsStream, cfg.AWSRegion)
+		if err != nil {
+			return nil, fmt.Errorf("unable to instantiate results writer: %s", err.Error())
+		}
+		resultsWriters = append(resultsWriters, streamWriter)
+	}
+	if cfg.ResultLambda != "" {
+		lambdaWriter, err := results.NewLambda(cfg.ResultLambda, cfg.AWSRegion)
+		if err != nil {
+			return nil, fmt.Errorf("unable to instantiate results callback: %s", err.Error())
+		}
+		resultsWriters = append(resultsWriters, lambdaWriter)
+	}
+	if cfg.ResultsEventBus != "" {
+		eventBridgeWriter, err := results.NewEventBridge(cfg.ResultsEventBus, cfg.AWSRegion)
+		if err != nil {
+			return nil, fmt.Errorf("unable to instantiate results event bus: %s", err.Error())
+		}
+		resultsWriters = append(resultsWriters, eventBridgeWriter)
+	}
+	if cfg.ResultsTopic != "" {
+		snsWriter, err := results.NewSNS(cfg.ResultsTopic, cfg.AWSRegion)
+		if err != nil {
+			return nil, fmt.Errorf("unable to instantiate results topic: %s", err.Error())
+		}
+		resultsWriters = append(resultsWriters, snsWriter)
+	}
+	if len(resultsWriters) > 0 {
+		h.resultsWriter = resultsWriters
+	}
+	if services.Results != nil {
+		h.resultsWriter = services.Results
+	}
+
+	h.rejectedWriter, h.failedWriter, h.sentKeys = services.Rejected, services.Failed, services.SentKeys
+	if cfg.RejectedQueue != "" && h.rejectedWriter == nil {
+		if h.rejectedWriter, err = deadletter.NewSQS(cfg.RejectedQueue, cfg.AWSRegion); err != nil {
+			return nil, fmt.Errorf("unable to instantiate rejected messages writer: %s", err.Error())
+		}
+	}
+
+	if cfg.FailedBucket != "" && h.failedWriter == nil {
+		if h.failedWriter, err = deadletter.NewS3(cfg.FailedBucket, cfg.FailedPrefix, cfg.AWSRegion); err != nil {
+			return nil, fmt.Errorf("unable to instantiate failed messages writer: %s", err.Error())
+		}
+	}
+
+	if cfg.IdempotencyTable != "" && h.sentKeys == nil {
+		if h.sentKeys, err = idempotency.NewDynamoDB(cfg.IdempotencyTable, cfg.IdempotencyTTL, cfg.AWSRegion); err != nil {
+			return nil, fmt.Errorf("unable to instantiate idempotency store: %s", err.Error())
+		}
+	}
+
+	var suppressions suppression.List
+	if cfg.SuppressionTable != "" {
+		if suppressions, err = suppression.NewDynamoDB(cfg.SuppressionTable, cfg.AWSRegion); err != nil {
+			return nil, fmt.Errorf("unable to instantiate suppression list: %s", err.Error())
+		}
+	}
+	if cfg.SuppressBucket != "" {
+		if suppressions, err = suppression.NewS3(cfg.SuppressBucket, cfg.SuppressKey, cfg.AWSRegion); err != nil {
+			return nil, fmt.Errorf("unable to instantiate suppression list: %s", err.Error())
+		}
+	}
+
+	var enrichers []mailmessage.ContextEnricher
+	if cfg.EnrichTable != "" || cfg.EnrichURL != "" {
+		var source enrichment.Source
+		if cfg.EnrichTable != "" {
+			source, err = enrichment.NewDynamoDB(cfg.EnrichTable, cfg.EnrichTableKey, cfg.AWSRegion)
+		} else {
+			source, err = enrichment.NewHTTP(cfg.EnrichURL, cfg.EnrichAuth, cfg.EnrichTimeout)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to instantiate context enrichment source: %s", err.Error())
+		}
+		enrichers = append(enrichers, mailmessage.ContextEnricher{Key: cfg.EnrichKey, Into: cfg.EnrichInto, Source: source})
+	}
+
+	var attachmentScanner antivirus.Scanner
+	if cfg.ClamAVAddress != "" {
+		attachmentScanner = antivirus.NewClamAV(cfg.ClamAVAddress, cfg.ClamAVTimeout)
+	}
+
+	var signingKeys signing.KeySource
+	if cfg.SigningSecret != "" {
+		secretsConnector, err := secrets.NewSecretsManager(cfg.AWSRegion)
+		if err != nil {
+			return nil, fmt.Errorf("unable to instantiate secrets connector: %s", err.Error())
+		}
+		secretKeys := signing.NewSecretKeys(secretsConnector, cfg.SigningSecret, cfg.SigningRefresh)
+		// The keys are read at cold start, so a missing secret fails the deployment rather than every message.
+		if _, err := secretKeys.Keys(); err != nil {
+			return nil, err
+		}
+		signingKeys = secretKeys
+	}
+
+	var recorders metrics.Recorders
+	if cfg.MetricsNamespace != "" {
+		recorders = append(recorders, metrics.NewEMF(cfg.MetricsNamespace, os.Stdout))
+	}
+	if cfg.MetricsAddress != "" {
+		h.prometheus = metrics.NewPrometheus("hermes")
+		recorders = append(recorders, h.prometheus)
+	}
+	if len(recorders) > 0 {
+		h.metrics = recorders
+	}
+
+	var warmupRamp *warmup.Ramp
+	if len(cfg.WarmupSchedule) > 0 {
+		warmupStore, err := warmup.NewDynamoDB(cfg.WarmupTable, cfg.AWSRegion)
+		if err != nil {
+			return nil, fmt.Errorf("unable to instantiate warmup store: %s", err.Error())
+		}
+		warmupRamp = warmup.NewRamp(cfg.WarmupSchedule, warmupStore)
+	}
+
+	var unsubscribeURLs *unsubscribe.URLBuilder
+	if cfg.UnsubscribeURL != "" {
+		if unsubscribeURLs, err = unsubscribe.NewURLBuilder(cfg.UnsubscribeKey, cfg.UnsubscribeURL); err != nil {
+			return nil, fmt.Errorf("unable to instantiate unsubscribe URL builder: %s", err.Error())
+		}
+	}
+
+	var trackingInjector *tracking.Injector
+	if cfg.TrackingClickURL != "" || cfg.TrackingOpenURL != "" {
+		if trackingInjector, err = tracking.NewInjector(cfg.TrackingKey, cfg.TrackingClickURL, cfg.TrackingOpenURL); err != nil {
+			return nil, fmt.Errorf("unable to instantiate tracking injector: %s", err.Error())
+		}
+	}
+
+	var dkimIdentities *dkim.Identities
+	if len(cfg.DKIMKeys) > 0 {
+		if err := resolveDKIMSecrets(cfg.DKIMKeys, cfg.AWSRegion); err != nil {
+			return nil, err
+		}
+		if dkimIdentities, err = dkim.NewIdentities(cfg.DKIMKeys); err != nil {
+			return nil, fmt.Errorf("unable to load DKIM keys: %s", err.Error())
+		}
+	}
+
+	mailOptions := mailmessage.Options{
+		Aliases:                 cfg.Aliases,
+		MaxContextBytes:         cfg.MaxContextBytes,
+		MaxFanOut:               cfg.MaxFanOut,
+		UndisclosedRecipients:   cfg.UndisclosedTo,
+		TextSignature:           cfg.TextSignature,
+		AttachmentBuckets:       cfg.AttachBuckets,
+		MaxAttachmentBytes:      cfg.MaxAttachment,
+		MaxTotalAttachmentBytes: cfg.MaxAttachTotal,
+		MaxBodyBytes:            cfg.MaxBodyBytes,
+		AttachmentLinkFallback:  cfg.AttachmentLinks,
+		AttachmentLinkExpiry:    cfg.AttachmentExpiry,
+		SendLimits:              ratelimit.NewController(cfg.MaxConcurrency, cfg.DomainSlots, cfg.TemplateRates, cfg.DomainRules.Rates(), cfg.ProviderRate, cfg.ProviderBurst),
+		Suppressions:            suppressions,
+		Enrichers:               enrichers,
+		Shadow:                  cfg.shadow(),
+		RecipientOverride:       cfg.RecipientInbox,
+		Warmup:                  warmupRamp,
+		RateLimitMaxWait:        cfg.RateLimitMaxWait,
+		RenderTimeout:           cfg.RenderTimeout,
+		SendTimeout:             cfg.SendTimeout,
+		TextCharset:             cfg.TextCharset,
+		HTMLCharset:             cfg.HTMLCharset,
+		AttachmentDigests:       cfg.AttachDigests,
+		MinifyHTML:              cfg.MinifyHTML,
+		SanitizeHTML:            cfg.SanitizeHTML,
+		ContextMarkup:           cfg.ContextMarkup,
+		InvalidRecipients:       cfg.InvalidRcpts,
+		Metrics:                 h.metrics,
+		DedupeAttachments:       cfg.DedupeAttach,
+		AllowedAttachmentTypes:  cfg.AttachmentTypes,
+		AttachmentScanner:       attachmentScanner,
+		NonCompliantAttachments: cfg.AttachmentPolicy,
+		Signatures:              signingKeys,
+		BodyContentTypes:        cfg.BodyTypes,
+		Unsubscribe:             unsubscribeURLs,
+		Tracking:                trackingInjector,
+		DKIM:                    dkimIdentities,
+		SMIME:                   smimeIdentities,
+		DefaultFromAddress:      cfg.DefaultFrom,
+		DefaultFromName:         cfg.DefaultFromName,
+		DefaultReplyTo:          cfg.DefaultReplyTo,
+		SenderDomains:           cfg.SenderDomains,
+		ForceFromName:           cfg.ForceFromName,
+		Transports:              profileTransports,
+		DomainRules:             cfg.DomainRules,
+		QuietHours:              cfg.quietHours(),
+		Tenants:                 tenants,
+		RejectSpoofyFrom:        cfg.RejectSpoofy,
+		ReturnPath:              cfg.ReturnPath,
+		HeaderEncoding:          cfg.HeaderEncoding,
+		SMTPUTF8:                cfg.SMTPUTF8,
+		Preprocessors:           cfg.Preprocessors,
+		Variants:                cfg.Variants,
+		StrictJSON:              cfg.StrictJSON,
+		StrictTemplates:         cfg.StrictTemplates,
+		TextFromHTML:            cfg.TextFromHTML,
+		MissingTemplates:        cfg.MissingTemplates,
+		MarkdownTemplates:       cfg.MarkdownEnabled,
+		MarkdownLayout:          cfg.MarkdownLayout,
+		TemplateNotFoundRetries: cfg.NotFoundRetries,
+		TemplateNotFoundBackoff: cfg.NotFoundBackoff,
+	}
+	if cfg.PreviewBucket != "" {
+		previews, err := storage.NewS3(cfg.PreviewBucket, cfg.AWSRegion)
+		if err != nil {
+			return nil, fmt.Errorf("unable to instantiate previews writer: %s", err.Error())
+		}
+		mailOptions.Previews = previews
+		mailOptions.PreviewPrefix = cfg.PreviewPrefix
+		if cfg.PreviewSnapshots {
+			mailOptions.Snapshots = mailmessage.NewSnapshots(previews, snapshotsPrefix(cfg))
+		}
+	}
+	if cfg.MJMLURL != "" {
+		mailOptions.MJML = mjml.NewAPI(cfg.MJMLURL, cfg.MJMLAppID, cfg.MJMLSecretKey, cfg.MJMLTimeout)
+	}
+	if cfg.MXCheck {
+		mailOptions.MXLookup = mxlookup.NewResolver(cfg.MXTimeout, cfg.MXCacheTTL)
+	}
+	if cfg.MustacheEnabled {
+		mailOptions.TemplateEngines = append(mailOptions.TemplateEngines, templating.NewMustache())
+	}
+	if cfg.TemplateEngine == "mustache" {
+		mailOptions.DefaultEngine = templating.NewMustache()
+	}
+	if cfg.ArchiveSent {
+		archive, err := storage.NewS3(cfg.ArchiveBucket, cfg.AWSRegion)
+		if err != nil {
+			return nil, fmt.Errorf("unable to instantiate archive writer: %s", err.Error())
+		}
+		mailOptions.Archive = archive
+		mailOptions.ArchivePrefix = cfg.ArchivePrefix
+	}
+	var exporters tracing.Exporters
+	if cfg.OTLPEndpoint != "" {
+		exporters = append(exporters, tracing.NewOTLP(cfg.OTLPEndpoint, "hermes"))
+	}
+	if cfg.XRayTracing {
+		exporters = append(exporters, tracing.NewXRay())
+	}
+	if len(exporters) > 0 {
+		mailOptions.Tracer = tracing.NewTracer(exporters)
+	}
+	mailOptions.Logger = h.logger
+	h.mailer = mailer.New(h.templateConnector, h.attachmentWriter, h.mailTransport)
+	h.mailer.Options = mailOptions
+
+	return h, nil
+}
+
+// recordRetries is how many times the redriver processes a record before reporting it as failed.
+const recordRetries = 3
+
+// handleSQSEvent sends the messages of the batch, unwrapping those delivered by an SNS subscription without raw message delivery.
+func (h *Handler) handleSQSEvent(ctx context.Context, event events.SQSEvent) (interface{}, error) {
+	unwrapSNSEnvelopes(event.Records)
+
+	return h.sendRecords(ctx, event.Records, true, nil)
+}
+
+// sendRecords sends the messages of the records. When batch item failures are reported, the failed records are listed in the returned response,
+// otherwise the redriver deletes the sent messages and an error is returned if any failed.
+// Records which were not queued, ie: SNS notifications, have no message to delete, and an error is returned if any failed so the event is retried.
+// The records having an unreadable failure, ie: a Kafka record value which can't be decoded, fail without being sent.
+func (h *Handler) sendRecords(ctx context.Context, records []events.SQSMessage, queued bool, unreadable map[string]error) (interface{}, error) {
+	putQueueLatencies(h.metrics, records)
+	if !h.keepConnections {
+		defer h.connectionPools.CloseIdle()
+	}
+
+	outcomes := newOutcomeRecorder()
+	messageRedriver := redriver.Redriver{Retries: recordRetries, ConsumedQueueURL: h.cfg.QueueURL}
+
+	var gate *priorityGate
+	if h.cfg.PriorityOrder {
+		gate = newPriorityGate(records, recordRetries)
+	}
+
+	var budget *retryBudget
+	if h.cfg.RetryBudget > 0 {
+		budget = newRetryBudget(h.cfg.RetryBudget)
+	}
+
+	// The records are given up before the lambda deadline, so they fail cleanly and are retried instead of the invocation being killed mid-send.
+	ctx, cancel := withHeadroom(ctx, h.cfg.DeadlineHeadroom)
+	defer cancel()
+	attempts := newAttemptCounter()
+	workerDeadline, ok := ctx.Deadline()
+	if !ok {
+		workerDeadline = time.Now().Add(time.Hour)
+	}
+	process := func(event events.SQSMessage) (err error) {
+		lastAttempt := attempts.next(event.MessageId) == recordRetries
+		// The record is archived as received, see sendRecord.
+		received := event
+		// Every attempt is settled whatever it returns from, so the retry budget, the priority gate, the failed messages archive and the outcomes
+		// see each of them.
+		var recorded bool
+		defer func() {
+			if !recorded {
+				outcomes.record(event.MessageId, "", err)
+			}
+			if err != nil && lastAttempt {
+				h.archiveFailure(received, err)
+			}
+			if budget != nil {
+				budget.record(event.MessageId, err)
+			}
+			if gate != nil {
+				gate.done(event.MessageId, err)
+			}
+			if err != nil {
+				err = fmt.Errorf("message %s: %s", event.MessageId, err.Error())
+			}
+		}()
+
+		if err := unreadable[event.MessageId]; err != nil {
+			return err
+		}
+		if budget != nil {
+			if err := budget.allow(event.MessageId); err != nil {
+				return err
+			}
+		}
+		if gate != nil {
+			gate.wait(event.MessageId)
+		}
+		// The worker slot is taken once the priority gate is open, so waiting records can't starve the ones allowed to run.
+		if err := h.recordWorkers.Acquire(workerDeadline); err != nil {
+			return fmt.Errorf("no worker available: %s", err.Error())
+		}
+		defer h.recordWorkers.Release()
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("not enough time left before the invocation deadline: %s", err.Error())
+		}
+		var outcome results.Outcome
+		received, outcome, err = h.sendRecord(ctx, event)
+		outcomes.put(outcome, err)
+		recorded = true
+		if err != nil && h.reject(received, err) {
+			// A rejected message is never retried, so it is done as if it was sent.
+			return nil
+		}
+
+		return err
+	}
+
+	if h.cfg.BatchFailures || !queued {
+		response := processRecords(records, recordRetries, process)
+		writeOutcomes(h.resultsWriter, outcomes.list(records))
+		if queued {
+			return response, nil
+		}
+		if len(response.BatchItemFailures) > 0 {
+			return nil, fmt.Errorf("unable to send %d of the %d messages", len(response.BatchItemFailures), len(records))
+		}
+		return nil, nil
+	}
+
+	err := messageRedriver.HandleMessages(records, process)
+	writeOutcomes(h.resultsWriter, outcomes.list(records))
+
+	return nil, err
+}
+
+// sendRecord sends the message of the record: a record holding a pointer to a payload offloaded to S3 is sent as if it held it, a signed message
+// is verified, a message sent later is scheduled, and a message already sent is skipped. It returns the record as received, with its resolved payload,
+// and the outcome of its message. The signed records are scheduled, rejected and archived as received, so they are verified again when received or replayed.
+func (h *Handler) sendRecord(ctx context.Context, event events.SQSMessage) (events.SQSMessage, results.Outcome, error) {
+	outcome := results.Outcome{MessageID: event.MessageId}
+	event, err := h.resolvePayload(event)
+	received := event
+	if err == nil {
+		event.Body, err = mailmessage.VerifySignature(event.Body, h.mailer.Options)
+	}
+	var deferred bool
+	if err == nil {
+		deferred, err = h.deferRecord(received, event.Body)
+	}
+	if deferred {
+		outcome.Status = results.StatusScheduled
+		return received, outcome, nil
+	}
+	var sentKey string
+	claimed := true
+	if err == nil {
+		sentKey, claimed, err = h.claimSend(event)
+	}
+	if err == nil && !claimed {
+		log.Printf("Skipping message %s, already sent with key %q", event.MessageId, sentKey)
+		outcome.Status = results.StatusSkipped
+		return received, outcome, nil
+	}
+	if err != nil {
+		return received, outcome, err
+	}
+
+	messageCtx, cancelMessage := withTimeout(ctx, h.cfg.MessageTimeout)
+	defer cancelMessage()
+	if mailmessage.IsFanOut(event.Body) {
+		err = h.sendFanOut(messageCtx, event)
+	} else {
+		start := time.Now()
+		outcome.ProviderID, err = h.mailer.Send(messageCtx, event.Body)
+		h.reportOutcome(event, outcome.ProviderID, time.Since(start), err)
+	}
+	// A message whose recipients are all suppressed is done as if it was sent, so it is never retried.
+	suppressed := mailmessage.IsSuppressed(err)
+	if suppressed {
+		err = nil
+	}
+	h.settleSend(sentKey, err)
+	if suppressed {
+		outcome.Status = results.StatusSuppressed
+	}
+
+	return received, outcome, err
+}
+
+// sendFanOut sends a copy of a fan-out message to each of its entries, reading each template once, the SMTP connections being reused when pooled.
+// Each copy is claimed with its own idempotency key, ie: "<message id>#3", so a retried message only sends the copies which were not sent.
+// It returns the error of the first failed copy once all of them were tried, a message whose copies are all suppressed being suppressed.
+func (h *Handler) sendFanOut(ctx context.Context, event events.SQSMessage) error {
+	bodies, err := mailmessage.ExpandFanOut(event.Body, h.mailer.Options)
+	if err != nil {
+		h.reportOutcome(event, "", 0, err)
+		return err
+	}
+
+	fanOutMailer := h.mailer.FanOut()
+	var firstErr error
+	var suppressedErr error
+	var delivered bool
+	for i, body := range bodies {
+		entry := event
+		entry.MessageId = fmt.Sprintf("%s#%d", event.MessageId, i)
+		entry.Body = body
+		key, claimed, err := h.claimSend(entry)
+		if err == nil && !claimed {
+			log.Printf("Skipping copy %s, already sent with key %q", entry.MessageId, key)
+			delivered = true
+			continue
+		}
+		if err == nil {
+			start := time.Now()
+			var providerID string
+			providerID, err = fanOutMailer.Send(ctx, entry.Body)
+			h.reportOutcome(entry, providerID, time.Since(start), err)
+			if mailmessage.IsSuppressed(err) {
+				suppressedErr, err = err, nil
+			} else if err == nil {
+				delivered = true
+			}
+			h.settleSend(key, err)
+		}
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	if firstErr != nil || delivered {
+		return firstErr
+	}
+
+	return suppressedErr
+}
+
+// claimSend reserves the idempotency key of a message, being its idempotency_key field or else its SQS message id, telling if it can be sent.
+// Every message can be sent when there is no idempotency store, and dry runs are never recorded as sent.
+func (h *Handler) claimSend(event events.SQSMessage) (string, bool, error) {
+	if h.sentKeys == nil || mailmessage.IsDryRun(event.Body) {
+		return "", true, nil
+	}
+
+	key := mailmessage.IdempotencyKey(event.Body)
+	if key == "" {
+		key = event.MessageId
+	}
+	claimed, err := h.sentKeys.Claim(key, time.Now())
+	if err != nil {
+		return key, false, fmt.Errorf("unable to check if message was already sent: %s", err.Error())
+	}
+
+	return key, claimed, nil
+}
+
+// settleSend records the claimed key as sent, or releases it so the message can be retried. Failures only are logged, as the message is already processed.
+func (h *Handler) settleSend(key string, err error) {
+	if h.sentKeys == nil || key == "" {
+		return
+	}
+
+	if err != nil {
+		if releaseErr := h.sentKeys.Release(key); releaseErr != nil {
+			log.Printf("Unable to release idempotency key %q: %s", key, releaseErr.Error())
+		}
+		return
+	}
+	if completeErr := h.sentKeys.Complete(key, time.Now()); completeErr != nil {
+		log.Printf("Unable to record idempotency key %q as sent: %s", key, completeErr.Error())
+	}
+}
+
+// reject sends an invalid message to the rejected messages queue, if any, telling if it was, in which case the record is not retried.
+func (h *Handler) reject(event events.SQSMessage, err error) bool {
+	if h.rejectedWriter == nil || mailmessage.ErrorPhase(err) != mailmessage.PhaseDecode {
+		return false
+	}
+
+	if writeErr := h.rejectedWriter.Write(event.MessageId, event.Body, err); writeErr != nil {
+		log.Printf("Unable to reject message %s: %s", event.MessageId, writeErr.Error())
+		return false
+	}
+	log.Printf("Rejected invalid message %s: %s", event.MessageId, err.Error())
+
+	return true
+}
+
+// archiveFailure keeps a message which failed its last attempt in the failed messages archive, if any, so it can be inspected and replayed.
+func (h *Handler) archiveFailure(event events.SQSMessage, err error) {
+	if h.failedWriter == nil {
+		return
+	}
+
+	if writeErr := h.failedWriter.Write(event.MessageId, event.Body, err); writeErr != nil {
+		log.Printf("Unable to archive failed message %s: %s", event.MessageId, writeErr.Error())
+	}
+}
+
+// reportOutcome logs the result of sending a message, with its id, template and main recipient domain as fields, and emits its metrics.
+func (h *Handler) reportOutcome(event events.SQSMessage, providerID string, duration time.Duration, err error) {
+	putSendMetrics(h.metrics, duration, err)
+
+	templateName, recipientDomain := mailmessage.DescribeMessage(event.Body)
+	fields := logging.Fields{
+		"message_id":       event.MessageId,
+		"template":         templateName,
+		"recipient_domain": recipientDomain,
+		"duration_ms":      int64(duration / time.Millisecond),
+	}
+	if variant := mailmessage.DescribeVariant(event.Body, h.mailer.Options); variant != "" {
+		fields["variant"] = variant
+		putVariantMetric(h.metrics, templateName, variant, err)
+	}
+	if mailmessage.IsSuppressed(err) {
+		fields["result"] = results.StatusSuppressed
+		fields["error"] = err.Error()
+		h.logger.Log(logging.LevelInfo, "Message suppressed", fields)
+		return
+	}
+	if err != nil {
+		fields["result"] = results.StatusFailed
+		fields["error"] = err.Error()
+		h.logger.Log(logging.LevelError, "Unable to send message", fields)
+		return
+	}
+
+	fields["result"] = results.StatusSent
+	if providerID != "" {
+		fields["provider_id"] = providerID
+	}
+	h.logger.Log(logging.LevelInfo, "Message sent", fields)
+}
+
+// HandleRequest is the main handler function used by the lambda runtime for the incoming event.
+// The event is either an SQS event or a management action, ie: {"action":"replay","key":"archive/..."}, whose response is returned.
+func (h *Handler) HandleRequest(ctx context.Context, payload json.RawMessage) (interface{}, error) {
+	ctx, span := h.mailer.Options.Tracer.StartSpan(ctx, "HandleRequest")
+	response, err := h.handlePayload(ctx, payload)
+	span.End(err)
+
+	if flushErr := h.mailer.Options.Tracer.Flush(); flushErr != nil {
+		log.Printf("Unable to export traces: %s", flushErr.Error())
+	}
+
+	return response, err
+}
+
+func (h *Handler) handlePayload(ctx context.Context, payload json.RawMessage) (interface{}, error) {
+	var action managementAction
+	if err := json.Unmarshal(payload, &action); err != nil {
+		return nil, fmt.Errorf("unable to unmarshal event: %s", err.Error())
+	}
+	if action.Action != "" {
+		return h.handleAction(action)
+	}
+
+	if isHTTPRequest(payload) {
+		return h.handleHTTPRequest(ctx, payload)
+	}
+	if isSNSEvent(payload) {
+		var event events.SNSEvent
+		if err := json.Unmarshal(payload, &event); err != nil {
+			return nil, fmt.Errorf("unable to unmarshal SNS event: %s", err.Error())
+		}
+		return h.sendRecords(ctx, snsRecords(event), false, nil)
+	}
+	if isEventBridgeEvent(payload) {
+		var event events.CloudWatchEvent
+		if err := json.Unmarshal(payload, &event); err != nil {
+			return nil, fmt.Errorf("unable to unmarshal EventBridge event: %s", err.Error())
+		}
+		return h.sendRecords(ctx, eventBridgeRecords(event), false, nil)
+	}
+	if isKafkaEvent(payload) {
+		var event kafkaEvent
+		if err := json.Unmarshal(payload, &event); err != nil {
+			return nil, fmt.Errorf("unable to unmarshal Kafka event: %s", err.Error())
+		}
+		records, undecodable := kafkaRecords(event)
+		return h.sendRecords(ctx, records, false, undecodable)
+	}
+
+	var event events.SQSEvent
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return nil, fmt.Errorf("unable to unmarshal SQS event: %s", err.Error())
+	}
+
+	return h.handleSQSEvent(ctx, event)
+}
+
+// Mailer returns the mailer running the pipeline, whose Options hold the behaviours of the configuration.
+func (h *Handler) Mailer() *mailer.Mailer {
+	return h.mailer
+}
+
+// ParseConfig reads the configuration from the environment variables, with their default values.
+func ParseConfig() (Config, error) {
+	cfg := Config{}
+	if err := env.Parse(&cfg); err != nil {
+		return cfg, fmt.Errorf("unable to parse configuration: %s", err.Error())
+	}
+
+	return cfg, nil
+}
+
+// Main runs the hermes command: a subcommand given as argument, or else the lambda handler of the configuration.
+func Main() {
+	cfg, err := ParseConfig()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	if len(os.Args) > 1 && os.Args[1] == "validate-templates" {
+		if err := validateTemplates(cfg, os.Args[2:]); err != nil {
+			log.Fatalf("unable to validate templates: %s", err.Error())
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		if err := replay(cfg, os.Args[2:]); err != nil {
+			log.Fatalf("unable to replay messages: %s", err.Error())
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "snapshot" {
+		if err := snapshotTemplate(cfg, os.Args[2:]); err != nil {
+			log.Fatalf("unable to snapshot template: %s", err.Error())
+		}
+		return
+	}
+	if len(os.Args) > 1 && (os.Args[1] == "send" || os.Args[1] == "render") {
+		if err := sendFile(cfg, os.Args[1], os.Args[2:]); err != nil {
+			log.Fatalf("unable to %s: %s", os.Args[1], err.Error())
+		}
+		return
+	}
+	if cfg.FeedbackMode {
+		fh, err := newFeedbackHandler(cfg)
+		if err != nil {
+			log.Fatalf("unable to initialize hermes: %s", err.Error())
+		}
+		lambda.Start(fh.HandleRequest)
+		return
+	}
+
+	h, err := newHandler(cfg)
+	if err != nil {
+		log.Fatalf("unable to initialize hermes: %s", err.Error())
+	}
+	if cfg.LogFormat == "json" {
+		log.SetFlags(0)
+		log.SetOutput(h.logger.Writer(logging.LevelInfo))
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "serve" {
+		if err := serve(h); err != nil {
+			log.Fatalf("unable to serve: %s", err.Error())
+		}
+		return
+	}
+
+	lambda.Start(h.HandleRequest)
+}
@@ -0,0 +1,174 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/forsam-education/hermes/idempotency"
+	"github.com/forsam-education/hermes/storage"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// failingStore is an idempotency store which is unavailable.
+type failingStore struct{}
+
+func (failingStore) Claim(key string, now time.Time) (bool, error) {
+	return false, errors.New("table is unavailable")
+}
+
+func (failingStore) Complete(key string, now time.Time) error {
+	return errors.New("table is unavailable")
+}
+
+func (failingStore) Release(key string) error {
+	return errors.New("table is unavailable")
+}
+
+func newIdempotentTestHandler(t *testing.T, dialer *flakyDialer, sentKeys idempotency.Store) *Handler {
+	t.Helper()
+
+	templates := storage.NewMemory()
+	templates.Set("welcome.html.template", []byte("<p>Hello {{.name}}</p>"))
+	templates.Set("welcome.txt.template", []byte("Hello {{.name}}"))
+	cfg := validConfig(t)
+	cfg.BatchFailures = true
+	h, err := New(cfg, Services{Templates: templates, Transport: dialer, SentKeys: sentKeys})
+	if err != nil {
+		t.Fatalf("unable to instantiate handler: %s", err.Error())
+	}
+
+	return h
+}
+
+func idempotentBody(fields string) string {
+	return `{"from_address": "noreply@example.com", "to": ["jane@example.org"], "subject": "Welcome", "template_name": "welcome", "template_context": {"name": "Jane"}` + fields + `}`
+}
+
+func sendTestRecords(t *testing.T, h *Handler, records ...events.SQSMessage) []string {
+	t.Helper()
+
+	response, err := h.sendRecords(context.Background(), records, true, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	var failed []string
+	for _, failure := range response.(*sqsBatchResponse).BatchItemFailures {
+		failed = append(failed, failure.ItemIdentifier)
+	}
+
+	return failed
+}
+
+func TestSendRecordsSkipsSentMessages(t *testing.T) {
+	tests := []struct {
+		name   string
+		first  events.SQSMessage
+		second events.SQSMessage
+		sends  int
+		sent   []string
+	}{
+		{
+			name:   "skips a message received again",
+			first:  events.SQSMessage{MessageId: "message-1", Body: idempotentBody("")},
+			second: events.SQSMessage{MessageId: "message-1", Body: idempotentBody("")},
+			sends:  1,
+			sent:   []string{"message-1"},
+		},
+		{
+			name:   "skips a message sent again with the same idempotency key",
+			first:  events.SQSMessage{MessageId: "message-1", Body: idempotentBody(`, "idempotency_key": "welcome-jane"`)},
+			second: events.SQSMessage{MessageId: "message-2", Body: idempotentBody(`, "idempotency_key": "welcome-jane"`)},
+			sends:  1,
+			sent:   []string{"welcome-jane"},
+		},
+		{
+			name:   "sends the messages with other idempotency keys",
+			first:  events.SQSMessage{MessageId: "message-1", Body: idempotentBody(`, "idempotency_key": "welcome-jane"`)},
+			second: events.SQSMessage{MessageId: "message-1", Body: idempotentBody(`, "idempotency_key": "welcome-jane-again"`)},
+			sends:  2,
+			sent:   []string{"welcome-jane", "welcome-jane-again"},
+		},
+		{
+			name:   "never records dry runs",
+			first:  events.SQSMessage{MessageId: "message-1", Body: idempotentBody(`, "dry_run": true`)},
+			second: events.SQSMessage{MessageId: "message-1", Body: idempotentBody("")},
+			sends:  1,
+			sent:   []string{"message-1"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dialer := &flakyDialer{}
+			sentKeys := idempotency.NewMemory(time.Hour)
+			h := newIdempotentTestHandler(t, dialer, sentKeys)
+
+			for _, record := range []events.SQSMessage{test.first, test.second} {
+				if failed := sendTestRecords(t, h, record); len(failed) != 0 {
+					t.Fatalf("expected %s to succeed, got failures %v", record.MessageId, failed)
+				}
+			}
+			if sends := dialer.Sends("jane@example.org"); sends != test.sends {
+				t.Errorf("expected %d sends, got %d", test.sends, sends)
+			}
+			if sent := sentKeys.Sent(); !reflect.DeepEqual(sent, test.sent) {
+				t.Errorf("expected the keys %v to be recorded as sent, got %v", test.sent, sent)
+			}
+		})
+	}
+}
+
+func TestSendRecordsReleasesFailedSends(t *testing.T) {
+	// The message fails every attempt of the first invocation.
+	dialer := &flakyDialer{flaky: "jane@example.org", failures: recordRetries}
+	sentKeys := idempotency.NewMemory(time.Hour)
+	h := newIdempotentTestHandler(t, dialer, sentKeys)
+	record := events.SQSMessage{MessageId: "message-1", Body: idempotentBody("")}
+
+	if failed := sendTestRecords(t, h, record); !reflect.DeepEqual(failed, []string{"message-1"}) {
+		t.Fatalf("expected the message to fail, got failures %v", failed)
+	}
+	if sent := sentKeys.Sent(); len(sent) != 0 {
+		t.Fatalf("expected a failed message not to be recorded as sent, got %v", sent)
+	}
+
+	// Its claim is released, so the redelivered message is sent.
+	if failed := sendTestRecords(t, h, record); len(failed) != 0 {
+		t.Fatalf("expected the retry to succeed, got failures %v", failed)
+	}
+	if sends := dialer.Sends("jane@example.org"); sends != 1 {
+		t.Errorf("expected the retry to send the message, got %d sends", sends)
+	}
+	if sent := sentKeys.Sent(); !reflect.DeepEqual(sent, []string{"message-1"}) {
+		t.Errorf("expected the retried message to be recorded as sent, got %v", sent)
+	}
+}
+
+func TestSendRecordsRetriesWithinInvocation(t *testing.T) {
+	// The retries of the invocation claim the key released by the failed attempt.
+	dialer := &flakyDialer{flaky: "jane@example.org", failures: recordRetries - 1}
+	sentKeys := idempotency.NewMemory(time.Hour)
+	h := newIdempotentTestHandler(t, dialer, sentKeys)
+
+	if failed := sendTestRecords(t, h, events.SQSMessage{MessageId: "message-1", Body: idempotentBody("")}); len(failed) != 0 {
+		t.Fatalf("expected the last attempt to succeed, got failures %v", failed)
+	}
+	if sends := dialer.Sends("jane@example.org"); sends != 1 {
+		t.Errorf("expected the message to be sent once, got %d sends", sends)
+	}
+}
+
+func TestClaimSend(t *testing.T) {
+	h := newIdempotentTestHandler(t, &flakyDialer{}, failingStore{})
+	if _, claimed, err := h.claimSend(events.SQSMessage{MessageId: "message-1", Body: idempotentBody("")}); claimed || err == nil || !strings.HasPrefix(err.Error(), "unable to check if message was already sent") {
+		t.Errorf("expected the claim to fail, got %t, %v", claimed, err)
+	}
+
+	h = newIdempotentTestHandler(t, &flakyDialer{}, nil)
+	if key, claimed, err := h.claimSend(events.SQSMessage{MessageId: "message-1", Body: idempotentBody("")}); key != "" || !claimed || err != nil {
+		t.Errorf("expected every message to be sent without store, got %q, %t, %v", key, claimed, err)
+	}
+}
@@ -0,0 +1,91 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/forsam-education/hermes/storage"
+	"net/http"
+	"sync/atomic"
+)
+
+// readinessProbeTemplate is the template fetched to check the template storage is reachable, its absence being the expected answer.
+const readinessProbeTemplate = "_readiness_probe.html.template"
+
+// healthChecks serves the /healthz and /readyz endpoints of the daemon mode, so orchestrators restart the dead instances and stop routing to the unready ones.
+type healthChecks struct {
+	h *Handler
+	// stopping is set once the daemon was asked to stop, making it unready while it completes its current batch.
+	stopping int32
+}
+
+// checkTemplates fetches a template which does not exist, so an answer other than not found tells the storage can't be reached.
+func (checks *healthChecks) checkTemplates() error {
+	_, err := storage.Uncached(checks.h.templateConnector).Fetch(readinessProbeTemplate)
+	if err != nil && !storage.IsNotFound(err) {
+		return err
+	}
+
+	return nil
+}
+
+// checkTransport opens a connection of the mail transport, greeting the SMTP server and authenticating, then closes it or returns it to the pool.
+func (checks *healthChecks) checkTransport() error {
+	sender, err := checks.h.mailTransport.Dial()
+	if err != nil {
+		return err
+	}
+
+	return sender.Close()
+}
+
+// writeStatus answers a probe with the given status and JSON body.
+func writeStatus(w http.ResponseWriter, status int, body interface{}) {
+	encoded, _ := json.Marshal(body)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(encoded)
+}
+
+// healthz answers 200 as long as the process serves requests.
+func (checks *healthChecks) healthz(w http.ResponseWriter, _ *http.Request) {
+	writeStatus(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// readyz checks the template storage and the mail transport, answering 503 with the failed checks when one of them fails, or when the daemon is stopping.
+func (checks *healthChecks) readyz(w http.ResponseWriter, _ *http.Request) {
+	if atomic.LoadInt32(&checks.stopping) == 1 {
+		writeStatus(w, http.StatusServiceUnavailable, map[string]string{"status": "stopping"})
+		return
+	}
+
+	results := map[string]string{"templates": "ok", "transport": "ok"}
+	status := http.StatusOK
+	if err := checks.checkTemplates(); err != nil {
+		results["templates"] = fmt.Sprintf("unable to reach template storage: %s", err.Error())
+		status = http.StatusServiceUnavailable
+	}
+	if err := checks.checkTransport(); err != nil {
+		results["transport"] = fmt.Sprintf("unable to connect to mail transport: %s", err.Error())
+		status = http.StatusServiceUnavailable
+	}
+
+	readiness := "ready"
+	if status != http.StatusOK {
+		readiness = "unready"
+	}
+	writeStatus(w, status, map[string]interface{}{"status": readiness, "checks": results})
+}
+
+// stop makes the daemon unready, so no more traffic is routed to it while it stops.
+func (checks *healthChecks) stop() {
+	if checks == nil {
+		return
+	}
+
+	atomic.StoreInt32(&checks.stopping, 1)
+}
+
+// newHealthChecks instanciates the health checks of the handler services.
+func newHealthChecks(h *Handler) *healthChecks {
+	return &healthChecks{h: h}
+}
@@ -0,0 +1,127 @@
+package handler
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/forsam-education/hermes/mailmessage"
+	"github.com/forsam-education/hermes/results"
+	"net/http"
+	"strings"
+)
+
+// httpRequest holds the fields shared by the API Gateway REST proxy and the HTTP API or Function URL payloads, whose method is not found at the same place.
+type httpRequest struct {
+	HTTPMethod     string            `json:"httpMethod"`
+	Headers        map[string]string `json:"headers"`
+	RequestContext struct {
+		RequestID string `json:"requestId"`
+		HTTP      struct {
+			Method string `json:"method"`
+		} `json:"http"`
+	} `json:"requestContext"`
+	Body            string `json:"body"`
+	IsBase64Encoded bool   `json:"isBase64Encoded"`
+}
+
+// method returns the HTTP method of the request, whichever its payload format is.
+func (request *httpRequest) method() string {
+	if request.HTTPMethod != "" {
+		return request.HTTPMethod
+	}
+
+	return request.RequestContext.HTTP.Method
+}
+
+// header returns the value of the named request header. API Gateway keeps the header names as sent, while Function URLs lower them.
+func (request *httpRequest) header(name string) string {
+	for headerName, value := range request.Headers {
+		if strings.EqualFold(headerName, name) {
+			return value
+		}
+	}
+
+	return ""
+}
+
+// isHTTPRequest tells if the payload is an HTTP request proxied by API Gateway or a Function URL.
+func isHTTPRequest(payload []byte) bool {
+	var request httpRequest
+	if err := json.Unmarshal(payload, &request); err != nil {
+		return false
+	}
+
+	return request.method() != ""
+}
+
+// httpResponse returns a response with the given status, whose JSON body has the given field. The same format is accepted by every HTTP payload version.
+func httpResponse(status int, field string, value string) *events.APIGatewayProxyResponse {
+	body, _ := json.Marshal(map[string]string{field: value})
+
+	return &events.APIGatewayProxyResponse{
+		StatusCode: status,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(body),
+	}
+}
+
+// httpStatus returns the status answering a failed send: the client is at fault for an invalid message or template, and the mail provider for a failed delivery.
+func httpStatus(err error) int {
+	switch mailmessage.ErrorPhase(err) {
+	case mailmessage.PhaseDecode:
+		return http.StatusBadRequest
+	case mailmessage.PhaseRender:
+		return http.StatusUnprocessableEntity
+	case mailmessage.PhaseDial, mailmessage.PhaseSend:
+		return http.StatusBadGateway
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// handleHTTPRequest synchronously sends the message posted as request body through the same pipeline as the queued records, answering 202
+// once it is accepted by the mail transport, or 200 when it is not sent as every recipient is suppressed or as it was already sent.
+// A message scheduled later is enqueued to SQS_QUEUE instead, and answered 202 too.
+// The errors are answered with a status telling their cause, so they are never returned to the lambda runtime.
+func (h *Handler) handleHTTPRequest(ctx context.Context, payload []byte) (*events.APIGatewayProxyResponse, error) {
+	var request httpRequest
+	if err := json.Unmarshal(payload, &request); err != nil {
+		return httpResponse(http.StatusBadRequest, "error", fmt.Sprintf("unable to unmarshal request: %s", err.Error())), nil
+	}
+	if request.method() != http.MethodPost {
+		return httpResponse(http.StatusMethodNotAllowed, "error", fmt.Sprintf("method %s is not allowed, expecting POST", request.method())), nil
+	}
+	if !h.cfg.HTTPAnonymous {
+		// Without API key, no request is authenticated until the anonymous requests are explicitly allowed.
+		token := strings.TrimPrefix(request.header("Authorization"), "Bearer ")
+		if h.cfg.HTTPAPIKey == "" || subtle.ConstantTimeCompare([]byte(token), []byte(h.cfg.HTTPAPIKey)) != 1 {
+			return httpResponse(http.StatusUnauthorized, "error", "missing or invalid API key"), nil
+		}
+	}
+
+	body := request.Body
+	if request.IsBase64Encoded {
+		decoded, err := base64.StdEncoding.DecodeString(body)
+		if err != nil {
+			return httpResponse(http.StatusBadRequest, "error", fmt.Sprintf("unable to decode request body: %s", err.Error())), nil
+		}
+		body = string(decoded)
+	}
+
+	_, outcome, err := h.sendRecord(ctx, events.SQSMessage{MessageId: request.RequestContext.RequestID, Body: body})
+	h.connectionPools.CloseIdle()
+	if err != nil {
+		return httpResponse(httpStatus(err), "error", err.Error()), nil
+	}
+	switch outcome.Status {
+	case results.StatusScheduled:
+		return httpResponse(http.StatusAccepted, "status", results.StatusScheduled), nil
+	case results.StatusSuppressed, results.StatusSkipped:
+		return httpResponse(http.StatusOK, "status", outcome.Status), nil
+	}
+
+	return httpResponse(http.StatusAccepted, "provider_id", outcome.ProviderID), nil
+}
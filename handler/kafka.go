@@ -0,0 +1,299 @@
+package handler
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/forsam-education/hermes/transport"
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Event sources of the Kafka events, delivered by an Amazon MSK or a self-managed Apache Kafka event source mapping, or fetched by the Kafka
+// consumer mode.
+const (
+	kafkaSourceMSK         = "aws:kafka"
+	kafkaSourceSelfManaged = "SelfManagedKafka"
+)
+
+// Settings of the Kafka consumer mode, fetching as many messages as an SQS batch at most.
+const (
+	kafkaBatchWait   = 500 * time.Millisecond
+	kafkaDialTimeout = 10 * time.Second
+)
+
+// kafkaRetryPause is the pause of the Kafka consumer mode before fetching again the messages not sent, so a failing dependency isn't hammered.
+var kafkaRetryPause = receiveErrorPause
+
+// SASL mechanisms of the Kafka consumer mode.
+const (
+	kafkaSASLPlain       = "plain"
+	kafkaSASLSCRAMSHA256 = "scram-sha-256"
+	kafkaSASLSCRAMSHA512 = "scram-sha-512"
+)
+
+// kafkaRecord is a record of a Kafka event. Its value holds the base64 encoded message, and its headers the bytes of their values.
+type kafkaRecord struct {
+	Topic     string             `json:"topic"`
+	Partition int64              `json:"partition"`
+	Offset    int64              `json:"offset"`
+	Timestamp int64              `json:"timestamp"`
+	Value     string             `json:"value"`
+	Headers   []map[string][]int `json:"headers"`
+}
+
+// kafkaEvent is the event of a Kafka event source mapping, whose records are grouped by topic partition, ie: "mail-0".
+type kafkaEvent struct {
+	EventSource    string                   `json:"eventSource"`
+	EventSourceARN string                   `json:"eventSourceArn"`
+	Records        map[string][]kafkaRecord `json:"records"`
+}
+
+// isKafkaEvent tells if the payload is an event of a Kafka event source mapping.
+func isKafkaEvent(payload []byte) bool {
+	var event struct {
+		EventSource string `json:"eventSource"`
+	}
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return false
+	}
+
+	return event.EventSource == kafkaSourceMSK || event.EventSource == kafkaSourceSelfManaged
+}
+
+// headerValue returns the string of a Kafka header value, given as its bytes.
+func headerValue(value []int) string {
+	bytes := make([]byte, len(value))
+	for i, b := range value {
+		bytes[i] = byte(b)
+	}
+
+	return string(bytes)
+}
+
+// kafkaRecords converts the Kafka records to records keyed by their topic, partition and offset, ie: "mail-0-42", so they are sent the same way
+// as the queued messages, and a record delivered again is recognized by the idempotency table. Each partition is the message group of its records,
+// so they are sent in their order, and their headers are kept as string attributes. It also returns the failures of the records whose value
+// can't be decoded, kept as is.
+func kafkaRecords(event kafkaEvent) ([]events.SQSMessage, map[string]error) {
+	partitions := make([]string, 0, len(event.Records))
+	for partition := range event.Records {
+		partitions = append(partitions, partition)
+	}
+	sort.Strings(partitions)
+
+	var records []events.SQSMessage
+	undecodable := make(map[string]error)
+	for _, partition := range partitions {
+		for _, record := range event.Records[partition] {
+			id := fmt.Sprintf("%s-%d-%d", record.Topic, record.Partition, record.Offset)
+			body, err := base64.StdEncoding.DecodeString(record.Value)
+			if err != nil {
+				undecodable[id] = fmt.Errorf("unable to decode value of Kafka record: %s", err.Error())
+				body = []byte(record.Value)
+			}
+			attributes := make(map[string]events.SQSMessageAttribute)
+			for _, header := range record.Headers {
+				for name, value := range header {
+					stringValue := headerValue(value)
+					attributes[name] = events.SQSMessageAttribute{DataType: "String", StringValue: &stringValue}
+				}
+			}
+			records = append(records, events.SQSMessage{
+				MessageId:      id,
+				Body:           string(body),
+				EventSource:    event.EventSource,
+				EventSourceARN: event.EventSourceARN,
+				Attributes: map[string]string{
+					"SentTimestamp":       strconv.FormatInt(record.Timestamp, 10),
+					messageGroupAttribute: fmt.Sprintf("%s-%d", record.Topic, record.Partition),
+				},
+				MessageAttributes: attributes,
+			})
+		}
+	}
+
+	return records, undecodable
+}
+
+// kafkaReader is the consumer group reader of the Kafka consumer mode, implemented by *kafka.Reader.
+type kafkaReader interface {
+	FetchMessage(ctx context.Context) (kafka.Message, error)
+	CommitMessages(ctx context.Context, messages ...kafka.Message) error
+	Close() error
+}
+
+// newKafkaDialer returns the dialer of the brokers, with the TLS and SASL settings of the configuration.
+func newKafkaDialer(cfg Config) (*kafka.Dialer, error) {
+	dialer := &kafka.Dialer{Timeout: kafkaDialTimeout, DualStack: true}
+	if cfg.KafkaTLS {
+		// The server name is the host of each broker.
+		tlsConfig, err := transport.NewTLSConfig("", transport.TLSSettings{MinVersion: "1.2", CABundle: cfg.KafkaCABundle})
+		if err != nil {
+			return nil, fmt.Errorf("unable to configure Kafka TLS: %s", err.Error())
+		}
+		dialer.TLS = tlsConfig
+	}
+
+	var err error
+	switch strings.ToLower(cfg.KafkaSASL) {
+	case "":
+	case kafkaSASLPlain:
+		dialer.SASLMechanism = plain.Mechanism{Username: cfg.KafkaUserName, Password: cfg.KafkaPassword}
+	case kafkaSASLSCRAMSHA256:
+		dialer.SASLMechanism, err = scram.Mechanism(scram.SHA256, cfg.KafkaUserName, cfg.KafkaPassword)
+	case kafkaSASLSCRAMSHA512:
+		dialer.SASLMechanism, err = scram.Mechanism(scram.SHA512, cfg.KafkaUserName, cfg.KafkaPassword)
+	default:
+		err = fmt.Errorf("mechanism %q is unknown", cfg.KafkaSASL)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to configure Kafka SASL: %s", err.Error())
+	}
+
+	return dialer, nil
+}
+
+// newKafkaReader joins the consumer group of the topic, whose offsets are committed by consumeKafka once the messages are sent.
+func newKafkaReader(cfg Config, dialer *kafka.Dialer) kafkaReader {
+	return kafka.NewReader(kafka.ReaderConfig{
+		Brokers:     cfg.KafkaBrokers,
+		GroupID:     cfg.KafkaGroup,
+		Topic:       cfg.KafkaTopic,
+		Dialer:      dialer,
+		ErrorLogger: kafka.LoggerFunc(log.Printf),
+	})
+}
+
+// fetchKafkaBatch waits for a message of the topic, then fetches the following ones for kafkaBatchWait at most, up to an SQS batch.
+func fetchKafkaBatch(ctx context.Context, reader kafkaReader) ([]kafka.Message, error) {
+	message, err := reader.FetchMessage(ctx)
+	if err != nil {
+		return nil, err
+	}
+	messages := []kafka.Message{message}
+
+	batchCtx, cancel := context.WithTimeout(ctx, kafkaBatchWait)
+	defer cancel()
+	for len(messages) < receiveBatchSize {
+		// A reader error is returned again by the next batch.
+		if message, err = reader.FetchMessage(batchCtx); err != nil {
+			break
+		}
+		messages = append(messages, message)
+	}
+
+	return messages, nil
+}
+
+// consumedEvent groups the fetched messages by topic partition as a self-managed Kafka event source mapping does, so they are converted to
+// records the same way.
+func consumedEvent(messages []kafka.Message) kafkaEvent {
+	event := kafkaEvent{EventSource: kafkaSourceSelfManaged, Records: make(map[string][]kafkaRecord)}
+	for _, message := range messages {
+		record := kafkaRecord{
+			Topic:     message.Topic,
+			Partition: int64(message.Partition),
+			Offset:    message.Offset,
+			Timestamp: message.Time.UnixNano() / int64(time.Millisecond),
+			Value:     base64.StdEncoding.EncodeToString(message.Value),
+		}
+		for _, header := range message.Headers {
+			value := make([]int, len(header.Value))
+			for i, b := range header.Value {
+				value[i] = int(b)
+			}
+			record.Headers = append(record.Headers, map[string][]int{header.Key: value})
+		}
+		partition := fmt.Sprintf("%s-%d", message.Topic, message.Partition)
+		event.Records[partition] = append(event.Records[partition], record)
+	}
+
+	return event
+}
+
+// committableMessages returns the messages whose offsets can be committed: those of each partition preceding its first failed message,
+// and tells if any failed.
+func committableMessages(messages []kafka.Message, response *sqsBatchResponse) ([]kafka.Message, bool) {
+	failed := make(map[string]bool)
+	if response != nil {
+		for _, failure := range response.BatchItemFailures {
+			failed[failure.ItemIdentifier] = true
+		}
+	}
+
+	var committable []kafka.Message
+	failedPartitions := make(map[string]bool)
+	for _, message := range messages {
+		partition := fmt.Sprintf("%s-%d", message.Topic, message.Partition)
+		if failedPartitions[partition] {
+			continue
+		}
+		if failed[fmt.Sprintf("%s-%d", partition, message.Offset)] {
+			failedPartitions[partition] = true
+			continue
+		}
+		committable = append(committable, message)
+	}
+
+	return committable, len(failedPartitions) > 0
+}
+
+// consumeKafka fetches the messages of the topic and sends them through the same pipeline as the lambda, until the context is done, committing
+// the offsets of the sent messages. A consumer group reader can't seek back, so after a failed message it is closed and opened again, the failed
+// message and the ones following it in its partition being fetched again from the committed offset.
+func consumeKafka(ctx context.Context, h *Handler, open func() kafkaReader) error {
+	reader := open()
+	defer func() {
+		if err := reader.Close(); err != nil {
+			log.Printf("Unable to leave the Kafka consumer group: %s", err.Error())
+		}
+	}()
+
+	for ctx.Err() == nil {
+		h.connectionPools.CloseExpired()
+		messages, err := fetchKafkaBatch(ctx, reader)
+		if ctx.Err() != nil {
+			break
+		}
+		if err != nil {
+			log.Printf("Unable to fetch Kafka messages: %s", err.Error())
+			pause(ctx, receiveErrorPause)
+			continue
+		}
+
+		records, undecodable := kafkaRecords(consumedEvent(messages))
+		batchCtx, span := h.mailer.Options.Tracer.StartSpan(context.Background(), "HandleMessages")
+		response, err := h.sendRecords(batchCtx, records, true, undecodable)
+		span.End(err)
+		if flushErr := h.mailer.Options.Tracer.Flush(); flushErr != nil {
+			log.Printf("Unable to export traces: %s", flushErr.Error())
+		}
+		batchResponse, _ := response.(*sqsBatchResponse)
+		committable, failed := committableMessages(messages, batchResponse)
+		if len(committable) > 0 {
+			// The offsets are committed even if the process is stopping, as their messages are sent.
+			if err := reader.CommitMessages(context.Background(), committable...); err != nil {
+				log.Printf("Unable to commit the offsets of %d sent Kafka messages: %s", len(committable), err.Error())
+			}
+		}
+		if failed {
+			log.Printf("Fetching again the %d Kafka messages not sent", len(messages)-len(committable))
+			if err := reader.Close(); err != nil {
+				log.Printf("Unable to leave the Kafka consumer group: %s", err.Error())
+			}
+			pause(ctx, kafkaRetryPause)
+			reader = open()
+		}
+	}
+
+	return nil
+}
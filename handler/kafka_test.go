@@ -0,0 +1,329 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"github.com/forsam-education/hermes/storage"
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"gopkg.in/gomail.v2"
+	"io"
+	"net/textproto"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConsumedEventRecords(t *testing.T) {
+	sentAt := time.Date(2020, 10, 26, 12, 0, 0, 0, time.UTC)
+	messages := []kafka.Message{
+		{Topic: "mail", Partition: 1, Offset: 7, Time: sentAt, Value: []byte(`{"subject": "Welcome"}`), Headers: []kafka.Header{{Key: "tenant", Value: []byte("acme")}}},
+		{Topic: "mail", Partition: 0, Offset: 41, Time: sentAt, Value: []byte(`{"subject": "First"}`)},
+		{Topic: "mail", Partition: 0, Offset: 42, Time: sentAt, Value: []byte(`{"subject": "Second"}`)},
+	}
+
+	records, undecodable := kafkaRecords(consumedEvent(messages))
+	if len(undecodable) != 0 {
+		t.Errorf("expected every record to be decoded, got %v", undecodable)
+	}
+	var ids []string
+	for _, record := range records {
+		ids = append(ids, record.MessageId)
+	}
+	if expected := []string{"mail-0-41", "mail-0-42", "mail-1-7"}; !reflect.DeepEqual(ids, expected) {
+		t.Fatalf("expected records %v, got %v", expected, ids)
+	}
+
+	record := records[2]
+	if record.Body != `{"subject": "Welcome"}` {
+		t.Errorf("expected the value as body, got %q", record.Body)
+	}
+	if record.Attributes[messageGroupAttribute] != "mail-1" {
+		t.Errorf("expected the partition as message group, got %q", record.Attributes[messageGroupAttribute])
+	}
+	if record.Attributes["SentTimestamp"] != "1603713600000" {
+		t.Errorf("expected the message time as sent timestamp, got %q", record.Attributes["SentTimestamp"])
+	}
+	if tenant := record.MessageAttributes["tenant"].StringValue; tenant == nil || *tenant != "acme" {
+		t.Errorf("expected the header as message attribute, got %v", record.MessageAttributes)
+	}
+	if record.EventSource != kafkaSourceSelfManaged {
+		t.Errorf("expected the %s event source, got %q", kafkaSourceSelfManaged, record.EventSource)
+	}
+}
+
+func TestCommittableMessages(t *testing.T) {
+	messages := []kafka.Message{
+		{Topic: "mail", Partition: 0, Offset: 1},
+		{Topic: "mail", Partition: 1, Offset: 5},
+		{Topic: "mail", Partition: 0, Offset: 2},
+		{Topic: "mail", Partition: 0, Offset: 3},
+		{Topic: "mail", Partition: 1, Offset: 6},
+	}
+
+	tests := []struct {
+		name        string
+		failures    []string
+		committable []int64
+		failed      bool
+	}{
+		{name: "commits every sent message", committable: []int64{1, 5, 2, 3, 6}},
+		{name: "stops each partition at its first failure", failures: []string{"mail-0-2", "mail-0-3"}, committable: []int64{1, 5, 6}, failed: true},
+		{name: "commits nothing of a partition failing first", failures: []string{"mail-1-5", "mail-1-6"}, committable: []int64{1, 2, 3}, failed: true},
+		{name: "commits nothing when everything failed", failures: []string{"mail-0-1", "mail-0-2", "mail-0-3", "mail-1-5", "mail-1-6"}, failed: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			response := &sqsBatchResponse{BatchItemFailures: []sqsBatchItemFailure{}}
+			for _, failure := range test.failures {
+				response.BatchItemFailures = append(response.BatchItemFailures, sqsBatchItemFailure{ItemIdentifier: failure})
+			}
+
+			committable, failed := committableMessages(messages, response)
+			var offsets []int64
+			for _, message := range committable {
+				offsets = append(offsets, message.Offset)
+			}
+			if !reflect.DeepEqual(offsets, test.committable) || failed != test.failed {
+				t.Errorf("expected offsets %v and failed %t, got %v and %t", test.committable, test.failed, offsets, failed)
+			}
+		})
+	}
+}
+
+func TestNewKafkaDialer(t *testing.T) {
+	tests := []struct {
+		name      string
+		tls       bool
+		sasl      string
+		mechanism string
+		err       string
+	}{
+		{name: "connects without TLS nor SASL"},
+		{name: "connects with TLS", tls: true},
+		{name: "authenticates with PLAIN", tls: true, sasl: "plain", mechanism: "PLAIN"},
+		{name: "authenticates with SCRAM-SHA-256", sasl: "scram-sha-256", mechanism: "SCRAM-SHA-256"},
+		{name: "authenticates with SCRAM-SHA-512", sasl: "SCRAM-SHA-512", mechanism: "SCRAM-SHA-512"},
+		{name: "rejects an unknown mechanism", sasl: "gssapi", err: `unable to configure Kafka SASL: mechanism "gssapi" is unknown`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := Config{KafkaTLS: test.tls, KafkaSASL: test.sasl, KafkaUserName: "hermes", KafkaPassword: "secret"}
+			dialer, err := newKafkaDialer(cfg)
+			if test.err != "" {
+				if err == nil || err.Error() != test.err {
+					t.Fatalf("expected error %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+			if (dialer.TLS != nil) != test.tls {
+				t.Errorf("expected TLS %t, got %v", test.tls, dialer.TLS)
+			}
+			if test.tls && (dialer.TLS.MinVersion == 0 || dialer.TLS.ServerName != "") {
+				t.Errorf("expected a minimum version and the server name of each broker, got %+v", dialer.TLS)
+			}
+			var mechanism string
+			if dialer.SASLMechanism != nil {
+				mechanism = dialer.SASLMechanism.Name()
+			}
+			if mechanism != test.mechanism {
+				t.Errorf("expected mechanism %q, got %q", test.mechanism, mechanism)
+			}
+			if plainMechanism, ok := dialer.SASLMechanism.(plain.Mechanism); ok && (plainMechanism.Username != "hermes" || plainMechanism.Password != "secret") {
+				t.Errorf("expected the credentials of the configuration, got %+v", plainMechanism)
+			}
+		})
+	}
+}
+
+// fakeTopic holds the messages of a topic and the offsets committed by its consumer group. Each reader it opens fetches the messages from
+// the committed offsets, as a reader joining the group does.
+type fakeTopic struct {
+	mu        sync.Mutex
+	messages  []kafka.Message
+	committed map[int]int64
+	opens     int
+	// drained is called once every message is committed.
+	drained func()
+}
+
+func (topic *fakeTopic) open() kafkaReader {
+	topic.mu.Lock()
+	defer topic.mu.Unlock()
+	topic.opens++
+
+	reader := &fakeKafkaReader{topic: topic}
+	for _, message := range topic.messages {
+		if message.Offset >= topic.committed[message.Partition] {
+			reader.pending = append(reader.pending, message)
+		}
+	}
+
+	return reader
+}
+
+func (topic *fakeTopic) Committed() map[int]int64 {
+	topic.mu.Lock()
+	defer topic.mu.Unlock()
+
+	committed := make(map[int]int64, len(topic.committed))
+	for partition, offset := range topic.committed {
+		committed[partition] = offset
+	}
+
+	return committed
+}
+
+type fakeKafkaReader struct {
+	topic   *fakeTopic
+	pending []kafka.Message
+}
+
+func (reader *fakeKafkaReader) FetchMessage(ctx context.Context) (kafka.Message, error) {
+	reader.topic.mu.Lock()
+	if len(reader.pending) > 0 {
+		message := reader.pending[0]
+		reader.pending = reader.pending[1:]
+		reader.topic.mu.Unlock()
+		return message, nil
+	}
+	drained := true
+	for _, message := range reader.topic.messages {
+		drained = drained && message.Offset < reader.topic.committed[message.Partition]
+	}
+	reader.topic.mu.Unlock()
+	if drained {
+		reader.topic.drained()
+	}
+	<-ctx.Done()
+
+	return kafka.Message{}, ctx.Err()
+}
+
+func (reader *fakeKafkaReader) CommitMessages(ctx context.Context, messages ...kafka.Message) error {
+	reader.topic.mu.Lock()
+	defer reader.topic.mu.Unlock()
+	for _, message := range messages {
+		if message.Offset+1 > reader.topic.committed[message.Partition] {
+			reader.topic.committed[message.Partition] = message.Offset + 1
+		}
+	}
+
+	return nil
+}
+
+func (reader *fakeKafkaReader) Close() error {
+	return nil
+}
+
+// flakyDialer records the recipients of the sent messages, failing the sends to its flaky recipient with a transient error the given times.
+type flakyDialer struct {
+	mu         sync.Mutex
+	flaky      string
+	failures   int
+	recipients []string
+}
+
+func (dialer *flakyDialer) Dial() (gomail.SendCloser, error) {
+	return dialer, nil
+}
+
+func (dialer *flakyDialer) Send(from string, to []string, msg io.WriterTo) error {
+	dialer.mu.Lock()
+	defer dialer.mu.Unlock()
+	if to[0] == dialer.flaky && dialer.failures > 0 {
+		dialer.failures--
+		return &textproto.Error{Code: 451, Msg: "4.3.0 try again later"}
+	}
+	dialer.recipients = append(dialer.recipients, to[0])
+
+	return nil
+}
+
+func (dialer *flakyDialer) Close() error {
+	return nil
+}
+
+func (dialer *flakyDialer) Sends(recipient string) int {
+	dialer.mu.Lock()
+	defer dialer.mu.Unlock()
+
+	var sends int
+	for _, sent := range dialer.recipients {
+		if sent == recipient {
+			sends++
+		}
+	}
+
+	return sends
+}
+
+func TestConsumeKafka(t *testing.T) {
+	defer func(retryPause time.Duration) { kafkaRetryPause = retryPause }(kafkaRetryPause)
+	kafkaRetryPause = 10 * time.Millisecond
+
+	templates := storage.NewMemory()
+	templates.Set("welcome.html.template", []byte("<p>Hello {{.name}}</p>"))
+	templates.Set("welcome.txt.template", []byte("Hello {{.name}}"))
+	message := func(partition int, offset int64, recipient string) kafka.Message {
+		body := fmt.Sprintf(`{"from_address": "noreply@example.com", "reply_to": "support@example.com", "to": [%q], "subject": "Welcome", "template_name": "welcome", "template_context": {"name": "Jane"}}`, recipient)
+		return kafka.Message{Topic: "mail", Partition: partition, Offset: offset, Time: time.Now(), Value: []byte(body)}
+	}
+
+	tests := []struct {
+		name     string
+		failures int
+		opens    int
+	}{
+		{name: "commits the sent messages", opens: 1},
+		// The first batch tries the flaky message recordRetries times.
+		{name: "fetches the failed messages again", failures: recordRetries, opens: 2},
+		{name: "fetches the failed messages again until they are sent", failures: 2 * recordRetries, opens: 3},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dialer := &flakyDialer{flaky: "flaky@example.org", failures: test.failures}
+			cfg := validConfig(t)
+			cfg.BatchFailures = true
+			h, err := New(cfg, Services{Templates: templates, Transport: dialer})
+			if err != nil {
+				t.Fatalf("unable to instantiate handler: %s", err.Error())
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+			defer cancel()
+			topic := &fakeTopic{
+				messages: []kafka.Message{
+					message(0, 0, "first@example.org"),
+					message(0, 1, "flaky@example.org"),
+					message(0, 2, "following@example.org"),
+					message(1, 0, "other@example.org"),
+				},
+				committed: make(map[int]int64),
+				drained:   cancel,
+			}
+			if err := consumeKafka(ctx, h, topic.open); err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+
+			if committed := topic.Committed(); !reflect.DeepEqual(committed, map[int]int64{0: 3, 1: 1}) {
+				t.Errorf("expected every message to be committed, got offsets %v", committed)
+			}
+			for _, recipient := range []string{"first@example.org", "flaky@example.org", "following@example.org", "other@example.org"} {
+				if sends := dialer.Sends(recipient); sends != 1 {
+					t.Errorf("expected a single message sent to %s, got %d", recipient, sends)
+				}
+			}
+			if topic.opens != test.opens {
+				t.Errorf("expected the reader to be opened %d times, got %d", test.opens, topic.opens)
+			}
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package handler
+
+import (
+	"fmt"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/forsam-education/hermes/metrics"
+	"log"
+	"strconv"
+	"time"
+)
+
+// queueLatency returns how long the record waited in the queue, from its SentTimestamp attribute in epoch milliseconds.
+func queueLatency(record events.SQSMessage, now time.Time) (time.Duration, error) {
+	sentTimestamp, ok := record.Attributes["SentTimestamp"]
+	if !ok {
+		return 0, fmt.Errorf("record has no SentTimestamp attribute")
+	}
+	sentMillis, err := strconv.ParseInt(sentTimestamp, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid SentTimestamp %q: %s", sentTimestamp, err.Error())
+	}
+
+	latency := now.Sub(time.Unix(0, sentMillis*int64(time.Millisecond)))
+	if latency < 0 {
+		latency = 0
+	}
+
+	return latency, nil
+}
+
+// putQueueLatencies emits the queue_latency metric of each record. Records without a valid timestamp are only logged.
+func putQueueLatencies(metricsRecorder metrics.Recorder, records []events.SQSMessage) {
+	if metricsRecorder == nil {
+		return
+	}
+
+	now := time.Now()
+	for _, record := range records {
+		latency, err := queueLatency(record, now)
+		if err != nil {
+			log.Printf("Unable to measure queue latency of message %s: %s", record.MessageId, err.Error())
+			continue
+		}
+		if err := metricsRecorder.Put("queue_latency", float64(latency/time.Millisecond), metrics.UnitMilliseconds, nil); err != nil {
+			log.Printf("Unable to emit queue latency of message %s: %s", record.MessageId, err.Error())
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/forsam-education/hermes/metrics"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestQueueLatency(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	sent := strconv.FormatInt(now.Add(-1500*time.Millisecond).UnixNano()/int64(time.Millisecond), 10)
+	future := strconv.FormatInt(now.Add(time.Minute).UnixNano()/int64(time.Millisecond), 10)
+
+	tests := []struct {
+		name       string
+		attributes map[string]string
+		expected   time.Duration
+		err        string
+	}{
+		{name: "computes the latency from the sent timestamp", attributes: map[string]string{"SentTimestamp": sent}, expected: 1500 * time.Millisecond},
+		{name: "clamps a timestamp in the future", attributes: map[string]string{"SentTimestamp": future}, expected: 0},
+		{name: "fails without timestamp", attributes: nil, err: "record has no SentTimestamp attribute"},
+		{name: "fails on an invalid timestamp", attributes: map[string]string{"SentTimestamp": "yesterday"}, err: `invalid SentTimestamp "yesterday"`},
+		{name: "fails on an empty timestamp", attributes: map[string]string{"SentTimestamp": ""}, err: `invalid SentTimestamp ""`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			latency, err := queueLatency(events.SQSMessage{Attributes: test.attributes}, now)
+			if test.err != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), test.err) {
+					t.Fatalf("expected error %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+			if latency != test.expected {
+				t.Errorf("expected %s, got %s", test.expected, latency)
+			}
+		})
+	}
+}
+
+func TestPutQueueLatencies(t *testing.T) {
+	sent := strconv.FormatInt(time.Now().Add(-2*time.Second).UnixNano()/int64(time.Millisecond), 10)
+	records := []events.SQSMessage{
+		{MessageId: "late", Attributes: map[string]string{"SentTimestamp": sent}},
+		{MessageId: "missing"},
+		{MessageId: "invalid", Attributes: map[string]string{"SentTimestamp": "-"}},
+	}
+
+	var output bytes.Buffer
+	putQueueLatencies(metrics.NewEMF("Hermes", &output), records)
+
+	lines := strings.Split(strings.TrimSuffix(output.String(), "\n"), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("expected a single metric for the valid record, got %q", output.String())
+	}
+	var entry struct {
+		QueueLatency float64 `json:"queue_latency"`
+		AWS          struct {
+			CloudWatchMetrics []struct {
+				Namespace string
+				Metrics   []struct{ Name, Unit string }
+			}
+		} `json:"_aws"`
+	}
+	if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil {
+		t.Fatalf("expected an EMF line, got %q: %s", lines[0], err.Error())
+	}
+	if entry.QueueLatency < 2000 || entry.QueueLatency > 60000 {
+		t.Errorf("expected a latency of about 2000 milliseconds, got %v", entry.QueueLatency)
+	}
+	if directives := entry.AWS.CloudWatchMetrics; len(directives) != 1 || directives[0].Namespace != "Hermes" || len(directives[0].Metrics) != 1 || directives[0].Metrics[0].Name != "queue_latency" || directives[0].Metrics[0].Unit != metrics.UnitMilliseconds {
+		t.Errorf("expected a queue_latency directive in milliseconds, got %+v", directives)
+	}
+
+	// A handler without metrics skips the measure.
+	putQueueLatencies(nil, records)
+}
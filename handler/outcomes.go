@@ -0,0 +1,128 @@
+package handler
+
+import (
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/forsam-education/hermes/mailmessage"
+	"github.com/forsam-education/hermes/metrics"
+	"github.com/forsam-education/hermes/results"
+	"log"
+	"sync"
+	"time"
+)
+
+// outcomeRecorder keeps the last outcome of each message, as the redriver processes them concurrently and retries them.
+type outcomeRecorder struct {
+	mu       sync.Mutex
+	outcomes map[string]results.Outcome
+}
+
+func newOutcomeRecorder() *outcomeRecorder {
+	return &outcomeRecorder{outcomes: make(map[string]results.Outcome)}
+}
+
+func (recorder *outcomeRecorder) record(messageID string, providerID string, err error) {
+	recorder.put(results.Outcome{MessageID: messageID, ProviderID: providerID}, err)
+}
+
+// put records the outcome of a message, being sent unless it has another status, or failed with err.
+func (recorder *outcomeRecorder) put(outcome results.Outcome, err error) {
+	if outcome.Status == "" {
+		outcome.Status = results.StatusSent
+	}
+	if err != nil {
+		outcome.Status = results.StatusFailed
+		outcome.Error = err.Error()
+	}
+
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	recorder.outcomes[outcome.MessageID] = outcome
+}
+
+// list returns the outcomes in the order of the batch records, with the template and main recipient of their message.
+func (recorder *outcomeRecorder) list(records []events.SQSMessage) []results.Outcome {
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+
+	outcomes := make([]results.Outcome, 0, len(records))
+	for _, record := range records {
+		if outcome, ok := recorder.outcomes[record.MessageId]; ok {
+			outcome.Template, outcome.Recipient = mailmessage.DescribeRecipient(record.Body)
+			outcomes = append(outcomes, outcome)
+		}
+	}
+
+	return outcomes
+}
+
+// writeOutcomes sends the outcomes to the results writers, if any. Failures only are logged, as the messages are already processed.
+func writeOutcomes(writer results.Writer, outcomes []results.Outcome) {
+	if writer == nil || len(outcomes) == 0 {
+		return
+	}
+
+	if err := writer.Write(outcomes); err != nil {
+		log.Printf("Unable to write %d outcomes: %s", len(outcomes), err.Error())
+	}
+}
+
+// putSendMetrics emits the send_latency metric of a processed message, and counts it as sent, suppressed or failed. Failures are also counted by cause,
+// as render_errors or dial_errors, and as send_failures with the failed phase as dimension.
+func putSendMetrics(metricsRecorder metrics.Recorder, duration time.Duration, err error) {
+	if metricsRecorder == nil {
+		return
+	}
+
+	counters := []string{"messages_sent"}
+	if mailmessage.IsSuppressed(err) {
+		counters = []string{"messages_suppressed"}
+	} else if err != nil {
+		counters = []string{"messages_failed"}
+		switch mailmessage.ErrorPhase(err) {
+		case mailmessage.PhaseRender:
+			counters = append(counters, "render_errors")
+		case mailmessage.PhaseDial:
+			counters = append(counters, "dial_errors")
+		}
+	}
+
+	if putErr := metricsRecorder.Put("send_latency", float64(duration/time.Millisecond), metrics.UnitMilliseconds, nil); putErr != nil {
+		log.Printf("Unable to emit send latency: %s", putErr.Error())
+	}
+	for _, counter := range counters {
+		if putErr := metricsRecorder.Put(counter, 1, metrics.UnitCount, nil); putErr != nil {
+			log.Printf("Unable to emit %s metric: %s", counter, putErr.Error())
+		}
+	}
+	if err == nil || mailmessage.IsSuppressed(err) {
+		return
+	}
+
+	phase := mailmessage.ErrorPhase(err)
+	if phase == "" {
+		phase = "other"
+	}
+	if putErr := metricsRecorder.Put("send_failures", 1, metrics.UnitCount, map[string]string{"phase": phase}); putErr != nil {
+		log.Printf("Unable to emit send_failures metric: %s", putErr.Error())
+	}
+}
+
+// putVariantMetric counts a processed message of a template experiment as variant_messages, with its template, variant and result as dimensions,
+// so the variants can be compared.
+func putVariantMetric(metricsRecorder metrics.Recorder, templateName string, variant string, err error) {
+	if metricsRecorder == nil {
+		return
+	}
+
+	result := results.StatusSent
+	if mailmessage.IsSuppressed(err) {
+		result = results.StatusSuppressed
+	} else if err != nil {
+		result = results.StatusFailed
+	}
+
+	dimensions := map[string]string{"template": templateName, "variant": variant, "result": result}
+	if putErr := metricsRecorder.Put("variant_messages", 1, metrics.UnitCount, dimensions); putErr != nil {
+		log.Printf("Unable to emit variant_messages metric: %s", putErr.Error())
+	}
+}
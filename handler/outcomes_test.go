@@ -0,0 +1,39 @@
+package handler
+
+import (
+	"bytes"
+	"errors"
+	"github.com/forsam-education/hermes/results"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+// failingWriter fails to write any outcome.
+type failingWriter struct {
+	calls int
+}
+
+func (writer *failingWriter) Write(outcomes []results.Outcome) error {
+	writer.calls++
+
+	return errors.New(`unable to invoke function "results": rate exceeded`)
+}
+
+func TestWriteOutcomesLogsFailures(t *testing.T) {
+	var output bytes.Buffer
+	log.SetOutput(&output)
+	defer log.SetOutput(os.Stderr)
+
+	writer := &failingWriter{}
+	writeOutcomes(writer, nil)
+	if writer.calls != 0 {
+		t.Errorf("expected no write without outcomes, got %d", writer.calls)
+	}
+	writeOutcomes(writer, []results.Outcome{{MessageID: "42", Status: results.StatusSent}})
+	if writer.calls != 1 || !strings.Contains(output.String(), "Unable to write 1 outcomes: unable to invoke function \"results\": rate exceeded") {
+		t.Errorf("expected the failure to be logged, got %q", output.String())
+	}
+	writeOutcomes(nil, []results.Outcome{{MessageID: "42", Status: results.StatusSent}})
+}
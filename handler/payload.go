@@ -0,0 +1,76 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-lambda-go/events"
+	"strings"
+)
+
+// payloadPointerClasses are the class names of the pointers written by the Amazon SQS Extended Client Library in place of the messages above
+// the SQS size limit, the first one being used by its current versions.
+var payloadPointerClasses = map[string]bool{
+	"software.amazon.payloadoffloading.PayloadS3Pointer": true,
+	"com.amazon.sqs.javamessaging.MessageS3Pointer":      true,
+}
+
+// payloadPointer locates the S3 object holding the JSON message of a record.
+type payloadPointer struct {
+	Bucket string `json:"s3BucketName"`
+	Key    string `json:"s3Key"`
+}
+
+// parsePayloadPointer returns the pointer held by a record body, ie: ["software.amazon.payloadoffloading.PayloadS3Pointer", {"s3BucketName": "...", "s3Key": "..."}],
+// or nil when the body is the message itself.
+func parsePayloadPointer(body string) *payloadPointer {
+	if !strings.HasPrefix(strings.TrimSpace(body), "[") {
+		return nil
+	}
+
+	var parts []json.RawMessage
+	if err := json.Unmarshal([]byte(body), &parts); err != nil || len(parts) != 2 {
+		return nil
+	}
+	var class string
+	if err := json.Unmarshal(parts[0], &class); err != nil || !payloadPointerClasses[class] {
+		return nil
+	}
+	var pointer payloadPointer
+	if err := json.Unmarshal(parts[1], &pointer); err != nil || pointer.Bucket == "" || pointer.Key == "" {
+		return nil
+	}
+
+	return &pointer
+}
+
+// isPayloadBucket tells if the bucket is one of those the payloads may be read from.
+func isPayloadBucket(bucket string, allowed []string) bool {
+	for _, allowedBucket := range allowed {
+		if bucket == allowedBucket {
+			return true
+		}
+	}
+
+	return false
+}
+
+// resolvePayload returns the record with its body read from S3 when it is a payload pointer. Pointers to buckets not listed in PAYLOAD_BUCKETS fail,
+// so producers can't make hermes read any bucket it has access to.
+func (h *Handler) resolvePayload(event events.SQSMessage) (events.SQSMessage, error) {
+	pointer := parsePayloadPointer(event.Body)
+	if pointer == nil {
+		return event, nil
+	}
+	if h.payloadStorage == nil || !isPayloadBucket(pointer.Bucket, h.cfg.PayloadBuckets) {
+		return event, fmt.Errorf("payload pointer to bucket %q is refused, it is not listed in PAYLOAD_BUCKETS", pointer.Bucket)
+	}
+
+	var body bytes.Buffer
+	if err := h.payloadStorage.InBucket(pointer.Bucket).Copy(pointer.Key, &body); err != nil {
+		return event, fmt.Errorf("unable to read payload %q: %s", pointer.Key, err.Error())
+	}
+	event.Body = body.String()
+
+	return event, nil
+}
@@ -0,0 +1,94 @@
+package handler
+
+import (
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/forsam-education/hermes/mailmessage"
+	"sort"
+	"sync"
+)
+
+// recordPriority reads the optional priority of a queued message, messages without a valid one having the default 0 priority.
+// The high, normal and low importance levels are ordered as 1, 0 and -1.
+func recordPriority(body string) int {
+	return mailmessage.PriorityOrder(body)
+}
+
+// priorityGate makes the records of a priority wait until every record of a higher priority is processed, as the redriver processes all of them concurrently.
+// A record is processed once it is sent, or once its last retry failed.
+type priorityGate struct {
+	mu         sync.Mutex
+	retries    int
+	levels     []int
+	current    int
+	remaining  map[int]int
+	opened     map[int]chan struct{}
+	priorities map[string]int
+	attempts   map[string]int
+}
+
+// newPriorityGate sorts the records by decreasing priority, keeping the order of records of the same priority, and returns the gate processing them in this order.
+// The records of a message group must keep their order, so they are not prioritized and never wait.
+func newPriorityGate(records []events.SQSMessage, retries int) *priorityGate {
+	gate := &priorityGate{
+		retries:    retries,
+		remaining:  make(map[int]int),
+		opened:     make(map[int]chan struct{}),
+		priorities: make(map[string]int, len(records)),
+		attempts:   make(map[string]int, len(records)),
+	}
+	for _, record := range records {
+		if record.Attributes[messageGroupAttribute] != "" {
+			continue
+		}
+		priority := recordPriority(record.Body)
+		gate.priorities[record.MessageId] = priority
+		if _, ok := gate.opened[priority]; !ok {
+			gate.opened[priority] = make(chan struct{})
+			gate.levels = append(gate.levels, priority)
+		}
+		gate.remaining[priority]++
+	}
+
+	sort.SliceStable(records, func(i, j int) bool {
+		return gate.priorities[records[i].MessageId] > gate.priorities[records[j].MessageId]
+	})
+	sort.Sort(sort.Reverse(sort.IntSlice(gate.levels)))
+	if len(gate.levels) > 0 {
+		close(gate.opened[gate.levels[0]])
+	}
+
+	return gate
+}
+
+// wait blocks until every record of a higher priority is processed.
+func (gate *priorityGate) wait(messageID string) {
+	gate.mu.Lock()
+	priority, ok := gate.priorities[messageID]
+	opened := gate.opened[priority]
+	gate.mu.Unlock()
+
+	if ok {
+		<-opened
+	}
+}
+
+// done records an attempt to process the record, letting the next priority through once every record of the current one is processed.
+func (gate *priorityGate) done(messageID string, err error) {
+	gate.mu.Lock()
+	defer gate.mu.Unlock()
+
+	priority, ok := gate.priorities[messageID]
+	if !ok {
+		return
+	}
+	gate.attempts[messageID]++
+	if err != nil && gate.attempts[messageID] < gate.retries {
+		return
+	}
+
+	gate.remaining[priority]--
+	for gate.remaining[gate.levels[gate.current]] == 0 && gate.current+1 < len(gate.levels) {
+		gate.current++
+		close(gate.opened[gate.levels[gate.current]])
+	}
+}
@@ -0,0 +1,138 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"github.com/aws/aws-lambda-go/events"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestRecordPriority(t *testing.T) {
+	tests := []struct {
+		body     string
+		expected int
+	}{
+		{body: `{"priority": 5}`, expected: 5},
+		{body: `{"priority": -2}`, expected: -2},
+		{body: `{"priority": "high"}`, expected: 1},
+		{body: `{"priority": "NORMAL"}`, expected: 0},
+		{body: `{"priority": "low"}`, expected: -1},
+		{body: `{"priority": "urgent"}`, expected: 0},
+		{body: `{"priority": 1.5}`, expected: 0},
+		{body: `{"template_name": "welcome"}`, expected: 0},
+		{body: `not json`, expected: 0},
+	}
+
+	for _, test := range tests {
+		if priority := recordPriority(test.body); priority != test.expected {
+			t.Errorf("expected %s to have priority %d, got %d", test.body, test.expected, priority)
+		}
+	}
+}
+
+// priorityRecords returns a record per priority, named after its index and priority, ie: "2:high".
+func priorityRecords(priorities ...string) []events.SQSMessage {
+	records := make([]events.SQSMessage, 0, len(priorities))
+	for i, priority := range priorities {
+		body := `{"template_name": "welcome"}`
+		if priority != "" {
+			body = `{"template_name": "welcome", "priority": "` + priority + `"}`
+		}
+		records = append(records, events.SQSMessage{MessageId: fmt.Sprintf("%d:%s", i, priority), Body: body})
+	}
+
+	return records
+}
+
+func messageIDs(records []events.SQSMessage) []string {
+	ids := make([]string, 0, len(records))
+	for _, record := range records {
+		ids = append(ids, record.MessageId)
+	}
+
+	return ids
+}
+
+func TestNewPriorityGateSortsRecords(t *testing.T) {
+	records := priorityRecords("low", "", "high", "normal", "high", "low")
+	records = append(records, events.SQSMessage{MessageId: "6:grouped", Body: `{"priority": "high"}`, Attributes: map[string]string{messageGroupAttribute: "orders"}})
+	newPriorityGate(records, recordRetries)
+
+	// The grouped record keeps its order, as if it had the default priority.
+	expected := []string{"2:high", "4:high", "1:", "3:normal", "6:grouped", "0:low", "5:low"}
+	if ids := messageIDs(records); !reflect.DeepEqual(ids, expected) {
+		t.Errorf("expected the records to be sorted by decreasing priority as %v, got %v", expected, ids)
+	}
+}
+
+func TestPriorityGateProcessingOrder(t *testing.T) {
+	tests := []struct {
+		name     string
+		records  []events.SQSMessage
+		failures map[string]int
+		order    []string
+	}{
+		{
+			name:    "processes the higher priorities first",
+			records: priorityRecords("low", "normal", "high"),
+			order:   []string{"2:high", "1:normal", "0:low"},
+		},
+		{
+			name:     "waits for the retries of a higher priority",
+			records:  priorityRecords("low", "high"),
+			failures: map[string]int{"1:high": 2},
+			order:    []string{"1:high", "1:high", "1:high", "0:low"},
+		},
+		{
+			name:     "goes on once a higher priority failed its last retry",
+			records:  priorityRecords("normal", "high"),
+			failures: map[string]int{"1:high": recordRetries},
+			order:    []string{"1:high", "1:high", "1:high", "0:normal"},
+		},
+		{
+			name:    "does not make the grouped records wait",
+			records: append(priorityRecords("high"), events.SQSMessage{MessageId: "1:grouped", Body: `{"priority": "low"}`, Attributes: map[string]string{messageGroupAttribute: "orders"}}),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gate := newPriorityGate(test.records, recordRetries)
+			// The records are processed concurrently, a record which isn't gated being held until every other one is processed.
+			var mu sync.Mutex
+			var order []string
+			attempts := make(map[string]int)
+			groupedDone := make(chan struct{})
+			processRecords(test.records, recordRetries, func(record events.SQSMessage) error {
+				gate.wait(record.MessageId)
+				mu.Lock()
+				order = append(order, record.MessageId)
+				attempts[record.MessageId]++
+				var err error
+				if attempts[record.MessageId] <= test.failures[record.MessageId] {
+					err = errors.New("451 try again later")
+				}
+				mu.Unlock()
+				if record.MessageId == "1:grouped" {
+					close(groupedDone)
+				} else if test.order == nil {
+					<-groupedDone
+				}
+				gate.done(record.MessageId, err)
+				return err
+			})
+
+			if test.order == nil {
+				if len(order) != 2 || order[0] != "1:grouped" {
+					t.Errorf("expected the grouped record to be processed without waiting, got %v", order)
+				}
+				return
+			}
+			if !reflect.DeepEqual(order, test.order) {
+				t.Errorf("expected the processing order %v, got %v", test.order, order)
+			}
+		})
+	}
+}
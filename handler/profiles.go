@@ -0,0 +1,84 @@
+package handler
+
+import (
+	"encoding/json"
+	"github.com/forsam-education/hermes/transport"
+)
+
+// transportProfile is a named mail transport a message may be sent through instead of the default one. Its fields are named after the
+// environment variables they stand for, ie: {"MAIL_TRANSPORT": "smtp", "SMTP_HOST": "relay.example.com"}.
+type transportProfile struct {
+	MailTransport  string          `json:"MAIL_TRANSPORT"`
+	SMTPHost       string          `json:"SMTP_HOST"`
+	SMTPPort       *transport.Port `json:"SMTP_PORT"`
+	SMTPTLS        string          `json:"SMTP_TLS"`
+	SMTPTLSMin     string          `json:"SMTP_TLS_MIN_VERSION"`
+	SMTPCABundle   string          `json:"SMTP_CA_BUNDLE"`
+	SMTPInsecure   bool            `json:"SMTP_TLS_INSECURE_SKIP_VERIFY"`
+	SMTPUserName   string          `json:"SMTP_USER"`
+	SMTPPassword   string          `json:"SMTP_PASS"`
+	SMTPSecret     string          `json:"SMTP_CREDENTIALS_SECRET_ARN"`
+	SMTPParameter  string          `json:"SMTP_CREDENTIALS_PARAMETER"`
+	SMTPAuth       string          `json:"SMTP_AUTH"`
+	SMTPTokenURL   string          `json:"SMTP_OAUTH_TOKEN_URL"`
+	SMTPClientID   string          `json:"SMTP_OAUTH_CLIENT_ID"`
+	SMTPClientKey  string          `json:"SMTP_OAUTH_CLIENT_SECRET"`
+	SMTPScope      string          `json:"SMTP_OAUTH_SCOPE"`
+	SESConfigSet   string          `json:"SES_CONFIGURATION_SET"`
+	SendGridKey    string          `json:"SENDGRID_API_KEY"`
+	MailgunDomain  string          `json:"MAILGUN_DOMAIN"`
+	MailgunKey     string          `json:"MAILGUN_API_KEY"`
+	MailgunAPIBase string          `json:"MAILGUN_API_BASE"`
+	SMIMESecret    string          `json:"SMIME_SECRET"`
+	SMIMEReceivers string          `json:"SMIME_RECIPIENTS_SECRET"`
+}
+
+// transportProfiles maps the profile names to their settings.
+type transportProfiles map[string]transportProfile
+
+// UnmarshalText decodes the profiles from their JSON representation, so they can be read from an environment variable.
+func (profiles *transportProfiles) UnmarshalText(text []byte) error {
+	return json.Unmarshal(text, (*map[string]transportProfile)(profiles))
+}
+
+// withProfile returns the configuration with the transport settings of the profile. They are not inherited from the default transport,
+// so its credentials are never sent to another provider, while the other settings, ie: the SMTP retries, are shared.
+func (cfg Config) withProfile(profile transportProfile) Config {
+	cfg.MailTransport = "smtp"
+	if profile.MailTransport != "" {
+		cfg.MailTransport = profile.MailTransport
+	}
+	cfg.SMTPHost = profile.SMTPHost
+	cfg.SMTPPort = transport.Port{Number: 465, TLS: transport.TLSImplicit}
+	if profile.SMTPPort != nil {
+		cfg.SMTPPort = *profile.SMTPPort
+	}
+	cfg.SMTPTLS = profile.SMTPTLS
+	cfg.SMTPTLSMin = "1.2"
+	if profile.SMTPTLSMin != "" {
+		cfg.SMTPTLSMin = profile.SMTPTLSMin
+	}
+	cfg.SMTPCABundle = profile.SMTPCABundle
+	cfg.SMTPInsecureTLS = profile.SMTPInsecure
+	cfg.SMTPUserName = profile.SMTPUserName
+	cfg.SMTPPassword = profile.SMTPPassword
+	cfg.SMTPSecret = profile.SMTPSecret
+	cfg.SMTPParameter = profile.SMTPParameter
+	cfg.SMTPAuth = profile.SMTPAuth
+	cfg.SMTPTokenURL = profile.SMTPTokenURL
+	cfg.SMTPClientID = profile.SMTPClientID
+	cfg.SMTPClientSecret = profile.SMTPClientKey
+	cfg.SMTPScope = profile.SMTPScope
+	cfg.SESConfigSet = profile.SESConfigSet
+	cfg.SendGridKey = profile.SendGridKey
+	cfg.MailgunDomain = profile.MailgunDomain
+	cfg.MailgunKey = profile.MailgunKey
+	cfg.SMIMESecret = profile.SMIMESecret
+	cfg.SMIMERecipients = profile.SMIMEReceivers
+	cfg.MailgunAPIBase = "https://api.mailgun.net"
+	if profile.MailgunAPIBase != "" {
+		cfg.MailgunAPIBase = profile.MailgunAPIBase
+	}
+
+	return cfg
+}
@@ -0,0 +1,212 @@
+package handler
+
+import (
+	"flag"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/forsam-education/hermes/deadletter"
+	"github.com/forsam-education/hermes/mailmessage"
+	"log"
+	"strconv"
+	"time"
+)
+
+// Settings of the dead-letter queue reads of the replay, the messages left in the queue being hidden until the replay is done.
+const (
+	replayWaitSeconds       = 1
+	replayVisibilityTimeout = 15 * 60
+	replayDefaultPeriod     = 7 * 24 * time.Hour
+)
+
+// replayOptions are the filters and destination of a replay.
+type replayOptions struct {
+	since    time.Time
+	until    time.Time
+	template string
+	target   string
+	dryRun   bool
+}
+
+// parseReplayDate parses a date given as a day, ie: 2020-10-01, or as an RFC 3339 time, the fallback being used when it is empty.
+func parseReplayDate(value string, fallback time.Time) (time.Time, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	if date, err := time.Parse("2006-01-02", value); err == nil {
+		return date, nil
+	}
+	date, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is not a YYYY-MM-DD day nor an RFC 3339 time", value)
+	}
+
+	return date, nil
+}
+
+// matches tells if a message which failed at the date is selected by the filters.
+func (opts replayOptions) matches(body string, failedAt time.Time) bool {
+	if failedAt.Before(opts.since) || failedAt.After(opts.until) {
+		return false
+	}
+	if opts.template != "" {
+		if templateName, _ := mailmessage.DescribeMessage(body); templateName != opts.template {
+			return false
+		}
+	}
+
+	return true
+}
+
+// enqueue sends a replayed message to the target queue, with the id of the message it replays as attribute.
+func (opts replayOptions) enqueue(sqsClient *sqs.SQS, messageID string, body string) error {
+	if opts.dryRun {
+		log.Printf("Would replay message %s", messageID)
+		return nil
+	}
+
+	_, err := sqsClient.SendMessage(&sqs.SendMessageInput{
+		QueueUrl:    aws.String(opts.target),
+		MessageBody: aws.String(body),
+		MessageAttributes: map[string]*sqs.MessageAttributeValue{
+			"source_message_id": {DataType: aws.String("String"), StringValue: aws.String(messageID)},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("unable to replay message %s to queue %q: %s", messageID, opts.target, err.Error())
+	}
+	log.Printf("Replayed message %s", messageID)
+
+	return nil
+}
+
+// replayArchive replays the messages archived in the failed messages bucket, which are kept there.
+func replayArchive(cfg Config, sqsClient *sqs.SQS, bucket string, prefix string, opts replayOptions) (int, error) {
+	archive, err := deadletter.NewS3(bucket, prefix, cfg.AWSRegion)
+	if err != nil {
+		return 0, fmt.Errorf("unable to instantiate failed messages archive: %s", err.Error())
+	}
+
+	var replayed int
+	err = archive.Walk(opts.since, opts.until, func(message deadletter.FailedMessage) error {
+		if !opts.matches(message.Body, message.FailedAt) {
+			return nil
+		}
+		if err := opts.enqueue(sqsClient, message.MessageID, message.Body); err != nil {
+			return err
+		}
+		replayed++
+		return nil
+	})
+
+	return replayed, err
+}
+
+// replayQueue replays the messages of a dead-letter queue, deleting them once they are sent back to the target queue. The messages the filters
+// skip are left in the queue, and received again once the visibility timeout is over.
+func replayQueue(sqsClient *sqs.SQS, queueURL string, opts replayOptions) (int, error) {
+	var replayed int
+	for {
+		output, err := sqsClient.ReceiveMessage(&sqs.ReceiveMessageInput{
+			QueueUrl:              aws.String(queueURL),
+			MaxNumberOfMessages:   aws.Int64(receiveBatchSize),
+			WaitTimeSeconds:       aws.Int64(replayWaitSeconds),
+			VisibilityTimeout:     aws.Int64(replayVisibilityTimeout),
+			AttributeNames:        aws.StringSlice([]string{sqs.MessageSystemAttributeNameSentTimestamp}),
+			MessageAttributeNames: aws.StringSlice([]string{sqs.QueueAttributeNameAll}),
+		})
+		if err != nil {
+			return replayed, fmt.Errorf("unable to receive messages of queue %q: %s", queueURL, err.Error())
+		}
+		if len(output.Messages) == 0 {
+			return replayed, nil
+		}
+
+		for _, message := range output.Messages {
+			messageID, body := aws.StringValue(message.MessageId), aws.StringValue(message.Body)
+			// The messages sent to the dead-letter queue by hermes keep the id of the original message.
+			if source := message.MessageAttributes["source_message_id"]; source != nil {
+				messageID = aws.StringValue(source.StringValue)
+			}
+			sentAt, _ := strconv.ParseInt(aws.StringValue(message.Attributes[sqs.MessageSystemAttributeNameSentTimestamp]), 10, 64)
+			if !opts.matches(body, time.Unix(0, sentAt*int64(time.Millisecond))) {
+				continue
+			}
+			if err := opts.enqueue(sqsClient, messageID, body); err != nil {
+				return replayed, err
+			}
+			replayed++
+			if opts.dryRun {
+				continue
+			}
+			_, err := sqsClient.DeleteMessage(&sqs.DeleteMessageInput{QueueUrl: aws.String(queueURL), ReceiptHandle: message.ReceiptHandle})
+			if err != nil {
+				return replayed, fmt.Errorf("unable to delete replayed message %s: %s", messageID, err.Error())
+			}
+		}
+	}
+}
+
+// replay is the replay subcommand, sending back to the queue the messages which failed during an outage, ie:
+// hermes replay --since 2020-10-01 --template welcome. They are read from FAILED_MESSAGES_BUCKET, or from the --queue dead-letter queue.
+func replay(cfg Config, args []string) error {
+	flags := flag.NewFlagSet("replay", flag.ContinueOnError)
+	bucket := flags.String("bucket", cfg.FailedBucket, "bucket archiving the failed messages, FAILED_MESSAGES_BUCKET by default")
+	prefix := flags.String("prefix", cfg.FailedPrefix, "key prefix of the failed messages, FAILED_MESSAGES_PREFIX by default")
+	queue := flags.String("queue", "", "dead-letter queue to replay the messages of, instead of the bucket")
+	since := flags.String("since", "", "replay the messages which failed from this day or time, 7 days ago by default")
+	until := flags.String("until", "", "replay the messages which failed until this day or time, now by default")
+	template := flags.String("template", "", "replay only the messages of this template")
+	target := flags.String("to", cfg.QueueURL, "queue the messages are sent back to, SQS_QUEUE by default")
+	dryRun := flags.Bool("dry-run", false, "list the messages which would be replayed without sending them")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	opts := replayOptions{template: *template, target: *target, dryRun: *dryRun}
+	var err error
+	if opts.since, err = parseReplayDate(*since, now.Add(-replayDefaultPeriod)); err != nil {
+		return fmt.Errorf("invalid --since: %s", err.Error())
+	}
+	if opts.until, err = parseReplayDate(*until, now); err != nil {
+		return fmt.Errorf("invalid --until: %s", err.Error())
+	}
+	if *until != "" && len(*until) == len("2006-01-02") {
+		// A day includes the messages which failed during it.
+		opts.until = opts.until.Add(24*time.Hour - time.Nanosecond)
+	}
+	if opts.until.Before(opts.since) {
+		return fmt.Errorf("--until is before --since")
+	}
+	if opts.target == "" && !opts.dryRun {
+		return fmt.Errorf("--to or SQS_QUEUE is required")
+	}
+	if *queue == "" && *bucket == "" {
+		return fmt.Errorf("--queue, --bucket or FAILED_MESSAGES_BUCKET is required")
+	}
+	if *queue != "" && *queue == opts.target {
+		return fmt.Errorf("--queue can't be the queue the messages are sent back to")
+	}
+
+	sess, err := session.NewSession(&aws.Config{Region: aws.String(cfg.AWSRegion)})
+	if err != nil {
+		return fmt.Errorf("unable to connect to AWS: %s", err.Error())
+	}
+	sqsClient := sqs.New(sess)
+
+	var replayed int
+	if *queue != "" {
+		replayed, err = replayQueue(sqsClient, *queue, opts)
+	} else {
+		replayed, err = replayArchive(cfg, sqsClient, *bucket, *prefix, opts)
+	}
+	if opts.dryRun {
+		log.Printf("Would replay %d messages", replayed)
+	} else {
+		log.Printf("Replayed %d messages", replayed)
+	}
+
+	return err
+}
@@ -0,0 +1,81 @@
+package handler
+
+import (
+	"fmt"
+	"sync"
+)
+
+// retryBudget bounds the retries of the whole batch, so a few failing records can't use the invocation time of the others.
+// A record is always attempted once, its retries being skipped once the budget is spent.
+type retryBudget struct {
+	mu         sync.Mutex
+	remaining  int
+	attempts   map[string]int
+	lastErrors map[string]error
+}
+
+func newRetryBudget(total int) *retryBudget {
+	return &retryBudget{remaining: total, attempts: make(map[string]int), lastErrors: make(map[string]error)}
+}
+
+// allow counts an attempt of the record, returning the error of its previous attempt when it is a retry the budget can't afford.
+func (budget *retryBudget) allow(messageID string) error {
+	budget.mu.Lock()
+	defer budget.mu.Unlock()
+
+	budget.attempts[messageID]++
+	if budget.attempts[messageID] == 1 {
+		return nil
+	}
+	if budget.remaining <= 0 {
+		return &budgetSpentError{lastError: budget.lastErrors[messageID]}
+	}
+	budget.remaining--
+
+	return nil
+}
+
+// record keeps the error of the last attempt of the record, an attempt denied by the budget keeping the error of the previous one.
+func (budget *retryBudget) record(messageID string, err error) {
+	if _, spent := err.(*budgetSpentError); spent {
+		return
+	}
+
+	budget.mu.Lock()
+	defer budget.mu.Unlock()
+
+	budget.lastErrors[messageID] = err
+}
+
+// budgetSpentError is returned for a retry the budget can't afford, with the error of the last attempt of the record, if any.
+type budgetSpentError struct {
+	lastError error
+}
+
+func (err *budgetSpentError) Error() string {
+	if err.lastError == nil {
+		return "retry budget of the batch is spent"
+	}
+
+	return fmt.Sprintf("retry budget of the batch is spent, last attempt failed: %s", err.lastError.Error())
+}
+
+// attemptCounter counts the attempts of each record, so its last one can be told apart.
+type attemptCounter struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+func newAttemptCounter() *attemptCounter {
+	return &attemptCounter{counts: make(map[string]int)}
+}
+
+// next counts a new attempt of the record, and returns its number starting at 1.
+func (counter *attemptCounter) next(messageID string) int {
+	counter.mu.Lock()
+	defer counter.mu.Unlock()
+
+	counter.counts[messageID]++
+
+	return counter.counts[messageID]
+}
@@ -0,0 +1,109 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/forsam-education/hermes/storage"
+	"gopkg.in/gomail.v2"
+	"net/textproto"
+	"sync"
+	"testing"
+)
+
+func TestRetryBudget(t *testing.T) {
+	budget := newRetryBudget(2)
+
+	tests := []struct {
+		messageID string
+		spent     string
+	}{
+		{messageID: "a"},
+		{messageID: "b"},
+		{messageID: "a"},
+		{messageID: "b"},
+		{messageID: "a", spent: "retry budget of the batch is spent, last attempt failed: a failed"},
+		{messageID: "c"},
+		{messageID: "c", spent: "retry budget of the batch is spent, last attempt failed: c failed"},
+		{messageID: "d"},
+	}
+	for i, test := range tests {
+		err := budget.allow(test.messageID)
+		if test.spent == "" {
+			if err != nil {
+				t.Fatalf("expected attempt %d of %s to be allowed, got %s", i+1, test.messageID, err.Error())
+			}
+			budget.record(test.messageID, errors.New(test.messageID+" failed"))
+			continue
+		}
+		if err == nil || err.Error() != test.spent {
+			t.Fatalf("expected attempt %d of %s to be denied with %q, got %v", i+1, test.messageID, test.spent, err)
+		}
+		// A denied attempt keeps the error of the previous one.
+		budget.record(test.messageID, err)
+	}
+	if err := budget.allow("a"); err == nil || err.Error() != "retry budget of the batch is spent, last attempt failed: a failed" {
+		t.Errorf("expected the last error of a to be kept, got %v", err)
+	}
+}
+
+// failingDialer counts the dials, failing each of them with a transient error.
+type failingDialer struct {
+	mu    sync.Mutex
+	dials int
+}
+
+func (dialer *failingDialer) Dial() (gomail.SendCloser, error) {
+	dialer.mu.Lock()
+	defer dialer.mu.Unlock()
+	dialer.dials++
+
+	return nil, &textproto.Error{Code: 421, Msg: "4.3.2 service not available"}
+}
+
+func TestSendRecordsRetryBudget(t *testing.T) {
+	tests := []struct {
+		name     string
+		budget   int
+		attempts int
+	}{
+		{name: "retries every record without budget", attempts: 3 * recordRetries},
+		{name: "stops the retries once the budget is spent", budget: 2, attempts: 3 + 2},
+		{name: "attempts each record once with a budget of one", budget: 1, attempts: 3 + 1},
+		{name: "retries every record within the budget", budget: 100, attempts: 3 * recordRetries},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			templates := storage.NewMemory()
+			templates.Set("welcome.html.template", []byte("<p>Hello {{.name}}</p>"))
+			templates.Set("welcome.txt.template", []byte("Hello {{.name}}"))
+			dialer := &failingDialer{}
+			cfg := validConfig(t)
+			cfg.BatchFailures = true
+			cfg.RetryBudget = test.budget
+			h, err := New(cfg, Services{Templates: templates, Transport: dialer})
+			if err != nil {
+				t.Fatalf("unable to instantiate handler: %s", err.Error())
+			}
+
+			var records []events.SQSMessage
+			for i := 0; i < 3; i++ {
+				body := `{"from_address": "noreply@example.com", "reply_to": "support@example.com", "to": ["jane@example.org"], "subject": "Welcome", "template_name": "welcome", "template_context": {"name": "Jane"}}`
+				records = append(records, events.SQSMessage{MessageId: fmt.Sprintf("message-%d", i), Body: body})
+			}
+			response, err := h.sendRecords(context.Background(), records, true, nil)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+
+			if failures := response.(*sqsBatchResponse).BatchItemFailures; len(failures) != 3 {
+				t.Errorf("expected the 3 records to fail, got %v", failures)
+			}
+			if attempts := dialer.dials; attempts != test.attempts {
+				t.Errorf("expected %d attempts, got %d", test.attempts, attempts)
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package handler
+
+import (
+	"fmt"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/forsam-education/hermes/mailmessage"
+	"log"
+	"strings"
+	"time"
+)
+
+// maxQueueDelay is the longest delay SQS can give a message. A message scheduled later is delayed again each time it is received, until its send_at.
+const maxQueueDelay = 15 * time.Minute
+
+// newScheduleQueue instanciates the SQS client enqueuing the messages scheduled later.
+func newScheduleQueue(region string) (*sqs.SQS, error) {
+	sess, err := session.NewSession(&aws.Config{Region: aws.String(region)})
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to AWS: %s", err.Error())
+	}
+
+	return sqs.New(sess), nil
+}
+
+// queueURLOf returns the URL of the queue a record comes from, the records received by hermes serve already holding it as their event source.
+func queueURLOf(eventSourceARN string) (string, error) {
+	if strings.HasPrefix(eventSourceARN, "https://") {
+		return eventSourceARN, nil
+	}
+
+	// ie: arn:aws:sqs:eu-west-1:123456789012:hermes
+	parts := strings.Split(eventSourceARN, ":")
+	if len(parts) != 6 || parts[0] != "arn" || parts[2] != "sqs" {
+		return "", fmt.Errorf("event source %q is not an SQS queue", eventSourceARN)
+	}
+
+	return fmt.Sprintf("https://sqs.%s.amazonaws.com/%s/%s", parts[3], parts[4], parts[5]), nil
+}
+
+// toMessageAttributes converts the attributes of a record back to those of a sent message.
+func toMessageAttributes(attributes map[string]events.SQSMessageAttribute) map[string]*sqs.MessageAttributeValue {
+	if len(attributes) == 0 {
+		return nil
+	}
+
+	messageAttributes := make(map[string]*sqs.MessageAttributeValue, len(attributes))
+	for name, attribute := range attributes {
+		messageAttributes[name] = &sqs.MessageAttributeValue{
+			DataType:    aws.String(attribute.DataType),
+			StringValue: attribute.StringValue,
+			BinaryValue: attribute.BinaryValue,
+		}
+	}
+
+	return messageAttributes
+}
+
+// deferRecord enqueues again a message whose send_at is in the future, or which is due during its quiet hours, with the delay left until then,
+// telling if it was deferred. The send time is read from the verified message of a signed record, which is enqueued as received.
+// The record itself is then processed as sent, so it is deleted from the queue.
+func (h *Handler) deferRecord(event events.SQSMessage, message string) (bool, error) {
+	sendAt := mailmessage.SendTime(message, h.mailer.Options, time.Now())
+	delay := time.Until(sendAt)
+	if sendAt.IsZero() || delay < time.Second {
+		return false, nil
+	}
+	if delay > maxQueueDelay {
+		delay = maxQueueDelay
+	}
+
+	queueURL := h.cfg.QueueURL
+	if queueURL == "" && event.EventSourceARN == "" {
+		return false, fmt.Errorf("unable to schedule message, SQS_QUEUE is required to schedule messages which are not queued")
+	}
+	if queueURL == "" {
+		var err error
+		if queueURL, err = queueURLOf(event.EventSourceARN); err != nil {
+			return false, fmt.Errorf("unable to schedule message: %s", err.Error())
+		}
+	}
+	if strings.HasSuffix(queueURL, ".fifo") {
+		return false, fmt.Errorf("unable to schedule message, FIFO queue %q doesn't support message delays", queueURL)
+	}
+
+	_, err := h.scheduleQueue.SendMessage(&sqs.SendMessageInput{
+		QueueUrl:          aws.String(queueURL),
+		MessageBody:       aws.String(event.Body),
+		MessageAttributes: toMessageAttributes(event.MessageAttributes),
+		DelaySeconds:      aws.Int64(int64((delay + time.Second - 1) / time.Second)),
+	})
+	if err != nil {
+		return false, fmt.Errorf("unable to schedule message: %s", err.Error())
+	}
+	log.Printf("Message %s is scheduled at %s, delayed by %s", event.MessageId, sendAt.Format(time.RFC3339), delay)
+
+	return true, nil
+}
@@ -0,0 +1,233 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	"github.com/forsam-education/hermes/storage"
+	"sync"
+	"testing"
+	"time"
+)
+
+const validQueueURL = "https://sqs.eu-west-1.amazonaws.com/123456789012/hermes"
+
+// fakeQueue records the messages sent to SQS, failing them with err when set. The other methods of the SQS API panic.
+type fakeQueue struct {
+	sqsiface.SQSAPI
+	mu     sync.Mutex
+	inputs []*sqs.SendMessageInput
+	err    error
+}
+
+func (queue *fakeQueue) SendMessage(input *sqs.SendMessageInput) (*sqs.SendMessageOutput, error) {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+
+	if queue.err != nil {
+		return nil, queue.err
+	}
+	queue.inputs = append(queue.inputs, input)
+
+	return &sqs.SendMessageOutput{MessageId: aws.String(fmt.Sprintf("queued-%d", len(queue.inputs)))}, nil
+}
+
+func (queue *fakeQueue) sent() []*sqs.SendMessageInput {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+
+	return append([]*sqs.SendMessageInput(nil), queue.inputs...)
+}
+
+func newScheduleTestHandler(t *testing.T, queue *fakeQueue, dialer *failingDialer) *Handler {
+	t.Helper()
+
+	templates := storage.NewMemory()
+	templates.Set("welcome.html.template", []byte("<p>Hello {{.name}}</p>"))
+	templates.Set("welcome.txt.template", []byte("Hello {{.name}}"))
+	h, err := New(validConfig(t), Services{Templates: templates, Transport: dialer, Queue: queue})
+	if err != nil {
+		t.Fatalf("unable to instantiate handler: %s", err.Error())
+	}
+
+	return h
+}
+
+func scheduledBody(sendAt time.Time) string {
+	return fmt.Sprintf(`{"from_address": "noreply@example.com", "to": ["jane@example.org"], "subject": "Welcome", "template_name": "welcome", "template_context": {"name": "Jane"}, "send_at": %q}`, sendAt.Format(time.RFC3339))
+}
+
+func TestQueueURLOf(t *testing.T) {
+	tests := []struct {
+		source   string
+		expected string
+		err      string
+	}{
+		{source: "arn:aws:sqs:eu-west-1:123456789012:hermes", expected: "https://sqs.eu-west-1.amazonaws.com/123456789012/hermes"},
+		{source: "arn:aws:sqs:us-east-1:123456789012:hermes.fifo", expected: "https://sqs.us-east-1.amazonaws.com/123456789012/hermes.fifo"},
+		{source: "https://sqs.eu-west-1.amazonaws.com/123456789012/hermes", expected: "https://sqs.eu-west-1.amazonaws.com/123456789012/hermes"},
+		{source: "arn:aws:sns:eu-west-1:123456789012:hermes", err: `event source "arn:aws:sns:eu-west-1:123456789012:hermes" is not an SQS queue`},
+		{source: "arn:aws:sqs:eu-west-1:hermes", err: `event source "arn:aws:sqs:eu-west-1:hermes" is not an SQS queue`},
+		{source: "hermes", err: `event source "hermes" is not an SQS queue`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.source, func(t *testing.T) {
+			queueURL, err := queueURLOf(test.source)
+			if test.err != "" {
+				if err == nil || err.Error() != test.err {
+					t.Errorf("expected error %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil || queueURL != test.expected {
+				t.Errorf("expected %q, got %q, %v", test.expected, queueURL, err)
+			}
+		})
+	}
+}
+
+func TestDeferRecord(t *testing.T) {
+	const sourceARN = "arn:aws:sqs:eu-west-1:123456789012:scheduled"
+	now := time.Now()
+
+	tests := []struct {
+		name     string
+		queueURL string
+		source   string
+		sendAt   time.Time
+		queueErr error
+		deferred bool
+		expected string
+		delay    time.Duration
+		err      string
+	}{
+		{name: "sends a message due now", queueURL: validQueueURL, sendAt: now.Add(-time.Minute)},
+		{name: "sends a message due within a second", queueURL: validQueueURL, sendAt: now},
+		{name: "delays a message until its send time", queueURL: validQueueURL, sendAt: now.Add(5 * time.Minute), deferred: true, expected: validQueueURL, delay: 5 * time.Minute},
+		{name: "caps the delay of a message scheduled later", queueURL: validQueueURL, sendAt: now.Add(2 * time.Hour), deferred: true, expected: validQueueURL, delay: maxQueueDelay},
+		{name: "caps the delay of a message scheduled just after the maximum", queueURL: validQueueURL, sendAt: now.Add(maxQueueDelay + 2*time.Second), deferred: true, expected: validQueueURL, delay: maxQueueDelay},
+		{name: "enqueues to the source queue without SQS_QUEUE", source: sourceARN, sendAt: now.Add(time.Hour), deferred: true, expected: "https://sqs.eu-west-1.amazonaws.com/123456789012/scheduled", delay: maxQueueDelay},
+		{name: "prefers SQS_QUEUE to the source queue", queueURL: validQueueURL, source: sourceARN, sendAt: now.Add(time.Hour), deferred: true, expected: validQueueURL, delay: maxQueueDelay},
+		{name: "rejects a FIFO source queue", source: sourceARN + ".fifo", sendAt: now.Add(time.Hour), err: `unable to schedule message, FIFO queue "https://sqs.eu-west-1.amazonaws.com/123456789012/scheduled.fifo" doesn't support message delays`},
+		{name: "rejects a FIFO SQS_QUEUE", queueURL: validQueueURL + ".fifo", sendAt: now.Add(time.Hour), err: fmt.Sprintf("unable to schedule message, FIFO queue %q doesn't support message delays", validQueueURL+".fifo")},
+		{name: "requires a queue", sendAt: now.Add(time.Hour), err: "unable to schedule message, SQS_QUEUE is required to schedule messages which are not queued"},
+		{name: "rejects a source which is not a queue", source: "arn:aws:sns:eu-west-1:123456789012:hermes", sendAt: now.Add(time.Hour), err: `unable to schedule message: event source "arn:aws:sns:eu-west-1:123456789012:hermes" is not an SQS queue`},
+		{name: "fails when the message can't be enqueued", queueURL: validQueueURL, sendAt: now.Add(time.Hour), queueErr: errors.New("throttled"), err: "unable to schedule message: throttled"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			queue := &fakeQueue{err: test.queueErr}
+			h := newScheduleTestHandler(t, queue, &failingDialer{})
+			h.cfg.QueueURL = test.queueURL
+
+			body := scheduledBody(test.sendAt)
+			deferred, err := h.deferRecord(events.SQSMessage{MessageId: "scheduled", Body: body, EventSourceARN: test.source}, body)
+			if test.err != "" {
+				if err == nil || err.Error() != test.err {
+					t.Errorf("expected error %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+			if deferred != test.deferred {
+				t.Fatalf("expected deferred to be %t, got %t", test.deferred, deferred)
+			}
+
+			sent := queue.sent()
+			if !test.deferred {
+				if len(sent) != 0 {
+					t.Errorf("expected no message to be enqueued, got %d", len(sent))
+				}
+				return
+			}
+			if len(sent) != 1 {
+				t.Fatalf("expected a message to be enqueued, got %d", len(sent))
+			}
+			if queueURL := aws.StringValue(sent[0].QueueUrl); queueURL != test.expected {
+				t.Errorf("expected the message to be enqueued to %q, got %q", test.expected, queueURL)
+			}
+			// The send time is written in seconds, so the delay can be up to a second shorter than expected.
+			delay := time.Duration(aws.Int64Value(sent[0].DelaySeconds)) * time.Second
+			if delay > test.delay || delay < test.delay-time.Second {
+				t.Errorf("expected a delay of %s, got %s", test.delay, delay)
+			}
+			if aws.StringValue(sent[0].MessageBody) != body {
+				t.Errorf("expected the message to be enqueued as received, got %q", aws.StringValue(sent[0].MessageBody))
+			}
+		})
+	}
+}
+
+func TestDeferRecordKeepsAttributes(t *testing.T) {
+	queue := &fakeQueue{}
+	h := newScheduleTestHandler(t, queue, &failingDialer{})
+
+	body := scheduledBody(time.Now().Add(time.Hour))
+	record := events.SQSMessage{MessageId: "scheduled", Body: body, MessageAttributes: map[string]events.SQSMessageAttribute{
+		"tenant":   {DataType: "String", StringValue: aws.String("acme")},
+		"priority": {DataType: "Number", StringValue: aws.String("1")},
+		"trace":    {DataType: "Binary", BinaryValue: []byte{1, 2, 3}},
+	}}
+	if deferred, err := h.deferRecord(record, body); err != nil || !deferred {
+		t.Fatalf("expected the message to be deferred, got %t, %v", deferred, err)
+	}
+
+	attributes := queue.sent()[0].MessageAttributes
+	if len(attributes) != 3 {
+		t.Fatalf("expected the 3 attributes to be kept, got %v", attributes)
+	}
+	if tenant := attributes["tenant"]; aws.StringValue(tenant.DataType) != "String" || aws.StringValue(tenant.StringValue) != "acme" {
+		t.Errorf("expected the tenant attribute to be kept, got %v", tenant)
+	}
+	if priority := attributes["priority"]; aws.StringValue(priority.DataType) != "Number" || aws.StringValue(priority.StringValue) != "1" {
+		t.Errorf("expected the priority attribute to be kept, got %v", priority)
+	}
+	if trace := attributes["trace"]; aws.StringValue(trace.DataType) != "Binary" || string(trace.BinaryValue) != "\x01\x02\x03" {
+		t.Errorf("expected the trace attribute to be kept, got %v", trace)
+	}
+}
+
+func TestSendRecordsDefersAgain(t *testing.T) {
+	queue := &fakeQueue{}
+	dialer := &failingDialer{}
+	h := newScheduleTestHandler(t, queue, dialer)
+	h.cfg.BatchFailures = true
+
+	// Each time the message is received before its send time, it is enqueued again, as the record received the first time.
+	body := scheduledBody(time.Now().Add(40 * time.Minute))
+	record := events.SQSMessage{MessageId: "scheduled", Body: body, EventSourceARN: "arn:aws:sqs:eu-west-1:123456789012:hermes"}
+	for i := 1; i <= 2; i++ {
+		response, err := h.sendRecords(context.Background(), []events.SQSMessage{record}, true, nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+		if failures := response.(*sqsBatchResponse).BatchItemFailures; len(failures) != 0 {
+			t.Fatalf("expected the scheduled record to be deleted, got %v", failures)
+		}
+		sent := queue.sent()
+		if len(sent) != i || aws.StringValue(sent[i-1].MessageBody) != body || aws.Int64Value(sent[i-1].DelaySeconds) != int64(maxQueueDelay/time.Second) {
+			t.Fatalf("expected the message to be delayed again, got %d messages", len(sent))
+		}
+		record = events.SQSMessage{MessageId: fmt.Sprintf("scheduled-%d", i), Body: aws.StringValue(sent[i-1].MessageBody), EventSourceARN: record.EventSourceARN}
+	}
+	if dialer.dials != 0 {
+		t.Errorf("expected a scheduled message not to be sent, got %d attempts", dialer.dials)
+	}
+
+	// Once due, it is sent.
+	due := events.SQSMessage{MessageId: "due", Body: scheduledBody(time.Now().Add(-time.Second))}
+	if _, err := h.sendRecords(context.Background(), []events.SQSMessage{due}, true, nil); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if len(queue.sent()) != 2 || dialer.dials == 0 {
+		t.Errorf("expected the due message to be sent, got %d enqueued messages and %d attempts", len(queue.sent()), dialer.dials)
+	}
+}
@@ -0,0 +1,209 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/segmentio/kafka-go"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// Long polling settings of the daemon mode, receiving as many messages as an SQS event source mapping batch at most.
+const (
+	receiveBatchSize   = 10
+	receiveWaitSeconds = 20
+	receiveErrorPause  = 5 * time.Second
+)
+
+// toRecord converts a received message to the record a Lambda SQS event would hold.
+func toRecord(message *sqs.Message, queueURL string) events.SQSMessage {
+	attributes := make(map[string]events.SQSMessageAttribute, len(message.MessageAttributes))
+	for name, attribute := range message.MessageAttributes {
+		attributes[name] = events.SQSMessageAttribute{
+			StringValue:      attribute.StringValue,
+			BinaryValue:      attribute.BinaryValue,
+			StringListValues: aws.StringValueSlice(attribute.StringListValues),
+			BinaryListValues: attribute.BinaryListValues,
+			DataType:         aws.StringValue(attribute.DataType),
+		}
+	}
+
+	return events.SQSMessage{
+		MessageId:              aws.StringValue(message.MessageId),
+		ReceiptHandle:          aws.StringValue(message.ReceiptHandle),
+		Body:                   aws.StringValue(message.Body),
+		Md5OfBody:              aws.StringValue(message.MD5OfBody),
+		Md5OfMessageAttributes: aws.StringValue(message.MD5OfMessageAttributes),
+		Attributes:             aws.StringValueMap(message.Attributes),
+		MessageAttributes:      attributes,
+		EventSourceARN:         queueURL,
+		EventSource:            "aws:sqs",
+	}
+}
+
+// deleteSent deletes the records which are not listed as failed, the failed ones being received again once their visibility timeout is over.
+func deleteSent(sqsClient *sqs.SQS, queueURL string, records []events.SQSMessage, response *sqsBatchResponse) error {
+	failed := make(map[string]bool, len(response.BatchItemFailures))
+	for _, failure := range response.BatchItemFailures {
+		failed[failure.ItemIdentifier] = true
+	}
+
+	var entries []*sqs.DeleteMessageBatchRequestEntry
+	for i, record := range records {
+		if failed[record.MessageId] {
+			continue
+		}
+		entries = append(entries, &sqs.DeleteMessageBatchRequestEntry{Id: aws.String(strconv.Itoa(i)), ReceiptHandle: aws.String(record.ReceiptHandle)})
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+
+	output, err := sqsClient.DeleteMessageBatch(&sqs.DeleteMessageBatchInput{QueueUrl: aws.String(queueURL), Entries: entries})
+	if err != nil {
+		return fmt.Errorf("unable to delete %d sent messages: %s", len(entries), err.Error())
+	}
+	if len(output.Failed) > 0 {
+		return fmt.Errorf("unable to delete %d of the %d sent messages: %s", len(output.Failed), len(entries), aws.StringValue(output.Failed[0].Message))
+	}
+
+	return nil
+}
+
+// listen serves the endpoints of the daemon on the address, ie: /metrics for Prometheus to scrape or /healthz for the orchestrator.
+// A server which can't listen is only logged, as the messages can still be sent.
+func listen(address string, mux *http.ServeMux) *http.Server {
+	server := &http.Server{Addr: address, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Printf("Unable to serve endpoints on %s: %s", address, err.Error())
+		}
+	}()
+	log.Printf("Serving endpoints on %s", address)
+
+	return server
+}
+
+// pause waits for the duration, or until the context is done.
+func pause(ctx context.Context, duration time.Duration) {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
+
+// serve long-polls the queue, or consumes the Kafka topic when KAFKA_BROKERS is set, and sends its messages through the same pipeline as the lambda,
+// until the process is interrupted or terminated. A batch being sent when the process is stopped is completed first.
+func serve(h *Handler) error {
+	if h.cfg.QueueURL == "" && len(h.cfg.KafkaBrokers) == 0 {
+		return fmt.Errorf("SQS_QUEUE or KAFKA_BROKERS is required to serve")
+	}
+	var dialer *kafka.Dialer
+	var sqsClient *sqs.SQS
+	if len(h.cfg.KafkaBrokers) > 0 {
+		var err error
+		if dialer, err = newKafkaDialer(h.cfg); err != nil {
+			return err
+		}
+	} else {
+		sess, err := session.NewSession(&aws.Config{Region: aws.String(h.cfg.AWSRegion)})
+		if err != nil {
+			return fmt.Errorf("unable to connect to AWS: %s", err.Error())
+		}
+		sqsClient = sqs.New(sess)
+	}
+	// The failed records are left in the queue or uncommitted, so the batch response is needed to delete or commit the sent ones.
+	h.cfg.BatchFailures = true
+	// The pooled connections are kept open between the batches, only the expired ones being closed.
+	h.keepConnections = true
+	defer h.connectionPools.CloseIdle()
+
+	// The endpoints sharing an address are served by the same server.
+	muxes := make(map[string]*http.ServeMux)
+	route := func(address string, path string, endpoint http.Handler) {
+		if muxes[address] == nil {
+			muxes[address] = http.NewServeMux()
+		}
+		muxes[address].Handle(path, endpoint)
+	}
+	if h.prometheus != nil {
+		route(h.cfg.MetricsAddress, "/metrics", h.prometheus)
+	}
+	var checks *healthChecks
+	if h.cfg.HealthAddress != "" {
+		checks = newHealthChecks(h)
+		route(h.cfg.HealthAddress, "/healthz", http.HandlerFunc(checks.healthz))
+		route(h.cfg.HealthAddress, "/readyz", http.HandlerFunc(checks.readyz))
+	}
+	for address, mux := range muxes {
+		server := listen(address, mux)
+		defer server.Close()
+	}
+
+	ctx, stop := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-signals
+		log.Printf("Stopping once the current batch is sent")
+		checks.stop()
+		stop()
+	}()
+
+	if dialer != nil {
+		log.Printf("Serving messages of Kafka topic %s as consumer group %s", h.cfg.KafkaTopic, h.cfg.KafkaGroup)
+		return consumeKafka(ctx, h, func() kafkaReader { return newKafkaReader(h.cfg, dialer) })
+	}
+
+	log.Printf("Serving messages of queue %s", h.cfg.QueueURL)
+	for ctx.Err() == nil {
+		h.connectionPools.CloseExpired()
+		output, err := sqsClient.ReceiveMessageWithContext(ctx, &sqs.ReceiveMessageInput{
+			QueueUrl:              aws.String(h.cfg.QueueURL),
+			MaxNumberOfMessages:   aws.Int64(receiveBatchSize),
+			WaitTimeSeconds:       aws.Int64(receiveWaitSeconds),
+			AttributeNames:        aws.StringSlice([]string{sqs.QueueAttributeNameAll}),
+			MessageAttributeNames: aws.StringSlice([]string{sqs.QueueAttributeNameAll}),
+		})
+		if ctx.Err() != nil {
+			break
+		}
+		if err != nil {
+			log.Printf("Unable to receive messages: %s", err.Error())
+			pause(ctx, receiveErrorPause)
+			continue
+		}
+		if len(output.Messages) == 0 {
+			continue
+		}
+
+		records := make([]events.SQSMessage, len(output.Messages))
+		for i, message := range output.Messages {
+			records[i] = toRecord(message, h.cfg.QueueURL)
+		}
+		batchCtx, span := h.mailer.Options.Tracer.StartSpan(context.Background(), "HandleMessages")
+		response, err := h.handleSQSEvent(batchCtx, events.SQSEvent{Records: records})
+		span.End(err)
+		if flushErr := h.mailer.Options.Tracer.Flush(); flushErr != nil {
+			log.Printf("Unable to export traces: %s", flushErr.Error())
+		}
+		if batchResponse, ok := response.(*sqsBatchResponse); ok {
+			if err := deleteSent(sqsClient, h.cfg.QueueURL, records, batchResponse); err != nil {
+				log.Printf("Unable to delete messages: %s", err.Error())
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,60 @@
+package handler
+
+import (
+	"encoding/json"
+	"github.com/aws/aws-lambda-go/events"
+	"strconv"
+	"time"
+)
+
+// snsNotification is the envelope of a message delivered by an SNS subscription to an SQS queue, when raw message delivery is disabled.
+type snsNotification struct {
+	Type      string `json:"Type"`
+	MessageID string `json:"MessageId"`
+	TopicArn  string `json:"TopicArn"`
+	Message   string `json:"Message"`
+}
+
+// unwrapSNSEnvelopes replaces the body of the records holding an SNS notification by the notified message, so it is decoded as if it was queued directly.
+func unwrapSNSEnvelopes(records []events.SQSMessage) {
+	for i, record := range records {
+		var notification snsNotification
+		if err := json.Unmarshal([]byte(record.Body), &notification); err != nil {
+			continue
+		}
+		if notification.Type != "Notification" || notification.TopicArn == "" {
+			continue
+		}
+		records[i].Body = notification.Message
+	}
+}
+
+// isSNSEvent tells if the payload is an event of a direct SNS subscription, whose records have the "aws:sns" event source.
+func isSNSEvent(payload []byte) bool {
+	var event struct {
+		Records []struct {
+			EventSource string `json:"EventSource"`
+		} `json:"Records"`
+	}
+	if err := json.Unmarshal(payload, &event); err != nil || len(event.Records) == 0 {
+		return false
+	}
+
+	return event.Records[0].EventSource == "aws:sns"
+}
+
+// snsRecords converts the SNS notifications to records, keyed by their SNS message id, so they are sent the same way as the queued messages.
+func snsRecords(event events.SNSEvent) []events.SQSMessage {
+	records := make([]events.SQSMessage, len(event.Records))
+	for i, record := range event.Records {
+		records[i] = events.SQSMessage{
+			MessageId:      record.SNS.MessageID,
+			Body:           record.SNS.Message,
+			EventSource:    record.EventSource,
+			EventSourceARN: record.SNS.TopicArn,
+			Attributes:     map[string]string{"SentTimestamp": strconv.FormatInt(record.SNS.Timestamp.UnixNano()/int64(time.Millisecond), 10)},
+		}
+	}
+
+	return records
+}
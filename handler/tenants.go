@@ -0,0 +1,101 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/forsam-education/hermes/mailmessage"
+	"github.com/forsam-education/hermes/secrets"
+	"github.com/forsam-education/hermes/storage"
+	"net/mail"
+)
+
+// tenantConfig is the configuration of a tenant, ie: {"template_bucket": "brand-a-templates", "transport": "brand-a", "default_from_address": "hello@brand-a.com"}.
+type tenantConfig struct {
+	TemplateBucket     string `json:"template_bucket"`
+	TemplatePrefix     string `json:"template_prefix"`
+	Transport          string `json:"transport"`
+	DKIMIdentity       string `json:"dkim_identity"`
+	DefaultFromAddress string `json:"default_from_address"`
+	DefaultFromName    string `json:"default_from_name"`
+}
+
+// readTenantConfigs reads the JSON object of the tenant configurations from the TENANTS_BUCKET object or the TENANTS_PARAMETER parameter, if any.
+func readTenantConfigs(cfg Config) (map[string]tenantConfig, error) {
+	var content string
+	switch {
+	case cfg.TenantsBucket != "":
+		tenantsStorage, err := storage.NewS3(cfg.TenantsBucket, cfg.AWSRegion)
+		if err != nil {
+			return nil, err
+		}
+		if content, err = tenantsStorage.Fetch(cfg.TenantsKey); err != nil {
+			return nil, err
+		}
+	case cfg.TenantsParameter != "":
+		ssmConnector, err := secrets.NewSSM(cfg.AWSRegion)
+		if err != nil {
+			return nil, err
+		}
+		if content, err = ssmConnector.Get(cfg.TenantsParameter); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, nil
+	}
+
+	var tenantConfigs map[string]tenantConfig
+	if err := json.Unmarshal([]byte(content), &tenantConfigs); err != nil {
+		return nil, fmt.Errorf("unable to unmarshal tenants: %s", err.Error())
+	}
+
+	return tenantConfigs, nil
+}
+
+// newTenants instanciates the configured tenants. The templates of a tenant with its own bucket or prefix fall back on the shared template storage,
+// so they can share its partials.
+func newTenants(cfg Config, sharedTemplates storage.TemplateFetcher) (map[string]*mailmessage.Tenant, error) {
+	tenantConfigs, err := readTenantConfigs(cfg)
+	if err != nil || len(tenantConfigs) == 0 {
+		return nil, err
+	}
+
+	tenants := make(map[string]*mailmessage.Tenant, len(tenantConfigs))
+	for name, tenantCfg := range tenantConfigs {
+		if _, ok := cfg.Transports[tenantCfg.Transport]; tenantCfg.Transport != "" && !ok {
+			return nil, fmt.Errorf("transport %q of tenant %q is not a configured transport profile", tenantCfg.Transport, name)
+		}
+		if _, ok := cfg.DKIMKeys[tenantCfg.DKIMIdentity]; tenantCfg.DKIMIdentity != "" && !ok {
+			return nil, fmt.Errorf("DKIM identity %q of tenant %q is not configured in DKIM_KEYS", tenantCfg.DKIMIdentity, name)
+		}
+		if parsed, err := mail.ParseAddress(tenantCfg.DefaultFromAddress); tenantCfg.DefaultFromAddress != "" && (err != nil || parsed.Address != tenantCfg.DefaultFromAddress) {
+			return nil, fmt.Errorf("default_from_address %q of tenant %q is not a valid address", tenantCfg.DefaultFromAddress, name)
+		}
+
+		tenant := &mailmessage.Tenant{
+			Transport:          tenantCfg.Transport,
+			DKIMIdentity:       tenantCfg.DKIMIdentity,
+			DefaultFromAddress: tenantCfg.DefaultFromAddress,
+			DefaultFromName:    tenantCfg.DefaultFromName,
+		}
+		if tenantCfg.TemplateBucket != "" || tenantCfg.TemplatePrefix != "" {
+			tenantTemplates := sharedTemplates
+			if tenantCfg.TemplateBucket != "" {
+				if tenantTemplates, err = newS3Templates(cfg, tenantCfg.TemplateBucket); err != nil {
+					return nil, fmt.Errorf("unable to instantiate template connector of tenant %q: %s", name, err.Error())
+				}
+			}
+			if tenantCfg.TemplatePrefix != "" {
+				tenantTemplates = storage.NewPrefixConnector(tenantTemplates, tenantCfg.TemplatePrefix)
+			}
+			tenant.Templates = storage.NewVersionedConnector(storage.NewChainConnector(tenantTemplates, sharedTemplates))
+			if cfg.TemplateCacheTTL > 0 {
+				templateCache := storage.NewCache(tenant.Templates, cfg.TemplateCacheTTL)
+				templateCache.MaxEntries = cfg.TemplateCacheMax
+				tenant.Templates = templateCache
+			}
+		}
+		tenants[name] = tenant
+	}
+
+	return tenants, nil
+}
@@ -0,0 +1,177 @@
+// Package hermestest runs the hermes handler against in-memory templates, attachments, queue, idempotency keys, dead letters and mail transport,
+// so the producers of messages can test their messages end to end without S3, SQS, DynamoDB or a mail server.
+package hermestest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/forsam-education/hermes/handler"
+	"github.com/forsam-education/hermes/idempotency"
+	"github.com/forsam-education/hermes/mailer"
+	"github.com/forsam-education/hermes/results"
+	"github.com/forsam-education/hermes/storage"
+	"github.com/forsam-education/hermes/transport"
+	"sync"
+	"time"
+)
+
+// Settings the harness gives the configuration when they are missing, as its services replace the AWS ones.
+const (
+	defaultRegion   = "eu-west-1"
+	defaultQueueURL = "https://sqs.eu-west-1.amazonaws.com/123456789012/hermes"
+	defaultBucket   = "hermestest"
+	defaultSMTPHost = "localhost"
+	defaultKeysTTL  = 24 * time.Hour
+)
+
+// BatchItemFailure identifies a message the lambda would return to the queue.
+type BatchItemFailure struct {
+	ItemIdentifier string `json:"itemIdentifier"`
+}
+
+// BatchResponse is the partial batch response the lambda returns with BATCH_ITEM_FAILURES, listing the messages to retry.
+type BatchResponse struct {
+	BatchItemFailures []BatchItemFailure `json:"batchItemFailures"`
+}
+
+// Harness runs the hermes handler with in-memory services: the templates and attachments are read from Storage, the messages are sent
+// through Transport, the scheduled messages are enqueued to Queue, the idempotency keys are kept in SentKeys, and the invalid and failed
+// messages are written to Rejected and Failed. The configuration always reports batch item failures, so the partial batch response
+// tells which messages the queue would deliver again.
+type Harness struct {
+	// Storage holds the templates and attachments, and receives the archived and previewed messages written through the options.
+	Storage *storage.Memory
+	// Transport records the sent messages.
+	Transport *transport.Fake
+	// Queue records the messages scheduled later, as SQS_QUEUE would receive them.
+	Queue *Queue
+	// SentKeys remembers the idempotency keys of the sent messages, as IDEMPOTENCY_TABLE does.
+	SentKeys *idempotency.Memory
+	// Rejected receives the invalid messages, as INVALID_MESSAGES_QUEUE does.
+	Rejected *DeadLetters
+	// Failed receives the messages which failed their last attempt, as FAILED_MESSAGES_BUCKET does.
+	Failed *DeadLetters
+	// Handler is the handler of the lambda, running the whole pipeline.
+	Handler *handler.Handler
+	// Mailer is the mailer of the handler, its Options holding the behaviours of the configuration.
+	Mailer   *mailer.Mailer
+	outcomes *outcomeWriter
+	mu       sync.Mutex
+	messages int
+}
+
+// HandleRequest handles an event as the lambda does, an SQS event, or a single message given as is which is wrapped in one.
+// It returns the partial batch response of the event, an error being returned only when the event can't be handled.
+func (harness *Harness) HandleRequest(ctx context.Context, payload json.RawMessage) (*BatchResponse, error) {
+	var event events.SQSEvent
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return nil, fmt.Errorf("unable to unmarshal event: %s", err.Error())
+	}
+	if len(event.Records) == 0 {
+		harness.mu.Lock()
+		harness.messages++
+		messageID := fmt.Sprintf("message-%d", harness.messages)
+		harness.mu.Unlock()
+
+		event.Records = []events.SQSMessage{{
+			MessageId:   messageID,
+			Body:        string(payload),
+			EventSource: "aws:sqs",
+			Attributes:  map[string]string{"SentTimestamp": fmt.Sprintf("%d", time.Now().UnixNano()/int64(time.Millisecond))},
+		}}
+		wrapped, err := json.Marshal(event)
+		if err != nil {
+			return nil, fmt.Errorf("unable to marshal SQS event: %s", err.Error())
+		}
+		payload = wrapped
+	}
+
+	response, err := harness.Handler.HandleRequest(ctx, payload)
+	if err != nil {
+		return nil, err
+	}
+	batchResponse := &BatchResponse{BatchItemFailures: []BatchItemFailure{}}
+	if response == nil {
+		return batchResponse, nil
+	}
+	encoded, err := json.Marshal(response)
+	if err != nil {
+		return nil, fmt.Errorf("unable to marshal batch response: %s", err.Error())
+	}
+	if err := json.Unmarshal(encoded, batchResponse); err != nil {
+		return nil, fmt.Errorf("unable to unmarshal batch response: %s", err.Error())
+	}
+
+	return batchResponse, nil
+}
+
+// Outcomes returns the outcomes of the handled messages, in the order they were handled, as the lambda writes them to its results destinations.
+func (harness *Harness) Outcomes() []results.Outcome {
+	return harness.outcomes.list()
+}
+
+// Reset forgets the outcomes, the sent, scheduled and dead letter messages, keeping the stored templates and attachments and the idempotency keys,
+// so a message delivered again is still skipped.
+func (harness *Harness) Reset() {
+	harness.outcomes.reset()
+	harness.Transport.Reset()
+	harness.Queue.Reset()
+	harness.Rejected.Reset()
+	harness.Failed.Reset()
+}
+
+// New instanciates a Harness running the handler of the configuration, ie: read by handler.ParseConfig, with empty in-memory services.
+// Batch item failures are always reported, and the AWS settings the services replace are given placeholder values when missing.
+func New(cfg handler.Config) (*Harness, error) {
+	cfg.BatchFailures = true
+	if cfg.AWSRegion == "" {
+		cfg.AWSRegion = defaultRegion
+	}
+	if cfg.QueueURL == "" {
+		cfg.QueueURL = defaultQueueURL
+	}
+	if cfg.TemplateSource == "" {
+		cfg.TemplateSource = "s3"
+	}
+	if cfg.TemplateBucket == "" {
+		cfg.TemplateBucket = defaultBucket
+	}
+	if cfg.MailTransport == "" {
+		cfg.MailTransport = "smtp"
+	}
+	if cfg.SMTPHost == "" {
+		cfg.SMTPHost = defaultSMTPHost
+	}
+	if cfg.IdempotencyTTL <= 0 {
+		cfg.IdempotencyTTL = defaultKeysTTL
+	}
+
+	harness := &Harness{
+		Storage:   storage.NewMemory(),
+		Transport: transport.NewFake(),
+		Queue:     NewQueue(),
+		SentKeys:  idempotency.NewMemory(cfg.IdempotencyTTL),
+		Rejected:  NewDeadLetters(),
+		Failed:    NewDeadLetters(),
+		outcomes:  &outcomeWriter{},
+	}
+	var err error
+	harness.Handler, err = handler.New(cfg, handler.Services{
+		Templates:   harness.Storage,
+		Attachments: harness.Storage,
+		Transport:   harness.Transport,
+		Queue:       harness.Queue,
+		SentKeys:    harness.SentKeys,
+		Rejected:    harness.Rejected,
+		Failed:      harness.Failed,
+		Results:     harness.outcomes,
+	})
+	if err != nil {
+		return nil, err
+	}
+	harness.Mailer = harness.Handler.Mailer()
+
+	return harness, nil
+}
@@ -0,0 +1,366 @@
+package hermestest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/forsam-education/hermes/handler"
+	"github.com/forsam-education/hermes/ratelimit"
+	"github.com/forsam-education/hermes/results"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"testing"
+	"time"
+)
+
+func newTestHarness(t *testing.T) *Harness {
+	t.Helper()
+
+	cfg, err := handler.ParseConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	harness, err := New(cfg)
+	if err != nil {
+		t.Fatalf("unable to instantiate harness: %s", err.Error())
+	}
+	harness.Storage.Set("welcome.html.template", []byte("<p>Hello {{.name}}</p>"))
+	harness.Storage.Set("welcome.txt.template", []byte("Hello {{.name}}"))
+
+	return harness
+}
+
+func welcomeMessage(fields map[string]interface{}) json.RawMessage {
+	message := map[string]interface{}{
+		"from_address":     "noreply@example.com",
+		"from_name":        "Example",
+		"reply_to":         "support@example.com",
+		"to":               []string{"jane@example.org"},
+		"subject":          "Welcome",
+		"template_name":    "welcome",
+		"template_context": map[string]interface{}{"name": "Jane"},
+	}
+	for name, value := range fields {
+		message[name] = value
+	}
+	body, _ := json.Marshal(message)
+
+	return body
+}
+
+func sqsEvent(bodies ...json.RawMessage) json.RawMessage {
+	var records []map[string]interface{}
+	for i, body := range bodies {
+		records = append(records, map[string]interface{}{
+			"messageId":   fmt.Sprintf("record-%d", i),
+			"body":        string(body),
+			"eventSource": "aws:sqs",
+		})
+	}
+	event, _ := json.Marshal(map[string]interface{}{"Records": records})
+
+	return event
+}
+
+func TestHarnessHandleRequest(t *testing.T) {
+	tests := []struct {
+		name      string
+		prepare   func(harness *Harness)
+		payloads  []json.RawMessage
+		statuses  []string
+		failures  int
+		sent      int
+		scheduled int
+		rejected  int
+		failed    int
+	}{
+		{
+			name:     "sends a templated message",
+			payloads: []json.RawMessage{welcomeMessage(nil)},
+			statuses: []string{results.StatusSent},
+			sent:     1,
+		},
+		{
+			name: "skips a message already sent with the same idempotency key",
+			payloads: []json.RawMessage{
+				welcomeMessage(map[string]interface{}{"idempotency_key": "welcome-jane"}),
+				welcomeMessage(map[string]interface{}{"idempotency_key": "welcome-jane"}),
+			},
+			statuses: []string{results.StatusSent, results.StatusSkipped},
+			sent:     1,
+		},
+		{
+			name:     "rejects an invalid message without retrying it",
+			payloads: []json.RawMessage{json.RawMessage(`{"template_name": "welcome", "to": "not an array"}`)},
+			statuses: []string{results.StatusFailed},
+			rejected: 1,
+		},
+		{
+			name:      "schedules a message due later",
+			payloads:  []json.RawMessage{welcomeMessage(map[string]interface{}{"send_at": time.Now().Add(time.Hour).Format(time.RFC3339)})},
+			statuses:  []string{results.StatusScheduled},
+			scheduled: 1,
+		},
+		{
+			name: "retries a failed send and archives it after its last attempt",
+			prepare: func(harness *Harness) {
+				rejection := &textproto.Error{Code: 550, Msg: "5.1.1 mailbox unavailable"}
+				harness.Transport.FailSends(rejection, rejection, rejection)
+			},
+			payloads: []json.RawMessage{welcomeMessage(nil)},
+			statuses: []string{results.StatusFailed},
+			failures: 1,
+			failed:   1,
+		},
+		{
+			name: "sends a message once its transient failure is retried",
+			prepare: func(harness *Harness) {
+				harness.Transport.FailSends(&textproto.Error{Code: 451, Msg: "4.3.0 try again later"})
+			},
+			payloads: []json.RawMessage{welcomeMessage(nil)},
+			statuses: []string{results.StatusSent},
+			sent:     1,
+		},
+		{
+			name: "sends a copy of a fan-out message to each entry",
+			payloads: []json.RawMessage{welcomeMessage(map[string]interface{}{
+				"to": nil,
+				"fan_out": []map[string]interface{}{
+					{"to": []string{"jane@example.org"}, "context": map[string]interface{}{"name": "Jane"}},
+					{"to": []string{"john@example.org"}, "context": map[string]interface{}{"name": "John"}},
+				},
+			})},
+			statuses: []string{results.StatusSent},
+			sent:     2,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			harness := newTestHarness(t)
+			if test.prepare != nil {
+				test.prepare(harness)
+			}
+
+			var failures int
+			for _, payload := range test.payloads {
+				response, err := harness.HandleRequest(context.Background(), payload)
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err.Error())
+				}
+				failures += len(response.BatchItemFailures)
+			}
+
+			outcomes := harness.Outcomes()
+			if len(outcomes) != len(test.statuses) {
+				t.Fatalf("expected %d outcomes, got %+v", len(test.statuses), outcomes)
+			}
+			for i, status := range test.statuses {
+				if outcomes[i].Status != status {
+					t.Errorf("expected outcome %d to be %s, got %+v", i, status, outcomes[i])
+				}
+			}
+			if failures != test.failures {
+				t.Errorf("expected %d batch item failures, got %d", test.failures, failures)
+			}
+			if sent := len(harness.Transport.Messages()); sent != test.sent {
+				t.Errorf("expected %d sent messages, got %d", test.sent, sent)
+			}
+			if scheduled := len(harness.Queue.Messages()); scheduled != test.scheduled {
+				t.Errorf("expected %d scheduled messages, got %d", test.scheduled, scheduled)
+			}
+			if rejected := len(harness.Rejected.Letters()); rejected != test.rejected {
+				t.Errorf("expected %d rejected messages, got %d", test.rejected, rejected)
+			}
+			if failed := len(harness.Failed.Letters()); failed != test.failed {
+				t.Errorf("expected %d failed messages, got %d", test.failed, failed)
+			}
+		})
+	}
+}
+
+func TestHarnessSQSEvent(t *testing.T) {
+	harness := newTestHarness(t)
+	harness.Transport.FailSends(&textproto.Error{Code: 550, Msg: "5.1.1 mailbox unavailable"}, &textproto.Error{Code: 550, Msg: "5.1.1 mailbox unavailable"},
+		&textproto.Error{Code: 550, Msg: "5.1.1 mailbox unavailable"})
+
+	response, err := harness.HandleRequest(context.Background(), sqsEvent(welcomeMessage(map[string]interface{}{"to": []string{"bob@example.org"}})))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if len(response.BatchItemFailures) != 1 || response.BatchItemFailures[0].ItemIdentifier != "record-0" {
+		t.Fatalf("expected record-0 to be retried, got %+v", response.BatchItemFailures)
+	}
+
+	// The record delivered again is sent, its idempotency key having been released by the failure.
+	harness.Reset()
+	response, err = harness.HandleRequest(context.Background(), sqsEvent(welcomeMessage(map[string]interface{}{"to": []string{"bob@example.org"}})))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if len(response.BatchItemFailures) != 0 {
+		t.Fatalf("expected no batch item failure, got %+v", response.BatchItemFailures)
+	}
+	messages := harness.Transport.Messages()
+	if len(messages) != 1 || messages[0].Recipients[0] != "bob@example.org" {
+		t.Fatalf("expected a message sent to bob@example.org, got %+v", messages)
+	}
+	parsed, err := messages[0].Parse()
+	if err != nil {
+		t.Fatalf("unable to parse sent message: %s", err.Error())
+	}
+	if subject := parsed.Header.Get("Subject"); subject != "Welcome" {
+		t.Errorf("expected subject Welcome, got %q", subject)
+	}
+	if keys := harness.SentKeys.Sent(); len(keys) != 1 || keys[0] != "record-0" {
+		t.Errorf("expected record-0 to be recorded as sent, got %v", keys)
+	}
+}
+
+func TestHarnessTemplateRateLimits(t *testing.T) {
+	cfg, err := handler.ParseConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.TemplateRates = ratelimit.Rates{"welcome": 0.01}
+	cfg.RateLimitMaxWait = 10 * time.Millisecond
+	harness, err := New(cfg)
+	if err != nil {
+		t.Fatalf("unable to instantiate harness: %s", err.Error())
+	}
+	for _, name := range []string{"welcome", "digest"} {
+		harness.Storage.Set(name+".html.template", []byte("<p>Hello {{.name}}</p>"))
+		harness.Storage.Set(name+".txt.template", []byte("Hello {{.name}}"))
+	}
+
+	response, err := harness.HandleRequest(context.Background(), sqsEvent(
+		welcomeMessage(nil),
+		welcomeMessage(map[string]interface{}{"to": []string{"john@example.org"}}),
+		welcomeMessage(map[string]interface{}{"template_name": "digest"}),
+	))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	// The second welcome message can't be sent before the rate limit wait, so it is retried later, while the digest one is not throttled.
+	if len(response.BatchItemFailures) != 1 {
+		t.Fatalf("expected a throttled record to be retried, got %+v", response.BatchItemFailures)
+	}
+	if len(harness.Transport.Messages()) != 2 {
+		t.Errorf("expected a welcome and a digest message to be sent, got %d messages", len(harness.Transport.Messages()))
+	}
+}
+
+func TestHarnessTraces(t *testing.T) {
+	type exportedSpan struct {
+		TraceID      string `json:"traceId"`
+		SpanID       string `json:"spanId"`
+		ParentSpanID string `json:"parentSpanId"`
+		Name         string `json:"name"`
+	}
+	var spans []exportedSpan
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			ResourceSpans []struct {
+				ScopeSpans []struct {
+					Spans []exportedSpan `json:"spans"`
+				} `json:"scopeSpans"`
+			} `json:"resourceSpans"`
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &request); err != nil {
+			t.Errorf("expected an OTLP JSON body, got %q", body)
+		}
+		for _, resource := range request.ResourceSpans {
+			for _, scope := range resource.ScopeSpans {
+				spans = append(spans, scope.Spans...)
+			}
+		}
+	}))
+	defer collector.Close()
+
+	cfg, err := handler.ParseConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.OTLPEndpoint = collector.URL
+	harness, err := New(cfg)
+	if err != nil {
+		t.Fatalf("unable to instantiate harness: %s", err.Error())
+	}
+	harness.Storage.Set("welcome.html.template", []byte("<p>Hello {{.name}}</p>"))
+	harness.Storage.Set("welcome.txt.template", []byte("Hello {{.name}}"))
+
+	if _, err := harness.HandleRequest(context.Background(), sqsEvent(welcomeMessage(nil))); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	// The phases of the record are traced under the span of the invocation.
+	byName := make(map[string]exportedSpan)
+	var root exportedSpan
+	for _, span := range spans {
+		byName[span.Name] = span
+		if span.ParentSpanID == "" {
+			root = span
+		}
+	}
+	if root.Name != "HandleRequest" {
+		t.Fatalf("expected the HandleRequest root span, got %+v", spans)
+	}
+	for _, name := range []string{"decode", "render", "send"} {
+		span, ok := byName[name]
+		if !ok {
+			t.Errorf("expected a %s span, got %+v", name, spans)
+			continue
+		}
+		if span.TraceID != root.TraceID || span.ParentSpanID == "" {
+			t.Errorf("expected the %s span to be part of the invocation trace %s, got %+v", name, root.TraceID, span)
+		}
+	}
+}
+
+func TestHarnessPriorityOrdering(t *testing.T) {
+	cfg, err := handler.ParseConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.PriorityOrder = true
+	harness, err := New(cfg)
+	if err != nil {
+		t.Fatalf("unable to instantiate harness: %s", err.Error())
+	}
+	harness.Storage.Set("welcome.html.template", []byte("<p>Hello {{.name}}</p>"))
+	harness.Storage.Set("welcome.txt.template", []byte("Hello {{.name}}"))
+
+	priorities := map[string]int{"low@example.org": -1, "default@example.org": 0, "urgent@example.org": 10, "high@example.org": 1, "later@example.org": -1}
+	var bodies []json.RawMessage
+	for _, recipient := range []string{"low@example.org", "default@example.org", "urgent@example.org", "high@example.org", "later@example.org"} {
+		fields := map[string]interface{}{"to": []string{recipient}}
+		switch priorities[recipient] {
+		case -1:
+			fields["priority"] = "low"
+		case 1:
+			fields["priority"] = "high"
+		case 10:
+			fields["priority"] = 10
+		}
+		bodies = append(bodies, welcomeMessage(fields))
+	}
+	if _, err := harness.HandleRequest(context.Background(), sqsEvent(bodies...)); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	messages := harness.Transport.Messages()
+	if len(messages) != len(bodies) {
+		t.Fatalf("expected %d sent messages, got %d", len(bodies), len(messages))
+	}
+	var order []string
+	for i, message := range messages {
+		order = append(order, message.Recipients[0])
+		if i > 0 && priorities[message.Recipients[0]] > priorities[messages[i-1].Recipients[0]] {
+			t.Errorf("expected the messages to be sent by decreasing priority, got %v", order)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package hermestest
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	"github.com/forsam-education/hermes/results"
+	"sync"
+	"time"
+)
+
+// QueuedMessage is a message enqueued by the handler, ie: a message scheduled later, with the delay it was given.
+type QueuedMessage struct {
+	QueueURL string
+	Body     string
+	Delay    time.Duration
+}
+
+// Queue records the messages the handler enqueues instead of sending them to SQS. Only SendMessage is implemented,
+// the other methods of the SQS API panicking. It implements the sqsiface.SQSAPI interface.
+type Queue struct {
+	sqsiface.SQSAPI
+	mu       sync.Mutex
+	messages []QueuedMessage
+}
+
+// SendMessage records the message.
+func (queue *Queue) SendMessage(input *sqs.SendMessageInput) (*sqs.SendMessageOutput, error) {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+
+	queue.messages = append(queue.messages, QueuedMessage{
+		QueueURL: aws.StringValue(input.QueueUrl),
+		Body:     aws.StringValue(input.MessageBody),
+		Delay:    time.Duration(aws.Int64Value(input.DelaySeconds)) * time.Second,
+	})
+
+	return &sqs.SendMessageOutput{MessageId: aws.String(fmt.Sprintf("queued-%d", len(queue.messages)))}, nil
+}
+
+// Messages returns the enqueued messages, in the order they were enqueued.
+func (queue *Queue) Messages() []QueuedMessage {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+
+	return append([]QueuedMessage(nil), queue.messages...)
+}
+
+// Reset forgets the enqueued messages.
+func (queue *Queue) Reset() {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+
+	queue.messages = nil
+}
+
+// NewQueue instanciates an empty Queue.
+func NewQueue() *Queue {
+	return &Queue{}
+}
+
+// DeadLetter is a message written to a dead letter destination, with the error which made it fail.
+type DeadLetter struct {
+	MessageID string
+	Body      string
+	Err       error
+}
+
+// DeadLetters records the messages the handler moves to a dead letter destination. It implements the deadletter.Writer interface.
+type DeadLetters struct {
+	mu      sync.Mutex
+	letters []DeadLetter
+}
+
+// Write records the message.
+func (deadLetters *DeadLetters) Write(messageID string, body string, cause error) error {
+	deadLetters.mu.Lock()
+	defer deadLetters.mu.Unlock()
+
+	deadLetters.letters = append(deadLetters.letters, DeadLetter{MessageID: messageID, Body: body, Err: cause})
+
+	return nil
+}
+
+// Letters returns the written messages, in the order they were written.
+func (deadLetters *DeadLetters) Letters() []DeadLetter {
+	deadLetters.mu.Lock()
+	defer deadLetters.mu.Unlock()
+
+	return append([]DeadLetter(nil), deadLetters.letters...)
+}
+
+// Reset forgets the written messages.
+func (deadLetters *DeadLetters) Reset() {
+	deadLetters.mu.Lock()
+	defer deadLetters.mu.Unlock()
+
+	deadLetters.letters = nil
+}
+
+// NewDeadLetters instanciates an empty DeadLetters.
+func NewDeadLetters() *DeadLetters {
+	return &DeadLetters{}
+}
+
+// outcomeWriter records the outcomes the handler writes to its results destinations. It implements the results.Writer interface.
+type outcomeWriter struct {
+	mu       sync.Mutex
+	outcomes []results.Outcome
+}
+
+func (writer *outcomeWriter) Write(outcomes []results.Outcome) error {
+	writer.mu.Lock()
+	defer writer.mu.Unlock()
+
+	writer.outcomes = append(writer.outcomes, outcomes...)
+
+	return nil
+}
+
+func (writer *outcomeWriter) list() []results.Outcome {
+	writer.mu.Lock()
+	defer writer.mu.Unlock()
+
+	return append([]results.Outcome(nil), writer.outcomes...)
+}
+
+func (writer *outcomeWriter) reset() {
+	writer.mu.Lock()
+	defer writer.mu.Unlock()
+
+	writer.outcomes = nil
+}
@@ -0,0 +1,101 @@
+package idempotency
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"strconv"
+	"time"
+)
+
+// claimLease is how long a claim holds before another attempt may take it over, ie: when the lambda timed out while sending.
+// It matches the maximum lambda duration.
+const claimLease = 15 * time.Minute
+
+// Statuses of a recorded key.
+const (
+	statusSending = "sending"
+	statusSent    = "sent"
+)
+
+// DynamoDB records the sent message keys in a DynamoDB table whose partition key is the "id" string attribute. It implements the Store interface.
+// The "expires_at" number attribute holds the expiry as a Unix time, so it can be set as the table TTL attribute.
+type DynamoDB struct {
+	table          string
+	ttl            time.Duration
+	dynamoDBClient dynamodbiface.DynamoDBAPI
+}
+
+// Claim records the key as being sent, unless it is already recorded and not expired.
+func (dynamoDBStore *DynamoDB) Claim(key string, now time.Time) (bool, error) {
+	_, err := dynamoDBStore.dynamoDBClient.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(dynamoDBStore.table),
+		Item: map[string]*dynamodb.AttributeValue{
+			"id":         {S: aws.String(key)},
+			"status":     {S: aws.String(statusSending)},
+			"expires_at": {N: aws.String(strconv.FormatInt(now.Add(claimLease).Unix(), 10))},
+		},
+		// DynamoDB deletes the expired items lazily, so they are overwritten as if they were absent.
+		ConditionExpression:       aws.String("attribute_not_exists(id) OR expires_at < :now"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":now": {N: aws.String(strconv.FormatInt(now.Unix(), 10))}},
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("unable to claim key %q in table %q: %s", key, dynamoDBStore.table, err.Error())
+	}
+
+	return true, nil
+}
+
+// Complete records the key as sent until the TTL is elapsed.
+func (dynamoDBStore *DynamoDB) Complete(key string, now time.Time) error {
+	_, err := dynamoDBStore.dynamoDBClient.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName:                aws.String(dynamoDBStore.table),
+		Key:                      map[string]*dynamodb.AttributeValue{"id": {S: aws.String(key)}},
+		UpdateExpression:         aws.String("SET #status = :sent, expires_at = :expiry"),
+		ExpressionAttributeNames: map[string]*string{"#status": aws.String("status")},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":sent":   {S: aws.String(statusSent)},
+			":expiry": {N: aws.String(strconv.FormatInt(now.Add(dynamoDBStore.ttl).Unix(), 10))},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("unable to record key %q as sent in table %q: %s", key, dynamoDBStore.table, err.Error())
+	}
+
+	return nil
+}
+
+// Release deletes the claim of the key, unless it was recorded as sent in the meantime.
+func (dynamoDBStore *DynamoDB) Release(key string) error {
+	_, err := dynamoDBStore.dynamoDBClient.DeleteItem(&dynamodb.DeleteItemInput{
+		TableName:                 aws.String(dynamoDBStore.table),
+		Key:                       map[string]*dynamodb.AttributeValue{"id": {S: aws.String(key)}},
+		ConditionExpression:       aws.String("#status = :sending"),
+		ExpressionAttributeNames:  map[string]*string{"#status": aws.String("status")},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":sending": {S: aws.String(statusSending)}},
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("unable to release key %q in table %q: %s", key, dynamoDBStore.table, err.Error())
+	}
+
+	return nil
+}
+
+// NewDynamoDB instanciates a DynamoDB store using the given table, keeping the sent keys for the given TTL.
+func NewDynamoDB(table string, ttl time.Duration, region string) (*DynamoDB, error) {
+	sess, err := session.NewSession(&aws.Config{Region: aws.String(region)})
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to AWS: %s", err.Error())
+	}
+
+	return &DynamoDB{table: table, ttl: ttl, dynamoDBClient: dynamodb.New(sess)}, nil
+}
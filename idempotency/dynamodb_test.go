@@ -0,0 +1,147 @@
+package idempotency
+
+import (
+	"errors"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeDynamoDB is a table evaluating the conditions of the DynamoDB store, failing every call with err when set.
+// The other methods of the DynamoDB API panic.
+type fakeDynamoDB struct {
+	dynamodbiface.DynamoDBAPI
+	items map[string]map[string]*dynamodb.AttributeValue
+	err   error
+}
+
+func newFakeDynamoDB() *fakeDynamoDB {
+	return &fakeDynamoDB{items: make(map[string]map[string]*dynamodb.AttributeValue)}
+}
+
+func conditionFailed() error {
+	return awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", nil)
+}
+
+func numberOf(value *dynamodb.AttributeValue) int64 {
+	number, _ := strconv.ParseInt(aws.StringValue(value.N), 10, 64)
+
+	return number
+}
+
+func (table *fakeDynamoDB) PutItem(input *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+	if table.err != nil {
+		return nil, table.err
+	}
+	if aws.StringValue(input.ConditionExpression) != "attribute_not_exists(id) OR expires_at < :now" {
+		return nil, errors.New("unexpected condition " + aws.StringValue(input.ConditionExpression))
+	}
+	key := aws.StringValue(input.Item["id"].S)
+	if item, ok := table.items[key]; ok && numberOf(item["expires_at"]) >= numberOf(input.ExpressionAttributeValues[":now"]) {
+		return nil, conditionFailed()
+	}
+	table.items[key] = input.Item
+
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func (table *fakeDynamoDB) UpdateItem(input *dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+	if table.err != nil {
+		return nil, table.err
+	}
+	key := aws.StringValue(input.Key["id"].S)
+	table.items[key] = map[string]*dynamodb.AttributeValue{
+		"id":         {S: aws.String(key)},
+		"status":     input.ExpressionAttributeValues[":sent"],
+		"expires_at": input.ExpressionAttributeValues[":expiry"],
+	}
+
+	return &dynamodb.UpdateItemOutput{}, nil
+}
+
+func (table *fakeDynamoDB) DeleteItem(input *dynamodb.DeleteItemInput) (*dynamodb.DeleteItemOutput, error) {
+	if table.err != nil {
+		return nil, table.err
+	}
+	key := aws.StringValue(input.Key["id"].S)
+	item, ok := table.items[key]
+	if !ok || aws.StringValue(item["status"].S) != aws.StringValue(input.ExpressionAttributeValues[":sending"].S) {
+		return nil, conditionFailed()
+	}
+	delete(table.items, key)
+
+	return &dynamodb.DeleteItemOutput{}, nil
+}
+
+func TestDynamoDBClaims(t *testing.T) {
+	now := time.Date(2020, 10, 26, 12, 0, 0, 0, time.UTC)
+	table := newFakeDynamoDB()
+	store := &DynamoDB{table: "hermes-sent", ttl: 24 * time.Hour, dynamoDBClient: table}
+
+	if claimed, err := store.Claim("message-1", now); err != nil || !claimed {
+		t.Fatalf("expected the first claim to succeed, got %t, %v", claimed, err)
+	}
+	if item := table.items["message-1"]; aws.StringValue(item["status"].S) != statusSending || numberOf(item["expires_at"]) != now.Add(claimLease).Unix() {
+		t.Errorf("expected the key to be claimed for the lease, got %v", item)
+	}
+	if claimed, err := store.Claim("message-1", now.Add(time.Minute)); err != nil || claimed {
+		t.Errorf("expected a key being sent not to be claimed again, got %t, %v", claimed, err)
+	}
+
+	if err := store.Complete("message-1", now.Add(time.Minute)); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if item := table.items["message-1"]; aws.StringValue(item["status"].S) != statusSent || numberOf(item["expires_at"]) != now.Add(time.Minute+24*time.Hour).Unix() {
+		t.Errorf("expected the key to be recorded as sent for the TTL, got %v", item)
+	}
+	if claimed, _ := store.Claim("message-1", now.Add(claimLease+time.Hour)); claimed {
+		t.Errorf("expected a sent key not to be claimed again")
+	}
+	if err := store.Release("message-1"); err != nil || table.items["message-1"] == nil {
+		t.Errorf("expected a sent key to be kept when released, got %v", err)
+	}
+	if claimed, _ := store.Claim("message-1", now.Add(25*time.Hour)); !claimed {
+		t.Errorf("expected a sent key to be claimed once its TTL is elapsed, as DynamoDB deletes the expired items lazily")
+	}
+}
+
+func TestDynamoDBRelease(t *testing.T) {
+	now := time.Date(2020, 10, 26, 12, 0, 0, 0, time.UTC)
+	table := newFakeDynamoDB()
+	store := &DynamoDB{table: "hermes-sent", ttl: 24 * time.Hour, dynamoDBClient: table}
+
+	if claimed, _ := store.Claim("message-2", now); !claimed {
+		t.Fatalf("expected the first claim to succeed")
+	}
+	// A failed send releases its claim, so the retry can send the message.
+	if err := store.Release("message-2"); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if claimed, _ := store.Claim("message-2", now.Add(time.Second)); !claimed {
+		t.Errorf("expected a released key to be claimed again")
+	}
+	if err := store.Release("unknown"); err != nil {
+		t.Errorf("expected the release of an unknown key to succeed, got %s", err.Error())
+	}
+}
+
+func TestDynamoDBErrors(t *testing.T) {
+	table := newFakeDynamoDB()
+	table.err = awserr.New(dynamodb.ErrCodeProvisionedThroughputExceededException, "throughput exceeded", nil)
+	store := &DynamoDB{table: "hermes-sent", ttl: time.Hour, dynamoDBClient: table}
+
+	if claimed, err := store.Claim("message-3", time.Now()); claimed || err == nil || !strings.HasPrefix(err.Error(), `unable to claim key "message-3" in table "hermes-sent"`) {
+		t.Errorf("expected the claim to fail, got %t, %v", claimed, err)
+	}
+	if err := store.Complete("message-3", time.Now()); err == nil || !strings.HasPrefix(err.Error(), `unable to record key "message-3" as sent in table "hermes-sent"`) {
+		t.Errorf("expected the completion to fail, got %v", err)
+	}
+	if err := store.Release("message-3"); err == nil || !strings.HasPrefix(err.Error(), `unable to release key "message-3" in table "hermes-sent"`) {
+		t.Errorf("expected the release to fail, got %v", err)
+	}
+}
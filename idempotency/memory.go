@@ -0,0 +1,77 @@
+package idempotency
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// memoryKey is a key recorded by a Memory store, with its status and expiry.
+type memoryKey struct {
+	status    string
+	expiresAt time.Time
+}
+
+// Memory records the sent message keys in memory, with the same claims as the DynamoDB store, ie: for integration tests.
+// The keys are only shared by the handlers given the same store. It implements the Store interface.
+type Memory struct {
+	mu   sync.Mutex
+	ttl  time.Duration
+	keys map[string]memoryKey
+}
+
+// Claim records the key as being sent, unless it is already recorded and not expired.
+func (memory *Memory) Claim(key string, now time.Time) (bool, error) {
+	memory.mu.Lock()
+	defer memory.mu.Unlock()
+
+	if recorded, ok := memory.keys[key]; ok && !recorded.expiresAt.Before(now) {
+		return false, nil
+	}
+	memory.keys[key] = memoryKey{status: statusSending, expiresAt: now.Add(claimLease)}
+
+	return true, nil
+}
+
+// Complete records the key as sent until the TTL is elapsed.
+func (memory *Memory) Complete(key string, now time.Time) error {
+	memory.mu.Lock()
+	defer memory.mu.Unlock()
+
+	memory.keys[key] = memoryKey{status: statusSent, expiresAt: now.Add(memory.ttl)}
+
+	return nil
+}
+
+// Release deletes the claim of the key, unless it was recorded as sent in the meantime.
+func (memory *Memory) Release(key string) error {
+	memory.mu.Lock()
+	defer memory.mu.Unlock()
+
+	if memory.keys[key].status == statusSending {
+		delete(memory.keys, key)
+	}
+
+	return nil
+}
+
+// Sent returns the sorted keys recorded as sent.
+func (memory *Memory) Sent() []string {
+	memory.mu.Lock()
+	defer memory.mu.Unlock()
+
+	var keys []string
+	for key, recorded := range memory.keys {
+		if recorded.status == statusSent {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// NewMemory instanciates an empty Memory store, keeping the sent keys for the given TTL.
+func NewMemory(ttl time.Duration) *Memory {
+	return &Memory{ttl: ttl, keys: make(map[string]memoryKey)}
+}
@@ -0,0 +1,57 @@
+package idempotency
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMemoryClaims(t *testing.T) {
+	now := time.Date(2020, 10, 26, 12, 0, 0, 0, time.UTC)
+	memory := NewMemory(24 * time.Hour)
+
+	if claimed, err := memory.Claim("message-1", now); err != nil || !claimed {
+		t.Fatalf("expected the first claim to succeed, got %t, %v", claimed, err)
+	}
+	if claimed, _ := memory.Claim("message-1", now.Add(time.Minute)); claimed {
+		t.Errorf("expected a key being sent not to be claimed again")
+	}
+	if claimed, _ := memory.Claim("message-1", now.Add(claimLease+time.Second)); !claimed {
+		t.Errorf("expected an expired claim to be taken over")
+	}
+
+	if err := memory.Complete("message-1", now.Add(claimLease+time.Minute)); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if claimed, _ := memory.Claim("message-1", now.Add(claimLease+time.Hour)); claimed {
+		t.Errorf("expected a sent key not to be claimed again")
+	}
+	if err := memory.Release("message-1"); err != nil || !reflect.DeepEqual(memory.Sent(), []string{"message-1"}) {
+		t.Errorf("expected a sent key to be kept when released, got %v, %v", memory.Sent(), err)
+	}
+	if claimed, _ := memory.Claim("message-1", now.Add(claimLease+25*time.Hour)); !claimed {
+		t.Errorf("expected a sent key to be claimed once its TTL is elapsed")
+	}
+}
+
+func TestMemoryRelease(t *testing.T) {
+	now := time.Date(2020, 10, 26, 12, 0, 0, 0, time.UTC)
+	memory := NewMemory(24 * time.Hour)
+
+	if claimed, _ := memory.Claim("message-2", now); !claimed {
+		t.Fatalf("expected the first claim to succeed")
+	}
+	// A failed send releases its claim, so the retry can send the message.
+	if err := memory.Release("message-2"); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if claimed, _ := memory.Claim("message-2", now.Add(time.Second)); !claimed {
+		t.Errorf("expected a released key to be claimed again")
+	}
+	if err := memory.Release("unknown"); err != nil {
+		t.Errorf("expected the release of an unknown key to succeed, got %s", err.Error())
+	}
+	if sent := memory.Sent(); len(sent) != 0 {
+		t.Errorf("expected no sent key, got %v", sent)
+	}
+}
@@ -0,0 +1,13 @@
+package idempotency
+
+import "time"
+
+// Store interface should be implemented by any service remembering the sent messages across invocations (DynamoDB, Redis... etc).
+type Store interface {
+	// Claim should reserve the key for a send, or return false if a message with this key was already sent or is being sent by another attempt.
+	Claim(key string, now time.Time) (bool, error)
+	// Complete should record the key as sent, so later messages having it are skipped until it expires.
+	Complete(key string, now time.Time) error
+	// Release should drop the claim of a failed send, so the message can be retried.
+	Release(key string) error
+}
@@ -0,0 +1,139 @@
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level is the severity of a log entry.
+type Level int
+
+// Levels of the log entries, from the most verbose.
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (level Level) String() string {
+	if level < LevelDebug || level > LevelError {
+		return fmt.Sprintf("level(%d)", int(level))
+	}
+
+	return levelNames[level]
+}
+
+// UnmarshalText parses the level from its name, so it can be read from an environment variable.
+func (level *Level) UnmarshalText(text []byte) error {
+	for i, name := range levelNames {
+		if strings.EqualFold(string(text), name) {
+			*level = Level(i)
+			return nil
+		}
+	}
+
+	return fmt.Errorf("log level %q is unknown, expecting one of %s", text, strings.Join(levelNames, ", "))
+}
+
+// Fields are the named values of a log entry, ie: the message id and template name.
+type Fields map[string]interface{}
+
+// Logger writes the entries above its level, either as JSON lines searchable in CloudWatch Logs, or as plain text lines.
+// A nil Logger writes through the standard logger.
+type Logger struct {
+	level  Level
+	json   bool
+	mu     sync.Mutex
+	writer io.Writer
+}
+
+// NewLogger instanciates a Logger writing the entries of the level and above to the writer, as JSON lines when asJSON is set.
+func NewLogger(level Level, asJSON bool, writer io.Writer) *Logger {
+	return &Logger{level: level, json: asJSON, writer: writer}
+}
+
+// escapeLine escapes the line breaks of a text, so a message or value can't forge another log line.
+func escapeLine(text string) string {
+	return strings.NewReplacer("\r", `\r`, "\n", `\n`).Replace(text)
+}
+
+// formatText writes the fields as sorted key=value pairs after the message. The values holding spaces, quotes or line breaks are quoted,
+// so each pair can be read back.
+func formatText(level Level, message string, fields Fields) string {
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var line strings.Builder
+	line.WriteString(strings.ToUpper(level.String()) + " " + escapeLine(message))
+	for _, key := range keys {
+		value := fmt.Sprint(fields[key])
+		if value == "" || strings.ContainsAny(value, " =\"\r\n\t") {
+			value = strconv.Quote(value)
+		}
+		fmt.Fprintf(&line, " %s=%s", escapeLine(key), value)
+	}
+
+	return line.String()
+}
+
+// Log writes an entry with its fields, unless its level is below the logger one.
+func (logger *Logger) Log(level Level, message string, fields Fields) {
+	if logger == nil {
+		log.Print(formatText(level, message, fields))
+		return
+	}
+	if level < logger.level {
+		return
+	}
+
+	var line []byte
+	if logger.json {
+		entry := make(map[string]interface{}, len(fields)+3)
+		for key, value := range fields {
+			entry[key] = value
+		}
+		entry["time"] = time.Now().UTC().Format(time.RFC3339Nano)
+		entry["level"] = level.String()
+		entry["message"] = message
+		var err error
+		if line, err = json.Marshal(entry); err != nil {
+			line, _ = json.Marshal(map[string]string{"level": LevelError.String(), "message": fmt.Sprintf("unable to encode log entry %q: %s", message, err.Error())})
+		}
+	} else {
+		line = []byte(time.Now().UTC().Format("2006/01/02 15:04:05") + " " + formatText(level, message, fields))
+	}
+
+	logger.mu.Lock()
+	defer logger.mu.Unlock()
+	logger.writer.Write(append(line, '\n'))
+}
+
+// levelWriter turns each line written by the standard logger into an entry of the level.
+type levelWriter struct {
+	logger *Logger
+	level  Level
+}
+
+func (writer levelWriter) Write(line []byte) (int, error) {
+	writer.logger.Log(writer.level, strings.TrimSuffix(string(line), "\n"), nil)
+
+	return len(line), nil
+}
+
+// Writer returns an io.Writer logging each written line as an entry of the level, so the standard logger output can be redirected.
+func (logger *Logger) Writer(level Level) io.Writer {
+	return levelWriter{logger: logger, level: level}
+}
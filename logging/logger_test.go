@@ -0,0 +1,183 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestLoggerJSON(t *testing.T) {
+	tests := []struct {
+		name     string
+		level    Level
+		message  string
+		fields   Fields
+		expected map[string]interface{}
+	}{
+		{
+			name:     "writes the fields",
+			level:    LevelInfo,
+			message:  "Message sent",
+			fields:   Fields{"message_id": "42", "template": "welcome", "attempt": 2},
+			expected: map[string]interface{}{"level": "info", "message": "Message sent", "message_id": "42", "template": "welcome", "attempt": 2.0},
+		},
+		{
+			name:     "keeps the reserved keys",
+			level:    LevelWarn,
+			message:  "Message retried",
+			fields:   Fields{"level": "debug", "message": "forged", "time": "never"},
+			expected: map[string]interface{}{"level": "warn", "message": "Message retried"},
+		},
+		{
+			name:     "escapes the line breaks and quotes",
+			level:    LevelError,
+			message:  "Message failed\n{\"level\":\"info\",\"message\":\"forged\"}",
+			fields:   Fields{"error": "550 rejected\r\nINFO forged"},
+			expected: map[string]interface{}{"level": "error", "message": "Message failed\n{\"level\":\"info\",\"message\":\"forged\"}", "error": "550 rejected\r\nINFO forged"},
+		},
+		{
+			name:     "reports the fields which can't be encoded",
+			level:    LevelInfo,
+			message:  `Message "sent"`,
+			fields:   Fields{"callback": func() {}},
+			expected: map[string]interface{}{"level": "error"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var output bytes.Buffer
+			NewLogger(LevelDebug, true, &output).Log(test.level, test.message, test.fields)
+
+			lines := strings.Split(strings.TrimSuffix(output.String(), "\n"), "\n")
+			if len(lines) != 1 {
+				t.Fatalf("expected a single line, got %q", output.String())
+			}
+			var entry map[string]interface{}
+			if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil {
+				t.Fatalf("expected a JSON line, got %q: %s", lines[0], err.Error())
+			}
+			for key, value := range test.expected {
+				if entry[key] != value {
+					t.Errorf("expected %s to be %v, got %v", key, value, entry[key])
+				}
+			}
+			if _, ok := entry["time"]; !ok && test.fields["callback"] == nil {
+				t.Errorf("expected a time, got %v", entry)
+			}
+		})
+	}
+}
+
+func TestLoggerText(t *testing.T) {
+	tests := []struct {
+		name     string
+		message  string
+		fields   Fields
+		expected string
+	}{
+		{name: "sorts the fields", message: "Message sent", fields: Fields{"template": "welcome", "message_id": "42"}, expected: "INFO Message sent message_id=42 template=welcome"},
+		{name: "quotes the values with spaces", message: "Message failed", fields: Fields{"error": "550 mailbox unavailable"}, expected: `INFO Message failed error="550 mailbox unavailable"`},
+		{name: "quotes the empty values", message: "Message sent", fields: Fields{"tenant": ""}, expected: `INFO Message sent tenant=""`},
+		{name: "escapes the line breaks of the message", message: "Message failed\nERROR forged", expected: `INFO Message failed\nERROR forged`},
+		{name: "escapes the line breaks of the values", message: "Message failed", fields: Fields{"error": "rejected\r\nERROR forged"}, expected: `INFO Message failed error="rejected\r\nERROR forged"`},
+		{name: "quotes the values forging a pair", message: "Message sent", fields: Fields{"template": "welcome template=forged"}, expected: `INFO Message sent template="welcome template=forged"`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var output bytes.Buffer
+			NewLogger(LevelInfo, false, &output).Log(LevelInfo, test.message, test.fields)
+
+			line := strings.TrimSuffix(output.String(), "\n")
+			if strings.Contains(line, "\n") {
+				t.Fatalf("expected a single line, got %q", output.String())
+			}
+			// The line starts with the date and time.
+			if parts := strings.SplitN(line, " ", 3); len(parts) != 3 || parts[2] != test.expected {
+				t.Errorf("expected %q, got %q", test.expected, line)
+			}
+		})
+	}
+}
+
+func TestLoggerLevels(t *testing.T) {
+	tests := []struct {
+		loggerLevel Level
+		entryLevel  Level
+		written     bool
+	}{
+		{loggerLevel: LevelInfo, entryLevel: LevelDebug, written: false},
+		{loggerLevel: LevelInfo, entryLevel: LevelInfo, written: true},
+		{loggerLevel: LevelInfo, entryLevel: LevelError, written: true},
+		{loggerLevel: LevelError, entryLevel: LevelWarn, written: false},
+	}
+
+	for _, test := range tests {
+		var output bytes.Buffer
+		NewLogger(test.loggerLevel, true, &output).Log(test.entryLevel, "entry", nil)
+		if written := output.Len() > 0; written != test.written {
+			t.Errorf("expected a %s entry written by a %s logger to be written: %t, got %t", test.entryLevel, test.loggerLevel, test.written, written)
+		}
+	}
+}
+
+func TestLevelUnmarshalText(t *testing.T) {
+	tests := []struct {
+		text     string
+		expected Level
+		err      bool
+	}{
+		{text: "debug", expected: LevelDebug},
+		{text: "WARN", expected: LevelWarn},
+		{text: "Error", expected: LevelError},
+		{text: "verbose", err: true},
+		{text: "", err: true},
+	}
+
+	for _, test := range tests {
+		var level Level
+		err := level.UnmarshalText([]byte(test.text))
+		if test.err {
+			if err == nil {
+				t.Errorf("expected %q to be rejected", test.text)
+			}
+			continue
+		}
+		if err != nil || level != test.expected {
+			t.Errorf("expected %q to be %s, got %s, %v", test.text, test.expected, level, err)
+		}
+	}
+	if name := Level(7).String(); name != "level(7)" {
+		t.Errorf("expected an unknown level to be named level(7), got %q", name)
+	}
+}
+
+func TestLoggerWriter(t *testing.T) {
+	var output bytes.Buffer
+	logger := log.New(NewLogger(LevelDebug, true, &output).Writer(LevelWarn), "", 0)
+	logger.Print("Unable to export traces")
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal(output.Bytes(), &entry); err != nil {
+		t.Fatalf("expected a JSON line, got %q", output.String())
+	}
+	if entry["level"] != "warn" || entry["message"] != "Unable to export traces" {
+		t.Errorf("expected the line to be logged as a warning, got %v", entry)
+	}
+}
+
+func TestNilLogger(t *testing.T) {
+	var output bytes.Buffer
+	log.SetOutput(&output)
+	defer log.SetOutput(os.Stderr)
+
+	var logger *Logger
+	logger.Log(LevelInfo, "Message sent", Fields{"message_id": "42"})
+	if !strings.Contains(output.String(), "INFO Message sent message_id=42") {
+		t.Errorf("expected the entry to be written by the standard logger, got %q", output.String())
+	}
+}
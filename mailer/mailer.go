@@ -0,0 +1,63 @@
+package mailer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/forsam-education/hermes/mailmessage"
+	"github.com/forsam-education/hermes/storage"
+	"github.com/forsam-education/hermes/transport"
+	"time"
+)
+
+// fanOutTemplatesTTL is how long the templates read for a fan-out message are kept, outliving any lambda invocation.
+const fanOutTemplatesTTL = time.Hour
+
+// Mailer renders and sends messages through the hermes pipeline, so Go services can embed it instead of queuing their messages for the lambda.
+type Mailer struct {
+	templateConnector storage.TemplateFetcher
+	attachmentWriter  storage.AttachmentCopier
+	mailTransport     transport.Dialer
+	// Options tunes the mail building pipeline, the zero value rendering and sending the messages as-is.
+	Options mailmessage.Options
+}
+
+// Send builds and sends the message of a JSON body, returning the id given to the message by the provider if the transport reports one.
+// A failure is a *mailmessage.SendError naming the failed phase, and a message whose recipients are all suppressed is not sent, returning a *mailmessage.SuppressedError.
+func (mailer *Mailer) Send(ctx context.Context, messageBody string) (string, error) {
+	return mailmessage.SendMail(ctx, mailer.templateConnector, mailer.attachmentWriter, mailer.mailTransport, mailer.Options, messageBody)
+}
+
+// FanOut returns a copy of the mailer reading each template once, to send the copies of a fan-out message with the same templates.
+// The messages asking for no_cache still read their templates from the storage.
+func (mailer *Mailer) FanOut() *Mailer {
+	fanOut := *mailer
+	fanOut.templateConnector = storage.NewCache(mailer.templateConnector, fanOutTemplatesTTL)
+
+	return &fanOut
+}
+
+// SendMessage builds and sends the message, as Send does with its JSON body.
+func (mailer *Mailer) SendMessage(ctx context.Context, message *Message) (string, error) {
+	messageBody, err := json.Marshal(message)
+	if err != nil {
+		return "", fmt.Errorf("unable to marshal message: %s", err.Error())
+	}
+
+	return mailer.Send(ctx, string(messageBody))
+}
+
+// Preview renders a template against the raw JSON context without sending anything.
+func (mailer *Mailer) Preview(templateName string, locale string, subject string, rawContext json.RawMessage) (*mailmessage.Rendering, error) {
+	return mailmessage.PreviewMail(mailer.templateConnector, mailer.Options, templateName, locale, subject, rawContext)
+}
+
+// Snapshot renders a template against the raw JSON context, and writes its snapshot to the Snapshots of the options, returning its key prefix.
+func (mailer *Mailer) Snapshot(templateName string, locale string, subject string, rawContext json.RawMessage) (string, error) {
+	return mailmessage.SnapshotTemplate(mailer.templateConnector, mailer.Options, templateName, locale, subject, rawContext)
+}
+
+// New instanciates a Mailer reading the templates and attachments from the given storages, and sending through the given transport.
+func New(templateConnector storage.TemplateFetcher, attachmentWriter storage.AttachmentCopier, mailTransport transport.Dialer) *Mailer {
+	return &Mailer{templateConnector: templateConnector, attachmentWriter: attachmentWriter, mailTransport: mailTransport}
+}
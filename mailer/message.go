@@ -0,0 +1,77 @@
+package mailer
+
+// Attachment is a file attached to a message, read from the attachment storage by its key, or given inline as base64 content.
+type Attachment struct {
+	Key         string `json:"key,omitempty"`
+	Bucket      string `json:"bucket,omitempty"`
+	Content     string `json:"content,omitempty"`
+	Filename    string `json:"filename,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+}
+
+// Address is a named address of a calendar event.
+type Address struct {
+	Name    string `json:"name,omitempty"`
+	Address string `json:"address"`
+}
+
+// CalendarEvent is a calendar invite sent along a message. Its dates are RFC 3339 dates, and its method defaults to REQUEST.
+type CalendarEvent struct {
+	UID         string    `json:"uid"`
+	Method      string    `json:"method,omitempty"`
+	Sequence    int       `json:"sequence,omitempty"`
+	Start       string    `json:"start"`
+	End         string    `json:"end"`
+	Summary     string    `json:"summary,omitempty"`
+	Description string    `json:"description,omitempty"`
+	Location    string    `json:"location,omitempty"`
+	Organizer   Address   `json:"organizer"`
+	Attendees   []Address `json:"attendees,omitempty"`
+}
+
+// FanOutEntry is a recipient of a fan-out message, receiving its own copy rendered with the context merged over the message one.
+type FanOutEntry struct {
+	To      []string               `json:"to"`
+	Locale  string                 `json:"locale,omitempty"`
+	Context map[string]interface{} `json:"context,omitempty"`
+}
+
+// Message is a message to send, with the same fields as the JSON messages queued for the lambda.
+type Message struct {
+	FromName        string                 `json:"from_name"`
+	FromAddress     string                 `json:"from_address"`
+	To              []string               `json:"to,omitempty"`
+	ReplyToAddress  string                 `json:"reply_to"`
+	ReplyToName     string                 `json:"reply_to_name,omitempty"`
+	Template        string                 `json:"template_name,omitempty"`
+	Locale          string                 `json:"locale,omitempty"`
+	Subject         string                 `json:"subject"`
+	CC              []string               `json:"cc,omitempty"`
+	BCC             []string               `json:"bcc,omitempty"`
+	Attachments     []Attachment           `json:"attachments,omitempty"`
+	CalendarEvent   *CalendarEvent         `json:"calendar_event,omitempty"`
+	InlineImages    []Attachment           `json:"inline_images,omitempty"`
+	ListID          string                 `json:"list_id,omitempty"`
+	Category        string                 `json:"category,omitempty"`
+	DKIMIdentity    string                 `json:"dkim_identity,omitempty"`
+	Date            string                 `json:"date,omitempty"`
+	SendAt          string                 `json:"send_at,omitempty"`
+	Timezone        string                 `json:"timezone,omitempty"`
+	Priority        string                 `json:"priority,omitempty"`
+	Transport       string                 `json:"transport,omitempty"`
+	Tenant          string                 `json:"tenant,omitempty"`
+	ReturnPath      string                 `json:"return_path,omitempty"`
+	Variant         string                 `json:"variant,omitempty"`
+	VariantSubjects map[string]string      `json:"variant_subjects,omitempty"`
+	FanOut          []FanOutEntry          `json:"fan_out,omitempty"`
+	NoCache         bool                   `json:"no_cache,omitempty"`
+	TrackingID      string                 `json:"tracking_id,omitempty"`
+	NoTracking      bool                   `json:"no_tracking,omitempty"`
+	IdempotencyKey  string                 `json:"idempotency_key,omitempty"`
+	DryRun          bool                   `json:"dry_run,omitempty"`
+	BodyContentType string                 `json:"body_content_type,omitempty"`
+	HTMLBody        string                 `json:"html_body,omitempty"`
+	TextBody        string                 `json:"text_body,omitempty"`
+	Headers         map[string]string      `json:"headers,omitempty"`
+	TemplateContext map[string]interface{} `json:"template_context,omitempty"`
+}
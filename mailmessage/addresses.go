@@ -0,0 +1,107 @@
+package mailmessage
+
+import (
+	"fmt"
+	"golang.org/x/net/idna"
+	"mime"
+	"net/mail"
+	"strings"
+)
+
+// Encodings of the non-ASCII display names and subjects.
+const (
+	HeaderEncodingQ = "Q"
+	HeaderEncodingB = "B"
+	// HeaderEncodingASCII transliterates them to ASCII, ie: "Café" becoming "Cafe", those which can't be transliterated being Q encoded.
+	HeaderEncodingASCII = "ASCII"
+)
+
+// isPrintableASCII tells if the text can be used in a header without RFC 2047 encoding.
+func isPrintableASCII(text string) bool {
+	for i := 0; i < len(text); i++ {
+		if text[i] < ' ' || text[i] > '~' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// hasSpecials tells if the text contains RFC 5322 specials, which the Q encoding can't represent in a display name.
+func hasSpecials(text string) bool {
+	return strings.ContainsAny(text, `()<>[]:;@\,."`)
+}
+
+// encodeDisplayName encodes a non-ASCII display name as an RFC 2047 encoded-word, falling back to the B encoding when the Q encoding would be ambiguous.
+// With HeaderEncodingASCII, the name is transliterated and quoted as required instead, when it can be.
+func encodeDisplayName(name string, opts Options) string {
+	if opts.HeaderEncoding == HeaderEncodingASCII {
+		if transliterated, ok := transliterate(name); ok {
+			return quoteDisplayName(transliterated)
+		}
+	}
+	if opts.HeaderEncoding == HeaderEncodingB || hasSpecials(name) {
+		return mime.BEncoding.Encode("UTF-8", name)
+	}
+
+	return mime.QEncoding.Encode("UTF-8", name)
+}
+
+// formatAddress formats an address header value, quoting or encoding its display name as required.
+func formatAddress(address *mail.Address, opts Options) string {
+	if address.Name == "" {
+		return address.Address
+	}
+	if isPrintableASCII(address.Name) {
+		return address.String()
+	}
+
+	return encodeDisplayName(address.Name, opts) + " <" + address.Address + ">"
+}
+
+// quoteDisplayName quotes an ASCII display name holding specials, escaping its quotes and backslashes.
+func quoteDisplayName(name string) string {
+	if !hasSpecials(name) {
+		return name
+	}
+
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(name) + `"`
+}
+
+// encodeText encodes a non-ASCII unstructured header value, ie: the subject, as RFC 2047 encoded-words of the HeaderEncoding,
+// or transliterates it with HeaderEncodingASCII when it can be.
+func encodeText(text string, opts Options) string {
+	if isPrintableASCII(text) {
+		return text
+	}
+
+	switch opts.HeaderEncoding {
+	case HeaderEncodingB:
+		return mime.BEncoding.Encode("UTF-8", text)
+	case HeaderEncodingASCII:
+		if transliterated, ok := transliterate(text); ok {
+			return transliterated
+		}
+	}
+
+	return mime.QEncoding.Encode("UTF-8", text)
+}
+
+// normalizeAddress returns the address as it is sent. Without SMTPUTF8, an internationalized domain is converted to its ASCII form,
+// ie: "jose@xn--exmple-cua.com", which any relay accepts, while a non-ASCII local part fails as it can only be delivered with SMTPUTF8.
+func normalizeAddress(address string, opts Options) (string, error) {
+	if opts.SMTPUTF8 || isPrintableASCII(address) {
+		return address, nil
+	}
+
+	at := strings.LastIndex(address, "@")
+	if !isPrintableASCII(address[:at+1]) {
+		return "", fmt.Errorf("%q has a non-ASCII local part, which requires SMTPUTF8", address)
+	}
+	domain, err := idna.Lookup.ToASCII(address[at+1:])
+	if err != nil {
+		return "", fmt.Errorf("%q has an invalid internationalized domain: %s", address, err.Error())
+	}
+
+	return address[:at+1] + domain, nil
+}
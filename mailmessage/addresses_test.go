@@ -0,0 +1,102 @@
+package mailmessage
+
+import (
+	"net/mail"
+	"strings"
+	"testing"
+)
+
+func TestFormatAddress(t *testing.T) {
+	tests := []struct {
+		name     string
+		address  mail.Address
+		encoding string
+		expected string
+	}{
+		{name: "without display name", address: mail.Address{Address: "support@example.com"}, expected: "support@example.com"},
+		{name: "ASCII display name", address: mail.Address{Name: "Forsam Support", Address: "support@example.com"}, expected: `"Forsam Support" <support@example.com>`},
+		{name: "ASCII display name with specials", address: mail.Address{Name: `Support, "Forsam"`, Address: "support@example.com"}, expected: `"Support, \"Forsam\"" <support@example.com>`},
+		{name: "accented display name", address: mail.Address{Name: "Café Support", Address: "support@example.com"}, expected: "=?UTF-8?q?Caf=C3=A9_Support?= <support@example.com>"},
+		{name: "accented display name in B", address: mail.Address{Name: "Café Support", Address: "support@example.com"}, encoding: HeaderEncodingB, expected: "=?UTF-8?b?Q2Fmw6kgU3VwcG9ydA==?= <support@example.com>"},
+		{name: "CJK display name", address: mail.Address{Name: "客服中心", Address: "support@example.com"}, expected: "=?UTF-8?q?=E5=AE=A2=E6=9C=8D=E4=B8=AD=E5=BF=83?= <support@example.com>"},
+		{name: "CJK display name in B", address: mail.Address{Name: "客服中心", Address: "support@example.com"}, encoding: HeaderEncodingB, expected: "=?UTF-8?b?5a6i5pyN5Lit5b+D?= <support@example.com>"},
+		{name: "non-ASCII display name with specials", address: mail.Address{Name: "Café, Support", Address: "support@example.com"}, expected: "=?UTF-8?b?Q2Fmw6ksIFN1cHBvcnQ=?= <support@example.com>"},
+		{name: "display name with a line break", address: mail.Address{Name: "Support\r\nBcc: x@example.org", Address: "support@example.com"}, expected: "=?UTF-8?b?U3VwcG9ydA0KQmNjOiB4QGV4YW1wbGUub3Jn?= <support@example.com>"},
+		{name: "transliterated display name", address: mail.Address{Name: "Café Support", Address: "support@example.com"}, encoding: HeaderEncodingASCII, expected: "Cafe Support <support@example.com>"},
+		{name: "transliterated display name with specials", address: mail.Address{Name: "Café, Support", Address: "support@example.com"}, encoding: HeaderEncodingASCII, expected: `"Cafe, Support" <support@example.com>`},
+		{name: "display name which can't be transliterated", address: mail.Address{Name: "客服", Address: "support@example.com"}, encoding: HeaderEncodingASCII, expected: "=?UTF-8?q?=E5=AE=A2=E6=9C=8D?= <support@example.com>"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			formatted := formatAddress(&test.address, Options{HeaderEncoding: test.encoding})
+			if formatted != test.expected {
+				t.Errorf("expected %q, got %q", test.expected, formatted)
+			}
+
+			// The header decodes back to the display name, unless it was transliterated.
+			parsed, err := mail.ParseAddress(formatted)
+			if err != nil {
+				t.Fatalf("expected %q to be a valid address: %s", formatted, err.Error())
+			}
+			if test.encoding != HeaderEncodingASCII && (parsed.Name != test.address.Name || parsed.Address != test.address.Address) {
+				t.Errorf("expected %q to decode to %+v, got %+v", formatted, test.address, *parsed)
+			}
+		})
+	}
+}
+
+func TestFormatAddressLongDisplayName(t *testing.T) {
+	name := strings.Repeat("Équipe support ", 8)
+	formatted := formatAddress(&mail.Address{Name: name, Address: "support@example.com"}, Options{})
+
+	// An encoded-word is at most 75 characters long.
+	for _, word := range strings.Fields(formatted) {
+		if len(word) > 75 {
+			t.Errorf("expected encoded-words of at most 75 characters, got %q", word)
+		}
+	}
+	parsed, err := mail.ParseAddress(formatted)
+	if err != nil || parsed.Name != name {
+		t.Errorf("expected %q to decode to %q, got %+v, %v", formatted, name, parsed, err)
+	}
+}
+
+func TestSendMailEncodesDisplayNames(t *testing.T) {
+	tests := []struct {
+		name     string
+		encoding string
+		expected map[string]string
+	}{
+		{
+			name: "Q encoding",
+			expected: map[string]string{
+				"From":     "=?UTF-8?q?Caf=C3=A9_Support?= <noreply@example.com>",
+				"Reply-To": "=?UTF-8?q?=E5=AE=A2=E6=9C=8D?= <support@example.com>",
+				"To":       "=?UTF-8?q?Zo=C3=A9_Martin?= <jane@example.org>",
+			},
+		},
+		{
+			name:     "B encoding",
+			encoding: HeaderEncodingB,
+			expected: map[string]string{
+				"From":     "=?UTF-8?b?Q2Fmw6kgU3VwcG9ydA==?= <noreply@example.com>",
+				"Reply-To": "=?UTF-8?b?5a6i5pyN?= <support@example.com>",
+				"To":       "=?UTF-8?b?Wm/DqSBNYXJ0aW4=?= <jane@example.org>",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			body := testMessageBody(map[string]interface{}{"from_name": "Café Support", "reply_to_name": "客服", "to": []string{"Zoé Martin <jane@example.org>"}})
+			messages := sendTestMessage(t, newTestTemplates(), Options{HeaderEncoding: test.encoding}, body)
+			raw := string(messages[0].Raw)
+			for header, value := range test.expected {
+				if !strings.Contains(raw, "\r\n"+header+": "+value+"\r\n") && !strings.HasPrefix(raw, header+": "+value+"\r\n") {
+					t.Errorf("expected the %s header %q, got %q", header, value, raw)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package mailmessage
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"log"
+	"path"
+	"strings"
+	"time"
+)
+
+// recordedMessage keeps a copy of the raw message as it was last written to the transport, so the sent message can be archived as-is.
+type recordedMessage struct {
+	io.WriterTo
+	raw bytes.Buffer
+}
+
+// WriteTo writes the message to the writer, replacing the copy of a previous attempt.
+func (message *recordedMessage) WriteTo(writer io.Writer) (int64, error) {
+	message.raw.Reset()
+
+	return message.WriterTo.WriteTo(io.MultiWriter(writer, &message.raw))
+}
+
+// archiveKey returns the key of an archived sent message, under the prefix and its send date, named by the provider message id if any.
+func archiveKey(prefix string, providerID string, now time.Time) string {
+	name := strings.Trim(providerID, "<>")
+	if name == "" {
+		id := make([]byte, 16)
+		_, _ = rand.Read(id)
+		name = hex.EncodeToString(id)
+	}
+
+	return path.Join(prefix, now.UTC().Format("2006/01/02"), strings.Replace(name, "/", "_", -1)+".eml")
+}
+
+// archiveSent stores the raw sent message in the archive. Failures only are logged, as the message is already sent.
+func archiveSent(opts Options, mailMsg *mailMessage, message *recordedMessage, providerID string) {
+	key := archiveKey(opts.ArchivePrefix, providerID, time.Now())
+	if err := opts.Archive.Put(key, &message.raw, "message/rfc822"); err != nil {
+		log.Printf("Unable to archive sent message of template %s: %s", mailMsg.Template, err.Error())
+		return
+	}
+	log.Printf("Archived sent message of template %s to %s", mailMsg.Template, key)
+}
@@ -0,0 +1,318 @@
+package mailmessage
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/forsam-education/hermes/storage"
+	"gopkg.in/gomail.v2"
+	"html"
+	"io"
+	"log"
+	"mime"
+	"path"
+	"strings"
+	ttemplate "text/template"
+	"unicode"
+)
+
+// attachmentDigestsHeader lists the SHA-256 digest of each attachment, so downstream systems can verify them.
+const attachmentDigestsHeader = "X-Attachment-Digests"
+
+// attachment references a file of the attachment storage. It is decoded either from its key as a plain string,
+// or from an object also holding the file name to send it under, ie: {"key": "invoices/42.pdf", "filename": "invoice-{{.OrderID}}.pdf"}.
+// The object form may also reference another bucket, or hold the base64 encoded content of the file instead of a key.
+type attachment struct {
+	Key         string `json:"key,omitempty"`
+	Bucket      string `json:"bucket,omitempty"`
+	Content     string `json:"content,omitempty"`
+	Filename    string `json:"filename,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+
+	name    string
+	content []byte
+}
+
+// UnmarshalJSON decodes the attachment from either its key or its object form.
+func (att *attachment) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		return json.Unmarshal(data, &att.Key)
+	}
+
+	type attachmentObject attachment
+	return json.Unmarshal(data, (*attachmentObject)(att))
+}
+
+// validateAttachments checks each attachment has either a key or an inline content, decoding the inline contents.
+// Attachments from another bucket than the attachment one must use an allowed bucket.
+func validateAttachments(attachments []attachment, opts Options) error {
+	for i := range attachments {
+		att := &attachments[i]
+		switch {
+		case att.Content != "":
+			if att.Key != "" || att.Bucket != "" {
+				return fmt.Errorf("attachment %d has both a content and a key", i)
+			}
+			if att.Filename == "" {
+				return fmt.Errorf("inline attachment %d has no filename", i)
+			}
+			var err error
+			if att.content, err = base64.StdEncoding.DecodeString(att.Content); err != nil {
+				return fmt.Errorf("inline attachment %d content is not valid base64: %s", i, err.Error())
+			}
+		case att.Key == "":
+			return fmt.Errorf("attachment %d has no key", i)
+		case att.Bucket != "" && !isAllowedBucket(att.Bucket, opts.AttachmentBuckets):
+			return fmt.Errorf("attachment %q uses the bucket %q, which is not allowed", att.Key, att.Bucket)
+		}
+		if att.ContentType != "" {
+			if _, _, err := mime.ParseMediaType(att.ContentType); err != nil {
+				return fmt.Errorf("attachment %d has an invalid content type %q: %s", i, att.ContentType, err.Error())
+			}
+		}
+	}
+
+	return nil
+}
+
+func isAllowedBucket(bucket string, allowed []string) bool {
+	for _, allowedBucket := range allowed {
+		if bucket == allowedBucket {
+			return true
+		}
+	}
+
+	return false
+}
+
+// storage returns the storage holding the attachment, which is either the attachment storage or another bucket of the same kind.
+func (att attachment) storage(attachmentWriter storage.AttachmentCopier) (storage.AttachmentCopier, error) {
+	if att.Bucket == "" {
+		return attachmentWriter, nil
+	}
+	switcher, ok := attachmentWriter.(storage.BucketSwitcher)
+	if !ok {
+		return nil, fmt.Errorf("attachment storage is unable to read another bucket")
+	}
+
+	return switcher.InBucket(att.Bucket), nil
+}
+
+// copy writes the content of the attachment, from its inline content or its storage.
+func (att attachment) copy(attachmentWriter storage.AttachmentCopier, writer io.Writer) error {
+	if att.content != nil {
+		_, err := writer.Write(att.content)
+		return err
+	}
+	attachmentStorage, err := att.storage(attachmentWriter)
+	if err != nil {
+		return err
+	}
+
+	return attachmentStorage.Copy(att.Key, writer)
+}
+
+// settings returns the gomail settings naming the attached file, and setting its content type when it is given.
+func (att attachment) settings(copyFunc func(io.Writer) error) []gomail.FileSetting {
+	settings := []gomail.FileSetting{gomail.Rename(att.name), gomail.SetCopyFunc(copyFunc)}
+	if att.ContentType != "" {
+		mediaType, params, _ := mime.ParseMediaType(att.ContentType)
+		params["name"] = att.name
+		settings = append(settings, gomail.SetHeader(map[string][]string{"Content-Type": {mime.FormatMediaType(mediaType, params)}}))
+	}
+
+	return settings
+}
+
+// sanitizeFilename removes path separators and control characters from a rendered file name,
+// along with the double quotes which would end the quoted filename parameter of the part headers.
+func sanitizeFilename(filename string) string {
+	sanitized := strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == '"' || unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, filename)
+
+	return strings.TrimSpace(sanitized)
+}
+
+// resolveAttachmentNames renders the file name templates of the attachments against the template context.
+// Attachments without file name are sent under the base name of their key.
+func resolveAttachmentNames(attachments []attachment, templateContext map[string]interface{}) error {
+	for i := range attachments {
+		att := &attachments[i]
+		if att.Filename == "" {
+			att.name = path.Base(att.Key)
+			continue
+		}
+
+		filenameTmpl, err := ttemplate.New("attachmentFilename").Funcs(templateFuncs()).Parse(att.Filename)
+		if err != nil {
+			return fmt.Errorf("unable to parse filename of attachment %q: %s", att.Key, err.Error())
+		}
+		var filename bytes.Buffer
+		if err := filenameTmpl.Execute(&filename, templateContext); err != nil {
+			return fmt.Errorf("unable to execute filename of attachment %q: %s", att.Key, err.Error())
+		}
+		att.name = sanitizeFilename(filename.String())
+		if att.name == "" || att.name == "." || att.name == ".." {
+			return fmt.Errorf("filename of attachment %q renders to the invalid %q name", att.Key, att.name)
+		}
+	}
+
+	return nil
+}
+
+// attachmentLink is an attachment sent as a download link instead of being attached, because of its size.
+type attachmentLink struct {
+	Name string
+	URL  string
+}
+
+// splitOversizedAttachments returns the attachments to attach to the message, and the download links replacing those above the size limit.
+// The total size of the attached files is checked against the total limit, if any. Without download links, the oversized attachments
+// of the template are stripped rather than failing the message when NonCompliantAttachments is NonCompliantAttachmentsStrip.
+func splitOversizedAttachments(attachmentWriter storage.AttachmentCopier, template string, attachments []attachment, opts Options) ([]attachment, []attachmentLink, error) {
+	if (opts.MaxAttachmentBytes <= 0 && opts.MaxTotalAttachmentBytes <= 0) || len(attachments) == 0 {
+		return attachments, nil, nil
+	}
+
+	kept := make([]attachment, 0, len(attachments))
+	var links []attachmentLink
+	var total int64
+	for _, att := range attachments {
+		if att.content != nil {
+			if opts.MaxAttachmentBytes > 0 && int64(len(att.content)) > opts.MaxAttachmentBytes {
+				if opts.NonCompliantAttachments == NonCompliantAttachmentsStrip {
+					failAttachment(template, att.name, "size", fmt.Sprintf("is %d bytes long, above the %d bytes limit", len(att.content), opts.MaxAttachmentBytes), opts)
+					continue
+				}
+				return nil, nil, fmt.Errorf("inline attachment %q is %d bytes long, above the %d bytes limit", att.name, len(att.content), opts.MaxAttachmentBytes)
+			}
+			kept = append(kept, att)
+			total += int64(len(att.content))
+			continue
+		}
+		attachmentStorage, err := att.storage(attachmentWriter)
+		if err != nil {
+			return nil, nil, err
+		}
+		linker, ok := attachmentStorage.(storage.AttachmentLinker)
+		if !ok {
+			return nil, nil, fmt.Errorf("attachment storage is unable to measure attachments size")
+		}
+		size, err := linker.Size(att.Key)
+		if err != nil {
+			return nil, nil, err
+		}
+		if opts.MaxAttachmentBytes <= 0 || size <= opts.MaxAttachmentBytes {
+			kept = append(kept, att)
+			total += size
+			continue
+		}
+		if !opts.AttachmentLinkFallback && opts.NonCompliantAttachments == NonCompliantAttachmentsStrip {
+			failAttachment(template, att.name, "size", fmt.Sprintf("is %d bytes long, above the %d bytes limit", size, opts.MaxAttachmentBytes), opts)
+			continue
+		}
+		if !opts.AttachmentLinkFallback {
+			return nil, nil, fmt.Errorf("attachment %q is %d bytes long, above the %d bytes limit", att.Key, size, opts.MaxAttachmentBytes)
+		}
+
+		url, err := linker.Link(att.Key, opts.AttachmentLinkExpiry)
+		if err != nil {
+			return nil, nil, err
+		}
+		links = append(links, attachmentLink{Name: att.name, URL: url})
+	}
+	if opts.MaxTotalAttachmentBytes > 0 && total > opts.MaxTotalAttachmentBytes {
+		return nil, nil, fmt.Errorf("attachments are %d bytes long in total, above the %d bytes limit", total, opts.MaxTotalAttachmentBytes)
+	}
+
+	return kept, links, nil
+}
+
+// appendTextLinks appends the download links list at the end of the plain text body.
+func appendTextLinks(textBody string, links []attachmentLink) string {
+	if len(links) == 0 {
+		return textBody
+	}
+
+	var builder strings.Builder
+	builder.WriteString(textBody)
+	builder.WriteString("\n\nAttachments available for download:\n")
+	for _, link := range links {
+		builder.WriteString(fmt.Sprintf("- %s: %s\n", link.Name, link.URL))
+	}
+
+	return builder.String()
+}
+
+// appendHTMLLinks inserts the download links list before the closing body tag of the HTML body, or at its end if there is none.
+func appendHTMLLinks(htmlBody string, links []attachmentLink) string {
+	if len(links) == 0 {
+		return htmlBody
+	}
+
+	var builder strings.Builder
+	builder.WriteString("<p>Attachments available for download:</p><ul>")
+	for _, link := range links {
+		builder.WriteString(fmt.Sprintf(`<li><a href="%s">%s</a></li>`, html.EscapeString(link.URL), html.EscapeString(link.Name)))
+	}
+	builder.WriteString("</ul>")
+
+	bodyEnd := strings.LastIndex(strings.ToLower(htmlBody), "</body>")
+	if bodyEnd < 0 {
+		return htmlBody + builder.String()
+	}
+
+	return htmlBody[:bodyEnd] + builder.String() + htmlBody[bodyEnd:]
+}
+
+// attachFromMemory downloads the attachments to compute their SHA-256 digests, and attaches them from memory.
+// Depending on the options, attachments with the same content as a previous one are skipped,
+// and the digests are listed in the X-Attachment-Digests header as "name=sha256:hex" entries.
+// Each attachment is inspected once downloaded, the non-compliant ones rejecting the message of the template or being stripped.
+func attachFromMemory(message *gomail.Message, attachmentWriter storage.AttachmentCopier, template string, attachments []attachment, opts Options) error {
+	digests := make([]string, 0, len(attachments))
+	attached := make(map[[sha256.Size]byte]string, len(attachments))
+	for _, att := range attachments {
+		var content bytes.Buffer
+		if err := att.copy(attachmentWriter, &content); err != nil {
+			return err
+		}
+		if opts.inspectsAttachments() {
+			reason, details, err := inspectAttachment(att, content.Bytes(), opts)
+			if err != nil {
+				return fmt.Errorf("unable to inspect attachment %q: %s", att.name, err.Error())
+			}
+			if reason != "" {
+				if err := failAttachment(template, att.name, reason, details, opts); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+		digest := sha256.Sum256(content.Bytes())
+		if name, ok := attached[digest]; ok && opts.DedupeAttachments {
+			log.Printf("Skipping attachment %q, which has the same content as %q", att.name, name)
+			continue
+		}
+		attached[digest] = att.name
+		digests = append(digests, fmt.Sprintf("%s=sha256:%s", att.name, hex.EncodeToString(digest[:])))
+
+		data := content.Bytes()
+		message.Attach(att.name, att.settings(func(writer io.Writer) error {
+			_, err := writer.Write(data)
+			return err
+		})...)
+	}
+	if opts.AttachmentDigests {
+		message.SetHeader(attachmentDigestsHeader, strings.Join(digests, ", "))
+	}
+
+	return nil
+}
@@ -0,0 +1,466 @@
+package mailmessage
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"github.com/forsam-education/hermes/storage"
+	"github.com/forsam-education/hermes/transport"
+	"log"
+	"mime"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// linkingStorage is a memory storage sharing its attachments through fake download links.
+type linkingStorage struct {
+	*storage.Memory
+}
+
+func (linker linkingStorage) Size(attachmentPath string) (int64, error) {
+	content, ok := linker.Get(attachmentPath)
+	if !ok {
+		return 0, &storage.NotFoundError{Name: attachmentPath}
+	}
+
+	return int64(len(content)), nil
+}
+
+func (linker linkingStorage) Link(attachmentPath string, expiry time.Duration) (string, error) {
+	return fmt.Sprintf("https://download.example.com/%s?expires=%d", attachmentPath, int(expiry.Seconds())), nil
+}
+
+func TestSplitOversizedAttachments(t *testing.T) {
+	attachments := linkingStorage{storage.NewMemory()}
+	attachments.Set("invoices/small.pdf", make([]byte, 10))
+	attachments.Set("invoices/limit.pdf", make([]byte, 100))
+	attachments.Set("invoices/large.pdf", make([]byte, 101))
+
+	tests := []struct {
+		name  string
+		keys  []string
+		opts  Options
+		kept  []string
+		links []attachmentLink
+		err   string
+	}{
+		{
+			name: "keeps the attachments without limit",
+			keys: []string{"invoices/large.pdf"},
+			kept: []string{"large.pdf"},
+		},
+		{
+			name: "keeps the attachments at the limit",
+			keys: []string{"invoices/small.pdf", "invoices/limit.pdf"},
+			opts: Options{MaxAttachmentBytes: 100, AttachmentLinkFallback: true},
+			kept: []string{"small.pdf", "limit.pdf"},
+		},
+		{
+			name:  "links the attachments above the limit",
+			keys:  []string{"invoices/small.pdf", "invoices/large.pdf"},
+			opts:  Options{MaxAttachmentBytes: 100, AttachmentLinkFallback: true, AttachmentLinkExpiry: time.Hour},
+			kept:  []string{"small.pdf"},
+			links: []attachmentLink{{Name: "large.pdf", URL: "https://download.example.com/invoices/large.pdf?expires=3600"}},
+		},
+		{
+			name: "fails the attachments above the limit without fallback",
+			keys: []string{"invoices/large.pdf"},
+			opts: Options{MaxAttachmentBytes: 100},
+			err:  `attachment "invoices/large.pdf" is 101 bytes long, above the 100 bytes limit`,
+		},
+		{
+			name: "strips the attachments above the limit without fallback",
+			keys: []string{"invoices/small.pdf", "invoices/large.pdf"},
+			opts: Options{MaxAttachmentBytes: 100, NonCompliantAttachments: NonCompliantAttachmentsStrip},
+			kept: []string{"small.pdf"},
+		},
+		{
+			name:  "excludes the linked attachments from the total",
+			keys:  []string{"invoices/limit.pdf", "invoices/large.pdf"},
+			opts:  Options{MaxAttachmentBytes: 100, MaxTotalAttachmentBytes: 100, AttachmentLinkFallback: true, AttachmentLinkExpiry: time.Minute},
+			kept:  []string{"limit.pdf"},
+			links: []attachmentLink{{Name: "large.pdf", URL: "https://download.example.com/invoices/large.pdf?expires=60"}},
+		},
+		{
+			name: "fails the attachments above the total limit",
+			keys: []string{"invoices/small.pdf", "invoices/limit.pdf"},
+			opts: Options{MaxAttachmentBytes: 100, MaxTotalAttachmentBytes: 100, AttachmentLinkFallback: true},
+			err:  "attachments are 110 bytes long in total, above the 100 bytes limit",
+		},
+		{
+			name: "fails a missing attachment",
+			keys: []string{"invoices/missing.pdf"},
+			opts: Options{MaxAttachmentBytes: 100, AttachmentLinkFallback: true},
+			err:  "invoices/missing.pdf",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var list []attachment
+			for _, key := range test.keys {
+				list = append(list, attachment{Key: key, name: key[strings.LastIndex(key, "/")+1:]})
+			}
+
+			kept, links, err := splitOversizedAttachments(attachments, "invoice", list, test.opts)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected error %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+			var names []string
+			for _, att := range kept {
+				names = append(names, att.name)
+			}
+			if strings.Join(names, ",") != strings.Join(test.kept, ",") {
+				t.Errorf("expected attachments %v, got %v", test.kept, names)
+			}
+			if fmt.Sprint(links) != fmt.Sprint(test.links) {
+				t.Errorf("expected links %v, got %v", test.links, links)
+			}
+		})
+	}
+}
+
+func TestSplitOversizedAttachmentsRequiresALinker(t *testing.T) {
+	attachments := storage.NewMemory()
+	attachments.Set("invoices/large.pdf", make([]byte, 101))
+
+	_, _, err := splitOversizedAttachments(attachments, "invoice", []attachment{{Key: "invoices/large.pdf", name: "large.pdf"}}, Options{MaxAttachmentBytes: 100, AttachmentLinkFallback: true})
+	if err == nil || !strings.Contains(err.Error(), "unable to measure attachments size") {
+		t.Errorf("expected a storage without links to fail, got %v", err)
+	}
+}
+
+func TestAppendLinks(t *testing.T) {
+	links := []attachmentLink{{Name: "report <2020>.zip", URL: "https://download.example.com/report.zip?a=1&b=2"}}
+	tests := []struct {
+		name     string
+		append   func(string, []attachmentLink) string
+		body     string
+		links    []attachmentLink
+		expected string
+	}{
+		{name: "text without links", append: appendTextLinks, body: "Hello", expected: "Hello"},
+		{name: "text", append: appendTextLinks, body: "Hello", links: links, expected: "Hello\n\nAttachments available for download:\n- report <2020>.zip: https://download.example.com/report.zip?a=1&b=2\n"},
+		{name: "HTML without links", append: appendHTMLLinks, body: "<p>Hello</p>", expected: "<p>Hello</p>"},
+		{
+			name:     "HTML without body",
+			append:   appendHTMLLinks,
+			body:     "<p>Hello</p>",
+			links:    links,
+			expected: `<p>Hello</p><p>Attachments available for download:</p><ul><li><a href="https://download.example.com/report.zip?a=1&amp;b=2">report &lt;2020&gt;.zip</a></li></ul>`,
+		},
+		{
+			name:     "HTML with body",
+			append:   appendHTMLLinks,
+			body:     "<html><BODY><p>Hello</p></BODY></html>",
+			links:    links,
+			expected: `<html><BODY><p>Hello</p><p>Attachments available for download:</p><ul><li><a href="https://download.example.com/report.zip?a=1&amp;b=2">report &lt;2020&gt;.zip</a></li></ul></BODY></html>`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if body := test.append(test.body, test.links); body != test.expected {
+				t.Errorf("expected %q, got %q", test.expected, body)
+			}
+		})
+	}
+}
+
+func TestSendMailLinksOversizedAttachments(t *testing.T) {
+	templates := newTestTemplates()
+	attachments := linkingStorage{storage.NewMemory()}
+	attachments.Set("invoices/small.pdf", []byte("%PDF small"))
+	attachments.Set("invoices/large.pdf", []byte("%PDF "+strings.Repeat("x", 100)))
+	fake := transport.NewFake()
+	opts := Options{MaxAttachmentBytes: 50, AttachmentLinkFallback: true, AttachmentLinkExpiry: 24 * time.Hour}
+	body := testMessageBody(map[string]interface{}{"attachments": []string{"invoices/small.pdf", "invoices/large.pdf"}})
+
+	if _, err := SendMail(context.Background(), templates, attachments, fake, opts, body); err != nil {
+		t.Fatalf("unable to send message: %s", err.Error())
+	}
+	_, parts := parseTestMessage(t, fake.Messages()[0])
+	link := "https://download.example.com/invoices/large.pdf?expires=86400"
+	var attached []string
+	for _, part := range parts {
+		switch {
+		case part.contentType == "text/plain" || part.contentType == "text/html":
+			if !strings.Contains(part.body, link) || !strings.Contains(part.body, "large.pdf") {
+				t.Errorf("expected the %s body to link large.pdf, got %q", part.contentType, part.body)
+			}
+		default:
+			attached = append(attached, part.body)
+		}
+	}
+	if len(attached) != 1 || attached[0] != "%PDF small" {
+		t.Errorf("expected only small.pdf to be attached, got %q", attached)
+	}
+}
+
+func TestSendMailAttachmentDigests(t *testing.T) {
+	attachments := storage.NewMemory()
+	attachments.Set("invoices/42.pdf", []byte("%PDF invoice 42"))
+	attachments.Set("invoices/43.pdf", []byte("%PDF invoice 43"))
+	attachments.Set("invoices/copy.pdf", []byte("%PDF invoice 42"))
+
+	tests := []struct {
+		name        string
+		opts        Options
+		attachments []interface{}
+		digested    []string
+	}{
+		{
+			name:        "omits the header by default",
+			attachments: []interface{}{"invoices/42.pdf"},
+		},
+		{
+			name:        "lists the digest of each attachment",
+			opts:        Options{AttachmentDigests: true},
+			attachments: []interface{}{"invoices/42.pdf", "invoices/43.pdf"},
+			digested:    []string{"42.pdf", "43.pdf"},
+		},
+		{
+			name:        "lists the digest of an inline attachment",
+			opts:        Options{AttachmentDigests: true},
+			attachments: []interface{}{map[string]string{"content": base64.StdEncoding.EncodeToString([]byte("name,total\njane,42\n")), "filename": "orders.csv"}},
+			digested:    []string{"orders.csv"},
+		},
+		{
+			name:        "lists the digest of duplicate attachments",
+			opts:        Options{AttachmentDigests: true},
+			attachments: []interface{}{"invoices/42.pdf", "invoices/copy.pdf"},
+			digested:    []string{"42.pdf", "copy.pdf"},
+		},
+		{
+			name:        "omits the digest of skipped duplicate attachments",
+			opts:        Options{AttachmentDigests: true, DedupeAttachments: true},
+			attachments: []interface{}{"invoices/42.pdf", "invoices/copy.pdf"},
+			digested:    []string{"42.pdf"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake := transport.NewFake()
+			body := testMessageBody(map[string]interface{}{"attachments": test.attachments})
+			if _, err := SendMail(context.Background(), newTestTemplates(), attachments, fake, test.opts, body); err != nil {
+				t.Fatalf("unable to send message: %s", err.Error())
+			}
+			header, parts := parseTestMessage(t, fake.Messages()[0])
+
+			value := header.Get(attachmentDigestsHeader)
+			if test.digested == nil {
+				if value != "" {
+					t.Errorf("expected no %s header, got %q", attachmentDigestsHeader, value)
+				}
+				return
+			}
+			digests := make(map[string]string)
+			var names []string
+			for _, entry := range strings.Split(value, ",") {
+				pair := strings.SplitN(strings.TrimSpace(entry), "=", 2)
+				if len(pair) != 2 || !strings.HasPrefix(pair[1], "sha256:") {
+					t.Fatalf("expected name=sha256:hex entries, got %q", value)
+				}
+				names = append(names, pair[0])
+				digests[pair[0]] = strings.TrimPrefix(pair[1], "sha256:")
+			}
+			if !reflect.DeepEqual(names, test.digested) {
+				t.Errorf("expected the digests of %v, got %q", test.digested, value)
+			}
+
+			// Each digest must match the content actually attached under its name.
+			var attached int
+			for _, part := range parts {
+				_, params, err := mime.ParseMediaType(strings.Join(part.header["Content-Disposition"], ""))
+				if err != nil || params["filename"] == "" {
+					continue
+				}
+				attached++
+				digest := sha256.Sum256([]byte(part.body))
+				if expected := hex.EncodeToString(digest[:]); digests[params["filename"]] != expected {
+					t.Errorf("expected %s to have digest %s, got %q", params["filename"], expected, digests[params["filename"]])
+				}
+			}
+			if attached != len(test.digested) {
+				t.Errorf("expected %d attachments, got %d", len(test.digested), attached)
+			}
+		})
+	}
+}
+
+func TestSanitizeFilename(t *testing.T) {
+	tests := []struct {
+		filename string
+		expected string
+	}{
+		{filename: "invoice-42.pdf", expected: "invoice-42.pdf"},
+		{filename: "../../etc/passwd", expected: "....etcpasswd"},
+		{filename: `C:\Users\jane\invoice.pdf`, expected: "C:Usersjaneinvoice.pdf"},
+		{filename: "invoice\r\nContent-Type: text/html.pdf", expected: "invoiceContent-Type: texthtml.pdf"},
+		{filename: "invoice\x00.pdf\x7f", expected: "invoice.pdf"},
+		{filename: `invoice".pdf"; name="evil.exe`, expected: "invoice.pdf; name=evil.exe"},
+		{filename: "  facture été.pdf\t", expected: "facture été.pdf"},
+	}
+
+	for _, test := range tests {
+		if sanitized := sanitizeFilename(test.filename); sanitized != test.expected {
+			t.Errorf("expected %q to be sanitized to %q, got %q", test.filename, test.expected, sanitized)
+		}
+	}
+}
+
+func TestResolveAttachmentNames(t *testing.T) {
+	templateContext := map[string]interface{}{"OrderID": 42, "Customer": map[string]interface{}{"Name": "Jane/Doe"}, "Path": "../", "Blank": " \n"}
+	tests := []struct {
+		name       string
+		attachment attachment
+		expected   string
+		err        string
+	}{
+		{name: "uses the base name of the key", attachment: attachment{Key: "invoices/2020/42.pdf"}, expected: "42.pdf"},
+		{name: "renders the filename template", attachment: attachment{Key: "invoices/42.pdf", Filename: "invoice-{{.OrderID}}.pdf"}, expected: "invoice-42.pdf"},
+		{name: "renders the template functions", attachment: attachment{Key: "invoices/42.pdf", Filename: `{{.Customer.Name | printf "%s"}}-{{.OrderID}}.pdf`}, expected: "JaneDoe-42.pdf"},
+		{name: "sanitizes the rendered filename", attachment: attachment{Key: "invoices/42.pdf", Filename: "{{.Path}}{{.Path}}invoice.pdf"}, expected: "....invoice.pdf"},
+		{name: "rejects a filename rendering empty", attachment: attachment{Key: "invoices/42.pdf", Filename: "{{.Blank}}"}, err: `filename of attachment "invoices/42.pdf" renders to the invalid "" name`},
+		{name: "rejects a filename rendering to the parent directory", attachment: attachment{Key: "invoices/42.pdf", Filename: "{{.Path}}"}, err: `renders to the invalid ".." name`},
+		{name: "rejects a filename which can't be parsed", attachment: attachment{Key: "invoices/42.pdf", Filename: "invoice-{{.OrderID.pdf"}, err: `unable to parse filename of attachment "invoices/42.pdf"`},
+		{name: "rejects a filename which can't be executed", attachment: attachment{Key: "invoices/42.pdf", Filename: "{{.OrderID.Number}}.pdf"}, err: `unable to execute filename of attachment "invoices/42.pdf"`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			attachments := []attachment{test.attachment}
+			err := resolveAttachmentNames(attachments, templateContext)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected error %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+			if attachments[0].name != test.expected {
+				t.Errorf("expected %q, got %q", test.expected, attachments[0].name)
+			}
+		})
+	}
+}
+
+func TestSendMailTemplatedAttachmentFilename(t *testing.T) {
+	attachments := storage.NewMemory()
+	attachments.Set("invoices/42.pdf", []byte("%PDF invoice 42"))
+	body := testMessageBody(map[string]interface{}{
+		"template_context": map[string]interface{}{"name": "Jane", "OrderID": 42},
+		"attachments":      []interface{}{map[string]string{"key": "invoices/42.pdf", "filename": `invoice-{{.OrderID}}".pdf`}},
+	})
+	fake := transport.NewFake()
+
+	if _, err := SendMail(context.Background(), newTestTemplates(), attachments, fake, Options{}, body); err != nil {
+		t.Fatalf("unable to send message: %s", err.Error())
+	}
+	_, parts := parseTestMessage(t, fake.Messages()[0])
+	var filenames []string
+	for _, part := range parts {
+		if disposition := part.header["Content-Disposition"]; len(disposition) > 0 {
+			_, params, err := mime.ParseMediaType(disposition[0])
+			if err != nil {
+				t.Fatalf("expected a valid Content-Disposition, got %q: %s", disposition[0], err.Error())
+			}
+			filenames = append(filenames, params["filename"])
+		}
+	}
+	if len(filenames) != 1 || filenames[0] != "invoice-42.pdf" {
+		t.Errorf("expected the attachment to be named invoice-42.pdf, got %q", filenames)
+	}
+}
+
+func TestSendMailDedupeAttachments(t *testing.T) {
+	attachments := storage.NewMemory()
+	attachments.Set("invoices/42.pdf", []byte("%PDF invoice 42"))
+	attachments.Set("invoices/43.pdf", []byte("%PDF invoice 43"))
+	attachments.Set("invoices/copy.pdf", []byte("%PDF invoice 42"))
+	inlineCopy := map[string]string{"content": base64.StdEncoding.EncodeToString([]byte("%PDF invoice 42")), "filename": "inline.pdf"}
+
+	tests := []struct {
+		name        string
+		opts        Options
+		attachments []interface{}
+		attached    []string
+		skipped     bool
+	}{
+		{name: "skips an identical attachment under another key", opts: Options{DedupeAttachments: true}, attachments: []interface{}{"invoices/42.pdf", "invoices/copy.pdf"}, attached: []string{"42.pdf"}, skipped: true},
+		{name: "skips an attachment listed twice", opts: Options{DedupeAttachments: true}, attachments: []interface{}{"invoices/43.pdf", "invoices/43.pdf"}, attached: []string{"43.pdf"}, skipped: true},
+		{name: "skips an identical inline attachment", opts: Options{DedupeAttachments: true}, attachments: []interface{}{"invoices/42.pdf", inlineCopy}, attached: []string{"42.pdf"}, skipped: true},
+		{name: "keeps differing attachments", opts: Options{DedupeAttachments: true}, attachments: []interface{}{"invoices/42.pdf", "invoices/43.pdf"}, attached: []string{"42.pdf", "43.pdf"}},
+		{name: "keeps the first of the identical attachments", opts: Options{DedupeAttachments: true}, attachments: []interface{}{"invoices/copy.pdf", "invoices/43.pdf", "invoices/42.pdf"}, attached: []string{"copy.pdf", "43.pdf"}, skipped: true},
+		{name: "keeps identical attachments by default", attachments: []interface{}{"invoices/42.pdf", "invoices/copy.pdf"}, attached: []string{"42.pdf", "copy.pdf"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var output bytes.Buffer
+			log.SetOutput(&output)
+			defer log.SetOutput(os.Stderr)
+
+			fake := transport.NewFake()
+			body := testMessageBody(map[string]interface{}{"attachments": test.attachments})
+			if _, err := SendMail(context.Background(), newTestTemplates(), attachments, fake, test.opts, body); err != nil {
+				t.Fatalf("unable to send message: %s", err.Error())
+			}
+			_, parts := parseTestMessage(t, fake.Messages()[0])
+
+			var attached []string
+			for _, part := range parts {
+				if _, params, err := mime.ParseMediaType(strings.Join(part.header["Content-Disposition"], "")); err == nil && params["filename"] != "" {
+					attached = append(attached, params["filename"])
+				}
+			}
+			if !reflect.DeepEqual(attached, test.attached) {
+				t.Errorf("expected the attachments %v, got %v", test.attached, attached)
+			}
+			if skipped := strings.Contains(output.String(), "Skipping attachment"); skipped != test.skipped {
+				t.Errorf("expected a skipped attachment to be logged: %t, got %q", test.skipped, output.String())
+			}
+		})
+	}
+}
+
+func TestSendMailDedupeAttachmentsKeepsInlineImages(t *testing.T) {
+	attachments := storage.NewMemory()
+	attachments.Set("images/logo.png", []byte("\x89PNG logo"))
+	attachments.Set("downloads/logo.png", []byte("\x89PNG logo"))
+
+	fake := transport.NewFake()
+	body := testMessageBody(map[string]interface{}{
+		"attachments":   []interface{}{"downloads/logo.png"},
+		"inline_images": []interface{}{"images/logo.png"},
+	})
+	if _, err := SendMail(context.Background(), newTestTemplates(), attachments, fake, Options{DedupeAttachments: true}, body); err != nil {
+		t.Fatalf("unable to send message: %s", err.Error())
+	}
+	_, parts := parseTestMessage(t, fake.Messages()[0])
+
+	var dispositions []string
+	for _, part := range parts {
+		if disposition, _, err := mime.ParseMediaType(strings.Join(part.header["Content-Disposition"], "")); err == nil {
+			dispositions = append(dispositions, disposition)
+		}
+	}
+	if strings.Join(dispositions, ",") != "inline,attachment" {
+		t.Errorf("expected the inline image and the identical attachment to be both kept, got %v", dispositions)
+	}
+}
@@ -1,102 +1,505 @@
 package mailmessage
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
 	"fmt"
+	"github.com/forsam-education/hermes/dkim"
+	"github.com/forsam-education/hermes/logging"
+	"github.com/forsam-education/hermes/smime"
 	"github.com/forsam-education/hermes/storage"
+	"github.com/forsam-education/hermes/transport"
 	"gopkg.in/gomail.v2"
-	htemplate "html/template"
 	"io"
 	"log"
-	ttemplate "text/template"
+	"net/mail"
+	"time"
 )
 
 type mailMessage struct {
 	FromName        string                 `json:"from_name"`
 	FromAddress     string                 `json:"from_address"`
 	ToAddress       string                 `json:"to_address"`
+	To              recipientList          `json:"to,omitempty"`
 	ReplyToAddress  string                 `json:"reply_to"`
+	ReplyToName     string                 `json:"reply_to_name,omitempty"`
 	Template        string                 `json:"template_name"`
+	Locale          string                 `json:"locale,omitempty"`
 	Subject         string                 `json:"subject"`
-	CC              []string               `json:"cc,omitempty"`
-	BCC             []string               `json:"bcc,omitempty"`
-	Attachments     []string               `json:"attachments,omitempty"`
+	CC              recipientList          `json:"cc,omitempty"`
+	BCC             recipientList          `json:"bcc,omitempty"`
+	Attachments     []attachment           `json:"attachments,omitempty"`
+	CalendarEvent   *calendarEvent         `json:"calendar_event,omitempty"`
+	InlineImages    []attachment           `json:"inline_images,omitempty"`
+	ListID          string                 `json:"list_id,omitempty"`
+	Category        string                 `json:"category,omitempty"`
+	DKIMIdentity    string                 `json:"dkim_identity,omitempty"`
+	Date            string                 `json:"date,omitempty"`
+	SendAt          string                 `json:"send_at,omitempty"`
+	Timezone        string                 `json:"timezone,omitempty"`
+	Priority        messagePriority        `json:"priority,omitempty"`
+	Transport       string                 `json:"transport,omitempty"`
+	Tenant          string                 `json:"tenant,omitempty"`
+	ReturnPath      string                 `json:"return_path,omitempty"`
+	Variant         string                 `json:"variant,omitempty"`
+	VariantSubjects map[string]string      `json:"variant_subjects,omitempty"`
+	FanOut          []fanOutEntry          `json:"fan_out,omitempty"`
+	NoCache         bool                   `json:"no_cache,omitempty"`
+	TrackingID      string                 `json:"tracking_id,omitempty"`
+	NoTracking      bool                   `json:"no_tracking,omitempty"`
+	IdempotencyKey  string                 `json:"idempotency_key,omitempty"`
+	DryRun          bool                   `json:"dry_run,omitempty"`
+	BodyContentType string                 `json:"body_content_type,omitempty"`
+	HTMLBody        string                 `json:"html_body,omitempty"`
+	TextBody        string                 `json:"text_body,omitempty"`
+	Headers         map[string]string      `json:"headers,omitempty"`
 	TemplateContext map[string]interface{} `json:"template_context"`
+
+	to             recipients
+	cc             recipients
+	bcc            recipients
+	date           time.Time
+	unsubscribeURL string
+	variant        string
+	shadow         bool
+	rendering      *Rendering
+	// unencodedHeaders are the address headers holding internationalized addresses, written as is rather than by gomail.
+	unencodedHeaders map[string][]string
+}
+
+// primaryContentType returns the content type of the first part, rendered from the TXT template.
+func (mailMsg *mailMessage) primaryContentType() string {
+	if mailMsg.BodyContentType == "" {
+		return "text/plain"
+	}
+
+	return mailMsg.BodyContentType
+}
+
+// hasRawBody tells if the message holds pre-rendered bodies, sent instead of rendering a template.
+func (mailMsg *mailMessage) hasRawBody() bool {
+	return mailMsg.HTMLBody != "" || mailMsg.TextBody != ""
+}
+
+// renderMailMessage returns the pre-rendered bodies of the message if it has some, or renders its template.
+func renderMailMessage(templateConnector storage.TemplateFetcher, mailMsg *mailMessage, opts Options) (*Rendering, error) {
+	if mailMsg.hasRawBody() {
+		return &Rendering{HTML: mailMsg.HTMLBody, Text: mailMsg.TextBody}, nil
+	}
+
+	var err error
+	if mailMsg.TemplateContext, err = opts.Preprocessors.apply(mailMsg.Template, mailMsg.TemplateContext); err != nil {
+		return nil, err
+	}
+
+	return renderTemplates(templateConnector, variantTemplate(mailMsg.Template, mailMsg.variant), mailMsg.Locale, mailMsg.TemplateContext, opts)
 }
 
-func buildMailContent(templateConnector storage.TemplateFetcher, attachmentWriter storage.AttachmentCopier, mailMsg *mailMessage) (*gomail.Message, error) {
+// checkBodySizes requires each rendered body to be within the size limit, if any.
+func checkBodySizes(rendered *Rendering, opts Options) error {
+	if opts.MaxBodyBytes <= 0 {
+		return nil
+	}
+
+	for _, body := range []struct {
+		name    string
+		content string
+	}{{"HTML", rendered.HTML}, {"TXT", rendered.Text}, {"AMP", rendered.AMP}} {
+		if len(body.content) > opts.MaxBodyBytes {
+			return fmt.Errorf("rendered %s body is %d bytes long, above the %d bytes limit", body.name, len(body.content), opts.MaxBodyBytes)
+		}
+	}
+
+	return nil
+}
+
+// undisclosedRecipients is the empty group used as To header of messages having only Bcc recipients.
+const undisclosedRecipients = "undisclosed-recipients:;"
+
+func buildMailContent(ctx context.Context, templateConnector storage.TemplateFetcher, attachmentWriter storage.AttachmentCopier, mailMsg *mailMessage, hookMsg *HookMessage, opts Options) (*gomail.Message, error) {
 	message := gomail.NewMessage()
 
-	htmlTemplateContent, err := templateConnector.Fetch(fmt.Sprintf("%s.html.template", mailMsg.Template))
+	rendered, err := renderMailMessage(templateConnector, mailMsg, opts)
 	if err != nil {
 		return nil, err
 	}
-	txtTemplateContent, err := templateConnector.Fetch(fmt.Sprintf("%s.txt.template", mailMsg.Template))
-	if err != nil {
+	if mailMsg.Subject, err = renderSubject(mailMsg.Subject, mailMsg.TemplateContext, opts); err != nil {
 		return nil, err
 	}
-	htmlTmpl, _ := htemplate.New("htmlTemplate").Parse(htmlTemplateContent)
-	txtTmpl, _ := ttemplate.New("textTemplate").Parse(txtTemplateContent)
-
-	var htmlTmplBuffer bytes.Buffer
-	err = htmlTmpl.Execute(&htmlTmplBuffer, mailMsg.TemplateContext)
-	if err != nil {
-		return nil, fmt.Errorf("unable to execute HTML template: %s", err.Error())
+	if len(opts.Hooks.AfterRender) > 0 {
+		rendered.Subject = mailMsg.Subject
+		if err := opts.Hooks.runAfterRender(ctx, hookMsg, rendered); err != nil {
+			return nil, err
+		}
+		mailMsg.Subject = rendered.Subject
+	}
+	if err := checkBodySizes(rendered, opts); err != nil {
+		return nil, err
 	}
 
-	var txtTmplBuffer bytes.Buffer
-	err = txtTmpl.Execute(&txtTmplBuffer, mailMsg.TemplateContext)
+	if err := resolveAttachmentNames(mailMsg.Attachments, mailMsg.TemplateContext); err != nil {
+		return nil, err
+	}
+	if err := resolveAttachmentNames(mailMsg.InlineImages, mailMsg.TemplateContext); err != nil {
+		return nil, err
+	}
+	attachments, attachmentLinks, err := splitOversizedAttachments(attachmentWriter, mailMsg.Template, mailMsg.Attachments, opts)
 	if err != nil {
-		return nil, fmt.Errorf("unable to execute TXT template: %s", err.Error())
+		return nil, err
 	}
 
-	ccAddresses := make([]string, len(mailMsg.CC))
-	for i, ccRecipient := range mailMsg.CC {
+	ccAddresses := make([]string, len(mailMsg.cc.header))
+	for i, ccRecipient := range mailMsg.cc.header {
 		ccAddresses[i] = message.FormatAddress(ccRecipient, "")
 	}
 
-	bccAddresses := make([]string, len(mailMsg.BCC))
-	for i, bccRecipient := range mailMsg.BCC {
+	bccAddresses := make([]string, len(mailMsg.bcc.header))
+	for i, bccRecipient := range mailMsg.bcc.header {
 		bccAddresses[i] = message.FormatAddress(bccRecipient, "")
 	}
 
-	message.SetBody("text/plain", txtTmplBuffer.String())
-	message.AddAlternative("text/html", htmlTmplBuffer.String())
-	message.SetAddressHeader("From", mailMsg.FromAddress, mailMsg.FromName)
-	message.SetHeader("To", mailMsg.ToAddress)
-	message.SetHeader("Subject", mailMsg.Subject)
-	message.SetHeader("Cc", ccAddresses...)
+	sentText := appendSignature(appendTextLinks(rendered.Text, attachmentLinks), opts.TextSignature)
+	textBody, err := transcode(sentText, opts.TextCharset, false)
+	if err != nil {
+		return nil, fmt.Errorf("unable to transcode TXT body: %s", err.Error())
+	}
+	if opts.SanitizeHTML {
+		rendered.HTML = sanitizeHTML(rendered.HTML)
+	}
+	if opts.MinifyHTML {
+		rendered.HTML = minifyHTML(rendered.HTML)
+	}
+	if rendered.HTML, err = addTracking(rendered.HTML, mailMsg, opts); err != nil {
+		return nil, err
+	}
+	sentHTML := appendHTMLLinks(rendered.HTML, attachmentLinks)
+	htmlBody, err := transcode(sentHTML, opts.HTMLCharset, true)
+	if err != nil {
+		return nil, fmt.Errorf("unable to transcode HTML body: %s", err.Error())
+	}
+	// The bodies are kept as they are sent, before their transcoding, for the snapshots.
+	mailMsg.rendering = &Rendering{Subject: mailMsg.Subject, HTML: sentHTML, Text: sentText, AMP: rendered.AMP}
+
+	switch {
+	// A pre-rendered message may hold a single body, as may a template missing a version, sent alone rather than along an empty alternative.
+	case mailMsg.hasRawBody() && mailMsg.HTMLBody == "", rendered.missing == missingHTML:
+		message.SetBody(mailMsg.primaryContentType(), textBody)
+	case mailMsg.hasRawBody() && mailMsg.TextBody == "", rendered.missing == missingText:
+		message.SetBody("text/html", htmlBody)
+	default:
+		// Gmail requires the AMP part to sit between the plain text and the HTML parts.
+		message.SetBody(mailMsg.primaryContentType(), textBody)
+		if rendered.AMP != "" {
+			message.AddAlternative("text/x-amp-html", rendered.AMP)
+		}
+		message.AddAlternative("text/html", htmlBody)
+	}
+	addCalendarEvent(message, mailMsg)
+	// The display names are encoded here rather than by gomail, so their encoding is configurable.
+	setAddressHeader(message, mailMsg, "From", formatAddress(&mail.Address{Name: mailMsg.FromName, Address: mailMsg.FromAddress}, opts))
+	if len(mailMsg.to.header) > 0 {
+		setAddressHeader(message, mailMsg, "To", mailMsg.to.header...)
+	} else if opts.UndisclosedRecipients {
+		message.SetHeader("To", undisclosedRecipients)
+	}
+	message.SetHeader("Subject", encodeText(mailMsg.Subject, opts))
+	message.SetDateHeader("Date", mailMsg.date)
+	setAddressHeader(message, mailMsg, "Cc", ccAddresses...)
 	message.SetHeader("Bcc", bccAddresses...)
-	message.SetHeader("Reply-To", mailMsg.ReplyToAddress)
-	for _, att := range mailMsg.Attachments {
-		message.Attach(att, gomail.SetCopyFunc(func(writer io.Writer) error {
-			return attachmentWriter.Copy(att, writer)
-		}))
+	setAddressHeader(message, mailMsg, "Reply-To", formatAddress(&mail.Address{Name: mailMsg.ReplyToName, Address: mailMsg.ReplyToAddress}, opts))
+	if mailMsg.Category == CategoryBulk {
+		setOneClickUnsubscribe(message, mailMsg.unsubscribeURL)
+	}
+	setImportanceHeaders(message, mailMsg)
+	for name, value := range mailMsg.Headers {
+		message.SetHeader(name, value)
+	}
+	// Inline images are referenced by the HTML body as cid:name.
+	for _, image := range mailMsg.InlineImages {
+		image := image
+		message.Embed(image.name, image.settings(func(writer io.Writer) error {
+			return image.copy(attachmentWriter, writer)
+		})...)
+	}
+	if (opts.AttachmentDigests || opts.DedupeAttachments || opts.inspectsAttachments()) && len(attachments) > 0 {
+		return message, attachFromMemory(message, attachmentWriter, mailMsg.Template, attachments, opts)
+	}
+	for _, att := range attachments {
+		att := att
+		message.Attach(att.name, att.settings(func(writer io.Writer) error {
+			return att.copy(attachmentWriter, writer)
+		})...)
 	}
 
 	return message, nil
 }
 
-// SendMail builds and sends a mail through SMTP transport
-func SendMail(templateConnector storage.TemplateFetcher, attachmentWriter storage.AttachmentCopier, smtpTransport *gomail.Dialer, messageBody string) error {
-	var mailMsg mailMessage
+// setAddressHeader sets an address header of the message. A header holding internationalized addresses is kept to be written unencoded,
+// as RFC 6532 requires, since gomail would encode it as a whole.
+func setAddressHeader(message *gomail.Message, mailMsg *mailMessage, field string, values ...string) {
+	for _, value := range values {
+		if !isPrintableASCII(value) {
+			if mailMsg.unencodedHeaders == nil {
+				mailMsg.unencodedHeaders = make(map[string][]string)
+			}
+			mailMsg.unencodedHeaders[field] = values
+			return
+		}
+	}
+
+	message.SetHeader(field, values...)
+}
 
-	err := json.Unmarshal([]byte(messageBody), &mailMsg)
+// sendMessage signs the built message if required, and sends it through the transport profile the message selects, or the given transport, once the sending limits allow it.
+// The connection is bounded by the context deadline when the sender is able to, so a slow relay fails the message rather than the invocation.
+func sendMessage(ctx context.Context, mailTransport transport.Dialer, opts Options, mailMsg *mailMessage, mail *gomail.Message) (string, error) {
+	envelope := mailMsg.envelopeRecipients()
+	domains := make([]string, len(envelope))
+	for i, address := range envelope {
+		domains[i] = domainOf(address)
+	}
+	maxWait := time.Now().Add(opts.RateLimitMaxWait)
+	if deadline, ok := ctx.Deadline(); ok && deadline.Before(maxWait) {
+		maxWait = deadline
+	}
+	release, err := opts.SendLimits.Acquire(mailMsg.Template, domains, maxWait)
 	if err != nil {
-		return fmt.Errorf("unable tu unmarshal email: %s", err.Error())
+		return "", fmt.Errorf("unable to send email: %s", err.Error())
 	}
+	defer release()
 
-	mail, err := buildMailContent(templateConnector, attachmentWriter, &mailMsg)
+	if err := opts.Warmup.Reserve(time.Now()); err != nil {
+		return "", fmt.Errorf("unable to send email: %s", err.Error())
+	}
+
+	envelopeFrom, err := envelopeSender(mailMsg, opts)
 	if err != nil {
-		return err
+		return "", fmt.Errorf("unable to send email: %s", err.Error())
+	}
+	if mailMsg.Transport != "" {
+		mailTransport = opts.Transports[mailMsg.Transport]
+	}
+	if err := ctx.Err(); err != nil {
+		return "", &dialError{message: fmt.Sprintf("no time left to connect to mail transport: %s", err.Error())}
+	}
+	sender, err := transport.Dial(ctx, mailTransport)
+	if err != nil {
+		return "", &dialError{message: fmt.Sprintf("unable to connect to mail transport: %s", err.Error())}
+	}
+	defer sender.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		if setter, ok := sender.(transport.DeadlineSetter); ok {
+			if err := setter.SetDeadline(deadline); err != nil {
+				return "", &dialError{message: fmt.Sprintf("unable to bound mail transport connection: %s", err.Error())}
+			}
+		}
 	}
 
-	if err := smtpTransport.DialAndSend(mail); err != nil {
-		return fmt.Errorf("unable to send email through smtp: %s", err.Error())
+	// A message sealed with S/MIME is encrypted for its recipients, listing their certificates, so each blind carbon copy recipient
+	// gets its own copy rather than being disclosed to the other recipients.
+	deliveries := [][]string{envelope}
+	identity := opts.SMIME[mailMsg.Transport]
+	if identity != nil {
+		deliveries = mailMsg.sealedDeliveries()
 	}
+	var providerID string
+	for i, recipients := range deliveries {
+		var rawMessage io.WriterTo = &charsetMessage{Message: mail, textType: mailMsg.primaryContentType(), textCharset: opts.TextCharset, htmlCharset: opts.HTMLCharset, headers: mailMsg.unencodedHeaders}
+		// The S/MIME entity is built first, so the DKIM signature covers its final form.
+		if identity != nil {
+			rawMessage = &smime.SealedMessage{Message: rawMessage, Identity: identity, Recipients: recipients}
+		}
+		if opts.DKIM != nil {
+			signer, err := opts.DKIM.Select(mailMsg.DKIMIdentity, domainOf(mailMsg.FromAddress))
+			if err != nil {
+				return "", fmt.Errorf("unable to sign email: %s", err.Error())
+			}
+			rawMessage = &dkim.SignedMessage{Message: rawMessage, Signer: signer}
+		}
 
-	log.Printf("Sent email message %+v\n", mailMsg)
+		// Only the first copy is archived, and gives the provider id.
+		var sentMessage *recordedMessage
+		if opts.Archive != nil && i == 0 {
+			sentMessage = &recordedMessage{WriterTo: rawMessage}
+			rawMessage = sentMessage
+		}
 
-	return nil
+		// The envelope is built from the message rather than parsed back from the headers, as the To header may be a group.
+		if err := sender.Send(envelopeFrom, recipients, rawMessage); err != nil {
+			return "", fmt.Errorf("unable to send email through mail transport: %s", err.Error())
+		}
+
+		if i > 0 {
+			continue
+		}
+		if reporter, ok := sender.(transport.MessageIDReporter); ok {
+			providerID = reporter.ProviderMessageID()
+		}
+		if sentMessage != nil {
+			archiveSent(opts, mailMsg, sentMessage, providerID)
+		}
+	}
+
+	return providerID, nil
+}
+
+// overrideFromName replaces the display name of the sender by the forced one, if any, keeping the address.
+func overrideFromName(mailMsg *mailMessage, opts Options) {
+	if opts.ForceFromName == "" || mailMsg.FromName == opts.ForceFromName {
+		return
+	}
+
+	log.Printf("Overriding from name %q by %q for template %s", mailMsg.FromName, opts.ForceFromName, mailMsg.Template)
+	mailMsg.FromName = opts.ForceFromName
+}
+
+// SendMail builds and sends a mail through the given transport, tracing the decode, lookup, render and send phases as children of the context span.
+// It returns the id given to the message by the provider, if the transport reports one, and a *SendError naming the failed phase and the template.
+// The suppressed recipients are dropped, and a *SuppressedError is returned without sending when none is left.
+func SendMail(ctx context.Context, templateConnector storage.TemplateFetcher, attachmentWriter storage.AttachmentCopier, mailTransport transport.Dialer, opts Options, messageBody string) (string, error) {
+	_, span := opts.Tracer.StartSpan(ctx, "decode")
+	mailMsg, err := decodeMailMessage(messageBody, opts)
+	if err == nil {
+		err = applyTenant(mailMsg, opts)
+	}
+	if err == nil {
+		err = validateMailMessage(mailMsg, opts)
+	}
+	if err == nil {
+		overrideRecipients(mailMsg, opts)
+		overrideFromName(mailMsg, opts)
+		err = checkFromName(mailMsg, opts)
+	}
+	if err == nil {
+		err = routeByDomain(mailMsg, opts)
+	}
+	if err == nil {
+		mailMsg.variant = selectVariant(mailMsg, opts)
+		if subject, ok := mailMsg.VariantSubjects[mailMsg.variant]; ok {
+			mailMsg.Subject = subject
+		}
+		mailMsg.shadow = opts.Shadow.selects(mailMsg)
+	}
+	span.End(err)
+	if err != nil {
+		return "", &SendError{Phase: PhaseDecode, message: fmt.Sprintf("invalid message: %s", err.Error())}
+	}
+
+	_, span = opts.Tracer.StartSpan(ctx, "lookup")
+	if err = checkRecipientDomains(mailMsg, opts); err != nil {
+		span.End(err)
+		return "", &SendError{Phase: PhaseDecode, message: fmt.Sprintf("invalid message: %s", err.Error())}
+	}
+	err = dropSuppressed(mailMsg, opts)
+	if IsSuppressed(err) {
+		span.End(nil)
+		log.Printf("Skipping message of template %s: %s", mailMsg.Template, err.Error())
+		return "", err
+	}
+	span.End(err)
+	if err != nil {
+		return "", &SendError{Phase: PhaseLookup, message: fmt.Sprintf("unable to check suppressed recipients: %s", err.Error())}
+	}
+	if len(opts.Enrichers) > 0 && mailMsg.TemplateContext != nil {
+		var enrichCtx context.Context
+		enrichCtx, span = opts.Tracer.StartSpan(ctx, "enrich")
+		err = enrichContext(enrichCtx, mailMsg, opts)
+		span.End(err)
+		if err != nil {
+			return "", &SendError{Phase: PhaseLookup, message: fmt.Sprintf("unable to enrich template context: %s", err.Error())}
+		}
+	}
+
+	if mailMsg.shadow && opts.Shadow.Inbox != "" {
+		if mailMsg.Headers == nil {
+			mailMsg.Headers = make(map[string]string)
+		}
+		redirectShadow(mailMsg, opts)
+	}
+
+	hookMsg := newHookMessage(mailMsg)
+	if err := opts.Hooks.runBeforeRender(ctx, hookMsg, mailMsg); err != nil {
+		return "", &SendError{Phase: PhaseDecode, message: fmt.Sprintf("invalid message: %s", err.Error())}
+	}
+
+	renderStart := time.Now()
+	renderCtx, cancelRender := stageContext(ctx, opts.RenderTimeout)
+	defer cancelRender()
+	renderCtx, span = opts.Tracer.StartSpan(renderCtx, "render")
+	templateConnector = tenantTemplates(templateConnector, mailMsg, opts)
+	if mailMsg.NoCache {
+		templateConnector = storage.Uncached(templateConnector)
+	}
+	templateConnector = tracedFetcher{TemplateFetcher: templateConnector, ctx: renderCtx, tracer: opts.Tracer}
+	var templates *digestingFetcher
+	if opts.Snapshots != nil {
+		templates = &digestingFetcher{TemplateFetcher: templateConnector}
+		templateConnector = templates
+	}
+	var mail *gomail.Message
+	err = addUnsubscribeURL(mailMsg, opts)
+	if err == nil {
+		mail, err = buildMailContent(renderCtx, templateConnector, attachmentWriter, mailMsg, hookMsg, opts)
+	}
+	if err == nil {
+		hookMsg.Subject = mailMsg.Subject
+		err = opts.Hooks.runBeforeSend(renderCtx, hookMsg, mail)
+	}
+	if err == nil && renderCtx.Err() != nil {
+		err = fmt.Errorf("render took too long: %s", renderCtx.Err().Error())
+	}
+	span.End(err)
+	putPhaseLatency(opts, "render_latency", renderStart)
+	if _, ok := err.(*rejectedAttachmentError); ok {
+		return "", &SendError{Phase: PhaseDecode, message: fmt.Sprintf("invalid message: %s", err.Error())}
+	}
+	if err != nil {
+		return "", &SendError{Phase: PhaseRender, message: fmt.Sprintf("unable to build message of template %q: %s", mailMsg.Template, err.Error())}
+	}
+	snapshotMessage(opts, mailMsg, templates)
+
+	if mailMsg.shadow && opts.Shadow.Inbox == "" {
+		if err := writeShadow(opts, mailMsg, mail); err != nil {
+			return "", &SendError{Phase: PhaseRender, message: fmt.Sprintf("unable to write shadowed message of template %q: %s", mailMsg.Template, err.Error())}
+		}
+		putShadowed(opts, mailMsg)
+		return "", nil
+	}
+	if mailMsg.DryRun || opts.DryRun {
+		if err := writePreview(opts, mailMsg, mail); err != nil {
+			return "", &SendError{Phase: PhaseRender, message: fmt.Sprintf("unable to write dry run of template %q: %s", mailMsg.Template, err.Error())}
+		}
+		return "", nil
+	}
+
+	sendStart := time.Now()
+	sendCtx, cancelSend := stageContext(ctx, opts.SendTimeout)
+	defer cancelSend()
+	sendCtx, span = opts.Tracer.StartSpan(sendCtx, "send")
+	providerID, err := sendMessage(sendCtx, mailTransport, opts, mailMsg, mail)
+	span.End(err)
+	putPhaseLatency(opts, "smtp_latency", sendStart)
+	if err != nil {
+		phase := PhaseSend
+		if _, ok := err.(*dialError); ok {
+			phase = PhaseDial
+		}
+		sendErr := &SendError{Phase: phase, message: fmt.Sprintf("unable to send message of template %q: %s", mailMsg.Template, err.Error())}
+		opts.Hooks.runAfterSend(ctx, hookMsg, "", sendErr)
+		return "", sendErr
+	}
+	opts.Hooks.runAfterSend(ctx, hookMsg, providerID, nil)
+	if mailMsg.shadow {
+		putShadowed(opts, mailMsg)
+	}
+
+	// The other fields of a sent message are logged by the caller, which knows its id.
+	if mailMsg.TrackingID != "" {
+		opts.Logger.Log(logging.LevelInfo, "Message tracked", logging.Fields{
+			"template":         mailMsg.Template,
+			"recipient_domain": domainOf(mailMsg.mainRecipient()),
+			"tracking_id":      mailMsg.TrackingID,
+		})
+	}
+
+	return providerID, nil
 }
@@ -0,0 +1,733 @@
+package mailmessage
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/forsam-education/hermes/logging"
+	"github.com/forsam-education/hermes/storage"
+	"github.com/forsam-education/hermes/tracking"
+	"github.com/forsam-education/hermes/transport"
+	"github.com/forsam-education/hermes/warmup"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// mimePart is a leaf part of a sent message, with its decoded body.
+type mimePart struct {
+	header      map[string][]string
+	contentType string
+	body        string
+}
+
+// newTestTemplates returns a storage holding the HTML and TXT versions of the "welcome" template.
+func newTestTemplates() *storage.Memory {
+	templates := storage.NewMemory()
+	templates.Set("welcome.html.template", []byte("<p>Hello {{.name}}</p>"))
+	templates.Set("welcome.txt.template", []byte("Hello {{.name}}"))
+
+	return templates
+}
+
+// testMessageBody returns the body of a message of the "welcome" template to jane@example.org, with the given fields changed.
+func testMessageBody(fields map[string]interface{}) string {
+	message := map[string]interface{}{
+		"from_address":     "noreply@example.com",
+		"reply_to":         "support@example.com",
+		"to":               []string{"jane@example.org"},
+		"subject":          "Welcome",
+		"template_name":    "welcome",
+		"template_context": map[string]interface{}{"name": "Jane"},
+	}
+	for name, value := range fields {
+		if value == nil {
+			delete(message, name)
+			continue
+		}
+		message[name] = value
+	}
+	body, _ := json.Marshal(message)
+
+	return string(body)
+}
+
+// sendTestMessage sends the message body through a fake transport and returns the recorded messages.
+func sendTestMessage(t *testing.T, templates *storage.Memory, opts Options, messageBody string) []transport.FakeMessage {
+	t.Helper()
+
+	fake := transport.NewFake()
+	if _, err := SendMail(context.Background(), templates, templates, fake, opts, messageBody); err != nil {
+		t.Fatalf("unable to send message: %s", err.Error())
+	}
+
+	return fake.Messages()
+}
+
+// parseTestMessage parses a recorded message, returning its headers and its leaf parts in order.
+func parseTestMessage(t *testing.T, message transport.FakeMessage) (mail.Header, []mimePart) {
+	t.Helper()
+
+	parsed, err := message.Parse()
+	if err != nil {
+		t.Fatalf("unable to parse message: %s", err.Error())
+	}
+	parts, err := readParts(parsed.Header, parsed.Body)
+	if err != nil {
+		t.Fatalf("unable to read parts: %s", err.Error())
+	}
+
+	return parsed.Header, parts
+}
+
+func readParts(header map[string][]string, body io.Reader) ([]mimePart, error) {
+	contentType := "text/plain"
+	if values := header["Content-Type"]; len(values) > 0 {
+		contentType = values[0]
+	}
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(mediaType, "multipart/") {
+		var parts []mimePart
+		reader := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := reader.NextRawPart()
+			if err == io.EOF {
+				return parts, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			children, err := readParts(part.Header, part)
+			if err != nil {
+				return nil, err
+			}
+			parts = append(parts, children...)
+		}
+	}
+
+	first := func(name string) string {
+		if values := header[name]; len(values) > 0 {
+			return values[0]
+		}
+		return ""
+	}
+	switch strings.ToLower(first("Content-Transfer-Encoding")) {
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	}
+	content, err := ioutil.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+
+	return []mimePart{{header: header, contentType: mediaType, body: string(content)}}, nil
+}
+
+func TestSendMailAMPPart(t *testing.T) {
+	tests := []struct {
+		name         string
+		templates    map[string]string
+		contentTypes []string
+		amp          string
+	}{
+		{
+			name:         "without AMP template",
+			contentTypes: []string{"text/plain", "text/html"},
+		},
+		{
+			name:         "with an AMP template",
+			templates:    map[string]string{"welcome.amp.template": `<html ⚡4email><body>Hello {{.name}}</body></html>`},
+			contentTypes: []string{"text/plain", "text/x-amp-html", "text/html"},
+			amp:          `<html ⚡4email><body>Hello Jane</body></html>`,
+		},
+		{
+			name:         "with an AMP HTML template",
+			templates:    map[string]string{"welcome.amp.html.template": `<html ⚡4email><body>Hi {{.name}}</body></html>`},
+			contentTypes: []string{"text/plain", "text/x-amp-html", "text/html"},
+			amp:          `<html ⚡4email><body>Hi Jane</body></html>`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			templates := newTestTemplates()
+			for key, content := range test.templates {
+				templates.Set(key, []byte(content))
+			}
+			messages := sendTestMessage(t, templates, Options{}, testMessageBody(nil))
+			if len(messages) != 1 {
+				t.Fatalf("expected a message, got %d", len(messages))
+			}
+
+			header, parts := parseTestMessage(t, messages[0])
+			if mediaType, _, _ := mime.ParseMediaType(header.Get("Content-Type")); mediaType != "multipart/alternative" {
+				t.Errorf("expected a multipart/alternative message, got %s", mediaType)
+			}
+			var contentTypes []string
+			for _, part := range parts {
+				contentTypes = append(contentTypes, part.contentType)
+				if part.contentType == "text/x-amp-html" && part.body != test.amp {
+					t.Errorf("expected AMP part %q, got %q", test.amp, part.body)
+				}
+			}
+			if strings.Join(contentTypes, ",") != strings.Join(test.contentTypes, ",") {
+				t.Errorf("expected parts %v, got %v", test.contentTypes, contentTypes)
+			}
+		})
+	}
+}
+
+func TestSendMailKeepsBlindRecipientsInTheEnvelope(t *testing.T) {
+	tests := []struct {
+		name       string
+		fields     map[string]interface{}
+		recipients []string
+		to         string
+		cc         string
+	}{
+		{
+			name:       "to only",
+			recipients: []string{"jane@example.org"},
+			to:         "jane@example.org",
+		},
+		{
+			name:       "to, cc and bcc in one message",
+			fields:     map[string]interface{}{"cc": []string{"john@example.org"}, "bcc": []string{"audit@example.com"}},
+			recipients: []string{"jane@example.org", "john@example.org", "audit@example.com"},
+			to:         "jane@example.org",
+			cc:         "john@example.org",
+		},
+		{
+			name:       "bcc only",
+			fields:     map[string]interface{}{"to": nil, "bcc": []string{"audit@example.com", "archive@example.com"}},
+			recipients: []string{"audit@example.com", "archive@example.com"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			messages := sendTestMessage(t, newTestTemplates(), Options{}, testMessageBody(test.fields))
+			if len(messages) != 1 {
+				t.Fatalf("expected a single message, got %d", len(messages))
+			}
+			if strings.Join(messages[0].Recipients, ",") != strings.Join(test.recipients, ",") {
+				t.Errorf("expected recipients %v, got %v", test.recipients, messages[0].Recipients)
+			}
+
+			header, _ := parseTestMessage(t, messages[0])
+			if _, ok := header["Bcc"]; ok {
+				t.Errorf("expected no Bcc header, got %q", header["Bcc"])
+			}
+			if header.Get("To") != test.to || header.Get("Cc") != test.cc {
+				t.Errorf("expected To %q and Cc %q, got %q and %q", test.to, test.cc, header.Get("To"), header.Get("Cc"))
+			}
+			headers := strings.SplitN(string(messages[0].Raw), "\r\n\r\n", 2)[0]
+			if strings.Contains(headers, "audit@example.com") {
+				t.Errorf("expected the blind recipients to stay out of the headers, got %q", headers)
+			}
+		})
+	}
+}
+
+func TestSendMailBlindCopyOnly(t *testing.T) {
+	tests := []struct {
+		name         string
+		undisclosed  bool
+		fields       map[string]interface{}
+		to           []string
+		recipients   []string
+		withToHeader bool
+	}{
+		{
+			name:       "omits the To header",
+			fields:     map[string]interface{}{"to": nil, "bcc": []string{"archive@example.com"}},
+			recipients: []string{"archive@example.com"},
+		},
+		{
+			name:         "sets undisclosed recipients as To header",
+			undisclosed:  true,
+			fields:       map[string]interface{}{"to": nil, "bcc": []string{"archive@example.com"}},
+			to:           []string{undisclosedRecipients},
+			recipients:   []string{"archive@example.com"},
+			withToHeader: true,
+		},
+		{
+			name:         "accepts an empty to list",
+			undisclosed:  true,
+			fields:       map[string]interface{}{"to": []string{}, "bcc": []string{"archive@example.com", "audit@example.com"}},
+			to:           []string{undisclosedRecipients},
+			recipients:   []string{"archive@example.com", "audit@example.com"},
+			withToHeader: true,
+		},
+		{
+			name:         "keeps the To header of a message with to recipients",
+			undisclosed:  true,
+			fields:       map[string]interface{}{"bcc": []string{"archive@example.com"}},
+			to:           []string{"jane@example.org"},
+			recipients:   []string{"jane@example.org", "archive@example.com"},
+			withToHeader: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			messages := sendTestMessage(t, newTestTemplates(), Options{UndisclosedRecipients: test.undisclosed}, testMessageBody(test.fields))
+			if len(messages) != 1 {
+				t.Fatalf("expected a single message, got %d", len(messages))
+			}
+			if strings.Join(messages[0].Recipients, ",") != strings.Join(test.recipients, ",") {
+				t.Errorf("expected recipients %v, got %v", test.recipients, messages[0].Recipients)
+			}
+
+			header, _ := parseTestMessage(t, messages[0])
+			to, ok := header["To"]
+			if ok != test.withToHeader || strings.Join(to, ",") != strings.Join(test.to, ",") {
+				t.Errorf("expected To header %v, got %v", test.to, to)
+			}
+			if _, ok := header["Bcc"]; ok {
+				t.Errorf("expected no Bcc header, got %v", header["Bcc"])
+			}
+		})
+	}
+}
+
+func TestValidateMailMessageRequiresARecipient(t *testing.T) {
+	tests := []struct {
+		name   string
+		fields map[string]interface{}
+		err    bool
+	}{
+		{name: "no recipient", fields: map[string]interface{}{"to": nil}, err: true},
+		{name: "empty recipient lists", fields: map[string]interface{}{"to": []string{}, "cc": []string{}, "bcc": []string{}}, err: true},
+		{name: "bcc only", fields: map[string]interface{}{"to": nil, "bcc": []string{"archive@example.com"}}},
+		{name: "cc only", fields: map[string]interface{}{"to": nil, "cc": []string{"audit@example.com"}}},
+		{name: "to_address only", fields: map[string]interface{}{"to": nil, "to_address": "jane@example.org"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mailMsg, err := decodeMailMessage(testMessageBody(test.fields), Options{})
+			if err != nil {
+				t.Fatalf("unable to decode: %s", err.Error())
+			}
+			err = validateMailMessage(mailMsg, Options{})
+			if test.err {
+				if err == nil || !strings.Contains(err.Error(), "message has no recipient") {
+					t.Errorf("expected a missing recipient error, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("unexpected error: %s", err.Error())
+			}
+		})
+	}
+}
+
+func TestSendMailDateHeader(t *testing.T) {
+	clock := time.Date(2020, time.October, 14, 7, 30, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		date     interface{}
+		clock    func() time.Time
+		expected string
+		err      string
+	}{
+		{name: "uses the date of the message", date: "2020-10-14T09:30:00+02:00", expected: "Wed, 14 Oct 2020 09:30:00 +0200"},
+		{name: "prefers the date of the message to the clock", date: "2020-10-15T08:00:00Z", clock: func() time.Time { return clock }, expected: "Thu, 15 Oct 2020 08:00:00 +0000"},
+		{name: "uses the configured clock", clock: func() time.Time { return clock }, expected: "Wed, 14 Oct 2020 07:30:00 +0000"},
+		{name: "rejects a date which is not RFC 3339", date: "14/10/2020 09:30", err: "invalid date, expecting an RFC 3339 date"},
+		{name: "rejects a date without time zone", date: "2020-10-14T09:30:00", err: "invalid date, expecting an RFC 3339 date"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake := transport.NewFake()
+			body := testMessageBody(map[string]interface{}{"date": test.date})
+			_, err := SendMail(context.Background(), newTestTemplates(), newTestTemplates(), fake, Options{Clock: test.clock}, body)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected error %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unable to send message: %s", err.Error())
+			}
+			header, _ := parseTestMessage(t, fake.Messages()[0])
+			if date := header.Get("Date"); date != test.expected {
+				t.Errorf("expected Date %q, got %q", test.expected, date)
+			}
+		})
+	}
+}
+
+func TestSendMailDateHeaderDefaultsToNow(t *testing.T) {
+	before := time.Now().Truncate(time.Second)
+	messages := sendTestMessage(t, newTestTemplates(), Options{}, testMessageBody(nil))
+	after := time.Now()
+
+	header, _ := parseTestMessage(t, messages[0])
+	date, err := header.Date()
+	if err != nil {
+		t.Fatalf("expected a Date header, got %q: %s", header.Get("Date"), err.Error())
+	}
+	if date.Before(before) || date.After(after) {
+		t.Errorf("expected a Date between %s and %s, got %s", before, after, date)
+	}
+}
+
+func TestSendMailForceFromName(t *testing.T) {
+	tests := []struct {
+		name     string
+		fields   map[string]interface{}
+		opts     Options
+		expected mail.Address
+		logged   bool
+	}{
+		{
+			name:     "keeps the message from name without override",
+			fields:   map[string]interface{}{"from_name": "Producer"},
+			expected: mail.Address{Name: "Producer", Address: "noreply@example.com"},
+		},
+		{
+			name:     "overrides the message from name",
+			fields:   map[string]interface{}{"from_name": "Producer"},
+			opts:     Options{ForceFromName: "Forsam"},
+			expected: mail.Address{Name: "Forsam", Address: "noreply@example.com"},
+			logged:   true,
+		},
+		{
+			name:     "names a message without from name",
+			opts:     Options{ForceFromName: "Forsam"},
+			expected: mail.Address{Name: "Forsam", Address: "noreply@example.com"},
+			logged:   true,
+		},
+		{
+			name:     "overrides the default from name",
+			fields:   map[string]interface{}{"from_address": nil},
+			opts:     Options{ForceFromName: "Forsam", DefaultFromAddress: "hello@example.com", DefaultFromName: "Default"},
+			expected: mail.Address{Name: "Forsam", Address: "hello@example.com"},
+			logged:   true,
+		},
+		{
+			name:     "overrides the tenant from name",
+			fields:   map[string]interface{}{"from_address": nil, "tenant": "acme"},
+			opts:     Options{ForceFromName: "Forsam", DefaultFromAddress: "hello@example.com", Tenants: map[string]*Tenant{"acme": {DefaultFromAddress: "hello@acme.example", DefaultFromName: "Acme"}}},
+			expected: mail.Address{Name: "Forsam", Address: "hello@acme.example"},
+			logged:   true,
+		},
+		{
+			name:     "does not log an identical name",
+			fields:   map[string]interface{}{"from_name": "Forsam"},
+			opts:     Options{ForceFromName: "Forsam"},
+			expected: mail.Address{Name: "Forsam", Address: "noreply@example.com"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var output bytes.Buffer
+			log.SetOutput(&output)
+			defer log.SetOutput(os.Stderr)
+
+			header, _ := parseTestMessage(t, sendTestMessage(t, newTestTemplates(), test.opts, testMessageBody(test.fields))[0])
+			from, err := mail.ParseAddress(header.Get("From"))
+			if err != nil {
+				t.Fatalf("expected a valid From header, got %q", header.Get("From"))
+			}
+			if *from != test.expected {
+				t.Errorf("expected the sender %+v, got %+v", test.expected, *from)
+			}
+			if logged := strings.Contains(output.String(), "Overriding from name"); logged != test.logged {
+				t.Errorf("expected the override to be logged: %t, got %q", test.logged, output.String())
+			}
+		})
+	}
+}
+
+func TestSendMailNoCache(t *testing.T) {
+	templates := newTestTemplates()
+	cache := storage.NewCache(templates, time.Hour)
+	textBody := func(noCache bool) string {
+		fake := transport.NewFake()
+		body := testMessageBody(map[string]interface{}{"no_cache": noCache})
+		if _, err := SendMail(context.Background(), cache, templates, fake, Options{}, body); err != nil {
+			t.Fatalf("unable to send message: %s", err.Error())
+		}
+		_, parts := parseTestMessage(t, fake.Messages()[0])
+		return parts[0].body
+	}
+
+	if body := textBody(false); body != "Hello Jane" {
+		t.Fatalf("expected %q, got %q", "Hello Jane", body)
+	}
+	templates.Set("welcome.txt.template", []byte("Welcome {{.name}}"))
+
+	tests := []struct {
+		name     string
+		noCache  bool
+		expected string
+	}{
+		{name: "reads the cached template", expected: "Hello Jane"},
+		{name: "bypasses the cache with no_cache", noCache: true, expected: "Welcome Jane"},
+		{name: "reads the template cached by no_cache", expected: "Welcome Jane"},
+	}
+	for _, test := range tests {
+		if body := textBody(test.noCache); body != test.expected {
+			t.Errorf("%s: expected %q, got %q", test.name, test.expected, body)
+		}
+	}
+}
+
+// warmupStore counts the warmup sends in memory, the warmup starting at the first send.
+type warmupStore struct {
+	start time.Time
+	sends map[int]int
+}
+
+func (store *warmupStore) Start(now time.Time) (time.Time, error) {
+	if store.start.IsZero() {
+		store.start = now
+	}
+
+	return store.start, nil
+}
+
+func (store *warmupStore) Reserve(day int, limit int) (bool, error) {
+	if store.sends[day] >= limit {
+		return false, nil
+	}
+	store.sends[day]++
+
+	return true, nil
+}
+
+func TestSendMailWarmupCap(t *testing.T) {
+	fake := transport.NewFake()
+	opts := Options{Warmup: warmup.NewRamp(warmup.Schedule{2}, &warmupStore{sends: make(map[int]int)})}
+	templates := newTestTemplates()
+
+	for i := 0; i < 3; i++ {
+		_, err := SendMail(context.Background(), templates, templates, fake, opts, testMessageBody(nil))
+		if i < 2 && err != nil {
+			t.Fatalf("expected send %d to be allowed, got %s", i+1, err.Error())
+		}
+		if i == 2 && (err == nil || !strings.Contains(err.Error(), "warmup day 1 cap of 2 sends is reached")) {
+			t.Errorf("expected the third send to be capped, got %v", err)
+		}
+	}
+	if messages := fake.Messages(); len(messages) != 2 {
+		t.Errorf("expected 2 messages to be sent, got %d", len(messages))
+	}
+}
+
+func TestSendMailBodyContentType(t *testing.T) {
+	allowed := []string{"text/plain", " text/markdown"}
+	tests := []struct {
+		name        string
+		contentType interface{}
+		charset     string
+		expected    []string
+		err         string
+	}{
+		{name: "keeps text/plain by default", expected: []string{"text/plain; charset=UTF-8", "text/html; charset=UTF-8"}},
+		{name: "overrides the primary part", contentType: "text/markdown", expected: []string{"text/markdown; charset=UTF-8", "text/html; charset=UTF-8"}},
+		{name: "uses the allowed spelling", contentType: "Text/Markdown", expected: []string{"text/markdown; charset=UTF-8", "text/html; charset=UTF-8"}},
+		{name: "declares the charset of the primary part", contentType: "text/markdown", charset: "ISO-8859-1", expected: []string{"text/markdown; charset=ISO-8859-1", "text/html; charset=UTF-8"}},
+		{name: "rejects a type out of the allowlist", contentType: "application/javascript", err: `body_content_type "application/javascript" is not allowed, expecting one of text/plain,  text/markdown`},
+		{name: "rejects a type with parameters", contentType: "text/markdown; variant=GFM", err: "is not allowed"},
+		{name: "rejects a type injecting a header", contentType: "text/markdown\r\nBcc: eve@example.org", err: "is not allowed"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake := transport.NewFake()
+			templates := newTestTemplates()
+			body := testMessageBody(map[string]interface{}{"body_content_type": test.contentType})
+			_, err := SendMail(context.Background(), templates, templates, fake, Options{BodyContentTypes: allowed, TextCharset: test.charset}, body)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected error %q, got %v", test.err, err)
+				}
+				if len(fake.Messages()) != 0 {
+					t.Errorf("expected nothing to be sent")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unable to send message: %s", err.Error())
+			}
+
+			_, parts := parseTestMessage(t, fake.Messages()[0])
+			var contentTypes []string
+			for _, part := range parts {
+				contentTypes = append(contentTypes, part.header["Content-Type"][0])
+			}
+			if strings.Join(contentTypes, ", ") != strings.Join(test.expected, ", ") {
+				t.Errorf("expected the parts %v, got %v", test.expected, contentTypes)
+			}
+			if parts[0].body != "Hello Jane" {
+				t.Errorf("expected the primary part to be rendered from the TXT template, got %q", parts[0].body)
+			}
+		})
+	}
+}
+
+func TestSendMailBodySource(t *testing.T) {
+	tests := []struct {
+		name     string
+		fields   map[string]interface{}
+		opts     Options
+		expected []string
+		warned   bool
+		err      string
+	}{
+		{
+			name:     "renders the template",
+			expected: []string{"Hello Jane", "<p>Hello Jane</p>"},
+		},
+		{
+			name:     "sends the pre-rendered bodies",
+			fields:   map[string]interface{}{"template_name": nil, "html_body": "<p>Raw</p>", "text_body": "Raw"},
+			expected: []string{"Raw", "<p>Raw</p>"},
+		},
+		{
+			name:     "sends a pre-rendered HTML body alone",
+			fields:   map[string]interface{}{"template_name": nil, "html_body": "<p>Raw</p>"},
+			expected: []string{"<p>Raw</p>"},
+		},
+		{
+			name:     "prefers the pre-rendered bodies to the template",
+			fields:   map[string]interface{}{"html_body": "<p>Raw</p>", "text_body": "Raw"},
+			expected: []string{"Raw", "<p>Raw</p>"},
+			warned:   true,
+		},
+		{
+			name:     "prefers a single pre-rendered body to the template",
+			fields:   map[string]interface{}{"text_body": "Raw"},
+			expected: []string{"Raw"},
+			warned:   true,
+		},
+		{
+			name:   "rejects both with strict JSON",
+			fields: map[string]interface{}{"html_body": "<p>Raw</p>", "text_body": "Raw"},
+			opts:   Options{StrictJSON: true},
+			err:    `message is ambiguous, it has both template_name "welcome" and pre-rendered bodies`,
+		},
+		{
+			name:   "requires a body",
+			fields: map[string]interface{}{"template_name": nil},
+			err:    "message has no body, either template_name or html_body and text_body are required",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var output bytes.Buffer
+			log.SetOutput(&output)
+			defer log.SetOutput(os.Stderr)
+
+			fake := transport.NewFake()
+			templates := newTestTemplates()
+			_, err := SendMail(context.Background(), templates, templates, fake, test.opts, testMessageBody(test.fields))
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected error %q, got %v", test.err, err)
+				}
+				if len(fake.Messages()) != 0 {
+					t.Errorf("expected nothing to be sent")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unable to send message: %s", err.Error())
+			}
+
+			_, parts := parseTestMessage(t, fake.Messages()[0])
+			var bodies []string
+			for _, part := range parts {
+				bodies = append(bodies, part.body)
+			}
+			if !reflect.DeepEqual(bodies, test.expected) {
+				t.Errorf("expected the bodies %q, got %q", test.expected, bodies)
+			}
+			if warned := strings.Contains(output.String(), `Message has both template_name "welcome" and pre-rendered bodies`); warned != test.warned {
+				t.Errorf("expected a warning to be logged: %t, got %q", test.warned, output.String())
+			}
+		})
+	}
+}
+
+func TestSendMailLogsNoMessageContent(t *testing.T) {
+	injector, err := tracking.NewInjector("secret", "https://t.example.com/click/{{.ID}}/{{.Link}}?url={{.URL}}&sig={{.Signature}}", "")
+	if err != nil {
+		t.Fatalf("unable to instantiate injector: %s", err.Error())
+	}
+	tests := []struct {
+		name     string
+		tracking *tracking.Injector
+		fields   map[string]interface{}
+		entries  []map[string]interface{}
+	}{
+		{name: "logs nothing for an untracked message"},
+		{
+			name:     "logs the tracking id of a tracked message",
+			tracking: injector,
+			fields:   map[string]interface{}{"tracking_id": "track-42"},
+			entries:  []map[string]interface{}{{"level": "info", "message": "Message tracked", "template": "welcome", "recipient_domain": "example.org", "tracking_id": "track-42"}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var output bytes.Buffer
+			log.SetOutput(&output)
+			defer log.SetOutput(os.Stderr)
+			var structured bytes.Buffer
+			opts := Options{Tracking: test.tracking, Logger: logging.NewLogger(logging.LevelDebug, true, &structured)}
+
+			fields := map[string]interface{}{"template_context": map[string]interface{}{"name": "Jane", "iban": "FR7630006000011234567890189"}}
+			for key, value := range test.fields {
+				fields[key] = value
+			}
+			sendTestMessage(t, newTestTemplates(), opts, testMessageBody(fields))
+
+			for _, private := range []string{"jane@example.org", "Jane", "FR7630006000011234567890189", "support@example.com"} {
+				if strings.Contains(output.String(), private) || strings.Contains(structured.String(), private) {
+					t.Errorf("expected %q not to be logged, got %q and %q", private, output.String(), structured.String())
+				}
+			}
+			var entries []map[string]interface{}
+			for _, line := range strings.Split(strings.TrimSpace(structured.String()), "\n") {
+				if line == "" {
+					continue
+				}
+				var entry map[string]interface{}
+				if err := json.Unmarshal([]byte(line), &entry); err != nil {
+					t.Fatalf("expected a JSON line, got %q", line)
+				}
+				delete(entry, "time")
+				entries = append(entries, entry)
+			}
+			if !reflect.DeepEqual(entries, test.entries) {
+				t.Errorf("expected entries %v, got %v", test.entries, entries)
+			}
+		})
+	}
+}
@@ -0,0 +1,186 @@
+package mailmessage
+
+import (
+	"fmt"
+	"gopkg.in/gomail.v2"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Methods of the calendar invites, as defined by RFC 5546.
+const (
+	CalendarMethodRequest = "REQUEST"
+	CalendarMethodCancel  = "CANCEL"
+	CalendarMethodPublish = "PUBLISH"
+)
+
+// icsDateFormat is the UTC date-time form of the iCalendar properties.
+const icsDateFormat = "20060102T150405Z"
+
+// icsLineLength is the maximum length in octets of an iCalendar content line, longer lines being folded.
+const icsLineLength = 75
+
+// calendarEvent is the calendar_event object of a message, sent as an iCalendar invite, ie:
+// {"uid": "meeting-42@forsam.education", "start": "2020-11-02T14:00:00Z", "end": "2020-11-02T15:00:00Z", "organizer": {"address": "zoe@forsam.education"}}.
+type calendarEvent struct {
+	UID         string         `json:"uid"`
+	Method      string         `json:"method,omitempty"`
+	Sequence    int            `json:"sequence,omitempty"`
+	Start       string         `json:"start"`
+	End         string         `json:"end"`
+	Summary     string         `json:"summary,omitempty"`
+	Description string         `json:"description,omitempty"`
+	Location    string         `json:"location,omitempty"`
+	Organizer   namedAddress   `json:"organizer"`
+	Attendees   []namedAddress `json:"attendees,omitempty"`
+
+	start time.Time
+	end   time.Time
+}
+
+// validateCalendarEvent checks the event dates, method and addresses, defaulting the method to REQUEST.
+func validateCalendarEvent(event *calendarEvent) error {
+	if strings.TrimSpace(event.UID) == "" {
+		return fmt.Errorf("uid is required")
+	}
+
+	event.Method = strings.ToUpper(event.Method)
+	switch event.Method {
+	case "":
+		event.Method = CalendarMethodRequest
+	case CalendarMethodRequest, CalendarMethodCancel, CalendarMethodPublish:
+	default:
+		return fmt.Errorf("method %q is unknown, expecting %s, %s or %s", event.Method, CalendarMethodRequest, CalendarMethodCancel, CalendarMethodPublish)
+	}
+
+	var err error
+	if event.start, err = time.Parse(time.RFC3339, event.Start); err != nil {
+		return fmt.Errorf("invalid start, expecting an RFC 3339 date: %s", err.Error())
+	}
+	if event.end, err = time.Parse(time.RFC3339, event.End); err != nil {
+		return fmt.Errorf("invalid end, expecting an RFC 3339 date: %s", err.Error())
+	}
+	if !event.end.After(event.start) {
+		return fmt.Errorf("end must be after start")
+	}
+
+	if !isBareAddress(event.Organizer.Address) {
+		return fmt.Errorf("organizer address %q is not a valid address", event.Organizer.Address)
+	}
+	for _, attendee := range event.Attendees {
+		if !isBareAddress(attendee.Address) {
+			return fmt.Errorf("attendee address %q is not a valid address", attendee.Address)
+		}
+	}
+
+	return nil
+}
+
+// escapeICSText escapes the special characters of an iCalendar text value.
+func escapeICSText(text string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`).Replace(text)
+}
+
+// quoteICSParam quotes an iCalendar parameter value, which can't hold double quotes.
+func quoteICSParam(value string) string {
+	return `"` + strings.Replace(value, `"`, "'", -1) + `"`
+}
+
+// foldICSLine splits a content line longer than 75 octets into CRLF and space separated lines, without splitting UTF-8 characters.
+func foldICSLine(line string) string {
+	var folded strings.Builder
+	length := 0
+	for _, char := range line {
+		size := len(string(char))
+		if length+size > icsLineLength {
+			folded.WriteString("\r\n ")
+			length = 1
+		}
+		folded.WriteRune(char)
+		length += size
+	}
+	folded.WriteString("\r\n")
+
+	return folded.String()
+}
+
+// icsAddress returns the address property of the organizer or an attendee, with its display name as CN parameter.
+func icsAddress(property string, params string, address namedAddress) string {
+	if address.Name != "" {
+		params += ";CN=" + quoteICSParam(address.Name)
+	}
+
+	return property + params + ":mailto:" + address.Address
+}
+
+// serializeCalendarEvent returns the iCalendar object of the event. The attendees default to the To recipients of the message.
+func serializeCalendarEvent(mailMsg *mailMessage) string {
+	event := mailMsg.CalendarEvent
+	summary := event.Summary
+	if summary == "" {
+		summary = mailMsg.Subject
+	}
+	attendees := event.Attendees
+	if len(attendees) == 0 {
+		for _, address := range mailMsg.to.envelope {
+			attendees = append(attendees, namedAddress{Address: address})
+		}
+	}
+
+	lines := []string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"PRODID:-//forsam-education//hermes//EN",
+		"METHOD:" + event.Method,
+		"BEGIN:VEVENT",
+		"UID:" + escapeICSText(event.UID),
+		"SEQUENCE:" + strconv.Itoa(event.Sequence),
+		"DTSTAMP:" + mailMsg.date.UTC().Format(icsDateFormat),
+		"DTSTART:" + event.start.UTC().Format(icsDateFormat),
+		"DTEND:" + event.end.UTC().Format(icsDateFormat),
+		"SUMMARY:" + escapeICSText(summary),
+	}
+	if event.Description != "" {
+		lines = append(lines, "DESCRIPTION:"+escapeICSText(event.Description))
+	}
+	if event.Location != "" {
+		lines = append(lines, "LOCATION:"+escapeICSText(event.Location))
+	}
+	lines = append(lines, icsAddress("ORGANIZER", "", event.Organizer))
+	for _, attendee := range attendees {
+		lines = append(lines, icsAddress("ATTENDEE", ";ROLE=REQ-PARTICIPANT;PARTSTAT=NEEDS-ACTION;RSVP=TRUE", attendee))
+	}
+	if event.Method == CalendarMethodCancel {
+		lines = append(lines, "STATUS:CANCELLED")
+	} else {
+		lines = append(lines, "STATUS:CONFIRMED")
+	}
+	lines = append(lines, "END:VEVENT", "END:VCALENDAR")
+
+	var ics strings.Builder
+	for _, line := range lines {
+		ics.WriteString(foldICSLine(line))
+	}
+
+	return ics.String()
+}
+
+// addCalendarEvent adds the invite of the message, if any, as a text/calendar alternative part rendered as an invitation by the mail clients,
+// and as an invite.ics attachment for those which don't.
+func addCalendarEvent(message *gomail.Message, mailMsg *mailMessage) {
+	if mailMsg.CalendarEvent == nil {
+		return
+	}
+
+	ics := serializeCalendarEvent(mailMsg)
+	message.AddAlternative("text/calendar; method="+mailMsg.CalendarEvent.Method, ics)
+	message.Attach("invite.ics",
+		gomail.SetHeader(map[string][]string{"Content-Type": {"application/ics; name=\"invite.ics\""}}),
+		gomail.SetCopyFunc(func(writer io.Writer) error {
+			_, err := io.WriteString(writer, ics)
+			return err
+		}),
+	)
+}
@@ -0,0 +1,89 @@
+package mailmessage
+
+import (
+	"bytes"
+	"fmt"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/ianaindex"
+	"gopkg.in/gomail.v2"
+	"io"
+	"sort"
+	"strings"
+)
+
+// defaultCharset is the charset gomail declares for every part.
+const defaultCharset = "UTF-8"
+
+// isDefaultCharset tells if the charset is the one already used by gomail, so the part needs no transcoding.
+func isDefaultCharset(charset string) bool {
+	return charset == "" || strings.EqualFold(charset, defaultCharset)
+}
+
+// transcode converts the rendered UTF-8 body to the given charset.
+// When escapeHTML is set, characters the charset can't represent are written as HTML numeric character references,
+// otherwise they make the transcoding fail.
+func transcode(body string, charset string, escapeHTML bool) (string, error) {
+	if isDefaultCharset(charset) {
+		return body, nil
+	}
+	// The IANA index is used rather than the HTML one, which maps ISO-8859-1 to Windows-1252 as browsers do.
+	enc, err := ianaindex.MIME.Encoding(charset)
+	if err != nil || enc == nil {
+		return "", fmt.Errorf("unknown charset %q", charset)
+	}
+
+	encoder := enc.NewEncoder()
+	if escapeHTML {
+		encoder = encoding.HTMLEscapeUnsupported(encoder)
+	}
+	transcoded, err := encoder.String(body)
+	if err != nil {
+		return "", fmt.Errorf("body can't be represented in charset %q: %s", charset, err.Error())
+	}
+
+	return transcoded, nil
+}
+
+// charsetMessage writes a gomail message while declaring the configured charset of its text and HTML parts,
+// since gomail uses a single charset for the whole message, along with the headers gomail can't write unencoded.
+type charsetMessage struct {
+	*gomail.Message
+	textType    string
+	textCharset string
+	htmlCharset string
+	headers     map[string][]string
+}
+
+// WriteTo dumps the whole message into w, replacing the charset declaration of the first primary and HTML parts.
+func (msg *charsetMessage) WriteTo(w io.Writer) (int64, error) {
+	if isDefaultCharset(msg.textCharset) && isDefaultCharset(msg.htmlCharset) && len(msg.headers) == 0 {
+		return msg.Message.WriteTo(w)
+	}
+
+	var rawMessage bytes.Buffer
+	fields := make([]string, 0, len(msg.headers))
+	for field := range msg.headers {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	for _, field := range fields {
+		// Each value is written on its own line, as the unencoded values can't be folded by gomail.
+		rawMessage.WriteString(field + ": " + strings.Join(msg.headers[field], ",\r\n ") + "\r\n")
+	}
+	if _, err := msg.Message.WriteTo(&rawMessage); err != nil {
+		return 0, err
+	}
+
+	raw := rawMessage.Bytes()
+	for contentType, charset := range map[string]string{msg.textType: msg.textCharset, "text/html": msg.htmlCharset} {
+		if isDefaultCharset(charset) {
+			continue
+		}
+		declaration := []byte("Content-Type: " + contentType + "; charset=" + defaultCharset + "\r\n")
+		raw = bytes.Replace(raw, declaration, []byte("Content-Type: "+contentType+"; charset="+charset+"\r\n"), 1)
+	}
+
+	n, err := w.Write(raw)
+
+	return int64(n), err
+}
@@ -0,0 +1,122 @@
+package mailmessage
+
+import (
+	"context"
+	"github.com/forsam-education/hermes/transport"
+	"mime"
+	"strings"
+	"testing"
+)
+
+func TestTranscode(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		charset    string
+		escapeHTML bool
+		expected   string
+		err        string
+	}{
+		{name: "keeps UTF-8", body: "Café €", charset: "UTF-8", expected: "Café €"},
+		{name: "keeps the default charset", body: "Café €", expected: "Café €"},
+		{name: "transcodes representable content", body: "Café à Noël", charset: "ISO-8859-1", expected: "Caf\xe9 \xe0 No\xebl"},
+		{name: "transcodes with a lowercase charset name", body: "Café", charset: "iso-8859-1", expected: "Caf\xe9"},
+		{name: "keeps ASCII content", body: "Hello Jane", charset: "ISO-8859-1", expected: "Hello Jane"},
+		{name: "fails on unrepresentable content", body: "Total: 12 €", charset: "ISO-8859-1", err: `body can't be represented in charset "ISO-8859-1"`},
+		{name: "fails on unrepresentable emoji", body: "Bravo 🎉", charset: "ISO-8859-1", err: `body can't be represented in charset "ISO-8859-1"`},
+		{name: "escapes unrepresentable HTML content", body: "<p>Total: 12 €</p>", charset: "ISO-8859-1", escapeHTML: true, expected: "<p>Total: 12 &#8364;</p>"},
+		{name: "transcodes to Windows-1252", body: "Total: 12 €", charset: "windows-1252", expected: "Total: 12 \x80"},
+		{name: "fails on an unknown charset", body: "Hello", charset: "klingon", err: `unknown charset "klingon"`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			transcoded, err := transcode(test.body, test.charset, test.escapeHTML)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected error %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+			if transcoded != test.expected {
+				t.Errorf("expected %q, got %q", test.expected, transcoded)
+			}
+		})
+	}
+}
+
+func TestSendMailPartCharsets(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     Options
+		text     string
+		html     string
+		charsets map[string]string
+		bodies   map[string]string
+		err      string
+	}{
+		{
+			name:     "transcodes the text part only",
+			opts:     Options{TextCharset: "ISO-8859-1"},
+			text:     "Bienvenue à {{.name}}",
+			html:     "<p>Bienvenue à {{.name}} €</p>",
+			charsets: map[string]string{"text/plain": "ISO-8859-1", "text/html": "UTF-8"},
+			bodies:   map[string]string{"text/plain": "Bienvenue \xe0 Jane", "text/html": "<p>Bienvenue à Jane €</p>"},
+		},
+		{
+			name:     "transcodes the HTML part escaping what it can't represent",
+			opts:     Options{HTMLCharset: "ISO-8859-1"},
+			text:     "Bienvenue à {{.name}} €",
+			html:     "<p>Bienvenue à {{.name}} €</p>",
+			charsets: map[string]string{"text/plain": "UTF-8", "text/html": "ISO-8859-1"},
+			bodies:   map[string]string{"text/plain": "Bienvenue à Jane €", "text/html": "<p>Bienvenue \xe0 Jane &#8364;</p>"},
+		},
+		{
+			name: "fails on a text part it can't represent",
+			opts: Options{TextCharset: "ISO-8859-1"},
+			text: "Total: 12 €",
+			html: "<p>Total: 12 €</p>",
+			err:  `can't be represented in charset "ISO-8859-1"`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			templates := newTestTemplates()
+			templates.Set("welcome.txt.template", []byte(test.text))
+			templates.Set("welcome.html.template", []byte(test.html))
+			fake := transport.NewFake()
+
+			_, err := SendMail(context.Background(), templates, templates, fake, test.opts, testMessageBody(nil))
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected error %q, got %v", test.err, err)
+				}
+				if len(fake.Messages()) != 0 {
+					t.Errorf("expected nothing sent, got %d messages", len(fake.Messages()))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unable to send: %s", err.Error())
+			}
+
+			_, parts := parseTestMessage(t, fake.Messages()[0])
+			if len(parts) != 2 {
+				t.Fatalf("expected a text and an HTML part, got %d parts", len(parts))
+			}
+			for _, part := range parts {
+				_, params, _ := mime.ParseMediaType(part.header["Content-Type"][0])
+				if params["charset"] != test.charsets[part.contentType] {
+					t.Errorf("expected the %s part in %s, got %s", part.contentType, test.charsets[part.contentType], params["charset"])
+				}
+				if part.body != test.bodies[part.contentType] {
+					t.Errorf("expected the %s part %q, got %q", part.contentType, test.bodies[part.contentType], part.body)
+				}
+			}
+		})
+	}
+}
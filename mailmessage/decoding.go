@@ -0,0 +1,179 @@
+package mailmessage
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/mail"
+	"strings"
+	"time"
+)
+
+// checkContextSize measures the raw JSON of the template context, so an oversized one is rejected before being decoded into memory.
+func checkContextSize(messageBody string, maxContextBytes int) error {
+	var rawMsg struct {
+		TemplateContext json.RawMessage `json:"template_context"`
+	}
+	if err := json.Unmarshal([]byte(messageBody), &rawMsg); err != nil {
+		return fmt.Errorf("unable tu unmarshal email: %s", err.Error())
+	}
+	if len(rawMsg.TemplateContext) > maxContextBytes {
+		return fmt.Errorf("template context is %d bytes long, above the %d bytes limit", len(rawMsg.TemplateContext), maxContextBytes)
+	}
+
+	return nil
+}
+
+// decodeStrictly decodes the JSON data, failing on the fields unknown to v.
+func decodeStrictly(data []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+
+	return decoder.Decode(v)
+}
+
+// checkRecipientFields checks the object recipients of an address field have no unknown field.
+func checkRecipientFields(rawRecipients json.RawMessage) error {
+	var entries []json.RawMessage
+	if len(rawRecipients) == 0 || rawRecipients[0] != '[' || json.Unmarshal(rawRecipients, &entries) != nil {
+		return nil
+	}
+	for i, entry := range entries {
+		if len(entry) > 0 && entry[0] == '{' {
+			if err := decodeStrictly(entry, &namedAddress{}); err != nil {
+				return fmt.Errorf("recipient %d: %s", i, err.Error())
+			}
+		}
+	}
+
+	return nil
+}
+
+// checkNestedFields checks the recipient and attachment objects have no unknown field, as their own decoding can't be made strict.
+func checkNestedFields(messageBody string) error {
+	var rawMsg struct {
+		To           json.RawMessage   `json:"to"`
+		CC           json.RawMessage   `json:"cc"`
+		BCC          json.RawMessage   `json:"bcc"`
+		Attachments  []json.RawMessage `json:"attachments"`
+		InlineImages []json.RawMessage `json:"inline_images"`
+	}
+	if err := json.Unmarshal([]byte(messageBody), &rawMsg); err != nil {
+		return nil
+	}
+
+	fields := []string{"to", "cc", "bcc"}
+	for i, rawRecipients := range []json.RawMessage{rawMsg.To, rawMsg.CC, rawMsg.BCC} {
+		if err := checkRecipientFields(rawRecipients); err != nil {
+			return fmt.Errorf("unable tu unmarshal email: %s %s", fields[i], err.Error())
+		}
+	}
+	type attachmentObject attachment
+	fields = []string{"attachments", "inline_images"}
+	for i, rawAttachments := range [][]json.RawMessage{rawMsg.Attachments, rawMsg.InlineImages} {
+		for j, entry := range rawAttachments {
+			if len(entry) > 0 && entry[0] == '{' {
+				if err := decodeStrictly(entry, &attachmentObject{}); err != nil {
+					return fmt.Errorf("unable tu unmarshal email: %s %d: %s", fields[i], j, err.Error())
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// decodeMailMessage decodes the message body, keeping the template context numbers as json.Number.
+// In strict mode, a field unknown to the message format fails the decoding, so producers notice their typos.
+func decodeMailMessage(messageBody string, opts Options) (*mailMessage, error) {
+	if opts.MaxContextBytes > 0 {
+		if err := checkContextSize(messageBody, opts.MaxContextBytes); err != nil {
+			return nil, err
+		}
+	}
+
+	var mailMsg mailMessage
+	decoder := json.NewDecoder(strings.NewReader(messageBody))
+	decoder.UseNumber()
+	if opts.StrictJSON {
+		decoder.DisallowUnknownFields()
+	}
+	if err := decoder.Decode(&mailMsg); err != nil {
+		return nil, fmt.Errorf("unable tu unmarshal email: %s", err.Error())
+	}
+	if opts.StrictJSON {
+		if err := checkNestedFields(messageBody); err != nil {
+			return nil, err
+		}
+	}
+
+	return &mailMsg, nil
+}
+
+// DescribeMessage returns the template name and the domain of the main recipient of a message body, for logs and metrics.
+// They are empty when the body can't be decoded or has no such field.
+func DescribeMessage(messageBody string) (string, string) {
+	templateName, recipient := DescribeRecipient(messageBody)
+	if recipient == "" {
+		return templateName, ""
+	}
+
+	return templateName, domainOf(recipient)
+}
+
+// DescribeRecipient returns the template name and the address of the main recipient of a message body, for the published outcomes.
+// They are empty when the body can't be decoded or has no such field, the message of a signed body being described without verifying it.
+func DescribeRecipient(messageBody string) (string, string) {
+	var mailMsg mailMessage
+	if err := json.Unmarshal([]byte(unsignedMessage(messageBody)), &mailMsg); err != nil {
+		return "", ""
+	}
+
+	return mailMsg.Template, mailMsg.mainRecipient()
+}
+
+// mainRecipient returns the address of the first valid recipient of the message, from to_address then to, cc and bcc, or an empty string.
+func (mailMsg *mailMessage) mainRecipient() string {
+	var recipients []string
+	if mailMsg.ToAddress != "" {
+		recipients = append(recipients, mailMsg.ToAddress)
+	}
+	for _, list := range []recipientList{mailMsg.To, mailMsg.CC, mailMsg.BCC} {
+		recipients = append(recipients, list...)
+	}
+	for _, recipient := range recipients {
+		if address, err := mail.ParseAddress(recipient); err == nil {
+			return address.Address
+		}
+	}
+
+	return ""
+}
+
+// IdempotencyKey returns the idempotency key given by the producer of a message body, or an empty string if it has none or can't be decoded.
+func IdempotencyKey(messageBody string) string {
+	var mailMsg struct {
+		IdempotencyKey string `json:"idempotency_key"`
+	}
+	if err := json.Unmarshal([]byte(messageBody), &mailMsg); err != nil {
+		return ""
+	}
+
+	return mailMsg.IdempotencyKey
+}
+
+// SendAt returns the date a message body is scheduled at, or the zero time if it has none or it can't be decoded.
+func SendAt(messageBody string) time.Time {
+	var mailMsg struct {
+		SendAt string `json:"send_at"`
+	}
+	if err := json.Unmarshal([]byte(messageBody), &mailMsg); err != nil || mailMsg.SendAt == "" {
+		return time.Time{}
+	}
+	sendAt, err := time.Parse(time.RFC3339, mailMsg.SendAt)
+	if err != nil {
+		return time.Time{}
+	}
+
+	return sendAt
+}
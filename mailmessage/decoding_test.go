@@ -0,0 +1,167 @@
+package mailmessage
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/forsam-education/hermes/transport"
+	"strings"
+	"testing"
+)
+
+// contextOfSize returns a raw JSON template context exactly size bytes long, size being at least 12.
+func contextOfSize(size int) string {
+	return `{"name":"` + strings.Repeat("a", size-11) + `"}`
+}
+
+func TestDecodeMailMessageContextSize(t *testing.T) {
+	tests := []struct {
+		name    string
+		limit   int
+		context string
+		err     string
+	}{
+		{name: "below the limit", limit: 64, context: contextOfSize(63)},
+		{name: "at the limit", limit: 64, context: contextOfSize(64)},
+		{name: "above the limit", limit: 64, context: contextOfSize(65), err: "template context is 65 bytes long, above the 64 bytes limit"},
+		{name: "far above the limit", limit: 64, context: contextOfSize(1 << 20), err: "template context is 1048576 bytes long, above the 64 bytes limit"},
+		{name: "spaces count in the size", limit: 64, context: `{"name":"a"` + strings.Repeat(" ", 60) + `}`, err: "template context is 72 bytes long, above the 64 bytes limit"},
+		{name: "without limit", limit: 0, context: contextOfSize(1 << 20)},
+		{name: "without context", limit: 64, context: ""},
+		{name: "null context", limit: 4, context: "null"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			body := `{"template_name":"welcome","to":["jane@example.org"]}`
+			if test.context != "" {
+				body = `{"template_name":"welcome","to":["jane@example.org"],"template_context":` + test.context + `}`
+			}
+			_, err := decodeMailMessage(body, Options{MaxContextBytes: test.limit})
+			if test.err == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %s", err.Error())
+				}
+				return
+			}
+			if err == nil || err.Error() != test.err {
+				t.Errorf("expected error %q, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestSendMailRejectsOversizedContext(t *testing.T) {
+	tests := []struct {
+		name   string
+		limit  int
+		failed bool
+	}{
+		{name: "at the limit", limit: len(`{"name":"Jane"}`)},
+		{name: "above the limit", limit: len(`{"name":"Jane"}`) - 1, failed: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake := transport.NewFake()
+			templates := newTestTemplates()
+			body := testMessageBody(map[string]interface{}{"template_context": json.RawMessage(`{"name":"Jane"}`)})
+
+			_, err := SendMail(context.Background(), templates, templates, fake, Options{MaxContextBytes: test.limit}, body)
+			if !test.failed {
+				if err != nil || len(fake.Messages()) != 1 {
+					t.Errorf("expected the message to be sent, got %v", err)
+				}
+				return
+			}
+			if err == nil || ErrorPhase(err) != PhaseDecode || !strings.Contains(err.Error(), "above the") {
+				t.Errorf("expected an invalid message error, got %v", err)
+			}
+			if len(fake.Messages()) != 0 {
+				t.Errorf("expected nothing sent, got %d messages", len(fake.Messages()))
+			}
+		})
+	}
+}
+
+func TestDecodeMailMessageUnknownFields(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		err  string
+	}{
+		{name: "known fields", body: `{"template_name":"welcome","to":["jane@example.org"],"template_context":{"anything":{"goes":true}}}`},
+		{name: "unknown field", body: `{"template_name":"welcome","to":["jane@example.org"],"templat_context":{}}`, err: `unable tu unmarshal email: json: unknown field "templat_context"`},
+		{name: "unknown calendar event field", body: `{"template_name":"welcome","to":["jane@example.org"],"calendar_event":{"uid":"42","strat":"2020-01-01T10:00:00Z"}}`, err: `json: unknown field "strat"`},
+		{name: "unknown recipient field", body: `{"template_name":"welcome","to":["john@example.org",{"address":"jane@example.org","nmae":"Jane"}]}`, err: `unable tu unmarshal email: to recipient 1: json: unknown field "nmae"`},
+		{name: "unknown bcc recipient field", body: `{"template_name":"welcome","to":"john@example.org","bcc":[{"address":"jane@example.org","display_name":"Jane"}]}`, err: `unable tu unmarshal email: bcc recipient 0: json: unknown field "display_name"`},
+		{name: "unknown attachment field", body: `{"template_name":"welcome","to":["jane@example.org"],"attachments":["invoices/41.pdf",{"key":"invoices/42.pdf","filname":"invoice.pdf"}]}`, err: `unable tu unmarshal email: attachments 1: json: unknown field "filname"`},
+		{name: "unknown inline image field", body: `{"template_name":"welcome","to":["jane@example.org"],"inline_images":[{"key":"logo.png","cid":"logo"}]}`, err: `unable tu unmarshal email: inline_images 0: json: unknown field "cid"`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := decodeMailMessage(test.body, Options{}); err != nil {
+				t.Errorf("expected the lenient mode to ignore unknown fields, got %s", err.Error())
+			}
+
+			_, err := decodeMailMessage(test.body, Options{StrictJSON: true})
+			if test.err == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %s", err.Error())
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("expected error %q, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestExpandFanOutUnknownFields(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries string
+		err     string
+	}{
+		{name: "known fields", entries: `[{"to":["jane@example.org"],"context":{"name":"Jane"}}]`},
+		{name: "unknown entry field", entries: `[{"to":["jane@example.org"],"contxt":{"name":"Jane"}}]`, err: `unable to decode fan_out: json: unknown field "contxt"`},
+		{name: "unknown entry recipient field", entries: `[{"to":["john@example.org"]},{"to":[{"address":"jane@example.org","nmae":"Jane"}]}]`, err: `unable to decode fan_out entry 1: to recipient 0: json: unknown field "nmae"`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			body := `{"template_name":"welcome","fan_out":` + test.entries + `}`
+			if _, err := ExpandFanOut(body, Options{}); err != nil {
+				t.Errorf("expected the lenient mode to ignore unknown fields, got %s", err.Error())
+			}
+
+			_, err := ExpandFanOut(body, Options{StrictJSON: true})
+			if test.err == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %s", err.Error())
+				}
+				return
+			}
+			if err == nil || err.Error() != test.err || ErrorPhase(err) != PhaseDecode {
+				t.Errorf("expected decode error %q, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestSendMailStrictJSON(t *testing.T) {
+	body := testMessageBody(map[string]interface{}{"subjet": "Welcome!"})
+
+	if messages := sendTestMessage(t, newTestTemplates(), Options{}, body); len(messages) != 1 {
+		t.Fatalf("expected the message to be sent in lenient mode, got %d messages", len(messages))
+	}
+	fake := transport.NewFake()
+	_, err := SendMail(context.Background(), newTestTemplates(), newTestTemplates(), fake, Options{StrictJSON: true}, body)
+	if err == nil || !strings.Contains(err.Error(), `unknown field "subjet"`) || ErrorPhase(err) != PhaseDecode {
+		t.Errorf("expected a decode error on the unknown field, got %v", err)
+	}
+	if len(fake.Messages()) != 0 {
+		t.Errorf("expected nothing sent in strict mode, got %d messages", len(fake.Messages()))
+	}
+}
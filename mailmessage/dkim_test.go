@@ -0,0 +1,68 @@
+package mailmessage
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"github.com/forsam-education/hermes/dkim"
+	"github.com/forsam-education/hermes/transport"
+	"strings"
+	"testing"
+)
+
+func TestSendMailSignsWithTheBrandIdentity(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("unable to generate key: %s", err.Error())
+	}
+	pemKey := string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}))
+	identities, err := dkim.NewIdentities(dkim.Keys{
+		"brand1": {Domain: "brand1.com", Selector: "b1", PrivateKey: pemKey},
+		"brand2": {Domain: "brand2.com", Selector: "b2", PrivateKey: pemKey},
+	})
+	if err != nil {
+		t.Fatalf("unable to instantiate identities: %s", err.Error())
+	}
+
+	tests := []struct {
+		name   string
+		fields map[string]interface{}
+		tags   string
+		err    string
+	}{
+		{name: "signs with the identity of the From domain", fields: map[string]interface{}{"from_address": "noreply@brand1.com"}, tags: "d=brand1.com; s=b1;"},
+		{name: "signs with the identity of another brand", fields: map[string]interface{}{"from_address": "noreply@news.brand2.com"}, tags: "d=brand2.com; s=b2;"},
+		{name: "signs with the named identity", fields: map[string]interface{}{"from_address": "noreply@brand2.com", "dkim_identity": "brand2"}, tags: "d=brand2.com; s=b2;"},
+		{name: "fails without identity of the From domain", fields: map[string]interface{}{"from_address": "noreply@example.com"}, err: "no DKIM key matches the From domain example.com"},
+		{name: "fails with the identity of another brand", fields: map[string]interface{}{"from_address": "noreply@brand2.com", "dkim_identity": "brand1"}, err: `DKIM identity "brand1" signs for brand1.com`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake := transport.NewFake()
+			_, err := SendMail(context.Background(), newTestTemplates(), newTestTemplates(), fake, Options{DKIM: identities}, testMessageBody(test.fields))
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected error %q, got %v", test.err, err)
+				}
+				if len(fake.Messages()) != 0 {
+					t.Errorf("expected nothing sent, got %d messages", len(fake.Messages()))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unable to send message: %s", err.Error())
+			}
+
+			raw := string(fake.Messages()[0].Raw)
+			if !strings.HasPrefix(raw, "DKIM-Signature: v=1; a=rsa-sha256; c=relaxed/relaxed; "+test.tags) {
+				t.Errorf("expected a signature with %q, got %q", test.tags, raw)
+			}
+			if strings.Count(raw, "DKIM-Signature:") != 1 {
+				t.Errorf("expected a single signature, got %q", raw)
+			}
+		})
+	}
+}
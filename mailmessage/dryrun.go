@@ -0,0 +1,55 @@
+package mailmessage
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"gopkg.in/gomail.v2"
+	"log"
+	"os"
+	"path"
+	"time"
+)
+
+// previewKey returns the key of a stored preview, under the prefix and the template name, unique by its build time.
+func previewKey(prefix string, templateName string, now time.Time) string {
+	if templateName == "" {
+		templateName = "raw"
+	}
+
+	return path.Join(prefix, templateName, now.UTC().Format("20060102T150405.000000000Z")+".eml")
+}
+
+// writePreview writes the built message, as it would have been sent, to the previews storage or else to the standard output.
+func writePreview(opts Options, mailMsg *mailMessage, mail *gomail.Message) error {
+	var rawMessage bytes.Buffer
+	message := &charsetMessage{Message: mail, textType: mailMsg.primaryContentType(), textCharset: opts.TextCharset, htmlCharset: opts.HTMLCharset, headers: mailMsg.unencodedHeaders}
+	if _, err := message.WriteTo(&rawMessage); err != nil {
+		return fmt.Errorf("unable to write raw message: %s", err.Error())
+	}
+
+	if opts.Previews == nil {
+		_, err := rawMessage.WriteTo(os.Stdout)
+		return err
+	}
+
+	key := previewKey(opts.PreviewPrefix, mailMsg.Template, time.Now())
+	if err := opts.Previews.Put(key, &rawMessage, "message/rfc822"); err != nil {
+		return err
+	}
+	log.Printf("Wrote dry run of template %s to %s", mailMsg.Template, key)
+
+	return nil
+}
+
+// IsDryRun tells if a message body asks to be built without being sent. It is false when the body can't be decoded.
+func IsDryRun(messageBody string) bool {
+	var mailMsg struct {
+		DryRun bool `json:"dry_run"`
+	}
+	if err := json.Unmarshal([]byte(messageBody), &mailMsg); err != nil {
+		return false
+	}
+
+	return mailMsg.DryRun
+}
@@ -0,0 +1,50 @@
+package mailmessage
+
+import (
+	"fmt"
+	"github.com/forsam-education/hermes/storage"
+	"github.com/forsam-education/hermes/templating"
+)
+
+// renderWithEngine executes a template written for the engine. The HTML versions escape the context values, and the partials are read
+// from the templates prefixed by an underscore, with the kind and extension of the template, ie: {{> footer}} reads "_footer.html.mustache".
+func renderWithEngine(templateConnector storage.TemplateFetcher, engine templating.Engine, key string, content string, kind string, extension string, templateContext map[string]interface{}) (string, error) {
+	partial := func(name string) (string, error) {
+		return templateConnector.Fetch(fmt.Sprintf("_%s.%s.%s", name, kind, extension))
+	}
+
+	body, err := engine.Render(key, content, templateContext, kind != "txt", partial)
+	if err != nil {
+		return "", fmt.Errorf("unable to execute %s template: %s", key, err.Error())
+	}
+
+	return body, nil
+}
+
+// renderEngineTemplate renders the HTML or TXT version of the template with another engine than Go templates: first the version stored with
+// the extension of one of the TemplateEngines, ie: "welcome.html.mustache", then the ".template" one when a DefaultEngine replaces Go templates.
+// It reports whether the template was rendered, the version must be rendered with Go templates otherwise.
+func renderEngineTemplate(templateConnector storage.TemplateFetcher, templateName string, locale string, kind string, templateContext map[string]interface{}, opts Options) (string, bool, error) {
+	for _, engine := range opts.TemplateEngines {
+		key, content, err := fetchLocalizedFile(templateConnector, templateName, locale, kind, engine.Extension(), Options{})
+		if storage.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return "", true, err
+		}
+		body, err := renderWithEngine(templateConnector, engine, key, content, kind, engine.Extension(), templateContext)
+		return body, true, err
+	}
+	if opts.DefaultEngine == nil {
+		return "", false, nil
+	}
+
+	key, content, err := fetchLocalizedTemplate(templateConnector, templateName, locale, kind, opts)
+	if err != nil {
+		return "", true, err
+	}
+	body, err := renderWithEngine(templateConnector, opts.DefaultEngine, key, content, kind, "template", templateContext)
+
+	return body, true, err
+}
@@ -0,0 +1,71 @@
+package mailmessage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/forsam-education/hermes/enrichment"
+)
+
+// ContextEnricher merges into the template context the fields its source gives for a context field, ie: the profile of the "user_id",
+// so producers don't have to copy every field of it in each message.
+type ContextEnricher struct {
+	// Key is the template context field whose value is looked up, the messages without it being rendered as they are.
+	Key string
+	// Into is the context field the looked up fields are set in, ie: "user", an empty one merging them in the context itself.
+	Into string
+	// Source gives the fields of each key.
+	Source enrichment.Source
+}
+
+// lookupKey returns the value of the key field of the context as a string, telling if it has one.
+func (enricher ContextEnricher) lookupKey(templateContext map[string]interface{}) (string, bool, error) {
+	value, ok := templateContext[enricher.Key]
+	if !ok || value == nil {
+		return "", false, nil
+	}
+
+	switch key := value.(type) {
+	case string:
+		return key, key != "", nil
+	case json.Number:
+		return key.String(), true, nil
+	default:
+		return "", false, fmt.Errorf("template context field %q must be a string or a number to be looked up", enricher.Key)
+	}
+}
+
+// enrichContext merges the fields of each enricher into the template context. The fields of the message win over the looked up ones,
+// so a producer can still override them.
+func enrichContext(ctx context.Context, mailMsg *mailMessage, opts Options) error {
+	for _, enricher := range opts.Enrichers {
+		key, ok, err := enricher.lookupKey(mailMsg.TemplateContext)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		fields, err := enricher.Source.Lookup(ctx, key)
+		if err != nil {
+			return err
+		}
+		if fields == nil {
+			continue
+		}
+
+		if enricher.Into != "" {
+			if _, ok := mailMsg.TemplateContext[enricher.Into]; !ok {
+				mailMsg.TemplateContext[enricher.Into] = fields
+			}
+			continue
+		}
+		for field, value := range fields {
+			if _, ok := mailMsg.TemplateContext[field]; !ok {
+				mailMsg.TemplateContext[field] = value
+			}
+		}
+	}
+
+	return nil
+}
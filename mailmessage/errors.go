@@ -0,0 +1,59 @@
+package mailmessage
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Phases of SendMail a failure happened in.
+const (
+	PhaseDecode = "decode"
+	PhaseLookup = "lookup"
+	PhaseRender = "render"
+	PhaseDial   = "dial"
+	PhaseSend   = "send"
+)
+
+// SendError is returned by SendMail, telling the phase which failed so failures can be counted by cause.
+type SendError struct {
+	Phase   string
+	message string
+}
+
+func (e *SendError) Error() string {
+	return e.message
+}
+
+// dialError is returned by sendMessage when the connection to the mail transport can't be opened.
+type dialError struct {
+	message string
+}
+
+func (e *dialError) Error() string {
+	return e.message
+}
+
+// ErrorPhase returns the phase of SendMail the error happened in, or an empty string if it is not a SendError.
+func ErrorPhase(err error) string {
+	if sendErr, ok := err.(*SendError); ok {
+		return sendErr.Phase
+	}
+
+	return ""
+}
+
+// SuppressedError is returned by SendMail when every recipient of the message is suppressed, in which case it is not sent.
+type SuppressedError struct {
+	Addresses []string
+}
+
+func (e *SuppressedError) Error() string {
+	return fmt.Sprintf("every recipient is suppressed: %s", strings.Join(e.Addresses, ", "))
+}
+
+// IsSuppressed tells if the message was not sent as every recipient is suppressed.
+func IsSuppressed(err error) bool {
+	_, ok := err.(*SuppressedError)
+
+	return ok
+}
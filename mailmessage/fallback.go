@@ -0,0 +1,48 @@
+package mailmessage
+
+import (
+	"github.com/forsam-education/hermes/metrics"
+	"log"
+)
+
+// Policies applied to the templates missing their HTML or TXT version.
+const (
+	// MissingTemplatesFail fails the messages whose template misses its HTML or TXT version.
+	MissingTemplatesFail = "fail"
+	// MissingTemplatesText sends the messages whose template misses its HTML version with their plain text body only.
+	MissingTemplatesText = "text"
+	// MissingTemplatesHTML sends the messages whose template misses its TXT version with their HTML body only.
+	MissingTemplatesHTML = "html"
+	// MissingTemplatesAny sends the messages whose template misses either version with the other one only.
+	MissingTemplatesAny = "any"
+)
+
+// Versions of a template a degraded rendering misses.
+const (
+	missingHTML = "html"
+	missingText = "txt"
+)
+
+// sendsWithout tells if a template missing the version, missingHTML or missingText, is sent with its other version only.
+func (opts Options) sendsWithout(version string) bool {
+	switch opts.MissingTemplates {
+	case MissingTemplatesAny:
+		return true
+	case MissingTemplatesText:
+		return version == missingHTML
+	case MissingTemplatesHTML:
+		return version == missingText
+	default:
+		return false
+	}
+}
+
+// putDegraded logs and counts a template rendered without the missing version, by template and missing version.
+func putDegraded(templateName string, version string, opts Options) {
+	missingVersion, sentVersion := "TXT", "HTML"
+	if version == missingHTML {
+		missingVersion, sentVersion = "HTML", "TXT"
+	}
+	log.Printf("Template %s has no %s version, sending its %s body only", templateName, missingVersion, sentVersion)
+	putMetric(opts, "templates_degraded", 1, metrics.UnitCount, map[string]string{"template": templateName, "missing": version})
+}
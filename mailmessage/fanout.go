@@ -0,0 +1,130 @@
+package mailmessage
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// fanOutEntry is a recipient of a fan-out message, with the context rendered for it only and the locale of its copy, if any.
+type fanOutEntry struct {
+	To      recipientList              `json:"to"`
+	Locale  string                     `json:"locale,omitempty"`
+	Context map[string]json.RawMessage `json:"context,omitempty"`
+}
+
+// fanOutFields are the fields of a fan-out message replaced by each entry, or which can't be shared by its copies.
+var fanOutFields = []string{"fan_out", "to", "to_address", "tracking_id"}
+
+// IsFanOut tells if a message body has a fan_out field, in which case ExpandFanOut gives the messages to send instead of it.
+func IsFanOut(messageBody string) bool {
+	var rawMsg struct {
+		FanOut json.RawMessage `json:"fan_out"`
+	}
+	if err := json.Unmarshal([]byte(messageBody), &rawMsg); err != nil {
+		return false
+	}
+
+	return len(rawMsg.FanOut) > 0 && string(rawMsg.FanOut) != "null"
+}
+
+// ExpandFanOut returns the message bodies of the entries of a fan-out message, one per entry: each copy is sent to the entry recipients, with the
+// template context of the message merged with the entry context, whose keys win, and the entry locale if it has one.
+// The idempotency key of the message is suffixed by the entry index, ie: "welcome-42#3", so each copy is sent once.
+// An invalid fan-out message is a *SendError of the decode phase, being rejected as a whole.
+func ExpandFanOut(messageBody string, opts Options) ([]string, error) {
+	bodies, err := expandFanOut(messageBody, opts)
+	if err != nil {
+		return nil, &SendError{Phase: PhaseDecode, message: err.Error()}
+	}
+
+	return bodies, nil
+}
+
+func expandFanOut(messageBody string, opts Options) ([]string, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(messageBody), &fields); err != nil {
+		return nil, fmt.Errorf("unable tu unmarshal email: %s", err.Error())
+	}
+	var entries []fanOutEntry
+	decode := json.Unmarshal
+	if opts.StrictJSON {
+		decode = decodeStrictly
+	}
+	if err := decode(fields["fan_out"], &entries); err != nil {
+		return nil, fmt.Errorf("unable to decode fan_out: %s", err.Error())
+	}
+	if opts.StrictJSON {
+		var rawEntries []struct {
+			To json.RawMessage `json:"to"`
+		}
+		_ = json.Unmarshal(fields["fan_out"], &rawEntries)
+		for i, rawEntry := range rawEntries {
+			if err := checkRecipientFields(rawEntry.To); err != nil {
+				return nil, fmt.Errorf("unable to decode fan_out entry %d: to %s", i, err.Error())
+			}
+		}
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("fan_out has no entry")
+	}
+	if opts.MaxFanOut > 0 && len(entries) > opts.MaxFanOut {
+		return nil, fmt.Errorf("fan_out has %d entries, above the %d entries limit", len(entries), opts.MaxFanOut)
+	}
+	// The cc and bcc recipients would receive every copy.
+	if _, ok := fields["cc"]; ok {
+		return nil, fmt.Errorf("fan-out messages can't have cc recipients")
+	}
+	if _, ok := fields["bcc"]; ok {
+		return nil, fmt.Errorf("fan-out messages can't have bcc recipients")
+	}
+	var baseContext map[string]json.RawMessage
+	if rawContext, ok := fields["template_context"]; ok {
+		if err := json.Unmarshal(rawContext, &baseContext); err != nil {
+			return nil, fmt.Errorf("unable to decode template_context: %s", err.Error())
+		}
+	}
+	var idempotencyKey string
+	if rawKey, ok := fields["idempotency_key"]; ok {
+		if err := json.Unmarshal(rawKey, &idempotencyKey); err != nil {
+			return nil, fmt.Errorf("unable to decode idempotency_key: %s", err.Error())
+		}
+	}
+	for _, field := range fanOutFields {
+		delete(fields, field)
+	}
+
+	bodies := make([]string, 0, len(entries))
+	for i, entry := range entries {
+		if len(entry.To) == 0 {
+			return nil, fmt.Errorf("fan_out entry %d has no recipient", i)
+		}
+		entryFields := make(map[string]json.RawMessage, len(fields)+2)
+		for field, value := range fields {
+			entryFields[field] = value
+		}
+		context := make(map[string]json.RawMessage, len(baseContext)+len(entry.Context))
+		for key, value := range baseContext {
+			context[key] = value
+		}
+		for key, value := range entry.Context {
+			context[key] = value
+		}
+		// The entry fields are marshalled back from validated values, which can't fail.
+		entryFields["to"], _ = json.Marshal([]string(entry.To))
+		entryFields["template_context"], _ = json.Marshal(context)
+		if entry.Locale != "" {
+			entryFields["locale"], _ = json.Marshal(entry.Locale)
+		}
+		if idempotencyKey != "" {
+			entryFields["idempotency_key"], _ = json.Marshal(fmt.Sprintf("%s#%d", idempotencyKey, i))
+		}
+
+		body, err := json.Marshal(entryFields)
+		if err != nil {
+			return nil, fmt.Errorf("unable to marshal fan_out entry %d: %s", i, err.Error())
+		}
+		bodies = append(bodies, string(body))
+	}
+
+	return bodies, nil
+}
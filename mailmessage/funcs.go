@@ -0,0 +1,146 @@
+package mailmessage
+
+import (
+	"encoding/json"
+	"fmt"
+	"golang.org/x/text/cases"
+	"golang.org/x/text/language"
+	"math/big"
+	"net/url"
+	"strings"
+)
+
+// templateFunctions is the registry of the helpers made available to templates, filled with the built-in ones and at init time.
+var templateFunctions = map[string]interface{}{
+	"integer":       formatInteger,
+	"decimal":       formatDecimal,
+	"currency":      formatCurrency,
+	"localcurrency": formatLocalCurrency,
+	"date":          formatDate,
+	"localdate":     formatLocalDate,
+	"upper":         strings.ToUpper,
+	"lower":         strings.ToLower,
+	"title":         titleCase,
+	"default":       defaultValue,
+	"pluralize":     pluralize,
+	"url":           buildURL,
+}
+
+// RegisterTemplateFunc makes a helper available to every template under the given name, replacing any helper of that name.
+// The helper must be a function as accepted by text/template, and RegisterTemplateFunc must be called from an init function.
+func RegisterTemplateFunc(name string, helper interface{}) {
+	templateFunctions[name] = helper
+}
+
+// templateFuncs returns the helpers made available to both HTML and TXT templates.
+func templateFuncs() map[string]interface{} {
+	return templateFunctions
+}
+
+// toRat converts a template context value to an exact rational number.
+// JSON numbers are decoded as json.Number, so no precision is lost before formatting.
+func toRat(value interface{}) (*big.Rat, error) {
+	var raw string
+	switch v := value.(type) {
+	case json.Number:
+		raw = v.String()
+	case string:
+		raw = v
+	case float64:
+		if r := new(big.Rat).SetFloat64(v); r != nil {
+			return r, nil
+		}
+		return nil, fmt.Errorf("value %v is not a finite number", v)
+	case int:
+		return new(big.Rat).SetInt64(int64(v)), nil
+	case int64:
+		return new(big.Rat).SetInt64(v), nil
+	default:
+		return nil, fmt.Errorf("value %v of type %T is not a number", value, value)
+	}
+
+	r, ok := new(big.Rat).SetString(raw)
+	if !ok {
+		return nil, fmt.Errorf("value %q is not a number", raw)
+	}
+
+	return r, nil
+}
+
+// formatInteger renders a number as an integer, rounding any decimal part.
+func formatInteger(value interface{}) (string, error) {
+	r, err := toRat(value)
+	if err != nil {
+		return "", err
+	}
+
+	return r.FloatString(0), nil
+}
+
+// formatDecimal renders a number with exactly the given count of decimal places, ie: {{decimal 2 .Amount}}.
+func formatDecimal(places int, value interface{}) (string, error) {
+	if places < 0 {
+		return "", fmt.Errorf("invalid decimal places count %d", places)
+	}
+	r, err := toRat(value)
+	if err != nil {
+		return "", err
+	}
+
+	return r.FloatString(places), nil
+}
+
+// titleCase capitalizes the first letter of each word, ie: {{title .City}}.
+func titleCase(value string) string {
+	return cases.Title(language.Und).String(value)
+}
+
+// defaultValue returns the value, or the fallback when the value is missing or empty, ie: {{default "friend" .FirstName}}.
+func defaultValue(fallback interface{}, value interface{}) interface{} {
+	switch v := value.(type) {
+	case nil:
+		return fallback
+	case string:
+		if v == "" {
+			return fallback
+		}
+	case json.Number:
+		if v == "" {
+			return fallback
+		}
+	}
+
+	return value
+}
+
+// pluralize returns the singular form when the count is one, the plural form otherwise, ie: {{pluralize .Count "item" "items"}}.
+func pluralize(count interface{}, singular string, plural string) (string, error) {
+	r, err := toRat(count)
+	if err != nil {
+		return "", err
+	}
+	if r.Cmp(big.NewRat(1, 1)) == 0 {
+		return singular, nil
+	}
+
+	return plural, nil
+}
+
+// buildURL adds escaped query parameters, given as name and value pairs, to an HTTP URL, ie: {{url "https://example.com/orders" "id" .OrderID}}.
+func buildURL(base string, pairs ...interface{}) (string, error) {
+	parsed, err := url.Parse(base)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+		return "", fmt.Errorf("%q is not an HTTP URL", base)
+	}
+	if len(pairs)%2 != 0 {
+		return "", fmt.Errorf("url query parameters must be name and value pairs")
+	}
+
+	query := parsed.Query()
+	for i := 0; i < len(pairs); i += 2 {
+		query.Add(fmt.Sprint(pairs[i]), fmt.Sprint(pairs[i+1]))
+	}
+	parsed.RawQuery = query.Encode()
+
+	return parsed.String(), nil
+}
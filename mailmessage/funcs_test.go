@@ -0,0 +1,120 @@
+package mailmessage
+
+import (
+	"encoding/json"
+	"github.com/forsam-education/hermes/storage"
+	"strings"
+	"testing"
+)
+
+func TestDecodeMailMessageKeepsNumbers(t *testing.T) {
+	tests := []struct {
+		name     string
+		context  string
+		key      string
+		expected json.Number
+	}{
+		{name: "large integer id", context: `{"id": 9007199254740993}`, key: "id", expected: "9007199254740993"},
+		{name: "currency amount", context: `{"total": 1.50}`, key: "total", expected: "1.50"},
+		{name: "small integer", context: `{"count": 5}`, key: "count", expected: "5"},
+		{name: "exponent", context: `{"ratio": 1e3}`, key: "ratio", expected: "1e3"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mailMsg, err := decodeMailMessage(`{"template_name": "order", "template_context": `+test.context+`}`, Options{})
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+			if value, ok := mailMsg.TemplateContext[test.key].(json.Number); !ok || value != test.expected {
+				t.Errorf("expected %s to be decoded as json.Number %s, got %#v", test.key, test.expected, mailMsg.TemplateContext[test.key])
+			}
+		})
+	}
+}
+
+func TestNumberHelpers(t *testing.T) {
+	tests := []struct {
+		name     string
+		helper   func() (string, error)
+		expected string
+		err      bool
+	}{
+		{name: "integer of a large id", helper: func() (string, error) { return formatInteger(json.Number("9007199254740993")) }, expected: "9007199254740993"},
+		{name: "integer of a very large id", helper: func() (string, error) { return formatInteger(json.Number("123456789012345678901234567890")) }, expected: "123456789012345678901234567890"},
+		{name: "integer rounds the decimal part", helper: func() (string, error) { return formatInteger(json.Number("2.5")) }, expected: "3"},
+		{name: "integer of a string", helper: func() (string, error) { return formatInteger("42") }, expected: "42"},
+		{name: "integer of a float", helper: func() (string, error) { return formatInteger(7.0) }, expected: "7"},
+		{name: "integer of a non number", helper: func() (string, error) { return formatInteger("forty-two") }, err: true},
+		{name: "integer of a boolean", helper: func() (string, error) { return formatInteger(true) }, err: true},
+		{name: "decimal pads the places", helper: func() (string, error) { return formatDecimal(2, json.Number("1.5")) }, expected: "1.50"},
+		{name: "decimal rounds the places", helper: func() (string, error) { return formatDecimal(2, json.Number("0.125")) }, expected: "0.13"},
+		{name: "decimal keeps the precision", helper: func() (string, error) { return formatDecimal(2, json.Number("90071992547409.93")) }, expected: "90071992547409.93"},
+		{name: "decimal without places", helper: func() (string, error) { return formatDecimal(0, json.Number("1.4")) }, expected: "1"},
+		{name: "decimal rejects negative places", helper: func() (string, error) { return formatDecimal(-1, json.Number("1.5")) }, err: true},
+		{name: "currency amount", helper: func() (string, error) { return formatCurrency("EUR", json.Number("1.5")) }, expected: "€1.50"},
+		{name: "currency groups the thousands", helper: func() (string, error) { return formatCurrency("USD", json.Number("1234567.891")) }, expected: "$1,234,567.89"},
+		{name: "currency negative amount", helper: func() (string, error) { return formatCurrency("EUR", json.Number("-3")) }, expected: "-€3.00"},
+		{name: "currency without minor unit", helper: func() (string, error) { return formatCurrency("JPY", json.Number("1500.4")) }, expected: "¥1,500"},
+		{name: "currency of a locale", helper: func() (string, error) { return formatLocalCurrency("fr", "EUR", json.Number("1234.5")) }, expected: "1\u00a0234,50\u00a0€"},
+		{name: "currency negative amount of a locale", helper: func() (string, error) { return formatLocalCurrency("fr", "EUR", json.Number("-1.5")) }, expected: "-1,50\u00a0€"},
+		{name: "currency unknown", helper: func() (string, error) { return formatCurrency("XYZ1", json.Number("1")) }, err: true},
+		{name: "currency unknown locale", helper: func() (string, error) { return formatLocalCurrency("not a locale", "EUR", json.Number("1")) }, err: true},
+		{name: "currency of a non number", helper: func() (string, error) { return formatCurrency("EUR", "free") }, err: true},
+		{name: "pluralize one", helper: func() (string, error) { return pluralize(json.Number("1"), "item", "items") }, expected: "item"},
+		{name: "pluralize decimal one", helper: func() (string, error) { return pluralize(json.Number("1.0"), "item", "items") }, expected: "item"},
+		{name: "pluralize zero", helper: func() (string, error) { return pluralize(json.Number("0"), "item", "items") }, expected: "items"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			formatted, err := test.helper()
+			if test.err {
+				if err == nil {
+					t.Errorf("expected an error, got %q", formatted)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+			if formatted != test.expected {
+				t.Errorf("expected %q, got %q", test.expected, formatted)
+			}
+		})
+	}
+}
+
+func TestRenderNumbers(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		context  string
+		expected string
+	}{
+		{name: "large integer id", template: `{{.id}}`, context: `{"id": 9007199254740993}`, expected: "9007199254740993"},
+		{name: "small integer", template: `{{.count}}`, context: `{"count": 5}`, expected: "5"},
+		{name: "currency amount", template: `{{currency "EUR" .total}}`, context: `{"total": 1.5}`, expected: "€1.50"},
+		{name: "decimal amount", template: `{{decimal 2 .total}}`, context: `{"total": 19.999}`, expected: "20.00"},
+		{name: "integer helper", template: `{{integer .id}}`, context: `{"id": 12345678901234567890}`, expected: "12345678901234567890"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			templates := storage.NewMemory()
+			templates.Set("order.html.template", []byte("<p>"+test.template+"</p>"))
+			templates.Set("order.txt.template", []byte(test.template))
+
+			rendering, err := PreviewMail(templates, Options{}, "order", "", "Order", json.RawMessage(test.context))
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+			if strings.TrimSpace(rendering.Text) != test.expected {
+				t.Errorf("expected text %q, got %q", test.expected, rendering.Text)
+			}
+			if !strings.Contains(rendering.HTML, "<p>"+test.expected+"</p>") {
+				t.Errorf("expected HTML to hold %q, got %q", test.expected, rendering.HTML)
+			}
+		})
+	}
+}
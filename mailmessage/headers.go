@@ -0,0 +1,60 @@
+package mailmessage
+
+import (
+	"fmt"
+	"net/textproto"
+	"strings"
+)
+
+// reservedHeaders are built by hermes from the message fields, and can't be set by the headers field.
+var reservedHeaders = map[string]bool{
+	"From":                      true,
+	"Sender":                    true,
+	"To":                        true,
+	"Cc":                        true,
+	"Bcc":                       true,
+	"Reply-To":                  true,
+	"Subject":                   true,
+	"Date":                      true,
+	"Message-Id":                true,
+	"Mime-Version":              true,
+	"Content-Type":              true,
+	"Content-Transfer-Encoding": true,
+	"Dkim-Signature":            true,
+	"Return-Path":               true,
+}
+
+// isHeaderName tells if the name only has the printable characters allowed in a header field name, colon excepted.
+func isHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, char := range name {
+		if char < '!' || char > '~' || char == ':' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// validateHeaders checks the custom headers have valid names and single line values, and don't override the reserved headers.
+// The names are canonicalized, ie: "x-campaign-id" becomes "X-Campaign-Id".
+func validateHeaders(headers map[string]string) (map[string]string, error) {
+	canonical := make(map[string]string, len(headers))
+	for name, value := range headers {
+		if !isHeaderName(name) {
+			return nil, fmt.Errorf("header name %q is invalid", name)
+		}
+		name = textproto.CanonicalMIMEHeaderKey(name)
+		if reservedHeaders[name] {
+			return nil, fmt.Errorf("header %s can't be overridden", name)
+		}
+		if strings.ContainsAny(value, "\r\n") {
+			return nil, fmt.Errorf("header %s value must be a single line", name)
+		}
+		canonical[name] = value
+	}
+
+	return canonical, nil
+}
@@ -0,0 +1,109 @@
+package mailmessage
+
+import (
+	"context"
+	"fmt"
+	"gopkg.in/gomail.v2"
+)
+
+// HookMessage is the message given to the hooks once it is decoded and validated, with its recipients being the addresses it is delivered to.
+// The BeforeRender hooks may change its subject, headers and template context, which the message is then rendered and sent with.
+type HookMessage struct {
+	Template        string
+	Locale          string
+	Variant         string
+	Tenant          string
+	Category        string
+	TrackingID      string
+	From            string
+	Recipients      []string
+	Subject         string
+	Headers         map[string]string
+	TemplateContext map[string]interface{}
+}
+
+// Hooks are the functions run around the phases of SendMail, in order, so integrators can add their own validation, headers or audit logs.
+// The zero value runs no hook.
+type Hooks struct {
+	// BeforeRender run before the templates are rendered, an error rejecting the message as invalid.
+	BeforeRender []func(ctx context.Context, message *HookMessage) error
+	// AfterRender run once the bodies and subject are rendered, and may change them. An error fails the message as a render failure.
+	AfterRender []func(ctx context.Context, message *HookMessage, rendering *Rendering) error
+	// BeforeSend run once the MIME message is built, and may set its headers. An error fails the message as a render failure, without sending it.
+	BeforeSend []func(ctx context.Context, message *HookMessage, mail *gomail.Message) error
+	// AfterSend run once the message was handed to the transport, with the provider id or the *SendError of the failed send. They are not run
+	// for the dry runs, nor for the messages which failed before being sent.
+	AfterSend []func(ctx context.Context, message *HookMessage, providerID string, err error)
+}
+
+// newHookMessage describes the message to the hooks.
+func newHookMessage(mailMsg *mailMessage) *HookMessage {
+	if mailMsg.Headers == nil {
+		mailMsg.Headers = make(map[string]string)
+	}
+
+	return &HookMessage{
+		Template:        mailMsg.Template,
+		Locale:          mailMsg.Locale,
+		Variant:         mailMsg.variant,
+		Tenant:          mailMsg.Tenant,
+		Category:        mailMsg.Category,
+		TrackingID:      mailMsg.TrackingID,
+		From:            mailMsg.FromAddress,
+		Recipients:      mailMsg.envelopeRecipients(),
+		Subject:         mailMsg.Subject,
+		Headers:         mailMsg.Headers,
+		TemplateContext: mailMsg.TemplateContext,
+	}
+}
+
+// runBeforeRender runs the BeforeRender hooks, then applies their changes to the message, the headers they set being validated as the message ones.
+func (hooks Hooks) runBeforeRender(ctx context.Context, message *HookMessage, mailMsg *mailMessage) error {
+	if len(hooks.BeforeRender) == 0 {
+		return nil
+	}
+
+	for i, hook := range hooks.BeforeRender {
+		if err := hook(ctx, message); err != nil {
+			return fmt.Errorf("rejected by hook %d: %s", i, err.Error())
+		}
+	}
+	headers, err := validateHeaders(message.Headers)
+	if err != nil {
+		return fmt.Errorf("invalid headers set by hooks: %s", err.Error())
+	}
+	mailMsg.Headers = headers
+	mailMsg.Subject = message.Subject
+	mailMsg.TemplateContext = message.TemplateContext
+
+	return nil
+}
+
+// runAfterRender runs the AfterRender hooks on the rendered bodies and subject.
+func (hooks Hooks) runAfterRender(ctx context.Context, message *HookMessage, rendering *Rendering) error {
+	for i, hook := range hooks.AfterRender {
+		if err := hook(ctx, message, rendering); err != nil {
+			return fmt.Errorf("hook %d failed after render: %s", i, err.Error())
+		}
+	}
+
+	return nil
+}
+
+// runBeforeSend runs the BeforeSend hooks on the built message.
+func (hooks Hooks) runBeforeSend(ctx context.Context, message *HookMessage, mail *gomail.Message) error {
+	for i, hook := range hooks.BeforeSend {
+		if err := hook(ctx, message, mail); err != nil {
+			return fmt.Errorf("hook %d failed before send: %s", i, err.Error())
+		}
+	}
+
+	return nil
+}
+
+// runAfterSend runs the AfterSend hooks with the outcome of the send.
+func (hooks Hooks) runAfterSend(ctx context.Context, message *HookMessage, providerID string, err error) {
+	for _, hook := range hooks.AfterSend {
+		hook(ctx, message, providerID, err)
+	}
+}
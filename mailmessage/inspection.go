@@ -0,0 +1,101 @@
+package mailmessage
+
+import (
+	"fmt"
+	"github.com/forsam-education/hermes/metrics"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// Policies applied to the attachments which fail their inspection.
+const (
+	// NonCompliantAttachmentsReject rejects the messages having an attachment which fails its inspection.
+	NonCompliantAttachmentsReject = "reject"
+	// NonCompliantAttachmentsStrip removes the attachments which fail their inspection, sending the message with the other ones.
+	NonCompliantAttachmentsStrip = "strip"
+)
+
+// rejectedAttachmentError is returned when an attachment fails its inspection, the message being invalid rather than unable to render.
+type rejectedAttachmentError struct {
+	message string
+}
+
+func (err *rejectedAttachmentError) Error() string {
+	return err.message
+}
+
+// inspectsAttachments tells if the attachments are inspected, which requires downloading them before building the message.
+func (opts Options) inspectsAttachments() bool {
+	return len(opts.AllowedAttachmentTypes) > 0 || opts.AttachmentScanner != nil
+}
+
+// attachmentType returns the media type of an attachment: its given content type, or else the type of its file name extension,
+// or else the type sniffed from its content.
+func attachmentType(att attachment, content []byte) string {
+	contentType := att.ContentType
+	if contentType == "" {
+		contentType = mime.TypeByExtension(path.Ext(att.name))
+	}
+	if contentType == "" {
+		contentType = http.DetectContentType(content)
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return strings.ToLower(contentType)
+	}
+
+	return mediaType
+}
+
+// isAllowedAttachmentType tells if the media type is listed, a "type/*" entry allowing every subtype, ie: "image/*".
+func isAllowedAttachmentType(mediaType string, allowed []string) bool {
+	for _, allowedType := range allowed {
+		allowedType = strings.ToLower(strings.TrimSpace(allowedType))
+		if allowedType == mediaType || (strings.HasSuffix(allowedType, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(allowedType, "*"))) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// inspectAttachment checks the content type of the attachment is allowed, then scans it, returning the reason it fails as "type" or "virus"
+// with the details of the failure. A scan which can't be completed is returned as an error.
+func inspectAttachment(att attachment, content []byte, opts Options) (string, string, error) {
+	if len(opts.AllowedAttachmentTypes) > 0 {
+		if mediaType := attachmentType(att, content); !isAllowedAttachmentType(mediaType, opts.AllowedAttachmentTypes) {
+			return "type", fmt.Sprintf("has content type %s, which is not allowed", mediaType), nil
+		}
+	}
+	if opts.AttachmentScanner != nil {
+		threat, err := opts.AttachmentScanner.Scan(att.name, content)
+		if err != nil {
+			return "", "", err
+		}
+		if threat != "" {
+			return "virus", fmt.Sprintf("is infected by %s", threat), nil
+		}
+	}
+
+	return "", "", nil
+}
+
+// failAttachment reports an attachment which failed its inspection, or above the size limit, in logs and in the attachments_rejected metric
+// with the reason and the action taken as dimensions. It returns the error rejecting the message, or nil when the attachment is stripped.
+func failAttachment(template string, name string, reason string, details string, opts Options) error {
+	action := "rejected"
+	if opts.NonCompliantAttachments == NonCompliantAttachmentsStrip {
+		action = "stripped"
+	}
+	putMetric(opts, "attachments_rejected", 1, metrics.UnitCount, map[string]string{"reason": reason, "action": action})
+	if action == "rejected" {
+		log.Printf("Rejecting message of template %s, whose attachment %q %s", template, name, details)
+		return &rejectedAttachmentError{message: fmt.Sprintf("attachment %q %s", name, details)}
+	}
+
+	log.Printf("Stripping attachment %q of message of template %s, which %s", name, template, details)
+	return nil
+}
@@ -0,0 +1,177 @@
+package mailmessage
+
+import (
+	"bytes"
+	"errors"
+	"github.com/forsam-education/hermes/storage"
+	"gopkg.in/gomail.v2"
+	"strings"
+	"testing"
+)
+
+// fakeScanner reports the threats of the files by name, failing every scan with err when set.
+type fakeScanner struct {
+	threats map[string]string
+	err     error
+	scanned []string
+}
+
+func (scanner *fakeScanner) Scan(name string, content []byte) (string, error) {
+	scanner.scanned = append(scanner.scanned, name)
+	if scanner.err != nil {
+		return "", scanner.err
+	}
+
+	return scanner.threats[name], nil
+}
+
+func TestIsAllowedAttachmentType(t *testing.T) {
+	tests := []struct {
+		mediaType string
+		allowed   []string
+		expected  bool
+	}{
+		{mediaType: "application/pdf", allowed: []string{"application/pdf"}, expected: true},
+		{mediaType: "application/pdf", allowed: []string{" Application/PDF "}, expected: true},
+		{mediaType: "image/png", allowed: []string{"application/pdf", "image/*"}, expected: true},
+		{mediaType: "image/svg+xml", allowed: []string{"image/*"}, expected: true},
+		{mediaType: "application/zip", allowed: []string{"application/pdf", "image/*"}},
+		{mediaType: "imagex/png", allowed: []string{"image/*"}},
+		{mediaType: "image", allowed: []string{"image/*"}},
+		{mediaType: "application/pdf", allowed: []string{"application/pdf+zip"}},
+		{mediaType: "text/plain", allowed: []string{"*/*"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.mediaType+" in "+strings.Join(test.allowed, ","), func(t *testing.T) {
+			if allowed := isAllowedAttachmentType(test.mediaType, test.allowed); allowed != test.expected {
+				t.Errorf("expected %t, got %t", test.expected, allowed)
+			}
+		})
+	}
+}
+
+func TestAttachmentType(t *testing.T) {
+	tests := []struct {
+		name     string
+		att      attachment
+		content  []byte
+		expected string
+	}{
+		{name: "uses the given content type", att: attachment{ContentType: "Application/PDF; name=invoice.pdf", name: "invoice.exe"}, expected: "application/pdf"},
+		{name: "uses the file name extension", att: attachment{name: "photo.PNG"}, expected: "image/png"},
+		{name: "sniffs the content without extension", att: attachment{name: "invoice"}, content: []byte("%PDF-1.4\n"), expected: "application/pdf"},
+		{name: "sniffs a binary content", att: attachment{name: "payload"}, content: []byte{0, 1, 2}, expected: "application/octet-stream"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if mediaType := attachmentType(test.att, test.content); mediaType != test.expected {
+				t.Errorf("expected %q, got %q", test.expected, mediaType)
+			}
+		})
+	}
+}
+
+func TestInspectAttachment(t *testing.T) {
+	tests := []struct {
+		name    string
+		att     attachment
+		allowed []string
+		scanner *fakeScanner
+		reason  string
+		details string
+		scanned bool
+		err     string
+	}{
+		{name: "accepts an allowed type", att: attachment{name: "invoice.pdf"}, allowed: []string{"application/pdf"}},
+		{name: "fails a type which is not allowed", att: attachment{name: "setup.zip"}, allowed: []string{"application/pdf", "image/*"}, reason: "type", details: "has content type application/zip, which is not allowed"},
+		{name: "accepts every type without list", att: attachment{name: "setup.zip"}, scanner: &fakeScanner{}, scanned: true},
+		{name: "accepts a clean file", att: attachment{name: "invoice.pdf"}, allowed: []string{"application/pdf"}, scanner: &fakeScanner{}, scanned: true},
+		{name: "fails an infected file", att: attachment{name: "invoice.pdf"}, scanner: &fakeScanner{threats: map[string]string{"invoice.pdf": "Eicar-Test-Signature"}}, reason: "virus", details: "is infected by Eicar-Test-Signature", scanned: true},
+		{name: "doesn't scan a type which is not allowed", att: attachment{name: "setup.zip"}, allowed: []string{"image/*"}, scanner: &fakeScanner{}, reason: "type", details: "has content type application/zip, which is not allowed"},
+		{name: "fails a scan which can't complete", att: attachment{name: "invoice.pdf"}, scanner: &fakeScanner{err: errors.New("unable to connect to ClamAV")}, scanned: true, err: "unable to connect to ClamAV"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opts := Options{AllowedAttachmentTypes: test.allowed}
+			if test.scanner != nil {
+				opts.AttachmentScanner = test.scanner
+			}
+			reason, details, err := inspectAttachment(test.att, []byte("content"), opts)
+			if test.err != "" {
+				if err == nil || err.Error() != test.err {
+					t.Errorf("expected error %q, got %v", test.err, err)
+				}
+			} else if err != nil || reason != test.reason || details != test.details {
+				t.Errorf("expected %q %q, got %q %q, %v", test.reason, test.details, reason, details, err)
+			}
+			if test.scanner != nil && (len(test.scanner.scanned) > 0) != test.scanned {
+				t.Errorf("expected scanned to be %t, got %v", test.scanned, test.scanner.scanned)
+			}
+		})
+	}
+}
+
+func TestAttachFromMemoryPolicies(t *testing.T) {
+	attachments := storage.NewMemory()
+	attachments.Set("invoices/invoice.pdf", []byte("%PDF-1.4\n"))
+	attachments.Set("invoices/setup.zip", []byte("PK\x03\x04"))
+	attachments.Set("invoices/infected.pdf", []byte("%PDF-1.4\nEICAR"))
+	scanner := &fakeScanner{threats: map[string]string{"infected.pdf": "Eicar-Test-Signature"}}
+
+	tests := []struct {
+		name     string
+		keys     []string
+		policy   string
+		attached []string
+		err      string
+	}{
+		{name: "attaches compliant attachments", keys: []string{"invoices/invoice.pdf"}, attached: []string{"invoice.pdf"}},
+		{name: "rejects a type which is not allowed", keys: []string{"invoices/invoice.pdf", "invoices/setup.zip"}, policy: NonCompliantAttachmentsReject, err: `attachment "setup.zip" has content type application/zip, which is not allowed`},
+		{name: "rejects an infected attachment by default", keys: []string{"invoices/infected.pdf"}, err: `attachment "infected.pdf" is infected by Eicar-Test-Signature`},
+		{
+			name:     "strips the non-compliant attachments",
+			keys:     []string{"invoices/setup.zip", "invoices/invoice.pdf", "invoices/infected.pdf"},
+			policy:   NonCompliantAttachmentsStrip,
+			attached: []string{"invoice.pdf"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var list []attachment
+			for _, key := range test.keys {
+				list = append(list, attachment{Key: key, name: key[strings.LastIndex(key, "/")+1:]})
+			}
+			opts := Options{AllowedAttachmentTypes: []string{"application/pdf"}, AttachmentScanner: scanner, NonCompliantAttachments: test.policy}
+
+			message := gomail.NewMessage()
+			message.SetBody("text/plain", "Hello")
+			err := attachFromMemory(message, attachments, "invoice", list, opts)
+			if test.err != "" {
+				if _, ok := err.(*rejectedAttachmentError); !ok || err.Error() != test.err {
+					t.Errorf("expected a rejected attachment error %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+
+			var output bytes.Buffer
+			if _, err := message.WriteTo(&output); err != nil {
+				t.Fatalf("unable to write message: %s", err.Error())
+			}
+			if count := strings.Count(output.String(), "Content-Disposition: attachment"); count != len(test.attached) {
+				t.Errorf("expected %d attachments, got %d", len(test.attached), count)
+			}
+			for _, name := range test.attached {
+				if !strings.Contains(output.String(), `filename="`+name+`"`) {
+					t.Errorf("expected %s to be attached, got %s", name, output.String())
+				}
+			}
+		})
+	}
+}
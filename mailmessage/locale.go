@@ -0,0 +1,170 @@
+package mailmessage
+
+import (
+	"encoding/json"
+	"fmt"
+	"golang.org/x/text/currency"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+	"golang.org/x/text/number"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// defaultLocale is used by the helpers given no locale.
+const defaultLocale = "en"
+
+// suffixedCurrencyLanguages write the currency symbol after the amount, ie: "12,50 €".
+var suffixedCurrencyLanguages = map[string]bool{
+	"fr": true, "de": true, "es": true, "it": true, "pt": true, "nl": true, "pl": true, "sv": true, "da": true, "fi": true, "nb": true, "cs": true, "ru": true,
+}
+
+// formatCurrency renders an amount in the currency, with the default locale, ie: {{currency "EUR" .Total}}.
+func formatCurrency(code string, value interface{}) (string, error) {
+	return formatLocalCurrency(defaultLocale, code, value)
+}
+
+// formatLocalCurrency renders an amount in the currency, with the separators, symbol and symbol position of the locale, ie: {{localcurrency "fr" "EUR" .Total}}.
+func formatLocalCurrency(locale string, code string, value interface{}) (string, error) {
+	tag, err := language.Parse(locale)
+	if err != nil {
+		return "", fmt.Errorf("unknown locale %q", locale)
+	}
+	unit, err := currency.ParseISO(code)
+	if err != nil {
+		return "", fmt.Errorf("unknown currency %q", code)
+	}
+	r, err := toRat(value)
+	if err != nil {
+		return "", err
+	}
+
+	// The sign is written before the symbol, ie: "-€3.00".
+	sign := ""
+	if r.Sign() < 0 {
+		sign = "-"
+		r.Neg(r)
+	}
+	amount, _ := r.Float64()
+	scale, _ := currency.Standard.Rounding(unit)
+	printer := message.NewPrinter(tag)
+	formatted := printer.Sprint(number.Decimal(amount, number.Scale(scale)))
+	symbol := printer.Sprint(currency.Symbol(unit))
+
+	base, _ := tag.Base()
+	if suffixedCurrencyLanguages[base.String()] {
+		return sign + formatted + " " + symbol, nil
+	}
+
+	return sign + symbol + formatted, nil
+}
+
+// localizedNames holds the month and weekday names of a language, full and abbreviated, in the order of time.Month and time.Weekday.
+type localizedNames struct {
+	months      [12]string
+	shortMonths [12]string
+	days        [7]string
+	shortDays   [7]string
+}
+
+var dateNames = map[string]localizedNames{
+	"fr": {
+		months:      [12]string{"janvier", "février", "mars", "avril", "mai", "juin", "juillet", "août", "septembre", "octobre", "novembre", "décembre"},
+		shortMonths: [12]string{"janv.", "févr.", "mars", "avr.", "mai", "juin", "juil.", "août", "sept.", "oct.", "nov.", "déc."},
+		days:        [7]string{"dimanche", "lundi", "mardi", "mercredi", "jeudi", "vendredi", "samedi"},
+		shortDays:   [7]string{"dim.", "lun.", "mar.", "mer.", "jeu.", "ven.", "sam."},
+	},
+	"de": {
+		months:      [12]string{"Januar", "Februar", "März", "April", "Mai", "Juni", "Juli", "August", "September", "Oktober", "November", "Dezember"},
+		shortMonths: [12]string{"Jan.", "Feb.", "März", "Apr.", "Mai", "Juni", "Juli", "Aug.", "Sept.", "Okt.", "Nov.", "Dez."},
+		days:        [7]string{"Sonntag", "Montag", "Dienstag", "Mittwoch", "Donnerstag", "Freitag", "Samstag"},
+		shortDays:   [7]string{"So.", "Mo.", "Di.", "Mi.", "Do.", "Fr.", "Sa."},
+	},
+	"es": {
+		months:      [12]string{"enero", "febrero", "marzo", "abril", "mayo", "junio", "julio", "agosto", "septiembre", "octubre", "noviembre", "diciembre"},
+		shortMonths: [12]string{"ene.", "feb.", "mar.", "abr.", "may.", "jun.", "jul.", "ago.", "sept.", "oct.", "nov.", "dic."},
+		days:        [7]string{"domingo", "lunes", "martes", "miércoles", "jueves", "viernes", "sábado"},
+		shortDays:   [7]string{"dom.", "lun.", "mar.", "mié.", "jue.", "vie.", "sáb."},
+	},
+	"it": {
+		months:      [12]string{"gennaio", "febbraio", "marzo", "aprile", "maggio", "giugno", "luglio", "agosto", "settembre", "ottobre", "novembre", "dicembre"},
+		shortMonths: [12]string{"gen", "feb", "mar", "apr", "mag", "giu", "lug", "ago", "set", "ott", "nov", "dic"},
+		days:        [7]string{"domenica", "lunedì", "martedì", "mercoledì", "giovedì", "venerdì", "sabato"},
+		shortDays:   [7]string{"dom", "lun", "mar", "mer", "gio", "ven", "sab"},
+	},
+}
+
+// englishDateName matches the English month and weekday names written by time.Format, full names first.
+var englishDateName = regexp.MustCompile(`\b(January|February|March|April|May|June|July|August|September|October|November|December|` +
+	`Sunday|Monday|Tuesday|Wednesday|Thursday|Friday|Saturday|` +
+	`Jan|Feb|Mar|Apr|Jun|Jul|Aug|Sep|Oct|Nov|Dec|Sun|Mon|Tue|Wed|Thu|Fri|Sat)\b`)
+
+// localizeDate replaces the English month and weekday names of a formatted date by those of the locale, when they are known.
+func localizeDate(formatted string, t time.Time, locale string) string {
+	tag, err := language.Parse(locale)
+	if err != nil {
+		return formatted
+	}
+	base, _ := tag.Base()
+	names, ok := dateNames[base.String()]
+	if !ok {
+		return formatted
+	}
+
+	month, weekday := t.Month()-1, t.Weekday()
+	return englishDateName.ReplaceAllStringFunc(formatted, func(name string) string {
+		switch {
+		case name == t.Month().String():
+			return names.months[month]
+		case name == weekday.String():
+			return names.days[weekday]
+		case name == t.Month().String()[:3]:
+			return names.shortMonths[month]
+		case name == weekday.String()[:3]:
+			return names.shortDays[weekday]
+		default:
+			return name
+		}
+	})
+}
+
+// toTime converts a template context value to a time, from an RFC 3339 date, a YYYY-MM-DD day or a Unix timestamp in seconds.
+func toTime(value interface{}) (time.Time, error) {
+	switch v := value.(type) {
+	case time.Time:
+		return v, nil
+	case json.Number:
+		seconds, err := v.Int64()
+		if err != nil {
+			return time.Time{}, fmt.Errorf("value %q is not a Unix timestamp", v)
+		}
+		return time.Unix(seconds, 0).UTC(), nil
+	case string:
+		if t, err := time.Parse(time.RFC3339, v); err == nil {
+			return t, nil
+		}
+		if t, err := time.Parse("2006-01-02", strings.TrimSpace(v)); err == nil {
+			return t, nil
+		}
+		return time.Time{}, fmt.Errorf("value %q is not an RFC 3339 date", v)
+	default:
+		return time.Time{}, fmt.Errorf("value %v of type %T is not a date", value, value)
+	}
+}
+
+// formatDate renders a date with the Go layout, ie: {{date "2 January 2006" .ShippedAt}}.
+func formatDate(layout string, value interface{}) (string, error) {
+	return formatLocalDate(defaultLocale, layout, value)
+}
+
+// formatLocalDate renders a date with the Go layout, the month and weekday names being translated to the locale, ie: {{localdate "fr" "2 January 2006" .ShippedAt}}.
+// Names of unsupported locales are left in English.
+func formatLocalDate(locale string, layout string, value interface{}) (string, error) {
+	t, err := toTime(value)
+	if err != nil {
+		return "", err
+	}
+
+	return localizeDate(t.Format(layout), t, locale), nil
+}
@@ -0,0 +1,201 @@
+package mailmessage
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	markdownHeading     = regexp.MustCompile(`^(#{1,6})\s+(.*?)\s*#*\s*$`)
+	markdownRule        = regexp.MustCompile(`^ {0,3}(?:(?:-\s*){3,}|(?:\*\s*){3,}|(?:_\s*){3,})$`)
+	markdownBullet      = regexp.MustCompile(`^ {0,3}[-*+]\s+(.*)$`)
+	markdownNumbered    = regexp.MustCompile(`^ {0,3}(\d{1,9})[.)]\s+(.*)$`)
+	markdownQuote       = regexp.MustCompile(`^ {0,3}>\s?(.*)$`)
+	markdownFence       = regexp.MustCompile("^ {0,3}(```|~~~)")
+	markdownCodeSpan    = regexp.MustCompile("`([^`]+)`")
+	markdownImage       = regexp.MustCompile(`!\[([^\]]*)\]\(([^)\s]+)(?:\s+&#34;([^"]*?)&#34;)?\)`)
+	markdownLink        = regexp.MustCompile(`\[([^\]]+)\]\(([^)\s]+)(?:\s+&#34;([^"]*?)&#34;)?\)`)
+	markdownAutolink    = regexp.MustCompile(`&lt;((?:https?|mailto):[^\s&]+)&gt;`)
+	markdownStrong      = regexp.MustCompile(`\*\*(\S(?:.*?\S)?)\*\*|__(\S(?:.*?\S)?)__`)
+	markdownEmphasis    = regexp.MustCompile(`\*(\S(?:.*?\S)?)\*|(^|[^\w])_(\S(?:.*?\S)?)_($|[^\w])`)
+	markdownBreak       = regexp.MustCompile(` {2,}\n`)
+	markdownPlaceholder = regexp.MustCompile("\x00(\\d+)\x00")
+	// safeLinkSchemes are the schemes a Markdown link or image may use, so a context value can't inject a javascript: link.
+	safeLinkSchemes = []string{"http://", "https://", "mailto:", "tel:", "cid:"}
+)
+
+// inlineRenderer converts the inline Markdown of a block, keeping the code spans and links apart as placeholders so their content
+// is not formatted further.
+type inlineRenderer struct {
+	fragments []string
+}
+
+func (renderer *inlineRenderer) keep(fragment string) string {
+	renderer.fragments = append(renderer.fragments, fragment)
+
+	return fmt.Sprintf("\x00%d\x00", len(renderer.fragments)-1)
+}
+
+// isSafeLink tells if the link target uses an allowed scheme. The target is HTML escaped already.
+func isSafeLink(target string) bool {
+	lowered := strings.ToLower(html.UnescapeString(target))
+	for _, scheme := range safeLinkSchemes {
+		if strings.HasPrefix(lowered, scheme) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// titleAttribute returns the title attribute of a link or image, if it has a title.
+func titleAttribute(title string) string {
+	if title == "" {
+		return ""
+	}
+
+	return ` title="` + title + `"`
+}
+
+// render returns the HTML of the inline Markdown text.
+func (renderer *inlineRenderer) render(text string) string {
+	text = html.EscapeString(text)
+	text = markdownCodeSpan.ReplaceAllStringFunc(text, func(match string) string {
+		return renderer.keep("<code>" + markdownCodeSpan.FindStringSubmatch(match)[1] + "</code>")
+	})
+	text = markdownImage.ReplaceAllStringFunc(text, func(match string) string {
+		parts := markdownImage.FindStringSubmatch(match)
+		if !isSafeLink(parts[2]) {
+			return parts[1]
+		}
+		return renderer.keep(`<img src="` + parts[2] + `" alt="` + parts[1] + `"` + titleAttribute(parts[3]) + `>`)
+	})
+	text = markdownLink.ReplaceAllStringFunc(text, func(match string) string {
+		parts := markdownLink.FindStringSubmatch(match)
+		if !isSafeLink(parts[2]) {
+			return parts[1]
+		}
+		return renderer.keep(`<a href="`+parts[2]+`"`+titleAttribute(parts[3])+`>`) + parts[1] + renderer.keep("</a>")
+	})
+	text = markdownAutolink.ReplaceAllStringFunc(text, func(match string) string {
+		target := markdownAutolink.FindStringSubmatch(match)[1]
+		return renderer.keep(`<a href="` + target + `">` + target + `</a>`)
+	})
+	text = markdownStrong.ReplaceAllString(text, "<strong>$1$2</strong>")
+	text = markdownEmphasis.ReplaceAllString(text, "$2<em>$1$3</em>$4")
+	text = markdownBreak.ReplaceAllString(text, "<br>\n")
+
+	// A link may hold a placeholder, ie: a code span, so they are restored until none is left.
+	for markdownPlaceholder.MatchString(text) {
+		text = markdownPlaceholder.ReplaceAllStringFunc(text, func(match string) string {
+			index, _ := strconv.Atoi(markdownPlaceholder.FindStringSubmatch(match)[1])
+			return renderer.fragments[index]
+		})
+	}
+
+	return text
+}
+
+// markdownToHTML converts Markdown to an HTML fragment. It supports the headings, paragraphs, emphasis, code spans and fenced code blocks,
+// links and images, block quotes, bullet and numbered lists and horizontal rules, which are enough for transactional emails.
+// Raw HTML is escaped, so the context values rendered in a Markdown template can't inject markup.
+func markdownToHTML(source string) string {
+	lines := strings.Split(strings.Replace(source, "\r\n", "\n", -1), "\n")
+	renderer := &inlineRenderer{}
+	var output strings.Builder
+	var paragraph []string
+	var listTag string
+	var items []string
+
+	flushParagraph := func() {
+		if len(paragraph) > 0 {
+			output.WriteString("<p>" + renderer.render(strings.Join(paragraph, "\n")) + "</p>\n")
+			paragraph = nil
+		}
+	}
+	flushList := func() {
+		if listTag == "" {
+			return
+		}
+		output.WriteString("<" + listTag + ">\n")
+		for _, item := range items {
+			output.WriteString("<li>" + renderer.render(item) + "</li>\n")
+		}
+		output.WriteString("</" + listTag + ">\n")
+		listTag, items = "", nil
+	}
+	startItem := func(tag string, text string) {
+		flushParagraph()
+		if listTag != tag {
+			flushList()
+			listTag = tag
+		}
+		items = append(items, text)
+	}
+
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		trimmed := strings.TrimSpace(line)
+
+		if fence := markdownFence.FindStringSubmatch(line); fence != nil {
+			flushParagraph()
+			flushList()
+			var code []string
+			for i++; i < len(lines) && !strings.HasPrefix(strings.TrimSpace(lines[i]), fence[1]); i++ {
+				code = append(code, html.EscapeString(lines[i]))
+			}
+			output.WriteString("<pre><code>" + strings.Join(code, "\n") + "</code></pre>\n")
+			continue
+		}
+		if trimmed == "" {
+			flushParagraph()
+			flushList()
+			continue
+		}
+		if heading := markdownHeading.FindStringSubmatch(trimmed); heading != nil {
+			flushParagraph()
+			flushList()
+			tag := fmt.Sprintf("h%d", len(heading[1]))
+			output.WriteString("<" + tag + ">" + renderer.render(heading[2]) + "</" + tag + ">\n")
+			continue
+		}
+		if markdownRule.MatchString(line) {
+			flushParagraph()
+			flushList()
+			output.WriteString("<hr>\n")
+			continue
+		}
+		if markdownQuote.MatchString(line) {
+			flushParagraph()
+			flushList()
+			var quoted []string
+			for ; i < len(lines) && markdownQuote.MatchString(lines[i]); i++ {
+				quoted = append(quoted, markdownQuote.FindStringSubmatch(lines[i])[1])
+			}
+			i--
+			output.WriteString("<blockquote>\n" + markdownToHTML(strings.Join(quoted, "\n")) + "</blockquote>\n")
+			continue
+		}
+		if bullet := markdownBullet.FindStringSubmatch(line); bullet != nil {
+			startItem("ul", bullet[1])
+			continue
+		}
+		if numbered := markdownNumbered.FindStringSubmatch(line); numbered != nil {
+			startItem("ol", numbered[2])
+			continue
+		}
+		// A line following a list item continues it, as does a line following a paragraph.
+		if listTag != "" {
+			items[len(items)-1] += "\n" + trimmed
+			continue
+		}
+		paragraph = append(paragraph, strings.TrimLeft(line, " "))
+	}
+	flushParagraph()
+	flushList()
+
+	return output.String()
+}
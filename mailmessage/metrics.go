@@ -0,0 +1,23 @@
+package mailmessage
+
+import (
+	"github.com/forsam-education/hermes/metrics"
+	"log"
+	"time"
+)
+
+// putMetric records a metric when metrics are configured, the failures being logged only as the message is already processed.
+func putMetric(opts Options, name string, value float64, unit string, dimensions map[string]string) {
+	if opts.Metrics == nil {
+		return
+	}
+
+	if err := opts.Metrics.Put(name, value, unit, dimensions); err != nil {
+		log.Printf("Unable to emit %s metric: %s", name, err.Error())
+	}
+}
+
+// putPhaseLatency records how long a phase of SendMail took, ie: render_latency or smtp_latency, in milliseconds.
+func putPhaseLatency(opts Options, name string, start time.Time) {
+	putMetric(opts, name, float64(time.Since(start)/time.Millisecond), metrics.UnitMilliseconds, nil)
+}
@@ -0,0 +1,32 @@
+package mailmessage
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	// protectedHTML matches the regions kept as-is: preformatted text, and the conditional comments used to target Outlook.
+	protectedHTML = regexp.MustCompile(`(?is)<pre\b.*?</pre>|<textarea\b.*?</textarea>|<!--\[if\b.*?<!\[endif\]-->`)
+	htmlComment   = regexp.MustCompile(`(?s)<!--.*?-->`)
+	whitespaces   = regexp.MustCompile(`\s+`)
+)
+
+// minifyHTMLSegment strips the comments of an unprotected segment and collapses its whitespaces, which browsers render as a single space anyway.
+func minifyHTMLSegment(segment string) string {
+	return whitespaces.ReplaceAllString(htmlComment.ReplaceAllString(segment, ""), " ")
+}
+
+// minifyHTML reduces the size of an HTML body, leaving the protected regions untouched.
+func minifyHTML(body string) string {
+	var minified strings.Builder
+	last := 0
+	for _, region := range protectedHTML.FindAllStringIndex(body, -1) {
+		minified.WriteString(minifyHTMLSegment(body[last:region[0]]))
+		minified.WriteString(body[region[0]:region[1]])
+		last = region[1]
+	}
+	minified.WriteString(minifyHTMLSegment(body[last:]))
+
+	return strings.TrimSpace(minified.String())
+}
@@ -0,0 +1,63 @@
+package mailmessage
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMinifyHTML(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		expected string
+	}{
+		{name: "collapses the whitespaces", body: "<table>\n\t<tr>\n\t\t<td>Hello   Jane</td>\n\t</tr>\n</table>\n", expected: "<table> <tr> <td>Hello Jane</td> </tr> </table>"},
+		{name: "strips the comments", body: "<p>Hello</p>\n<!-- header\nend -->\n<p>Bye</p>", expected: "<p>Hello</p> <p>Bye</p>"},
+		{name: "keeps the non-breaking spaces", body: "<p>10 €</p>", expected: "<p>10 €</p>"},
+		{name: "keeps preformatted text", body: "<p>a  b</p>\n<pre class=\"code\">  line 1\n    line 2</pre>", expected: "<p>a b</p> <pre class=\"code\">  line 1\n    line 2</pre>"},
+		{name: "keeps comments in preformatted text", body: "<PRE><!-- kept -->\n x</PRE>", expected: "<PRE><!-- kept -->\n x</PRE>"},
+		{name: "keeps text areas", body: "<textarea>\n  a\n</textarea>", expected: "<textarea>\n  a\n</textarea>"},
+		{name: "does not mistake an element starting like pre", body: "<preview>\n  a\n</preview>", expected: "<preview> a </preview>"},
+		{name: "keeps the outlook conditional comments", body: "<!--[if mso]>\n<table>\n  <tr><td>x</td></tr>\n</table>\n<![endif]-->\n<!-- note -->", expected: "<!--[if mso]>\n<table>\n  <tr><td>x</td></tr>\n</table>\n<![endif]-->"},
+		{name: "keeps the content hidden from outlook", body: "<!--[if !mso]><!-->\n  <p>x</p>\n<!--<![endif]-->", expected: "<!--[if !mso]><!-->\n  <p>x</p>\n<!--<![endif]-->"},
+		{name: "keeps uppercase conditional comments", body: "<!--[IF gte mso 9]>\n  x\n<![ENDIF]-->", expected: "<!--[IF gte mso 9]>\n  x\n<![ENDIF]-->"},
+		{name: "minifies between protected regions", body: "<pre>a</pre>\n\n<!-- x -->\n\n<pre>b</pre>", expected: "<pre>a</pre> <pre>b</pre>"},
+		{name: "keeps an unclosed preformatted text minified", body: "<pre>\n  a", expected: "<pre> a"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if minified := minifyHTML(test.body); minified != test.expected {
+				t.Errorf("expected %q, got %q", test.expected, minified)
+			}
+		})
+	}
+}
+
+func TestSendMailMinifiesHTML(t *testing.T) {
+	templates := newTestTemplates()
+	templates.Set("welcome.html.template", []byte("<html>\n  <body>\n    <!-- greeting -->\n    <p>\n      Hello {{.name}}\n    </p>\n    <pre>\n  order 42\n</pre>\n  </body>\n</html>\n"))
+
+	htmlBody := func(opts Options) string {
+		_, parts := parseTestMessage(t, sendTestMessage(t, templates, opts, testMessageBody(nil))[0])
+		for _, part := range parts {
+			if strings.HasPrefix(part.contentType, "text/html") {
+				return strings.Replace(part.body, "\r\n", "\n", -1)
+			}
+		}
+		t.Fatal("expected an HTML part")
+		return ""
+	}
+
+	original := htmlBody(Options{})
+	minified := htmlBody(Options{MinifyHTML: true})
+	if len(minified) >= len(original) {
+		t.Errorf("expected the minified body to be smaller than %d bytes, got %d", len(original), len(minified))
+	}
+	if expected := "<html> <body> <p> Hello Jane </p> <pre>\n  order 42\n</pre> </body> </html>"; minified != expected {
+		t.Errorf("expected %q, got %q", expected, minified)
+	}
+	if !strings.Contains(original, "<html>\n  <body>") {
+		t.Errorf("expected the body to be kept as is without MINIFY_HTML, got %q", original)
+	}
+}
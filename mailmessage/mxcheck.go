@@ -0,0 +1,39 @@
+package mailmessage
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// checkRecipientDomains rejects the message when the domain of one of its recipients has no mail server, as sending to it would only bounce.
+// A domain which can't be looked up, ie: on a DNS timeout, is accepted.
+func checkRecipientDomains(mailMsg *mailMessage, opts Options) error {
+	if opts.MXLookup == nil {
+		return nil
+	}
+
+	checked := make(map[string]bool)
+	var undeliverable []string
+	for _, address := range mailMsg.envelopeRecipients() {
+		domain := strings.ToLower(domainOf(address))
+		if checked[domain] {
+			continue
+		}
+		checked[domain] = true
+
+		deliverable, err := opts.MXLookup.Deliverable(domain)
+		if err != nil {
+			log.Printf("Unable to look up the mail servers of %s, accepting its recipients: %s", domain, err.Error())
+			continue
+		}
+		if !deliverable {
+			undeliverable = append(undeliverable, domain)
+		}
+	}
+	if len(undeliverable) > 0 {
+		return fmt.Errorf("recipient domains %s have no mail server", strings.Join(undeliverable, ", "))
+	}
+
+	return nil
+}
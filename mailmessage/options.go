@@ -0,0 +1,215 @@
+package mailmessage
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/forsam-education/hermes/antivirus"
+	"github.com/forsam-education/hermes/dkim"
+	"github.com/forsam-education/hermes/logging"
+	"github.com/forsam-education/hermes/metrics"
+	"github.com/forsam-education/hermes/mjml"
+	"github.com/forsam-education/hermes/mxlookup"
+	"github.com/forsam-education/hermes/ratelimit"
+	"github.com/forsam-education/hermes/signing"
+	"github.com/forsam-education/hermes/smime"
+	"github.com/forsam-education/hermes/storage"
+	"github.com/forsam-education/hermes/suppression"
+	"github.com/forsam-education/hermes/templating"
+	"github.com/forsam-education/hermes/tracing"
+	"github.com/forsam-education/hermes/tracking"
+	"github.com/forsam-education/hermes/transport"
+	"github.com/forsam-education/hermes/unsubscribe"
+	"github.com/forsam-education/hermes/warmup"
+	"time"
+)
+
+// Options holds the configurable behaviours of the mail building pipeline.
+type Options struct {
+	// Aliases maps mailing-list aliases, ie: "team:support", to the addresses they expand to.
+	Aliases Aliases
+	// MaxContextBytes is the maximum size of the raw JSON template context, 0 meaning no limit.
+	MaxContextBytes int
+	// MaxFanOut is the maximum number of entries of a fan-out message, 0 meaning no limit.
+	MaxFanOut int
+	// UndisclosedRecipients sets "To: undisclosed-recipients:;" on messages having only Bcc recipients, instead of omitting the To header.
+	UndisclosedRecipients bool
+	// TextSignature is appended to the plain text body after a "-- " delimiter, unless the body already has a signature.
+	TextSignature string
+	// AttachmentBuckets lists the buckets, besides the attachment one, attachments may reference.
+	AttachmentBuckets []string
+	// MaxAttachmentBytes is the maximum size of each attachment, 0 meaning no limit.
+	MaxAttachmentBytes int64
+	// MaxTotalAttachmentBytes is the maximum size of all the attachments of a message, those sent as links excluded, 0 meaning no limit.
+	MaxTotalAttachmentBytes int64
+	// MaxBodyBytes is the maximum size of each rendered body, 0 meaning no limit.
+	MaxBodyBytes int
+	// AttachmentLinkFallback replaces the attachments above MaxAttachmentBytes by download links in the body, instead of failing.
+	AttachmentLinkFallback bool
+	// AttachmentLinkExpiry is the validity duration of the attachments download links.
+	AttachmentLinkExpiry time.Duration
+	// SendLimits bounds the concurrent sends and throttles them per template and provider, nil meaning no limit.
+	SendLimits *ratelimit.Controller
+	// Suppressions lists the addresses which are never mailed, nil meaning every address is.
+	Suppressions suppression.List
+	// Warmup caps the daily sends while a new sending IP or domain is warming up, nil meaning no cap.
+	Warmup *warmup.Ramp
+	// RateLimitMaxWait is how long a limited message may wait for its turn before failing.
+	RateLimitMaxWait time.Duration
+	// RenderTimeout bounds the render phase, template fetches included, 0 meaning it is only bounded by the context.
+	RenderTimeout time.Duration
+	// SendTimeout bounds the send phase, from the wait for the sending limits to the SMTP reply, 0 meaning it is only bounded by the context.
+	SendTimeout time.Duration
+	// TextCharset is the charset of the plain text part, UTF-8 when empty.
+	TextCharset string
+	// HTMLCharset is the charset of the HTML part, UTF-8 when empty.
+	HTMLCharset string
+	// BodyContentTypes lists the content types a message may use for its primary part instead of text/plain.
+	BodyContentTypes []string
+	// DedupeAttachments skips the attachments having the same content as a previous attachment of the message.
+	DedupeAttachments bool
+	// SanitizeHTML removes the scripts, frames, forms, event handlers and unsafe links of the rendered HTML body, keeping the markup safe in an email.
+	SanitizeHTML bool
+	// ContextMarkup is the policy, ContextMarkupEscape, ContextMarkupStrip or ContextMarkupReject, applied to the context values holding markup.
+	// The values are left to the template escaping when empty.
+	ContextMarkup string
+	// InvalidRecipients is the policy, InvalidRecipientsReject or InvalidRecipientsDrop, applied to the invalid cc and bcc recipients.
+	// The messages having one are rejected when empty.
+	InvalidRecipients string
+	// MinifyHTML strips the comments and collapses the whitespaces of the HTML body, except in preformatted text and conditional comments.
+	MinifyHTML bool
+	// AttachmentDigests adds the SHA-256 digest of each attachment in the X-Attachment-Digests header.
+	AttachmentDigests bool
+	// AllowedAttachmentTypes lists the content types the attachments may have, "type/*" allowing every subtype, none meaning all of them.
+	AllowedAttachmentTypes []string
+	// AttachmentScanner scans the content of each attachment for viruses before sending, nil meaning none.
+	AttachmentScanner antivirus.Scanner
+	// NonCompliantAttachments is the policy, NonCompliantAttachmentsReject or NonCompliantAttachmentsStrip, applied to the attachments
+	// whose content type is not allowed, which are infected, or which are above MaxAttachmentBytes. The messages having one are rejected when empty.
+	NonCompliantAttachments string
+	// Signatures provides the keys the messages are verified with by VerifySignature, the unsigned ones being rejected. Messages are not
+	// signed when nil.
+	Signatures signing.KeySource
+	// Unsubscribe builds the per-recipient unsubscribe URLs exposed to templates, nil meaning none.
+	Unsubscribe *unsubscribe.URLBuilder
+	// Tracking rewrites the links of the HTML bodies and adds them an open tracking pixel, nil meaning messages are not tracked.
+	Tracking *tracking.Injector
+	// MarkdownTemplates renders the Markdown version of the templates, when they have one, as both their HTML and plain text bodies.
+	MarkdownTemplates bool
+	// MarkdownLayout is the name of the HTML template wrapping the HTML converted from the Markdown templates as {{.Content}}, none being used when empty.
+	MarkdownLayout string
+	// MJML compiles the MJML versions of the templates, nil meaning they are ignored and the HTML templates are used.
+	MJML mjml.Compiler
+	// TemplateEngines are the engines whose HTML and TXT templates, stored with their extension, ie: "welcome.html.mustache", are rendered
+	// instead of the ".template" ones when they exist.
+	TemplateEngines []templating.Engine
+	// DefaultEngine renders the ".template" HTML and TXT templates, nil meaning they are Go templates.
+	DefaultEngine templating.Engine
+	// SMIME maps the transport profiles to the S/MIME identity signing their messages, the default transport being the empty name.
+	// Transports without identity send unsigned messages.
+	SMIME map[string]*smime.Identity
+	// DKIM holds the signing identities, nil meaning messages are not signed.
+	DKIM *dkim.Identities
+	// DefaultFromAddress is the sender of the messages without from_address, none being required when empty.
+	DefaultFromAddress string
+	// DefaultFromName is the from name of the messages sent from DefaultFromAddress without a from_name.
+	DefaultFromName string
+	// DefaultReplyTo is the reply-to address of the messages without one.
+	DefaultReplyTo string
+	// SenderDomains lists the domains the from addresses must belong to, every domain being allowed when empty.
+	SenderDomains []string
+	// Transports maps the transport profiles a message may select with its transport field to their dialers.
+	Transports map[string]transport.Dialer
+	// QuietHours defers the messages due during the quiet hours of their category until they end, with SendTime.
+	QuietHours QuietHours
+	// DomainRules are the rules of the recipient domains, blocking them or routing them through a transport profile. Their rates are
+	// honored by SendLimits, which must be built with them.
+	DomainRules DomainRules
+	// Tenants maps the tenants a message may select with its tenant field to their settings.
+	Tenants map[string]*Tenant
+	// ForceFromName replaces the from name of every message, when not empty.
+	ForceFromName string
+	// ReturnPath is the envelope sender of the messages without return_path, receiving their bounces instead of their from address,
+	// ie: "bounces+{recipient}@example.com". The from address is used when empty.
+	ReturnPath string
+	// RejectSpoofyFrom rejects the messages whose from name contains an address other than the from address.
+	RejectSpoofyFrom bool
+	// HeaderEncoding is the RFC 2047 encoding, HeaderEncodingQ or HeaderEncodingB, of the non-ASCII display names and subjects, or
+	// HeaderEncodingASCII to transliterate them. Q is used when empty.
+	HeaderEncoding string
+	// SMTPUTF8 sends the internationalized addresses as is, written unencoded in the headers as RFC 6532 requires, which the SMTP server must
+	// support. Otherwise their domains are converted to ASCII, and the addresses with a non-ASCII local part are invalid.
+	SMTPUTF8 bool
+	// Preprocessors selects the registered preprocessors applied to the context of each template.
+	Preprocessors TemplatePreprocessors
+	// MXLookup rejects the messages having a recipient whose domain has no mail server, nil meaning the domains are not checked.
+	MXLookup *mxlookup.Resolver
+	// Variants splits the recipients of the templates running an experiment between their variants, a message variant field taking precedence.
+	Variants TemplateVariants
+	// StrictJSON rejects the messages having fields unknown to the message format.
+	StrictJSON bool
+	// StrictTemplates fails the messages whose template reads a variable missing from the context, instead of rendering "<no value>".
+	StrictTemplates bool
+	// TextFromHTML generates the plain text part from the rendered HTML body when a template has no TXT version.
+	TextFromHTML bool
+	// MissingTemplates is the policy, MissingTemplatesFail, MissingTemplatesText, MissingTemplatesHTML or MissingTemplatesAny, applied to the
+	// templates missing their HTML or TXT version, which fail the message when empty.
+	MissingTemplates string
+	// TemplateNotFoundRetries is how many times a missing HTML or TXT template is fetched again before failing the message.
+	TemplateNotFoundRetries int
+	// TemplateNotFoundBackoff is the delay before the first template fetch retry, doubled after each attempt.
+	TemplateNotFoundBackoff time.Duration
+	// Clock gives the Date header of the messages without a date, time.Now being used when nil.
+	Clock func() time.Time
+	// DryRun builds every message without sending it, as if they all had dry_run set.
+	DryRun bool
+	// RecipientOverride receives every message instead of its To, Cc and Bcc recipients, so a staging deployment never mails real customers.
+	RecipientOverride string
+	// Shadow selects the messages rendered without being delivered to their recipients, ie: to validate a new template in production.
+	Shadow Shadow
+	// Previews stores the messages built by dry runs, nil meaning they are written to the standard output.
+	Previews storage.ObjectWriter
+	// PreviewPrefix is the key prefix of the stored previews.
+	PreviewPrefix string
+	// Snapshots writes the rendered bodies of each template version sent, nil meaning none are.
+	Snapshots *Snapshots
+	// Archive stores a copy of every sent message, as it was sent, nil meaning they are not archived.
+	Archive storage.ObjectWriter
+	// ArchivePrefix is the key prefix of the archived messages.
+	ArchivePrefix string
+	// Tracer records the processing phases of each message, nil meaning they are not traced.
+	Tracer *tracing.Tracer
+	// Metrics counts the dropped invalid recipients as invalid_recipients_dropped, nil meaning they are not counted.
+	Metrics metrics.Recorder
+	// Logger logs the tracking id of the tracked messages once sent, nil meaning the standard logger is used.
+	Logger *logging.Logger
+	// Enrichers merge context fields looked up in external sources into the template context before it is rendered, in order.
+	Enrichers []ContextEnricher
+	// Hooks run around the render and send phases, ie: to add a validation or audit log the sent messages.
+	Hooks Hooks
+}
+
+// Aliases maps mailing-list aliases to the real addresses they stand for.
+type Aliases map[string][]string
+
+// UnmarshalText decodes aliases from their JSON representation, so they can be read from an environment variable.
+func (aliases *Aliases) UnmarshalText(text []byte) error {
+	return json.Unmarshal(text, (*map[string][]string)(aliases))
+}
+
+// stageContext returns the context of a phase, bounded by its timeout if it has one.
+func stageContext(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, timeout)
+}
+
+// now returns the current time of the configured clock.
+func (opts Options) now() time.Time {
+	if opts.Clock == nil {
+		return time.Now()
+	}
+
+	return opts.Clock()
+}
@@ -0,0 +1,111 @@
+package mailmessage
+
+import (
+	"fmt"
+	"github.com/forsam-education/hermes/storage"
+	htemplate "html/template"
+	"regexp"
+	ttemplate "text/template"
+)
+
+var (
+	// templateReference matches the templates invoked by a template, ie: {{template "header" .}}.
+	templateReference = regexp.MustCompile(`{{-?\s*(?:template|block)\s+"([^"]+)"`)
+	// templateDefinition matches the templates defined by a template, ie: {{define "content"}}.
+	templateDefinition = regexp.MustCompile(`{{-?\s*(?:define|block)\s+"([^"]+)"`)
+)
+
+// partial is a shared template, ie: a header, a footer or a layout, invoked by name from other templates.
+type partial struct {
+	name    string
+	content string
+}
+
+func matchedNames(pattern *regexp.Regexp, content string) []string {
+	var names []string
+	for _, match := range pattern.FindAllStringSubmatch(content, -1) {
+		names = append(names, match[1])
+	}
+
+	return names
+}
+
+// fetchPartials fetches the partials invoked by the template content, and by these partials in turn. A partial named "header" is stored
+// as "_header" followed by the suffix of the template, ie: "_header.html.template". Invoked templates defined by the content itself, or
+// missing from the storage, are skipped, the latter failing at execution.
+func fetchPartials(templateConnector storage.TemplateFetcher, content string, suffix string) ([]partial, error) {
+	known := make(map[string]bool)
+	for _, name := range matchedNames(templateDefinition, content) {
+		known[name] = true
+	}
+	pending := matchedNames(templateReference, content)
+
+	var partials []partial
+	for len(pending) > 0 {
+		name := pending[0]
+		pending = pending[1:]
+		if known[name] {
+			continue
+		}
+		known[name] = true
+
+		partialContent, err := templateConnector.Fetch("_" + name + suffix)
+		if storage.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to fetch partial %q: %s", name, err.Error())
+		}
+		partials = append(partials, partial{name: name, content: partialContent})
+		for _, defined := range matchedNames(templateDefinition, partialContent) {
+			known[defined] = true
+		}
+		pending = append(pending, matchedNames(templateReference, partialContent)...)
+	}
+
+	return partials, nil
+}
+
+// templateSet is the part of the html/template and text/template Template API needed to register partials.
+type templateSet interface {
+	Parse(text string) error
+	New(name string) templateSet
+}
+
+type htmlSet struct{ *htemplate.Template }
+
+func (set htmlSet) Parse(text string) error {
+	_, err := set.Template.Parse(text)
+	return err
+}
+
+func (set htmlSet) New(name string) templateSet {
+	return htmlSet{set.Template.New(name)}
+}
+
+type textSet struct{ *ttemplate.Template }
+
+func (set textSet) Parse(text string) error {
+	_, err := set.Template.Parse(text)
+	return err
+}
+
+func (set textSet) New(name string) templateSet {
+	return textSet{set.Template.New(name)}
+}
+
+// parseWithPartials fetches the partials of the template content and parses them in the set before the content, so the blocks a layout
+// partial declares are overridden by the definitions of the content.
+func parseWithPartials(templateConnector storage.TemplateFetcher, set templateSet, content string, suffix string) error {
+	partials, err := fetchPartials(templateConnector, content, suffix)
+	if err != nil {
+		return err
+	}
+	for _, partial := range partials {
+		if err := set.New(partial.name).Parse(partial.content); err != nil {
+			return fmt.Errorf("unable to parse partial %q: %s", partial.name, err.Error())
+		}
+	}
+
+	return set.Parse(content)
+}
@@ -0,0 +1,149 @@
+package mailmessage
+
+import (
+	"golang.org/x/net/html"
+	"regexp"
+	"strings"
+)
+
+var (
+	// skippedElements hold no readable text.
+	skippedElements = map[string]bool{"head": true, "title": true, "style": true, "script": true, "template": true}
+	// blockElements start and end on their own line.
+	blockElements = map[string]bool{
+		"address": true, "article": true, "aside": true, "blockquote": true, "center": true, "dd": true, "div": true, "dl": true, "dt": true,
+		"footer": true, "form": true, "header": true, "li": true, "main": true, "nav": true, "ol": true, "section": true, "table": true,
+		"tbody": true, "td": true, "tfoot": true, "th": true, "thead": true, "tr": true, "ul": true,
+	}
+	// paragraphElements are separated from the surrounding text by a blank line.
+	paragraphElements = map[string]bool{"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true, "p": true, "pre": true}
+	blankLines        = regexp.MustCompile(`\n{3,}`)
+)
+
+// plainTextWriter accumulates the text of an HTML document, collapsing its whitespaces as a browser would.
+type plainTextWriter struct {
+	builder      strings.Builder
+	pendingSpace bool
+}
+
+func (writer *plainTextWriter) text(text string, preformatted bool) {
+	if preformatted {
+		writer.builder.WriteString(text)
+		writer.pendingSpace = false
+		return
+	}
+
+	collapsed := whitespaces.ReplaceAllString(text, " ")
+	if strings.HasPrefix(collapsed, " ") {
+		writer.pendingSpace = true
+	}
+	word := strings.TrimSpace(collapsed)
+	if word == "" {
+		return
+	}
+	output := writer.builder.String()
+	if writer.pendingSpace && output != "" && !strings.HasSuffix(output, "\n") && !strings.HasSuffix(output, " ") {
+		writer.builder.WriteByte(' ')
+	}
+	writer.builder.WriteString(word)
+	writer.pendingSpace = strings.HasSuffix(collapsed, " ")
+}
+
+func (writer *plainTextWriter) newlines(count int) {
+	output := writer.builder.String()
+	trailing := len(output) - len(strings.TrimRight(output, "\n"))
+	if len(output) == 0 {
+		return
+	}
+	for ; trailing < count; trailing++ {
+		writer.builder.WriteByte('\n')
+	}
+	writer.pendingSpace = false
+}
+
+// htmlToText returns a plain text version of the HTML body, keeping its paragraphs, list items and the URLs of its links, ie:
+// `<a href="https://example.com">our site</a>` becoming "our site (https://example.com)".
+func htmlToText(body string) string {
+	tokenizer := html.NewTokenizer(strings.NewReader(body))
+	writer := &plainTextWriter{}
+	var links []string
+	var skipped, preformatted int
+	for {
+		tokenType := tokenizer.Next()
+		if tokenType == html.ErrorToken {
+			break
+		}
+		token := tokenizer.Token()
+		switch tokenType {
+		case html.TextToken:
+			if skipped == 0 {
+				writer.text(token.Data, preformatted > 0)
+			}
+		case html.StartTagToken, html.SelfClosingTagToken:
+			switch {
+			case skippedElements[token.Data]:
+				if tokenType == html.StartTagToken {
+					skipped++
+				}
+			case token.Data == "br":
+				writer.builder.WriteByte('\n')
+				writer.pendingSpace = false
+			case token.Data == "hr":
+				writer.newlines(1)
+				writer.builder.WriteString("---")
+				writer.newlines(1)
+			case token.Data == "li":
+				writer.newlines(1)
+				writer.builder.WriteString("- ")
+			case token.Data == "img":
+				writer.text(attribute(token, "alt"), false)
+			case token.Data == "a":
+				links = append(links, attribute(token, "href"))
+			case paragraphElements[token.Data]:
+				writer.newlines(2)
+			case blockElements[token.Data]:
+				writer.newlines(1)
+			}
+			if token.Data == "pre" && tokenType == html.StartTagToken {
+				preformatted++
+			}
+		case html.EndTagToken:
+			switch {
+			case skippedElements[token.Data]:
+				if skipped > 0 {
+					skipped--
+				}
+			case token.Data == "a" && len(links) > 0:
+				href := links[len(links)-1]
+				links = links[:len(links)-1]
+				if href != "" && !strings.HasPrefix(href, "#") && !strings.HasSuffix(writer.builder.String(), href) {
+					writer.builder.WriteString(" (" + href + ")")
+				}
+			case paragraphElements[token.Data]:
+				writer.newlines(2)
+			case blockElements[token.Data]:
+				writer.newlines(1)
+			}
+			if token.Data == "pre" && preformatted > 0 {
+				preformatted--
+			}
+		}
+	}
+
+	lines := strings.Split(writer.builder.String(), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t")
+	}
+
+	return strings.TrimSpace(blankLines.ReplaceAllString(strings.Join(lines, "\n"), "\n\n")) + "\n"
+}
+
+func attribute(token html.Token, name string) string {
+	for _, attr := range token.Attr {
+		if attr.Key == name {
+			return strings.TrimSpace(attr.Val)
+		}
+	}
+
+	return ""
+}
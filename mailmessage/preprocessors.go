@@ -0,0 +1,54 @@
+package mailmessage
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Preprocessor transforms a template context before the templates are executed, ie: to format a domain object once for both bodies.
+type Preprocessor func(templateContext map[string]interface{}) (map[string]interface{}, error)
+
+// preprocessors is the registry of the named preprocessors, filled at init time.
+var preprocessors = make(map[string]Preprocessor)
+
+// RegisterPreprocessor makes a preprocessor selectable by name in the template configuration. It must be called from an init function.
+func RegisterPreprocessor(name string, preprocessor Preprocessor) {
+	preprocessors[name] = preprocessor
+}
+
+// TemplatePreprocessors maps template names to the names of the preprocessors applied, in order, to their context.
+type TemplatePreprocessors map[string][]string
+
+// UnmarshalText decodes the template preprocessors from their JSON representation, so they can be read from an environment variable.
+func (templatePreprocessors *TemplatePreprocessors) UnmarshalText(text []byte) error {
+	return json.Unmarshal(text, (*map[string][]string)(templatePreprocessors))
+}
+
+// Validate checks every selected preprocessor is registered.
+func (templatePreprocessors TemplatePreprocessors) Validate() error {
+	for template, names := range templatePreprocessors {
+		for _, name := range names {
+			if _, ok := preprocessors[name]; !ok {
+				return fmt.Errorf("template %q uses the unknown preprocessor %q", template, name)
+			}
+		}
+	}
+
+	return nil
+}
+
+// apply runs the preprocessors of the template on its context.
+func (templatePreprocessors TemplatePreprocessors) apply(template string, templateContext map[string]interface{}) (map[string]interface{}, error) {
+	for _, name := range templatePreprocessors[template] {
+		preprocessor, ok := preprocessors[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown preprocessor %q", name)
+		}
+		var err error
+		if templateContext, err = preprocessor(templateContext); err != nil {
+			return nil, fmt.Errorf("preprocessor %q failed: %s", name, err.Error())
+		}
+	}
+
+	return templateContext, nil
+}
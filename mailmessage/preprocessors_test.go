@@ -0,0 +1,142 @@
+package mailmessage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func init() {
+	RegisterPreprocessor("formatOrder", func(templateContext map[string]interface{}) (map[string]interface{}, error) {
+		order, ok := templateContext["order"].(map[string]interface{})
+		if !ok {
+			return nil, errors.New("order is missing")
+		}
+		templateContext["order_summary"] = fmt.Sprintf("Order #%s (%s items)", order["id"], order["items"])
+		return templateContext, nil
+	})
+	RegisterPreprocessor("shout", func(templateContext map[string]interface{}) (map[string]interface{}, error) {
+		shouted := make(map[string]interface{}, len(templateContext))
+		for key, value := range templateContext {
+			if text, ok := value.(string); ok {
+				value = strings.ToUpper(text)
+			}
+			shouted[key] = value
+		}
+		return shouted, nil
+	})
+}
+
+func TestTemplatePreprocessorsApply(t *testing.T) {
+	tests := []struct {
+		name          string
+		preprocessors TemplatePreprocessors
+		template      string
+		context       string
+		expected      map[string]interface{}
+		err           string
+	}{
+		{
+			name:          "leaves the context of other templates",
+			preprocessors: TemplatePreprocessors{"order": {"formatOrder"}},
+			template:      "welcome",
+			context:       `{"name": "Jane"}`,
+			expected:      map[string]interface{}{"name": "Jane"},
+		},
+		{
+			name:          "applies the preprocessor of the template",
+			preprocessors: TemplatePreprocessors{"order": {"formatOrder"}},
+			template:      "order",
+			context:       `{"order": {"id": 12345678901234567, "items": 3}}`,
+			expected:      map[string]interface{}{"order_summary": "Order #12345678901234567 (3 items)"},
+		},
+		{
+			name:          "applies the preprocessors in order",
+			preprocessors: TemplatePreprocessors{"order": {"formatOrder", "shout"}},
+			template:      "order",
+			context:       `{"order": {"id": 42, "items": 1}}`,
+			expected:      map[string]interface{}{"order_summary": "ORDER #42 (1 ITEMS)"},
+		},
+		{
+			name:          "reports the failing preprocessor",
+			preprocessors: TemplatePreprocessors{"order": {"shout", "formatOrder"}},
+			template:      "order",
+			context:       `{"name": "Jane"}`,
+			err:           `preprocessor "formatOrder" failed: order is missing`,
+		},
+		{
+			name:          "fails on an unknown preprocessor",
+			preprocessors: TemplatePreprocessors{"order": {"formatInvoice"}},
+			template:      "order",
+			context:       `{}`,
+			err:           `unknown preprocessor "formatInvoice"`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var templateContext map[string]interface{}
+			decoder := json.NewDecoder(strings.NewReader(test.context))
+			decoder.UseNumber()
+			if err := decoder.Decode(&templateContext); err != nil {
+				t.Fatal(err)
+			}
+
+			processed, err := test.preprocessors.apply(test.template, templateContext)
+			if test.err != "" {
+				if err == nil || err.Error() != test.err {
+					t.Fatalf("expected error %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+			for key, value := range test.expected {
+				if processed[key] != value {
+					t.Errorf("expected %s to be %v, got %v", key, value, processed[key])
+				}
+			}
+		})
+	}
+}
+
+func TestTemplatePreprocessorsValidate(t *testing.T) {
+	var preprocessors TemplatePreprocessors
+	if err := preprocessors.UnmarshalText([]byte(`{"order": ["formatOrder", "shout"]}`)); err != nil {
+		t.Fatalf("unable to decode preprocessors: %s", err.Error())
+	}
+	if err := preprocessors.Validate(); err != nil {
+		t.Errorf("expected the registered preprocessors to be valid, got %s", err.Error())
+	}
+
+	preprocessors["invoice"] = []string{"formatInvoice"}
+	if err := preprocessors.Validate(); err == nil || err.Error() != `template "invoice" uses the unknown preprocessor "formatInvoice"` {
+		t.Errorf("expected an unknown preprocessor to be rejected, got %v", err)
+	}
+}
+
+func TestSendMailAppliesPreprocessors(t *testing.T) {
+	templates := newTestTemplates()
+	templates.Set("order.html.template", []byte("<p>{{.order_summary}}</p>"))
+	templates.Set("order.txt.template", []byte("{{.order_summary}}"))
+	opts := Options{Preprocessors: TemplatePreprocessors{"order": {"formatOrder"}}}
+
+	body := testMessageBody(map[string]interface{}{"template_name": "order", "template_context": map[string]interface{}{"order": map[string]interface{}{"id": 42, "items": 2}}})
+	_, parts := parseTestMessage(t, sendTestMessage(t, templates, opts, body)[0])
+	for _, part := range parts {
+		if !strings.Contains(part.body, "Order #42 (2 items)") {
+			t.Errorf("expected the %s part to render the preprocessed context, got %q", part.contentType, part.body)
+		}
+	}
+
+	rendering, err := PreviewMail(templates, opts, "order", "", "Order", json.RawMessage(`{"order": {"id": 43, "items": 1}}`))
+	if err != nil {
+		t.Fatalf("unable to preview: %s", err.Error())
+	}
+	if rendering.Text != "Order #43 (1 items)" {
+		t.Errorf("expected the preview to render the preprocessed context, got %q", rendering.Text)
+	}
+}
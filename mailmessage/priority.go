@@ -0,0 +1,75 @@
+package mailmessage
+
+import (
+	"encoding/json"
+	"fmt"
+	"gopkg.in/gomail.v2"
+	"strings"
+)
+
+// Importance levels of the priority field.
+const (
+	PriorityHigh   = "high"
+	PriorityNormal = "normal"
+	PriorityLow    = "low"
+)
+
+// importanceOrders are the processing orders of the importance levels, relative to the default 0 priority.
+var importanceOrders = map[string]int{PriorityHigh: 1, PriorityNormal: 0, PriorityLow: -1}
+
+// importanceHeaders are the X-Priority, Importance and X-MSMail-Priority headers of each importance level, read by the different clients.
+var importanceHeaders = map[string][3]string{
+	PriorityHigh:   {"1 (Highest)", "High", "High"},
+	PriorityNormal: {"3 (Normal)", "Normal", "Normal"},
+	PriorityLow:    {"5 (Lowest)", "Low", "Low"},
+}
+
+// messagePriority is the priority field of a message: either a number ordering the processing of the messages of a batch,
+// or an importance level, high, normal or low, which also sets the importance headers and is ordered as 1, 0 and -1.
+type messagePriority struct {
+	order      int
+	importance string
+}
+
+// UnmarshalJSON decodes the priority from a number or an importance level.
+func (priority *messagePriority) UnmarshalJSON(data []byte) error {
+	if len(data) == 0 || data[0] != '"' {
+		return json.Unmarshal(data, &priority.order)
+	}
+
+	var importance string
+	if err := json.Unmarshal(data, &importance); err != nil {
+		return err
+	}
+	importance = strings.ToLower(importance)
+	order, ok := importanceOrders[importance]
+	if !ok {
+		return fmt.Errorf("priority %q is unknown, expecting high, normal, low or a number", importance)
+	}
+	priority.order, priority.importance = order, importance
+
+	return nil
+}
+
+// setImportanceHeaders sets the importance headers of the message priority, if it is an importance level. The headers field may override them.
+func setImportanceHeaders(message *gomail.Message, mailMsg *mailMessage) {
+	values, ok := importanceHeaders[mailMsg.Priority.importance]
+	if !ok {
+		return
+	}
+	for i, name := range []string{"X-Priority", "Importance", "X-MSMail-Priority"} {
+		message.SetHeader(name, values[i])
+	}
+}
+
+// PriorityOrder returns the processing order of a message body, 0 if it has no valid priority.
+func PriorityOrder(messageBody string) int {
+	var mailMsg struct {
+		Priority messagePriority `json:"priority"`
+	}
+	if err := json.Unmarshal([]byte(messageBody), &mailMsg); err != nil {
+		return 0
+	}
+
+	return mailMsg.Priority.order
+}
@@ -0,0 +1,115 @@
+package mailmessage
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// DailyWindow is a daily time range, in minutes after midnight, a window ending before it starts spanning midnight, ie: 22:00-08:00.
+type DailyWindow struct {
+	Start int
+	End   int
+}
+
+// parseClock parses a time of day written as 15:04, returning it in minutes after midnight.
+func parseClock(value string) (int, error) {
+	clock, err := time.Parse("15:04", strings.TrimSpace(value))
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a HH:MM time", value)
+	}
+
+	return clock.Hour()*60 + clock.Minute(), nil
+}
+
+// UnmarshalText decodes the window from its HH:MM-HH:MM representation, so it can be read from an environment variable.
+func (window *DailyWindow) UnmarshalText(text []byte) error {
+	bounds := strings.Split(string(text), "-")
+	if len(bounds) != 2 {
+		return fmt.Errorf("%q is not a HH:MM-HH:MM window", string(text))
+	}
+
+	var err error
+	if window.Start, err = parseClock(bounds[0]); err != nil {
+		return err
+	}
+	if window.End, err = parseClock(bounds[1]); err != nil {
+		return err
+	}
+	if window.Start == window.End {
+		return fmt.Errorf("window %q is empty", string(text))
+	}
+
+	return nil
+}
+
+// ends returns the end of the window the time falls within, in its location, or the zero time when it is out of the window.
+func (window DailyWindow) ends(at time.Time) time.Time {
+	minutes := at.Hour()*60 + at.Minute()
+	year, month, day := at.Date()
+	switch {
+	case window.Start < window.End && minutes >= window.Start && minutes < window.End:
+	case window.Start > window.End && minutes >= window.Start:
+		// The window spans midnight, and ends on the next day.
+		day++
+	case window.Start > window.End && minutes < window.End:
+	default:
+		return time.Time{}
+	}
+
+	return time.Date(year, month, day, window.End/60, window.End%60, 0, 0, at.Location())
+}
+
+// QuietHours are the hours the messages of some categories are not sent at, in the timezone of their recipient, ie: no bulk message
+// from 22:00 to 08:00. The messages due during the quiet hours are deferred until they end.
+type QuietHours struct {
+	// Window is the quiet hours span, none being quiet when its start and end are equal.
+	Window DailyWindow
+	// Categories lists the categories of the deferred messages, none meaning all of them. The messages without category are transactional.
+	Categories []string
+	// Location is the timezone of the recipients of the messages without timezone field, UTC when nil.
+	Location *time.Location
+}
+
+// applies tells if the quiet hours defer the messages of the category.
+func (quietHours QuietHours) applies(category string) bool {
+	if quietHours.Window.Start == quietHours.Window.End {
+		return false
+	}
+	if category == "" {
+		category = CategoryTransactional
+	}
+
+	return contains(quietHours.Categories, category)
+}
+
+// SendTime returns the date a message body is due at: its send_at, or the end of the quiet hours when it falls within those of its category,
+// in the recipient timezone given by its timezone field, ie: "Europe/Paris". It is the zero time when the message is due now, or can't be decoded.
+func SendTime(messageBody string, opts Options, now time.Time) time.Time {
+	sendAt := SendAt(messageBody)
+	var mailMsg struct {
+		Category string `json:"category"`
+		Timezone string `json:"timezone"`
+	}
+	if err := json.Unmarshal([]byte(messageBody), &mailMsg); err != nil || !opts.QuietHours.applies(mailMsg.Category) {
+		return sendAt
+	}
+
+	location := opts.QuietHours.Location
+	if recipientLocation, err := time.LoadLocation(mailMsg.Timezone); mailMsg.Timezone != "" && err == nil {
+		location = recipientLocation
+	}
+	if location == nil {
+		location = time.UTC
+	}
+	due := now
+	if sendAt.After(now) {
+		due = sendAt
+	}
+	if quietEnd := opts.QuietHours.Window.ends(due.In(location)); !quietEnd.IsZero() {
+		return quietEnd
+	}
+
+	return sendAt
+}
@@ -0,0 +1,165 @@
+package mailmessage
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestDailyWindowUnmarshalText(t *testing.T) {
+	tests := []struct {
+		text     string
+		expected DailyWindow
+		err      string
+	}{
+		{text: "22:00-08:00", expected: DailyWindow{Start: 22 * 60, End: 8 * 60}},
+		{text: "12:30 - 13:45", expected: DailyWindow{Start: 12*60 + 30, End: 13*60 + 45}},
+		{text: "22:00", err: `"22:00" is not a HH:MM-HH:MM window`},
+		{text: "22h-08h", err: `"22h" is not a HH:MM time`},
+		{text: "08:00-08:00", err: `window "08:00-08:00" is empty`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.text, func(t *testing.T) {
+			var window DailyWindow
+			err := window.UnmarshalText([]byte(test.text))
+			if test.err != "" {
+				if err == nil || err.Error() != test.err {
+					t.Errorf("expected error %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil || window != test.expected {
+				t.Errorf("expected %+v, got %+v, %v", test.expected, window, err)
+			}
+		})
+	}
+}
+
+func TestDailyWindowEnds(t *testing.T) {
+	night := DailyWindow{Start: 22 * 60, End: 8 * 60}
+	lunch := DailyWindow{Start: 12 * 60, End: 14 * 60}
+	day := func(hour int, minute int) time.Time {
+		return time.Date(2020, 10, 26, hour, minute, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name     string
+		window   DailyWindow
+		at       time.Time
+		expected time.Time
+	}{
+		{name: "before a midnight spanning window", window: night, at: day(21, 59)},
+		{name: "at the start of a midnight spanning window", window: night, at: day(22, 0), expected: time.Date(2020, 10, 27, 8, 0, 0, 0, time.UTC)},
+		{name: "before midnight", window: night, at: day(23, 59), expected: time.Date(2020, 10, 27, 8, 0, 0, 0, time.UTC)},
+		{name: "after midnight", window: night, at: day(0, 0), expected: day(8, 0)},
+		{name: "at the end of a midnight spanning window", window: night, at: day(8, 0)},
+		{name: "on the last day of the month", window: night, at: time.Date(2020, 10, 31, 23, 0, 0, 0, time.UTC), expected: time.Date(2020, 11, 1, 8, 0, 0, 0, time.UTC)},
+		{name: "within a window", window: lunch, at: day(12, 30), expected: day(14, 0)},
+		{name: "after a window", window: lunch, at: day(14, 0)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if ends := test.window.ends(test.at); !ends.Equal(test.expected) {
+				t.Errorf("expected %s, got %s", test.expected, ends)
+			}
+		})
+	}
+}
+
+func TestSendTime(t *testing.T) {
+	paris, err := time.LoadLocation("Europe/Paris")
+	if err != nil {
+		t.Skipf("timezone database is unavailable: %s", err.Error())
+	}
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("timezone database is unavailable: %s", err.Error())
+	}
+	quietHours := QuietHours{Window: DailyWindow{Start: 22 * 60, End: 8 * 60}, Categories: []string{CategoryBulk}, Location: paris}
+	beforeMidnight := time.Date(2020, 10, 26, 22, 30, 0, 0, paris)
+	afterMidnight := time.Date(2020, 10, 27, 6, 0, 0, 0, paris)
+	message := func(fields string) string {
+		return fmt.Sprintf(`{"to":["jane@example.org"],"template":"welcome"%s}`, fields)
+	}
+
+	tests := []struct {
+		name       string
+		body       string
+		quietHours QuietHours
+		now        time.Time
+		expected   time.Time
+	}{
+		{name: "defers a bulk message before midnight", body: message(`,"category":"bulk"`), quietHours: quietHours, now: beforeMidnight, expected: time.Date(2020, 10, 27, 8, 0, 0, 0, paris)},
+		{name: "defers a bulk message after midnight", body: message(`,"category":"bulk"`), quietHours: quietHours, now: afterMidnight, expected: time.Date(2020, 10, 27, 8, 0, 0, 0, paris)},
+		{name: "sends a bulk message out of the quiet hours", body: message(`,"category":"bulk"`), quietHours: quietHours, now: time.Date(2020, 10, 27, 8, 0, 0, 0, paris)},
+		{name: "exempts transactional messages", body: message(`,"category":"transactional"`), quietHours: quietHours, now: beforeMidnight},
+		{name: "exempts messages without category", body: message(""), quietHours: quietHours, now: beforeMidnight},
+		{
+			name:       "defers all categories when none is set",
+			body:       message(""),
+			quietHours: QuietHours{Window: quietHours.Window, Location: paris},
+			now:        beforeMidnight,
+			expected:   time.Date(2020, 10, 27, 8, 0, 0, 0, paris),
+		},
+		{name: "sends without quiet hours", body: message(`,"category":"bulk"`), now: beforeMidnight},
+		{
+			name:       "uses the recipient timezone",
+			body:       message(`,"category":"bulk","timezone":"America/New_York"`),
+			quietHours: quietHours,
+			now:        time.Date(2020, 10, 27, 6, 0, 0, 0, paris),
+			expected:   time.Date(2020, 10, 27, 8, 0, 0, 0, newYork),
+		},
+		{
+			name:       "sends when it is day in the recipient timezone",
+			body:       message(`,"category":"bulk","timezone":"America/New_York"`),
+			quietHours: quietHours,
+			now:        beforeMidnight,
+		},
+		{
+			name:       "falls back to the configured timezone for an invalid timezone",
+			body:       message(`,"category":"bulk","timezone":"Mars/Olympus_Mons"`),
+			quietHours: quietHours,
+			now:        beforeMidnight,
+			expected:   time.Date(2020, 10, 27, 8, 0, 0, 0, paris),
+		},
+		{
+			name:       "falls back to UTC without configured timezone",
+			body:       message(`,"category":"bulk"`),
+			quietHours: QuietHours{Window: quietHours.Window, Categories: quietHours.Categories},
+			now:        time.Date(2020, 10, 26, 23, 0, 0, 0, time.UTC),
+			expected:   time.Date(2020, 10, 27, 8, 0, 0, 0, time.UTC),
+		},
+		{
+			name:       "keeps a future send_at out of the quiet hours",
+			body:       message(`,"category":"bulk","send_at":"2020-10-27T09:00:00+01:00"`),
+			quietHours: quietHours,
+			now:        beforeMidnight,
+			expected:   time.Date(2020, 10, 27, 9, 0, 0, 0, paris),
+		},
+		{
+			name:       "defers a future send_at within the quiet hours",
+			body:       message(`,"category":"bulk","send_at":"2020-10-27T05:00:00+01:00"`),
+			quietHours: quietHours,
+			now:        time.Date(2020, 10, 26, 12, 0, 0, 0, paris),
+			expected:   time.Date(2020, 10, 27, 8, 0, 0, 0, paris),
+		},
+		{
+			name:       "keeps the send_at of transactional messages",
+			body:       message(`,"send_at":"2020-10-27T05:00:00+01:00"`),
+			quietHours: quietHours,
+			now:        time.Date(2020, 10, 26, 12, 0, 0, 0, paris),
+			expected:   time.Date(2020, 10, 27, 5, 0, 0, 0, paris),
+		},
+		{name: "sends a message which can't be decoded", body: "{", quietHours: quietHours, now: beforeMidnight},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if sendTime := SendTime(test.body, Options{QuietHours: test.quietHours}, test.now); !sendTime.Equal(test.expected) {
+				t.Errorf("expected %s, got %s", test.expected, sendTime)
+			}
+		})
+	}
+}
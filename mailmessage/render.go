@@ -0,0 +1,367 @@
+package mailmessage
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/forsam-education/hermes/storage"
+	htemplate "html/template"
+	"log"
+	"strings"
+	ttemplate "text/template"
+	"time"
+)
+
+// Rendering is the result of executing the templates of a message.
+type Rendering struct {
+	Subject string `json:"subject"`
+	HTML    string `json:"html"`
+	Text    string `json:"text"`
+	AMP     string `json:"amp,omitempty"`
+	// missing is the version of the template which was not found, missingHTML or missingText, the message being sent with its other body only.
+	missing string
+}
+
+// fetchTemplate fetches a required template, retrying with an exponential backoff while it is not found, as a just uploaded template may take some time to propagate.
+func fetchTemplate(templateConnector storage.TemplateFetcher, name string, opts Options) (string, error) {
+	backoff := opts.TemplateNotFoundBackoff
+	for attempt := 0; ; attempt++ {
+		content, err := templateConnector.Fetch(name)
+		if !storage.IsNotFound(err) || attempt >= opts.TemplateNotFoundRetries {
+			return content, err
+		}
+		log.Printf("Template %s is not found, retrying in %s", name, backoff)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// localeCandidates lists the locales a template is looked up for, from the most specific, ie: "fr-CA" then "fr".
+func localeCandidates(locale string) []string {
+	var candidates []string
+	for locale != "" {
+		candidates = append(candidates, locale)
+		separator := strings.LastIndexAny(locale, "-_")
+		if separator < 0 {
+			break
+		}
+		locale = locale[:separator]
+	}
+
+	return candidates
+}
+
+// fetchLocalizedTemplate fetches the version of the template for the locale, ie: "welcome.fr.html.template", falling back to less specific
+// locales then to the unlocalized template. Only the unlocalized template is retried while not found. It returns the key of the fetched template with its content.
+func fetchLocalizedTemplate(templateConnector storage.TemplateFetcher, templateName string, locale string, kind string, opts Options) (string, string, error) {
+	return fetchLocalizedFile(templateConnector, templateName, locale, kind, "template", opts)
+}
+
+// fetchLocalizedFile fetches the localized version of the template stored with the extension, ie: "welcome.fr.html.mustache", as fetchLocalizedTemplate does.
+func fetchLocalizedFile(templateConnector storage.TemplateFetcher, templateName string, locale string, kind string, extension string, opts Options) (string, string, error) {
+	for _, candidate := range localeCandidates(locale) {
+		key := fmt.Sprintf("%s.%s.%s.%s", templateName, candidate, kind, extension)
+		content, err := templateConnector.Fetch(key)
+		if !storage.IsNotFound(err) {
+			return key, content, err
+		}
+	}
+
+	key := fmt.Sprintf("%s.%s.%s", templateName, kind, extension)
+	content, err := fetchTemplate(templateConnector, key, opts)
+
+	return key, content, err
+}
+
+// renderTextTemplate fetches and executes the TXT template against the context. A missing template is reported by a *storage.NotFoundError,
+// and is not retried when the text can be generated from the HTML body instead.
+func renderTextTemplate(templateConnector storage.TemplateFetcher, templateName string, locale string, templateContext map[string]interface{}, opts Options) (string, error) {
+	fetchOpts := opts
+	if opts.TextFromHTML {
+		fetchOpts.TemplateNotFoundRetries = 0
+	}
+	if body, rendered, err := renderEngineTemplate(templateConnector, templateName, locale, "txt", templateContext, fetchOpts); rendered {
+		return body, err
+	}
+	txtTemplateKey, txtTemplateContent, err := fetchLocalizedTemplate(templateConnector, templateName, locale, "txt", fetchOpts)
+	if err != nil {
+		return "", err
+	}
+	txtTmpl := ttemplate.New(txtTemplateKey).Funcs(templateFuncs())
+	if err := parseWithPartials(templateConnector, textSet{txtTmpl}, txtTemplateContent, ".txt.template"); err != nil {
+		return "", fmt.Errorf("unable to parse TXT template: %s", err.Error())
+	}
+	if opts.StrictTemplates {
+		if err := checkContextFields(txtTmpl.Tree, templateContext); err != nil {
+			return "", err
+		}
+		txtTmpl.Option("missingkey=error")
+	}
+
+	var txtTmplBuffer bytes.Buffer
+	if err := txtTmpl.Execute(&txtTmplBuffer, templateContext); err != nil {
+		return "", fmt.Errorf("unable to execute TXT template: %s", err.Error())
+	}
+
+	return txtTmplBuffer.String(), nil
+}
+
+// renderTemplates fetches and executes the HTML, or MJML when there is one, TXT and optional AMP templates against the context, in their version for the locale if any.
+// When enabled, a missing TXT template is replaced by a plain text version of the HTML body, and a Markdown template replaces both the HTML and TXT ones.
+// A template missing its HTML or TXT version is rendered with the other one only when the MissingTemplates policy allows it, a template missing both failing.
+func renderTemplates(templateConnector storage.TemplateFetcher, templateName string, locale string, templateContext map[string]interface{}, opts Options) (*Rendering, error) {
+	var missing string
+	htmlBody, textBody, err := renderMarkdownTemplate(templateConnector, templateName, locale, templateContext, opts)
+	if storage.IsNotFound(err) {
+		htmlBody, err = renderMJMLTemplate(templateConnector, templateName, locale, templateContext, opts)
+		if storage.IsNotFound(err) {
+			htmlBody, err = renderHTMLTemplate(templateConnector, templateName, locale, templateContext, opts)
+		}
+		var htmlErr error
+		if storage.IsNotFound(err) && opts.sendsWithout(missingHTML) {
+			missing, htmlErr, err = missingHTML, err, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		textBody, err = renderTextTemplate(templateConnector, templateName, locale, templateContext, opts)
+		if storage.IsNotFound(err) && missing == "" && opts.TextFromHTML {
+			textBody, err = htmlToText(htmlBody), nil
+		}
+		if storage.IsNotFound(err) && missing == "" && opts.sendsWithout(missingText) {
+			missing, err = missingText, nil
+		}
+		if storage.IsNotFound(err) && htmlErr != nil {
+			// The template has no version to send.
+			err = htmlErr
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// The AMP version is not sent without the HTML version it falls back to.
+	var ampBody string
+	if missing != missingHTML {
+		ampBody, err = renderAMPTemplate(templateConnector, templateName, locale, templateContext, opts)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if missing != "" {
+		putDegraded(templateName, missing, opts)
+	}
+
+	return &Rendering{HTML: htmlBody, Text: textBody, AMP: ampBody, missing: missing}, nil
+}
+
+// markdownContentKey is the layout context key receiving the HTML converted from a Markdown template.
+const markdownContentKey = "Content"
+
+// renderMarkdownTemplate executes the Markdown version of the template against the context, and converts the result to the HTML body, wrapped in
+// the Markdown layout if any, and to the plain text body. It returns a *storage.NotFoundError when Markdown templates are disabled or the template has none.
+func renderMarkdownTemplate(templateConnector storage.TemplateFetcher, templateName string, locale string, templateContext map[string]interface{}, opts Options) (string, string, error) {
+	if !opts.MarkdownTemplates {
+		return "", "", &storage.NotFoundError{Name: templateName + ".md.template"}
+	}
+	// The Markdown version is optional, so it is not retried while not found.
+	mdTemplateKey, mdTemplateContent, err := fetchLocalizedTemplate(templateConnector, templateName, locale, "md", Options{})
+	if err != nil {
+		return "", "", err
+	}
+
+	mdTmpl := ttemplate.New(mdTemplateKey).Funcs(templateFuncs())
+	if err := parseWithPartials(templateConnector, textSet{mdTmpl}, mdTemplateContent, ".md.template"); err != nil {
+		return "", "", fmt.Errorf("unable to parse Markdown template: %s", err.Error())
+	}
+	if opts.StrictTemplates {
+		if err := checkContextFields(mdTmpl.Tree, templateContext); err != nil {
+			return "", "", err
+		}
+		mdTmpl.Option("missingkey=error")
+	}
+
+	var mdTmplBuffer bytes.Buffer
+	if err := mdTmpl.Execute(&mdTmplBuffer, templateContext); err != nil {
+		return "", "", fmt.Errorf("unable to execute Markdown template: %s", err.Error())
+	}
+	content := markdownToHTML(mdTmplBuffer.String())
+	textBody := htmlToText(content)
+	if opts.MarkdownLayout == "" {
+		return "<html><body>\n" + content + "</body></html>", textBody, nil
+	}
+
+	layoutKey := opts.MarkdownLayout + ".html.template"
+	layoutContent, err := fetchTemplate(templateConnector, layoutKey, opts)
+	if err != nil {
+		return "", "", fmt.Errorf("unable to fetch Markdown layout: %s", err.Error())
+	}
+	layoutTmpl := htemplate.New(layoutKey).Funcs(templateFuncs())
+	if err := parseWithPartials(templateConnector, htmlSet{layoutTmpl}, layoutContent, ".html.template"); err != nil {
+		return "", "", fmt.Errorf("unable to parse Markdown layout: %s", err.Error())
+	}
+	layoutContext := make(map[string]interface{}, len(templateContext)+1)
+	for key, value := range templateContext {
+		layoutContext[key] = value
+	}
+	layoutContext[markdownContentKey] = htemplate.HTML(content)
+
+	var layoutTmplBuffer bytes.Buffer
+	if err := layoutTmpl.Execute(&layoutTmplBuffer, layoutContext); err != nil {
+		return "", "", fmt.Errorf("unable to execute Markdown layout: %s", err.Error())
+	}
+
+	return layoutTmplBuffer.String(), textBody, nil
+}
+
+// renderHTMLTemplate fetches and executes the HTML template against the context.
+func renderHTMLTemplate(templateConnector storage.TemplateFetcher, templateName string, locale string, templateContext map[string]interface{}, opts Options) (string, error) {
+	if body, rendered, err := renderEngineTemplate(templateConnector, templateName, locale, "html", templateContext, opts); rendered {
+		return body, err
+	}
+	htmlTemplateKey, htmlTemplateContent, err := fetchLocalizedTemplate(templateConnector, templateName, locale, "html", opts)
+	if err != nil {
+		return "", err
+	}
+	// The templates are named by their key, so the parse and execution errors locate them, ie: "welcome.html.template:3:14".
+	htmlTmpl := htemplate.New(htmlTemplateKey).Funcs(templateFuncs())
+	if err := parseWithPartials(templateConnector, htmlSet{htmlTmpl}, htmlTemplateContent, ".html.template"); err != nil {
+		return "", fmt.Errorf("unable to parse HTML template: %s", err.Error())
+	}
+	if opts.StrictTemplates {
+		if err := checkContextFields(htmlTmpl.Tree, templateContext); err != nil {
+			return "", err
+		}
+		htmlTmpl.Option("missingkey=error")
+	}
+
+	var htmlTmplBuffer bytes.Buffer
+	if err := htmlTmpl.Execute(&htmlTmplBuffer, templateContext); err != nil {
+		return "", fmt.Errorf("unable to execute HTML template: %s", err.Error())
+	}
+
+	return htmlTmplBuffer.String(), nil
+}
+
+// renderMJMLTemplate executes the MJML version of the template against the context, escaping it as HTML, and compiles the result to HTML.
+// It returns a *storage.NotFoundError when there is no MJML compiler or MJML template, in which case the HTML template is used.
+func renderMJMLTemplate(templateConnector storage.TemplateFetcher, templateName string, locale string, templateContext map[string]interface{}, opts Options) (string, error) {
+	if opts.MJML == nil {
+		return "", &storage.NotFoundError{Name: templateName + ".mjml.template"}
+	}
+	// The MJML version is optional, so it is not retried while not found.
+	mjmlTemplateKey, mjmlTemplateContent, err := fetchLocalizedTemplate(templateConnector, templateName, locale, "mjml", Options{})
+	if err != nil {
+		return "", err
+	}
+
+	mjmlTmpl := htemplate.New(mjmlTemplateKey).Funcs(templateFuncs())
+	if err := parseWithPartials(templateConnector, htmlSet{mjmlTmpl}, mjmlTemplateContent, ".mjml.template"); err != nil {
+		return "", fmt.Errorf("unable to parse MJML template: %s", err.Error())
+	}
+	if opts.StrictTemplates {
+		if err := checkContextFields(mjmlTmpl.Tree, templateContext); err != nil {
+			return "", err
+		}
+		mjmlTmpl.Option("missingkey=error")
+	}
+
+	var mjmlTmplBuffer bytes.Buffer
+	if err := mjmlTmpl.Execute(&mjmlTmplBuffer, templateContext); err != nil {
+		return "", fmt.Errorf("unable to execute MJML template: %s", err.Error())
+	}
+	htmlBody, err := opts.MJML.Compile(mjmlTmplBuffer.String())
+	if err != nil {
+		return "", fmt.Errorf("unable to compile MJML template %s: %s", mjmlTemplateKey, err.Error())
+	}
+
+	return htmlBody, nil
+}
+
+// renderAMPTemplate renders the optional AMP version of the template, stored as "welcome.amp.template" or "welcome.amp.html.template",
+// returning an empty string when there is none.
+func renderAMPTemplate(templateConnector storage.TemplateFetcher, templateName string, locale string, templateContext map[string]interface{}, opts Options) (string, error) {
+	// The AMP version is optional, so it is not retried while not found.
+	ampTemplateKey, ampTemplateContent, err := fetchLocalizedTemplate(templateConnector, templateName, locale, "amp", Options{})
+	if storage.IsNotFound(err) {
+		ampTemplateKey, ampTemplateContent, err = fetchLocalizedFile(templateConnector, templateName, locale, "amp", "html.template", Options{})
+	}
+	if storage.IsNotFound(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	ampTmpl := htemplate.New(ampTemplateKey).Funcs(templateFuncs())
+	if err := parseWithPartials(templateConnector, htmlSet{ampTmpl}, ampTemplateContent, ".amp.template"); err != nil {
+		return "", fmt.Errorf("unable to parse AMP template: %s", err.Error())
+	}
+	if opts.StrictTemplates {
+		if err := checkContextFields(ampTmpl.Tree, templateContext); err != nil {
+			return "", err
+		}
+		ampTmpl.Option("missingkey=error")
+	}
+
+	var ampTmplBuffer bytes.Buffer
+	if err := ampTmpl.Execute(&ampTmplBuffer, templateContext); err != nil {
+		return "", fmt.Errorf("unable to execute AMP template: %s", err.Error())
+	}
+
+	return ampTmplBuffer.String(), nil
+}
+
+// renderSubject executes the subject of the message as a text template against the context, ie: "Your order {{.OrderID}} has shipped".
+// Line breaks are replaced by spaces, as a context value can't be allowed to break the header.
+func renderSubject(subject string, templateContext map[string]interface{}, opts Options) (string, error) {
+	if !strings.Contains(subject, "{{") {
+		return subject, nil
+	}
+
+	subjectTmpl, err := ttemplate.New("subject").Funcs(templateFuncs()).Parse(subject)
+	if err != nil {
+		return "", fmt.Errorf("unable to parse subject: %s", err.Error())
+	}
+	if opts.StrictTemplates {
+		if err := checkContextFields(subjectTmpl.Tree, templateContext); err != nil {
+			return "", err
+		}
+		subjectTmpl.Option("missingkey=error")
+	}
+
+	var subjectBuffer bytes.Buffer
+	if err := subjectTmpl.Execute(&subjectBuffer, templateContext); err != nil {
+		return "", fmt.Errorf("unable to execute subject: %s", err.Error())
+	}
+
+	return strings.Join(strings.Fields(subjectBuffer.String()), " "), nil
+}
+
+// PreviewMail renders a template against the raw JSON context without sending anything, for operators to check its output.
+// The templates are always fetched from the storage, so a preview shows their last version.
+func PreviewMail(templateConnector storage.TemplateFetcher, opts Options, templateName string, locale string, subject string, rawContext json.RawMessage) (*Rendering, error) {
+	var templateContext map[string]interface{}
+	if len(rawContext) > 0 {
+		decoder := json.NewDecoder(bytes.NewReader(rawContext))
+		decoder.UseNumber()
+		if err := decoder.Decode(&templateContext); err != nil {
+			return nil, fmt.Errorf("unable to unmarshal template context: %s", err.Error())
+		}
+	}
+
+	templateContext, err := opts.Preprocessors.apply(templateName, templateContext)
+	if err != nil {
+		return nil, err
+	}
+	rendering, err := renderTemplates(storage.Uncached(templateConnector), templateName, locale, templateContext, opts)
+	if err != nil {
+		return nil, err
+	}
+	if rendering.Subject, err = renderSubject(subject, templateContext, opts); err != nil {
+		return nil, err
+	}
+
+	return rendering, nil
+}
@@ -0,0 +1,121 @@
+package mailmessage
+
+import (
+	"context"
+	"errors"
+	"github.com/forsam-education/hermes/storage"
+	"github.com/forsam-education/hermes/transport"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// propagatingStorage is a memory storage whose templates are only found after some fetches, as if they were still propagating.
+type propagatingStorage struct {
+	*storage.Memory
+	mu        sync.Mutex
+	missing   map[string]int
+	err       error
+	fetches   map[string]int
+	fetchesAt []time.Time
+}
+
+func newPropagatingStorage(missing map[string]int) *propagatingStorage {
+	return &propagatingStorage{Memory: newTestTemplates(), missing: missing, fetches: make(map[string]int)}
+}
+
+func (templates *propagatingStorage) Fetch(templateName string) (string, error) {
+	templates.mu.Lock()
+	templates.fetches[templateName]++
+	templates.fetchesAt = append(templates.fetchesAt, time.Now())
+	missing := templates.missing[templateName] >= templates.fetches[templateName]
+	templates.mu.Unlock()
+
+	if templates.err != nil {
+		return "", templates.err
+	}
+	if missing {
+		return "", &storage.NotFoundError{Name: templateName}
+	}
+
+	return templates.Memory.Fetch(templateName)
+}
+
+func (templates *propagatingStorage) FetchFresh(templateName string) (string, error) {
+	return templates.Fetch(templateName)
+}
+
+func TestFetchTemplateRetriesNotFound(t *testing.T) {
+	tests := []struct {
+		name    string
+		missing int
+		retries int
+		err     error
+		fetches int
+		found   bool
+	}{
+		{name: "fetches once without retry", missing: 1, fetches: 1},
+		{name: "finds the template after its propagation", missing: 2, retries: 3, fetches: 3, found: true},
+		{name: "finds the template on the last retry", missing: 3, retries: 3, fetches: 4, found: true},
+		{name: "fails once the retries are exhausted", missing: 10, retries: 3, fetches: 4},
+		{name: "does not retry a template found first", missing: 0, retries: 3, fetches: 1, found: true},
+		{name: "does not retry other errors", retries: 3, err: errors.New("throttled"), fetches: 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			templates := newPropagatingStorage(map[string]int{"welcome.txt.template": test.missing})
+			templates.err = test.err
+			opts := Options{TemplateNotFoundRetries: test.retries, TemplateNotFoundBackoff: time.Millisecond}
+
+			content, err := fetchTemplate(templates, "welcome.txt.template", opts)
+			if test.found {
+				if err != nil || content != "Hello {{.name}}" {
+					t.Errorf("expected the template to be found, got %q, %v", content, err)
+				}
+			} else if err == nil {
+				t.Errorf("expected the fetch to fail, got %q", content)
+			} else if test.err == nil && !storage.IsNotFound(err) {
+				t.Errorf("expected a not found error, got %v", err)
+			}
+			if fetches := templates.fetches["welcome.txt.template"]; fetches != test.fetches {
+				t.Errorf("expected %d fetches, got %d", test.fetches, fetches)
+			}
+		})
+	}
+}
+
+func TestFetchTemplateBackoff(t *testing.T) {
+	templates := newPropagatingStorage(map[string]int{"welcome.txt.template": 3})
+	opts := Options{TemplateNotFoundRetries: 3, TemplateNotFoundBackoff: 10 * time.Millisecond}
+
+	if _, err := fetchTemplate(templates, "welcome.txt.template", opts); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	// The delays double after each attempt: 10ms, 20ms then 40ms.
+	for i, minimum := range []time.Duration{10 * time.Millisecond, 20 * time.Millisecond, 40 * time.Millisecond} {
+		if delay := templates.fetchesAt[i+1].Sub(templates.fetchesAt[i]); delay < minimum {
+			t.Errorf("expected retry %d to wait at least %s, got %s", i+1, minimum, delay)
+		}
+	}
+}
+
+func TestSendMailRetriesPropagatingTemplate(t *testing.T) {
+	templates := newPropagatingStorage(map[string]int{"welcome.html.template": 2, "welcome.txt.template": 1})
+	fake := transport.NewFake()
+	opts := Options{TemplateNotFoundRetries: 3, TemplateNotFoundBackoff: time.Millisecond}
+
+	if _, err := SendMail(context.Background(), templates, templates, fake, opts, testMessageBody(nil)); err != nil {
+		t.Fatalf("unable to send message: %s", err.Error())
+	}
+	_, parts := parseTestMessage(t, fake.Messages()[0])
+	if len(parts) != 2 || !strings.Contains(parts[0].body+parts[1].body, "<p>Hello Jane</p>") {
+		t.Errorf("expected the message to be rendered from the propagated templates, got %+v", parts)
+	}
+
+	templates = newPropagatingStorage(map[string]int{"welcome.html.template": 10})
+	if _, err := SendMail(context.Background(), templates, templates, transport.NewFake(), opts, testMessageBody(nil)); err == nil || !strings.Contains(err.Error(), "welcome.html.template") {
+		t.Errorf("expected a template still missing after the retries to fail the message, got %v", err)
+	}
+}
@@ -0,0 +1,78 @@
+package mailmessage
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/forsam-education/hermes/storage"
+	"github.com/forsam-education/hermes/transport"
+	"log"
+	"net/mail"
+)
+
+// archivedRecipients lists the addresses of the To and Cc headers of an archived message.
+// Bcc recipients are never written in the archived message, so they can't be replayed.
+func archivedRecipients(header mail.Header) ([]string, error) {
+	var recipients []string
+	for _, field := range []string{"To", "Cc"} {
+		addresses, err := header.AddressList(field)
+		if err == mail.ErrHeaderNotPresent {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s header: %s", field, err.Error())
+		}
+		for _, address := range addresses {
+			recipients = append(recipients, address.Address)
+		}
+	}
+
+	return recipients, nil
+}
+
+// ReplayMail re-sends an archived raw message as-is through the mail transport.
+// When redirectTo is not empty, the message is delivered to these addresses instead of its original recipients.
+func ReplayMail(archiveConnector storage.AttachmentCopier, mailTransport transport.Dialer, key string, redirectTo []string) error {
+	var rawMessage bytes.Buffer
+	if err := archiveConnector.Copy(key, &rawMessage); err != nil {
+		return fmt.Errorf("unable to fetch archived message %q: %s", key, err.Error())
+	}
+
+	parsedMessage, err := mail.ReadMessage(bytes.NewReader(rawMessage.Bytes()))
+	if err != nil {
+		return fmt.Errorf("archived message %q is not a valid email: %s", key, err.Error())
+	}
+
+	fromField := "Sender"
+	if parsedMessage.Header.Get(fromField) == "" {
+		fromField = "From"
+	}
+	from, err := mail.ParseAddress(parsedMessage.Header.Get(fromField))
+	if err != nil {
+		return fmt.Errorf("archived message %q has an invalid %s header: %s", key, fromField, err.Error())
+	}
+
+	recipients := redirectTo
+	if len(recipients) == 0 {
+		recipients, err = archivedRecipients(parsedMessage.Header)
+		if err != nil {
+			return fmt.Errorf("archived message %q has invalid recipients: %s", key, err.Error())
+		}
+	}
+	if len(recipients) == 0 {
+		return fmt.Errorf("archived message %q has no recipient to replay to", key)
+	}
+
+	sender, err := mailTransport.Dial()
+	if err != nil {
+		return fmt.Errorf("unable to connect to mail transport: %s", err.Error())
+	}
+	defer sender.Close()
+
+	if err := sender.Send(from.Address, recipients, bytes.NewReader(rawMessage.Bytes())); err != nil {
+		return fmt.Errorf("unable to replay archived message %q: %s", key, err.Error())
+	}
+
+	log.Printf("Replayed archived message %s to %v\n", key, recipients)
+
+	return nil
+}
@@ -0,0 +1,159 @@
+package mailmessage
+
+import (
+	"errors"
+	"github.com/forsam-education/hermes/storage"
+	"github.com/forsam-education/hermes/transport"
+	"strings"
+	"testing"
+)
+
+const archivedMessage = "From: Example <noreply@example.com>\r\n" +
+	"To: Jane <jane@example.org>, jim@example.org\r\n" +
+	"Cc: john@example.org\r\n" +
+	"Subject: Welcome\r\n" +
+	"Content-Type: text/plain; charset=UTF-8\r\n" +
+	"\r\n" +
+	"Hello Jane\r\n"
+
+func TestReplayMail(t *testing.T) {
+	tests := []struct {
+		name       string
+		archived   string
+		key        string
+		redirectTo []string
+		from       string
+		recipients []string
+		err        string
+	}{
+		{
+			name:       "replays to the original recipients",
+			archived:   archivedMessage,
+			from:       "noreply@example.com",
+			recipients: []string{"jane@example.org", "jim@example.org", "john@example.org"},
+		},
+		{
+			name:       "replays to the redirected recipients",
+			archived:   archivedMessage,
+			redirectTo: []string{"qa@example.com"},
+			from:       "noreply@example.com",
+			recipients: []string{"qa@example.com"},
+		},
+		{
+			name:       "replays from the sender",
+			archived:   "Sender: bounces@example.com\r\n" + archivedMessage,
+			from:       "bounces@example.com",
+			recipients: []string{"jane@example.org", "jim@example.org", "john@example.org"},
+		},
+		{
+			name:     "rejects a missing archive",
+			archived: archivedMessage,
+			key:      "archive/2020/10/01/missing.eml",
+			err:      `unable to fetch archived message "archive/2020/10/01/missing.eml"`,
+		},
+		{
+			name:     "rejects a content which is not a message",
+			archived: "not a message",
+			err:      "is not a valid email",
+		},
+		{
+			name:     "rejects an invalid from",
+			archived: strings.Replace(archivedMessage, "Example <noreply@example.com>", "noreply", 1),
+			err:      "has an invalid From header",
+		},
+		{
+			name:     "rejects an invalid recipient",
+			archived: strings.Replace(archivedMessage, "Cc: john@example.org", "Cc: john", 1),
+			err:      "invalid Cc header",
+		},
+		{
+			name:     "rejects a message without recipient",
+			archived: strings.Replace(strings.Replace(archivedMessage, "To: Jane <jane@example.org>, jim@example.org\r\n", "", 1), "Cc: john@example.org\r\n", "", 1),
+			err:      "has no recipient to replay to",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			archive := storage.NewMemory()
+			archive.Set("archive/2020/10/01/42.eml", []byte(test.archived))
+			key := test.key
+			if key == "" {
+				key = "archive/2020/10/01/42.eml"
+			}
+			fake := transport.NewFake()
+
+			err := ReplayMail(archive, fake, key, test.redirectTo)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected error %q, got %v", test.err, err)
+				}
+				if sent := fake.Messages(); len(sent) != 0 {
+					t.Errorf("expected nothing sent, got %d messages", len(sent))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+
+			sent := fake.Messages()
+			if len(sent) != 1 {
+				t.Fatalf("expected a replayed message, got %d", len(sent))
+			}
+			if sent[0].From != test.from || strings.Join(sent[0].Recipients, ",") != strings.Join(test.recipients, ",") {
+				t.Errorf("expected a message from %s to %v, got %s to %v", test.from, test.recipients, sent[0].From, sent[0].Recipients)
+			}
+			if string(sent[0].Raw) != test.archived {
+				t.Errorf("expected the archived message to be sent as-is, got %q", sent[0].Raw)
+			}
+		})
+	}
+}
+
+func TestReplayMailTransportFailures(t *testing.T) {
+	tests := []struct {
+		name    string
+		prepare func(fake *transport.Fake)
+		err     string
+	}{
+		{name: "dial failure", prepare: func(fake *transport.Fake) { fake.FailDials(errors.New("connection refused")) }, err: "unable to connect to mail transport: connection refused"},
+		{name: "send failure", prepare: func(fake *transport.Fake) { fake.FailSends(errors.New("550 rejected")) }, err: "unable to replay archived message"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			archive := storage.NewMemory()
+			archive.Set("archive/42.eml", []byte(archivedMessage))
+			fake := transport.NewFake()
+			test.prepare(fake)
+
+			if err := ReplayMail(archive, fake, "archive/42.eml", nil); err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("expected error %q, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestReplayArchivedMessage(t *testing.T) {
+	archive := storage.NewMemory()
+	opts := Options{Archive: archive, ArchivePrefix: "archive"}
+	sent := sendTestMessage(t, newTestTemplates(), opts, testMessageBody(map[string]interface{}{"bcc": []string{"audit@example.com"}}))
+	keys := archive.Keys("archive/")
+	if len(sent) != 1 || len(keys) != 1 {
+		t.Fatalf("expected a sent and archived message, got %d sent and %v archived", len(sent), keys)
+	}
+
+	fake := transport.NewFake()
+	if err := ReplayMail(archive, fake, keys[0], nil); err != nil {
+		t.Fatalf("unable to replay: %s", err.Error())
+	}
+	replayed := fake.Messages()
+	if len(replayed) != 1 || string(replayed[0].Raw) != string(sent[0].Raw) {
+		t.Fatalf("expected the sent message to be replayed as-is, got %+v", replayed)
+	}
+	// The blind recipients are not in the archived message, so they are not replayed to.
+	if strings.Join(replayed[0].Recipients, ",") != "jane@example.org" {
+		t.Errorf("expected a replay to jane@example.org, got %v", replayed[0].Recipients)
+	}
+}
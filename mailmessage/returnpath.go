@@ -0,0 +1,67 @@
+package mailmessage
+
+import (
+	"fmt"
+	"github.com/forsam-education/hermes/tracking"
+	"strings"
+)
+
+// verpRecipient encodes the recipient address in the local part of the envelope sender, as VERP does, ie: "jane=example.com" for jane@example.com.
+func verpRecipient(address string) string {
+	separator := strings.LastIndex(address, "@")
+	if separator < 0 {
+		return address
+	}
+
+	return address[:separator] + "=" + address[separator+1:]
+}
+
+// expandReturnPath replaces the placeholders of a return path: {recipient} by the VERP encoded main recipient, and {tracking_id} by the tracking id
+// of the message, generated when it has none so it is logged once sent.
+func expandReturnPath(returnPath string, mailMsg *mailMessage) (string, error) {
+	if strings.Contains(returnPath, "{tracking_id}") && mailMsg.TrackingID == "" {
+		id, err := tracking.NewID()
+		if err != nil {
+			return "", err
+		}
+		mailMsg.TrackingID = id
+	}
+
+	var recipient string
+	if envelope := mailMsg.envelopeRecipients(); len(envelope) > 0 {
+		recipient = verpRecipient(envelope[0])
+	}
+
+	return strings.NewReplacer("{recipient}", recipient, "{tracking_id}", mailMsg.TrackingID).Replace(returnPath), nil
+}
+
+// envelopeSender returns the address the bounces of the message are sent to: its return_path field, or else the ReturnPath option, or else its from address.
+func envelopeSender(mailMsg *mailMessage, opts Options) (string, error) {
+	returnPath := mailMsg.ReturnPath
+	if returnPath == "" {
+		returnPath = opts.ReturnPath
+	}
+	if returnPath == "" {
+		return mailMsg.FromAddress, nil
+	}
+
+	sender, err := expandReturnPath(returnPath, mailMsg)
+	if err != nil {
+		return "", err
+	}
+	if !isBareAddress(sender) {
+		return "", fmt.Errorf("return path %q is not a valid address", sender)
+	}
+
+	return sender, nil
+}
+
+// ValidateReturnPath checks the return path is a valid address once its placeholders are replaced, ie: "bounces+{recipient}@example.com".
+func ValidateReturnPath(returnPath string) error {
+	sample := strings.NewReplacer("{recipient}", "jane=example.com", "{tracking_id}", "0123456789abcdef").Replace(returnPath)
+	if !isBareAddress(sample) {
+		return fmt.Errorf("%q is not a valid address once its placeholders are replaced", returnPath)
+	}
+
+	return nil
+}
@@ -0,0 +1,112 @@
+package mailmessage
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/forsam-education/hermes/ratelimit"
+	"log"
+	"sort"
+	"strings"
+)
+
+// DomainRule is the routing of the messages delivered to the recipients of a domain.
+type DomainRule struct {
+	// Transport is the transport profile the messages are sent through, the default transport being used when empty.
+	Transport string `json:"transport,omitempty"`
+	// Rate is the maximum number of messages sent to the domain per second, 0 meaning no limit.
+	Rate float64 `json:"rate,omitempty"`
+	// Block rejects the messages having a recipient of the domain, ie: a disposable email domain.
+	Block bool `json:"block,omitempty"`
+}
+
+// DomainRules maps the lowercased recipient domains to their rule, ie: {"forsam.education": {"transport": "internal"}, "yahoo.com": {"rate": 5}}.
+type DomainRules map[string]DomainRule
+
+// UnmarshalText decodes the rules from their JSON representation, so they can be read from an environment variable.
+func (rules *DomainRules) UnmarshalText(text []byte) error {
+	var decoded map[string]DomainRule
+	if err := json.Unmarshal(text, &decoded); err != nil {
+		return err
+	}
+
+	*rules = make(DomainRules, len(decoded))
+	for domain, rule := range decoded {
+		(*rules)[strings.ToLower(domain)] = rule
+	}
+
+	return nil
+}
+
+// Rates returns the rate limits of the domains which have one, in sends per second.
+func (rules DomainRules) Rates() ratelimit.Rates {
+	rates := make(ratelimit.Rates)
+	for domain, rule := range rules {
+		if rule.Rate > 0 {
+			rates[domain] = rule.Rate
+		}
+	}
+
+	return rates
+}
+
+// routeByDomain applies the rules of the recipient domains to a validated message: it fails when a domain is blocked, and selects the transport
+// profile its domains are routed through, which takes precedence over the transport of the message and of its tenant. The recipients of a message
+// can't be routed through different transports, as its single envelope is sent through one of them.
+func routeByDomain(mailMsg *mailMessage, opts Options) error {
+	if len(opts.DomainRules) == 0 {
+		return nil
+	}
+
+	var blocked []string
+	transports := make(map[string]bool)
+	for _, domain := range uniqueRecipientDomains(mailMsg) {
+		rule, ok := opts.DomainRules[domain]
+		if !ok {
+			continue
+		}
+		if rule.Block {
+			blocked = append(blocked, domain)
+		}
+		if rule.Transport != "" {
+			transports[rule.Transport] = true
+		}
+	}
+	if len(blocked) > 0 {
+		return fmt.Errorf("recipient domains %s are blocked", strings.Join(blocked, ", "))
+	}
+
+	switch len(transports) {
+	case 0:
+		return nil
+	case 1:
+		for transport := range transports {
+			if mailMsg.Transport != transport {
+				log.Printf("Routing message of template %s through transport %q, by its recipient domains", mailMsg.Template, transport)
+				mailMsg.Transport = transport
+			}
+		}
+		return nil
+	default:
+		names := make([]string, 0, len(transports))
+		for transport := range transports {
+			names = append(names, transport)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("recipients are routed through different transports %s", strings.Join(names, ", "))
+	}
+}
+
+// uniqueRecipientDomains returns the lowercased domains of the envelope recipients, in order and without duplicates.
+func uniqueRecipientDomains(mailMsg *mailMessage) []string {
+	seen := make(map[string]bool)
+	var domains []string
+	for _, address := range mailMsg.envelopeRecipients() {
+		domain := strings.ToLower(domainOf(address))
+		if !seen[domain] {
+			seen[domain] = true
+			domains = append(domains, domain)
+		}
+	}
+
+	return domains
+}
@@ -0,0 +1,92 @@
+package mailmessage
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRouteByDomain(t *testing.T) {
+	rules := DomainRules{
+		"forsam.education":  {Transport: "internal"},
+		"partner.forsam.co": {Transport: "internal", Rate: 2},
+		"yahoo.com":         {Rate: 5},
+		"outlook.com":       {Transport: "microsoft"},
+		"mailinator.com":    {Block: true},
+		"guerrillamail.com": {Block: true, Transport: "internal"},
+	}
+
+	tests := []struct {
+		name      string
+		to        []string
+		cc        []string
+		bcc       []string
+		rules     DomainRules
+		transport string
+		expected  string
+		err       string
+	}{
+		{name: "keeps the transport without rules", to: []string{"jane@outlook.com"}, transport: "tenant", expected: "tenant"},
+		{name: "keeps the transport of domains without rule", to: []string{"jane@example.org"}, rules: rules, transport: "tenant", expected: "tenant"},
+		{name: "keeps the transport of domains with a rate only", to: []string{"jane@yahoo.com"}, rules: rules, expected: ""},
+		{name: "routes through the transport of the domain", to: []string{"jane@forsam.education"}, rules: rules, expected: "internal"},
+		{name: "overrides the transport of the message", to: []string{"jane@outlook.com"}, rules: rules, transport: "tenant", expected: "microsoft"},
+		{name: "routes domains sharing a transport", to: []string{"jane@forsam.education"}, cc: []string{"john@partner.forsam.co"}, rules: rules, expected: "internal"},
+		{name: "routes with recipients of domains without rule", to: []string{"jane@example.org", "john@outlook.com"}, rules: rules, expected: "microsoft"},
+		{name: "matches mixed-case domains", to: []string{"Jane@Forsam.Education"}, rules: rules, expected: "internal"},
+		{name: "blocks a domain", to: []string{"jane@example.org"}, bcc: []string{"spam@mailinator.com"}, rules: rules, err: "recipient domains mailinator.com are blocked"},
+		{name: "blocks a mixed-case domain", to: []string{"spam@MailInator.COM"}, rules: rules, err: "recipient domains mailinator.com are blocked"},
+		{name: "lists the blocked domains", to: []string{"spam@mailinator.com", "eggs@guerrillamail.com"}, rules: rules, err: "recipient domains mailinator.com, guerrillamail.com are blocked"},
+		{name: "blocks before routing", to: []string{"jane@outlook.com", "eggs@guerrillamail.com"}, rules: rules, err: "recipient domains guerrillamail.com are blocked"},
+		{
+			name:  "fails recipients routed through different transports",
+			to:    []string{"jane@outlook.com"},
+			cc:    []string{"john@forsam.education"},
+			bcc:   []string{"archive@Forsam.Education"},
+			rules: rules,
+			err:   "recipients are routed through different transports internal, microsoft",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mailMsg := &mailMessage{
+				Template:  "welcome",
+				Transport: test.transport,
+				to:        recipients{envelope: test.to},
+				cc:        recipients{envelope: test.cc},
+				bcc:       recipients{envelope: test.bcc},
+			}
+			err := routeByDomain(mailMsg, Options{DomainRules: test.rules})
+			if test.err != "" {
+				if err == nil || err.Error() != test.err {
+					t.Errorf("expected error %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+			if mailMsg.Transport != test.expected {
+				t.Errorf("expected transport %q, got %q", test.expected, mailMsg.Transport)
+			}
+		})
+	}
+}
+
+func TestDomainRulesUnmarshalText(t *testing.T) {
+	var rules DomainRules
+	if err := rules.UnmarshalText([]byte(`{"Forsam.Education": {"transport": "internal"}, "YAHOO.com": {"rate": 5}, "mailinator.com": {"block": true}}`)); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	expected := DomainRules{"forsam.education": {Transport: "internal"}, "yahoo.com": {Rate: 5}, "mailinator.com": {Block: true}}
+	if !reflect.DeepEqual(rules, expected) {
+		t.Errorf("expected %v, got %v", expected, rules)
+	}
+	if rates := rules.Rates(); len(rates) != 1 || rates["yahoo.com"] != 5 {
+		t.Errorf("expected the rate of yahoo.com only, got %v", rates)
+	}
+	if err := rules.UnmarshalText([]byte(`["forsam.education"]`)); err == nil {
+		t.Errorf("expected rules which are not an object to fail")
+	}
+}
@@ -0,0 +1,303 @@
+package mailmessage
+
+import (
+	"fmt"
+	"golang.org/x/net/html"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Policies applied to the markup found in the string values of the template context.
+const (
+	// ContextMarkupEscape leaves the values as is, the HTML templates escaping them when they are rendered.
+	ContextMarkupEscape = "escape"
+	// ContextMarkupStrip removes the tags from the values, keeping their text.
+	ContextMarkupStrip = "strip"
+	// ContextMarkupReject fails the messages having a value holding a tag.
+	ContextMarkupReject = "reject"
+)
+
+var (
+	// sanitizedElements are the elements kept by the sanitization, the other ones being unwrapped to their content.
+	sanitizedElements = map[string]bool{
+		"a": true, "abbr": true, "address": true, "area": true, "article": true, "aside": true, "b": true, "bdi": true, "bdo": true, "big": true,
+		"blockquote": true, "body": true, "br": true, "caption": true, "center": true, "cite": true, "code": true, "col": true, "colgroup": true,
+		"dd": true, "del": true, "div": true, "dl": true, "dt": true, "em": true, "figcaption": true, "figure": true, "font": true, "footer": true,
+		"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true, "head": true, "header": true, "hr": true, "html": true, "i": true,
+		"img": true, "ins": true, "kbd": true, "li": true, "main": true, "map": true, "mark": true, "meta": true, "nav": true, "ol": true, "p": true,
+		"pre": true, "q": true, "s": true, "section": true, "small": true, "span": true, "strike": true, "strong": true, "style": true, "sub": true,
+		"sup": true, "table": true, "tbody": true, "td": true, "tfoot": true, "th": true, "thead": true, "time": true, "title": true, "tr": true,
+		"tt": true, "u": true, "ul": true, "wbr": true,
+	}
+	// droppedElements are removed with their content, as they run code, load other documents or take input.
+	droppedElements = map[string]bool{
+		"applet": true, "base": true, "button": true, "embed": true, "form": true, "frame": true, "frameset": true, "iframe": true, "input": true,
+		"link": true, "math": true, "noscript": true, "object": true, "option": true, "portal": true, "script": true, "select": true, "svg": true,
+		"template": true, "textarea": true,
+	}
+	// sanitizedAttributes are the attributes kept on the kept elements. Event handlers are never kept.
+	sanitizedAttributes = map[string]bool{
+		"abbr": true, "align": true, "alt": true, "background": true, "bgcolor": true, "border": true, "cellpadding": true, "cellspacing": true,
+		"charset": true, "cite": true, "class": true, "color": true, "colspan": true, "content": true, "coords": true, "datetime": true, "dir": true,
+		"face": true, "headers": true, "height": true, "href": true, "hspace": true, "id": true, "lang": true, "media": true, "name": true,
+		"nowrap": true, "rel": true, "role": true, "rowspan": true, "scope": true, "shape": true, "size": true, "span": true, "src": true,
+		"start": true, "style": true, "summary": true, "target": true, "title": true, "type": true, "usemap": true, "valign": true, "vspace": true,
+		"width": true, "xmlns": true,
+	}
+	// urlAttributes hold a link or the address of a resource, whose scheme is checked.
+	urlAttributes = map[string]bool{"background": true, "cite": true, "href": true, "src": true}
+	// unsafeCSS matches the style constructs able to run code in some clients, once the comments and escapes are removed.
+	unsafeCSS = regexp.MustCompile(`(?i)expression\s*\(|javascript:|vbscript:|behavior\s*:|-moz-binding`)
+	// cssComment matches a style comment, an unterminated one running up to the end.
+	cssComment = regexp.MustCompile(`(?s)/\*.*?(?:\*/|$)`)
+	// cssEscape matches a style escape, ie: \6a or \6a followed by a space, or an escaped character.
+	cssEscape = regexp.MustCompile(`\\(?:([0-9A-Fa-f]{1,6})[ \t\r\n\f]?|(.))`)
+	// safeImageData matches the inline images allowed as a src.
+	safeImageData = regexp.MustCompile(`^data:image/(?:png|gif|jpeg|webp);`)
+	// revealedComment matches the comments around the content hidden from Outlook only, ie: <!--[if !mso]><!--> and <!--<![endif]-->.
+	revealedComment = regexp.MustCompile(`^(?:\[if [^\]<>]*\]><!|<!\[endif\])$`)
+	// contextMarkup matches a tag, comment or doctype in a context value.
+	contextMarkup = regexp.MustCompile(`<[A-Za-z/!?][^>]*>`)
+)
+
+// isUnsafeCSS tells if a style attribute or style sheet holds a construct able to run code, its comments being removed and its escapes decoded
+// as the clients do, so they can't hide one, ie: "expr/**/ession(" or "\6a avascript:".
+func isUnsafeCSS(css string) bool {
+	decoded := cssEscape.ReplaceAllStringFunc(cssComment.ReplaceAllString(css, ""), func(escape string) string {
+		match := cssEscape.FindStringSubmatch(escape)
+		if match[1] == "" {
+			return match[2]
+		}
+		code, err := strconv.ParseInt(match[1], 16, 32)
+		if err != nil || code == 0 || code > unicode.MaxRune {
+			return string(unicode.ReplacementChar)
+		}
+		return string(rune(code))
+	})
+
+	return unsafeCSS.MatchString(decoded)
+}
+
+// isSafeURL tells if an URL attribute is relative or uses a safe scheme. Whitespaces and control characters are ignored, as browsers do.
+func isSafeURL(attribute string, value string) bool {
+	compact := strings.ToLower(strings.Map(func(r rune) rune {
+		if r <= ' ' {
+			return -1
+		}
+		return r
+	}, value))
+
+	colon := strings.Index(compact, ":")
+	if colon < 0 || strings.ContainsAny(compact[:colon], "/?#") {
+		return true
+	}
+	if attribute == "src" && safeImageData.MatchString(compact) {
+		return true
+	}
+	for _, scheme := range safeLinkSchemes {
+		if strings.HasPrefix(compact, scheme) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// sanitizedTag returns the start tag keeping its allowed attributes only.
+func sanitizedTag(token html.Token) string {
+	var tag strings.Builder
+	tag.WriteString("<" + token.Data)
+	for _, attribute := range token.Attr {
+		if attribute.Namespace != "" || !sanitizedAttributes[attribute.Key] {
+			continue
+		}
+		if urlAttributes[attribute.Key] && !isSafeURL(attribute.Key, attribute.Val) {
+			continue
+		}
+		if attribute.Key == "style" && isUnsafeCSS(attribute.Val) {
+			continue
+		}
+		tag.WriteString(" " + attribute.Key + `="` + html.EscapeString(attribute.Val) + `"`)
+	}
+	if token.Type == html.SelfClosingTagToken {
+		tag.WriteString(" /")
+	}
+	tag.WriteString(">")
+
+	return tag.String()
+}
+
+// sanitizeComment keeps the conditional comments targeting Outlook, sanitizing their content, and removes the other comments.
+func sanitizeComment(comment string) string {
+	if revealedComment.MatchString(comment) {
+		return "<!--" + comment + "-->"
+	}
+	if !strings.HasPrefix(comment, "[if") {
+		return ""
+	}
+	opening := strings.Index(comment, ">")
+	closing := strings.LastIndex(comment, "<![endif]")
+	if opening < 0 || closing < opening {
+		return ""
+	}
+
+	return "<!--" + comment[:opening+1] + sanitizeHTML(comment[opening+1:closing]) + comment[closing:] + "-->"
+}
+
+// sanitizeHTML keeps the elements and attributes of an HTML body that are safe in an email: the scripts, frames, forms and embedded objects
+// are removed, the unknown elements are unwrapped to their content, and the event handlers, unsafe links and unsafe styles are dropped.
+func sanitizeHTML(body string) string {
+	tokenizer := html.NewTokenizer(strings.NewReader(body))
+	var sanitized strings.Builder
+	var dropped string
+	var droppedDepth int
+	var inStyle bool
+
+	for {
+		tokenType := tokenizer.Next()
+		if tokenType == html.ErrorToken {
+			if tokenizer.Err() != io.EOF {
+				return ""
+			}
+			return sanitized.String()
+		}
+		token := tokenizer.Token()
+
+		// The content of a dropped element is skipped up to its end tag.
+		if dropped != "" {
+			switch {
+			case tokenType == html.StartTagToken && token.Data == dropped:
+				droppedDepth++
+			case tokenType == html.EndTagToken && token.Data == dropped:
+				droppedDepth--
+				if droppedDepth == 0 {
+					dropped = ""
+				}
+			}
+			continue
+		}
+
+		switch tokenType {
+		case html.DoctypeToken:
+			sanitized.WriteString(token.String())
+		case html.CommentToken:
+			sanitized.WriteString(sanitizeComment(token.Data))
+		case html.TextToken:
+			// The style sheets are raw text, which must not be escaped.
+			if inStyle {
+				if !isUnsafeCSS(token.Data) {
+					sanitized.WriteString(token.Data)
+				}
+				continue
+			}
+			sanitized.WriteString(html.EscapeString(token.Data))
+		case html.StartTagToken, html.SelfClosingTagToken:
+			if droppedElements[token.Data] {
+				if tokenType == html.StartTagToken && !isVoidElement(token.Data) {
+					dropped, droppedDepth = token.Data, 1
+				}
+				continue
+			}
+			if sanitizedElements[token.Data] {
+				inStyle = token.Data == "style" && tokenType == html.StartTagToken
+				sanitized.WriteString(sanitizedTag(token))
+			}
+		case html.EndTagToken:
+			if sanitizedElements[token.Data] {
+				if token.Data == "style" {
+					inStyle = false
+				}
+				sanitized.WriteString("</" + token.Data + ">")
+			}
+		}
+	}
+}
+
+// isVoidElement tells if the element has no end tag.
+func isVoidElement(name string) bool {
+	switch name {
+	case "area", "base", "br", "col", "embed", "hr", "img", "input", "link", "meta", "source", "track", "wbr":
+		return true
+	default:
+		return false
+	}
+}
+
+// stripMarkup returns the text of a value holding markup, without its tags and comments, nor the content of the dropped elements, ie: scripts.
+func stripMarkup(value string) string {
+	tokenizer := html.NewTokenizer(strings.NewReader(value))
+	var text strings.Builder
+	var skipped string
+	for {
+		tokenType := tokenizer.Next()
+		switch tokenType {
+		case html.ErrorToken:
+			return text.String()
+		case html.StartTagToken:
+			if name, _ := tokenizer.TagName(); skipped == "" && !isVoidElement(string(name)) && (droppedElements[string(name)] || string(name) == "style") {
+				skipped = string(name)
+			}
+		case html.EndTagToken:
+			if name, _ := tokenizer.TagName(); string(name) == skipped {
+				skipped = ""
+			}
+		case html.TextToken:
+			if skipped == "" {
+				text.Write(tokenizer.Text())
+			}
+		}
+	}
+}
+
+// applyContextMarkup applies the ContextMarkup policy to the string values of the template context, nested ones included.
+// The path of a rejected value is given in the error, ie: "template_context value user.name holds markup".
+func applyContextMarkup(value interface{}, path string, opts Options) (interface{}, error) {
+	switch typed := value.(type) {
+	case string:
+		if !contextMarkup.MatchString(typed) {
+			return typed, nil
+		}
+		if opts.ContextMarkup == ContextMarkupReject {
+			return nil, fmt.Errorf("template_context value %s holds markup, which is rejected", path)
+		}
+		return stripMarkup(typed), nil
+	case map[string]interface{}:
+		for key, field := range typed {
+			fieldPath := key
+			if path != "" {
+				fieldPath = path + "." + key
+			}
+			sanitizedField, err := applyContextMarkup(field, fieldPath, opts)
+			if err != nil {
+				return nil, err
+			}
+			typed[key] = sanitizedField
+		}
+		return typed, nil
+	case []interface{}:
+		for i, item := range typed {
+			sanitizedItem, err := applyContextMarkup(item, fmt.Sprintf("%s[%d]", path, i), opts)
+			if err != nil {
+				return nil, err
+			}
+			typed[i] = sanitizedItem
+		}
+		return typed, nil
+	default:
+		return value, nil
+	}
+}
+
+// sanitizeContext strips or rejects the markup of the template context values, unless the policy leaves them to the template escaping.
+func sanitizeContext(mailMsg *mailMessage, opts Options) error {
+	if opts.ContextMarkup == "" || opts.ContextMarkup == ContextMarkupEscape {
+		return nil
+	}
+
+	_, err := applyContextMarkup(mailMsg.TemplateContext, "", opts)
+
+	return err
+}
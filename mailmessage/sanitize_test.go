@@ -0,0 +1,163 @@
+package mailmessage
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSanitizeHTMLRemovesScripts(t *testing.T) {
+	tests := []struct {
+		name      string
+		body      string
+		forbidden []string
+	}{
+		{name: "script element", body: `<p>Hi</p><script>alert(1)</script>`, forbidden: []string{"<script", "alert"}},
+		{name: "uppercase script element", body: `<SCRIPT SRC="https://evil.example/x.js"></SCRIPT>`, forbidden: []string{"<script", "evil"}},
+		{name: "nested dropped elements", body: `<object><object></object><embed src="x"></object>`, forbidden: []string{"<object", "<embed"}},
+		{name: "svg with script", body: `<svg><script>alert(1)</script><a xlink:href="javascript:alert(1)">x</a></svg>`, forbidden: []string{"<svg", "<script", "javascript:"}},
+		{name: "math with link", body: `<math><mtext><a href="javascript:alert(1)">x</a></mtext></math>`, forbidden: []string{"<math", "javascript:"}},
+		{name: "event handler", body: `<img src="https://example.com/a.png" onerror="alert(1)">`, forbidden: []string{"onerror", "alert"}},
+		{name: "event handler without quotes", body: `<body onload=alert(1)>`, forbidden: []string{"onload", "alert"}},
+		{name: "javascript link", body: `<a href="javascript:alert(1)">x</a>`, forbidden: []string{"javascript:"}},
+		{name: "mixed case javascript link", body: `<a href="JaVaScRiPt:alert(1)">x</a>`, forbidden: []string{"javascript:"}},
+		{name: "javascript link with encoded characters", body: `<a href="jav&#x09;ascript:alert(1)">x</a><a href="&#106;avascript:alert(1)">y</a>`, forbidden: []string{"javascript:", "ascript:"}},
+		{name: "javascript link with leading spaces", body: `<a href=" &#14; javascript:alert(1)">x</a>`, forbidden: []string{"javascript:"}},
+		{name: "vbscript link", body: `<a href="vbscript:msgbox(1)">x</a>`, forbidden: []string{"vbscript:"}},
+		{name: "data link", body: `<a href="data:text/html;base64,PHNjcmlwdD5hbGVydCgxKTwvc2NyaXB0Pg==">x</a>`, forbidden: []string{"data:"}},
+		{name: "svg image data", body: `<img src="data:image/svg+xml;base64,PHN2Zz4=">`, forbidden: []string{"data:"}},
+		{name: "javascript background", body: `<td background="javascript:alert(1)">x</td>`, forbidden: []string{"javascript:"}},
+		{name: "iframe srcdoc", body: `<iframe srcdoc="<script>alert(1)</script>"></iframe>`, forbidden: []string{"<iframe", "srcdoc", "script"}},
+		{name: "srcdoc on a kept element", body: `<div srcdoc="&lt;script&gt;alert(1)&lt;/script&gt;">x</div>`, forbidden: []string{"srcdoc", "script"}},
+		{name: "form action", body: `<form action="https://evil.example"><input name="password"></form><button formaction="javascript:alert(1)">x</button>`, forbidden: []string{"<form", "<input", "formaction"}},
+		{name: "base element", body: `<base href="https://evil.example/">`, forbidden: []string{"<base", "evil"}},
+		{name: "style expression", body: `<div style="width: expression(alert(1))">x</div>`, forbidden: []string{"expression"}},
+		{name: "style javascript url", body: `<div style="background: url('javascript:alert(1)')">x</div>`, forbidden: []string{"javascript:"}},
+		{name: "style with comment", body: `<div style="width: expr/**/ession(alert(1))">x</div>`, forbidden: []string{"ession(", "alert"}},
+		{name: "style with escapes", body: `<div style="background: url(\6a\61vascript:alert(1))">x</div>`, forbidden: []string{"vascript:", "alert"}},
+		{name: "style with escaped expression", body: `<div style="width: e\78pression(alert(1))">x</div>`, forbidden: []string{"pression(", "alert"}},
+		{name: "style behavior", body: `<div style="behavior: url(x.htc)">x</div>`, forbidden: []string{"behavior"}},
+		{name: "style binding", body: `<div style="-moz-binding: url(x.xml#xss)">x</div>`, forbidden: []string{"binding"}},
+		{name: "style sheet expression", body: `<style>p { width: expression(alert(1)) }</style><p>x</p>`, forbidden: []string{"expression", "alert"}},
+		{name: "style sheet with escapes", body: `<style>p { background: url(\6a avascript:alert(1)) }</style>`, forbidden: []string{"vascript:", "alert"}},
+		{name: "style sheet closed early", body: `<style></style><script>alert(1)</script></style>`, forbidden: []string{"<script", "alert"}},
+		{name: "malformed nested tag", body: `<scr<script>ipt>alert(1)</script>`, forbidden: []string{"<script", "<scr"}},
+		{name: "unterminated tag", body: `<p>Hi</p><img src=x onerror=alert(1)//`, forbidden: []string{"onerror"}},
+		{name: "unterminated attribute", body: `<a href="javascript:alert(1)`, forbidden: []string{"javascript:"}},
+		{name: "tag in attribute value", body: `<a title="<script>alert(1)</script>">x</a>`, forbidden: []string{"<script"}},
+		{name: "attribute breaking out of its quotes", body: `<a title='x" onclick="alert(1)'>x</a>`, forbidden: []string{`" onclick`}},
+		{name: "conditional comment with script", body: `<!--[if gte mso 9]><script>alert(1)</script><![endif]-->`, forbidden: []string{"<script", "alert"}},
+		{name: "comment hiding a script", body: `<!-- --><script>alert(1)</script><!-- -->`, forbidden: []string{"<script", "alert"}},
+		{name: "unclosed comment", body: `<!--<script>alert(1)</script>`, forbidden: []string{"<script"}},
+		{name: "processing instruction", body: `<?xml-stylesheet href="javascript:alert(1)"?>`, forbidden: []string{"javascript:"}},
+		{name: "meta refresh", body: `<meta http-equiv="refresh" content="0;url=https://evil.example">`, forbidden: []string{"http-equiv"}},
+		{name: "link element", body: `<link rel="stylesheet" href="https://evil.example/x.css">`, forbidden: []string{"<link", "evil"}},
+		{name: "template element", body: `<template><img src=x onerror=alert(1)></template>`, forbidden: []string{"<template", "onerror", "<img"}},
+		{name: "noscript element", body: `<noscript><p title="</noscript><img src=x onerror=alert(1)>"></noscript>`, forbidden: []string{"onerror"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sanitized := strings.ToLower(sanitizeHTML(test.body))
+			for _, forbidden := range test.forbidden {
+				if strings.Contains(sanitized, strings.ToLower(forbidden)) {
+					t.Errorf("expected %q to be removed from %q, got %q", forbidden, test.body, sanitized)
+				}
+			}
+		})
+	}
+}
+
+func TestSanitizeHTMLKeepsSafeMarkup(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		expected string
+	}{
+		{name: "text", body: `<p>Hello &amp; welcome</p>`, expected: `<p>Hello &amp; welcome</p>`},
+		{name: "link", body: `<a href="https://example.com/?a=1&amp;b=2" target="_blank">x</a>`, expected: `<a href="https://example.com/?a=1&amp;b=2" target="_blank">x</a>`},
+		{name: "relative link", body: `<a href="/orders/42">x</a>`, expected: `<a href="/orders/42">x</a>`},
+		{name: "mailto link", body: `<a href="mailto:support@example.com">x</a>`, expected: `<a href="mailto:support@example.com">x</a>`},
+		{name: "inline image", body: `<img src="cid:logo" alt="Logo">`, expected: `<img src="cid:logo" alt="Logo">`},
+		{name: "png data image", body: `<img src="data:image/png;base64,iVBORw0KGgo=">`, expected: `<img src="data:image/png;base64,iVBORw0KGgo=">`},
+		{name: "table layout", body: `<table cellpadding="0"><tr><td style="color: #333; padding: 4px">x</td></tr></table>`, expected: `<table cellpadding="0"><tr><td style="color: #333; padding: 4px">x</td></tr></table>`},
+		{name: "style sheet", body: `<style>p { color: red; }</style>`, expected: `<style>p { color: red; }</style>`},
+		{name: "unknown element unwrapped", body: `<custom-card><p>x</p></custom-card>`, expected: `<p>x</p>`},
+		{name: "outlook conditional comment", body: `<!--[if mso]><table><tr><td>x</td></tr></table><![endif]-->`, expected: `<!--[if mso]><table><tr><td>x</td></tr></table><![endif]-->`},
+		{name: "content hidden from outlook", body: `<!--[if !mso]><!--><p>x</p><!--<![endif]-->`, expected: `<!--[if !mso]><!--><p>x</p><!--<![endif]-->`},
+		{name: "plain comment removed", body: `<p>x</p><!-- internal note -->`, expected: `<p>x</p>`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if sanitized := sanitizeHTML(test.body); sanitized != test.expected {
+				t.Errorf("expected %q, got %q", test.expected, sanitized)
+			}
+		})
+	}
+}
+
+func TestApplyContextMarkup(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   string
+		value    interface{}
+		expected interface{}
+		err      string
+	}{
+		{name: "strips tags", policy: ContextMarkupStrip, value: `<b>Jane</b>`, expected: "Jane"},
+		{name: "strips scripts with their content", policy: ContextMarkupStrip, value: `Jane<script>alert(1)</script>`, expected: "Jane"},
+		{name: "strips styles with their content", policy: ContextMarkupStrip, value: `<style>body{}</style>Jane`, expected: "Jane"},
+		{name: "keeps comparisons", policy: ContextMarkupStrip, value: `1 < 2 and 3 > 2`, expected: `1 < 2 and 3 > 2`},
+		{name: "strips nested values", policy: ContextMarkupStrip, value: map[string]interface{}{"user": map[string]interface{}{"name": `<img src=x onerror=alert(1)>Jane`}}, expected: map[string]interface{}{"user": map[string]interface{}{"name": "Jane"}}},
+		{name: "strips list items", policy: ContextMarkupStrip, value: []interface{}{`<i>a</i>`, 42.0}, expected: []interface{}{"a", 42.0}},
+		{name: "rejects markup with its path", policy: ContextMarkupReject, value: map[string]interface{}{"items": []interface{}{map[string]interface{}{"name": `<a href="javascript:alert(1)">x</a>`}}}, err: "template_context value items[0].name holds markup"},
+		{name: "accepts text when rejecting", policy: ContextMarkupReject, value: `Jane & John`, expected: `Jane & John`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			value, err := applyContextMarkup(test.value, "", Options{ContextMarkup: test.policy})
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected error %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+			if !equalValues(value, test.expected) {
+				t.Errorf("expected %#v, got %#v", test.expected, value)
+			}
+		})
+	}
+}
+
+func equalValues(a interface{}, b interface{}) bool {
+	switch typed := a.(type) {
+	case map[string]interface{}:
+		other, ok := b.(map[string]interface{})
+		if !ok || len(other) != len(typed) {
+			return false
+		}
+		for key, value := range typed {
+			if !equalValues(value, other[key]) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		other, ok := b.([]interface{})
+		if !ok || len(other) != len(typed) {
+			return false
+		}
+		for i := range typed {
+			if !equalValues(typed[i], other[i]) {
+				return false
+			}
+		}
+		return true
+	default:
+		return a == b
+	}
+}
@@ -0,0 +1,107 @@
+package mailmessage
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/forsam-education/hermes/metrics"
+	"gopkg.in/gomail.v2"
+	"hash/fnv"
+	"log"
+	"path"
+	"strings"
+	"time"
+)
+
+// shadowRecipientsHeader lists the recipients a shadowed message delivered to the shadow inbox was addressed to.
+const shadowRecipientsHeader = "X-Hermes-Shadow-Recipients"
+
+// Shadow selects the messages rendered without being delivered to their recipients, so new templates and transports can be validated
+// in production. The zero value shadows no message.
+type Shadow struct {
+	// Percent is the share of the matching messages which are shadowed, picked by hashing their template and main recipient so a retried
+	// message is shadowed again.
+	Percent int
+	// Templates limits the shadowed messages to those of these templates, whatever their version, every template matching when empty.
+	Templates []string
+	// Transports limits the shadowed messages to those sent through these transport profiles, every message matching when empty.
+	Transports []string
+	// Inbox receives the shadowed messages instead of their recipients, which are listed in the X-Hermes-Shadow-Recipients header.
+	// When empty, the shadowed messages are written to the archive, or else as dry runs.
+	Inbox string
+}
+
+// Validate checks the percent is between 0 and 100 and the inbox is a valid address.
+func (shadow Shadow) Validate() error {
+	if shadow.Percent < 0 || shadow.Percent > 100 {
+		return fmt.Errorf("shadow percent %d is not between 0 and 100", shadow.Percent)
+	}
+	if shadow.Inbox != "" {
+		if !isBareAddress(shadow.Inbox) {
+			return fmt.Errorf("shadow inbox %q is not a valid address", shadow.Inbox)
+		}
+	}
+
+	return nil
+}
+
+// contains tells if the name is in the list, an empty list containing every name.
+func contains(names []string, name string) bool {
+	if len(names) == 0 {
+		return true
+	}
+	for _, candidate := range names {
+		if candidate == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// selects tells if the message is shadowed.
+func (shadow Shadow) selects(mailMsg *mailMessage) bool {
+	if shadow.Percent <= 0 {
+		return false
+	}
+	templateName := unversionedName(mailMsg.Template)
+	if !contains(shadow.Templates, templateName) || !contains(shadow.Transports, mailMsg.Transport) {
+		return false
+	}
+
+	hash := fnv.New32a()
+	hash.Write([]byte(templateName + "\x00shadow\x00" + strings.ToLower(mailMsg.mainRecipient())))
+	return int(hash.Sum32()%100) < shadow.Percent
+}
+
+// redirectShadow addresses a shadowed message to the shadow inbox only, keeping its recipients in a header.
+func redirectShadow(mailMsg *mailMessage, opts Options) {
+	mailMsg.Headers[shadowRecipientsHeader] = strings.Join(mailMsg.envelopeRecipients(), ", ")
+	mailMsg.to = recipients{header: []string{opts.Shadow.Inbox}, envelope: []string{opts.Shadow.Inbox}}
+	mailMsg.cc, mailMsg.bcc = recipients{}, recipients{}
+	log.Printf("Shadowing message of template %s to %s", mailMsg.Template, opts.Shadow.Inbox)
+}
+
+// writeShadow writes the built message, as it would have been sent, to the archive under its shadow/ prefix, or else as a dry run.
+func writeShadow(opts Options, mailMsg *mailMessage, mail *gomail.Message) error {
+	if opts.Archive == nil {
+		return writePreview(opts, mailMsg, mail)
+	}
+
+	var rawMessage bytes.Buffer
+	message := &charsetMessage{Message: mail, textType: mailMsg.primaryContentType(), textCharset: opts.TextCharset, htmlCharset: opts.HTMLCharset, headers: mailMsg.unencodedHeaders}
+	if _, err := message.WriteTo(&rawMessage); err != nil {
+		return fmt.Errorf("unable to write raw message: %s", err.Error())
+	}
+	key := archiveKey(path.Join(opts.ArchivePrefix, "shadow"), "", time.Now())
+	if err := opts.Archive.Put(key, &rawMessage, "message/rfc822"); err != nil {
+		return err
+	}
+	log.Printf("Archived shadowed message of template %s to %s", mailMsg.Template, key)
+
+	return nil
+}
+
+// putShadowed counts a shadowed message by template.
+func putShadowed(opts Options, mailMsg *mailMessage) {
+	putMetric(opts, "messages_shadowed", 1, metrics.UnitCount, map[string]string{"template": mailMsg.Template})
+}
@@ -0,0 +1,29 @@
+package mailmessage
+
+import "strings"
+
+// signatureDelimiter is the conventional line separating a plain text body from its signature (RFC 3676).
+const signatureDelimiter = "-- "
+
+// hasSignature tells if the plain text body already contains a signature delimiter line.
+func hasSignature(textBody string) bool {
+	for _, line := range strings.Split(textBody, "\n") {
+		if strings.TrimRight(line, "\r") == signatureDelimiter {
+			return true
+		}
+	}
+
+	return false
+}
+
+// appendSignature appends the signature block to the plain text body, unless the body already has one.
+func appendSignature(textBody string, signature string) string {
+	if signature == "" || hasSignature(textBody) {
+		return textBody
+	}
+	if !strings.HasSuffix(textBody, "\n") {
+		textBody += "\n"
+	}
+
+	return textBody + signatureDelimiter + "\n" + signature
+}
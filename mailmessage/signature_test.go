@@ -0,0 +1,66 @@
+package mailmessage
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAppendSignature(t *testing.T) {
+	tests := []struct {
+		name      string
+		body      string
+		signature string
+		expected  string
+	}{
+		{name: "appends the signature", body: "Hello Jane\n", signature: "The Example team", expected: "Hello Jane\n-- \nThe Example team"},
+		{name: "appends the signature on its own line", body: "Hello Jane", signature: "The Example team", expected: "Hello Jane\n-- \nThe Example team"},
+		{name: "appends a multiline signature", body: "Hello Jane\n", signature: "The Example team\nhttps://example.com", expected: "Hello Jane\n-- \nThe Example team\nhttps://example.com"},
+		{name: "skips a body with a signature", body: "Hello Jane\n-- \nJohn", signature: "The Example team", expected: "Hello Jane\n-- \nJohn"},
+		{name: "skips a body with a CRLF signature", body: "Hello Jane\r\n-- \r\nJohn\r\n", signature: "The Example team", expected: "Hello Jane\r\n-- \r\nJohn\r\n"},
+		{name: "skips a body ending with the delimiter", body: "Hello Jane\n-- ", signature: "The Example team", expected: "Hello Jane\n-- "},
+		{name: "appends after a dashed line", body: "Hello Jane\n--\n", signature: "The Example team", expected: "Hello Jane\n--\n-- \nThe Example team"},
+		{name: "appends after an inline delimiter", body: "Hello -- Jane\n", signature: "The Example team", expected: "Hello -- Jane\n-- \nThe Example team"},
+		{name: "without signature", body: "Hello Jane\n", expected: "Hello Jane\n"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if signed := appendSignature(test.body, test.signature); signed != test.expected {
+				t.Errorf("expected %q, got %q", test.expected, signed)
+			}
+		})
+	}
+}
+
+func TestSendMailAppendsTextSignature(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		expected string
+	}{
+		{name: "appends the signature", template: "Hello {{.name}}", expected: "Hello Jane\n-- \nThe Example team"},
+		{name: "skips a template with a signature", template: "Hello {{.name}}\n-- \nJohn", expected: "Hello Jane\n-- \nJohn"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			templates := newTestTemplates()
+			templates.Set("welcome.txt.template", []byte(test.template))
+			messages := sendTestMessage(t, templates, Options{TextSignature: "The Example team"}, testMessageBody(nil))
+
+			_, parts := parseTestMessage(t, messages[0])
+			for _, part := range parts {
+				switch part.contentType {
+				case "text/plain":
+					if body := strings.Replace(part.body, "\r\n", "\n", -1); body != test.expected {
+						t.Errorf("expected text %q, got %q", test.expected, body)
+					}
+				case "text/html":
+					if strings.Contains(part.body, "Example team") {
+						t.Errorf("expected the HTML body to be unsigned, got %q", part.body)
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package mailmessage
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/forsam-education/hermes/signing"
+	"strings"
+	"time"
+)
+
+// signedEnvelope is a message signed by its producer with an HMAC, ie: {"message": {...}, "signature": "sha256=...", "key_id": "2020-10"},
+// the signature being the hex encoded HMAC-SHA256 of the message field as it is written in the body.
+type signedEnvelope struct {
+	Message   json.RawMessage `json:"message"`
+	Signature string          `json:"signature"`
+	KeyID     string          `json:"key_id"`
+}
+
+// isJWT tells if a message body is a compact JWT, whose header always starts with the base64 encoding of `{"`.
+func isJWT(messageBody string) bool {
+	return strings.HasPrefix(strings.TrimSpace(messageBody), "eyJ") && strings.Count(messageBody, ".") == 2
+}
+
+// unsignedMessage returns the message of a signed body without verifying it, for logs and metrics, or the body itself when it is not signed.
+func unsignedMessage(messageBody string) string {
+	var envelope signedEnvelope
+	if json.Unmarshal([]byte(messageBody), &envelope) == nil && envelope.Signature != "" && len(envelope.Message) > 0 {
+		return string(envelope.Message)
+	}
+
+	return messageBody
+}
+
+// verifySignature returns the message of a signed body once its signature is verified, and whether the signing keys could be read.
+func verifySignature(messageBody string, keys signing.KeySource) (string, bool, error) {
+	signingKeys, err := keys.Keys()
+	if err != nil {
+		return "", false, err
+	}
+
+	if isJWT(messageBody) {
+		claims, err := signing.VerifyJWT(signingKeys, strings.TrimSpace(messageBody), time.Now())
+		if err != nil {
+			return "", true, err
+		}
+		if len(claims["message"]) == 0 || claims["message"][0] != '{' {
+			return "", true, fmt.Errorf("token has no message claim")
+		}
+		return string(claims["message"]), true, nil
+	}
+
+	var envelope signedEnvelope
+	if err := json.Unmarshal([]byte(messageBody), &envelope); err != nil || envelope.Signature == "" || len(envelope.Message) == 0 {
+		return "", true, fmt.Errorf("message is not signed")
+	}
+	if err := signing.VerifyHMAC(signingKeys, envelope.Message, envelope.KeyID, envelope.Signature); err != nil {
+		return "", true, err
+	}
+
+	return string(envelope.Message), true, nil
+}
+
+// VerifySignature returns the message of a body signed by its producer, either as an HMAC envelope or as a JWT with a "message" claim,
+// once its signature is verified with the keys of Signatures. The body is returned as is when Signatures is nil.
+// An unsigned or invalid message is a *SendError of the decode phase, being rejected, while keys which can't be read fail it in the lookup phase.
+func VerifySignature(messageBody string, opts Options) (string, error) {
+	if opts.Signatures == nil {
+		return messageBody, nil
+	}
+
+	message, keysRead, err := verifySignature(messageBody, opts.Signatures)
+	if !keysRead {
+		return "", &SendError{Phase: PhaseLookup, message: fmt.Sprintf("unable to verify message signature: %s", err.Error())}
+	}
+	if err != nil {
+		return "", &SendError{Phase: PhaseDecode, message: fmt.Sprintf("invalid message signature: %s", err.Error())}
+	}
+
+	return message, nil
+}
@@ -0,0 +1,69 @@
+package mailmessage
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"github.com/forsam-education/hermes/signing"
+	"testing"
+)
+
+// failingKeys is a signing.KeySource whose keys can't be read.
+type failingKeys struct{}
+
+func (failingKeys) Keys() (map[string][]byte, error) {
+	return nil, fmt.Errorf("secret is unavailable")
+}
+
+func testMAC(payload string) []byte {
+	signer := hmac.New(sha256.New, []byte("producer key"))
+	signer.Write([]byte(payload))
+
+	return signer.Sum(nil)
+}
+
+func testJWT(claims string) string {
+	content := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`)) + "." + base64.RawURLEncoding.EncodeToString([]byte(claims))
+
+	return content + "." + base64.RawURLEncoding.EncodeToString(testMAC(content))
+}
+
+func TestVerifySignature(t *testing.T) {
+	message := `{"to":["jane@example.org"],"template":"welcome"}`
+	keys := signing.StaticKeys{"2020-10": []byte("producer key")}
+
+	tests := []struct {
+		name     string
+		body     string
+		keys     signing.KeySource
+		expected string
+		phase    string
+	}{
+		{name: "passes the body through without signing keys", body: message, expected: message},
+		{name: "returns the message of a signed envelope", body: fmt.Sprintf(`{"message":%s,"signature":"sha256=%s","key_id":"2020-10"}`, message, hex.EncodeToString(testMAC(message))), keys: keys, expected: message},
+		{name: "returns the message claim of a JWT", body: testJWT(`{"message":` + message + `}`), keys: keys, expected: message},
+		{name: "rejects a tampered envelope", body: fmt.Sprintf(`{"message":%s,"signature":"%s"}`, `{"to":["eve@example.org"],"template":"welcome"}`, hex.EncodeToString(testMAC(message))), keys: keys, phase: PhaseDecode},
+		{name: "rejects an unknown key id", body: fmt.Sprintf(`{"message":%s,"signature":"%s","key_id":"2019-01"}`, message, hex.EncodeToString(testMAC(message))), keys: keys, phase: PhaseDecode},
+		{name: "rejects an unsigned message when signing is required", body: message, keys: keys, phase: PhaseDecode},
+		{name: "rejects a JWT without message claim", body: testJWT(`{"sub":"jane"}`), keys: keys, phase: PhaseDecode},
+		{name: "fails in the lookup phase when the keys can't be read", body: message, keys: failingKeys{}, phase: PhaseLookup},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			verified, err := VerifySignature(test.body, Options{Signatures: test.keys})
+			if test.phase == "" {
+				if err != nil || verified != test.expected {
+					t.Errorf("expected %q, got %q, %v", test.expected, verified, err)
+				}
+				return
+			}
+			sendErr, ok := err.(*SendError)
+			if !ok || sendErr.Phase != test.phase {
+				t.Errorf("expected an error of the %s phase, got %v", test.phase, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package mailmessage
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/forsam-education/hermes/smime"
+	"github.com/forsam-education/hermes/storage"
+	"github.com/forsam-education/hermes/transport"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func newTestSMIMEIdentity(t *testing.T, recipients ...string) *smime.Identity {
+	t.Helper()
+
+	read := func(name string) string {
+		content, err := ioutil.ReadFile(filepath.Join("..", "smime", "testdata", name))
+		if err != nil {
+			t.Fatalf("unable to read fixture %s: %s", name, err.Error())
+		}
+		return string(content)
+	}
+	keys, _ := json.Marshal(smime.Keys{Certificate: read("sender.pem"), PrivateKey: read("sender.key")})
+	certificates := make(map[string]string)
+	for _, recipient := range recipients {
+		certificates[recipient+"@example.com"] = read(recipient + ".pem")
+	}
+	recipientsJSON, _ := json.Marshal(certificates)
+	identity, err := smime.NewIdentity(string(keys), string(recipientsJSON))
+	if err != nil {
+		t.Fatalf("unable to instantiate identity: %s", err.Error())
+	}
+
+	return identity
+}
+
+func TestSendMailSealsBlindCopiesApart(t *testing.T) {
+	tests := []struct {
+		name       string
+		to         []string
+		cc         []string
+		bcc        []string
+		deliveries [][]string
+	}{
+		{
+			name:       "shares a copy without blind carbon copy",
+			to:         []string{"alice@example.com"},
+			cc:         []string{"bob@example.com"},
+			deliveries: [][]string{{"alice@example.com", "bob@example.com"}},
+		},
+		{
+			name:       "seals a copy for each blind carbon copy recipient",
+			to:         []string{"alice@example.com"},
+			bcc:        []string{"bob@example.com", "sender@example.com"},
+			deliveries: [][]string{{"alice@example.com"}, {"bob@example.com"}, {"sender@example.com"}},
+		},
+		{
+			name:       "keeps a blind carbon copy recipient also in to in the shared copy",
+			to:         []string{"alice@example.com"},
+			bcc:        []string{"alice@example.com", "bob@example.com"},
+			deliveries: [][]string{{"alice@example.com"}, {"bob@example.com"}},
+		},
+	}
+
+	templates := storage.NewMemory()
+	templates.Set("invoice.html.template", []byte("<p>Your invoice</p>"))
+	templates.Set("invoice.txt.template", []byte("Your invoice"))
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake := transport.NewFake()
+			opts := Options{SMIME: map[string]*smime.Identity{"": newTestSMIMEIdentity(t, "alice", "bob")}}
+			body, _ := json.Marshal(map[string]interface{}{
+				"from_address":     "sender@example.com",
+				"reply_to":         "sender@example.com",
+				"to":               test.to,
+				"cc":               test.cc,
+				"bcc":              test.bcc,
+				"subject":          "Invoice",
+				"template_name":    "invoice",
+				"template_context": map[string]interface{}{},
+			})
+			if _, err := SendMail(context.Background(), templates, templates, fake, opts, string(body)); err != nil {
+				t.Fatalf("unable to send message: %s", err.Error())
+			}
+
+			var deliveries [][]string
+			for _, message := range fake.Messages() {
+				deliveries = append(deliveries, message.Recipients)
+				if strings.Contains(string(message.Raw), "\r\nBcc:") {
+					t.Errorf("expected no Bcc header, got %q", message.Raw)
+				}
+			}
+			if !reflect.DeepEqual(deliveries, test.deliveries) {
+				t.Errorf("expected deliveries %v, got %v", test.deliveries, deliveries)
+			}
+		})
+	}
+}
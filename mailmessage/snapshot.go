@@ -0,0 +1,200 @@
+package mailmessage
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/forsam-education/hermes/storage"
+	"log"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// snapshotViewport is a width the HTML body of a snapshot should be captured at by the screenshot tools.
+type snapshotViewport struct {
+	Name  string `json:"name"`
+	Width int    `json:"width"`
+}
+
+// snapshotViewports are the widths of the desktop and mobile email clients.
+var snapshotViewports = []snapshotViewport{{Name: "desktop", Width: 1200}, {Name: "mobile", Width: 375}}
+
+// snapshotManifest describes the files of a snapshot, for the QA reviews and the screenshot tools.
+type snapshotManifest struct {
+	Template  string             `json:"template"`
+	Version   string             `json:"version"`
+	Locale    string             `json:"locale,omitempty"`
+	Subject   string             `json:"subject"`
+	TakenAt   string             `json:"taken_at"`
+	Files     []string           `json:"files"`
+	Viewports []snapshotViewport `json:"viewports"`
+}
+
+// digestingFetcher records the templates fetched to render a message, so its snapshot is keyed by the version of their content.
+type digestingFetcher struct {
+	storage.TemplateFetcher
+	mu      sync.Mutex
+	fetched map[string]string
+}
+
+func (fetcher *digestingFetcher) Fetch(templateName string) (string, error) {
+	content, err := fetcher.TemplateFetcher.Fetch(templateName)
+	if err == nil {
+		fetcher.mu.Lock()
+		if fetcher.fetched == nil {
+			fetcher.fetched = make(map[string]string)
+		}
+		fetcher.fetched[templateName] = content
+		fetcher.mu.Unlock()
+	}
+
+	return content, err
+}
+
+// version returns the first 12 hex digits of the SHA-256 digest of the fetched templates, which changes with any of them.
+func (fetcher *digestingFetcher) version() string {
+	fetcher.mu.Lock()
+	defer fetcher.mu.Unlock()
+
+	names := make([]string, 0, len(fetcher.fetched))
+	for name := range fetcher.fetched {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	digest := sha256.New()
+	for _, name := range names {
+		digest.Write([]byte(name + "\x00" + fetcher.fetched[name] + "\x00"))
+	}
+
+	return hex.EncodeToString(digest.Sum(nil))[:12]
+}
+
+// Snapshots writes the rendered bodies of a template, once per version, so QA can review what recipients get for each template change.
+// A snapshot is made of the HTML body, the plain text summary, the AMP body if any and a manifest listing them with the viewports
+// to capture the HTML body at, under <prefix>/<template>/<version>/<locale>/.
+type Snapshots struct {
+	writer storage.ObjectWriter
+	prefix string
+	mu     sync.Mutex
+	taken  map[string]bool
+}
+
+// summarizeRendering returns the plain text summary of a snapshot: its template, version, locale and subject, the size of its bodies and its plain text body.
+func summarizeRendering(manifest snapshotManifest, rendering *Rendering) string {
+	var summary strings.Builder
+	locale := manifest.Locale
+	if locale == "" {
+		locale = "default"
+	}
+	fmt.Fprintf(&summary, "Template: %s\nVersion: %s\nLocale: %s\nSubject: %s\n", manifest.Template, manifest.Version, locale, rendering.Subject)
+	fmt.Fprintf(&summary, "HTML body: %d bytes\nText body: %d bytes\n", len(rendering.HTML), len(rendering.Text))
+	if rendering.AMP != "" {
+		fmt.Fprintf(&summary, "AMP body: %d bytes\n", len(rendering.AMP))
+	}
+	summary.WriteString("\n")
+	summary.WriteString(rendering.Text)
+
+	return summary.String()
+}
+
+// take writes the snapshot of the rendering of the template version, unless it was already taken by this process and force is not set.
+// It returns the key prefix of the snapshot.
+func (snapshots *Snapshots) take(templateName string, version string, locale string, rendering *Rendering, force bool) (string, error) {
+	directory := strings.ToLower(locale)
+	if directory == "" {
+		directory = "default"
+	}
+	key := path.Join(snapshots.prefix, unversionedName(templateName), version, directory)
+	snapshots.mu.Lock()
+	if snapshots.taken[key] && !force {
+		snapshots.mu.Unlock()
+		return key, nil
+	}
+	snapshots.taken[key] = true
+	snapshots.mu.Unlock()
+
+	manifest := snapshotManifest{
+		Template:  templateName,
+		Version:   version,
+		Locale:    locale,
+		Subject:   rendering.Subject,
+		TakenAt:   time.Now().UTC().Format(time.RFC3339),
+		Files:     []string{"body.html", "summary.txt"},
+		Viewports: snapshotViewports,
+	}
+	files := map[string]string{"body.html": rendering.HTML, "summary.txt": summarizeRendering(manifest, rendering)}
+	if rendering.AMP != "" {
+		manifest.Files = append(manifest.Files, "amp.html")
+		files["amp.html"] = rendering.AMP
+	}
+	encodedManifest, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("unable to encode snapshot manifest: %s", err.Error())
+	}
+
+	for _, name := range manifest.Files {
+		contentType := "text/html; charset=utf-8"
+		if strings.HasSuffix(name, ".txt") {
+			contentType = "text/plain; charset=utf-8"
+		}
+		if err := snapshots.writer.Put(path.Join(key, name), strings.NewReader(files[name]), contentType); err != nil {
+			snapshots.forget(key)
+			return "", err
+		}
+	}
+	// The manifest is written last, so a listed snapshot is complete.
+	if err := snapshots.writer.Put(path.Join(key, "manifest.json"), bytes.NewReader(encodedManifest), "application/json"); err != nil {
+		snapshots.forget(key)
+		return "", err
+	}
+
+	return key, nil
+}
+
+// forget allows a snapshot which could not be written to be taken again.
+func (snapshots *Snapshots) forget(key string) {
+	snapshots.mu.Lock()
+	defer snapshots.mu.Unlock()
+
+	delete(snapshots.taken, key)
+}
+
+// snapshotMessage takes the snapshot of the rendered message, its failure being only logged as the snapshots are not part of the sending.
+func snapshotMessage(opts Options, mailMsg *mailMessage, templates *digestingFetcher) {
+	if opts.Snapshots == nil || mailMsg.Template == "" || mailMsg.rendering == nil {
+		return
+	}
+
+	key, err := opts.Snapshots.take(mailMsg.Template, templates.version(), mailMsg.Locale, mailMsg.rendering, false)
+	if err != nil {
+		log.Printf("Unable to write snapshot of template %s: %s", mailMsg.Template, err.Error())
+		return
+	}
+	log.Printf("Snapshot of template %s is %s", mailMsg.Template, key)
+}
+
+// SnapshotTemplate renders a template against the raw JSON context, as PreviewMail does, and writes its snapshot, replacing any previous
+// snapshot of the same version. It returns the key prefix of the snapshot.
+func SnapshotTemplate(templateConnector storage.TemplateFetcher, opts Options, templateName string, locale string, subject string, rawContext json.RawMessage) (string, error) {
+	if opts.Snapshots == nil {
+		return "", fmt.Errorf("no snapshot storage is configured")
+	}
+
+	templates := &digestingFetcher{TemplateFetcher: storage.Uncached(templateConnector)}
+	rendering, err := PreviewMail(templates, opts, templateName, locale, subject, rawContext)
+	if err != nil {
+		return "", err
+	}
+
+	return opts.Snapshots.take(templateName, templates.version(), locale, rendering, true)
+}
+
+// NewSnapshots instanciates Snapshots written with the writer under the key prefix.
+func NewSnapshots(writer storage.ObjectWriter, prefix string) *Snapshots {
+	return &Snapshots{writer: writer, prefix: prefix, taken: make(map[string]bool)}
+}
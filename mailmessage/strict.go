@@ -0,0 +1,105 @@
+package mailmessage
+
+import (
+	"fmt"
+	"strings"
+	"text/template/parse"
+)
+
+// lookupPath tells if the path of keys exists in the context, returning the first missing one. A path going through a value which
+// is not a map, ie: null, is not followed further, as the template can't be told wrong before executing it.
+func lookupPath(templateContext map[string]interface{}, path []string) (string, bool) {
+	var current interface{} = templateContext
+	for i, key := range path {
+		values, ok := current.(map[string]interface{})
+		if !ok {
+			return "", true
+		}
+		if current, ok = values[key]; !ok {
+			return "." + strings.Join(path[:i+1], "."), false
+		}
+	}
+
+	return "", true
+}
+
+// contextChecker walks a template tree for the fields it reads from the context, the dot being the context outside of range and with blocks.
+type contextChecker struct {
+	templateContext map[string]interface{}
+	missing         string
+}
+
+func (checker *contextChecker) check(path []string) {
+	if checker.missing != "" {
+		return
+	}
+	if missing, ok := lookupPath(checker.templateContext, path); !ok {
+		checker.missing = missing
+	}
+}
+
+func (checker *contextChecker) walkPipe(pipe *parse.PipeNode, atRoot bool) {
+	if pipe == nil {
+		return
+	}
+	for _, command := range pipe.Cmds {
+		for _, arg := range command.Args {
+			switch node := arg.(type) {
+			case *parse.FieldNode:
+				if atRoot {
+					checker.check(node.Ident)
+				}
+			case *parse.VariableNode:
+				if len(node.Ident) > 1 && node.Ident[0] == "$" {
+					checker.check(node.Ident[1:])
+				}
+			case *parse.PipeNode:
+				checker.walkPipe(node, atRoot)
+			}
+		}
+	}
+}
+
+func (checker *contextChecker) walk(node parse.Node, atRoot bool) {
+	switch node := node.(type) {
+	case *parse.ListNode:
+		if node == nil {
+			return
+		}
+		for _, child := range node.Nodes {
+			checker.walk(child, atRoot)
+		}
+	case *parse.ActionNode:
+		checker.walkPipe(node.Pipe, atRoot)
+	case *parse.TemplateNode:
+		checker.walkPipe(node.Pipe, atRoot)
+	case *parse.IfNode:
+		checker.walkPipe(node.Pipe, atRoot)
+		checker.walk(node.List, atRoot)
+		checker.walk(node.ElseList, atRoot)
+	case *parse.RangeNode:
+		checker.walkPipe(node.Pipe, atRoot)
+		checker.walk(node.List, false)
+		checker.walk(node.ElseList, atRoot)
+	case *parse.WithNode:
+		checker.walkPipe(node.Pipe, atRoot)
+		checker.walk(node.List, false)
+		checker.walk(node.ElseList, atRoot)
+	}
+}
+
+// checkContextFields fails when the template reads a field missing from the context, naming the first one and the template, so strict
+// rendering reports it before anything is executed. The partials are not checked, as the dot they get depends on their invocation.
+func checkContextFields(tree *parse.Tree, templateContext map[string]interface{}) error {
+	if tree == nil {
+		return nil
+	}
+
+	checker := &contextChecker{templateContext: templateContext}
+	checker.walk(tree.Root, true)
+	if checker.missing != "" {
+		return fmt.Errorf("missing variable %s in template %s", checker.missing, tree.Name)
+	}
+
+	return nil
+}
@@ -0,0 +1,51 @@
+package mailmessage
+
+import (
+	"log"
+	"net/mail"
+	"strings"
+)
+
+// withoutSuppressed returns the recipients but the suppressed addresses. Groups are kept in the headers, their suppressed members being dropped from the envelope only.
+func withoutSuppressed(list recipients, suppressed map[string]bool) recipients {
+	var kept recipients
+	for _, header := range list.header {
+		if address, err := mail.ParseAddress(header); err == nil && suppressed[strings.ToLower(address.Address)] {
+			continue
+		}
+		kept.header = append(kept.header, header)
+	}
+	for _, address := range list.envelope {
+		if !suppressed[strings.ToLower(address)] {
+			kept.envelope = append(kept.envelope, address)
+		}
+	}
+
+	return kept
+}
+
+// dropSuppressed removes the suppressed recipients of the message, returning a *SuppressedError when none is left.
+func dropSuppressed(mailMsg *mailMessage, opts Options) error {
+	if opts.Suppressions == nil {
+		return nil
+	}
+
+	addresses, err := opts.Suppressions.Suppressed(mailMsg.envelopeRecipients())
+	if err != nil || len(addresses) == 0 {
+		return err
+	}
+
+	suppressed := make(map[string]bool, len(addresses))
+	for _, address := range addresses {
+		suppressed[strings.ToLower(address)] = true
+	}
+	mailMsg.to = withoutSuppressed(mailMsg.to, suppressed)
+	mailMsg.cc = withoutSuppressed(mailMsg.cc, suppressed)
+	mailMsg.bcc = withoutSuppressed(mailMsg.bcc, suppressed)
+	if len(mailMsg.envelopeRecipients()) == 0 {
+		return &SuppressedError{Addresses: addresses}
+	}
+	log.Printf("Dropping suppressed recipients %s of template %s", strings.Join(addresses, ", "), mailMsg.Template)
+
+	return nil
+}
@@ -0,0 +1,56 @@
+package mailmessage
+
+import (
+	"fmt"
+	"github.com/forsam-education/hermes/storage"
+)
+
+// Tenant is a brand served by a shared deployment, whose settings apply to the messages naming it in their tenant field.
+// The message fields always take precedence over the tenant settings.
+type Tenant struct {
+	// Templates reads the templates of the tenant messages instead of the default template storage, when not nil.
+	Templates storage.TemplateFetcher
+	// Transport is the transport profile of the tenant messages, the default transport being used when empty.
+	Transport string
+	// DKIMIdentity is the DKIM identity signing the tenant messages, which are otherwise signed by the identity of their From domain.
+	DKIMIdentity string
+	// DefaultFromAddress is the sender of the tenant messages without from_address, the Options one being used when empty.
+	DefaultFromAddress string
+	// DefaultFromName is the from name of the messages sent from DefaultFromAddress without a from_name.
+	DefaultFromName string
+}
+
+// applyTenant gives the settings of its tenant to the message, failing when the tenant is unknown.
+func applyTenant(mailMsg *mailMessage, opts Options) error {
+	if mailMsg.Tenant == "" {
+		return nil
+	}
+	tenant, ok := opts.Tenants[mailMsg.Tenant]
+	if !ok {
+		return fmt.Errorf("tenant %q is not a configured tenant", mailMsg.Tenant)
+	}
+
+	if mailMsg.Transport == "" {
+		mailMsg.Transport = tenant.Transport
+	}
+	if mailMsg.DKIMIdentity == "" {
+		mailMsg.DKIMIdentity = tenant.DKIMIdentity
+	}
+	if mailMsg.FromAddress == "" && tenant.DefaultFromAddress != "" {
+		mailMsg.FromAddress = tenant.DefaultFromAddress
+		if mailMsg.FromName == "" {
+			mailMsg.FromName = tenant.DefaultFromName
+		}
+	}
+
+	return nil
+}
+
+// tenantTemplates returns the template storage of the message tenant, or the given one by default.
+func tenantTemplates(templateConnector storage.TemplateFetcher, mailMsg *mailMessage, opts Options) storage.TemplateFetcher {
+	if tenant, ok := opts.Tenants[mailMsg.Tenant]; ok && tenant.Templates != nil {
+		return tenant.Templates
+	}
+
+	return templateConnector
+}
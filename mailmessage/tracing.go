@@ -0,0 +1,27 @@
+package mailmessage
+
+import (
+	"context"
+	"github.com/forsam-education/hermes/storage"
+	"github.com/forsam-education/hermes/tracing"
+)
+
+// tracedFetcher traces each template fetch as a child span of the context one, so slow downloads show up in the render phase.
+type tracedFetcher struct {
+	storage.TemplateFetcher
+	ctx    context.Context
+	tracer *tracing.Tracer
+}
+
+func (fetcher tracedFetcher) Fetch(templateName string) (string, error) {
+	_, span := fetcher.tracer.StartSpan(fetcher.ctx, "fetch_template")
+	content, err := storage.FetchContext(fetcher.ctx, fetcher.TemplateFetcher, templateName)
+	if storage.IsNotFound(err) {
+		// Optional templates are expected to be missing, which is not a failure of the fetch.
+		span.End(nil)
+	} else {
+		span.End(err)
+	}
+
+	return content, err
+}
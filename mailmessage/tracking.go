@@ -0,0 +1,28 @@
+package mailmessage
+
+import (
+	"fmt"
+	"github.com/forsam-education/hermes/tracking"
+)
+
+// addTracking rewrites the links of the HTML body and adds it the open tracking pixel, identifying the message by its tracking_id field,
+// or else by a generated id kept in the field so it is logged once sent. Messages with no HTML body or with no_tracking are not tracked.
+func addTracking(htmlBody string, mailMsg *mailMessage, opts Options) (string, error) {
+	if opts.Tracking == nil || mailMsg.NoTracking || htmlBody == "" {
+		return htmlBody, nil
+	}
+
+	if mailMsg.TrackingID == "" {
+		id, err := tracking.NewID()
+		if err != nil {
+			return "", err
+		}
+		mailMsg.TrackingID = id
+	}
+	tracked, err := opts.Tracking.Inject(htmlBody, mailMsg.TrackingID)
+	if err != nil {
+		return "", fmt.Errorf("unable to add tracking: %s", err.Error())
+	}
+
+	return tracked, nil
+}
@@ -0,0 +1,38 @@
+package mailmessage
+
+import (
+	"golang.org/x/text/unicode/norm"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// asciiReplacements transliterate the letters and punctuation which don't decompose into an ASCII character and combining marks.
+var asciiReplacements = map[rune]string{
+	'ß': "ss", 'æ': "ae", 'Æ': "AE", 'œ': "oe", 'Œ': "OE", 'ø': "o", 'Ø': "O", 'đ': "d", 'Đ': "D", 'ð': "d", 'Ð': "D",
+	'ł': "l", 'Ł': "L", 'þ': "th", 'Þ': "Th", 'ı': "i", 'ħ': "h", 'Ħ': "H", 'ŋ': "ng", 'Ŋ': "NG",
+	'‘': "'", '’': "'", '‚': "'", '“': `"`, '”': `"`, '„': `"`, '«': `"`, '»': `"`, '‹': "'", '›': "'",
+	'–': "-", '—': "-", '‐': "-", '…': "...", '•': "*", '·': ".", '\u00a0': " ", '€': "EUR", '£': "GBP", '©': "(c)", '®': "(R)", '™': "TM",
+}
+
+// transliterate returns the ASCII version of the text, removing the accents of its letters, ie: "Crème brûlée" becoming "Creme brulee".
+// It tells false when the text holds characters which have no ASCII version, ie: CJK ideographs.
+func transliterate(text string) (string, bool) {
+	var builder strings.Builder
+	for _, char := range norm.NFD.String(text) {
+		switch {
+		case char < utf8.RuneSelf:
+			builder.WriteRune(char)
+		case unicode.Is(unicode.Mn, char):
+			continue
+		default:
+			replacement, ok := asciiReplacements[char]
+			if !ok {
+				return "", false
+			}
+			builder.WriteString(replacement)
+		}
+	}
+
+	return builder.String(), true
+}
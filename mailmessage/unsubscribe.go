@@ -0,0 +1,42 @@
+package mailmessage
+
+import "gopkg.in/gomail.v2"
+
+// Categories of the messages. Bulk messages get the RFC 8058 one-click unsubscribe headers.
+const (
+	CategoryTransactional = "transactional"
+	CategoryBulk          = "bulk"
+)
+
+// unsubscribeURLKey is the template context key receiving the per-recipient unsubscribe URL.
+const unsubscribeURLKey = "UnsubscribeURL"
+
+// addUnsubscribeURL signs an unsubscribe token for the main recipient, and exposes the resulting URL to the templates as {{.UnsubscribeURL}}.
+func addUnsubscribeURL(mailMsg *mailMessage, opts Options) error {
+	if opts.Unsubscribe == nil {
+		return nil
+	}
+	recipients := mailMsg.envelopeRecipients()
+	if len(mailMsg.to.envelope) > 0 {
+		recipients = mailMsg.to.envelope
+	}
+
+	unsubscribeURL, err := opts.Unsubscribe.Build(recipients[0], mailMsg.ListID)
+	if err != nil {
+		return err
+	}
+	if mailMsg.TemplateContext == nil {
+		mailMsg.TemplateContext = make(map[string]interface{})
+	}
+	mailMsg.TemplateContext[unsubscribeURLKey] = unsubscribeURL
+	mailMsg.unsubscribeURL = unsubscribeURL
+
+	return nil
+}
+
+// setOneClickUnsubscribe sets the List-Unsubscribe header to the unsubscribe URL, and the List-Unsubscribe-Post header telling mailbox providers
+// they may unsubscribe the recipient with a POST request to it, as defined by RFC 8058.
+func setOneClickUnsubscribe(message *gomail.Message, unsubscribeURL string) {
+	message.SetHeader("List-Unsubscribe", "<"+unsubscribeURL+">")
+	message.SetHeader("List-Unsubscribe-Post", "List-Unsubscribe=One-Click")
+}
@@ -0,0 +1,74 @@
+package mailmessage
+
+import (
+	"context"
+	"github.com/forsam-education/hermes/transport"
+	"github.com/forsam-education/hermes/unsubscribe"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSendMailUnsubscribeURL(t *testing.T) {
+	builder, err := unsubscribe.NewURLBuilder("s3cr3t", "https://example.com/unsubscribe?token={{.Token}}")
+	if err != nil {
+		t.Fatalf("unable to instantiate builder: %s", err.Error())
+	}
+	templates := newTestTemplates()
+	templates.Set("welcome.txt.template", []byte("Hello {{.name}}, unsubscribe at {{.UnsubscribeURL}}"))
+
+	tests := []struct {
+		name     string
+		fields   map[string]interface{}
+		oneClick bool
+	}{
+		{name: "exposes the URL to transactional messages", fields: map[string]interface{}{"list_id": "newsletter"}},
+		{name: "sets the one-click headers of bulk messages", fields: map[string]interface{}{"list_id": "newsletter", "category": CategoryBulk}, oneClick: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			messages := sendTestMessage(t, templates, Options{Unsubscribe: builder}, testMessageBody(test.fields))
+			header, parts := parseTestMessage(t, messages[0])
+
+			var unsubscribeURL string
+			for _, part := range parts {
+				if part.contentType == "text/plain" {
+					unsubscribeURL = strings.TrimPrefix(part.body, "Hello Jane, unsubscribe at ")
+				}
+			}
+			parsed, err := url.Parse(unsubscribeURL)
+			if err != nil {
+				t.Fatalf("expected an unsubscribe URL, got %q", unsubscribeURL)
+			}
+			token, err := unsubscribe.VerifyToken([]byte("s3cr3t"), parsed.Query().Get("token"), time.Minute)
+			if err != nil {
+				t.Fatalf("expected a valid token in %q: %s", unsubscribeURL, err.Error())
+			}
+			if token.Recipient != "jane@example.org" || token.ListID != "newsletter" {
+				t.Errorf("expected the token of jane@example.org for newsletter, got %+v", token)
+			}
+
+			if !test.oneClick {
+				if value := header.Get("List-Unsubscribe"); value != "" {
+					t.Errorf("expected no List-Unsubscribe header, got %q", value)
+				}
+				return
+			}
+			if value := header.Get("List-Unsubscribe"); value != "<"+unsubscribeURL+">" {
+				t.Errorf("expected List-Unsubscribe <%s>, got %q", unsubscribeURL, value)
+			}
+			if value := header.Get("List-Unsubscribe-Post"); value != "List-Unsubscribe=One-Click" {
+				t.Errorf("expected List-Unsubscribe-Post List-Unsubscribe=One-Click, got %q", value)
+			}
+		})
+	}
+}
+
+func TestValidateMailMessageBulkRequiresUnsubscribe(t *testing.T) {
+	_, err := SendMail(context.Background(), newTestTemplates(), newTestTemplates(), transport.NewFake(), Options{}, testMessageBody(map[string]interface{}{"category": CategoryBulk}))
+	if err == nil || !strings.Contains(err.Error(), `category "bulk" requires unsubscribe URLs`) {
+		t.Errorf("expected a bulk message without unsubscribe URLs to be rejected, got %v", err)
+	}
+}
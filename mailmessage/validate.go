@@ -0,0 +1,49 @@
+package mailmessage
+
+import (
+	"fmt"
+	"github.com/forsam-education/hermes/storage"
+	"github.com/forsam-education/hermes/templating"
+	htemplate "html/template"
+	"strings"
+	ttemplate "text/template"
+)
+
+// ValidateTemplate fetches and parses the stored template, with the partials it invokes, without executing it.
+// The kind of template is told by its key suffix, and a syntax error gives the key, line and column of the faulty action, ie:
+// "template: welcome.html.template:3:14: function "fullname" not defined".
+func ValidateTemplate(templateConnector storage.TemplateFetcher, key string) error {
+	content, err := templateConnector.Fetch(key)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case strings.HasSuffix(key, ".amp.html.template"):
+		return parseWithPartials(templateConnector, htmlSet{htemplate.New(key).Funcs(templateFuncs())}, content, ".amp.template")
+	case strings.HasSuffix(key, ".html.template"):
+		return parseWithPartials(templateConnector, htmlSet{htemplate.New(key).Funcs(templateFuncs())}, content, ".html.template")
+	case strings.HasSuffix(key, ".mjml.template"):
+		return parseWithPartials(templateConnector, htmlSet{htemplate.New(key).Funcs(templateFuncs())}, content, ".mjml.template")
+	case strings.HasSuffix(key, ".md.template"):
+		return parseWithPartials(templateConnector, textSet{ttemplate.New(key).Funcs(templateFuncs())}, content, ".md.template")
+	case strings.HasSuffix(key, ".amp.template"):
+		return parseWithPartials(templateConnector, htmlSet{htemplate.New(key).Funcs(templateFuncs())}, content, ".amp.template")
+	case strings.HasSuffix(key, ".txt.template"):
+		return parseWithPartials(templateConnector, textSet{ttemplate.New(key).Funcs(templateFuncs())}, content, ".txt.template")
+	case strings.HasSuffix(key, ".mustache"):
+		return templating.NewMustache().Parse(key, content)
+	default:
+		return fmt.Errorf("template %q has an unknown kind, expecting .html.template, .mjml.template, .md.template, .amp.template, .txt.template or .mustache", key)
+	}
+}
+
+// ValidateEngineTemplate fetches and parses the stored template written for the engine, ie: a ".txt.template" rendered with Mustache.
+func ValidateEngineTemplate(templateConnector storage.TemplateFetcher, engine templating.Engine, key string) error {
+	content, err := templateConnector.Fetch(key)
+	if err != nil {
+		return err
+	}
+
+	return engine.Parse(key, content)
+}
@@ -0,0 +1,522 @@
+package mailmessage
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/forsam-education/hermes/metrics"
+	"golang.org/x/text/unicode/norm"
+	"log"
+	"net/mail"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// domainOf returns the domain part of an email address.
+func domainOf(address string) string {
+	return address[strings.LastIndex(address, "@")+1:]
+}
+
+// recipients holds the header values of an address field, and the addresses the message must be delivered to for this field.
+type recipients struct {
+	header   []string
+	envelope []string
+}
+
+// namedAddress is the object form of a recipient, ie: {"name": "Zoé Martin", "address": "zoe@forsam.education"}.
+type namedAddress struct {
+	Name    string `json:"name"`
+	Address string `json:"address"`
+}
+
+// recipientList is an address field decoded from either a single recipient, or an array of recipients as strings or objects.
+// Object recipients are kept in their "Name <address>" form, so they are resolved as any other recipient.
+type recipientList []string
+
+// UnmarshalJSON decodes the recipients from a string, or from an array of strings and namedAddress objects.
+func (list *recipientList) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		var recipient string
+		if err := json.Unmarshal(data, &recipient); err != nil {
+			return err
+		}
+		*list = recipientList{recipient}
+		return nil
+	}
+
+	var entries []json.RawMessage
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return err
+	}
+	*list = make(recipientList, 0, len(entries))
+	for _, entry := range entries {
+		if len(entry) > 0 && entry[0] == '"' {
+			var recipient string
+			if err := json.Unmarshal(entry, &recipient); err != nil {
+				return err
+			}
+			*list = append(*list, recipient)
+			continue
+		}
+		var recipient namedAddress
+		if err := json.Unmarshal(entry, &recipient); err != nil {
+			return err
+		}
+		if recipient.Address == "" {
+			return fmt.Errorf("recipient %s has no address", entry)
+		}
+		*list = append(*list, (&mail.Address{Name: recipient.Name, Address: recipient.Address}).String())
+	}
+
+	return nil
+}
+
+// isAlias tells if the recipient is a mailing-list alias, ie: "team:support", rather than an address or a group.
+func isAlias(recipient string) bool {
+	return strings.Contains(recipient, ":") && !strings.Contains(recipient, "@") && !strings.HasSuffix(recipient, ";")
+}
+
+// isGroup tells if the recipient uses the RFC 5322 group syntax, ie: "Team: a@example.com, b@example.com;".
+func isGroup(recipient string) bool {
+	recipient = strings.TrimSpace(recipient)
+
+	return strings.Contains(recipient, ":") && strings.HasSuffix(recipient, ";")
+}
+
+// parseGroup validates the members of an RFC 5322 group, returning the normalized group header value and the member addresses.
+func parseGroup(recipient string, opts Options) (string, []string, error) {
+	recipient = strings.TrimSpace(recipient)
+	separator := strings.Index(recipient, ":")
+	name := strings.TrimSpace(recipient[:separator])
+	if name == "" {
+		return "", nil, fmt.Errorf("group %q has no name", recipient)
+	}
+	membersList := strings.TrimSpace(strings.TrimSuffix(recipient[separator+1:], ";"))
+
+	var members []*mail.Address
+	if membersList != "" {
+		var err error
+		if members, err = mail.ParseAddressList(membersList); err != nil {
+			return "", nil, fmt.Errorf("group %q has invalid members: %s", name, err.Error())
+		}
+	}
+
+	formattedMembers := make([]string, len(members))
+	addresses := make([]string, len(members))
+	for i, member := range members {
+		var err error
+		if member.Address, err = normalizeAddress(member.Address, opts); err != nil {
+			return "", nil, fmt.Errorf("group %q has an invalid member: address %s", name, err.Error())
+		}
+		formattedMembers[i] = formatAddress(member, opts)
+		addresses[i] = member.Address
+	}
+
+	if !isPrintableASCII(name) {
+		name = encodeDisplayName(name, opts)
+	}
+
+	return name + ": " + strings.Join(formattedMembers, ", ") + ";", addresses, nil
+}
+
+// resolveRecipients splits groups into their header value and member addresses, and the other recipients into their encoded header value and address.
+func resolveRecipients(list []string, opts Options) (recipients, error) {
+	var resolved recipients
+	for _, recipient := range list {
+		if !isGroup(recipient) {
+			address, err := mail.ParseAddress(recipient)
+			if err != nil {
+				return recipients{}, fmt.Errorf("invalid address %q: %s", recipient, err.Error())
+			}
+			if address.Address, err = normalizeAddress(address.Address, opts); err != nil {
+				return recipients{}, fmt.Errorf("invalid address %s", err.Error())
+			}
+			resolved.header = append(resolved.header, formatAddress(address, opts))
+			resolved.envelope = append(resolved.envelope, address.Address)
+			continue
+		}
+		header, members, err := parseGroup(recipient, opts)
+		if err != nil {
+			return recipients{}, err
+		}
+		resolved.header = append(resolved.header, header)
+		resolved.envelope = append(resolved.envelope, members...)
+	}
+
+	return resolved, nil
+}
+
+// expand replaces the aliases of the recipients list by the addresses they stand for, removing duplicates.
+func (aliases Aliases) expand(list []string) ([]string, error) {
+	expanded := make([]string, 0, len(list))
+	seen := make(map[string]bool, len(list))
+	add := func(address string) {
+		if !seen[address] {
+			seen[address] = true
+			expanded = append(expanded, address)
+		}
+	}
+
+	for _, recipient := range list {
+		if !isAlias(recipient) {
+			add(recipient)
+			continue
+		}
+		addresses, ok := aliases[recipient]
+		if !ok {
+			return nil, fmt.Errorf("unknown mailing-list alias %q", recipient)
+		}
+		for _, address := range addresses {
+			add(address)
+		}
+	}
+
+	return expanded, nil
+}
+
+// envelopeRecipients lists every To, Cc and Bcc address the message must be delivered to, without duplicates.
+func (mailMsg *mailMessage) envelopeRecipients() []string {
+	var addresses []string
+	seen := make(map[string]bool)
+	for _, list := range [][]string{mailMsg.to.envelope, mailMsg.cc.envelope, mailMsg.bcc.envelope} {
+		for _, address := range list {
+			if !seen[address] {
+				seen[address] = true
+				addresses = append(addresses, address)
+			}
+		}
+	}
+
+	return addresses
+}
+
+// sealedDeliveries returns the envelope recipients of each copy of a message sealed with S/MIME: the to and cc recipients share the first copy,
+// and each blind carbon copy recipient has their own.
+func (mailMsg *mailMessage) sealedDeliveries() [][]string {
+	visible := make(map[string]bool)
+	for _, list := range [][]string{mailMsg.to.envelope, mailMsg.cc.envelope} {
+		for _, address := range list {
+			visible[address] = true
+		}
+	}
+
+	var shared []string
+	var deliveries [][]string
+	for _, address := range mailMsg.envelopeRecipients() {
+		if visible[address] {
+			shared = append(shared, address)
+		} else {
+			deliveries = append(deliveries, []string{address})
+		}
+	}
+	if len(shared) > 0 {
+		deliveries = append([][]string{shared}, deliveries...)
+	}
+
+	return deliveries
+}
+
+// validateRecipients expands the mailing-list aliases of an address field, and resolves the groups it contains.
+func validateRecipients(list []string, opts Options) (recipients, error) {
+	expanded, err := opts.Aliases.expand(list)
+	if err != nil {
+		return recipients{}, err
+	}
+
+	return resolveRecipients(expanded, opts)
+}
+
+// Policies applied to the invalid cc and bcc recipients of a message.
+const (
+	// InvalidRecipientsReject fails the messages having an invalid recipient.
+	InvalidRecipientsReject = "reject"
+	// InvalidRecipientsDrop removes the invalid recipients, sending the message to the other ones.
+	InvalidRecipientsDrop = "drop"
+)
+
+// validateCopyRecipients validates the cc or bcc recipients. With the InvalidRecipientsDrop policy, the invalid ones are dropped with a warning
+// and counted, the message being sent to the valid ones.
+func validateCopyRecipients(field string, list []string, mailMsg *mailMessage, opts Options) (recipients, error) {
+	resolved, err := validateRecipients(list, opts)
+	if err == nil || opts.InvalidRecipients != InvalidRecipientsDrop {
+		return resolved, err
+	}
+
+	resolved = recipients{}
+	seen := make(map[string]bool, len(list))
+	var dropped int
+	for _, recipient := range list {
+		entry, err := validateRecipients([]string{recipient}, opts)
+		if err != nil {
+			log.Printf("Dropping invalid %s recipient of template %s: %s", field, mailMsg.Template, err.Error())
+			dropped++
+			continue
+		}
+		for _, header := range entry.header {
+			if !seen[header] {
+				seen[header] = true
+				resolved.header = append(resolved.header, header)
+			}
+		}
+		resolved.envelope = append(resolved.envelope, entry.envelope...)
+	}
+	putMetric(opts, "invalid_recipients_dropped", float64(dropped), metrics.UnitCount, map[string]string{"field": field})
+
+	return resolved, nil
+}
+
+// allowedContentType returns the content type of the allowlist matching the given one, ignoring the case and the spaces, and tells if there is one.
+func allowedContentType(contentType string, allowed []string) (string, bool) {
+	for _, allowedType := range allowed {
+		if allowedType = strings.TrimSpace(allowedType); strings.EqualFold(strings.TrimSpace(contentType), allowedType) {
+			return allowedType, true
+		}
+	}
+
+	return "", false
+}
+
+// checkBodySource requires either a template or pre-rendered bodies. When a message has both, the pre-rendered bodies win,
+// unless strict JSON decoding is enabled, in which case the message is rejected as ambiguous.
+func checkBodySource(mailMsg *mailMessage, opts Options) error {
+	if !mailMsg.hasRawBody() {
+		if mailMsg.Template == "" {
+			return fmt.Errorf("message has no body, either template_name or html_body and text_body are required")
+		}
+		return nil
+	}
+	if mailMsg.Template == "" {
+		return nil
+	}
+
+	if opts.StrictJSON {
+		return fmt.Errorf("message is ambiguous, it has both template_name %q and pre-rendered bodies", mailMsg.Template)
+	}
+	log.Printf("Message has both template_name %q and pre-rendered bodies, sending the pre-rendered bodies", mailMsg.Template)
+
+	return nil
+}
+
+// isBareAddress tells if the value is a valid address, without display name nor angle brackets.
+func isBareAddress(value string) bool {
+	address, err := mail.ParseAddress(value)
+
+	return err == nil && address.Name == "" && address.Address == value
+}
+
+// overrideRecipients addresses the message to the override inbox only, ie: in a staging environment, keeping its original recipients in its
+// X-Original-To, X-Original-Cc and X-Original-Bcc headers.
+func overrideRecipients(mailMsg *mailMessage, opts Options) {
+	if opts.RecipientOverride == "" {
+		return
+	}
+
+	if mailMsg.Headers == nil {
+		mailMsg.Headers = make(map[string]string)
+	}
+	for _, field := range []struct {
+		header string
+		list   recipients
+	}{{"X-Original-To", mailMsg.to}, {"X-Original-Cc", mailMsg.cc}, {"X-Original-Bcc", mailMsg.bcc}} {
+		if len(field.list.header) > 0 {
+			mailMsg.Headers[field.header] = strings.Join(field.list.header, ", ")
+		}
+	}
+	mailMsg.to = recipients{header: []string{opts.RecipientOverride}, envelope: []string{opts.RecipientOverride}}
+	mailMsg.cc, mailMsg.bcc = recipients{}, recipients{}
+}
+
+// applySenderDefaults sets the default sender of the messages having none, and the default reply-to of those without one.
+// The default from name is only given to the default from address, as another sender has its own name.
+func applySenderDefaults(mailMsg *mailMessage, opts Options) {
+	if mailMsg.FromAddress == "" && opts.DefaultFromAddress != "" {
+		mailMsg.FromAddress = opts.DefaultFromAddress
+		if mailMsg.FromName == "" {
+			mailMsg.FromName = opts.DefaultFromName
+		}
+	}
+	if mailMsg.ReplyToAddress == "" {
+		mailMsg.ReplyToAddress = opts.DefaultReplyTo
+	}
+}
+
+// isAllowedSender tells if the domain of the from address is one of the allowed sender domains, every domain being allowed when there is none.
+func isAllowedSender(address string, opts Options) bool {
+	if len(opts.SenderDomains) == 0 {
+		return true
+	}
+
+	domain := domainOf(address)
+	for _, allowed := range opts.SenderDomains {
+		if strings.EqualFold(domain, allowed) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkSender requires a valid from address of an allowed domain and a subject, and a valid reply-to address when one is given,
+// normalizing the addresses as they are sent.
+func checkSender(mailMsg *mailMessage, opts Options) error {
+	if mailMsg.FromAddress == "" {
+		return fmt.Errorf("from_address is required")
+	}
+	if !isBareAddress(mailMsg.FromAddress) {
+		return fmt.Errorf("from_address %q is not a valid address", mailMsg.FromAddress)
+	}
+	if !isAllowedSender(mailMsg.FromAddress, opts) {
+		return fmt.Errorf("from_address %q is not of an allowed sender domain", mailMsg.FromAddress)
+	}
+	if mailMsg.ReturnPath != "" {
+		if err := ValidateReturnPath(mailMsg.ReturnPath); err != nil {
+			return fmt.Errorf("return_path %s", err.Error())
+		}
+		if !isAllowedSender(mailMsg.ReturnPath, opts) {
+			return fmt.Errorf("return_path %q is not of an allowed sender domain", mailMsg.ReturnPath)
+		}
+	}
+	if mailMsg.ReplyToAddress != "" && !isBareAddress(mailMsg.ReplyToAddress) {
+		return fmt.Errorf("reply_to %q is not a valid address", mailMsg.ReplyToAddress)
+	}
+	if strings.TrimSpace(mailMsg.Subject) == "" {
+		return fmt.Errorf("subject is required")
+	}
+
+	var err error
+	if mailMsg.FromAddress, err = normalizeAddress(mailMsg.FromAddress, opts); err != nil {
+		return fmt.Errorf("from_address %s", err.Error())
+	}
+	if mailMsg.ReplyToAddress, err = normalizeAddress(mailMsg.ReplyToAddress, opts); err != nil {
+		return fmt.Errorf("reply_to %s", err.Error())
+	}
+
+	return nil
+}
+
+// validateMailMessage checks the message sender, subject and recipients, expanding the mailing-list aliases and groups they contain, and its date.
+func validateMailMessage(mailMsg *mailMessage, opts Options) error {
+	applySenderDefaults(mailMsg, opts)
+	if err := checkSender(mailMsg, opts); err != nil {
+		return err
+	}
+
+	if len(mailMsg.FanOut) > 0 {
+		return fmt.Errorf("fan-out messages must be expanded with ExpandFanOut, sending one message per entry")
+	}
+	if _, ok := opts.Transports[mailMsg.Transport]; mailMsg.Transport != "" && !ok {
+		return fmt.Errorf("transport %q is not a configured transport profile", mailMsg.Transport)
+	}
+	if mailMsg.Variant != "" && !variantSuffix.MatchString(mailMsg.Variant) {
+		return fmt.Errorf("variant %q is invalid, expecting letters, digits and underscores", mailMsg.Variant)
+	}
+
+	var toAddresses []string
+	if mailMsg.ToAddress != "" {
+		toAddresses = []string{mailMsg.ToAddress}
+	}
+	toAddresses = append(toAddresses, mailMsg.To...)
+
+	var err error
+	if mailMsg.to, err = validateRecipients(toAddresses, opts); err != nil {
+		return fmt.Errorf("invalid to: %s", err.Error())
+	}
+	if mailMsg.cc, err = validateCopyRecipients("cc", mailMsg.CC, mailMsg, opts); err != nil {
+		return fmt.Errorf("invalid cc: %s", err.Error())
+	}
+	if mailMsg.bcc, err = validateCopyRecipients("bcc", mailMsg.BCC, mailMsg, opts); err != nil {
+		return fmt.Errorf("invalid bcc: %s", err.Error())
+	}
+
+	if len(mailMsg.envelopeRecipients()) == 0 {
+		return fmt.Errorf("message has no recipient, at least one to, to_address, cc or bcc is required")
+	}
+
+	if err := validateAttachments(mailMsg.Attachments, opts); err != nil {
+		return err
+	}
+	if err := validateAttachments(mailMsg.InlineImages, opts); err != nil {
+		return fmt.Errorf("invalid inline image: %s", err.Error())
+	}
+
+	if mailMsg.CalendarEvent != nil {
+		if err := validateCalendarEvent(mailMsg.CalendarEvent); err != nil {
+			return fmt.Errorf("invalid calendar_event: %s", err.Error())
+		}
+	}
+
+	if err := checkBodySource(mailMsg, opts); err != nil {
+		return err
+	}
+	if err := sanitizeContext(mailMsg, opts); err != nil {
+		return err
+	}
+
+	switch mailMsg.Category {
+	case "", CategoryTransactional:
+	case CategoryBulk:
+		if opts.Unsubscribe == nil {
+			return fmt.Errorf("category %q requires unsubscribe URLs, which are not configured", mailMsg.Category)
+		}
+	default:
+		return fmt.Errorf("category %q is unknown, expecting %s or %s", mailMsg.Category, CategoryTransactional, CategoryBulk)
+	}
+
+	if mailMsg.Locale != "" && !localePattern.MatchString(mailMsg.Locale) {
+		return fmt.Errorf("locale %q is invalid, expecting a language tag such as fr or fr-CA", mailMsg.Locale)
+	}
+
+	if mailMsg.Headers, err = validateHeaders(mailMsg.Headers); err != nil {
+		return fmt.Errorf("invalid headers: %s", err.Error())
+	}
+
+	if mailMsg.BodyContentType != "" {
+		contentType, ok := allowedContentType(mailMsg.BodyContentType, opts.BodyContentTypes)
+		if !ok {
+			return fmt.Errorf("body_content_type %q is not allowed, expecting one of %s", mailMsg.BodyContentType, strings.Join(opts.BodyContentTypes, ", "))
+		}
+		mailMsg.BodyContentType = contentType
+	}
+
+	if mailMsg.Date == "" {
+		mailMsg.date = opts.now()
+	} else if mailMsg.date, err = time.Parse(time.RFC3339, mailMsg.Date); err != nil {
+		return fmt.Errorf("invalid date, expecting an RFC 3339 date: %s", err.Error())
+	}
+	if mailMsg.SendAt != "" {
+		if _, err := time.Parse(time.RFC3339, mailMsg.SendAt); err != nil {
+			return fmt.Errorf("invalid send_at, expecting an RFC 3339 date: %s", err.Error())
+		}
+	}
+	if mailMsg.Timezone != "" {
+		if _, err := time.LoadLocation(mailMsg.Timezone); err != nil {
+			return fmt.Errorf("invalid timezone, expecting an IANA time zone name: %s", err.Error())
+		}
+	}
+
+	return nil
+}
+
+// localePattern matches the language tags accepted as locale, which are part of the template names.
+var localePattern = regexp.MustCompile(`^[A-Za-z]{2,3}([-_][A-Za-z0-9]{2,8})*$`)
+
+// embeddedAddress matches the email addresses written in a display name.
+var embeddedAddress = regexp.MustCompile(`[^\s@<>"'(),;:\[\]]+@[^\s@<>"'(),;:\[\]]+\.[^\s@<>"'(),;:\[\]]+`)
+
+// checkFromName rejects a from name containing an address other than the from address, ie: "paypal@paypal.com" <scam@example.com>, when enabled.
+// The name is NFKC normalized first, so an address written with look-alike characters, ie: the fullwidth "＠", is found too.
+func checkFromName(mailMsg *mailMessage, opts Options) error {
+	if !opts.RejectSpoofyFrom {
+		return nil
+	}
+
+	for _, address := range embeddedAddress.FindAllString(norm.NFKC.String(mailMsg.FromName), -1) {
+		// A sentence may end with the address.
+		address = strings.TrimRight(address, ".")
+		if !strings.EqualFold(address, mailMsg.FromAddress) {
+			return fmt.Errorf("from name %q contains the address %s, which is not the from address %s", mailMsg.FromName, address, mailMsg.FromAddress)
+		}
+	}
+
+	return nil
+}
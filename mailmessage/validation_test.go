@@ -0,0 +1,252 @@
+package mailmessage
+
+import (
+	"context"
+	"github.com/forsam-education/hermes/transport"
+	"strings"
+	"testing"
+)
+
+func TestAliasesExpand(t *testing.T) {
+	aliases := Aliases{
+		"team:support": {"alice@example.com", "bob@example.com"},
+		"team:billing": {"bob@example.com", "carol@example.com"},
+		"team:empty":   {},
+	}
+	tests := []struct {
+		name     string
+		list     []string
+		expected []string
+		err      string
+	}{
+		{name: "keeps the addresses", list: []string{"jane@example.org", "Jim <jim@example.org>"}, expected: []string{"jane@example.org", "Jim <jim@example.org>"}},
+		{name: "expands an alias", list: []string{"team:support"}, expected: []string{"alice@example.com", "bob@example.com"}},
+		{name: "expands an alias among addresses", list: []string{"jane@example.org", "team:support"}, expected: []string{"jane@example.org", "alice@example.com", "bob@example.com"}},
+		{name: "deduplicates overlapping aliases", list: []string{"team:support", "team:billing"}, expected: []string{"alice@example.com", "bob@example.com", "carol@example.com"}},
+		{name: "deduplicates an address also in an alias", list: []string{"bob@example.com", "team:support"}, expected: []string{"bob@example.com", "alice@example.com"}},
+		{name: "expands an empty alias", list: []string{"team:empty", "jane@example.org"}, expected: []string{"jane@example.org"}},
+		{name: "keeps the groups", list: []string{"Team: a@example.com, b@example.com;"}, expected: []string{"Team: a@example.com, b@example.com;"}},
+		{name: "rejects an unknown alias", list: []string{"jane@example.org", "team:sales"}, err: `unknown mailing-list alias "team:sales"`},
+		{name: "rejects an alias of another case", list: []string{"Team:Support"}, err: `unknown mailing-list alias "Team:Support"`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			expanded, err := aliases.expand(test.list)
+			if test.err != "" {
+				if err == nil || err.Error() != test.err {
+					t.Fatalf("expected error %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+			if strings.Join(expanded, "|") != strings.Join(test.expected, "|") {
+				t.Errorf("expected %v, got %v", test.expected, expanded)
+			}
+		})
+	}
+}
+
+func TestAliasesUnmarshalText(t *testing.T) {
+	var aliases Aliases
+	if err := aliases.UnmarshalText([]byte(`{"team:support": ["alice@example.com", "bob@example.com"]}`)); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if len(aliases["team:support"]) != 2 {
+		t.Errorf("expected the alias to be decoded, got %v", aliases)
+	}
+	if err := aliases.UnmarshalText([]byte(`["team:support"]`)); err == nil {
+		t.Errorf("expected a list to be rejected")
+	}
+}
+
+func TestValidateMailMessageExpandsAliases(t *testing.T) {
+	opts := Options{Aliases: Aliases{"team:support": {"alice@example.com", "bob@example.com"}}}
+	tests := []struct {
+		name     string
+		fields   map[string]interface{}
+		to       []string
+		cc       []string
+		bcc      []string
+		envelope []string
+		err      string
+	}{
+		{
+			name:     "expands the aliases of every field",
+			fields:   map[string]interface{}{"to": []string{"team:support"}, "cc": []string{"jane@example.org"}, "bcc": []string{"team:support"}},
+			to:       []string{"alice@example.com", "bob@example.com"},
+			cc:       []string{"jane@example.org"},
+			bcc:      []string{"alice@example.com", "bob@example.com"},
+			envelope: []string{"alice@example.com", "bob@example.com", "jane@example.org"},
+		},
+		{
+			name:   "fails the message on an unknown alias in to",
+			fields: map[string]interface{}{"to": []string{"team:sales"}},
+			err:    `invalid to: unknown mailing-list alias "team:sales"`,
+		},
+		{
+			name:   "fails the message on an unknown alias in bcc",
+			fields: map[string]interface{}{"bcc": []string{"team:sales"}},
+			err:    `invalid bcc: unknown mailing-list alias "team:sales"`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mailMsg, err := decodeMailMessage(testMessageBody(test.fields), opts)
+			if err != nil {
+				t.Fatalf("unable to decode: %s", err.Error())
+			}
+			err = validateMailMessage(mailMsg, opts)
+			if test.err != "" {
+				if err == nil || err.Error() != test.err {
+					t.Fatalf("expected error %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+			for _, field := range []struct {
+				name     string
+				got      []string
+				expected []string
+			}{{"to", mailMsg.to.envelope, test.to}, {"cc", mailMsg.cc.envelope, test.cc}, {"bcc", mailMsg.bcc.envelope, test.bcc}, {"envelope", mailMsg.envelopeRecipients(), test.envelope}} {
+				if strings.Join(field.got, ",") != strings.Join(field.expected, ",") {
+					t.Errorf("expected %s %v, got %v", field.name, field.expected, field.got)
+				}
+			}
+		})
+	}
+}
+
+func TestResolveRecipientsGroups(t *testing.T) {
+	tests := []struct {
+		name     string
+		list     []string
+		header   []string
+		envelope []string
+		err      string
+	}{
+		{
+			name:     "keeps the group syntax in the header",
+			list:     []string{"Team: alice@example.com, Bob <bob@example.com>;"},
+			header:   []string{`Team: alice@example.com, "Bob" <bob@example.com>;`},
+			envelope: []string{"alice@example.com", "bob@example.com"},
+		},
+		{
+			name:     "mixes groups and addresses",
+			list:     []string{"jane@example.org", "Team:alice@example.com;"},
+			header:   []string{"jane@example.org", "Team: alice@example.com;"},
+			envelope: []string{"jane@example.org", "alice@example.com"},
+		},
+		{
+			name:   "accepts an empty group",
+			list:   []string{"undisclosed-recipients:;"},
+			header: []string{"undisclosed-recipients: ;"},
+		},
+		{
+			name:     "encodes a non-ASCII group name",
+			list:     []string{"Équipe: alice@example.com;"},
+			header:   []string{"=?UTF-8?q?=C3=89quipe?=: alice@example.com;"},
+			envelope: []string{"alice@example.com"},
+		},
+		{name: "rejects a group without name", list: []string{": alice@example.com;"}, err: `group ": alice@example.com;" has no name`},
+		{name: "rejects an invalid member", list: []string{"Team: alice@example.com, bob;"}, err: `group "Team" has invalid members`},
+		{name: "rejects an invalid address", list: []string{"not an address"}, err: `invalid address "not an address"`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resolved, err := resolveRecipients(test.list, Options{})
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected error %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+			if strings.Join(resolved.header, "|") != strings.Join(test.header, "|") {
+				t.Errorf("expected header %q, got %q", test.header, resolved.header)
+			}
+			if strings.Join(resolved.envelope, "|") != strings.Join(test.envelope, "|") {
+				t.Errorf("expected envelope %v, got %v", test.envelope, resolved.envelope)
+			}
+		})
+	}
+}
+
+func TestSendMailGroupRecipients(t *testing.T) {
+	messages := sendTestMessage(t, newTestTemplates(), Options{}, testMessageBody(map[string]interface{}{
+		"to": []string{"Team: alice@example.com, bob@example.com;"},
+		"cc": []string{"Managers: carol@example.com;", "jane@example.org"},
+	}))
+
+	expected := []string{"alice@example.com", "bob@example.com", "carol@example.com", "jane@example.org"}
+	if strings.Join(messages[0].Recipients, ",") != strings.Join(expected, ",") {
+		t.Errorf("expected the group members to be recipients, got %v", messages[0].Recipients)
+	}
+	header, _ := parseTestMessage(t, messages[0])
+	if to := header.Get("To"); to != "Team: alice@example.com, bob@example.com;" {
+		t.Errorf("expected the group syntax in the To header, got %q", to)
+	}
+	if cc := header.Get("Cc"); cc != "Managers: carol@example.com;, jane@example.org" {
+		t.Errorf("expected the group syntax in the Cc header, got %q", cc)
+	}
+	if addresses, err := header.AddressList("Cc"); err != nil || len(addresses) != 2 {
+		t.Errorf("expected the Cc header to parse as an address list, got %v, %v", addresses, err)
+	}
+}
+
+func TestCheckFromName(t *testing.T) {
+	tests := []struct {
+		name     string
+		fromName string
+		err      string
+	}{
+		{name: "accepts a plain name", fromName: "Forsam Support"},
+		{name: "accepts an empty name", fromName: ""},
+		{name: "accepts the from address", fromName: "Support (noreply@example.com)"},
+		{name: "accepts the from address in another case", fromName: "NoReply@Example.com"},
+		{name: "accepts the from address ending a sentence", fromName: "Write to noreply@example.com."},
+		{name: "accepts the from address between brackets", fromName: "Support [noreply@example.com]"},
+		{name: "accepts an at sign without address", fromName: "Team @ Forsam"},
+		{name: "rejects another address", fromName: "paypal@paypal.com", err: `from name "paypal@paypal.com" contains the address paypal@paypal.com, which is not the from address noreply@example.com`},
+		{name: "rejects another address in angle brackets", fromName: "PayPal <service@paypal.com>", err: "contains the address service@paypal.com"},
+		{name: "rejects another address after the from address", fromName: "noreply@example.com via billing@paypal.com", err: "contains the address billing@paypal.com"},
+		{name: "rejects another address of the same domain", fromName: "ceo@example.com", err: "contains the address ceo@example.com"},
+		{name: "rejects an address with a fullwidth at sign", fromName: "paypal＠paypal.com", err: "contains the address paypal@paypal.com"},
+		{name: "rejects an address with fullwidth letters", fromName: "ｓｅｒｖｉｃｅ@ｐａｙｐａｌ.ｃｏｍ", err: "contains the address service@paypal.com"},
+		{name: "rejects an address with a small at sign", fromName: "service﹫paypal.com", err: "contains the address service@paypal.com"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mailMsg := &mailMessage{FromAddress: "noreply@example.com", FromName: test.fromName}
+			err := checkFromName(mailMsg, Options{RejectSpoofyFrom: true})
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected error %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("unexpected error: %s", err.Error())
+			}
+		})
+	}
+}
+
+func TestSendMailRejectSpoofyFrom(t *testing.T) {
+	body := testMessageBody(map[string]interface{}{"from_name": "security@paypal.com"})
+
+	if _, err := SendMail(context.Background(), newTestTemplates(), newTestTemplates(), transport.NewFake(), Options{RejectSpoofyFrom: true}, body); err == nil || !strings.Contains(err.Error(), "contains the address security@paypal.com") {
+		t.Errorf("expected the spoofy from name to be rejected, got %v", err)
+	}
+	sendTestMessage(t, newTestTemplates(), Options{}, body)
+	sendTestMessage(t, newTestTemplates(), Options{RejectSpoofyFrom: true}, testMessageBody(map[string]interface{}{"from_name": "Forsam"}))
+}
@@ -0,0 +1,109 @@
+package mailmessage
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// variantSuffix matches the suffixes naming the variants of a template, ie: "short" for "welcome-short".
+var variantSuffix = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+// TemplateVariants maps the templates running an experiment to the weights of their variants, ie: {"welcome": {"": 50, "short": 50}}.
+// A variant is rendered from the template named after its suffix, ie: "welcome-short", the empty suffix being the template itself.
+type TemplateVariants map[string]map[string]int
+
+// UnmarshalText decodes the template variants from their JSON representation, so they can be read from an environment variable.
+func (templateVariants *TemplateVariants) UnmarshalText(text []byte) error {
+	return json.Unmarshal(text, (*map[string]map[string]int)(templateVariants))
+}
+
+// Validate checks the variants of every template have valid suffixes and positive weights.
+func (templateVariants TemplateVariants) Validate() error {
+	for template, weights := range templateVariants {
+		var total int
+		for suffix, weight := range weights {
+			if suffix != "" && !variantSuffix.MatchString(suffix) {
+				return fmt.Errorf("template %q has the invalid variant %q, expecting letters, digits and underscores", template, suffix)
+			}
+			if weight < 0 {
+				return fmt.Errorf("template %q has a negative weight for variant %q", template, suffix)
+			}
+			total += weight
+		}
+		if total == 0 {
+			return fmt.Errorf("template %q has no variant with a positive weight", template)
+		}
+	}
+
+	return nil
+}
+
+// pick deterministically selects the variant of the template for the recipient, hashing them so a recipient always receives the same variant.
+func (templateVariants TemplateVariants) pick(templateName string, recipient string) string {
+	weights := templateVariants[templateName]
+	suffixes := make([]string, 0, len(weights))
+	var total int
+	for suffix, weight := range weights {
+		suffixes = append(suffixes, suffix)
+		total += weight
+	}
+	if total <= 0 {
+		return ""
+	}
+	sort.Strings(suffixes)
+
+	hash := fnv.New32a()
+	hash.Write([]byte(templateName + "\x00" + strings.ToLower(recipient)))
+	point := int(hash.Sum32() % uint32(total))
+	for _, suffix := range suffixes {
+		if point < weights[suffix] {
+			return suffix
+		}
+		point -= weights[suffix]
+	}
+
+	return ""
+}
+
+// unversionedName returns the template name without its pinned version, ie: "welcome" for "welcome@v3".
+func unversionedName(templateName string) string {
+	if separator := strings.Index(templateName, "@"); separator >= 0 {
+		return templateName[:separator]
+	}
+
+	return templateName
+}
+
+// selectVariant returns the variant the message is sent with: its variant field if set, or else the variant picked for its main recipient
+// when its template has variants. The variants of a versioned template are configured for its unversioned name.
+func selectVariant(mailMsg *mailMessage, opts Options) string {
+	if mailMsg.Variant != "" || mailMsg.Template == "" {
+		return mailMsg.Variant
+	}
+
+	return opts.Variants.pick(unversionedName(mailMsg.Template), mailMsg.mainRecipient())
+}
+
+// variantTemplate returns the name of the template rendered for the variant, ie: "welcome-short", or "welcome-short@v3" for a versioned template.
+func variantTemplate(templateName string, variant string) string {
+	if variant == "" {
+		return templateName
+	}
+
+	name := unversionedName(templateName)
+	return name + "-" + variant + templateName[len(name):]
+}
+
+// DescribeVariant returns the variant a message body is sent with, for logs and metrics. It is empty when the message has no variant.
+func DescribeVariant(messageBody string, opts Options) string {
+	var mailMsg mailMessage
+	if err := json.Unmarshal([]byte(messageBody), &mailMsg); err != nil {
+		return ""
+	}
+
+	return selectVariant(&mailMsg, opts)
+}
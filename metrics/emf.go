@@ -0,0 +1,82 @@
+package metrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Units of the metric values.
+const (
+	UnitMilliseconds = "Milliseconds"
+	UnitCount        = "Count"
+)
+
+// EMF writes metrics as CloudWatch embedded metric format log lines, which CloudWatch Logs extracts from the lambda output.
+// A nil EMF writes nothing.
+type EMF struct {
+	namespace string
+	mu        sync.Mutex
+	writer    io.Writer
+}
+
+type emfMetric struct {
+	Name string `json:"Name"`
+	Unit string `json:"Unit"`
+}
+
+type emfDirective struct {
+	Namespace  string      `json:"Namespace"`
+	Dimensions [][]string  `json:"Dimensions"`
+	Metrics    []emfMetric `json:"Metrics"`
+}
+
+type emfMetadata struct {
+	Timestamp         int64          `json:"Timestamp"`
+	CloudWatchMetrics []emfDirective `json:"CloudWatchMetrics"`
+}
+
+// NewEMF instanciates an EMF writing the metrics of the namespace to the writer, usually the standard output.
+func NewEMF(namespace string, writer io.Writer) *EMF {
+	return &EMF{namespace: namespace, writer: writer}
+}
+
+// Put writes one metric value, with the given dimensions.
+func (emf *EMF) Put(name string, value float64, unit string, dimensions map[string]string) error {
+	if emf == nil {
+		return nil
+	}
+
+	dimensionNames := make([]string, 0, len(dimensions))
+	line := make(map[string]interface{}, len(dimensions)+2)
+	for dimension, dimensionValue := range dimensions {
+		dimensionNames = append(dimensionNames, dimension)
+		line[dimension] = dimensionValue
+	}
+	sort.Strings(dimensionNames)
+	line[name] = value
+	line["_aws"] = emfMetadata{
+		Timestamp: time.Now().UnixNano() / int64(time.Millisecond),
+		CloudWatchMetrics: []emfDirective{{
+			Namespace:  emf.namespace,
+			Dimensions: [][]string{dimensionNames},
+			Metrics:    []emfMetric{{Name: name, Unit: unit}},
+		}},
+	}
+
+	encoded, err := json.Marshal(line)
+	if err != nil {
+		return fmt.Errorf("unable to marshal metric %s: %s", name, err.Error())
+	}
+
+	emf.mu.Lock()
+	defer emf.mu.Unlock()
+	if _, err := emf.writer.Write(append(encoded, '\n')); err != nil {
+		return fmt.Errorf("unable to write metric %s: %s", name, err.Error())
+	}
+
+	return nil
+}
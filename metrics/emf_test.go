@@ -0,0 +1,56 @@
+package metrics
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestEMFPut(t *testing.T) {
+	tests := []struct {
+		name       string
+		dimensions map[string]string
+		expected   [][]string
+	}{
+		{name: "without dimensions", expected: [][]string{{}}},
+		{name: "sorts the dimensions", dimensions: map[string]string{"template": "welcome", "status": "sent"}, expected: [][]string{{"status", "template"}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var output bytes.Buffer
+			if err := NewEMF("Hermes", &output).Put("queue_latency", 1500, UnitMilliseconds, test.dimensions); err != nil {
+				t.Fatalf("unable to put metric: %s", err.Error())
+			}
+
+			var entry map[string]json.RawMessage
+			if err := json.Unmarshal(output.Bytes(), &entry); err != nil {
+				t.Fatalf("expected a JSON line, got %q", output.String())
+			}
+			if string(entry["queue_latency"]) != "1500" {
+				t.Errorf("expected the value 1500, got %s", entry["queue_latency"])
+			}
+			for dimension, value := range test.dimensions {
+				if string(entry[dimension]) != `"`+value+`"` {
+					t.Errorf("expected the dimension %s to be %q, got %s", dimension, value, entry[dimension])
+				}
+			}
+			var metadata emfMetadata
+			if err := json.Unmarshal(entry["_aws"], &metadata); err != nil {
+				t.Fatalf("expected the EMF metadata, got %s", entry["_aws"])
+			}
+			expected := []emfDirective{{Namespace: "Hermes", Dimensions: test.expected, Metrics: []emfMetric{{Name: "queue_latency", Unit: UnitMilliseconds}}}}
+			if metadata.Timestamp == 0 || !reflect.DeepEqual(metadata.CloudWatchMetrics, expected) {
+				t.Errorf("expected the directives %+v, got %+v", expected, metadata)
+			}
+		})
+	}
+}
+
+func TestNilEMF(t *testing.T) {
+	var emf *EMF
+	if err := emf.Put("queue_latency", 1, UnitMilliseconds, nil); err != nil {
+		t.Errorf("expected a nil EMF to write nothing, got %s", err.Error())
+	}
+}
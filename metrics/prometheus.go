@@ -0,0 +1,172 @@
+package metrics
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// latencyBuckets are the upper bounds, in seconds, of the latency histograms, from the render of a template to the lag of a backed up queue.
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 300, 900, 3600}
+
+// promSeries is the value of a metric for one set of labels: the total of a counter, or the bucket counts, sum and count of a histogram.
+type promSeries struct {
+	total   float64
+	buckets []uint64
+	count   uint64
+}
+
+// promMetric is a metric and its series, by labels.
+type promMetric struct {
+	histogram bool
+	series    map[string]*promSeries
+}
+
+// Prometheus keeps the metrics in memory to expose them in the Prometheus text format, ie: on the /metrics endpoint of the daemon mode.
+// Counts are exposed as <prefix>_<name>_total counters, and milliseconds as <prefix>_<name>_seconds histograms.
+// It implements the Recorder and http.Handler interfaces.
+type Prometheus struct {
+	prefix  string
+	mu      sync.Mutex
+	metrics map[string]*promMetric
+}
+
+// promLabels formats the dimensions as sorted Prometheus labels, ie: {result="sent",template="welcome"}.
+func promLabels(dimensions map[string]string) string {
+	if len(dimensions) == 0 {
+		return ""
+	}
+
+	names := make([]string, 0, len(dimensions))
+	for name := range dimensions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	labels := make([]string, 0, len(names))
+	for _, name := range names {
+		labels = append(labels, name+"="+strconv.Quote(dimensions[name]))
+	}
+
+	return "{" + strings.Join(labels, ",") + "}"
+}
+
+// withLabel returns the labels with one more label, ie: the le bound of histogram buckets.
+func withLabel(labels string, label string) string {
+	if labels == "" {
+		return "{" + label + "}"
+	}
+
+	return labels[:len(labels)-1] + "," + label + "}"
+}
+
+// formatValue formats a sample value as Prometheus does.
+func formatValue(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// Put adds a count to its counter, or observes a duration in its histogram. Other units are ignored, as they have no Prometheus type.
+func (prometheus *Prometheus) Put(name string, value float64, unit string, dimensions map[string]string) error {
+	if prometheus == nil {
+		return nil
+	}
+
+	var histogram bool
+	switch unit {
+	case UnitCount:
+		name = prometheus.prefix + "_" + name + "_total"
+	case UnitMilliseconds:
+		name = prometheus.prefix + "_" + name + "_seconds"
+		value /= 1000
+		histogram = true
+	default:
+		return nil
+	}
+
+	prometheus.mu.Lock()
+	defer prometheus.mu.Unlock()
+	metric, ok := prometheus.metrics[name]
+	if !ok {
+		metric = &promMetric{histogram: histogram, series: make(map[string]*promSeries)}
+		prometheus.metrics[name] = metric
+	}
+	if metric.histogram != histogram {
+		return fmt.Errorf("metric %s is already recorded with another unit", name)
+	}
+	labels := promLabels(dimensions)
+	series, ok := metric.series[labels]
+	if !ok {
+		series = &promSeries{}
+		if histogram {
+			series.buckets = make([]uint64, len(latencyBuckets))
+		}
+		metric.series[labels] = series
+	}
+
+	series.total += value
+	series.count++
+	for i := range series.buckets {
+		if value <= latencyBuckets[i] {
+			series.buckets[i]++
+		}
+	}
+
+	return nil
+}
+
+// Write returns the metrics in the Prometheus text exposition format, sorted by name and labels.
+func (prometheus *Prometheus) Write() string {
+	prometheus.mu.Lock()
+	defer prometheus.mu.Unlock()
+
+	names := make([]string, 0, len(prometheus.metrics))
+	for name := range prometheus.metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var exposition strings.Builder
+	for _, name := range names {
+		metric := prometheus.metrics[name]
+		labelSets := make([]string, 0, len(metric.series))
+		for labels := range metric.series {
+			labelSets = append(labelSets, labels)
+		}
+		sort.Strings(labelSets)
+
+		if !metric.histogram {
+			fmt.Fprintf(&exposition, "# TYPE %s counter\n", name)
+			for _, labels := range labelSets {
+				fmt.Fprintf(&exposition, "%s%s %s\n", name, labels, formatValue(metric.series[labels].total))
+			}
+			continue
+		}
+
+		fmt.Fprintf(&exposition, "# TYPE %s histogram\n", name)
+		for _, labels := range labelSets {
+			series := metric.series[labels]
+			for i, bound := range latencyBuckets {
+				fmt.Fprintf(&exposition, "%s_bucket%s %d\n", name, withLabel(labels, `le="`+formatValue(bound)+`"`), series.buckets[i])
+			}
+			fmt.Fprintf(&exposition, "%s_bucket%s %d\n", name, withLabel(labels, `le="+Inf"`), series.count)
+			fmt.Fprintf(&exposition, "%s_sum%s %s\n", name, labels, formatValue(series.total))
+			fmt.Fprintf(&exposition, "%s_count%s %d\n", name, labels, series.count)
+		}
+	}
+
+	return exposition.String()
+}
+
+// ServeHTTP writes the metrics for a Prometheus scrape.
+func (prometheus *Prometheus) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	// A failed write means the scraper went away, and has nothing to be told.
+	w.Write([]byte(prometheus.Write()))
+}
+
+// NewPrometheus instanciates a Prometheus registry whose metric names start with the prefix, ie: hermes.
+func NewPrometheus(prefix string) *Prometheus {
+	return &Prometheus{prefix: prefix, metrics: make(map[string]*promMetric)}
+}
@@ -0,0 +1,23 @@
+package metrics
+
+// Recorder interface should be implemented by any metrics backend (CloudWatch embedded metric format, Prometheus... etc).
+// The *EMF and *Prometheus types implement it.
+type Recorder interface {
+	// Put should record one metric value, with the given dimensions.
+	Put(name string, value float64, unit string, dimensions map[string]string) error
+}
+
+// Recorders puts each metric to several recorders. It implements the Recorder interface.
+type Recorders []Recorder
+
+// Put puts the metric to every recorder, returning the first failure once all of them were tried.
+func (recorders Recorders) Put(name string, value float64, unit string, dimensions map[string]string) error {
+	var firstErr error
+	for _, recorder := range recorders {
+		if err := recorder.Put(name, value, unit, dimensions); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
@@ -0,0 +1,92 @@
+package mjml
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// maxResponseBytes bounds the size of a compiled message, so a misbehaving endpoint can't exhaust the lambda memory.
+const maxResponseBytes = 10 << 20
+
+// Compiler interface should be implemented by any service able to compile MJML markup to responsive HTML (the MJML API, a self-hosted mjml server... etc).
+type Compiler interface {
+	// Compile should return the HTML of the MJML markup, failing when the markup is invalid.
+	Compile(markup string) (string, error)
+}
+
+// compileError is an error reported by the MJML compiler, with the line of the faulty tag.
+type compileError struct {
+	Line    int    `json:"line"`
+	Message string `json:"message"`
+	TagName string `json:"tagName"`
+}
+
+// API compiles MJML through the render endpoint of the MJML API, or of a self-hosted server exposing the same endpoint. It implements the Compiler interface.
+type API struct {
+	endpoint   string
+	appID      string
+	secretKey  string
+	httpClient *http.Client
+}
+
+// Compile posts the markup to the render endpoint, ie: {"mjml": "<mjml>...</mjml>"}, and returns the html of its response.
+// The markup errors reported by the endpoint make the compilation fail, as the resulting HTML may be broken.
+func (api *API) Compile(markup string) (string, error) {
+	payload, err := json.Marshal(struct {
+		MJML string `json:"mjml"`
+	}{markup})
+	if err != nil {
+		return "", fmt.Errorf("unable to marshal MJML: %s", err.Error())
+	}
+	request, err := http.NewRequest(http.MethodPost, api.endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return "", fmt.Errorf("unable to build MJML request: %s", err.Error())
+	}
+	request.Header.Set("Content-Type", "application/json")
+	if api.appID != "" {
+		request.SetBasicAuth(api.appID, api.secretKey)
+	}
+
+	response, err := api.httpClient.Do(request)
+	if err != nil {
+		return "", fmt.Errorf("unable to compile MJML with %q: %s", api.endpoint, err.Error())
+	}
+	defer response.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(response.Body, maxResponseBytes))
+	if err != nil {
+		return "", fmt.Errorf("unable to read compiled MJML: %s", err.Error())
+	}
+
+	var compiled struct {
+		HTML    string         `json:"html"`
+		Errors  []compileError `json:"errors"`
+		Message string         `json:"message"`
+	}
+	if err := json.Unmarshal(body, &compiled); err != nil && response.StatusCode < 300 {
+		return "", fmt.Errorf("unable to unmarshal compiled MJML: %s", err.Error())
+	}
+	if len(compiled.Errors) > 0 {
+		messages := make([]string, len(compiled.Errors))
+		for i, compileErr := range compiled.Errors {
+			messages[i] = fmt.Sprintf("line %d: %s", compileErr.Line, compileErr.Message)
+		}
+		return "", fmt.Errorf("invalid MJML: %s", strings.Join(messages, "; "))
+	}
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return "", fmt.Errorf("unable to compile MJML with %q: %s %s", api.endpoint, response.Status, compiled.Message)
+	}
+
+	return compiled.HTML, nil
+}
+
+// NewAPI instanciates an API compiling through the render endpoint, ie: https://api.mjml.io/v1/render, authenticated with the application id and
+// secret key when the id is not empty.
+func NewAPI(endpoint string, appID string, secretKey string, timeout time.Duration) *API {
+	return &API{endpoint: endpoint, appID: appID, secretKey: secretKey, httpClient: &http.Client{Timeout: timeout}}
+}
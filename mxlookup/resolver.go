@@ -0,0 +1,91 @@
+package mxlookup
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// cachedAnswer is the cached result of the lookup of a domain.
+type cachedAnswer struct {
+	deliverable bool
+	expires     time.Time
+}
+
+// Resolver tells if the domains of the recipients can receive emails, caching the answers. A nil Resolver accepts every domain.
+type Resolver struct {
+	client  *net.Resolver
+	timeout time.Duration
+	ttl     time.Duration
+	mu      sync.Mutex
+	answers map[string]cachedAnswer
+}
+
+// NewResolver instanciates a Resolver bounding each lookup by the timeout, and caching the answers for the ttl.
+func NewResolver(timeout time.Duration, ttl time.Duration) *Resolver {
+	return &Resolver{client: net.DefaultResolver, timeout: timeout, ttl: ttl, answers: make(map[string]cachedAnswer)}
+}
+
+// isNotFound tells if the lookup error reports a missing domain or record, rather than a failure to get an answer.
+func isNotFound(err error) bool {
+	dnsErr, ok := err.(*net.DNSError)
+
+	return ok && !dnsErr.IsTimeout && !dnsErr.IsTemporary
+}
+
+// lookup resolves the MX records of the domain, falling back on its address records as an implicit MX, as RFC 5321 requires.
+// A null MX, ie: "0 .", tells the domain accepts no email.
+func (resolver *Resolver) lookup(domain string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), resolver.timeout)
+	defer cancel()
+
+	records, err := resolver.client.LookupMX(ctx, domain)
+	if err == nil && len(records) > 0 {
+		for _, record := range records {
+			if record.Host != "." && record.Host != "" {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	if err != nil && !isNotFound(err) {
+		return false, err
+	}
+
+	if _, err := resolver.client.LookupHost(ctx, domain); err != nil {
+		if isNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Deliverable tells if the domain has a mail server. Timeouts and server failures are returned as errors and are not cached,
+// so the caller can accept the address rather than rejecting it on a transient failure.
+func (resolver *Resolver) Deliverable(domain string) (bool, error) {
+	if resolver == nil {
+		return true, nil
+	}
+
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	resolver.mu.Lock()
+	answer, ok := resolver.answers[domain]
+	resolver.mu.Unlock()
+	if ok && time.Now().Before(answer.expires) {
+		return answer.deliverable, nil
+	}
+
+	deliverable, err := resolver.lookup(domain)
+	if err != nil {
+		return false, err
+	}
+	resolver.mu.Lock()
+	resolver.answers[domain] = cachedAnswer{deliverable: deliverable, expires: time.Now().Add(resolver.ttl)}
+	resolver.mu.Unlock()
+
+	return deliverable, nil
+}
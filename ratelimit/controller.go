@@ -0,0 +1,155 @@
+package ratelimit
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Semaphore bounds how many sends run concurrently. A nil Semaphore is unbounded.
+type Semaphore struct {
+	slots chan struct{}
+}
+
+// NewSemaphore instanciates a Semaphore allowing size concurrent holders, or returns nil when size is not positive.
+func NewSemaphore(size int) *Semaphore {
+	if size <= 0 {
+		return nil
+	}
+
+	return &Semaphore{slots: make(chan struct{}, size)}
+}
+
+// Acquire blocks until a slot is free, or returns an error if none is freed before the deadline.
+func (semaphore *Semaphore) Acquire(deadline time.Time) error {
+	if semaphore == nil {
+		return nil
+	}
+
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+	select {
+	case semaphore.slots <- struct{}{}:
+		return nil
+	case <-timer.C:
+		return fmt.Errorf("all %d slots are still busy at %s", cap(semaphore.slots), deadline.Format(time.RFC3339))
+	}
+}
+
+// Release frees a slot taken by Acquire.
+func (semaphore *Semaphore) Release() {
+	if semaphore != nil {
+		<-semaphore.slots
+	}
+}
+
+// Controller combines every sending limit, so they are honored together by a single call before each send.
+// The limits are taken in a fixed order: a worker slot, a slot of each recipient domain, the template rate, the rate of each recipient domain
+// and last the provider rate, so a message never consumes a rate token while still waiting for a slot.
+type Controller struct {
+	workers           *Semaphore
+	domainConcurrency int
+	templates         *Group
+	domainRates       *Group
+	provider          *Limiter
+
+	mu      sync.Mutex
+	domains map[string]*Semaphore
+}
+
+// NewController instanciates a Controller. Non positive workers, domainConcurrency or providerRate disable the matching limit,
+// and the templates or domains without rate are not throttled. The provider rate allows bursts of up to providerBurst sends, ie: after the workers were idle.
+func NewController(workers int, domainConcurrency int, templateRates Rates, domainRates Rates, providerRate float64, providerBurst int) *Controller {
+	controller := &Controller{
+		workers:           NewSemaphore(workers),
+		domainConcurrency: domainConcurrency,
+		templates:         NewGroup(templateRates),
+		domainRates:       NewGroup(domainRates),
+		domains:           make(map[string]*Semaphore),
+	}
+	if providerRate > 0 {
+		controller.provider = NewLimiter(providerRate, providerBurst)
+	}
+
+	return controller
+}
+
+func (controller *Controller) domain(name string) *Semaphore {
+	controller.mu.Lock()
+	defer controller.mu.Unlock()
+
+	semaphore, ok := controller.domains[name]
+	if !ok {
+		semaphore = NewSemaphore(controller.domainConcurrency)
+		controller.domains[name] = semaphore
+	}
+
+	return semaphore
+}
+
+// Acquire blocks until the message of the template, delivered to the given recipient domains, may be sent.
+// It returns the function releasing the taken slots once the message is sent, or an error if the limits can't be honored before the deadline.
+// A nil Controller never blocks.
+func (controller *Controller) Acquire(template string, domains []string, deadline time.Time) (func(), error) {
+	var taken []*Semaphore
+	release := func() {
+		for _, semaphore := range taken {
+			semaphore.Release()
+		}
+	}
+	if controller == nil {
+		return release, nil
+	}
+
+	if err := controller.workers.Acquire(deadline); err != nil {
+		return nil, fmt.Errorf("no sending worker is available: %s", err.Error())
+	}
+	taken = append(taken, controller.workers)
+
+	// Domain slots are taken in a sorted order, so concurrent messages can't deadlock each other.
+	if controller.domainConcurrency > 0 {
+		for _, name := range uniqueDomains(domains) {
+			semaphore := controller.domain(name)
+			if err := semaphore.Acquire(deadline); err != nil {
+				release()
+				return nil, fmt.Errorf("domain %s is busy: %s", name, err.Error())
+			}
+			taken = append(taken, semaphore)
+		}
+	}
+
+	if err := controller.templates.Wait(template, deadline); err != nil {
+		release()
+		return nil, fmt.Errorf("template %s", err.Error())
+	}
+	for _, name := range uniqueDomains(domains) {
+		if err := controller.domainRates.Wait(name, deadline); err != nil {
+			release()
+			return nil, fmt.Errorf("domain %s", err.Error())
+		}
+	}
+	if err := controller.provider.Wait(deadline); err != nil {
+		release()
+		return nil, fmt.Errorf("provider is throttled: %s", err.Error())
+	}
+
+	return release, nil
+}
+
+// uniqueDomains returns the lowercased domains, sorted and without duplicates.
+func uniqueDomains(domains []string) []string {
+	seen := make(map[string]bool, len(domains))
+	unique := make([]string, 0, len(domains))
+	for _, name := range domains {
+		name = strings.ToLower(name)
+		if !seen[name] {
+			seen[name] = true
+			unique = append(unique, name)
+		}
+	}
+	sort.Strings(unique)
+
+	return unique
+}
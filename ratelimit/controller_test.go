@@ -0,0 +1,251 @@
+package ratelimit
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestControllerAcquire(t *testing.T) {
+	tests := []struct {
+		name       string
+		controller func() *Controller
+		messages   []string
+		allowed    int
+		err        string
+	}{
+		{
+			name:       "throttles a template on top of the provider rate",
+			controller: func() *Controller { return NewController(0, 0, Rates{"newsletter": 1}, nil, 100, 10) },
+			messages:   []string{"newsletter", "welcome", "newsletter", "welcome"},
+			allowed:    3,
+			err:        `template "newsletter" is throttled`,
+		},
+		{
+			name:       "throttles the provider across templates",
+			controller: func() *Controller { return NewController(0, 0, Rates{"newsletter": 100}, nil, 1, 2) },
+			messages:   []string{"newsletter", "welcome", "newsletter"},
+			allowed:    2,
+			err:        "provider is throttled",
+		},
+		{
+			name:       "throttles a domain",
+			controller: func() *Controller { return NewController(0, 0, nil, Rates{"example.org": 1}, 0, 0) },
+			messages:   []string{"welcome", "welcome"},
+			allowed:    1,
+			err:        `domain "example.org" is throttled`,
+		},
+		{
+			name:       "does not throttle without limits",
+			controller: func() *Controller { return NewController(0, 0, nil, nil, 0, 0) },
+			messages:   []string{"welcome", "welcome", "welcome"},
+			allowed:    3,
+		},
+		{
+			name:       "does not throttle with a nil controller",
+			controller: func() *Controller { return nil },
+			messages:   []string{"welcome", "welcome"},
+			allowed:    2,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			controller := test.controller()
+			deadline := time.Now().Add(100 * time.Millisecond)
+			var allowed int
+			for _, template := range test.messages {
+				release, err := controller.Acquire(template, []string{"Example.org"}, deadline)
+				if err != nil {
+					if test.err == "" || !strings.Contains(err.Error(), test.err) {
+						t.Errorf("expected error %q, got %s", test.err, err.Error())
+					}
+					continue
+				}
+				allowed++
+				release()
+			}
+			if allowed != test.allowed {
+				t.Errorf("expected %d allowed messages, got %d", test.allowed, allowed)
+			}
+		})
+	}
+}
+
+func TestControllerReleasesSlotsOfThrottledMessages(t *testing.T) {
+	controller := NewController(1, 1, Rates{"newsletter": 1}, nil, 0, 0)
+	deadline := time.Now().Add(50 * time.Millisecond)
+
+	release, err := controller.Acquire("newsletter", []string{"example.org"}, deadline)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	release()
+	if _, err := controller.Acquire("newsletter", []string{"example.org"}, deadline); err == nil {
+		t.Fatalf("expected the second newsletter to be throttled")
+	}
+	// The throttled message released its worker and domain slots, so other templates are still sent.
+	release, err = controller.Acquire("welcome", []string{"example.org"}, deadline)
+	if err != nil {
+		t.Fatalf("expected the slots of the throttled message to be released, got %s", err.Error())
+	}
+	release()
+}
+
+func TestControllerBoundsConcurrency(t *testing.T) {
+	controller := NewController(2, 0, nil, nil, 0, 0)
+	var mu sync.Mutex
+	var running, maxRunning int
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			release, err := controller.Acquire("welcome", nil, time.Now().Add(time.Second))
+			if err != nil {
+				t.Errorf("unexpected error: %s", err.Error())
+				return
+			}
+			mu.Lock()
+			running++
+			if running > maxRunning {
+				maxRunning = running
+			}
+			mu.Unlock()
+			time.Sleep(5 * time.Millisecond)
+			mu.Lock()
+			running--
+			mu.Unlock()
+			release()
+		}()
+	}
+	wg.Wait()
+	if maxRunning > 2 {
+		t.Errorf("expected at most 2 concurrent sends, got %d", maxRunning)
+	}
+}
+
+func TestControllerCombinesDomainAndWorkerSlots(t *testing.T) {
+	controller := NewController(3, 1, nil, nil, 0, 0)
+	var mu sync.Mutex
+	running := make(map[string]int)
+	maxRunning := make(map[string]int)
+	var total, maxTotal int
+	var wg sync.WaitGroup
+	for i := 0; i < 12; i++ {
+		domain := []string{"example.org", "example.com", "example.net", "example.io"}[i%4]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			release, err := controller.Acquire("welcome", []string{domain}, time.Now().Add(2*time.Second))
+			if err != nil {
+				t.Errorf("unexpected error: %s", err.Error())
+				return
+			}
+			mu.Lock()
+			running[domain]++
+			total++
+			if running[domain] > maxRunning[domain] {
+				maxRunning[domain] = running[domain]
+			}
+			if total > maxTotal {
+				maxTotal = total
+			}
+			mu.Unlock()
+			time.Sleep(5 * time.Millisecond)
+			mu.Lock()
+			running[domain]--
+			total--
+			mu.Unlock()
+			release()
+		}()
+	}
+	wg.Wait()
+
+	// Each domain gets a single slot, while the worker slots are shared by the messages of every domain.
+	for domain, max := range maxRunning {
+		if max > 1 {
+			t.Errorf("expected at most 1 concurrent send to %s, got %d", domain, max)
+		}
+	}
+	if maxTotal > 3 {
+		t.Errorf("expected at most 3 concurrent sends, got %d", maxTotal)
+	}
+}
+
+func TestControllerTakesDomainSlotsWithoutDeadlock(t *testing.T) {
+	controller := NewController(0, 1, nil, nil, 0, 0)
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		domains := []string{"example.org", "EXAMPLE.COM"}
+		if i%2 == 1 {
+			domains = []string{"example.com", "example.org", "example.com"}
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			release, err := controller.Acquire("welcome", domains, time.Now().Add(2*time.Second))
+			if err != nil {
+				t.Errorf("expected messages to opposite domain orders to be sent in turn, got %s", err.Error())
+				return
+			}
+			time.Sleep(time.Millisecond)
+			release()
+		}()
+	}
+	wg.Wait()
+}
+
+func TestControllerWaitsForSlotsBeforeRateTokens(t *testing.T) {
+	controller := NewController(1, 0, Rates{"newsletter": 0.1}, nil, 0, 0)
+
+	release, err := controller.Acquire("welcome", nil, time.Now().Add(50*time.Millisecond))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	_, err = controller.Acquire("newsletter", nil, time.Now().Add(20*time.Millisecond))
+	if err == nil || !strings.Contains(err.Error(), "no sending worker is available") {
+		t.Fatalf("expected the newsletter to wait for a worker, got %v", err)
+	}
+	release()
+
+	// The newsletter which timed out waiting for a worker did not consume the only token of its template.
+	release, err = controller.Acquire("newsletter", nil, time.Now().Add(20*time.Millisecond))
+	if err != nil {
+		t.Fatalf("expected the newsletter token to be left, got %s", err.Error())
+	}
+	release()
+}
+
+func TestControllerDeadline(t *testing.T) {
+	tests := []struct {
+		name       string
+		controller *Controller
+		err        string
+	}{
+		{name: "worker slot", controller: NewController(1, 0, nil, nil, 0, 0), err: "no sending worker is available: all 1 slots are still busy"},
+		{name: "domain slot", controller: NewController(0, 1, nil, nil, 0, 0), err: "domain example.org is busy: all 1 slots are still busy"},
+		{name: "template rate", controller: NewController(0, 0, Rates{"welcome": 0.1}, nil, 0, 0), err: `template "welcome" is throttled`},
+		{name: "provider rate", controller: NewController(0, 0, nil, nil, 0.1, 1), err: "provider is throttled"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			release, err := test.controller.Acquire("welcome", []string{"example.org"}, time.Now().Add(time.Second))
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+			defer release()
+
+			start := time.Now()
+			_, err = test.controller.Acquire("welcome", []string{"example.org"}, start.Add(30*time.Millisecond))
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Fatalf("expected error %q, got %v", test.err, err)
+			}
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Errorf("expected the limit to give up by its deadline, took %s", elapsed)
+			}
+		})
+	}
+}
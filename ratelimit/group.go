@@ -0,0 +1,58 @@
+package ratelimit
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Rates maps keys, ie: template names, to their rate limit in sends per second.
+type Rates map[string]float64
+
+// UnmarshalText decodes rates from their JSON representation, so they can be read from an environment variable.
+func (rates *Rates) UnmarshalText(text []byte) error {
+	return json.Unmarshal(text, (*map[string]float64)(rates))
+}
+
+// Group holds one Limiter per key, created on first use from the configured rates.
+type Group struct {
+	mu       sync.Mutex
+	rates    Rates
+	limiters map[string]*Limiter
+}
+
+// NewGroup instanciates a Group limiting each key to its configured rate. Keys without rate are not limited.
+func NewGroup(rates Rates) *Group {
+	return &Group{rates: rates, limiters: make(map[string]*Limiter)}
+}
+
+func (group *Group) limiter(key string) *Limiter {
+	group.mu.Lock()
+	defer group.mu.Unlock()
+
+	if limiter, ok := group.limiters[key]; ok {
+		return limiter
+	}
+	rate, ok := group.rates[key]
+	if !ok {
+		return nil
+	}
+	limiter := NewLimiter(rate, 1)
+	group.limiters[key] = limiter
+
+	return limiter
+}
+
+// Wait blocks until a send is allowed for the key, or returns an error if it would not be allowed before the deadline.
+// A nil Group never blocks.
+func (group *Group) Wait(key string, deadline time.Time) error {
+	if group == nil {
+		return nil
+	}
+	if err := group.limiter(key).Wait(deadline); err != nil {
+		return fmt.Errorf("%q is throttled: %s", key, err.Error())
+	}
+
+	return nil
+}
@@ -0,0 +1,69 @@
+package ratelimit
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGroupThrottlesEachTemplate(t *testing.T) {
+	rates := Rates{"newsletter": 1, "digest": 20}
+	tests := []struct {
+		name     string
+		template string
+		sends    int
+		allowed  int
+	}{
+		{name: "throttles a slow template", template: "newsletter", sends: 3, allowed: 1},
+		{name: "throttles a faster template less", template: "digest", sends: 3, allowed: 3},
+		{name: "does not throttle a template without rate", template: "password_reset", sends: 50, allowed: 50},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			group := NewGroup(rates)
+			deadline := time.Now().Add(200 * time.Millisecond)
+			var allowed int
+			for i := 0; i < test.sends; i++ {
+				err := group.Wait(test.template, deadline)
+				if err == nil {
+					allowed++
+					continue
+				}
+				if !strings.Contains(err.Error(), `"`+test.template+`" is throttled`) {
+					t.Errorf("expected the error to name the template, got %s", err.Error())
+				}
+			}
+			if allowed != test.allowed {
+				t.Errorf("expected %d allowed sends, got %d", test.allowed, allowed)
+			}
+		})
+	}
+}
+
+func TestGroupKeepsTemplatesApart(t *testing.T) {
+	group := NewGroup(Rates{"newsletter": 1, "welcome": 1})
+	deadline := time.Now().Add(10 * time.Millisecond)
+	if err := group.Wait("newsletter", deadline); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if err := group.Wait("newsletter", deadline); err == nil {
+		t.Errorf("expected the second newsletter to be throttled")
+	}
+	if err := group.Wait("welcome", deadline); err != nil {
+		t.Errorf("expected a throttled newsletter not to throttle welcome, got %s", err.Error())
+	}
+}
+
+func TestRatesUnmarshalText(t *testing.T) {
+	var rates Rates
+	if err := rates.UnmarshalText([]byte(`{"newsletter": 0.5, "welcome": 10}`)); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if rates["newsletter"] != 0.5 || rates["welcome"] != 10 {
+		t.Errorf("expected the rates to be decoded, got %v", rates)
+	}
+	if err := rates.UnmarshalText([]byte(`{"newsletter": "fast"}`)); err == nil {
+		t.Errorf("expected a rate which is not a number to be rejected")
+	}
+}
@@ -0,0 +1,65 @@
+package ratelimit
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Limiter is a token bucket shared by concurrent senders, refilled at a fixed rate up to its burst size.
+type Limiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewLimiter instanciates a full Limiter allowing rate sends per second, with bursts of up to burst sends.
+func NewLimiter(rate float64, burst int) *Limiter {
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &Limiter{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// reserve takes a token and returns how long to wait before using it, or reports it can't be used before the deadline.
+func (limiter *Limiter) reserve(deadline time.Time) (time.Duration, bool) {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+
+	now := time.Now()
+	limiter.tokens += now.Sub(limiter.last).Seconds() * limiter.rate
+	if limiter.tokens > limiter.burst {
+		limiter.tokens = limiter.burst
+	}
+	limiter.last = now
+
+	var delay time.Duration
+	if limiter.tokens < 1 {
+		delay = time.Duration((1 - limiter.tokens) / limiter.rate * float64(time.Second))
+	}
+	if now.Add(delay).After(deadline) {
+		return 0, false
+	}
+	limiter.tokens--
+
+	return delay, true
+}
+
+// Wait blocks until a send is allowed, or returns an error without waiting if it would not be allowed before the deadline.
+// A nil Limiter never blocks.
+func (limiter *Limiter) Wait(deadline time.Time) error {
+	if limiter == nil || limiter.rate <= 0 {
+		return nil
+	}
+
+	delay, ok := limiter.reserve(deadline)
+	if !ok {
+		return fmt.Errorf("rate limit of %g sends per second can't be honored before %s", limiter.rate, deadline.Format(time.RFC3339))
+	}
+	time.Sleep(delay)
+
+	return nil
+}
@@ -0,0 +1,97 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLimiterWait(t *testing.T) {
+	tests := []struct {
+		name     string
+		rate     float64
+		burst    int
+		sends    int
+		maxWait  time.Duration
+		allowed  int
+		minDelay time.Duration
+	}{
+		{name: "allows a burst at once", rate: 10, burst: 3, sends: 3, maxWait: time.Second, allowed: 3},
+		{name: "spaces the sends above the burst", rate: 20, burst: 1, sends: 3, maxWait: time.Second, allowed: 3, minDelay: 90 * time.Millisecond},
+		{name: "fails the sends which can't be honored before the deadline", rate: 1, burst: 2, sends: 4, maxWait: 100 * time.Millisecond, allowed: 2},
+		{name: "does not limit without rate", rate: 0, burst: 1, sends: 100, maxWait: 0, allowed: 100},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			limiter := NewLimiter(test.rate, test.burst)
+			start := time.Now()
+			deadline := start.Add(test.maxWait)
+			var allowed int
+			for i := 0; i < test.sends; i++ {
+				if err := limiter.Wait(deadline); err == nil {
+					allowed++
+				}
+			}
+			if allowed != test.allowed {
+				t.Errorf("expected %d allowed sends, got %d", test.allowed, allowed)
+			}
+			if elapsed := time.Since(start); elapsed < test.minDelay || elapsed > test.maxWait+50*time.Millisecond {
+				t.Errorf("expected the sends to take between %s and %s, got %s", test.minDelay, test.maxWait, elapsed)
+			}
+		})
+	}
+}
+
+func TestLimiterFailsWithoutWaiting(t *testing.T) {
+	limiter := NewLimiter(0.1, 1)
+	_ = limiter.Wait(time.Now().Add(time.Minute))
+
+	start := time.Now()
+	if err := limiter.Wait(start.Add(time.Second)); err == nil {
+		t.Fatalf("expected the send to be throttled")
+	}
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Errorf("expected a throttled send to fail at once, waited %s", elapsed)
+	}
+	// The throttled send took no token, so the next one is allowed as soon as the bucket is refilled.
+	if limiter.tokens < -0.01 {
+		t.Errorf("expected a throttled send not to take a token, got %f tokens", limiter.tokens)
+	}
+}
+
+func TestNilLimiter(t *testing.T) {
+	var limiter *Limiter
+	if err := limiter.Wait(time.Now()); err != nil {
+		t.Errorf("expected a nil limiter not to block, got %s", err.Error())
+	}
+}
+
+func TestSemaphore(t *testing.T) {
+	semaphore := NewSemaphore(2)
+	deadline := time.Now().Add(50 * time.Millisecond)
+	for i := 0; i < 2; i++ {
+		if err := semaphore.Acquire(deadline); err != nil {
+			t.Fatalf("expected slot %d to be free, got %s", i, err.Error())
+		}
+	}
+	if err := semaphore.Acquire(deadline); err == nil {
+		t.Fatalf("expected the third holder to wait until the deadline")
+	}
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		semaphore.Release()
+	}()
+	if err := semaphore.Acquire(time.Now().Add(time.Second)); err != nil {
+		t.Errorf("expected the released slot to be taken, got %s", err.Error())
+	}
+
+	if unbounded := NewSemaphore(0); unbounded != nil {
+		t.Errorf("expected a semaphore without size to be nil")
+	}
+	var unbounded *Semaphore
+	if err := unbounded.Acquire(time.Now()); err != nil {
+		t.Errorf("expected a nil semaphore not to block, got %s", err.Error())
+	}
+	unbounded.Release()
+}
@@ -0,0 +1,113 @@
+package results
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/eventbridge"
+	"github.com/aws/aws-sdk-go/service/eventbridge/eventbridgeiface"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sns/snsiface"
+)
+
+// EventSource is the source of the published events, which EventBridge rules can match.
+const EventSource = "hermes"
+
+// maxEventsPerCall is the number of entries a PutEvents call accepts at most.
+const maxEventsPerCall = 10
+
+// EventType returns the type of the event published for an outcome, ie: email.sent or email.failed.
+func EventType(outcome Outcome) string {
+	return "email." + outcome.Status
+}
+
+// EventBridge publishes one event per outcome to an Amazon EventBridge event bus, its detail type being the event type. It implements the Writer interface.
+type EventBridge struct {
+	eventBus          string
+	eventBridgeClient eventbridgeiface.EventBridgeAPI
+}
+
+// Write puts the events by batches of 10.
+func (eventBridgeWriter *EventBridge) Write(outcomes []Outcome) error {
+	for start := 0; start < len(outcomes); start += maxEventsPerCall {
+		end := start + maxEventsPerCall
+		if end > len(outcomes) {
+			end = len(outcomes)
+		}
+
+		entries := make([]*eventbridge.PutEventsRequestEntry, 0, end-start)
+		for _, outcome := range outcomes[start:end] {
+			detail, err := json.Marshal(outcome)
+			if err != nil {
+				return fmt.Errorf("unable to marshal outcome: %s", err.Error())
+			}
+			entries = append(entries, &eventbridge.PutEventsRequestEntry{
+				EventBusName: aws.String(eventBridgeWriter.eventBus),
+				Source:       aws.String(EventSource),
+				DetailType:   aws.String(EventType(outcome)),
+				Detail:       aws.String(string(detail)),
+			})
+		}
+
+		output, err := eventBridgeWriter.eventBridgeClient.PutEvents(&eventbridge.PutEventsInput{Entries: entries})
+		if err != nil {
+			return fmt.Errorf("unable to put events on bus %q: %s", eventBridgeWriter.eventBus, err.Error())
+		}
+		if failed := aws.Int64Value(output.FailedEntryCount); failed > 0 {
+			return fmt.Errorf("%d events could not be put on bus %q", failed, eventBridgeWriter.eventBus)
+		}
+	}
+
+	return nil
+}
+
+// NewEventBridge instanciates an EventBridge writer publishing to the event bus, given by its name or ARN.
+func NewEventBridge(eventBus string, region string) (*EventBridge, error) {
+	sess, err := session.NewSession(&aws.Config{Region: aws.String(region)})
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to AWS: %s", err.Error())
+	}
+
+	return &EventBridge{eventBus: eventBus, eventBridgeClient: eventbridge.New(sess)}, nil
+}
+
+// SNS publishes one message per outcome to an Amazon SNS topic, with the event type as event_type message attribute, so subscriptions can filter on it.
+// It implements the Writer interface.
+type SNS struct {
+	topicARN  string
+	snsClient snsiface.SNSAPI
+}
+
+// Write publishes the outcomes one by one, stopping at the first failure.
+func (snsWriter *SNS) Write(outcomes []Outcome) error {
+	for _, outcome := range outcomes {
+		message, err := json.Marshal(outcome)
+		if err != nil {
+			return fmt.Errorf("unable to marshal outcome: %s", err.Error())
+		}
+
+		_, err = snsWriter.snsClient.Publish(&sns.PublishInput{
+			TopicArn: aws.String(snsWriter.topicARN),
+			Message:  aws.String(string(message)),
+			MessageAttributes: map[string]*sns.MessageAttributeValue{
+				"event_type": {DataType: aws.String("String"), StringValue: aws.String(EventType(outcome))},
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("unable to publish outcome of message %s to topic %q: %s", outcome.MessageID, snsWriter.topicARN, err.Error())
+		}
+	}
+
+	return nil
+}
+
+// NewSNS instanciates an SNS writer publishing to the topic.
+func NewSNS(topicARN string, region string) (*SNS, error) {
+	sess, err := session.NewSession(&aws.Config{Region: aws.String(region)})
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to AWS: %s", err.Error())
+	}
+
+	return &SNS{topicARN: topicARN, snsClient: sns.New(sess)}, nil
+}
@@ -0,0 +1,81 @@
+package results
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/aws/aws-sdk-go/service/lambda/lambdaiface"
+)
+
+// lambdaMaxPayload is the maximum size of the payload of an asynchronous invocation.
+const lambdaMaxPayload = 256 * 1024
+
+// Lambda sends the outcomes of a batch to a downstream AWS Lambda function, invoked asynchronously. It implements the Writer interface.
+type Lambda struct {
+	functionARN  string
+	lambdaClient lambdaiface.LambdaAPI
+}
+
+// lambdaPayloads returns the JSON payloads {"outcomes": [...]} of the outcomes, as many as needed for each to fit in an asynchronous invocation.
+func lambdaPayloads(outcomes []Outcome) ([][]byte, error) {
+	var payloads [][]byte
+	var payload bytes.Buffer
+	for _, outcome := range outcomes {
+		encoded, err := json.Marshal(outcome)
+		if err != nil {
+			return nil, fmt.Errorf("unable to marshal outcome of message %s: %s", outcome.MessageID, err.Error())
+		}
+		if payload.Len() > 0 && payload.Len()+1+len(encoded)+2 > lambdaMaxPayload {
+			payload.WriteString("]}")
+			payloads = append(payloads, payload.Bytes())
+			payload = bytes.Buffer{}
+		}
+		if payload.Len() == 0 {
+			payload.WriteString(`{"outcomes":[`)
+		} else {
+			payload.WriteByte(',')
+		}
+		payload.Write(encoded)
+	}
+	if payload.Len() == 0 {
+		payload.WriteString(`{"outcomes":[`)
+	}
+	payload.WriteString("]}")
+
+	return append(payloads, payload.Bytes()), nil
+}
+
+// Write invokes the function with the JSON payload {"outcomes": [...]}, without waiting for its execution.
+// A batch too large for a single invocation is split in several, stopping at the first failure.
+func (lambdaWriter *Lambda) Write(outcomes []Outcome) error {
+	payloads, err := lambdaPayloads(outcomes)
+	if err != nil {
+		return err
+	}
+
+	for _, payload := range payloads {
+		_, err = lambdaWriter.lambdaClient.Invoke(&lambda.InvokeInput{
+			FunctionName:   aws.String(lambdaWriter.functionARN),
+			InvocationType: aws.String(lambda.InvocationTypeEvent),
+			Payload:        payload,
+		})
+		if err != nil {
+			return fmt.Errorf("unable to invoke function %q: %s", lambdaWriter.functionARN, err.Error())
+		}
+	}
+
+	return nil
+}
+
+// NewLambda instanciates a Lambda writer invoking the given function.
+func NewLambda(functionARN string, region string) (*Lambda, error) {
+	sess, err := session.NewSession(&aws.Config{Region: aws.String(region)})
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to AWS: %s", err.Error())
+	}
+
+	return &Lambda{functionARN: functionARN, lambdaClient: lambda.New(sess)}, nil
+}
@@ -0,0 +1,89 @@
+package results
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/aws/aws-sdk-go/service/lambda/lambdaiface"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// mockLambda records the Invoke calls, failing them with err.
+type mockLambda struct {
+	lambdaiface.LambdaAPI
+	calls []*lambda.InvokeInput
+	err   error
+}
+
+func (client *mockLambda) Invoke(input *lambda.InvokeInput) (*lambda.InvokeOutput, error) {
+	client.calls = append(client.calls, input)
+	if client.err != nil {
+		return nil, client.err
+	}
+
+	return &lambda.InvokeOutput{StatusCode: aws.Int64(202)}, nil
+}
+
+func TestLambdaWrite(t *testing.T) {
+	largeOutcomes := testOutcomes(3)
+	for i := range largeOutcomes {
+		largeOutcomes[i].Status, largeOutcomes[i].Error = StatusFailed, strings.Repeat("x", 100*1024)
+	}
+
+	tests := []struct {
+		name        string
+		outcomes    []Outcome
+		err         error
+		calls       []int
+		expectedErr string
+	}{
+		{name: "invokes the function once with the outcomes", outcomes: testOutcomes(3), calls: []int{3}},
+		{name: "keeps a thousand outcomes in one invocation", outcomes: testOutcomes(1000), calls: []int{1000}},
+		{name: "splits the outcomes exceeding the payload size", outcomes: largeOutcomes, calls: []int{2, 1}},
+		{name: "reports a failed invocation", outcomes: testOutcomes(2), err: errors.New("rate exceeded"), calls: []int{2}, expectedErr: `unable to invoke function "arn:aws:lambda:eu-west-1:123456789012:function:results": rate exceeded`},
+		{name: "stops at the first failure", outcomes: largeOutcomes, err: errors.New("rate exceeded"), calls: []int{2}, expectedErr: "rate exceeded"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := &mockLambda{err: test.err}
+			writer := &Lambda{functionARN: "arn:aws:lambda:eu-west-1:123456789012:function:results", lambdaClient: client}
+			err := writer.Write(test.outcomes)
+			if test.expectedErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.expectedErr) {
+					t.Fatalf("expected error %q, got %v", test.expectedErr, err)
+				}
+			} else if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+
+			var calls []int
+			var received []Outcome
+			for _, call := range client.calls {
+				if aws.StringValue(call.FunctionName) != writer.functionARN || aws.StringValue(call.InvocationType) != lambda.InvocationTypeEvent {
+					t.Errorf("expected an asynchronous invocation of %s, got %s %s", writer.functionARN, aws.StringValue(call.InvocationType), aws.StringValue(call.FunctionName))
+				}
+				if len(call.Payload) > lambdaMaxPayload {
+					t.Errorf("expected a payload of at most %d bytes, got %d", lambdaMaxPayload, len(call.Payload))
+				}
+				var payload struct {
+					Outcomes []Outcome `json:"outcomes"`
+				}
+				if err := json.Unmarshal(call.Payload, &payload); err != nil {
+					t.Fatalf("expected a JSON payload, got %q", call.Payload)
+				}
+				calls = append(calls, len(payload.Outcomes))
+				received = append(received, payload.Outcomes...)
+			}
+			if !reflect.DeepEqual(calls, test.calls) {
+				t.Errorf("expected invocations of %v outcomes, got %v", test.calls, calls)
+			}
+			if test.err == nil && !reflect.DeepEqual(received, test.outcomes) {
+				t.Errorf("expected the outcomes to be sent in order")
+			}
+		})
+	}
+}
@@ -0,0 +1,26 @@
+package results
+
+// Statuses of a processed message.
+const (
+	StatusSent       = "sent"
+	StatusFailed     = "failed"
+	StatusSkipped    = "skipped"
+	StatusSuppressed = "suppressed"
+	StatusScheduled  = "scheduled"
+)
+
+// Outcome is the result of processing one queued message.
+type Outcome struct {
+	MessageID  string `json:"message_id"`
+	Template   string `json:"template,omitempty"`
+	Recipient  string `json:"recipient,omitempty"`
+	ProviderID string `json:"provider_id,omitempty"`
+	Status     string `json:"status"`
+	Error      string `json:"error,omitempty"`
+}
+
+// Writer interface should be implemented by any service responsible to record the outcomes of a batch (Kinesis, Firehose... etc).
+type Writer interface {
+	// Write should record the outcomes, in the given order.
+	Write(outcomes []Outcome) error
+}
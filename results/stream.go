@@ -0,0 +1,121 @@
+package results
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/firehose"
+	"github.com/aws/aws-sdk-go/service/firehose/firehoseiface"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/aws/aws-sdk-go/service/kinesis/kinesisiface"
+	"strings"
+)
+
+// streamMaxRecords is the maximum count of records of a single PutRecords or PutRecordBatch call.
+const streamMaxRecords = 500
+
+// inBatches calls put with consecutive batches of at most streamMaxRecords outcomes, stopping at the first failure.
+func inBatches(outcomes []Outcome, put func(batch []Outcome) error) error {
+	for start := 0; start < len(outcomes); start += streamMaxRecords {
+		end := start + streamMaxRecords
+		if end > len(outcomes) {
+			end = len(outcomes)
+		}
+		if err := put(outcomes[start:end]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Kinesis writes outcomes as records of an AWS Kinesis data stream. It implements the Writer interface.
+type Kinesis struct {
+	streamName    string
+	kinesisClient kinesisiface.KinesisAPI
+}
+
+// Write puts one record per outcome, partitioned by message id, in as many calls as the records count requires.
+func (kinesisWriter *Kinesis) Write(outcomes []Outcome) error {
+	return inBatches(outcomes, kinesisWriter.put)
+}
+
+func (kinesisWriter *Kinesis) put(outcomes []Outcome) error {
+	records := make([]*kinesis.PutRecordsRequestEntry, len(outcomes))
+	for i, outcome := range outcomes {
+		data, err := json.Marshal(outcome)
+		if err != nil {
+			return fmt.Errorf("unable to marshal outcome: %s", err.Error())
+		}
+		records[i] = &kinesis.PutRecordsRequestEntry{Data: data, PartitionKey: aws.String(outcome.MessageID)}
+	}
+
+	output, err := kinesisWriter.kinesisClient.PutRecords(&kinesis.PutRecordsInput{StreamName: aws.String(kinesisWriter.streamName), Records: records})
+	if err != nil {
+		return fmt.Errorf("unable to put records in stream %q: %s", kinesisWriter.streamName, err.Error())
+	}
+	if failed := aws.Int64Value(output.FailedRecordCount); failed > 0 {
+		return fmt.Errorf("%d records could not be put in stream %q", failed, kinesisWriter.streamName)
+	}
+
+	return nil
+}
+
+// Firehose writes outcomes as newline delimited JSON records of an AWS Kinesis Firehose delivery stream. It implements the Writer interface.
+type Firehose struct {
+	streamName     string
+	firehoseClient firehoseiface.FirehoseAPI
+}
+
+// Write puts one record per outcome, in as many calls as the records count requires.
+func (firehoseWriter *Firehose) Write(outcomes []Outcome) error {
+	return inBatches(outcomes, firehoseWriter.put)
+}
+
+func (firehoseWriter *Firehose) put(outcomes []Outcome) error {
+	records := make([]*firehose.Record, len(outcomes))
+	for i, outcome := range outcomes {
+		data, err := json.Marshal(outcome)
+		if err != nil {
+			return fmt.Errorf("unable to marshal outcome: %s", err.Error())
+		}
+		records[i] = &firehose.Record{Data: append(data, '\n')}
+	}
+
+	output, err := firehoseWriter.firehoseClient.PutRecordBatch(&firehose.PutRecordBatchInput{DeliveryStreamName: aws.String(firehoseWriter.streamName), Records: records})
+	if err != nil {
+		return fmt.Errorf("unable to put records in delivery stream %q: %s", firehoseWriter.streamName, err.Error())
+	}
+	if failed := aws.Int64Value(output.FailedPutCount); failed > 0 {
+		return fmt.Errorf("%d records could not be put in delivery stream %q", failed, firehoseWriter.streamName)
+	}
+
+	return nil
+}
+
+// NewStreamWriter instanciates a Kinesis or Firehose writer, depending on the service of the stream ARN.
+func NewStreamWriter(streamARN string, region string) (Writer, error) {
+	parsedARN, err := arn.Parse(streamARN)
+	if err != nil {
+		return nil, fmt.Errorf("invalid stream ARN %q: %s", streamARN, err.Error())
+	}
+	sess, err := session.NewSession(
+		&aws.Config{
+			Region: aws.String(region),
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to AWS: %s", err.Error())
+	}
+
+	switch {
+	case parsedARN.Service == "kinesis" && strings.HasPrefix(parsedARN.Resource, "stream/"):
+		return &Kinesis{streamName: strings.TrimPrefix(parsedARN.Resource, "stream/"), kinesisClient: kinesis.New(sess)}, nil
+	case parsedARN.Service == "firehose" && strings.HasPrefix(parsedARN.Resource, "deliverystream/"):
+		return &Firehose{streamName: strings.TrimPrefix(parsedARN.Resource, "deliverystream/"), firehoseClient: firehose.New(sess)}, nil
+	default:
+		return nil, fmt.Errorf("stream ARN %q is neither a Kinesis stream nor a Firehose delivery stream", streamARN)
+	}
+}
@@ -0,0 +1,232 @@
+package results
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/firehose"
+	"github.com/aws/aws-sdk-go/service/firehose/firehoseiface"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/aws/aws-sdk-go/service/kinesis/kinesisiface"
+	"strings"
+	"testing"
+)
+
+// mockKinesis records the PutRecords calls, failing them with err or failing failedRecords records of each call.
+type mockKinesis struct {
+	kinesisiface.KinesisAPI
+	calls         []*kinesis.PutRecordsInput
+	err           error
+	failedRecords int64
+}
+
+func (client *mockKinesis) PutRecords(input *kinesis.PutRecordsInput) (*kinesis.PutRecordsOutput, error) {
+	client.calls = append(client.calls, input)
+	if client.err != nil {
+		return nil, client.err
+	}
+
+	return &kinesis.PutRecordsOutput{FailedRecordCount: aws.Int64(client.failedRecords)}, nil
+}
+
+// mockFirehose records the PutRecordBatch calls, failing them with err or failing failedRecords records of each call.
+type mockFirehose struct {
+	firehoseiface.FirehoseAPI
+	calls         []*firehose.PutRecordBatchInput
+	err           error
+	failedRecords int64
+}
+
+func (client *mockFirehose) PutRecordBatch(input *firehose.PutRecordBatchInput) (*firehose.PutRecordBatchOutput, error) {
+	client.calls = append(client.calls, input)
+	if client.err != nil {
+		return nil, client.err
+	}
+
+	return &firehose.PutRecordBatchOutput{FailedPutCount: aws.Int64(client.failedRecords)}, nil
+}
+
+func testOutcomes(count int) []Outcome {
+	outcomes := make([]Outcome, count)
+	for i := range outcomes {
+		outcomes[i] = Outcome{MessageID: fmt.Sprintf("message-%d", i), Template: "welcome", ProviderID: fmt.Sprintf("provider-%d", i), Status: StatusSent}
+	}
+
+	return outcomes
+}
+
+func TestKinesisWrite(t *testing.T) {
+	tests := []struct {
+		name          string
+		outcomes      int
+		err           error
+		failedRecords int64
+		calls         []int
+		expectedErr   string
+	}{
+		{name: "puts a record per outcome", outcomes: 3, calls: []int{3}},
+		{name: "splits the records in calls of 500", outcomes: 1001, calls: []int{500, 500, 1}},
+		{name: "reports a failed call", outcomes: 2, err: errors.New("throughput exceeded"), calls: []int{2}, expectedErr: `unable to put records in stream "results": throughput exceeded`},
+		{name: "reports the failed records", outcomes: 2, failedRecords: 1, calls: []int{2}, expectedErr: `1 records could not be put in stream "results"`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := &mockKinesis{err: test.err, failedRecords: test.failedRecords}
+			outcomes := testOutcomes(test.outcomes)
+			err := (&Kinesis{streamName: "results", kinesisClient: client}).Write(outcomes)
+			if test.expectedErr != "" {
+				if err == nil || err.Error() != test.expectedErr {
+					t.Errorf("expected error %q, got %v", test.expectedErr, err)
+				}
+			} else if err != nil {
+				t.Errorf("unexpected error: %s", err.Error())
+			}
+
+			if len(client.calls) != len(test.calls) {
+				t.Fatalf("expected %d calls, got %d", len(test.calls), len(client.calls))
+			}
+			offset := 0
+			for i, call := range client.calls {
+				if aws.StringValue(call.StreamName) != "results" || len(call.Records) != test.calls[i] {
+					t.Errorf("expected call %d to put %d records in results, got %d in %s", i, test.calls[i], len(call.Records), aws.StringValue(call.StreamName))
+				}
+				for _, record := range call.Records {
+					var outcome Outcome
+					if err := json.Unmarshal(record.Data, &outcome); err != nil {
+						t.Fatalf("expected a JSON record, got %q", record.Data)
+					}
+					if outcome != outcomes[offset] || aws.StringValue(record.PartitionKey) != outcomes[offset].MessageID {
+						t.Errorf("expected record %d to be %+v partitioned by its message id, got %+v by %s", offset, outcomes[offset], outcome, aws.StringValue(record.PartitionKey))
+					}
+					offset++
+				}
+			}
+		})
+	}
+}
+
+func TestFirehoseWrite(t *testing.T) {
+	tests := []struct {
+		name          string
+		outcomes      int
+		err           error
+		failedRecords int64
+		calls         []int
+		expectedErr   string
+	}{
+		{name: "puts a record per outcome", outcomes: 3, calls: []int{3}},
+		{name: "splits the records in calls of 500", outcomes: 500, calls: []int{500}},
+		{name: "splits the records above 500", outcomes: 501, calls: []int{500, 1}},
+		{name: "stops at the first failed call", outcomes: 600, err: errors.New("service unavailable"), calls: []int{500}, expectedErr: `unable to put records in delivery stream "results": service unavailable`},
+		{name: "reports the failed records", outcomes: 2, failedRecords: 2, calls: []int{2}, expectedErr: `2 records could not be put in delivery stream "results"`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := &mockFirehose{err: test.err, failedRecords: test.failedRecords}
+			outcomes := testOutcomes(test.outcomes)
+			err := (&Firehose{streamName: "results", firehoseClient: client}).Write(outcomes)
+			if test.expectedErr != "" {
+				if err == nil || err.Error() != test.expectedErr {
+					t.Errorf("expected error %q, got %v", test.expectedErr, err)
+				}
+			} else if err != nil {
+				t.Errorf("unexpected error: %s", err.Error())
+			}
+
+			if len(client.calls) != len(test.calls) {
+				t.Fatalf("expected %d calls, got %d", len(test.calls), len(client.calls))
+			}
+			offset := 0
+			for i, call := range client.calls {
+				if aws.StringValue(call.DeliveryStreamName) != "results" || len(call.Records) != test.calls[i] {
+					t.Errorf("expected call %d to put %d records in results, got %d", i, test.calls[i], len(call.Records))
+				}
+				for _, record := range call.Records {
+					// The records are newline delimited, so the delivered objects hold one outcome per line.
+					if !strings.HasSuffix(string(record.Data), "}\n") || strings.Count(string(record.Data), "\n") != 1 {
+						t.Errorf("expected a newline delimited JSON record, got %q", record.Data)
+					}
+					var outcome Outcome
+					if err := json.Unmarshal(record.Data, &outcome); err != nil || outcome != outcomes[offset] {
+						t.Errorf("expected record %d to be %+v, got %q", offset, outcomes[offset], record.Data)
+					}
+					offset++
+				}
+			}
+		})
+	}
+}
+
+func TestNewStreamWriter(t *testing.T) {
+	tests := []struct {
+		arn    string
+		writer string
+		stream string
+		err    bool
+	}{
+		{arn: "arn:aws:kinesis:eu-west-1:123456789012:stream/results", writer: "kinesis", stream: "results"},
+		{arn: "arn:aws:firehose:eu-west-1:123456789012:deliverystream/send-log", writer: "firehose", stream: "send-log"},
+		{arn: "arn:aws:sqs:eu-west-1:123456789012:results", err: true},
+		{arn: "arn:aws:kinesis:eu-west-1:123456789012:results", err: true},
+		{arn: "results", err: true},
+	}
+
+	for _, test := range tests {
+		writer, err := NewStreamWriter(test.arn, "eu-west-1")
+		if test.err {
+			if err == nil {
+				t.Errorf("expected %s to be rejected", test.arn)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("unexpected error for %s: %s", test.arn, err.Error())
+			continue
+		}
+		switch typed := writer.(type) {
+		case *Kinesis:
+			if test.writer != "kinesis" || typed.streamName != test.stream {
+				t.Errorf("expected a %s writer to %s, got a Kinesis one to %s", test.writer, test.stream, typed.streamName)
+			}
+		case *Firehose:
+			if test.writer != "firehose" || typed.streamName != test.stream {
+				t.Errorf("expected a %s writer to %s, got a Firehose one to %s", test.writer, test.stream, typed.streamName)
+			}
+		default:
+			t.Errorf("unexpected writer %T", writer)
+		}
+	}
+}
+
+type failingWriter struct {
+	err     error
+	written []Outcome
+}
+
+func (writer *failingWriter) Write(outcomes []Outcome) error {
+	writer.written = append(writer.written, outcomes...)
+
+	return writer.err
+}
+
+func TestWriters(t *testing.T) {
+	first := &failingWriter{err: errors.New("stream unavailable")}
+	second := &failingWriter{}
+	third := &failingWriter{err: errors.New("callback failed")}
+
+	err := Writers{first, second, third}.Write(testOutcomes(2))
+	if err == nil || err.Error() != "2 writers failed: stream unavailable; callback failed" {
+		t.Errorf("expected both failures, got %v", err)
+	}
+	for i, writer := range []*failingWriter{first, second, third} {
+		if len(writer.written) != 2 {
+			t.Errorf("expected writer %d to receive the outcomes despite the failures, got %d", i, len(writer.written))
+		}
+	}
+	if err := (Writers{second}).Write(testOutcomes(1)); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+}
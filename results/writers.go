@@ -0,0 +1,24 @@
+package results
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Writers records the outcomes with each of its writers. It implements the Writer interface.
+type Writers []Writer
+
+// Write records the outcomes with every writer, even when some of them fail.
+func (writers Writers) Write(outcomes []Outcome) error {
+	var failures []string
+	for _, writer := range writers {
+		if err := writer.Write(outcomes); err != nil {
+			failures = append(failures, err.Error())
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("%d writers failed: %s", len(failures), strings.Join(failures, "; "))
+	}
+
+	return nil
+}
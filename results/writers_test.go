@@ -0,0 +1,52 @@
+package results
+
+import (
+	"errors"
+	"testing"
+)
+
+// recordingWriter records the written outcomes, failing with err.
+type recordingWriter struct {
+	written [][]Outcome
+	err     error
+}
+
+func (writer *recordingWriter) Write(outcomes []Outcome) error {
+	writer.written = append(writer.written, outcomes)
+
+	return writer.err
+}
+
+func TestWritersWrite(t *testing.T) {
+	tests := []struct {
+		name        string
+		errs        []error
+		expectedErr string
+	}{
+		{name: "writes to every writer", errs: []error{nil, nil}},
+		{name: "writes to the writers after a failure", errs: []error{errors.New("stream is unavailable"), nil}, expectedErr: "1 writers failed: stream is unavailable"},
+		{name: "reports every failure", errs: []error{errors.New("stream is unavailable"), errors.New("rate exceeded")}, expectedErr: "2 writers failed: stream is unavailable; rate exceeded"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var writers Writers
+			for _, err := range test.errs {
+				writers = append(writers, &recordingWriter{err: err})
+			}
+			outcomes := testOutcomes(2)
+			err := writers.Write(outcomes)
+			if test.expectedErr == "" && err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+			if test.expectedErr != "" && (err == nil || err.Error() != test.expectedErr) {
+				t.Fatalf("expected error %q, got %v", test.expectedErr, err)
+			}
+			for i, writer := range writers {
+				if written := writer.(*recordingWriter).written; len(written) != 1 || len(written[0]) != 2 {
+					t.Errorf("expected writer %d to write the outcomes once, got %v", i, written)
+				}
+			}
+		})
+	}
+}
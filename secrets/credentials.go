@@ -0,0 +1,76 @@
+package secrets
+
+import (
+	"encoding/json"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Getter interface should be implemented by any service able to read a secret value (Secrets Manager, Parameter Store... etc).
+type Getter interface {
+	// Get should return the current value of the named secret.
+	Get(name string) (string, error)
+}
+
+// Credentials reads a username and password from a secret, keeping them for the refresh interval so a rotated secret is eventually used.
+// The secret is either a JSON object, ie: {"username": "...", "password": "..."}, or the password alone, used with the default username.
+type Credentials struct {
+	getter          Getter
+	secretName      string
+	defaultUsername string
+	refresh         time.Duration
+	mu              sync.Mutex
+	username        string
+	password        string
+	expires         time.Time
+}
+
+// parseCredentials decodes the secret value, falling back to the default username when the secret is the password alone or has no username.
+func parseCredentials(value string, defaultUsername string) (string, string) {
+	var credentials struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+	if !strings.HasPrefix(strings.TrimSpace(value), "{") || json.Unmarshal([]byte(value), &credentials) != nil {
+		return defaultUsername, value
+	}
+	if credentials.Username == "" {
+		credentials.Username = defaultUsername
+	}
+
+	return credentials.Username, credentials.Password
+}
+
+// Credentials returns the username and password, reading the secret again once the refresh interval elapsed.
+// When the refresh fails, the previous credentials are kept and the failure is only logged.
+func (credentials *Credentials) Credentials() (string, string, error) {
+	credentials.mu.Lock()
+	defer credentials.mu.Unlock()
+
+	now := time.Now()
+	if !credentials.expires.IsZero() && now.Before(credentials.expires) {
+		return credentials.username, credentials.password, nil
+	}
+
+	value, err := credentials.getter.Get(credentials.secretName)
+	if err != nil {
+		if credentials.expires.IsZero() {
+			return "", "", err
+		}
+		log.Printf("Unable to refresh credentials, using the previous ones: %s", err.Error())
+		credentials.expires = now.Add(credentials.refresh)
+		return credentials.username, credentials.password, nil
+	}
+
+	credentials.username, credentials.password = parseCredentials(value, credentials.defaultUsername)
+	credentials.expires = now.Add(credentials.refresh)
+
+	return credentials.username, credentials.password, nil
+}
+
+// NewCredentials instanciates Credentials read from the named secret, and refreshed after the given interval.
+func NewCredentials(getter Getter, secretName string, defaultUsername string, refresh time.Duration) *Credentials {
+	return &Credentials{getter: getter, secretName: secretName, defaultUsername: defaultUsername, refresh: refresh}
+}
@@ -0,0 +1,36 @@
+package secrets
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+)
+
+// SecretsManager reads secret values from AWS Secrets Manager.
+type SecretsManager struct {
+	client *secretsmanager.SecretsManager
+}
+
+// Get returns the current string value of the secret, given by its name or ARN.
+func (secretsConnector *SecretsManager) Get(secretID string) (string, error) {
+	output, err := secretsConnector.client.GetSecretValue(&secretsmanager.GetSecretValueInput{SecretId: aws.String(secretID)})
+	if err != nil {
+		return "", fmt.Errorf("unable to get secret %q: %s", secretID, err.Error())
+	}
+	if output.SecretString == nil {
+		return "", fmt.Errorf("secret %q has no string value", secretID)
+	}
+
+	return aws.StringValue(output.SecretString), nil
+}
+
+// NewSecretsManager instanciates a SecretsManager reading the secrets of the region.
+func NewSecretsManager(region string) (*SecretsManager, error) {
+	sess, err := session.NewSession(&aws.Config{Region: aws.String(region)})
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to AWS: %s", err.Error())
+	}
+
+	return &SecretsManager{client: secretsmanager.New(sess)}, nil
+}
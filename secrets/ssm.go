@@ -0,0 +1,33 @@
+package secrets
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ssm"
+)
+
+// SSM reads secret values from AWS Systems Manager Parameter Store.
+type SSM struct {
+	client *ssm.SSM
+}
+
+// Get returns the decrypted value of the parameter, given by its name or path.
+func (ssmConnector *SSM) Get(parameterName string) (string, error) {
+	output, err := ssmConnector.client.GetParameter(&ssm.GetParameterInput{Name: aws.String(parameterName), WithDecryption: aws.Bool(true)})
+	if err != nil {
+		return "", fmt.Errorf("unable to get parameter %q: %s", parameterName, err.Error())
+	}
+
+	return aws.StringValue(output.Parameter.Value), nil
+}
+
+// NewSSM instanciates an SSM reading the parameters of the region.
+func NewSSM(region string) (*SSM, error) {
+	sess, err := session.NewSession(&aws.Config{Region: aws.String(region)})
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to AWS: %s", err.Error())
+	}
+
+	return &SSM{client: ssm.New(sess)}, nil
+}
@@ -0,0 +1,95 @@
+package signing
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/forsam-education/hermes/secrets"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// KeySource interface should be implemented by any service able to provide the keys the messages are signed with (Secrets Manager, static keys... etc).
+type KeySource interface {
+	// Keys should return the signing keys by key id, the key of a source holding a single one having an empty id.
+	Keys() (map[string][]byte, error)
+}
+
+// StaticKeys are signing keys given by key id, ie: by an embedding application. It implements the KeySource interface.
+type StaticKeys map[string][]byte
+
+// Keys returns the static keys.
+func (keys StaticKeys) Keys() (map[string][]byte, error) {
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no signing key is set")
+	}
+
+	return keys, nil
+}
+
+// SecretKeys reads the signing keys from a secret, keeping them for the refresh interval so a rotated secret is eventually used.
+// The secret is either a JSON object of the keys by key id, ie: {"2020-10": "...", "2020-07": "..."}, keeping the previous key
+// during a rotation, or a single key. It implements the KeySource interface.
+type SecretKeys struct {
+	getter     secrets.Getter
+	secretName string
+	refresh    time.Duration
+	mu         sync.Mutex
+	keys       map[string][]byte
+	expires    time.Time
+}
+
+// parseKeys decodes the secret value, a value which is not a JSON object of strings being a single key.
+func parseKeys(value string) (map[string][]byte, error) {
+	var keyStrings map[string]string
+	if !strings.HasPrefix(strings.TrimSpace(value), "{") || json.Unmarshal([]byte(value), &keyStrings) != nil {
+		keyStrings = map[string]string{"": value}
+	}
+
+	keys := make(map[string][]byte, len(keyStrings))
+	for keyID, key := range keyStrings {
+		if key == "" {
+			return nil, fmt.Errorf("signing key %q is empty", keyID)
+		}
+		keys[keyID] = []byte(key)
+	}
+
+	return keys, nil
+}
+
+// Keys returns the signing keys, reading the secret again once the refresh interval elapsed.
+// When the refresh fails, the previous keys are kept and the failure is only logged.
+func (secretKeys *SecretKeys) Keys() (map[string][]byte, error) {
+	secretKeys.mu.Lock()
+	defer secretKeys.mu.Unlock()
+
+	now := time.Now()
+	if !secretKeys.expires.IsZero() && now.Before(secretKeys.expires) {
+		return secretKeys.keys, nil
+	}
+
+	value, err := secretKeys.getter.Get(secretKeys.secretName)
+	var keys map[string][]byte
+	if err == nil {
+		keys, err = parseKeys(value)
+	}
+	if err != nil {
+		if secretKeys.expires.IsZero() {
+			return nil, fmt.Errorf("unable to read signing keys: %s", err.Error())
+		}
+		log.Printf("Unable to refresh signing keys, using the previous ones: %s", err.Error())
+		secretKeys.expires = now.Add(secretKeys.refresh)
+		return secretKeys.keys, nil
+	}
+
+	secretKeys.keys = keys
+	secretKeys.expires = now.Add(secretKeys.refresh)
+
+	return secretKeys.keys, nil
+}
+
+// NewSecretKeys instanciates SecretKeys read from the named secret, and refreshed after the given interval.
+func NewSecretKeys(getter secrets.Getter, secretName string, refresh time.Duration) *SecretKeys {
+	return &SecretKeys{getter: getter, secretName: secretName, refresh: refresh}
+}
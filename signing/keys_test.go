@@ -0,0 +1,99 @@
+package signing
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// fakeGetter returns the next of its values on each read, or the error when there is none left.
+type fakeGetter struct {
+	values []string
+	reads  int
+}
+
+func (getter *fakeGetter) Get(name string) (string, error) {
+	getter.reads++
+	if len(getter.values) == 0 {
+		return "", fmt.Errorf("secret %s is unavailable", name)
+	}
+	value := getter.values[0]
+	getter.values = getter.values[1:]
+
+	return value, nil
+}
+
+func TestParseKeys(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    string
+		expected map[string][]byte
+		err      string
+	}{
+		{name: "reads the keys by key id", value: `{"2020-10": "current", "2020-07": "previous"}`, expected: map[string][]byte{"2020-10": []byte("current"), "2020-07": []byte("previous")}},
+		{name: "reads a single key", value: "secret", expected: map[string][]byte{"": []byte("secret")}},
+		{name: "reads a key which is not a JSON object of strings as a single key", value: `{"2020-10": 42}`, expected: map[string][]byte{"": []byte(`{"2020-10": 42}`)}},
+		{name: "rejects an empty key", value: `{"2020-10": ""}`, err: `signing key "2020-10" is empty`},
+		{name: "rejects an empty secret", value: "", err: `signing key "" is empty`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			keys, err := parseKeys(test.value)
+			if test.err != "" {
+				if err == nil || err.Error() != test.err {
+					t.Errorf("expected error %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil || !reflect.DeepEqual(keys, test.expected) {
+				t.Errorf("expected %q, got %q, %v", test.expected, keys, err)
+			}
+		})
+	}
+}
+
+func TestSecretKeysRotation(t *testing.T) {
+	getter := &fakeGetter{values: []string{`{"2020-07": "previous"}`, `{"2020-10": "current", "2020-07": "previous"}`}}
+	secretKeys := NewSecretKeys(getter, "hermes/signing", time.Hour)
+
+	keys, err := secretKeys.Keys()
+	if err != nil || string(keys["2020-07"]) != "previous" {
+		t.Fatalf("expected the keys of the secret, got %q, %v", keys, err)
+	}
+	if keys, _ = secretKeys.Keys(); getter.reads != 1 || len(keys) != 1 {
+		t.Errorf("expected the keys to be kept until refreshed, got %d reads and %q", getter.reads, keys)
+	}
+
+	// The rotated secret is read once the refresh interval elapsed.
+	secretKeys.expires = time.Now().Add(-time.Second)
+	if keys, err = secretKeys.Keys(); err != nil || string(keys["2020-10"]) != "current" || string(keys["2020-07"]) != "previous" {
+		t.Errorf("expected the rotated keys, got %q, %v", keys, err)
+	}
+
+	// A failed refresh keeps the previous keys.
+	secretKeys.expires = time.Now().Add(-time.Second)
+	if keys, err = secretKeys.Keys(); err != nil || string(keys["2020-10"]) != "current" {
+		t.Errorf("expected the previous keys to be kept, got %q, %v", keys, err)
+	}
+	if secretKeys.expires.Before(time.Now()) {
+		t.Errorf("expected the next refresh to wait for the interval, got %s", secretKeys.expires)
+	}
+}
+
+func TestSecretKeysUnreadable(t *testing.T) {
+	secretKeys := NewSecretKeys(&fakeGetter{}, "hermes/signing", time.Hour)
+	if _, err := secretKeys.Keys(); err == nil || err.Error() != "unable to read signing keys: secret hermes/signing is unavailable" {
+		t.Errorf("expected the keys to be unreadable, got %v", err)
+	}
+}
+
+func TestStaticKeys(t *testing.T) {
+	if _, err := (StaticKeys{}).Keys(); err == nil {
+		t.Errorf("expected static keys without key to fail")
+	}
+	if keys, err := (StaticKeys{"": []byte("secret")}).Keys(); err != nil || string(keys[""]) != "secret" {
+		t.Errorf("expected the static keys, got %q, %v", keys, err)
+	}
+}
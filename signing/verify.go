@@ -0,0 +1,131 @@
+package signing
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"strings"
+	"time"
+)
+
+// jwtAlgorithms are the hash functions of the HMAC algorithms a JWT may be signed with, the asymmetric ones and "none" being refused.
+var jwtAlgorithms = map[string]func() hash.Hash{
+	"HS256": sha256.New,
+	"HS384": sha512.New384,
+	"HS512": sha512.New,
+}
+
+// candidateKeys returns the keys a signature may be made with: the one of the key id, or all of them when it is not given,
+// so the producers may keep signing with the previous key during a rotation.
+func candidateKeys(keys map[string][]byte, keyID string) ([][]byte, error) {
+	if keyID != "" {
+		key, ok := keys[keyID]
+		if !ok {
+			return nil, fmt.Errorf("signing key %q is unknown", keyID)
+		}
+		return [][]byte{key}, nil
+	}
+
+	candidates := make([][]byte, 0, len(keys))
+	for _, key := range keys {
+		candidates = append(candidates, key)
+	}
+
+	return candidates, nil
+}
+
+// matches tells if the MAC of the content by one of the keys is the given one.
+func matches(newHash func() hash.Hash, candidates [][]byte, content []byte, mac []byte) bool {
+	for _, key := range candidates {
+		signer := hmac.New(newHash, key)
+		signer.Write(content)
+		if hmac.Equal(signer.Sum(nil), mac) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// VerifyHMAC checks the signature is the hex encoded HMAC-SHA256 of the payload, with the key of the key id or any key when it is empty.
+// The signature may be prefixed by "sha256=", as webhook signatures usually are.
+func VerifyHMAC(keys map[string][]byte, payload []byte, keyID string, signature string) error {
+	mac, err := hex.DecodeString(strings.TrimPrefix(signature, "sha256="))
+	if err != nil {
+		return fmt.Errorf("signature is not hex encoded")
+	}
+	candidates, err := candidateKeys(keys, keyID)
+	if err != nil {
+		return err
+	}
+	if !matches(sha256.New, candidates, payload, mac) {
+		return fmt.Errorf("signature doesn't match")
+	}
+
+	return nil
+}
+
+// numericDate returns the time of a JWT NumericDate, the seconds since the epoch.
+func numericDate(seconds float64) time.Time {
+	return time.Unix(0, int64(seconds*float64(time.Second)))
+}
+
+// VerifyJWT checks the signature of a compact JWT signed with an HMAC algorithm, with the key of its kid header or any key when it has none,
+// and returns its claims once its exp and nbf claims, if any, are checked at the given time.
+func VerifyJWT(keys map[string][]byte, token string, now time.Time) (map[string]json.RawMessage, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("token is not a compact JWT")
+	}
+
+	var header struct {
+		Algorithm string `json:"alg"`
+		KeyID     string `json:"kid"`
+	}
+	encodedHeader, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || json.Unmarshal(encodedHeader, &header) != nil {
+		return nil, fmt.Errorf("invalid token header")
+	}
+	newHash, ok := jwtAlgorithms[header.Algorithm]
+	if !ok {
+		return nil, fmt.Errorf("token algorithm %q is not allowed, expecting HS256, HS384 or HS512", header.Algorithm)
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("invalid token signature")
+	}
+	candidates, err := candidateKeys(keys, header.KeyID)
+	if err != nil {
+		return nil, err
+	}
+	if !matches(newHash, candidates, []byte(parts[0]+"."+parts[1]), mac) {
+		return nil, fmt.Errorf("token signature doesn't match")
+	}
+
+	var claims map[string]json.RawMessage
+	encodedClaims, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || json.Unmarshal(encodedClaims, &claims) != nil {
+		return nil, fmt.Errorf("invalid token claims")
+	}
+	// The times are NumericDate values, which may have a fractional part.
+	var times struct {
+		Expires   *float64 `json:"exp"`
+		NotBefore *float64 `json:"nbf"`
+	}
+	if err := json.Unmarshal(encodedClaims, &times); err != nil {
+		return nil, fmt.Errorf("invalid token exp or nbf claim")
+	}
+	if times.Expires != nil && !now.Before(numericDate(*times.Expires)) {
+		return nil, fmt.Errorf("token expired at %s", numericDate(*times.Expires).UTC().Format(time.RFC3339))
+	}
+	if times.NotBefore != nil && now.Before(numericDate(*times.NotBefore)) {
+		return nil, fmt.Errorf("token is not valid before %s", numericDate(*times.NotBefore).UTC().Format(time.RFC3339))
+	}
+
+	return claims, nil
+}
@@ -0,0 +1,115 @@
+package signing
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"hash"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testKeys = map[string][]byte{"2020-10": []byte("current key"), "2020-07": []byte("previous key")}
+
+func hmacHex(key string, payload string) string {
+	signer := hmac.New(sha256.New, []byte(key))
+	signer.Write([]byte(payload))
+
+	return hex.EncodeToString(signer.Sum(nil))
+}
+
+// signJWT returns a compact JWT of the header and claims, signed with the key by the hash function.
+func signJWT(header string, claims string, newHash func() hash.Hash, key string) string {
+	content := base64.RawURLEncoding.EncodeToString([]byte(header)) + "." + base64.RawURLEncoding.EncodeToString([]byte(claims))
+	signer := hmac.New(newHash, []byte(key))
+	signer.Write([]byte(content))
+
+	return content + "." + base64.RawURLEncoding.EncodeToString(signer.Sum(nil))
+}
+
+func TestVerifyHMAC(t *testing.T) {
+	payload := `{"subject":"Welcome"}`
+	tests := []struct {
+		name      string
+		payload   string
+		keyID     string
+		signature string
+		err       string
+	}{
+		{name: "accepts the signature of the key id", payload: payload, keyID: "2020-10", signature: hmacHex("current key", payload)},
+		{name: "accepts the webhook prefix", payload: payload, keyID: "2020-10", signature: "sha256=" + hmacHex("current key", payload)},
+		{name: "accepts any key without key id", payload: payload, signature: hmacHex("previous key", payload)},
+		{name: "rejects a tampered payload", payload: `{"subject":"Welcome!"}`, keyID: "2020-10", signature: hmacHex("current key", payload), err: "signature doesn't match"},
+		{name: "rejects a wrong key", payload: payload, signature: hmacHex("attacker key", payload), err: "signature doesn't match"},
+		{name: "rejects the signature of another key id", payload: payload, keyID: "2020-07", signature: hmacHex("current key", payload), err: "signature doesn't match"},
+		{name: "rejects an unknown key id", payload: payload, keyID: "2019-01", signature: hmacHex("current key", payload), err: `signing key "2019-01" is unknown`},
+		{name: "rejects a signature which is not hex encoded", payload: payload, signature: "not-hex", err: "signature is not hex encoded"},
+		{name: "rejects an empty signature", payload: payload, err: "signature doesn't match"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := VerifyHMAC(testKeys, []byte(test.payload), test.keyID, test.signature)
+			if test.err == "" && err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+			if test.err != "" && (err == nil || err.Error() != test.err) {
+				t.Errorf("expected error %q, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestVerifyJWT(t *testing.T) {
+	now := time.Date(2020, 10, 26, 12, 0, 0, 0, time.UTC)
+	claims := `{"message":{"subject":"Welcome"}}`
+	valid := signJWT(`{"alg":"HS256","kid":"2020-10"}`, claims, sha256.New, "current key")
+	parts := strings.Split(valid, ".")
+	tamperedClaims := base64.RawURLEncoding.EncodeToString([]byte(`{"message":{"subject":"Free money"}}`))
+
+	tests := []struct {
+		name  string
+		token string
+		err   string
+	}{
+		{name: "accepts an HS256 token of the key id", token: valid},
+		{name: "accepts an HS384 token", token: signJWT(`{"alg":"HS384","kid":"2020-07"}`, claims, sha512.New384, "previous key")},
+		{name: "accepts an HS512 token without key id", token: signJWT(`{"alg":"HS512"}`, claims, sha512.New, "previous key")},
+		{name: "accepts a token before its expiration", token: signJWT(`{"alg":"HS256"}`, `{"message":{},"exp":1603713601}`, sha256.New, "current key")},
+		{name: "accepts a fractional expiration", token: signJWT(`{"alg":"HS256"}`, `{"message":{},"exp":1603713600.5}`, sha256.New, "current key")},
+		{name: "accepts a token once valid", token: signJWT(`{"alg":"HS256"}`, `{"message":{},"nbf":1603713600}`, sha256.New, "current key")},
+		{name: "rejects tampered claims", token: parts[0] + "." + tamperedClaims + "." + parts[2], err: "token signature doesn't match"},
+		{name: "rejects a wrong key", token: signJWT(`{"alg":"HS256"}`, claims, sha256.New, "attacker key"), err: "token signature doesn't match"},
+		{name: "rejects an unknown key id", token: signJWT(`{"alg":"HS256","kid":"2019-01"}`, claims, sha256.New, "current key"), err: `signing key "2019-01" is unknown`},
+		{name: "rejects the none algorithm", token: base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`)) + "." + parts[1] + ".", err: `token algorithm "none" is not allowed`},
+		{name: "rejects an asymmetric algorithm", token: signJWT(`{"alg":"RS256"}`, claims, sha256.New, "current key"), err: `token algorithm "RS256" is not allowed`},
+		{name: "rejects an algorithm mismatch", token: signJWT(`{"alg":"HS512","kid":"2020-10"}`, claims, sha256.New, "current key"), err: "token signature doesn't match"},
+		{name: "rejects an expired token", token: signJWT(`{"alg":"HS256"}`, `{"message":{},"exp":1603713600}`, sha256.New, "current key"), err: "token expired at 2020-10-26T12:00:00Z"},
+		{name: "rejects a token not valid yet", token: signJWT(`{"alg":"HS256"}`, `{"message":{},"nbf":1603713601}`, sha256.New, "current key"), err: "token is not valid before 2020-10-26T12:00:01Z"},
+		{name: "rejects an invalid expiration", token: signJWT(`{"alg":"HS256"}`, `{"message":{},"exp":"tomorrow"}`, sha256.New, "current key"), err: "invalid token exp or nbf claim"},
+		{name: "rejects a token which is not compact", token: parts[0] + "." + parts[1], err: "token is not a compact JWT"},
+		{name: "rejects an invalid header", token: "e30." + parts[1] + "." + parts[2] + "x", err: `token algorithm "" is not allowed`},
+		{name: "rejects claims which are not an object", token: signJWT(`{"alg":"HS256"}`, `[1]`, sha256.New, "current key"), err: "invalid token claims"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			claims, err := VerifyJWT(testKeys, test.token, now)
+			if test.err != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), test.err) {
+					t.Errorf("expected error %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+			if _, ok := claims["message"]; !ok {
+				t.Errorf("expected the message claim, got %v", claims)
+			}
+		})
+	}
+}
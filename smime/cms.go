@@ -0,0 +1,127 @@
+package smime
+
+import (
+	"bytes"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"sort"
+	"time"
+)
+
+// Object identifiers of the CMS content types, attributes and algorithms, as defined by RFC 5652 and RFC 5754.
+var (
+	oidData             = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidSignedData       = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidEnvelopedData    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 3}
+	oidAttributeType    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	oidAttributeDigest  = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidAttributeTime    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 5}
+	oidSHA256           = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidRSAEncryption    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	oidAES256CBC        = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+	nullAlgorithmParams = asn1.RawValue{Tag: asn1.TagNull}
+)
+
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue
+}
+
+type issuerAndSerialNumber struct {
+	Issuer       asn1.RawValue
+	SerialNumber *big.Int
+}
+
+type encapsulatedContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+}
+
+type signerInfo struct {
+	Version            int
+	SID                issuerAndSerialNumber
+	DigestAlgorithm    pkix.AlgorithmIdentifier
+	SignedAttributes   asn1.RawValue
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          []byte
+}
+
+type signedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	ContentInfo      encapsulatedContentInfo
+	Certificates     asn1.RawValue
+	SignerInfos      []signerInfo `asn1:"set"`
+}
+
+type attribute struct {
+	Type   asn1.ObjectIdentifier
+	Values asn1.RawValue
+}
+
+type keyTransRecipientInfo struct {
+	Version                int
+	RID                    issuerAndSerialNumber
+	KeyEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedKey           []byte
+}
+
+type encryptedContentInfo struct {
+	ContentType                asn1.ObjectIdentifier
+	ContentEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedContent           asn1.RawValue
+}
+
+type envelopedData struct {
+	Version              int
+	RecipientInfos       []keyTransRecipientInfo `asn1:"set"`
+	EncryptedContentInfo encryptedContentInfo
+}
+
+// derSet returns the DER set of the encoded elements with the given class and tag, sorted as DER requires.
+func derSet(class int, tag int, elements [][]byte) asn1.RawValue {
+	sorted := make([][]byte, len(elements))
+	copy(sorted, elements)
+	sort.Slice(sorted, func(i, j int) bool { return bytes.Compare(sorted[i], sorted[j]) < 0 })
+
+	return asn1.RawValue{Class: class, Tag: tag, IsCompound: true, Bytes: bytes.Join(sorted, nil)}
+}
+
+// newAttribute returns the encoded attribute holding the single value.
+func newAttribute(attributeType asn1.ObjectIdentifier, value interface{}) ([]byte, error) {
+	encodedValue, err := asn1.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	return asn1.Marshal(attribute{Type: attributeType, Values: derSet(asn1.ClassUniversal, asn1.TagSet, [][]byte{encodedValue})})
+}
+
+// signedAttributes returns the encoded content type, message digest and signing time attributes of a detached signature.
+func signedAttributes(digest []byte, signingTime time.Time) ([][]byte, error) {
+	var attributes [][]byte
+	for _, attr := range []struct {
+		attributeType asn1.ObjectIdentifier
+		value         interface{}
+	}{
+		{oidAttributeType, oidData},
+		{oidAttributeDigest, digest},
+		{oidAttributeTime, signingTime.UTC()},
+	} {
+		encoded, err := newAttribute(attr.attributeType, attr.value)
+		if err != nil {
+			return nil, err
+		}
+		attributes = append(attributes, encoded)
+	}
+
+	return attributes, nil
+}
+
+// wrapContentInfo returns the encoded content info of the encoded content of the given type.
+func wrapContentInfo(contentType asn1.ObjectIdentifier, content []byte) ([]byte, error) {
+	return asn1.Marshal(contentInfo{
+		ContentType: contentType,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: content},
+	})
+}
@@ -0,0 +1,130 @@
+package smime
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+)
+
+// base64LineLength is the length of the base64 lines of the S/MIME parts, as required by RFC 2045.
+const base64LineLength = 76
+
+// splitEntity separates the header fields of a CRLF message describing its content, which belong to the signed or encrypted entity,
+// from the other ones, which are kept as the message headers.
+func splitEntity(message []byte) (string, string, []byte) {
+	headerEnd := bytes.Index(message, []byte("\r\n\r\n"))
+	if headerEnd < 0 {
+		return string(message), "", nil
+	}
+
+	var messageHeaders, contentHeaders strings.Builder
+	inContent := false
+	for _, line := range strings.SplitAfter(string(message[:headerEnd+2]), "\r\n") {
+		if line == "" {
+			continue
+		}
+		// Folded lines continue the field they follow.
+		if line[0] != ' ' && line[0] != '\t' {
+			inContent = strings.HasPrefix(strings.ToLower(line), "content-")
+		}
+		if inContent {
+			contentHeaders.WriteString(line)
+		} else {
+			messageHeaders.WriteString(line)
+		}
+	}
+
+	return messageHeaders.String(), contentHeaders.String(), message[headerEnd+4:]
+}
+
+// encodeBase64Lines returns the base64 encoding of the data, in CRLF terminated lines.
+func encodeBase64Lines(data []byte) string {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	var lines strings.Builder
+	for len(encoded) > base64LineLength {
+		lines.WriteString(encoded[:base64LineLength] + "\r\n")
+		encoded = encoded[base64LineLength:]
+	}
+	lines.WriteString(encoded + "\r\n")
+
+	return lines.String()
+}
+
+// newBoundary returns a random multipart boundary.
+func newBoundary() (string, error) {
+	random := make([]byte, 24)
+	if _, err := rand.Read(random); err != nil {
+		return "", fmt.Errorf("unable to generate boundary: %s", err.Error())
+	}
+
+	return hex.EncodeToString(random), nil
+}
+
+// Seal returns the raw CRLF message signed as a multipart/signed entity, then encrypted as an application/pkcs7-mime entity
+// when the certificates of every recipient are known. The signer certificate is one of the encryption recipients, so the sent copies stay readable.
+func (identity *Identity) Seal(message []byte, recipients []string, now time.Time) ([]byte, error) {
+	messageHeaders, contentHeaders, body := splitEntity(message)
+	entity := append([]byte(contentHeaders+"\r\n"), body...)
+
+	signature, err := identity.sign(entity, now)
+	if err != nil {
+		return nil, err
+	}
+	boundary, err := newBoundary()
+	if err != nil {
+		return nil, err
+	}
+	var signed bytes.Buffer
+	signed.WriteString("Content-Type: multipart/signed; protocol=\"application/pkcs7-signature\"; micalg=sha-256;\r\n boundary=\"" + boundary + "\"\r\n\r\n")
+	signed.WriteString("--" + boundary + "\r\n")
+	signed.Write(entity)
+	signed.WriteString("\r\n--" + boundary + "\r\n")
+	signed.WriteString("Content-Type: application/pkcs7-signature; name=\"smime.p7s\"\r\nContent-Transfer-Encoding: base64\r\nContent-Disposition: attachment; filename=\"smime.p7s\"\r\n\r\n")
+	signed.WriteString(encodeBase64Lines(signature))
+	signed.WriteString("--" + boundary + "--\r\n")
+
+	sealed := []byte(messageHeaders)
+	certificates, ok := identity.recipientCertificates(recipients)
+	if !ok {
+		if len(identity.recipients) > 0 {
+			log.Printf("Sending S/MIME message signed only, as some of its recipients have no known certificate")
+		}
+		return append(sealed, signed.Bytes()...), nil
+	}
+
+	enveloped, err := encrypt(signed.Bytes(), append(certificates, identity.certificate))
+	if err != nil {
+		return nil, err
+	}
+	sealed = append(sealed, "Content-Type: application/pkcs7-mime; smime-type=enveloped-data; name=\"smime.p7m\"\r\nContent-Transfer-Encoding: base64\r\nContent-Disposition: attachment; filename=\"smime.p7m\"\r\n\r\n"...)
+
+	return append(sealed, encodeBase64Lines(enveloped)...), nil
+}
+
+// SealedMessage writes a message signed, and encrypted when possible, by an Identity.
+type SealedMessage struct {
+	Message    io.WriterTo
+	Identity   *Identity
+	Recipients []string
+}
+
+// WriteTo renders the message once, seals it and dumps the sealed message into w.
+func (msg *SealedMessage) WriteTo(w io.Writer) (int64, error) {
+	var rawMessage bytes.Buffer
+	if _, err := msg.Message.WriteTo(&rawMessage); err != nil {
+		return 0, err
+	}
+	sealed, err := msg.Identity.Seal(rawMessage.Bytes(), msg.Recipients, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.Write(sealed)
+
+	return int64(n), err
+}
@@ -0,0 +1,367 @@
+package smime
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"mime"
+	"net/mail"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The fixtures of testdata are generated by openssl: a test authority, the certificates and keys of the sender and of the alice and bob
+// recipients it issued, and a detached signature and an enveloped data of content.txt, so the helpers decoding the sealed messages are
+// checked against openssl itself.
+
+func readFixture(t *testing.T, name string) []byte {
+	t.Helper()
+
+	content, err := ioutil.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatalf("unable to read fixture %s: %s", name, err.Error())
+	}
+
+	return content
+}
+
+func newTestIdentity(t *testing.T, recipients ...string) *Identity {
+	t.Helper()
+
+	keys, _ := json.Marshal(Keys{Certificate: string(readFixture(t, "sender.pem")), PrivateKey: string(readFixture(t, "sender.key"))})
+	certificates := make(map[string]string)
+	for _, recipient := range recipients {
+		certificates[recipient+"@example.com"] = string(readFixture(t, recipient+".pem"))
+	}
+	recipientsJSON, _ := json.Marshal(certificates)
+	identity, err := NewIdentity(string(keys), string(recipientsJSON))
+	if err != nil {
+		t.Fatalf("unable to instantiate identity: %s", err.Error())
+	}
+
+	return identity
+}
+
+func readCertificate(t *testing.T, name string) *x509.Certificate {
+	t.Helper()
+
+	certificates, err := parseCertificates(string(readFixture(t, name+".pem")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return certificates[0]
+}
+
+func readKey(t *testing.T, name string) *rsa.PrivateKey {
+	t.Helper()
+
+	key, err := parsePrivateKey(string(readFixture(t, name+".key")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return key
+}
+
+// unwrapContentInfo returns the content of the encoded content info, checking its type.
+func unwrapContentInfo(t *testing.T, encoded []byte, contentType asn1.ObjectIdentifier) []byte {
+	t.Helper()
+
+	var info contentInfo
+	if _, err := asn1.Unmarshal(encoded, &info); err != nil {
+		t.Fatalf("unable to decode content info: %s", err.Error())
+	}
+	if !info.ContentType.Equal(contentType) {
+		t.Fatalf("expected content type %s, got %s", contentType, info.ContentType)
+	}
+
+	return info.Content.Bytes
+}
+
+// decryptEnveloped decrypts the enveloped data with the key of the certificate, failing the test if the certificate is not one of its recipients.
+// It returns the serial numbers of every recipient along the content.
+func decryptEnveloped(t *testing.T, encoded []byte, certificate *x509.Certificate, key *rsa.PrivateKey) ([]byte, []string) {
+	t.Helper()
+
+	var enveloped envelopedData
+	if _, err := asn1.Unmarshal(unwrapContentInfo(t, encoded, oidEnvelopedData), &enveloped); err != nil {
+		t.Fatalf("unable to decode enveloped data: %s", err.Error())
+	}
+
+	var serials []string
+	var contentKey []byte
+	for _, recipient := range enveloped.RecipientInfos {
+		serials = append(serials, recipient.RID.SerialNumber.String())
+		if recipient.RID.SerialNumber.Cmp(certificate.SerialNumber) != 0 || !bytes.Equal(recipient.RID.Issuer.FullBytes, certificate.RawIssuer) {
+			continue
+		}
+		var err error
+		if contentKey, err = rsa.DecryptPKCS1v15(nil, key, recipient.EncryptedKey); err != nil {
+			t.Fatalf("unable to decrypt content key: %s", err.Error())
+		}
+	}
+	if contentKey == nil {
+		t.Fatalf("certificate %s is not a recipient of the enveloped data", certificate.Subject)
+	}
+
+	algorithm := enveloped.EncryptedContentInfo.ContentEncryptionAlgorithm
+	if !algorithm.Algorithm.Equal(oidAES256CBC) {
+		t.Fatalf("expected AES-256-CBC content encryption, got %s", algorithm.Algorithm)
+	}
+	var iv []byte
+	if _, err := asn1.Unmarshal(algorithm.Parameters.FullBytes, &iv); err != nil {
+		t.Fatalf("unable to decode initialization vector: %s", err.Error())
+	}
+	block, err := aes.NewCipher(contentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := append([]byte(nil), enveloped.EncryptedContentInfo.EncryptedContent.Bytes...)
+	if len(content) == 0 || len(content)%aes.BlockSize != 0 {
+		t.Fatalf("encrypted content of %d bytes is not made of blocks", len(content))
+	}
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(content, content)
+	padding := int(content[len(content)-1])
+	if padding == 0 || padding > aes.BlockSize {
+		t.Fatalf("invalid padding %d", padding)
+	}
+
+	return content[:len(content)-padding], serials
+}
+
+// verifySignature checks the detached signature of the content, and the certificate chain of its signer up to the test authority.
+// It returns the signer certificate.
+func verifySignature(t *testing.T, encoded []byte, content []byte) *x509.Certificate {
+	t.Helper()
+
+	var signed signedData
+	if _, err := asn1.Unmarshal(unwrapContentInfo(t, encoded, oidSignedData), &signed); err != nil {
+		t.Fatalf("unable to decode signed data: %s", err.Error())
+	}
+	certificates, err := x509.ParseCertificates(signed.Certificates.Bytes)
+	if err != nil {
+		t.Fatalf("unable to decode certificates: %s", err.Error())
+	}
+	if len(signed.SignerInfos) != 1 {
+		t.Fatalf("expected a single signer, got %d", len(signed.SignerInfos))
+	}
+	signer := signed.SignerInfos[0]
+	var signerCertificate *x509.Certificate
+	intermediates := x509.NewCertPool()
+	for _, certificate := range certificates {
+		intermediates.AddCert(certificate)
+		if certificate.SerialNumber.Cmp(signer.SID.SerialNumber) == 0 {
+			signerCertificate = certificate
+		}
+	}
+	if signerCertificate == nil {
+		t.Fatalf("signer certificate is not sent along the signature")
+	}
+
+	// The signed attributes are signed as a SET, while being sent with an implicit [0] tag.
+	signedAttributes := append([]byte(nil), signer.SignedAttributes.FullBytes...)
+	signedAttributes[0] = 0x31
+	var attributes []attribute
+	if _, err := asn1.UnmarshalWithParams(signedAttributes, &attributes, "set"); err != nil {
+		t.Fatalf("unable to decode signed attributes: %s", err.Error())
+	}
+	digest := sha256.Sum256(content)
+	var digestFound bool
+	for _, attr := range attributes {
+		if !attr.Type.Equal(oidAttributeDigest) {
+			continue
+		}
+		var signedDigest []byte
+		if _, err := asn1.Unmarshal(attr.Values.Bytes, &signedDigest); err != nil {
+			t.Fatalf("unable to decode message digest: %s", err.Error())
+		}
+		if !bytes.Equal(signedDigest, digest[:]) {
+			t.Fatalf("message digest doesn't match the content")
+		}
+		digestFound = true
+	}
+	if !digestFound {
+		t.Fatalf("no message digest attribute is signed")
+	}
+	attributesDigest := sha256.Sum256(signedAttributes)
+	if err := rsa.VerifyPKCS1v15(signerCertificate.PublicKey.(*rsa.PublicKey), crypto.SHA256, attributesDigest[:], signer.Signature); err != nil {
+		t.Fatalf("invalid signature: %s", err.Error())
+	}
+
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(readFixture(t, "ca.pem"))
+	if _, err := signerCertificate.Verify(x509.VerifyOptions{Roots: roots, Intermediates: intermediates, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageEmailProtection}}); err != nil {
+		t.Fatalf("untrusted signer certificate: %s", err.Error())
+	}
+
+	return signerCertificate
+}
+
+// readSigned returns the signed entity and the decoded signature of a multipart/signed entity.
+func readSigned(t *testing.T, entity []byte) ([]byte, []byte) {
+	t.Helper()
+
+	message, err := mail.ReadMessage(bytes.NewReader(entity))
+	if err != nil {
+		t.Fatalf("unable to read signed entity: %s", err.Error())
+	}
+	mediaType, params, err := mime.ParseMediaType(message.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/signed" || params["protocol"] != "application/pkcs7-signature" {
+		t.Fatalf("expected a multipart/signed entity, got %q", message.Header.Get("Content-Type"))
+	}
+	body, _ := ioutil.ReadAll(message.Body)
+	delimiter := "--" + params["boundary"]
+	parts := strings.Split(string(body), "\r\n"+delimiter)
+	if len(parts) != 3 || !strings.HasPrefix(parts[0], delimiter+"\r\n") {
+		t.Fatalf("expected a signed part and a signature part, got %d parts", len(parts))
+	}
+	signedPart := strings.TrimPrefix(parts[0], delimiter+"\r\n")
+	signaturePart := strings.SplitN(parts[1], "\r\n\r\n", 2)
+	signature, err := base64.StdEncoding.DecodeString(strings.Replace(signaturePart[1], "\r\n", "", -1))
+	if err != nil {
+		t.Fatalf("unable to decode signature: %s", err.Error())
+	}
+
+	return []byte(signedPart), signature
+}
+
+// readEnveloped returns the decoded enveloped data of an application/pkcs7-mime message.
+func readEnveloped(t *testing.T, sealed []byte) []byte {
+	t.Helper()
+
+	message, err := mail.ReadMessage(bytes.NewReader(sealed))
+	if err != nil {
+		t.Fatalf("unable to read sealed message: %s", err.Error())
+	}
+	if mediaType, params, _ := mime.ParseMediaType(message.Header.Get("Content-Type")); mediaType != "application/pkcs7-mime" || params["smime-type"] != "enveloped-data" {
+		t.Fatalf("expected an enveloped message, got %q", message.Header.Get("Content-Type"))
+	}
+	body, _ := ioutil.ReadAll(message.Body)
+	enveloped, err := base64.StdEncoding.DecodeString(strings.Replace(string(body), "\r\n", "", -1))
+	if err != nil {
+		t.Fatalf("unable to decode enveloped data: %s", err.Error())
+	}
+
+	return enveloped
+}
+
+// signedEntity returns the entity of a sealed message, being its multipart/signed body with its content headers.
+func signedEntity(t *testing.T, sealed []byte) []byte {
+	t.Helper()
+
+	_, contentHeaders, body := splitEntity(sealed)
+
+	return append([]byte(contentHeaders+"\r\n"), body...)
+}
+
+const testMessage = "From: sender@example.com\r\nTo: alice@example.com\r\nSubject: Invoice\r\nMIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n" +
+	"Content-Transfer-Encoding: quoted-printable\r\n\r\nHello Alice\r\n"
+
+func TestHelpersDecodeOpenSSLFixtures(t *testing.T) {
+	content := readFixture(t, "content.txt")
+
+	if signer := verifySignature(t, readFixture(t, "openssl-signature.p7s"), content); signer.Subject.CommonName != "sender@example.com" {
+		t.Errorf("expected sender as signer, got %s", signer.Subject)
+	}
+	decrypted, _ := decryptEnveloped(t, readFixture(t, "openssl-enveloped.p7m"), readCertificate(t, "alice"), readKey(t, "alice"))
+	if !bytes.Equal(decrypted, content) {
+		t.Errorf("expected %q, got %q", content, decrypted)
+	}
+}
+
+func TestSeal(t *testing.T) {
+	tests := []struct {
+		name       string
+		known      []string
+		recipients []string
+		encrypted  bool
+		readers    []string
+	}{
+		{name: "signs only without recipient certificates", recipients: []string{"alice@example.com"}},
+		{name: "signs only when a recipient certificate is unknown", known: []string{"alice"}, recipients: []string{"alice@example.com", "carol@example.com"}},
+		{name: "encrypts for the recipient and the sender", known: []string{"alice", "bob"}, recipients: []string{"alice@example.com"}, encrypted: true, readers: []string{"alice", "sender"}},
+		{name: "encrypts for every recipient", known: []string{"alice", "bob"}, recipients: []string{"Alice@Example.com", "bob@example.com"}, encrypted: true, readers: []string{"alice", "bob", "sender"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			identity := newTestIdentity(t, test.known...)
+			sealed, err := identity.Seal([]byte(testMessage), test.recipients, time.Now())
+			if err != nil {
+				t.Fatalf("unable to seal message: %s", err.Error())
+			}
+			headers, _, _ := splitEntity(sealed)
+			if !strings.Contains(headers, "Subject: Invoice\r\n") || strings.Contains(headers, "Content-Transfer-Encoding") {
+				t.Errorf("expected the message headers to be kept out of the sealed entity, got %q", headers)
+			}
+
+			entities := [][]byte{signedEntity(t, sealed)}
+			if test.encrypted {
+				entities = nil
+				enveloped := readEnveloped(t, sealed)
+				for _, reader := range test.readers {
+					decrypted, serials := decryptEnveloped(t, enveloped, readCertificate(t, reader), readKey(t, reader))
+					if len(serials) != len(test.readers) {
+						t.Errorf("expected %d recipient infos, got %d", len(test.readers), len(serials))
+					}
+					entities = append(entities, decrypted)
+				}
+			}
+			for _, entity := range entities {
+				signed, signature := readSigned(t, entity)
+				verifySignature(t, signature, signed)
+				if !bytes.HasSuffix(signed, []byte("\r\n\r\nHello Alice\r\n")) || !bytes.HasPrefix(signed, []byte("Content-Type: text/plain")) {
+					t.Errorf("expected the content headers and body to be signed, got %q", signed)
+				}
+			}
+		})
+	}
+}
+
+func TestSealInteroperatesWithOpenSSL(t *testing.T) {
+	opensslPath, err := exec.LookPath("openssl")
+	if err != nil {
+		t.Skip("openssl is not installed")
+	}
+	sealed, err := newTestIdentity(t, "alice").Seal([]byte(testMessage), []string{"alice@example.com"}, time.Now())
+	if err != nil {
+		t.Fatalf("unable to seal message: %s", err.Error())
+	}
+
+	directory, err := ioutil.TempDir("", "smime")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+	sealedPath := filepath.Join(directory, "sealed.eml")
+	if err := ioutil.WriteFile(sealedPath, sealed, 0600); err != nil {
+		t.Fatal(err)
+	}
+	decrypt := exec.Command(opensslPath, "cms", "-decrypt", "-in", sealedPath, "-recip", filepath.Join("testdata", "alice.pem"), "-inkey", filepath.Join("testdata", "alice.key"))
+	decrypted, err := decrypt.Output()
+	if err != nil {
+		t.Fatalf("openssl is unable to decrypt the message: %s", err.Error())
+	}
+	verify := exec.Command(opensslPath, "cms", "-verify", "-CAfile", filepath.Join("testdata", "ca.pem"), "-purpose", "smimesign")
+	verify.Stdin = bytes.NewReader(decrypted)
+	verified, err := verify.Output()
+	if err != nil {
+		t.Fatalf("openssl is unable to verify the message: %s", err.Error())
+	}
+	if !bytes.Contains(verified, []byte("Hello Alice")) {
+		t.Errorf("expected the verified content to hold the body, got %q", verified)
+	}
+}
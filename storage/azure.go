@@ -0,0 +1,113 @@
+package storage
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// azureAPIVersion is the Blob service version requests are signed for.
+const azureAPIVersion = "2020-04-08"
+
+// AzureBlob handles getting template content from Azure Blob Storage containers. It implements TemplateFetcher interface.
+type AzureBlob struct {
+	account    string
+	container  string
+	accountKey []byte
+	sasToken   string
+	httpClient *http.Client
+}
+
+// blobPath returns the escaped path of the blob in the container.
+func (azureConnector *AzureBlob) blobPath(blobName string) string {
+	segments := strings.Split(blobName, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+
+	return "/" + url.PathEscape(azureConnector.container) + "/" + strings.Join(segments, "/")
+}
+
+// sign authorizes the GET request with the account shared key, signing its date, version and resource.
+func (azureConnector *AzureBlob) sign(request *http.Request, blobPath string) {
+	date := time.Now().UTC().Format(http.TimeFormat)
+	request.Header.Set("x-ms-date", date)
+	request.Header.Set("x-ms-version", azureAPIVersion)
+
+	// The verb is followed by the 11 standard headers, all empty for a GET, then the canonicalized headers and resource.
+	stringToSign := http.MethodGet + "\n" + strings.Repeat("\n", 11) +
+		"x-ms-date:" + date + "\n" + "x-ms-version:" + azureAPIVersion + "\n" +
+		"/" + azureConnector.account + blobPath
+
+	mac := hmac.New(sha256.New, azureConnector.accountKey)
+	mac.Write([]byte(stringToSign))
+	request.Header.Set("Authorization", "SharedKey "+azureConnector.account+":"+base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+}
+
+// Fetch downloads the template content by its name from the container.
+func (azureConnector *AzureBlob) Fetch(templateName string) (string, error) {
+	return azureConnector.FetchContext(context.Background(), templateName)
+}
+
+// FetchContext downloads the template content as Fetch does, the request being canceled once the context is done.
+func (azureConnector *AzureBlob) FetchContext(ctx context.Context, templateName string) (string, error) {
+	blobPath := azureConnector.blobPath(templateName)
+	blobURL := "https://" + azureConnector.account + ".blob.core.windows.net" + blobPath
+	if azureConnector.sasToken != "" {
+		blobURL += "?" + azureConnector.sasToken
+	}
+
+	request, err := http.NewRequest(http.MethodGet, blobURL, nil)
+	if err != nil {
+		return "", fmt.Errorf("unable to build template request: %s", err.Error())
+	}
+	request = request.WithContext(ctx)
+	if azureConnector.accountKey != nil {
+		azureConnector.sign(request, blobPath)
+	} else {
+		request.Header.Set("x-ms-version", azureAPIVersion)
+	}
+
+	response, err := azureConnector.httpClient.Do(request)
+	if err != nil {
+		return "", fmt.Errorf("unable to get blob in container %q: %s", azureConnector.container, err.Error())
+	}
+	defer response.Body.Close()
+	if response.StatusCode == http.StatusNotFound {
+		return "", &NotFoundError{Name: templateName}
+	}
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unable to get blob in container %q: %s", azureConnector.container, response.Status)
+	}
+
+	content, err := ioutil.ReadAll(io.LimitReader(response.Body, maxTemplateBytes))
+	if err != nil {
+		return "", fmt.Errorf("unable to read template %q: %s", templateName, err.Error())
+	}
+	log.Printf("Downloaded template %s from Azure Blob storage", templateName)
+
+	return string(content), nil
+}
+
+// NewAzureBlob instanciates an AzureBlob connector for the container of the storage account, authenticated with either
+// the base64 encoded account key, or a SAS token.
+func NewAzureBlob(account string, container string, accountKey string, sasToken string) (*AzureBlob, error) {
+	azureConnector := &AzureBlob{account: account, container: container, sasToken: strings.TrimPrefix(sasToken, "?"), httpClient: &http.Client{Timeout: 30 * time.Second}}
+	if accountKey != "" {
+		var err error
+		if azureConnector.accountKey, err = base64.StdEncoding.DecodeString(accountKey); err != nil {
+			return nil, fmt.Errorf("account key is not valid base64: %s", err.Error())
+		}
+	}
+
+	return azureConnector, nil
+}
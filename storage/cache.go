@@ -0,0 +1,112 @@
+package storage
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// FreshFetcher interface should be implemented by caching fetchers able to bypass their cache.
+type FreshFetcher interface {
+	// FetchFresh should return the content of the template from the underlying storage, ignoring any cached content.
+	FetchFresh(templateName string) (string, error)
+}
+
+type cacheEntry struct {
+	name    string
+	content string
+	expires time.Time
+}
+
+// Cache keeps the fetched templates in memory for a warm lambda. It implements TemplateFetcher and FreshFetcher interfaces.
+type Cache struct {
+	// MaxEntries is how many templates are kept at most, the least recently used one being evicted first. Zero means no limit.
+	MaxEntries int
+	fetcher    TemplateFetcher
+	ttl        time.Duration
+	mu         sync.Mutex
+	entries    map[string]*list.Element
+	recency    *list.List
+}
+
+// NewCache instanciates a Cache keeping the templates of the fetcher for the ttl duration.
+func NewCache(fetcher TemplateFetcher, ttl time.Duration) *Cache {
+	return &Cache{fetcher: fetcher, ttl: ttl, entries: make(map[string]*list.Element), recency: list.New()}
+}
+
+// Fetch returns the cached template content, fetching it when it is not cached or expired.
+func (cache *Cache) Fetch(templateName string) (string, error) {
+	return cache.FetchContext(context.Background(), templateName)
+}
+
+// FetchContext returns the cached template content as Fetch does, the context being given to the fetcher when it is not cached.
+func (cache *Cache) FetchContext(ctx context.Context, templateName string) (string, error) {
+	cache.mu.Lock()
+	element, ok := cache.entries[templateName]
+	if ok && time.Now().Before(element.Value.(*cacheEntry).expires) {
+		cache.recency.MoveToFront(element)
+		content := element.Value.(*cacheEntry).content
+		cache.mu.Unlock()
+		return content, nil
+	}
+	cache.mu.Unlock()
+
+	return cache.fetch(ctx, cache.fetcher, templateName)
+}
+
+// FetchFresh fetches the template content, and caches it for the next calls. Missing templates are not cached.
+// The cache of the fetcher, if it is a caching one too, is bypassed as well.
+func (cache *Cache) FetchFresh(templateName string) (string, error) {
+	return cache.fetch(context.Background(), Uncached(cache.fetcher), templateName)
+}
+
+// fetch reads the template content from the fetcher, and caches it.
+func (cache *Cache) fetch(ctx context.Context, fetcher TemplateFetcher, templateName string) (string, error) {
+	content, err := FetchContext(ctx, fetcher, templateName)
+	if err != nil {
+		return "", err
+	}
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	entry := &cacheEntry{name: templateName, content: content, expires: time.Now().Add(cache.ttl)}
+	if element, ok := cache.entries[templateName]; ok {
+		element.Value = entry
+		cache.recency.MoveToFront(element)
+	} else {
+		cache.entries[templateName] = cache.recency.PushFront(entry)
+	}
+	for cache.MaxEntries > 0 && cache.recency.Len() > cache.MaxEntries {
+		oldest := cache.recency.Back()
+		cache.recency.Remove(oldest)
+		delete(cache.entries, oldest.Value.(*cacheEntry).name)
+	}
+
+	return content, nil
+}
+
+type uncached struct {
+	FreshFetcher
+}
+
+func (fetcher uncached) Fetch(templateName string) (string, error) {
+	return fetcher.FetchFresh(templateName)
+}
+
+func (fetcher uncached) FetchContext(ctx context.Context, templateName string) (string, error) {
+	if cache, ok := fetcher.FreshFetcher.(*Cache); ok {
+		return cache.fetch(ctx, Uncached(cache.fetcher), templateName)
+	}
+
+	return fetcher.FetchFresh(templateName)
+}
+
+// Uncached returns a TemplateFetcher bypassing the cache of the fetcher, if it has one.
+func Uncached(fetcher TemplateFetcher) TemplateFetcher {
+	if freshFetcher, ok := fetcher.(FreshFetcher); ok {
+		return uncached{freshFetcher}
+	}
+
+	return fetcher
+}
@@ -0,0 +1,142 @@
+package storage
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// countingFetcher counts the fetches of each template from its memory storage.
+type countingFetcher struct {
+	*Memory
+	mu      sync.Mutex
+	fetches map[string]int
+}
+
+func newCountingFetcher(templates map[string]string) *countingFetcher {
+	fetcher := &countingFetcher{Memory: NewMemory(), fetches: make(map[string]int)}
+	for name, content := range templates {
+		fetcher.Set(name, []byte(content))
+	}
+
+	return fetcher
+}
+
+func (fetcher *countingFetcher) Fetch(templateName string) (string, error) {
+	fetcher.mu.Lock()
+	fetcher.fetches[templateName]++
+	fetcher.mu.Unlock()
+
+	return fetcher.Memory.Fetch(templateName)
+}
+
+func (fetcher *countingFetcher) Fetches(templateName string) int {
+	fetcher.mu.Lock()
+	defer fetcher.mu.Unlock()
+
+	return fetcher.fetches[templateName]
+}
+
+func TestCacheFetch(t *testing.T) {
+	tests := []struct {
+		name     string
+		ttl      time.Duration
+		template string
+		fetches  int
+		err      bool
+	}{
+		{name: "fetches a template once", ttl: time.Hour, template: "welcome.html.template", fetches: 1},
+		{name: "fetches an expired template again", ttl: -time.Second, template: "welcome.html.template", fetches: 3},
+		{name: "does not cache a missing template", ttl: time.Hour, template: "missing.html.template", fetches: 3, err: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fetcher := newCountingFetcher(map[string]string{"welcome.html.template": "<p>Hello</p>"})
+			cache := NewCache(fetcher, test.ttl)
+			for i := 0; i < 3; i++ {
+				content, err := cache.Fetch(test.template)
+				if test.err {
+					if !IsNotFound(err) {
+						t.Fatalf("expected a not found error, got %v", err)
+					}
+					continue
+				}
+				if err != nil || content != "<p>Hello</p>" {
+					t.Fatalf("expected the template content, got %q, %v", content, err)
+				}
+			}
+			if fetches := fetcher.Fetches(test.template); fetches != test.fetches {
+				t.Errorf("expected %d fetches, got %d", test.fetches, fetches)
+			}
+		})
+	}
+}
+
+func TestCacheMaxEntries(t *testing.T) {
+	fetcher := newCountingFetcher(map[string]string{"a": "A", "b": "B", "c": "C"})
+	cache := NewCache(fetcher, time.Hour)
+	cache.MaxEntries = 2
+
+	for _, name := range []string{"a", "b", "a", "c", "a", "b"} {
+		if _, err := cache.Fetch(name); err != nil {
+			t.Fatalf("unable to fetch %s: %s", name, err.Error())
+		}
+	}
+	// "b" was the least recently used template when "c" was cached.
+	expected := map[string]int{"a": 1, "b": 2, "c": 1}
+	for name, fetches := range expected {
+		if got := fetcher.Fetches(name); got != fetches {
+			t.Errorf("expected %s to be fetched %d times, got %d", name, fetches, got)
+		}
+	}
+}
+
+func TestUncached(t *testing.T) {
+	tests := []struct {
+		name   string
+		nested bool
+	}{
+		{name: "bypasses the cache"},
+		{name: "bypasses nested caches", nested: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fetcher := newCountingFetcher(map[string]string{"welcome.html.template": "v1"})
+			var cache TemplateFetcher = NewCache(fetcher, time.Hour)
+			if test.nested {
+				cache = NewCache(cache, time.Hour)
+			}
+			if content, _ := cache.Fetch("welcome.html.template"); content != "v1" {
+				t.Fatalf("expected v1, got %q", content)
+			}
+
+			fetcher.Set("welcome.html.template", []byte("v2"))
+			if content, _ := cache.Fetch("welcome.html.template"); content != "v1" {
+				t.Errorf("expected the cached v1, got %q", content)
+			}
+			if content, err := Uncached(cache).Fetch("welcome.html.template"); err != nil || content != "v2" {
+				t.Errorf("expected the fresh v2, got %q, %v", content, err)
+			}
+			if content, err := FetchContext(context.Background(), Uncached(cache), "welcome.html.template"); err != nil || content != "v2" {
+				t.Errorf("expected the fresh v2 with a context, got %q, %v", content, err)
+			}
+			// The fresh content is cached for the next reads.
+			if content, _ := cache.Fetch("welcome.html.template"); content != "v2" {
+				t.Errorf("expected the cache to be populated with v2, got %q", content)
+			}
+			if fetches := fetcher.Fetches("welcome.html.template"); fetches != 3 {
+				t.Errorf("expected 3 fetches, got %d", fetches)
+			}
+		})
+	}
+}
+
+func TestUncachedWithoutCache(t *testing.T) {
+	fetcher := NewMemory()
+	if Uncached(fetcher) != TemplateFetcher(fetcher) {
+		t.Errorf("expected a fetcher without cache to be used as-is")
+	}
+}
@@ -0,0 +1,39 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+)
+
+// ChainConnector resolves templates from an ordered chain of fetchers, ie: a per-tenant bucket then a shared defaults bucket. It implements the TemplateFetcher interface.
+type ChainConnector struct {
+	fetchers []TemplateFetcher
+}
+
+// NewChainConnector instanciates a ChainConnector trying the fetchers in the given order.
+func NewChainConnector(fetchers ...TemplateFetcher) *ChainConnector {
+	return &ChainConnector{fetchers: fetchers}
+}
+
+// Fetch returns the template content from the first fetcher having it, or the error of the last fetcher if none has it.
+// The error is a *NotFoundError when the last fetcher does not have the template. Any other error of a fetcher is returned
+// right away, so that a failing storage doesn't silently resolve the template from the next one.
+func (chainConnector *ChainConnector) Fetch(templateName string) (string, error) {
+	return chainConnector.FetchContext(context.Background(), templateName)
+}
+
+// FetchContext returns the template content as Fetch does, each fetcher being given the context.
+func (chainConnector *ChainConnector) FetchContext(ctx context.Context, templateName string) (string, error) {
+	err := fmt.Errorf("no template storage is configured")
+	for _, fetcher := range chainConnector.fetchers {
+		var content string
+		if content, err = FetchContext(ctx, fetcher, templateName); err == nil {
+			return content, nil
+		}
+		if !IsNotFound(err) {
+			return "", err
+		}
+	}
+
+	return "", err
+}
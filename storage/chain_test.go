@@ -0,0 +1,126 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// failingFetcher fails every fetch with its error.
+type failingFetcher struct {
+	err error
+}
+
+func (fetcher failingFetcher) Fetch(templateName string) (string, error) {
+	return "", fetcher.err
+}
+
+func TestChainConnectorFetch(t *testing.T) {
+	accessDenied := errors.New("access denied")
+	tests := []struct {
+		name     string
+		fetchers []map[string]string
+		failing  map[int]error
+		expected string
+		notFound bool
+		err      error
+		fetches  []int
+	}{
+		{
+			name:     "first hit",
+			fetchers: []map[string]string{{"welcome.html.template": "tenant"}, {"welcome.html.template": "shared"}},
+			expected: "tenant",
+			fetches:  []int{1, 0},
+		},
+		{
+			name:     "fallback hit",
+			fetchers: []map[string]string{{}, {"welcome.html.template": "shared"}},
+			expected: "shared",
+			fetches:  []int{1, 1},
+		},
+		{
+			name:     "fallback hit after several misses",
+			fetchers: []map[string]string{{}, {}, {"welcome.html.template": "defaults"}},
+			expected: "defaults",
+			fetches:  []int{1, 1, 1},
+		},
+		{
+			name:     "all miss",
+			fetchers: []map[string]string{{}, {}},
+			notFound: true,
+			fetches:  []int{1, 1},
+		},
+		{
+			name:     "last fetcher failing",
+			fetchers: []map[string]string{{}, nil},
+			failing:  map[int]error{1: accessDenied},
+			err:      accessDenied,
+			fetches:  []int{1, 0},
+		},
+		{
+			name:     "first fetcher failing",
+			fetchers: []map[string]string{nil, {"welcome.html.template": "shared"}},
+			failing:  map[int]error{0: accessDenied},
+			err:      accessDenied,
+			fetches:  []int{0, 0},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var fetchers []TemplateFetcher
+			counters := make([]*countingFetcher, len(test.fetchers))
+			for i, templates := range test.fetchers {
+				if err, ok := test.failing[i]; ok {
+					fetchers = append(fetchers, failingFetcher{err: err})
+					continue
+				}
+				counters[i] = newCountingFetcher(templates)
+				fetchers = append(fetchers, counters[i])
+			}
+
+			content, err := NewChainConnector(fetchers...).Fetch("welcome.html.template")
+			switch {
+			case test.notFound:
+				if !IsNotFound(err) {
+					t.Errorf("expected a not found error, got %v", err)
+				}
+			case test.err != nil:
+				if err != test.err {
+					t.Errorf("expected error %v, got %v", test.err, err)
+				}
+			case err != nil:
+				t.Fatalf("unexpected error: %s", err.Error())
+			case content != test.expected:
+				t.Errorf("expected %q, got %q", test.expected, content)
+			}
+			for i, counter := range counters {
+				if counter != nil && counter.Fetches("welcome.html.template") != test.fetches[i] {
+					t.Errorf("expected %d fetches from fetcher %d, got %d", test.fetches[i], i, counter.Fetches("welcome.html.template"))
+				}
+			}
+		})
+	}
+}
+
+func TestChainConnectorWithoutFetchers(t *testing.T) {
+	_, err := NewChainConnector().Fetch("welcome.html.template")
+	if err == nil || err.Error() != "no template storage is configured" {
+		t.Errorf("expected no template storage to be configured, got %v", err)
+	}
+}
+
+func TestChainConnectorCanceledContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	fallback := newCountingFetcher(map[string]string{"welcome.html.template": "shared"})
+	chain := NewChainConnector(NewMemory(), fallback)
+	if _, err := chain.FetchContext(ctx, "welcome.html.template"); err == nil || !strings.Contains(err.Error(), context.Canceled.Error()) {
+		t.Errorf("expected the context to be canceled, got %v", err)
+	}
+	if fetches := fallback.Fetches("welcome.html.template"); fetches != 0 {
+		t.Errorf("expected the fallback not to be fetched, got %d fetches", fetches)
+	}
+}
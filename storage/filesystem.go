@@ -0,0 +1,89 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// FileSystem handles getting template content and attachments from a local directory. It implements AttachmentCopier, TemplateFetcher and TemplateLister interfaces.
+type FileSystem struct {
+	root string
+}
+
+// resolve returns the path of the file in the directory, the name being cleaned so it can't escape the directory.
+func (fsConnector *FileSystem) resolve(name string) string {
+	return filepath.Join(fsConnector.root, filepath.FromSlash(path.Clean("/"+name)))
+}
+
+// Fetch reads the template content from its file in the directory.
+func (fsConnector *FileSystem) Fetch(templateName string) (string, error) {
+	content, err := ioutil.ReadFile(fsConnector.resolve(templateName))
+	if os.IsNotExist(err) {
+		return "", &NotFoundError{Name: templateName}
+	}
+	if err != nil {
+		return "", fmt.Errorf("unable to read template in directory %q: %s", fsConnector.root, err.Error())
+	}
+
+	log.Printf("Read template %s from file system storage", templateName)
+
+	return string(content), nil
+}
+
+// List walks the directory for the template files, returning their slash separated paths relative to it.
+func (fsConnector *FileSystem) List() ([]string, error) {
+	var names []string
+	err := filepath.Walk(fsConnector.root, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || !isTemplateFile(info.Name()) {
+			return nil
+		}
+		name, err := filepath.Rel(fsConnector.root, filePath)
+		if err != nil {
+			return err
+		}
+		names = append(names, filepath.ToSlash(name))
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list templates in directory %q: %s", fsConnector.root, err.Error())
+	}
+
+	return names, nil
+}
+
+// Copy reads the attachment file from the directory and copies it to attach it to an email.
+func (fsConnector *FileSystem) Copy(attachmentPath string, writer io.Writer) error {
+	file, err := os.Open(fsConnector.resolve(attachmentPath))
+	if err != nil {
+		return fmt.Errorf("unable to open attachment in directory %q: %s", fsConnector.root, err.Error())
+	}
+	defer file.Close()
+	log.Printf("Read attachment %s from file system storage", attachmentPath)
+
+	if _, err := io.Copy(writer, file); err != nil {
+		return fmt.Errorf("unable to read attachment %q: %s", attachmentPath, err.Error())
+	}
+
+	return nil
+}
+
+// NewFileSystem instanciates a FileSystem reading from the directory, which must exist.
+func NewFileSystem(root string) (*FileSystem, error) {
+	info, err := os.Stat(root)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open directory %q: %s", root, err.Error())
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%q is not a directory", root)
+	}
+
+	return &FileSystem{root: root}, nil
+}
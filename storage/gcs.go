@@ -0,0 +1,225 @@
+package storage
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// gcsReadScope is the OAuth scope allowing to read the objects of Google Cloud Storage.
+	gcsReadScope = "https://www.googleapis.com/auth/devstorage.read_only"
+	// gcsMetadataTokenURL is the token endpoint of the metadata server, used when no service account key is configured.
+	gcsMetadataTokenURL = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+)
+
+// serviceAccountKey is the part of a Google service account key file needed to sign token requests.
+type serviceAccountKey struct {
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+}
+
+// GCS handles getting template content from Google Cloud Storage buckets. It implements TemplateFetcher interface.
+type GCS struct {
+	bucket     string
+	account    *serviceAccountKey
+	signingKey *rsa.PrivateKey
+	httpClient *http.Client
+	mu         sync.Mutex
+	token      string
+	expires    time.Time
+}
+
+// signedAssertion returns the JWT exchanged against an access token, signed with the service account key.
+func (gcsConnector *GCS) signedAssertion(now time.Time) (string, error) {
+	encode := func(value interface{}) (string, error) {
+		encoded, err := json.Marshal(value)
+		return base64.RawURLEncoding.EncodeToString(encoded), err
+	}
+
+	header, err := encode(map[string]string{"alg": "RS256", "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	claims, err := encode(map[string]interface{}{
+		"iss":   gcsConnector.account.ClientEmail,
+		"scope": gcsReadScope,
+		"aud":   gcsConnector.account.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	digest := sha256.Sum256([]byte(header + "." + claims))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, gcsConnector.signingKey, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+
+	return header + "." + claims + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// requestToken asks a new access token, to the token endpoint of the service account, or to the metadata server when there is none.
+func (gcsConnector *GCS) requestToken(now time.Time) (*http.Request, error) {
+	if gcsConnector.account == nil {
+		request, err := http.NewRequest(http.MethodGet, gcsMetadataTokenURL, nil)
+		if err == nil {
+			request.Header.Set("Metadata-Flavor", "Google")
+		}
+		return request, err
+	}
+
+	assertion, err := gcsConnector.signedAssertion(now)
+	if err != nil {
+		return nil, fmt.Errorf("unable to sign token request: %s", err.Error())
+	}
+	form := url.Values{"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"}, "assertion": {assertion}}
+	request, err := http.NewRequest(http.MethodPost, gcsConnector.account.TokenURI, strings.NewReader(form.Encode()))
+	if err == nil {
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+
+	return request, err
+}
+
+// accessToken returns the cached access token, requesting a new one shortly before it expires.
+func (gcsConnector *GCS) accessToken() (string, error) {
+	gcsConnector.mu.Lock()
+	defer gcsConnector.mu.Unlock()
+
+	now := time.Now()
+	if gcsConnector.token != "" && now.Before(gcsConnector.expires) {
+		return gcsConnector.token, nil
+	}
+
+	request, err := gcsConnector.requestToken(now)
+	if err != nil {
+		return "", err
+	}
+	response, err := gcsConnector.httpClient.Do(request)
+	if err != nil {
+		return "", fmt.Errorf("unable to get access token: %s", err.Error())
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unable to get access token: %s", response.Status)
+	}
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&token); err != nil {
+		return "", fmt.Errorf("unable to decode access token: %s", err.Error())
+	}
+	gcsConnector.token = token.AccessToken
+	gcsConnector.expires = now.Add(time.Duration(token.ExpiresIn)*time.Second - time.Minute)
+
+	return gcsConnector.token, nil
+}
+
+// Fetch downloads the template content by its name from the GCS bucket.
+func (gcsConnector *GCS) Fetch(templateName string) (string, error) {
+	return gcsConnector.FetchContext(context.Background(), templateName)
+}
+
+// FetchContext downloads the template content as Fetch does, the request being canceled once the context is done.
+func (gcsConnector *GCS) FetchContext(ctx context.Context, templateName string) (string, error) {
+	token, err := gcsConnector.accessToken()
+	if err != nil {
+		return "", err
+	}
+
+	objectURL := "https://storage.googleapis.com/storage/v1/b/" + url.PathEscape(gcsConnector.bucket) + "/o/" + url.PathEscape(templateName) + "?alt=media"
+	request, err := http.NewRequest(http.MethodGet, objectURL, nil)
+	if err != nil {
+		return "", fmt.Errorf("unable to build template request: %s", err.Error())
+	}
+	request = request.WithContext(ctx)
+	request.Header.Set("Authorization", "Bearer "+token)
+
+	response, err := gcsConnector.httpClient.Do(request)
+	if err != nil {
+		return "", fmt.Errorf("unable to get item in bucket %q: %s", gcsConnector.bucket, err.Error())
+	}
+	defer response.Body.Close()
+	if response.StatusCode == http.StatusNotFound {
+		return "", &NotFoundError{Name: templateName}
+	}
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unable to get item in bucket %q: %s", gcsConnector.bucket, response.Status)
+	}
+
+	content, err := ioutil.ReadAll(io.LimitReader(response.Body, maxTemplateBytes))
+	if err != nil {
+		return "", fmt.Errorf("unable to read template %q: %s", templateName, err.Error())
+	}
+	log.Printf("Downloaded template %s from GCS storage", templateName)
+
+	return string(content), nil
+}
+
+// parsePrivateKey decodes the PEM encoded RSA key of a service account, in PKCS #8 or PKCS #1 form.
+func parsePrivateKey(encoded string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(encoded))
+	if block == nil {
+		return nil, fmt.Errorf("private key is not PEM encoded")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse private key: %s", err.Error())
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("private key is not an RSA key")
+	}
+
+	return rsaKey, nil
+}
+
+// NewGCS instanciates a GCS connector for the bucket, authenticated with the service account key file,
+// or with the service account of the instance from the metadata server when credentialsFile is empty.
+func NewGCS(bucket string, credentialsFile string) (*GCS, error) {
+	gcsConnector := &GCS{bucket: bucket, httpClient: &http.Client{Timeout: 30 * time.Second}}
+	if credentialsFile == "" {
+		return gcsConnector, nil
+	}
+
+	content, err := ioutil.ReadFile(credentialsFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read credentials file: %s", err.Error())
+	}
+	gcsConnector.account = &serviceAccountKey{}
+	if err := json.Unmarshal(content, gcsConnector.account); err != nil {
+		return nil, fmt.Errorf("unable to decode credentials file: %s", err.Error())
+	}
+	if gcsConnector.account.TokenURI == "" {
+		gcsConnector.account.TokenURI = "https://oauth2.googleapis.com/token"
+	}
+	if gcsConnector.signingKey, err = parsePrivateKey(gcsConnector.account.PrivateKey); err != nil {
+		return nil, fmt.Errorf("invalid credentials file: %s", err.Error())
+	}
+
+	return gcsConnector, nil
+}
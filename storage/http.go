@@ -0,0 +1,92 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// maxTemplateBytes bounds the size of a downloaded template, so a misbehaving endpoint can't exhaust the lambda memory.
+const maxTemplateBytes = 10 << 20
+
+// HTTP handles getting template content from an HTTP endpoint, ie: a template service or a CDN. It implements TemplateFetcher interface.
+type HTTP struct {
+	baseURL     string
+	headerName  string
+	headerValue string
+	httpClient  *http.Client
+}
+
+// templateURL returns the URL of the template, each segment of its name being escaped.
+func (httpConnector *HTTP) templateURL(templateName string) string {
+	segments := strings.Split(templateName, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+
+	return httpConnector.baseURL + "/" + strings.Join(segments, "/")
+}
+
+// Fetch downloads the template content from its URL under the base URL.
+func (httpConnector *HTTP) Fetch(templateName string) (string, error) {
+	return httpConnector.FetchContext(context.Background(), templateName)
+}
+
+// FetchContext downloads the template content as Fetch does, the request being canceled once the context is done.
+func (httpConnector *HTTP) FetchContext(ctx context.Context, templateName string) (string, error) {
+	request, err := http.NewRequest(http.MethodGet, httpConnector.templateURL(templateName), nil)
+	if err != nil {
+		return "", fmt.Errorf("unable to build template request: %s", err.Error())
+	}
+	request = request.WithContext(ctx)
+	if httpConnector.headerName != "" {
+		request.Header.Set(httpConnector.headerName, httpConnector.headerValue)
+	}
+
+	response, err := httpConnector.httpClient.Do(request)
+	if err != nil {
+		return "", fmt.Errorf("unable to get template from %q: %s", httpConnector.baseURL, err.Error())
+	}
+	defer response.Body.Close()
+	if response.StatusCode == http.StatusNotFound {
+		return "", &NotFoundError{Name: templateName}
+	}
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return "", fmt.Errorf("unable to get template from %q: %s", httpConnector.baseURL, response.Status)
+	}
+
+	content, err := ioutil.ReadAll(io.LimitReader(response.Body, maxTemplateBytes))
+	if err != nil {
+		return "", fmt.Errorf("unable to read template %q: %s", templateName, err.Error())
+	}
+	log.Printf("Downloaded template %s from HTTP storage", templateName)
+
+	return string(content), nil
+}
+
+// NewHTTP instanciates an HTTP connector fetching the templates under the base URL. The optional auth header, ie: "Authorization: Bearer ...",
+// is sent with every request.
+func NewHTTP(baseURL string, authHeader string, timeout time.Duration) (*HTTP, error) {
+	parsedURL, err := url.Parse(baseURL)
+	if err != nil || (parsedURL.Scheme != "http" && parsedURL.Scheme != "https") || parsedURL.Host == "" {
+		return nil, fmt.Errorf("%q is not a valid HTTP URL", baseURL)
+	}
+
+	httpConnector := &HTTP{baseURL: strings.TrimSuffix(baseURL, "/"), httpClient: &http.Client{Timeout: timeout}}
+	if authHeader != "" {
+		separator := strings.Index(authHeader, ":")
+		if separator <= 0 {
+			return nil, fmt.Errorf("auth header %q is invalid, expecting \"Name: value\"", authHeader)
+		}
+		httpConnector.headerName = strings.TrimSpace(authHeader[:separator])
+		httpConnector.headerValue = strings.TrimSpace(authHeader[separator+1:])
+	}
+
+	return httpConnector, nil
+}
@@ -1,15 +1,95 @@
 package storage
 
-import "io"
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
 
 // TemplateFetcher interface should be implemented by any service responsible to get template content from a storage manager (FS, S3 TemplateBucket, Redis... etc).
 type TemplateFetcher interface {
-	// Fetch should return the content of the template as string
+	// Fetch should return the content of the template as string, or a *NotFoundError if the template does not exist.
 	Fetch(templateName string) (string, error)
 }
 
+// ContextFetcher interface should be implemented by any template storage able to cancel a fetch once its context is done, ie: at the lambda deadline.
+type ContextFetcher interface {
+	// FetchContext should fetch the template as Fetch does, failing once the context is done.
+	FetchContext(ctx context.Context, templateName string) (string, error)
+}
+
+// FetchContext fetches the template with the context when the fetcher supports it, and fails without fetching when the context is already done.
+func FetchContext(ctx context.Context, fetcher TemplateFetcher, templateName string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", fmt.Errorf("unable to fetch template %s: %s", templateName, err.Error())
+	}
+	if contextFetcher, ok := fetcher.(ContextFetcher); ok {
+		return contextFetcher.FetchContext(ctx, templateName)
+	}
+
+	return fetcher.Fetch(templateName)
+}
+
+// TemplateLister interface should be implemented by any template storage able to enumerate its templates, ie: to validate them all.
+type TemplateLister interface {
+	// List should return the names of every stored template, partials included.
+	List() ([]string, error)
+}
+
+// templateExtensions are the extensions of the template files listed by a TemplateLister, Go templates and Mustache ones.
+var templateExtensions = []string{".template", ".mustache"}
+
+// isTemplateFile tells if the file name has the extension of a template.
+func isTemplateFile(name string) bool {
+	for _, extension := range templateExtensions {
+		if strings.HasSuffix(name, extension) {
+			return true
+		}
+	}
+
+	return false
+}
+
 // AttachmentCopier interface should be implemented by any service responsible to get attachment files from a storage manager (FS, S3 TemplateBucket, Redis... etc).
 type AttachmentCopier interface {
 	// Copy should, as expected, copy the attachment file to the provided io.Writer.
 	Copy(attachmentPath string, writer io.Writer) error
 }
+
+// ObjectWriter interface should be implemented by any storage able to store generated files, ie: the previews of dry run messages.
+type ObjectWriter interface {
+	// Put should store the content under the key, replacing any existing object.
+	Put(key string, content io.Reader, contentType string) error
+}
+
+// BucketSwitcher interface should be implemented by bucket based storages able to read attachments from other buckets.
+type BucketSwitcher interface {
+	// InBucket should return the same storage, reading from the given bucket.
+	InBucket(bucket string) AttachmentCopier
+}
+
+// NotFoundError is returned by connectors when the requested object does not exist in the storage.
+type NotFoundError struct {
+	Name string
+}
+
+func (e *NotFoundError) Error() string {
+	return fmt.Sprintf("object %q not found", e.Name)
+}
+
+// IsNotFound tells if the error returned by a connector means the requested object does not exist.
+func IsNotFound(err error) bool {
+	_, ok := err.(*NotFoundError)
+
+	return ok
+}
+
+// AttachmentLinker interface should be implemented by any storage able to share attachment files through temporary download links.
+type AttachmentLinker interface {
+	// Size should return the size in bytes of the attachment file.
+	Size(attachmentPath string) (int64, error)
+	// Link should return a URL allowing to download the attachment file until the expiry delay is elapsed.
+	Link(attachmentPath string, expiry time.Duration) (string, error)
+}
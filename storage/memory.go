@@ -0,0 +1,110 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Memory keeps the templates, attachments and written objects in memory, so the hermes pipeline can be tested without a real storage.
+// Listing and reading it is deterministic. It implements the TemplateFetcher, TemplateLister, AttachmentCopier and ObjectWriter interfaces.
+type Memory struct {
+	mu      sync.RWMutex
+	objects map[string][]byte
+}
+
+// Set stores the content under the name, ie: a template as "welcome.html.template" or an attachment as "invoices/42.pdf".
+func (memory *Memory) Set(name string, content []byte) {
+	memory.mu.Lock()
+	defer memory.mu.Unlock()
+
+	memory.objects[name] = append([]byte(nil), content...)
+}
+
+// Get returns the content stored under the name, ie: a written preview, telling if there is one.
+func (memory *Memory) Get(name string) ([]byte, bool) {
+	memory.mu.RLock()
+	defer memory.mu.RUnlock()
+
+	content, ok := memory.objects[name]
+
+	return append([]byte(nil), content...), ok
+}
+
+// Delete removes the content stored under the name, if any.
+func (memory *Memory) Delete(name string) {
+	memory.mu.Lock()
+	defer memory.mu.Unlock()
+
+	delete(memory.objects, name)
+}
+
+// Keys returns the sorted names of the stored contents starting with the prefix.
+func (memory *Memory) Keys(prefix string) []string {
+	memory.mu.RLock()
+	defer memory.mu.RUnlock()
+
+	var keys []string
+	for name := range memory.objects {
+		if strings.HasPrefix(name, prefix) {
+			keys = append(keys, name)
+		}
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// Fetch returns the content of the template, or a *NotFoundError if it is not stored.
+func (memory *Memory) Fetch(templateName string) (string, error) {
+	content, ok := memory.Get(templateName)
+	if !ok {
+		return "", &NotFoundError{Name: templateName}
+	}
+
+	return string(content), nil
+}
+
+// List returns the sorted names of the stored template files.
+func (memory *Memory) List() ([]string, error) {
+	var names []string
+	for _, name := range memory.Keys("") {
+		if isTemplateFile(name) {
+			names = append(names, name)
+		}
+	}
+
+	return names, nil
+}
+
+// Copy copies the stored attachment file to the writer.
+func (memory *Memory) Copy(attachmentPath string, writer io.Writer) error {
+	content, ok := memory.Get(attachmentPath)
+	if !ok {
+		return fmt.Errorf("unable to read attachment %q: %s", attachmentPath, (&NotFoundError{Name: attachmentPath}).Error())
+	}
+	if _, err := writer.Write(content); err != nil {
+		return fmt.Errorf("unable to copy attachment %q: %s", attachmentPath, err.Error())
+	}
+
+	return nil
+}
+
+// Put stores the content under the key, replacing any existing one. The content type is not kept.
+func (memory *Memory) Put(key string, content io.Reader, contentType string) error {
+	var buf bytes.Buffer
+	if _, err := buf.ReadFrom(content); err != nil {
+		return fmt.Errorf("unable to read content of object %q: %s", key, err.Error())
+	}
+	memory.Set(key, buf.Bytes())
+
+	return nil
+}
+
+// NewMemory instanciates an empty Memory storage.
+func NewMemory() *Memory {
+	return &Memory{objects: make(map[string][]byte)}
+}
@@ -0,0 +1,24 @@
+package storage
+
+import "context"
+
+// PrefixConnector reads the templates of a fetcher under a key prefix, ie: the brand-a/ folder of a bucket shared by several tenants. It implements the TemplateFetcher interface.
+type PrefixConnector struct {
+	fetcher TemplateFetcher
+	prefix  string
+}
+
+// NewPrefixConnector instanciates a PrefixConnector reading the templates of the fetcher under the prefix.
+func NewPrefixConnector(fetcher TemplateFetcher, prefix string) *PrefixConnector {
+	return &PrefixConnector{fetcher: fetcher, prefix: prefix}
+}
+
+// Fetch returns the content of the prefixed template.
+func (prefixConnector *PrefixConnector) Fetch(templateName string) (string, error) {
+	return prefixConnector.fetcher.Fetch(prefixConnector.prefix + templateName)
+}
+
+// FetchContext returns the content of the prefixed template, giving the context to the fetcher.
+func (prefixConnector *PrefixConnector) FetchContext(ctx context.Context, templateName string) (string, error) {
+	return FetchContext(ctx, prefixConnector.fetcher, prefixConnector.prefix+templateName)
+}
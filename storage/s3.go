@@ -2,23 +2,55 @@ package storage
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"io"
 	"log"
+	"net/http"
+	"time"
 )
 
-// S3 handles getting template content from AWS S3 buckets. It implements both AttachmentCopier and TemplateFetcher interfaces.
+// S3 handles getting template content from AWS S3 buckets. It implements AttachmentCopier, AttachmentLinker, BucketSwitcher, ObjectWriter, TemplateFetcher and TemplateLister interfaces.
 type S3 struct {
 	bucket   string
 	s3Client *s3.S3
+	// DeniedAsNotFound makes the templates whose download is denied missing, as S3 denies the access to a missing object rather than
+	// replying NoSuchKey when the role lacks the s3:ListBucket permission. A template denied for other reasons is then missing too.
+	DeniedAsNotFound bool
+}
+
+// isNotFound tells if a GetObject error means the object is missing: a NoSuchKey error, or an access denied one when DeniedAsNotFound is set.
+func (s3Connector *S3) isNotFound(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	if !ok {
+		return false
+	}
+	if aerr.Code() == s3.ErrCodeNoSuchKey {
+		return true
+	}
+	if !s3Connector.DeniedAsNotFound {
+		return false
+	}
+	requestErr, ok := err.(awserr.RequestFailure)
+
+	return aerr.Code() == "AccessDenied" || (ok && requestErr.StatusCode() == http.StatusForbidden)
 }
 
 // Fetch the template content by it's name from the S3 TemplateBucket and returns content.
 func (s3Connector *S3) Fetch(templateName string) (string, error) {
-	templateS3Object, err := s3Connector.s3Client.GetObject(&s3.GetObjectInput{Bucket: aws.String(s3Connector.bucket), Key: &templateName})
+	return s3Connector.FetchContext(context.Background(), templateName)
+}
+
+// FetchContext fetches the template content as Fetch does, the request being canceled once the context is done.
+func (s3Connector *S3) FetchContext(ctx context.Context, templateName string) (string, error) {
+	templateS3Object, err := s3Connector.s3Client.GetObjectWithContext(ctx, &s3.GetObjectInput{Bucket: aws.String(s3Connector.bucket), Key: &templateName})
+	if s3Connector.isNotFound(err) {
+		return "", &NotFoundError{Name: templateName}
+	}
 	if err != nil {
 		return "", fmt.Errorf("unable to get item in bucket %q: %s", s3Connector.bucket, err.Error())
 	}
@@ -34,6 +66,24 @@ func (s3Connector *S3) Fetch(templateName string) (string, error) {
 	return buf.String(), nil
 }
 
+// List returns the keys of the template objects of the bucket.
+func (s3Connector *S3) List() ([]string, error) {
+	var names []string
+	err := s3Connector.s3Client.ListObjectsV2Pages(&s3.ListObjectsV2Input{Bucket: aws.String(s3Connector.bucket)}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, object := range page.Contents {
+			if key := aws.StringValue(object.Key); isTemplateFile(key) {
+				names = append(names, key)
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list items in bucket %q: %s", s3Connector.bucket, err.Error())
+	}
+
+	return names, nil
+}
+
 // Copy fetches attachment content by it's name from the S3 bucket and copies it to attach it to an email.
 func (s3Connector *S3) Copy(attachmentPath string, writer io.Writer) error {
 	attachmentS3Object, err := s3Connector.s3Client.GetObject(&s3.GetObjectInput{Bucket: aws.String(s3Connector.bucket), Key: &attachmentPath})
@@ -50,6 +100,52 @@ func (s3Connector *S3) Copy(attachmentPath string, writer io.Writer) error {
 	return nil
 }
 
+// Size returns the size in bytes of the attachment object, without downloading it.
+func (s3Connector *S3) Size(attachmentPath string) (int64, error) {
+	head, err := s3Connector.s3Client.HeadObject(&s3.HeadObjectInput{Bucket: aws.String(s3Connector.bucket), Key: &attachmentPath})
+	if err != nil {
+		return 0, fmt.Errorf("unable to get item metadata in bucket %q: %s", s3Connector.bucket, err.Error())
+	}
+
+	return aws.Int64Value(head.ContentLength), nil
+}
+
+// Link returns a presigned URL allowing to download the attachment object until the expiry delay is elapsed.
+func (s3Connector *S3) Link(attachmentPath string, expiry time.Duration) (string, error) {
+	request, _ := s3Connector.s3Client.GetObjectRequest(&s3.GetObjectInput{Bucket: aws.String(s3Connector.bucket), Key: &attachmentPath})
+	url, err := request.Presign(expiry)
+	if err != nil {
+		return "", fmt.Errorf("unable to presign item in bucket %q: %s", s3Connector.bucket, err.Error())
+	}
+
+	return url, nil
+}
+
+// Put uploads the content as an object of the S3 bucket.
+func (s3Connector *S3) Put(key string, content io.Reader, contentType string) error {
+	buf := new(bytes.Buffer)
+	if _, err := buf.ReadFrom(content); err != nil {
+		return fmt.Errorf("unable to read content of object %q: %s", key, err.Error())
+	}
+
+	_, err := s3Connector.s3Client.PutObject(&s3.PutObjectInput{
+		Bucket:      aws.String(s3Connector.bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(buf.Bytes()),
+		ContentType: aws.String(contentType),
+	})
+	if err != nil {
+		return fmt.Errorf("unable to put item in bucket %q: %s", s3Connector.bucket, err.Error())
+	}
+
+	return nil
+}
+
+// InBucket returns an S3 connector of the given bucket, sharing the client of this one.
+func (s3Connector *S3) InBucket(bucket string) AttachmentCopier {
+	return &S3{bucket: bucket, s3Client: s3Connector.s3Client, DeniedAsNotFound: s3Connector.DeniedAsNotFound}
+}
+
 // NewS3 instanciates an S3 with the AWS Session and AWS S3 Client
 func NewS3(bucket string, region string) (*S3, error) {
 	p := new(S3)
@@ -65,7 +161,7 @@ func NewS3(bucket string, region string) (*S3, error) {
 
 	p.s3Client = s3.New(sess)
 
-	fmt.Printf("Connected to S3 storage bucket %s", bucket)
+	log.Printf("Connected to S3 storage bucket %s", bucket)
 
 	return p, nil
 }
@@ -0,0 +1,152 @@
+package storage
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// newTestS3 returns an S3 connector to the bucket of the endpoint, with static credentials.
+func newTestS3(t *testing.T, endpoint string, bucket string) *S3 {
+	t.Helper()
+
+	sess, err := session.NewSession(&aws.Config{
+		Region:           aws.String("eu-west-1"),
+		Endpoint:         aws.String(endpoint),
+		S3ForcePathStyle: aws.Bool(true),
+		Credentials:      credentials.NewStaticCredentials("AKIDEXAMPLE", "secret", ""),
+	})
+	if err != nil {
+		t.Fatalf("unable to instantiate session: %s", err.Error())
+	}
+
+	return &S3{bucket: bucket, s3Client: s3.New(sess)}
+}
+
+func TestS3Link(t *testing.T) {
+	tests := []struct {
+		name    string
+		key     string
+		expiry  time.Duration
+		path    string
+		expires string
+	}{
+		{name: "links the attachment", key: "invoices/42.pdf", expiry: 15 * time.Minute, path: "/attachments/invoices/42.pdf", expires: "900"},
+		{name: "links with the expiry", key: "reports/2020.zip", expiry: 7 * 24 * time.Hour, path: "/attachments/reports/2020.zip", expires: "604800"},
+		{name: "escapes the key", key: "invoices/été 42.pdf", expiry: time.Hour, path: "/attachments/invoices/été 42.pdf", expires: "3600"},
+	}
+
+	connector := newTestS3(t, "https://s3.example.com", "attachments")
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			link, err := connector.Link(test.key, test.expiry)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+			parsed, err := url.Parse(link)
+			if err != nil {
+				t.Fatalf("expected a URL, got %q", link)
+			}
+			if parsed.Scheme != "https" || parsed.Host != "s3.example.com" || parsed.Path != test.path {
+				t.Errorf("expected a link to %s, got %s", test.path, link)
+			}
+			query := parsed.Query()
+			if query.Get("X-Amz-Expires") != test.expires {
+				t.Errorf("expected the link to expire after %s seconds, got %s", test.expires, query.Get("X-Amz-Expires"))
+			}
+			if query.Get("X-Amz-Signature") == "" || query.Get("X-Amz-Credential") == "" {
+				t.Errorf("expected a presigned link, got %s", link)
+			}
+		})
+	}
+}
+
+func TestS3Size(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodHead {
+			http.Error(w, "unexpected method", http.StatusMethodNotAllowed)
+			return
+		}
+		if r.URL.Path != "/attachments/invoices/42.pdf" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Length", "10485760")
+	}))
+	defer server.Close()
+	connector := newTestS3(t, server.URL, "attachments")
+
+	size, err := connector.Size("invoices/42.pdf")
+	if err != nil || size != 10485760 {
+		t.Errorf("expected a 10485760 bytes attachment, got %d, %v", size, err)
+	}
+	if _, err := connector.Size("invoices/43.pdf"); err == nil {
+		t.Errorf("expected a missing attachment to fail")
+	}
+}
+
+func TestS3FetchNotFound(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/xml")
+		switch r.URL.Path {
+		case "/templates/welcome.html.template":
+			w.Write([]byte("<p>Hello {{.name}}</p>"))
+		case "/templates/missing.html.template":
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?><Error><Code>NoSuchKey</Code><Message>The specified key does not exist.</Message></Error>`))
+		case "/templates/denied.html.template":
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?><Error><Code>AccessDenied</Code><Message>Access Denied</Message></Error>`))
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?><Error><Code>InternalError</Code><Message>We encountered an internal error.</Message></Error>`))
+		}
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name             string
+		template         string
+		deniedAsNotFound bool
+		content          string
+		notFound         bool
+		err              bool
+	}{
+		{name: "fetches a template", template: "welcome.html.template", content: "<p>Hello {{.name}}</p>"},
+		{name: "misses a template which doesn't exist", template: "missing.html.template", notFound: true},
+		{name: "fails a denied template", template: "denied.html.template", err: true},
+		{name: "misses a denied template when configured", template: "denied.html.template", deniedAsNotFound: true, notFound: true},
+		{name: "fails on a server error", template: "failing.html.template", deniedAsNotFound: true, err: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			connector := newTestS3(t, server.URL, "templates")
+			connector.DeniedAsNotFound = test.deniedAsNotFound
+			// The S3 client retries the server errors.
+			connector.s3Client.Config.MaxRetries = aws.Int(0)
+
+			content, err := connector.Fetch(test.template)
+			switch {
+			case test.notFound:
+				if !IsNotFound(err) {
+					t.Errorf("expected a not found error, got %v", err)
+				}
+			case test.err:
+				if err == nil || IsNotFound(err) {
+					t.Errorf("expected a failure other than not found, got %v", err)
+				}
+			default:
+				if err != nil || content != test.content {
+					t.Errorf("expected %q, got %q, %v", test.content, content, err)
+				}
+			}
+		})
+	}
+}
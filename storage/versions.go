@@ -0,0 +1,72 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// LatestVersion is the alias of the version a versioned template resolves to when it is not pinned, ie: "welcome@latest".
+const LatestVersion = "latest"
+
+// templateVersion matches the versions a template may be pinned to, ie: "v3" or "2020-05-01".
+var templateVersion = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// VersionedConnector resolves the versioned template keys of a fetcher, ie: "welcome@v3.fr.html.template" reads "welcome/v3/welcome.fr.html.template",
+// so a producer can pin a known good version of a template while a new one is rolled out. The "latest" version reads the version
+// stored in the "welcome/latest" alias object. Unversioned keys are read as is. It implements the TemplateFetcher interface.
+type VersionedConnector struct {
+	fetcher TemplateFetcher
+}
+
+// NewVersionedConnector instanciates a VersionedConnector resolving the versioned templates of the fetcher.
+func NewVersionedConnector(fetcher TemplateFetcher) *VersionedConnector {
+	return &VersionedConnector{fetcher: fetcher}
+}
+
+// resolve returns the storage key of a template key, resolving the latest alias of its template when it is not pinned.
+func (versionedConnector *VersionedConnector) resolve(templateName string) (string, error) {
+	separator := strings.Index(templateName, "@")
+	if separator < 0 {
+		return templateName, nil
+	}
+
+	name := templateName[:separator]
+	version := templateName[separator+1:]
+	suffix := ""
+	if dot := strings.Index(version, "."); dot >= 0 {
+		version, suffix = version[:dot], version[dot:]
+	}
+	if version == LatestVersion {
+		latest, err := versionedConnector.fetcher.Fetch(name + "/" + LatestVersion)
+		if IsNotFound(err) {
+			return "", fmt.Errorf("template %q has no %s version alias: %s", name, LatestVersion, err.Error())
+		}
+		if err != nil {
+			return "", fmt.Errorf("unable to read %s version of template %q: %s", LatestVersion, name, err.Error())
+		}
+		version = strings.TrimSpace(latest)
+	}
+	if !templateVersion.MatchString(version) || version == LatestVersion {
+		return "", fmt.Errorf("template %q has an invalid version %q", name, version)
+	}
+
+	return fmt.Sprintf("%s/%s/%s%s", name, version, path.Base(name), suffix), nil
+}
+
+// Fetch returns the content of the template, reading the resolved key of versioned templates.
+func (versionedConnector *VersionedConnector) Fetch(templateName string) (string, error) {
+	return versionedConnector.FetchContext(context.Background(), templateName)
+}
+
+// FetchContext returns the content of the template as Fetch does, giving the context to the fetcher.
+func (versionedConnector *VersionedConnector) FetchContext(ctx context.Context, templateName string) (string, error) {
+	key, err := versionedConnector.resolve(templateName)
+	if err != nil {
+		return "", err
+	}
+
+	return FetchContext(ctx, versionedConnector.fetcher, key)
+}
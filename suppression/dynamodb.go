@@ -0,0 +1,88 @@
+package suppression
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"time"
+)
+
+// batchGetLimit is the maximum number of keys of a DynamoDB BatchGetItem request.
+const batchGetLimit = 100
+
+// DynamoDB looks the addresses up in a DynamoDB table whose partition key is the "id" string attribute, holding the lowercased suppressed addresses.
+// It implements the List and Recorder interfaces.
+type DynamoDB struct {
+	table          string
+	dynamoDBClient dynamodbiface.DynamoDBAPI
+}
+
+// Suppressed returns the addresses having an item in the table. The keys DynamoDB leaves unprocessed, ie: when throttled, are requested again.
+func (dynamoDBList *DynamoDB) Suppressed(addresses []string) ([]string, error) {
+	byKey := make(map[string]string, len(addresses))
+	var keys []map[string]*dynamodb.AttributeValue
+	for _, address := range addresses {
+		key := normalize(address)
+		if _, ok := byKey[key]; ok {
+			continue
+		}
+		byKey[key] = address
+		keys = append(keys, map[string]*dynamodb.AttributeValue{"id": {S: aws.String(key)}})
+	}
+
+	var suppressed []string
+	for len(keys) > 0 {
+		count := len(keys)
+		if count > batchGetLimit {
+			count = batchGetLimit
+		}
+		output, err := dynamoDBList.dynamoDBClient.BatchGetItem(&dynamodb.BatchGetItemInput{
+			RequestItems: map[string]*dynamodb.KeysAndAttributes{
+				dynamoDBList.table: {Keys: keys[:count], ProjectionExpression: aws.String("id")},
+			},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("unable to look addresses up in table %q: %s", dynamoDBList.table, err.Error())
+		}
+		for _, item := range output.Responses[dynamoDBList.table] {
+			if id := item["id"]; id != nil {
+				suppressed = append(suppressed, byKey[aws.StringValue(id.S)])
+			}
+		}
+		keys = keys[count:]
+		if unprocessed, ok := output.UnprocessedKeys[dynamoDBList.table]; ok {
+			keys = append(keys, unprocessed.Keys...)
+		}
+	}
+
+	return suppressed, nil
+}
+
+// Suppress puts the item of the address, with its reason and suppressed_at date. An address suppressed again gets the latest reason.
+func (dynamoDBList *DynamoDB) Suppress(address string, reason string, at time.Time) error {
+	_, err := dynamoDBList.dynamoDBClient.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(dynamoDBList.table),
+		Item: map[string]*dynamodb.AttributeValue{
+			"id":            {S: aws.String(normalize(address))},
+			"reason":        {S: aws.String(reason)},
+			"suppressed_at": {S: aws.String(at.UTC().Format(time.RFC3339))},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("unable to suppress address in table %q: %s", dynamoDBList.table, err.Error())
+	}
+
+	return nil
+}
+
+// NewDynamoDB instanciates a DynamoDB suppression list using the given table.
+func NewDynamoDB(table string, region string) (*DynamoDB, error) {
+	sess, err := session.NewSession(&aws.Config{Region: aws.String(region)})
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to AWS: %s", err.Error())
+	}
+
+	return &DynamoDB{table: table, dynamoDBClient: dynamodb.New(sess)}, nil
+}
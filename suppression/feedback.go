@@ -0,0 +1,74 @@
+package suppression
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// sesRecipient is a recipient of an SES bounce or complaint notification.
+type sesRecipient struct {
+	EmailAddress string `json:"emailAddress"`
+}
+
+// sesNotification is a bounce, complaint or delivery notification published by Amazon SES, either by its identity notifications,
+// which set notificationType, or by a configuration set event destination, which sets eventType.
+type sesNotification struct {
+	NotificationType string `json:"notificationType"`
+	EventType        string `json:"eventType"`
+	Bounce           *struct {
+		BounceType        string         `json:"bounceType"`
+		BounceSubType     string         `json:"bounceSubType"`
+		BouncedRecipients []sesRecipient `json:"bouncedRecipients"`
+	} `json:"bounce"`
+	Complaint *struct {
+		ComplaintFeedbackType string         `json:"complaintFeedbackType"`
+		ComplainedRecipients  []sesRecipient `json:"complainedRecipients"`
+	} `json:"complaint"`
+}
+
+// Feedback lists the addresses an SES notification asks to suppress, with the reason, ie: bounce:Permanent:General or complaint:abuse.
+type Feedback struct {
+	Addresses []string
+	Reason    string
+}
+
+// ParseSESNotification returns the addresses to suppress of an SES notification: those of permanent bounces and of complaints.
+// Transient bounces and other notifications, ie: deliveries, suppress no address.
+func ParseSESNotification(message string) (*Feedback, error) {
+	var notification sesNotification
+	if err := json.Unmarshal([]byte(message), &notification); err != nil {
+		return nil, fmt.Errorf("unable to unmarshal SES notification: %s", err.Error())
+	}
+	notificationType := notification.NotificationType
+	if notificationType == "" {
+		notificationType = notification.EventType
+	}
+
+	var recipients []sesRecipient
+	var reason []string
+	switch {
+	case notificationType == "Bounce" && notification.Bounce != nil:
+		if notification.Bounce.BounceType != "Permanent" {
+			return &Feedback{}, nil
+		}
+		recipients = notification.Bounce.BouncedRecipients
+		reason = []string{"bounce", notification.Bounce.BounceType, notification.Bounce.BounceSubType}
+	case notificationType == "Complaint" && notification.Complaint != nil:
+		recipients = notification.Complaint.ComplainedRecipients
+		reason = []string{"complaint", notification.Complaint.ComplaintFeedbackType}
+	case notificationType == "":
+		return nil, fmt.Errorf("message is not an SES notification")
+	default:
+		return &Feedback{}, nil
+	}
+
+	feedback := &Feedback{Reason: strings.TrimRight(strings.Join(reason, ":"), ":")}
+	for _, recipient := range recipients {
+		if recipient.EmailAddress != "" {
+			feedback.Addresses = append(feedback.Addresses, recipient.EmailAddress)
+		}
+	}
+
+	return feedback, nil
+}
@@ -0,0 +1,23 @@
+package suppression
+
+import (
+	"strings"
+	"time"
+)
+
+// List interface should be implemented by any service knowing the addresses which must not be mailed anymore, ie: hard-bounced or unsubscribed ones (DynamoDB, S3... etc).
+type List interface {
+	// Suppressed should return the given addresses which are suppressed.
+	Suppressed(addresses []string) ([]string, error)
+}
+
+// Recorder interface should be implemented by any suppression list able to suppress new addresses, ie: from bounce notifications.
+type Recorder interface {
+	// Suppress should add the address to the list, with the reason it must not be mailed anymore.
+	Suppress(address string, reason string, at time.Time) error
+}
+
+// normalize returns the form an address is looked up by, as the domain part of addresses is case insensitive and most mailboxes are too.
+func normalize(address string) string {
+	return strings.ToLower(strings.TrimSpace(address))
+}
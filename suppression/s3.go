@@ -0,0 +1,59 @@
+package suppression
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"log"
+	"strings"
+)
+
+// S3 holds the suppressed addresses listed by an S3 object, one per line. Empty lines and lines starting with # are ignored.
+// It implements the List interface.
+type S3 struct {
+	addresses map[string]bool
+}
+
+// Suppressed returns the addresses which are listed.
+func (s3List *S3) Suppressed(addresses []string) ([]string, error) {
+	var suppressed []string
+	for _, address := range addresses {
+		if s3List.addresses[normalize(address)] {
+			suppressed = append(suppressed, address)
+		}
+	}
+
+	return suppressed, nil
+}
+
+// NewS3 instanciates an S3 suppression list, reading the addresses from the object once.
+func NewS3(bucket string, key string, region string) (*S3, error) {
+	sess, err := session.NewSession(&aws.Config{Region: aws.String(region)})
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to AWS: %s", err.Error())
+	}
+
+	object, err := s3.New(sess).GetObject(&s3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+	if err != nil {
+		return nil, fmt.Errorf("unable to get suppression list %q in bucket %q: %s", key, bucket, err.Error())
+	}
+	defer object.Body.Close()
+
+	s3List := &S3{addresses: make(map[string]bool)}
+	scanner := bufio.NewScanner(object.Body)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		s3List.addresses[normalize(line)] = true
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("unable to read suppression list %q in bucket %q: %s", key, bucket, err.Error())
+	}
+	log.Printf("Read %d suppressed addresses from S3 object %s", len(s3List.addresses), key)
+
+	return s3List, nil
+}
@@ -0,0 +1,12 @@
+package templating
+
+// Engine interface should be implemented by the template languages templates may be written in besides Go templates (Mustache... etc).
+type Engine interface {
+	// Extension should return the file extension of the templates written for the engine, ie: "mustache" for welcome.html.mustache.
+	Extension() string
+	// Parse should check the syntax of the template, without executing it.
+	Parse(name string, content string) error
+	// Render should execute the template against the context, escaping the context values as HTML when escapeHTML is set.
+	// The partials invoked by the template are read with the partial function, given their name.
+	Render(name string, content string, context map[string]interface{}, escapeHTML bool, partial func(name string) (string, error)) (string, error)
+}
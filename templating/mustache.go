@@ -0,0 +1,304 @@
+package templating
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"strconv"
+	"strings"
+)
+
+// maxPartialDepth bounds the nesting of partials, so a partial invoking itself can't recurse forever.
+const maxPartialDepth = 20
+
+// Kinds of the nodes of a parsed Mustache template.
+const (
+	mustacheText = iota
+	mustacheVariable
+	mustacheRaw
+	mustacheSection
+	mustacheInverted
+	mustachePartial
+)
+
+// mustacheNode is a piece of a parsed Mustache template: a text, a tag, or a section holding its own nodes.
+type mustacheNode struct {
+	kind     int
+	value    string
+	children []*mustacheNode
+}
+
+// isStandalone tells if the tag spanning content[start:end] is alone on its line, returning the start of its line and the end of its line,
+// newline included, so the whole line can be removed as the Mustache specification requires for non-variable tags.
+func isStandalone(content string, start int, end int, pos int) (bool, int, int) {
+	lineStart := strings.LastIndex(content[:start], "\n") + 1
+	if lineStart < pos || strings.TrimLeft(content[lineStart:start], " \t") != "" {
+		return false, 0, 0
+	}
+	lineEnd := strings.Index(content[end:], "\n")
+	if lineEnd < 0 {
+		lineEnd = len(content)
+	} else {
+		lineEnd += end + 1
+	}
+	if strings.TrimSpace(content[end:lineEnd]) != "" {
+		return false, 0, 0
+	}
+
+	return true, lineStart, lineEnd
+}
+
+// parseMustache parses the template to its nodes. Set delimiter tags are not supported.
+func parseMustache(content string) ([]*mustacheNode, error) {
+	root := &mustacheNode{kind: mustacheSection}
+	open := []*mustacheNode{root}
+	pos := 0
+	for pos < len(content) {
+		start := strings.Index(content[pos:], "{{")
+		if start < 0 {
+			break
+		}
+		start += pos
+		closing := "}}"
+		if strings.HasPrefix(content[start:], "{{{") {
+			closing = "}}}"
+		}
+		end := strings.Index(content[start+2:], closing)
+		if end < 0 {
+			return nil, fmt.Errorf("line %d: unclosed tag", strings.Count(content[:start], "\n")+1)
+		}
+		end += start + 2 + len(closing)
+		tag := strings.TrimSpace(content[start+2 : end-2])
+		line := strings.Count(content[:start], "\n") + 1
+
+		var sigil byte
+		if len(tag) > 0 && strings.IndexByte("#^/!>&{=", tag[0]) >= 0 {
+			sigil = tag[0]
+			tag = strings.TrimSpace(strings.TrimSuffix(tag[1:], "}"))
+		}
+		textEnd, next := start, end
+		if sigil != 0 && sigil != '&' && sigil != '{' {
+			if standalone, lineStart, lineEnd := isStandalone(content, start, end, pos); standalone {
+				textEnd, next = lineStart, lineEnd
+			}
+		}
+		current := open[len(open)-1]
+		if textEnd > pos {
+			current.children = append(current.children, &mustacheNode{kind: mustacheText, value: content[pos:textEnd]})
+		}
+		pos = next
+
+		switch sigil {
+		case '!':
+		case '=':
+			return nil, fmt.Errorf("line %d: set delimiter tags are not supported", line)
+		case '#', '^':
+			kind := mustacheSection
+			if sigil == '^' {
+				kind = mustacheInverted
+			}
+			section := &mustacheNode{kind: kind, value: tag}
+			current.children = append(current.children, section)
+			open = append(open, section)
+		case '/':
+			if len(open) == 1 || current.value != tag {
+				return nil, fmt.Errorf("line %d: unexpected closing tag %q", line, tag)
+			}
+			open = open[:len(open)-1]
+		case '>':
+			current.children = append(current.children, &mustacheNode{kind: mustachePartial, value: tag})
+		case '&', '{':
+			current.children = append(current.children, &mustacheNode{kind: mustacheRaw, value: tag})
+		default:
+			current.children = append(current.children, &mustacheNode{kind: mustacheVariable, value: tag})
+		}
+	}
+	if len(open) > 1 {
+		return nil, fmt.Errorf("unclosed section %q", open[len(open)-1].value)
+	}
+	if pos < len(content) {
+		root.children = append(root.children, &mustacheNode{kind: mustacheText, value: content[pos:]})
+	}
+
+	return root.children, nil
+}
+
+// lookupMustache resolves a dotted name against the context stack, from the innermost context. A missing name resolves to nil.
+func lookupMustache(name string, stack []interface{}) interface{} {
+	if name == "." {
+		return stack[len(stack)-1]
+	}
+
+	parts := strings.Split(name, ".")
+	for i := len(stack) - 1; i >= 0; i-- {
+		fields, ok := stack[i].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		value, ok := fields[parts[0]]
+		if !ok {
+			continue
+		}
+		for _, part := range parts[1:] {
+			fields, ok := value.(map[string]interface{})
+			if !ok {
+				return nil
+			}
+			value = fields[part]
+		}
+		return value
+	}
+
+	return nil
+}
+
+// isTruthy tells if a section is rendered for the value. Missing values, false, zero, empty strings and empty lists are falsy.
+func isTruthy(value interface{}) bool {
+	switch typed := value.(type) {
+	case nil:
+		return false
+	case bool:
+		return typed
+	case string:
+		return typed != ""
+	case json.Number:
+		number, err := typed.Float64()
+		return err != nil || number != 0
+	case float64:
+		return typed != 0
+	case int:
+		return typed != 0
+	case []interface{}:
+		return len(typed) > 0
+	default:
+		return true
+	}
+}
+
+// formatMustache returns the text of a variable value.
+func formatMustache(value interface{}) string {
+	switch typed := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return typed
+	case json.Number:
+		return typed.String()
+	case float64:
+		return strconv.FormatFloat(typed, 'f', -1, 64)
+	default:
+		return fmt.Sprint(typed)
+	}
+}
+
+// mustacheRenderer executes the parsed nodes, caching the parsed partials.
+type mustacheRenderer struct {
+	escapeHTML bool
+	partial    func(name string) (string, error)
+	partials   map[string][]*mustacheNode
+	depth      int
+}
+
+func (renderer *mustacheRenderer) render(output *strings.Builder, nodes []*mustacheNode, stack []interface{}) error {
+	for _, node := range nodes {
+		switch node.kind {
+		case mustacheText:
+			output.WriteString(node.value)
+		case mustacheVariable:
+			text := formatMustache(lookupMustache(node.value, stack))
+			if renderer.escapeHTML {
+				text = html.EscapeString(text)
+			}
+			output.WriteString(text)
+		case mustacheRaw:
+			output.WriteString(formatMustache(lookupMustache(node.value, stack)))
+		case mustacheInverted:
+			if !isTruthy(lookupMustache(node.value, stack)) {
+				if err := renderer.render(output, node.children, stack); err != nil {
+					return err
+				}
+			}
+		case mustacheSection:
+			value := lookupMustache(node.value, stack)
+			items, isList := value.([]interface{})
+			if !isList {
+				if !isTruthy(value) {
+					continue
+				}
+				items = []interface{}{value}
+			}
+			for _, item := range items {
+				if err := renderer.render(output, node.children, append(stack, item)); err != nil {
+					return err
+				}
+			}
+		case mustachePartial:
+			if err := renderer.renderPartial(output, node.value, stack); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// renderPartial executes the partial against the current context stack.
+func (renderer *mustacheRenderer) renderPartial(output *strings.Builder, name string, stack []interface{}) error {
+	if renderer.depth >= maxPartialDepth {
+		return fmt.Errorf("partial %q is nested more than %d times", name, maxPartialDepth)
+	}
+	nodes, ok := renderer.partials[name]
+	if !ok {
+		content, err := renderer.partial(name)
+		if err != nil {
+			return fmt.Errorf("unable to fetch partial %q: %s", name, err.Error())
+		}
+		if nodes, err = parseMustache(content); err != nil {
+			return fmt.Errorf("partial %q: %s", name, err.Error())
+		}
+		renderer.partials[name] = nodes
+	}
+
+	renderer.depth++
+	defer func() { renderer.depth-- }()
+
+	return renderer.render(output, nodes, stack)
+}
+
+// Mustache renders logic-less Mustache templates: variables, sections, inverted sections, comments and partials. It implements the Engine interface.
+type Mustache struct{}
+
+// Extension returns the extension of the Mustache templates, ie: welcome.html.mustache.
+func (engine *Mustache) Extension() string {
+	return "mustache"
+}
+
+// Parse checks the syntax of the template.
+func (engine *Mustache) Parse(name string, content string) error {
+	if _, err := parseMustache(content); err != nil {
+		return fmt.Errorf("template: %s: %s", name, err.Error())
+	}
+
+	return nil
+}
+
+// Render executes the template against the context. The errors name the template, ie: "template: welcome.html.mustache: line 3: unclosed tag".
+func (engine *Mustache) Render(name string, content string, context map[string]interface{}, escapeHTML bool, partial func(name string) (string, error)) (string, error) {
+	nodes, err := parseMustache(content)
+	if err != nil {
+		return "", fmt.Errorf("template: %s: %s", name, err.Error())
+	}
+
+	renderer := &mustacheRenderer{escapeHTML: escapeHTML, partial: partial, partials: make(map[string][]*mustacheNode)}
+	var output strings.Builder
+	if err := renderer.render(&output, nodes, []interface{}{context}); err != nil {
+		return "", fmt.Errorf("template: %s: %s", name, err.Error())
+	}
+
+	return output.String(), nil
+}
+
+// NewMustache instanciates the Mustache engine.
+func NewMustache() *Mustache {
+	return &Mustache{}
+}
@@ -0,0 +1,125 @@
+package tracing
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// OTLP exports spans to an OpenTelemetry collector, using the OTLP/HTTP protocol with JSON encoding.
+type OTLP struct {
+	endpoint    string
+	serviceName string
+	httpClient  *http.Client
+}
+
+type otlpValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID           string     `json:"traceId"`
+	SpanID            string     `json:"spanId"`
+	ParentSpanID      string     `json:"parentSpanId,omitempty"`
+	Name              string     `json:"name"`
+	Kind              int        `json:"kind"`
+	StartTimeUnixNano string     `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+	Status            otlpStatus `json:"status"`
+}
+
+type otlpScopeSpans struct {
+	Scope struct {
+		Name string `json:"name"`
+	} `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpResourceSpans struct {
+	Resource struct {
+		Attributes []otlpAttribute `json:"attributes"`
+	} `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+// Status codes and span kind of the OTLP specification.
+const (
+	otlpStatusOK       = 1
+	otlpStatusError    = 2
+	otlpSpanKindServer = 2
+)
+
+// NewOTLP returns an exporter sending spans to the traces path of the given collector endpoint, ie: http://localhost:4318.
+func NewOTLP(endpoint string, serviceName string) *OTLP {
+	return &OTLP{
+		endpoint:    strings.TrimSuffix(endpoint, "/") + "/v1/traces",
+		serviceName: serviceName,
+		httpClient:  &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+func toOTLPSpan(span *Span) otlpSpan {
+	converted := otlpSpan{
+		TraceID:           hex.EncodeToString(span.traceID[:]),
+		SpanID:            hex.EncodeToString(span.spanID[:]),
+		Name:              span.name,
+		Kind:              otlpSpanKindServer,
+		StartTimeUnixNano: strconv.FormatInt(span.start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(span.end.UnixNano(), 10),
+		Status:            otlpStatus{Code: otlpStatusOK},
+	}
+	if span.parentID != [8]byte{} {
+		converted.ParentSpanID = hex.EncodeToString(span.parentID[:])
+	}
+	if span.err != nil {
+		converted.Status = otlpStatus{Code: otlpStatusError, Message: span.err.Error()}
+	}
+
+	return converted
+}
+
+// Export posts the spans to the collector in a single request.
+func (otlpExporter *OTLP) Export(spans []*Span) error {
+	scopeSpans := otlpScopeSpans{Spans: make([]otlpSpan, len(spans))}
+	scopeSpans.Scope.Name = otlpExporter.serviceName
+	for i, span := range spans {
+		scopeSpans.Spans[i] = toOTLPSpan(span)
+	}
+	resourceSpans := otlpResourceSpans{ScopeSpans: []otlpScopeSpans{scopeSpans}}
+	resourceSpans.Resource.Attributes = []otlpAttribute{{Key: "service.name", Value: otlpValue{StringValue: otlpExporter.serviceName}}}
+
+	body, err := json.Marshal(otlpRequest{ResourceSpans: []otlpResourceSpans{resourceSpans}})
+	if err != nil {
+		return fmt.Errorf("unable to marshal spans: %s", err.Error())
+	}
+
+	response, err := otlpExporter.httpClient.Post(otlpExporter.endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("unable to export spans to %s: %s", otlpExporter.endpoint, err.Error())
+	}
+	defer response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return fmt.Errorf("unable to export spans to %s: collector answered %s", otlpExporter.endpoint, response.Status)
+	}
+
+	return nil
+}
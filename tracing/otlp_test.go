@@ -0,0 +1,125 @@
+package tracing
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestOTLPExport(t *testing.T) {
+	tracer := NewTracer(nil)
+	ctx, root := tracer.StartSpan(context.Background(), "HandleRequest")
+	_, child := tracer.StartSpan(ctx, "send")
+	child.End(errors.New("550 \"mailbox\" unavailable\r\n"))
+	root.End(nil)
+
+	var request otlpRequest
+	var path, contentType string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		contentType = r.Header.Get("Content-Type")
+		body, _ := ioutil.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &request); err != nil {
+			t.Errorf("expected a JSON body, got %q: %s", body, err.Error())
+		}
+	}))
+	defer server.Close()
+
+	if err := NewOTLP(server.URL+"/", "hermes").Export([]*Span{child, root}); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if path != "/v1/traces" || contentType != "application/json" {
+		t.Errorf("expected a JSON post to /v1/traces, got %s to %s", contentType, path)
+	}
+	if len(request.ResourceSpans) != 1 || len(request.ResourceSpans[0].ScopeSpans) != 1 {
+		t.Fatalf("expected a single resource and scope, got %+v", request)
+	}
+	attributes := request.ResourceSpans[0].Resource.Attributes
+	if len(attributes) != 1 || attributes[0].Key != "service.name" || attributes[0].Value.StringValue != "hermes" {
+		t.Errorf("expected the service name attribute, got %+v", attributes)
+	}
+	spans := request.ResourceSpans[0].ScopeSpans[0].Spans
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %+v", spans)
+	}
+
+	tests := []struct {
+		name     string
+		exported otlpSpan
+		span     *Span
+		parentID string
+		status   otlpStatus
+	}{
+		{name: "child span", exported: spans[0], span: child, parentID: hex.EncodeToString(root.spanID[:]), status: otlpStatus{Code: otlpStatusError, Message: "550 \"mailbox\" unavailable\r\n"}},
+		{name: "root span", exported: spans[1], span: root, status: otlpStatus{Code: otlpStatusOK}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.exported.Name != test.span.name {
+				t.Errorf("expected name %s, got %s", test.span.name, test.exported.Name)
+			}
+			if test.exported.TraceID != hex.EncodeToString(root.traceID[:]) || len(test.exported.TraceID) != 32 {
+				t.Errorf("expected trace id %x, got %s", root.traceID, test.exported.TraceID)
+			}
+			if test.exported.SpanID != hex.EncodeToString(test.span.spanID[:]) || len(test.exported.SpanID) != 16 {
+				t.Errorf("expected span id %x, got %s", test.span.spanID, test.exported.SpanID)
+			}
+			if test.exported.ParentSpanID != test.parentID {
+				t.Errorf("expected parent span id %q, got %q", test.parentID, test.exported.ParentSpanID)
+			}
+			if test.exported.Status != test.status {
+				t.Errorf("expected status %+v, got %+v", test.status, test.exported.Status)
+			}
+			if test.exported.StartTimeUnixNano != strconv.FormatInt(test.span.start.UnixNano(), 10) || test.exported.EndTimeUnixNano != strconv.FormatInt(test.span.end.UnixNano(), 10) {
+				t.Errorf("expected the span times in nanoseconds, got %s and %s", test.exported.StartTimeUnixNano, test.exported.EndTimeUnixNano)
+			}
+		})
+	}
+}
+
+func TestOTLPExportFailure(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		err    string
+	}{
+		{name: "accepted", status: http.StatusOK},
+		{name: "partially accepted", status: http.StatusAccepted},
+		{name: "rejected", status: http.StatusBadRequest, err: "collector answered 400 Bad Request"},
+		{name: "unavailable", status: http.StatusServiceUnavailable, err: "collector answered 503 Service Unavailable"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(test.status)
+			}))
+			defer server.Close()
+
+			err := NewOTLP(server.URL, "hermes").Export([]*Span{{name: "send"}})
+			if test.err == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %s", err.Error())
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("expected error %q, got %v", test.err, err)
+			}
+		})
+	}
+
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+	if err := NewOTLP(server.URL, "hermes").Export([]*Span{{name: "send"}}); err == nil {
+		t.Errorf("expected an unreachable collector to fail the export")
+	}
+}
@@ -0,0 +1,107 @@
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"sync"
+	"time"
+)
+
+// Tracer records the spans of an invocation until they are exported. A nil tracer records nothing.
+type Tracer struct {
+	exporter Exporter
+	mu       sync.Mutex
+	spans    []*Span
+}
+
+// Span is one timed phase of the processing, ie: the rendering of a message.
+type Span struct {
+	tracer   *Tracer
+	name     string
+	traceID  [16]byte
+	spanID   [8]byte
+	parentID [8]byte
+	start    time.Time
+	end      time.Time
+	err      error
+}
+
+type spanKey struct{}
+
+// NewTracer returns a tracer exporting its spans through the given exporter.
+func NewTracer(exporter Exporter) *Tracer {
+	return &Tracer{exporter: exporter}
+}
+
+// StartSpan starts a span, child of the span held by the context if any, and returns a context holding the new span.
+func (tracer *Tracer) StartSpan(ctx context.Context, name string) (context.Context, *Span) {
+	if tracer == nil {
+		return ctx, nil
+	}
+
+	span := &Span{tracer: tracer, name: name, start: time.Now()}
+	if parent, ok := ctx.Value(spanKey{}).(*Span); ok {
+		span.traceID = parent.traceID
+		span.parentID = parent.spanID
+	} else {
+		_, _ = rand.Read(span.traceID[:])
+	}
+	_, _ = rand.Read(span.spanID[:])
+
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+// End ends the span, marking it as failed when err is not nil. Ending a span again does nothing.
+func (span *Span) End(err error) {
+	if span == nil {
+		return
+	}
+
+	span.tracer.mu.Lock()
+	defer span.tracer.mu.Unlock()
+	if !span.end.IsZero() {
+		return
+	}
+	span.end = time.Now()
+	span.err = err
+	span.tracer.spans = append(span.tracer.spans, span)
+}
+
+// Flush exports the ended spans, forgetting them even when the export fails.
+func (tracer *Tracer) Flush() error {
+	if tracer == nil {
+		return nil
+	}
+
+	tracer.mu.Lock()
+	spans := tracer.spans
+	tracer.spans = nil
+	tracer.mu.Unlock()
+
+	if len(spans) == 0 {
+		return nil
+	}
+
+	return tracer.exporter.Export(spans)
+}
+
+// Exporter interface should be implemented by any service responsible to send the ended spans to a tracing backend (OTLP collector, X-Ray... etc).
+type Exporter interface {
+	// Export should send the spans, in a single batch when possible.
+	Export(spans []*Span) error
+}
+
+// Exporters sends the spans to each exporter, so they can be sent to several backends.
+type Exporters []Exporter
+
+// Export sends the spans to every exporter, even when one fails, and returns the first error.
+func (exporters Exporters) Export(spans []*Span) error {
+	var firstErr error
+	for _, exporter := range exporters {
+		if err := exporter.Export(spans); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
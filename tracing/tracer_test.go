@@ -0,0 +1,169 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+)
+
+type recordingExporter struct {
+	batches [][]*Span
+	err     error
+}
+
+func (exporter *recordingExporter) Export(spans []*Span) error {
+	exporter.batches = append(exporter.batches, spans)
+
+	return exporter.err
+}
+
+func TestTracerStartSpan(t *testing.T) {
+	tracer := NewTracer(&recordingExporter{})
+	ctx, root := tracer.StartSpan(context.Background(), "HandleRequest")
+	_, child := tracer.StartSpan(ctx, "render")
+	_, other := tracer.StartSpan(context.Background(), "HandleRequest")
+
+	tests := []struct {
+		name     string
+		span     *Span
+		traceID  [16]byte
+		parentID [8]byte
+	}{
+		{name: "root span", span: root, traceID: root.traceID},
+		{name: "child span", span: child, traceID: root.traceID, parentID: root.spanID},
+		{name: "other root span", span: other, traceID: other.traceID},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.span.traceID == [16]byte{} || test.span.spanID == [8]byte{} {
+				t.Fatalf("expected random ids, got %x and %x", test.span.traceID, test.span.spanID)
+			}
+			if test.span.traceID != test.traceID {
+				t.Errorf("expected trace id %x, got %x", test.traceID, test.span.traceID)
+			}
+			if test.span.parentID != test.parentID {
+				t.Errorf("expected parent id %x, got %x", test.parentID, test.span.parentID)
+			}
+		})
+	}
+	if other.traceID == root.traceID {
+		t.Errorf("expected the root spans to have their own trace id, got %x", root.traceID)
+	}
+}
+
+func TestTracerFlush(t *testing.T) {
+	exportErr := errors.New("collector unavailable")
+	tests := []struct {
+		name    string
+		ended   int
+		err     error
+		batches int
+	}{
+		{name: "exports the ended spans", ended: 2, batches: 1},
+		{name: "exports nothing without ended spans", ended: 0, batches: 0},
+		{name: "returns the export error", ended: 1, err: exportErr, batches: 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			exporter := &recordingExporter{err: test.err}
+			tracer := NewTracer(exporter)
+			for i := 0; i < test.ended; i++ {
+				_, span := tracer.StartSpan(context.Background(), "send")
+				span.End(nil)
+			}
+			_, _ = tracer.StartSpan(context.Background(), "not ended")
+
+			if err := tracer.Flush(); err != test.err {
+				t.Errorf("expected error %v, got %v", test.err, err)
+			}
+			if len(exporter.batches) != test.batches {
+				t.Fatalf("expected %d batches, got %d", test.batches, len(exporter.batches))
+			}
+			if test.batches > 0 && len(exporter.batches[0]) != test.ended {
+				t.Errorf("expected %d spans, got %d", test.ended, len(exporter.batches[0]))
+			}
+
+			// The spans are forgotten even when the export failed.
+			if err := tracer.Flush(); err != nil || len(exporter.batches) != test.batches {
+				t.Errorf("expected the spans to be exported once, got %d batches and %v", len(exporter.batches), err)
+			}
+		})
+	}
+}
+
+func TestSpanEnd(t *testing.T) {
+	exporter := &recordingExporter{}
+	tracer := NewTracer(exporter)
+	_, span := tracer.StartSpan(context.Background(), "send")
+	failure := errors.New("550 mailbox unavailable")
+	span.End(failure)
+	span.End(nil)
+
+	if err := tracer.Flush(); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if len(exporter.batches) != 1 || len(exporter.batches[0]) != 1 {
+		t.Fatalf("expected a span ended twice to be exported once, got %v", exporter.batches)
+	}
+	if span.err != failure || span.end.Before(span.start) {
+		t.Errorf("expected the first end to be kept, got %v ended at %s", span.err, span.end)
+	}
+}
+
+func TestSpanEndConcurrently(t *testing.T) {
+	exporter := &recordingExporter{}
+	tracer := NewTracer(exporter)
+	ctx, root := tracer.StartSpan(context.Background(), "HandleMessages")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, span := tracer.StartSpan(ctx, "send")
+			span.End(nil)
+		}()
+	}
+	wg.Wait()
+	root.End(nil)
+
+	if err := tracer.Flush(); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if len(exporter.batches) != 1 || len(exporter.batches[0]) != 51 {
+		t.Errorf("expected 51 spans in a single batch, got %v", exporter.batches)
+	}
+}
+
+func TestNilTracer(t *testing.T) {
+	var tracer *Tracer
+	ctx := context.Background()
+	spanCtx, span := tracer.StartSpan(ctx, "send")
+	if spanCtx != ctx || span != nil {
+		t.Errorf("expected a nil tracer to start no span, got %v", span)
+	}
+	span.End(errors.New("ignored"))
+	if err := tracer.Flush(); err != nil {
+		t.Errorf("expected a nil tracer to flush nothing, got %s", err.Error())
+	}
+}
+
+func TestExporters(t *testing.T) {
+	first := &recordingExporter{err: errors.New("collector unavailable")}
+	second := &recordingExporter{err: errors.New("daemon unavailable")}
+	third := &recordingExporter{}
+	spans := []*Span{{name: "send"}}
+
+	err := Exporters{first, second, third}.Export(spans)
+	if err != first.err {
+		t.Errorf("expected the first error, got %v", err)
+	}
+	for i, exporter := range []*recordingExporter{first, second, third} {
+		if len(exporter.batches) != 1 {
+			t.Errorf("expected exporter %d to receive the spans, got %d batches", i, len(exporter.batches))
+		}
+	}
+}
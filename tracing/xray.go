@@ -0,0 +1,120 @@
+package tracing
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// xrayHeader prefixes each document sent to the X-Ray daemon.
+const xrayHeader = "{\"format\": \"json\", \"version\": 1}\n"
+
+// XRay exports spans as subsegments of the lambda invocation segment, through the X-Ray daemon of the lambda environment.
+type XRay struct {
+	daemonAddress string
+}
+
+type xrayException struct {
+	Message string `json:"message"`
+}
+
+type xrayCause struct {
+	Exceptions []xrayException `json:"exceptions"`
+}
+
+type xraySubsegment struct {
+	Name      string     `json:"name"`
+	ID        string     `json:"id"`
+	TraceID   string     `json:"trace_id"`
+	ParentID  string     `json:"parent_id"`
+	StartTime float64    `json:"start_time"`
+	EndTime   float64    `json:"end_time"`
+	Type      string     `json:"type"`
+	Error     bool       `json:"error,omitempty"`
+	Cause     *xrayCause `json:"cause,omitempty"`
+}
+
+// NewXRay returns an exporter sending spans to the X-Ray daemon, at AWS_XRAY_DAEMON_ADDRESS in the lambda environment.
+func NewXRay() *XRay {
+	daemonAddress := os.Getenv("AWS_XRAY_DAEMON_ADDRESS")
+	if daemonAddress == "" {
+		daemonAddress = "127.0.0.1:2000"
+	}
+
+	return &XRay{daemonAddress: daemonAddress}
+}
+
+// invocationTrace returns the trace id, the segment id and the sampling decision of the current lambda invocation, set by the runtime
+// in the _X_AMZN_TRACE_ID variable, ie: "Root=1-5759e988-bd862e3fe1be46a994272793;Parent=53995c3f42cd8ad8;Sampled=1".
+func invocationTrace() (string, string, bool) {
+	var root, parent string
+	sampled := false
+	for _, part := range strings.Split(os.Getenv("_X_AMZN_TRACE_ID"), ";") {
+		keyValue := strings.SplitN(part, "=", 2)
+		if len(keyValue) != 2 {
+			continue
+		}
+		switch keyValue[0] {
+		case "Root":
+			root = keyValue[1]
+		case "Parent":
+			parent = keyValue[1]
+		case "Sampled":
+			sampled = keyValue[1] == "1"
+		}
+	}
+
+	return root, parent, sampled
+}
+
+func unixSeconds(t time.Time) float64 {
+	return float64(t.UnixNano()) / float64(time.Second)
+}
+
+// Export sends each span as a subsegment of the invocation segment, the spans without parent being attached to the segment.
+// Nothing is sent when the invocation is not sampled.
+func (xrayExporter *XRay) Export(spans []*Span) error {
+	root, parent, sampled := invocationTrace()
+	if root == "" || !sampled {
+		return nil
+	}
+
+	connection, err := net.Dial("udp", xrayExporter.daemonAddress)
+	if err != nil {
+		return fmt.Errorf("unable to connect to X-Ray daemon %s: %s", xrayExporter.daemonAddress, err.Error())
+	}
+	defer connection.Close()
+
+	for _, span := range spans {
+		subsegment := xraySubsegment{
+			Name:      span.name,
+			ID:        hex.EncodeToString(span.spanID[:]),
+			TraceID:   root,
+			ParentID:  parent,
+			StartTime: unixSeconds(span.start),
+			EndTime:   unixSeconds(span.end),
+			Type:      "subsegment",
+		}
+		if span.parentID != [8]byte{} {
+			subsegment.ParentID = hex.EncodeToString(span.parentID[:])
+		}
+		if span.err != nil {
+			subsegment.Error = true
+			subsegment.Cause = &xrayCause{Exceptions: []xrayException{{Message: span.err.Error()}}}
+		}
+
+		document, err := json.Marshal(subsegment)
+		if err != nil {
+			return fmt.Errorf("unable to marshal subsegment: %s", err.Error())
+		}
+		if _, err := connection.Write(append([]byte(xrayHeader), document...)); err != nil {
+			return fmt.Errorf("unable to send subsegment to X-Ray daemon: %s", err.Error())
+		}
+	}
+
+	return nil
+}
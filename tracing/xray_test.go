@@ -0,0 +1,174 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+// setEnv sets the environment variable, and returns the function restoring it.
+func setEnv(name string, value string) func() {
+	previous, ok := os.LookupEnv(name)
+	os.Setenv(name, value)
+
+	return func() {
+		if ok {
+			os.Setenv(name, previous)
+		} else {
+			os.Unsetenv(name)
+		}
+	}
+}
+
+// listenXRayDaemon listens as the X-Ray daemon, and returns the function closing it.
+func listenXRayDaemon(t *testing.T) (*net.UDPConn, func()) {
+	t.Helper()
+
+	connection, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("unable to listen: %s", err.Error())
+	}
+	restore := setEnv("AWS_XRAY_DAEMON_ADDRESS", connection.LocalAddr().String())
+
+	return connection, func() {
+		restore()
+		connection.Close()
+	}
+}
+
+func readSubsegments(t *testing.T, connection *net.UDPConn, count int) []xraySubsegment {
+	t.Helper()
+
+	var subsegments []xraySubsegment
+	buffer := make([]byte, 65536)
+	for len(subsegments) < count {
+		_ = connection.SetReadDeadline(time.Now().Add(2 * time.Second))
+		n, err := connection.Read(buffer)
+		if err != nil {
+			t.Fatalf("expected %d subsegments, got %d: %s", count, len(subsegments), err.Error())
+		}
+		if !bytes.HasPrefix(buffer[:n], []byte(xrayHeader)) {
+			t.Fatalf("expected the document to start with the X-Ray header, got %q", buffer[:n])
+		}
+		var subsegment xraySubsegment
+		if err := json.Unmarshal(buffer[len(xrayHeader):n], &subsegment); err != nil {
+			t.Fatalf("expected a JSON document, got %q: %s", buffer[:n], err.Error())
+		}
+		subsegments = append(subsegments, subsegment)
+	}
+
+	return subsegments
+}
+
+func TestInvocationTrace(t *testing.T) {
+	tests := []struct {
+		header  string
+		root    string
+		parent  string
+		sampled bool
+	}{
+		{header: "Root=1-5759e988-bd862e3fe1be46a994272793;Parent=53995c3f42cd8ad8;Sampled=1", root: "1-5759e988-bd862e3fe1be46a994272793", parent: "53995c3f42cd8ad8", sampled: true},
+		{header: "Root=1-5759e988-bd862e3fe1be46a994272793;Parent=53995c3f42cd8ad8;Sampled=0", root: "1-5759e988-bd862e3fe1be46a994272793", parent: "53995c3f42cd8ad8"},
+		{header: "Sampled=1;Lineage=a87bd80c:0;Root=1-5759e988-bd862e3fe1be46a994272793", root: "1-5759e988-bd862e3fe1be46a994272793", sampled: true},
+		{header: "Root;Parent=;Sampled", parent: ""},
+		{header: ""},
+	}
+
+	defer setEnv("_X_AMZN_TRACE_ID", "")()
+	for _, test := range tests {
+		os.Setenv("_X_AMZN_TRACE_ID", test.header)
+		root, parent, sampled := invocationTrace()
+		if root != test.root || parent != test.parent || sampled != test.sampled {
+			t.Errorf("expected %q to be %q, %q, %t, got %q, %q, %t", test.header, test.root, test.parent, test.sampled, root, parent, sampled)
+		}
+	}
+}
+
+func TestXRayExport(t *testing.T) {
+	connection, closeDaemon := listenXRayDaemon(t)
+	defer closeDaemon()
+	defer setEnv("_X_AMZN_TRACE_ID", "Root=1-5759e988-bd862e3fe1be46a994272793;Parent=53995c3f42cd8ad8;Sampled=1")()
+
+	tracer := NewTracer(nil)
+	ctx, root := tracer.StartSpan(context.Background(), "HandleRequest")
+	_, child := tracer.StartSpan(ctx, "send")
+	child.End(errors.New("550 \"mailbox\" unavailable\n{\"format\": \"json\"}"))
+	root.End(nil)
+
+	if err := NewXRay().Export([]*Span{child, root}); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	subsegments := readSubsegments(t, connection, 2)
+
+	tests := []struct {
+		name       string
+		subsegment xraySubsegment
+		span       *Span
+		parentID   string
+		cause      string
+	}{
+		{name: "child span", subsegment: subsegments[0], span: child, parentID: hex.EncodeToString(root.spanID[:]), cause: child.err.Error()},
+		{name: "root span attached to the invocation segment", subsegment: subsegments[1], span: root, parentID: "53995c3f42cd8ad8"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.subsegment.Name != test.span.name || test.subsegment.Type != "subsegment" {
+				t.Errorf("expected subsegment %s, got %+v", test.span.name, test.subsegment)
+			}
+			if test.subsegment.TraceID != "1-5759e988-bd862e3fe1be46a994272793" {
+				t.Errorf("expected the invocation trace id, got %s", test.subsegment.TraceID)
+			}
+			if test.subsegment.ID != hex.EncodeToString(test.span.spanID[:]) || len(test.subsegment.ID) != 16 {
+				t.Errorf("expected id %x, got %s", test.span.spanID, test.subsegment.ID)
+			}
+			if test.subsegment.ParentID != test.parentID {
+				t.Errorf("expected parent id %s, got %s", test.parentID, test.subsegment.ParentID)
+			}
+			if test.subsegment.StartTime > test.subsegment.EndTime || test.subsegment.StartTime < float64(time.Now().Add(-time.Minute).Unix()) {
+				t.Errorf("expected times in seconds, got %f and %f", test.subsegment.StartTime, test.subsegment.EndTime)
+			}
+			if test.cause == "" {
+				if test.subsegment.Error || test.subsegment.Cause != nil {
+					t.Errorf("expected no error, got %+v", test.subsegment)
+				}
+				return
+			}
+			if !test.subsegment.Error || test.subsegment.Cause == nil || len(test.subsegment.Cause.Exceptions) != 1 || test.subsegment.Cause.Exceptions[0].Message != test.cause {
+				t.Errorf("expected cause %q, got %+v", test.cause, test.subsegment.Cause)
+			}
+		})
+	}
+}
+
+func TestXRayExportUnsampled(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+	}{
+		{name: "not sampled", header: "Root=1-5759e988-bd862e3fe1be46a994272793;Parent=53995c3f42cd8ad8;Sampled=0"},
+		{name: "outside of a lambda invocation", header: ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			connection, closeDaemon := listenXRayDaemon(t)
+			defer closeDaemon()
+			defer setEnv("_X_AMZN_TRACE_ID", test.header)()
+
+			if err := NewXRay().Export([]*Span{{name: "send"}}); err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+			_ = connection.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+			if n, err := connection.Read(make([]byte, 65536)); err == nil {
+				t.Errorf("expected nothing sent, got %d bytes", n)
+			}
+		})
+	}
+}
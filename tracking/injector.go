@@ -0,0 +1,151 @@
+package tracking
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"html"
+	"net/url"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+// linkPattern matches the href attribute of the HTML anchors, capturing the attribute up to its value, and the double or single quoted value.
+var linkPattern = regexp.MustCompile(`(?i)(<a\s[^>]*?\bhref\s*=\s*)(?:"([^"]*)"|'([^']*)')`)
+
+// bodyEndPattern matches the closing body tag, the tracking pixel being inserted before it.
+var bodyEndPattern = regexp.MustCompile(`(?i)</body\s*>`)
+
+// Injector rewrites the links of HTML bodies to a click tracking redirect endpoint, and adds an open tracking pixel to them.
+// Its URL templates, such as "https://t.example.com/click/{{.ID}}/{{.Link}}?url={{.URL}}&sig={{.Signature}}", are rendered for each link.
+type Injector struct {
+	secret   []byte
+	clickURL *template.Template
+	openURL  *template.Template
+}
+
+// urlContext is the data available to the tracking URL templates. Values are query escaped.
+type urlContext struct {
+	ID        string
+	Link      int
+	URL       string
+	Signature string
+}
+
+// NewInjector parses the URL templates, signing the tracked URLs with the secret. An empty template disables the matching tracking.
+func NewInjector(secret string, clickURL string, openURL string) (*Injector, error) {
+	if secret == "" {
+		return nil, fmt.Errorf("tracking URLs require a secret")
+	}
+
+	injector := &Injector{secret: []byte(secret)}
+	var err error
+	if clickURL != "" {
+		if injector.clickURL, err = template.New("clickURL").Parse(clickURL); err != nil {
+			return nil, fmt.Errorf("unable to parse click tracking URL template: %s", err.Error())
+		}
+	}
+	if openURL != "" {
+		if injector.openURL, err = template.New("openURL").Parse(openURL); err != nil {
+			return nil, fmt.Errorf("unable to parse open tracking URL template: %s", err.Error())
+		}
+	}
+
+	return injector, nil
+}
+
+// NewID returns a random tracking id, identifying the sent message in the tracking URLs.
+func NewID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", fmt.Errorf("unable to generate tracking id: %s", err.Error())
+	}
+
+	return hex.EncodeToString(id), nil
+}
+
+// Sign returns the URL safe HMAC-SHA256 of the tracking id and the tracked URL, so the redirect endpoint only redirects to the URLs of sent messages.
+func Sign(secret []byte, id string, trackedURL string) string {
+	mac := hmac.New(sha256.New, secret)
+	_, _ = mac.Write([]byte(id + "\n" + trackedURL))
+
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// VerifySignature tells if the signature was made by Sign for the tracking id and the tracked URL.
+func VerifySignature(secret []byte, id string, trackedURL string, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, id, trackedURL)), []byte(signature))
+}
+
+func (injector *Injector) render(urlTemplate *template.Template, id string, link int, trackedURL string) (string, error) {
+	var buffer bytes.Buffer
+	err := urlTemplate.Execute(&buffer, urlContext{
+		ID:        url.QueryEscape(id),
+		Link:      link,
+		URL:       url.QueryEscape(trackedURL),
+		Signature: url.QueryEscape(Sign(injector.secret, id, trackedURL)),
+	})
+	if err != nil {
+		return "", fmt.Errorf("unable to execute %s template: %s", urlTemplate.Name(), err.Error())
+	}
+
+	return buffer.String(), nil
+}
+
+// isTrackable tells if the link leads to a web page, other links, ie: mailto: or anchors, being left as is.
+func isTrackable(link string) bool {
+	lower := strings.ToLower(strings.TrimSpace(link))
+
+	return strings.HasPrefix(lower, "http://") || strings.HasPrefix(lower, "https://")
+}
+
+// Inject rewrites the web links of the HTML body to the click tracking URL, and inserts the open tracking pixel before its closing body tag,
+// or at its end when it has none. Links are numbered from 0 in the order of the body. A nil Injector returns the body unchanged.
+func (injector *Injector) Inject(htmlBody string, id string) (string, error) {
+	if injector == nil {
+		return htmlBody, nil
+	}
+
+	var err error
+	if injector.clickURL != nil {
+		link := 0
+		htmlBody = linkPattern.ReplaceAllStringFunc(htmlBody, func(anchor string) string {
+			groups := linkPattern.FindStringSubmatch(anchor)
+			value := groups[2] + groups[3]
+			trackedURL := strings.TrimSpace(html.UnescapeString(value))
+			if err != nil || !isTrackable(trackedURL) {
+				return anchor
+			}
+			clickURL, renderErr := injector.render(injector.clickURL, id, link, trackedURL)
+			if renderErr != nil {
+				err = renderErr
+				return anchor
+			}
+			link++
+			return groups[1] + `"` + html.EscapeString(clickURL) + `"`
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if injector.openURL != nil {
+		openURL, err := injector.render(injector.openURL, id, 0, "")
+		if err != nil {
+			return "", err
+		}
+		pixel := `<img src="` + html.EscapeString(openURL) + `" width="1" height="1" alt="" style="display:none">`
+		if location := bodyEndPattern.FindAllStringIndex(htmlBody, -1); len(location) > 0 {
+			end := location[len(location)-1][0]
+			return htmlBody[:end] + pixel + htmlBody[end:], nil
+		}
+		htmlBody += pixel
+	}
+
+	return htmlBody, nil
+}
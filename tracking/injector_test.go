@@ -0,0 +1,156 @@
+package tracking
+
+import (
+	"html"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+const (
+	testSecret   = "tracking secret"
+	testClickURL = "https://t.example.com/click/{{.ID}}/{{.Link}}?url={{.URL}}&sig={{.Signature}}"
+	testOpenURL  = "https://t.example.com/open/{{.ID}}.gif"
+)
+
+var hrefPattern = regexp.MustCompile(`href="([^"]*)"`)
+
+func newTestInjector(t *testing.T, clickURL string, openURL string) *Injector {
+	injector, err := NewInjector(testSecret, clickURL, openURL)
+	if err != nil {
+		t.Fatalf("unable to create injector: %s", err.Error())
+	}
+
+	return injector
+}
+
+func TestInjectLinks(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		expected string
+	}{
+		{
+			name:     "rewrites a web link",
+			body:     `<a href="https://example.com/welcome">Welcome</a>`,
+			expected: `<a href="https://t.example.com/click/abc/0?url=https%3A%2F%2Fexample.com%2Fwelcome&amp;sig=` + Sign([]byte(testSecret), "abc", "https://example.com/welcome") + `">Welcome</a>`,
+		},
+		{
+			name:     "rewrites a single quoted link with attributes",
+			body:     `<A class="button" HREF='http://example.com/?a=1&amp;b=2'>Go</A>`,
+			expected: `<A class="button" HREF="https://t.example.com/click/abc/0?url=http%3A%2F%2Fexample.com%2F%3Fa%3D1%26b%3D2&amp;sig=` + Sign([]byte(testSecret), "abc", "http://example.com/?a=1&b=2") + `">Go</A>`,
+		},
+		{name: "leaves mailto links", body: `<a href="mailto:support@example.com">Contact</a>`, expected: `<a href="mailto:support@example.com">Contact</a>`},
+		{name: "leaves tel links", body: `<a href="tel:+33123456789">Call</a>`, expected: `<a href="tel:+33123456789">Call</a>`},
+		{name: "leaves anchors", body: `<a href="#details">Details</a>`, expected: `<a href="#details">Details</a>`},
+		{name: "leaves the href of other tags", body: `<link href="https://example.com/style.css">`, expected: `<link href="https://example.com/style.css">`},
+	}
+
+	injector := newTestInjector(t, testClickURL, "")
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			body, err := injector.Inject(test.body, "abc")
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+			if body != test.expected {
+				t.Errorf("expected %q, got %q", test.expected, body)
+			}
+		})
+	}
+}
+
+func TestInjectNumbersLinks(t *testing.T) {
+	injector := newTestInjector(t, testClickURL, "")
+	body, err := injector.Inject(`<a href="mailto:jane@example.org">Jane</a><a href="https://example.com/a">A</a><a href="https://example.com/b">B</a>`, "abc")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	hrefs := hrefPattern.FindAllStringSubmatch(body, -1)
+	if len(hrefs) != 3 || !strings.Contains(hrefs[1][1], "/click/abc/0?") || !strings.Contains(hrefs[2][1], "/click/abc/1?") {
+		t.Errorf("expected the web links to be numbered from 0, got %q", body)
+	}
+}
+
+func TestInjectPixel(t *testing.T) {
+	pixel := `<img src="https://t.example.com/open/abc.gif" width="1" height="1" alt="" style="display:none">`
+	tests := []struct {
+		name     string
+		body     string
+		expected string
+	}{
+		{name: "inserts the pixel before the closing body tag", body: "<html><body><p>Hello</p></BODY ></html>", expected: "<html><body><p>Hello</p>" + pixel + "</BODY ></html>"},
+		{name: "inserts the pixel before the last closing body tag", body: "<body><pre></body></pre></body>", expected: "<body><pre></body></pre>" + pixel + "</body>"},
+		{name: "appends the pixel without body tag", body: "<p>Hello</p>", expected: "<p>Hello</p>" + pixel},
+	}
+
+	injector := newTestInjector(t, "", testOpenURL)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			body, err := injector.Inject(test.body, "abc")
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+			if body != test.expected {
+				t.Errorf("expected %q, got %q", test.expected, body)
+			}
+		})
+	}
+}
+
+func TestInjectSignsRedirects(t *testing.T) {
+	trackedURL := "https://example.com/offer?utm_source=email&id=42"
+	injector := newTestInjector(t, testClickURL, "")
+	body, err := injector.Inject(`<a href="`+html.EscapeString(trackedURL)+`">Offer</a>`, "abc")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	href := hrefPattern.FindStringSubmatch(body)
+	if href == nil {
+		t.Fatalf("expected a link, got %q", body)
+	}
+	redirect, err := url.Parse(html.UnescapeString(href[1]))
+	if err != nil {
+		t.Fatalf("unable to parse redirect URL: %s", err.Error())
+	}
+
+	// The redirect endpoint gets the tracked URL and its signature back from the query.
+	query := redirect.Query()
+	if query.Get("url") != trackedURL {
+		t.Errorf("expected the tracked URL %q, got %q", trackedURL, query.Get("url"))
+	}
+	if !VerifySignature([]byte(testSecret), "abc", query.Get("url"), query.Get("sig")) {
+		t.Errorf("expected the signature %q to verify", query.Get("sig"))
+	}
+	if VerifySignature([]byte(testSecret), "abc", "https://attacker.example.com/", query.Get("sig")) {
+		t.Errorf("expected the signature not to verify for another URL")
+	}
+	if VerifySignature([]byte(testSecret), "def", query.Get("url"), query.Get("sig")) {
+		t.Errorf("expected the signature not to verify for another tracking id")
+	}
+	if VerifySignature([]byte("other secret"), "abc", query.Get("url"), query.Get("sig")) {
+		t.Errorf("expected the signature not to verify with another secret")
+	}
+}
+
+func TestInjectNilInjector(t *testing.T) {
+	var injector *Injector
+	if body, err := injector.Inject(`<a href="https://example.com">A</a>`, "abc"); err != nil || body != `<a href="https://example.com">A</a>` {
+		t.Errorf("expected the body to be unchanged, got %q, %v", body, err)
+	}
+}
+
+func TestNewInjectorErrors(t *testing.T) {
+	if _, err := NewInjector("", testClickURL, testOpenURL); err == nil {
+		t.Errorf("expected an injector without secret to fail")
+	}
+	if _, err := NewInjector(testSecret, "https://t.example.com/{{.ID", ""); err == nil || !strings.HasPrefix(err.Error(), "unable to parse click tracking URL template") {
+		t.Errorf("expected an invalid click template to fail, got %v", err)
+	}
+	if _, err := NewInjector(testSecret, "", "https://t.example.com/{{.Unknown}}"); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+}
@@ -0,0 +1,153 @@
+package transport
+
+import (
+	"bytes"
+	"fmt"
+	"gopkg.in/gomail.v2"
+	"io"
+	"math/rand"
+	"net/mail"
+	"net/textproto"
+	"sync"
+)
+
+// FakeMessage is a message recorded by a Fake transport, with its envelope and raw content as it would have been delivered.
+type FakeMessage struct {
+	From       string
+	Recipients []string
+	Raw        []byte
+	ProviderID string
+}
+
+// Parse returns the headers and body of the recorded message.
+func (message FakeMessage) Parse() (*mail.Message, error) {
+	return mail.ReadMessage(bytes.NewReader(message.Raw))
+}
+
+// Fake records the messages in memory instead of delivering them, so the producers of messages can be tested against the hermes pipeline
+// without a mail server. The failures it is given are returned by the next dials or sends, and a random share of the sends may fail with a
+// transient error, ie: to test retries. The zero value records every message. It implements the Dialer interface.
+type Fake struct {
+	mu          sync.Mutex
+	messages    []FakeMessage
+	dialErrors  []error
+	sendErrors  []error
+	failureRate float64
+	random      *rand.Rand
+}
+
+// fakeSender sends messages by recording them in its Fake transport, keeping the id of the last sent message.
+type fakeSender struct {
+	fake      *Fake
+	messageID string
+}
+
+// Dial returns a sender recording in the fake transport, or the next dial failure it was given.
+func (fake *Fake) Dial() (gomail.SendCloser, error) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	if len(fake.dialErrors) > 0 {
+		err := fake.dialErrors[0]
+		fake.dialErrors = fake.dialErrors[1:]
+		return nil, err
+	}
+
+	return &fakeSender{fake: fake}, nil
+}
+
+// FailDials makes the next dials fail with the errors, in order.
+func (fake *Fake) FailDials(errs ...error) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	fake.dialErrors = append(fake.dialErrors, errs...)
+}
+
+// FailSends makes the next sends fail with the errors, in order, ie: a *textproto.Error with a 5xx code for a rejected message.
+func (fake *Fake) FailSends(errs ...error) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	fake.sendErrors = append(fake.sendErrors, errs...)
+}
+
+// FailRandomly makes the given share of the sends, from 0 to 1, fail with a transient 451 error. The failures are drawn from the seed,
+// so a test run is repeatable.
+func (fake *Fake) FailRandomly(rate float64, seed int64) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	fake.failureRate = rate
+	fake.random = rand.New(rand.NewSource(seed))
+}
+
+// Messages returns the recorded messages, in the order they were sent.
+func (fake *Fake) Messages() []FakeMessage {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	return append([]FakeMessage(nil), fake.messages...)
+}
+
+// Reset forgets the recorded messages and the failures which were not returned yet.
+func (fake *Fake) Reset() {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	fake.messages = nil
+	fake.dialErrors = nil
+	fake.sendErrors = nil
+	fake.failureRate = 0
+}
+
+// record keeps the sent message, returning its provider id, or fails it with the next send failure.
+func (fake *Fake) record(from string, to []string, raw []byte) (string, error) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	if len(fake.sendErrors) > 0 {
+		err := fake.sendErrors[0]
+		fake.sendErrors = fake.sendErrors[1:]
+		return "", err
+	}
+	if fake.failureRate > 0 && fake.random.Float64() < fake.failureRate {
+		return "", &textproto.Error{Code: 451, Msg: "4.3.0 Injected failure of the fake transport"}
+	}
+
+	providerID := fmt.Sprintf("fake-%d", len(fake.messages)+1)
+	fake.messages = append(fake.messages, FakeMessage{From: from, Recipients: append([]string(nil), to...), Raw: raw, ProviderID: providerID})
+
+	return providerID, nil
+}
+
+// Send writes the raw message and records it.
+func (sender *fakeSender) Send(from string, to []string, msg io.WriterTo) error {
+	var rawMessage bytes.Buffer
+	if _, err := msg.WriteTo(&rawMessage); err != nil {
+		return fmt.Errorf("unable to write raw message: %s", err.Error())
+	}
+
+	providerID, err := sender.fake.record(from, to, rawMessage.Bytes())
+	if err != nil {
+		return err
+	}
+	sender.messageID = providerID
+
+	return nil
+}
+
+// ProviderMessageID returns the id the fake transport gave to the last sent message.
+func (sender *fakeSender) ProviderMessageID() string {
+	return sender.messageID
+}
+
+// Close does nothing, as there is no connection to close.
+func (sender *fakeSender) Close() error {
+	return nil
+}
+
+// NewFake instanciates a Fake transport recording every message.
+func NewFake() *Fake {
+	return &Fake{}
+}
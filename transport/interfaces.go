@@ -0,0 +1,60 @@
+package transport
+
+import (
+	"context"
+	"gopkg.in/gomail.v2"
+	"time"
+)
+
+// Dialer interface should be implemented by any service responsible to deliver built messages (SMTP relay, SES API... etc).
+// The standard *gomail.Dialer implements it.
+type Dialer interface {
+	// Dial should open the connection or client used to send messages. The returned SendCloser should be closed when done using it.
+	Dial() (gomail.SendCloser, error)
+}
+
+// ContextDialer interface should be implemented by dialers able to bound their dial by a context, ie: so the retries of a busy SMTP server
+// stop before the lambda deadline.
+type ContextDialer interface {
+	// DialContext should open the connection as Dial does, giving up once the context is done.
+	DialContext(ctx context.Context) (gomail.SendCloser, error)
+}
+
+// Dial opens a connection of the dialer, bounded by the context when the dialer is a ContextDialer.
+func Dial(ctx context.Context, dialer Dialer) (gomail.SendCloser, error) {
+	if contextDialer, ok := dialer.(ContextDialer); ok {
+		return contextDialer.DialContext(ctx)
+	}
+
+	return dialer.Dial()
+}
+
+// CredentialsProvider interface should be implemented by any service able to give the current credentials of a relay, ie: from a rotated secret.
+type CredentialsProvider interface {
+	// Credentials should return the username and password to authenticate with.
+	Credentials() (string, string, error)
+}
+
+// TokenProvider interface should be implemented by any service able to give a current OAuth2 access token, ie: from a token endpoint.
+type TokenProvider interface {
+	// Token should return an access token which is not expired.
+	Token() (string, error)
+}
+
+// MessageIDReporter interface should be implemented by senders able to report the id given by the provider to the last sent message.
+type MessageIDReporter interface {
+	// ProviderMessageID should return the provider id of the last sent message.
+	ProviderMessageID() string
+}
+
+// HealthChecker interface should be implemented by senders able to check their connection is still usable, ie: with an SMTP NOOP command.
+type HealthChecker interface {
+	// Check should return an error when the connection can't send messages anymore.
+	Check() error
+}
+
+// DeadlineSetter interface should be implemented by senders able to bound their network operations, ie: so a send fails before the lambda deadline.
+type DeadlineSetter interface {
+	// SetDeadline should make the reads and writes of the connection fail once passed, the zero time meaning no deadline.
+	SetDeadline(deadline time.Time) error
+}
@@ -0,0 +1,98 @@
+package transport
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"gopkg.in/gomail.v2"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Mailgun handles sending raw messages through the Mailgun HTTP API. It implements the Dialer interface.
+type Mailgun struct {
+	endpoint   string
+	apiKey     string
+	httpClient *http.Client
+}
+
+// mailgunSender sends messages with the Mailgun API, keeping the id of the last sent message.
+type mailgunSender struct {
+	*Mailgun
+	messageID string
+}
+
+// Dial returns a sender using the Mailgun API, as it does not hold any connection.
+func (mailgunConnector *Mailgun) Dial() (gomail.SendCloser, error) {
+	return &mailgunSender{Mailgun: mailgunConnector}, nil
+}
+
+// Send posts the raw message to the MIME messages API, with every To, Cc and Bcc recipient as destination.
+func (sender *mailgunSender) Send(_ string, to []string, msg io.WriterTo) error {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	for _, address := range to {
+		if err := form.WriteField("to", address); err != nil {
+			return fmt.Errorf("unable to write Mailgun form: %s", err.Error())
+		}
+	}
+	messageField, err := form.CreateFormFile("message", "message.eml")
+	if err != nil {
+		return fmt.Errorf("unable to write Mailgun form: %s", err.Error())
+	}
+	if _, err := msg.WriteTo(messageField); err != nil {
+		return fmt.Errorf("unable to write raw message: %s", err.Error())
+	}
+	if err := form.Close(); err != nil {
+		return fmt.Errorf("unable to write Mailgun form: %s", err.Error())
+	}
+
+	request, err := http.NewRequest(http.MethodPost, sender.endpoint, &body)
+	if err != nil {
+		return fmt.Errorf("unable to build Mailgun request: %s", err.Error())
+	}
+	request.SetBasicAuth("api", sender.apiKey)
+	request.Header.Set("Content-Type", form.FormDataContentType())
+
+	response, err := sender.httpClient.Do(request)
+	if err != nil {
+		return fmt.Errorf("unable to send email through Mailgun: %s", err.Error())
+	}
+	defer response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		details, _ := ioutil.ReadAll(io.LimitReader(response.Body, 4096))
+		return fmt.Errorf("unable to send email through Mailgun: %s %s", response.Status, details)
+	}
+
+	var result struct {
+		ID string `json:"id"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&result); err == nil {
+		sender.messageID = strings.Trim(result.ID, "<>")
+	}
+
+	return nil
+}
+
+// ProviderMessageID returns the id Mailgun gave to the last sent message.
+func (sender *mailgunSender) ProviderMessageID() string {
+	return sender.messageID
+}
+
+// Close does nothing, as there is no connection to close.
+func (sender *mailgunSender) Close() error {
+	return nil
+}
+
+// NewMailgun instanciates a Mailgun transport sending from the domain, through the API base URL, ie: https://api.eu.mailgun.net for the EU region.
+func NewMailgun(apiBase string, domain string, apiKey string) *Mailgun {
+	return &Mailgun{
+		endpoint:   strings.TrimSuffix(apiBase, "/") + "/v3/" + domain + "/messages.mime",
+		apiKey:     apiKey,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
@@ -0,0 +1,166 @@
+package transport
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"strings"
+)
+
+// mimePart is an attached or embedded file of a parsed message.
+type mimePart struct {
+	filename    string
+	contentType string
+	contentID   string
+	inline      bool
+	content     []byte
+}
+
+// parsedMessage is the content of a raw message, for the HTTP APIs which don't accept raw MIME messages.
+type parsedMessage struct {
+	header      mail.Header
+	subject     string
+	text        string
+	html        string
+	attachments []mimePart
+}
+
+var headerDecoder = &mime.WordDecoder{}
+
+// decodePartBody decodes the part content of its transfer encoding.
+func decodePartBody(header textproto.MIMEHeader, body io.Reader) ([]byte, error) {
+	switch strings.ToLower(header.Get("Content-Transfer-Encoding")) {
+	case "base64":
+		return ioutil.ReadAll(base64.NewDecoder(base64.StdEncoding, body))
+	case "quoted-printable":
+		return ioutil.ReadAll(quotedprintable.NewReader(body))
+	default:
+		return ioutil.ReadAll(body)
+	}
+}
+
+// walkPart collects the bodies and files of a MIME part, recursing in multipart ones. Only the first text and HTML bodies are kept.
+func (parsed *parsedMessage) walkPart(header textproto.MIMEHeader, body io.Reader) error {
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		mediaType, params = "text/plain", map[string]string{}
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		reader := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := reader.NextRawPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("invalid multipart body: %s", err.Error())
+			}
+			if err := parsed.walkPart(part.Header, part); err != nil {
+				return err
+			}
+		}
+	}
+
+	content, err := decodePartBody(header, body)
+	if err != nil {
+		return fmt.Errorf("unable to decode %s part: %s", mediaType, err.Error())
+	}
+
+	disposition, dispositionParams, _ := mime.ParseMediaType(header.Get("Content-Disposition"))
+	if disposition == "" && parsed.text == "" && mediaType == "text/plain" {
+		parsed.text = string(content)
+		return nil
+	}
+	if disposition == "" && parsed.html == "" && mediaType == "text/html" {
+		parsed.html = string(content)
+		return nil
+	}
+	if disposition == "" && strings.HasPrefix(mediaType, "text/") {
+		return nil
+	}
+
+	filename := dispositionParams["filename"]
+	if filename == "" {
+		filename = params["name"]
+	}
+	parsed.attachments = append(parsed.attachments, mimePart{
+		filename:    filename,
+		contentType: mediaType,
+		contentID:   strings.Trim(header.Get("Content-ID"), "<>"),
+		inline:      disposition == "inline",
+		content:     content,
+	})
+
+	return nil
+}
+
+// parseMessage parses a raw message into its headers, bodies and files.
+func parseMessage(msg io.WriterTo) (*parsedMessage, error) {
+	var raw bytes.Buffer
+	if _, err := msg.WriteTo(&raw); err != nil {
+		return nil, fmt.Errorf("unable to write raw message: %s", err.Error())
+	}
+	message, err := mail.ReadMessage(&raw)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse raw message: %s", err.Error())
+	}
+
+	parsed := &parsedMessage{header: message.Header}
+	if parsed.subject, err = headerDecoder.DecodeHeader(message.Header.Get("Subject")); err != nil {
+		parsed.subject = message.Header.Get("Subject")
+	}
+	if err := parsed.walkPart(textproto.MIMEHeader(message.Header), message.Body); err != nil {
+		return nil, err
+	}
+
+	return parsed, nil
+}
+
+// addresses returns the addresses of an address header, ignoring a missing or invalid one.
+func (parsed *parsedMessage) addresses(field string) []*mail.Address {
+	addresses, err := parsed.header.AddressList(field)
+	if err != nil {
+		return nil
+	}
+
+	return addresses
+}
+
+// blindRecipients returns the envelope recipients missing from the To and Cc headers, which are the Bcc ones.
+func (parsed *parsedMessage) blindRecipients(envelope []string) []string {
+	visible := make(map[string]bool)
+	for _, field := range []string{"To", "Cc"} {
+		for _, address := range parsed.addresses(field) {
+			visible[strings.ToLower(address.Address)] = true
+		}
+	}
+
+	var blind []string
+	for _, address := range envelope {
+		if !visible[strings.ToLower(address)] {
+			blind = append(blind, address)
+		}
+	}
+
+	return blind
+}
+
+// customHeaders returns the headers the HTTP APIs don't build themselves, ie: List-Unsubscribe or X-* ones.
+func (parsed *parsedMessage) customHeaders() map[string]string {
+	headers := make(map[string]string)
+	for name, values := range parsed.header {
+		if strings.HasPrefix(name, "X-") || strings.HasPrefix(name, "List-") {
+			headers[name] = strings.Join(values, ", ")
+		}
+	}
+
+	return headers
+}
@@ -0,0 +1,107 @@
+package transport
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tokenRefreshMargin is how long before its expiry a token is renewed, so it doesn't expire during a dial.
+const tokenRefreshMargin = time.Minute
+
+// xoauth2Auth implements the XOAUTH2 authentication mechanism of Gmail and Microsoft 365, sending an OAuth2 access token as bearer.
+type xoauth2Auth struct {
+	username string
+	token    string
+}
+
+func (auth *xoauth2Auth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if !server.TLS {
+		return "", nil, fmt.Errorf("XOAUTH2 requires a TLS connection")
+	}
+
+	return "XOAUTH2", []byte("user=" + auth.username + "\x01auth=Bearer " + auth.token + "\x01\x01"), nil
+}
+
+// Next answers the error challenge sent when the token is refused with an empty response, so the server replies with the error.
+func (auth *xoauth2Auth) Next(_ []byte, more bool) ([]byte, error) {
+	if more {
+		return []byte{}, nil
+	}
+
+	return nil, nil
+}
+
+// OAuth2ClientCredentials gets OAuth2 access tokens from a token endpoint with the client credentials grant, ie: from Microsoft identity platform
+// with the https://outlook.office365.com/.default scope. It implements the TokenProvider interface.
+type OAuth2ClientCredentials struct {
+	tokenURL     string
+	clientID     string
+	clientSecret string
+	scope        string
+	httpClient   *http.Client
+
+	mu        sync.Mutex
+	token     string
+	expiresAt time.Time
+}
+
+type tokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	ExpiresIn        int    `json:"expires_in"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// Token returns the current access token, requesting a new one when it is about to expire.
+func (provider *OAuth2ClientCredentials) Token() (string, error) {
+	provider.mu.Lock()
+	defer provider.mu.Unlock()
+
+	if provider.token != "" && time.Now().Add(tokenRefreshMargin).Before(provider.expiresAt) {
+		return provider.token, nil
+	}
+
+	form := url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {provider.clientID},
+		"client_secret": {provider.clientSecret},
+	}
+	if provider.scope != "" {
+		form.Set("scope", provider.scope)
+	}
+	response, err := provider.httpClient.PostForm(provider.tokenURL, form)
+	if err != nil {
+		return "", fmt.Errorf("unable to request access token: %s", err.Error())
+	}
+	defer response.Body.Close()
+
+	var token tokenResponse
+	if err := json.NewDecoder(response.Body).Decode(&token); err != nil {
+		return "", fmt.Errorf("unable to decode access token response (status %d): %s", response.StatusCode, err.Error())
+	}
+	if response.StatusCode != http.StatusOK || token.AccessToken == "" {
+		return "", fmt.Errorf("access token request failed with status %d: %s", response.StatusCode, strings.TrimSpace(token.Error+" "+token.ErrorDescription))
+	}
+
+	provider.token = token.AccessToken
+	provider.expiresAt = time.Now().Add(time.Duration(token.ExpiresIn) * time.Second)
+
+	return provider.token, nil
+}
+
+// NewOAuth2ClientCredentials instanciates an OAuth2ClientCredentials requesting tokens of the scope for the client from the token endpoint.
+func NewOAuth2ClientCredentials(tokenURL string, clientID string, clientSecret string, scope string) *OAuth2ClientCredentials {
+	return &OAuth2ClientCredentials{
+		tokenURL:     tokenURL,
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		scope:        scope,
+		httpClient:   &http.Client{Timeout: 30 * time.Second},
+	}
+}
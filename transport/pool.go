@@ -0,0 +1,222 @@
+package transport
+
+import (
+	"context"
+	"gopkg.in/gomail.v2"
+	"io"
+	"log"
+	"sync"
+	"time"
+)
+
+// Pool keeps the connections of a dialer open between messages, so the records of a batch reuse them instead of dialing for each one.
+// It implements the Dialer interface: closing a sender returns its connection to the pool, and CloseIdle closes them for good.
+type Pool struct {
+	// MaxIdle is the number of idle connections kept at most, the other ones being closed when returned. Zero means no limit.
+	MaxIdle int
+	// MaxMessages is the number of messages sent at most on a connection before it is closed, as some providers limit it. Zero means no limit.
+	MaxMessages int
+	// IdleTimeout closes the connections idle for longer, before the server drops them. Zero means they are kept until CloseIdle.
+	IdleTimeout time.Duration
+	// CheckAfter is how long a connection may be idle before being checked when reused, if it is able to, ie: with an SMTP NOOP command.
+	// Zero means the connections are never checked.
+	CheckAfter time.Duration
+	dialer     Dialer
+	mu         sync.Mutex
+	idle       []*pooledConnection
+}
+
+// pooledConnection is a connection of the pool, with the number of messages it sent and the date it was returned to the pool.
+type pooledConnection struct {
+	sender    gomail.SendCloser
+	messages  int
+	idleSince time.Time
+}
+
+// pooledSender sends messages with a connection of the pool.
+type pooledSender struct {
+	pool       *Pool
+	connection *pooledConnection
+	failed     bool
+	// deadline tells the connection was given a deadline, which is removed before it is returned to the pool.
+	deadline bool
+}
+
+// closeConnection closes a connection leaving the pool, logging the failures as the connection is not used anymore.
+func closeConnection(connection *pooledConnection) {
+	if err := connection.sender.Close(); err != nil {
+		log.Printf("Unable to close pooled connection: %s", err.Error())
+	}
+}
+
+// take returns the most recently used idle connection which is still usable, closing the expired and unhealthy ones, or nil if there is none.
+func (pool *Pool) take() *pooledConnection {
+	for {
+		pool.mu.Lock()
+		count := len(pool.idle)
+		if count == 0 {
+			pool.mu.Unlock()
+			return nil
+		}
+		connection := pool.idle[count-1]
+		pool.idle = pool.idle[:count-1]
+		pool.mu.Unlock()
+
+		idleFor := time.Since(connection.idleSince)
+		if pool.IdleTimeout > 0 && idleFor > pool.IdleTimeout {
+			closeConnection(connection)
+			continue
+		}
+		if checker, ok := connection.sender.(HealthChecker); ok && pool.CheckAfter > 0 && idleFor > pool.CheckAfter {
+			if err := checker.Check(); err != nil {
+				log.Printf("Dropping pooled connection, which failed its health check: %s", err.Error())
+				// The connection is already broken, so closing it may fail too.
+				connection.sender.Close()
+				continue
+			}
+		}
+		return connection
+	}
+}
+
+// Dial returns a sender using an idle connection of the pool, or a new one when none is idle.
+func (pool *Pool) Dial() (gomail.SendCloser, error) {
+	return pool.DialContext(context.Background())
+}
+
+// DialContext returns a sender as Dial does, a new connection being dialed within the context.
+func (pool *Pool) DialContext(ctx context.Context) (gomail.SendCloser, error) {
+	if connection := pool.take(); connection != nil {
+		return &pooledSender{pool: pool, connection: connection}, nil
+	}
+
+	sender, err := Dial(ctx, pool.dialer)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pooledSender{pool: pool, connection: &pooledConnection{sender: sender}}, nil
+}
+
+// release returns a connection to the pool, unless it sent its maximum number of messages or the pool has enough idle connections.
+func (pool *Pool) release(connection *pooledConnection) error {
+	if pool.MaxMessages > 0 && connection.messages >= pool.MaxMessages {
+		return connection.sender.Close()
+	}
+
+	pool.mu.Lock()
+	if pool.MaxIdle > 0 && len(pool.idle) >= pool.MaxIdle {
+		pool.mu.Unlock()
+		return connection.sender.Close()
+	}
+	connection.idleSince = time.Now()
+	pool.idle = append(pool.idle, connection)
+	pool.mu.Unlock()
+
+	return nil
+}
+
+// CloseExpired closes the connections idle for longer than the idle timeout, ie: between the batches of the daemon mode.
+func (pool *Pool) CloseExpired() {
+	if pool == nil || pool.IdleTimeout <= 0 {
+		return
+	}
+
+	pool.mu.Lock()
+	var expired []*pooledConnection
+	kept := pool.idle[:0]
+	for _, connection := range pool.idle {
+		if time.Since(connection.idleSince) > pool.IdleTimeout {
+			expired = append(expired, connection)
+		} else {
+			kept = append(kept, connection)
+		}
+	}
+	pool.idle = kept
+	pool.mu.Unlock()
+
+	for _, connection := range expired {
+		closeConnection(connection)
+	}
+}
+
+// CloseIdle closes the idle connections of the pool, ie: at the end of an invocation, before the lambda is frozen.
+func (pool *Pool) CloseIdle() {
+	if pool == nil {
+		return
+	}
+
+	pool.mu.Lock()
+	idle := pool.idle
+	pool.idle = nil
+	pool.mu.Unlock()
+
+	for _, connection := range idle {
+		closeConnection(connection)
+	}
+}
+
+// Pools groups the pools of several transports, the nil ones being skipped.
+type Pools []*Pool
+
+// CloseExpired closes the expired idle connections of every pool.
+func (pools Pools) CloseExpired() {
+	for _, pool := range pools {
+		pool.CloseExpired()
+	}
+}
+
+// CloseIdle closes the idle connections of every pool.
+func (pools Pools) CloseIdle() {
+	for _, pool := range pools {
+		pool.CloseIdle()
+	}
+}
+
+// Send sends the message with the pooled connection, which is not returned to the pool if it fails.
+func (sender *pooledSender) Send(from string, to []string, msg io.WriterTo) error {
+	err := sender.connection.sender.Send(from, to, msg)
+	sender.failed = err != nil
+	sender.connection.messages++
+
+	return err
+}
+
+// ProviderMessageID returns the id the provider gave to the last sent message, if the connection reports one.
+func (sender *pooledSender) ProviderMessageID() string {
+	if reporter, ok := sender.connection.sender.(MessageIDReporter); ok {
+		return reporter.ProviderMessageID()
+	}
+
+	return ""
+}
+
+// SetDeadline bounds the pooled connection until it is returned to the pool, if it is able to.
+func (sender *pooledSender) SetDeadline(deadline time.Time) error {
+	setter, ok := sender.connection.sender.(DeadlineSetter)
+	if !ok {
+		return nil
+	}
+
+	sender.deadline = true
+	return setter.SetDeadline(deadline)
+}
+
+// Close returns the connection to the pool, or closes it when its last send failed, as it may be in the middle of a transaction.
+func (sender *pooledSender) Close() error {
+	if sender.failed {
+		return sender.connection.sender.Close()
+	}
+	if sender.deadline {
+		if err := sender.connection.sender.(DeadlineSetter).SetDeadline(time.Time{}); err != nil {
+			return sender.connection.sender.Close()
+		}
+	}
+
+	return sender.pool.release(sender.connection)
+}
+
+// NewPool instanciates a Pool of connections opened by the dialer.
+func NewPool(dialer Dialer) *Pool {
+	return &Pool{dialer: dialer}
+}
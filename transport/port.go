@@ -0,0 +1,60 @@
+package transport
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// TLSMode is the way the SMTP connection is secured.
+type TLSMode int
+
+const (
+	// TLSStartTLS connects in plain text and upgrades the connection with STARTTLS when the server supports it.
+	TLSStartTLS TLSMode = iota
+	// TLSImplicit connects with TLS from the start.
+	TLSImplicit
+	// TLSStartTLSRequired connects in plain text and upgrades the connection with STARTTLS, failing when the server doesn't support it.
+	TLSStartTLSRequired
+)
+
+// Port is an SMTP port along with the TLS mode it implies.
+type Port struct {
+	Number int
+	TLS    TLSMode
+}
+
+// portPresets are the well known SMTP port names.
+var portPresets = map[string]Port{
+	"smtp":       {Number: 25, TLS: TLSStartTLS},
+	"submission": {Number: 587, TLS: TLSStartTLS},
+	"smtps":      {Number: 465, TLS: TLSImplicit},
+}
+
+// ParsePort reads either a port number, implicit TLS being used on 465 only, or one of the "smtp", "submission" and "smtps" presets.
+func ParsePort(value string) (Port, error) {
+	if preset, ok := portPresets[strings.ToLower(strings.TrimSpace(value))]; ok {
+		return preset, nil
+	}
+
+	number, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil || number <= 0 || number > 65535 {
+		return Port{}, fmt.Errorf("invalid SMTP port %q, expecting a port number or one of smtp, submission and smtps", value)
+	}
+	if number == portPresets["smtps"].Number {
+		return Port{Number: number, TLS: TLSImplicit}, nil
+	}
+
+	return Port{Number: number, TLS: TLSStartTLS}, nil
+}
+
+// UnmarshalText parses the port, so it can be read from an environment variable.
+func (port *Port) UnmarshalText(text []byte) error {
+	parsed, err := ParsePort(string(text))
+	if err != nil {
+		return err
+	}
+	*port = parsed
+
+	return nil
+}
@@ -0,0 +1,106 @@
+package transport
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParsePort(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected Port
+		err      bool
+	}{
+		{value: "smtp", expected: Port{Number: 25, TLS: TLSStartTLS}},
+		{value: "submission", expected: Port{Number: 587, TLS: TLSStartTLS}},
+		{value: "smtps", expected: Port{Number: 465, TLS: TLSImplicit}},
+		{value: " SMTPS ", expected: Port{Number: 465, TLS: TLSImplicit}},
+		{value: "Submission", expected: Port{Number: 587, TLS: TLSStartTLS}},
+		{value: "465", expected: Port{Number: 465, TLS: TLSImplicit}},
+		{value: "587", expected: Port{Number: 587, TLS: TLSStartTLS}},
+		{value: "2525", expected: Port{Number: 2525, TLS: TLSStartTLS}},
+		{value: "65535", expected: Port{Number: 65535, TLS: TLSStartTLS}},
+		{value: "", err: true},
+		{value: "0", err: true},
+		{value: "-25", err: true},
+		{value: "65536", err: true},
+		{value: "imaps", err: true},
+		{value: "587/tcp", err: true},
+	}
+
+	for _, test := range tests {
+		port, err := ParsePort(test.value)
+		if test.err {
+			if err == nil || !strings.Contains(err.Error(), "expecting a port number or one of smtp, submission and smtps") {
+				t.Errorf("expected %q to be rejected, got %+v, %v", test.value, port, err)
+			}
+			continue
+		}
+		if err != nil || port != test.expected {
+			t.Errorf("expected %q to be %+v, got %+v, %v", test.value, test.expected, port, err)
+		}
+	}
+}
+
+func TestPortUnmarshalText(t *testing.T) {
+	port := Port{Number: 465, TLS: TLSImplicit}
+	if err := port.UnmarshalText([]byte("submission")); err != nil || port != (Port{Number: 587, TLS: TLSStartTLS}) {
+		t.Errorf("expected the submission preset, got %+v, %v", port, err)
+	}
+	if err := port.UnmarshalText([]byte("nope")); err == nil || port != (Port{Number: 587, TLS: TLSStartTLS}) {
+		t.Errorf("expected an invalid port to be rejected and leave the port unchanged, got %+v, %v", port, err)
+	}
+}
+
+func TestNewSMTPTLSMode(t *testing.T) {
+	tests := []struct {
+		preset   string
+		mode     string
+		ssl      bool
+		required bool
+	}{
+		{preset: "smtp", ssl: false},
+		{preset: "submission", ssl: false},
+		{preset: "smtps", ssl: true},
+		{preset: "submission", mode: "starttls-required", required: true},
+		{preset: "submission", mode: "implicit", ssl: true},
+	}
+
+	for _, test := range tests {
+		port, _ := ParsePort(test.preset)
+		if test.mode != "" {
+			port.TLS, _ = ParseTLSMode(test.mode)
+		}
+		smtpTransport := NewSMTP("smtp.example.com", port, "user", "password")
+		if smtpTransport.Dialer.Port != port.Number || smtpTransport.Dialer.SSL != test.ssl || smtpTransport.RequireStartTLS != test.required {
+			t.Errorf("expected %s %s to dial port %d with implicit TLS %t and STARTTLS required %t, got port %d with %t and %t", test.preset, test.mode, port.Number, test.ssl, test.required, smtpTransport.Dialer.Port, smtpTransport.Dialer.SSL, smtpTransport.RequireStartTLS)
+		}
+	}
+}
+
+func TestParseTLSMode(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected TLSMode
+		err      bool
+	}{
+		{value: "starttls", expected: TLSStartTLS},
+		{value: " STARTTLS-Required ", expected: TLSStartTLSRequired},
+		{value: "implicit", expected: TLSImplicit},
+		{value: "none", err: true},
+		{value: "", err: true},
+	}
+
+	for _, test := range tests {
+		mode, err := ParseTLSMode(test.value)
+		if test.err {
+			if err == nil || !strings.Contains(err.Error(), "expecting starttls, starttls-required or implicit") {
+				t.Errorf("expected %q to be rejected, got %v, %v", test.value, mode, err)
+			}
+			continue
+		}
+		if err != nil || mode != test.expected {
+			t.Errorf("expected %q to be %v, got %v, %v", test.value, test.expected, mode, err)
+		}
+	}
+}
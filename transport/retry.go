@@ -0,0 +1,155 @@
+package transport
+
+import (
+	"context"
+	"gopkg.in/gomail.v2"
+	"io"
+	"log"
+	"net"
+	"net/textproto"
+	"time"
+)
+
+// Retry sends again, on a new connection, the messages whose sending failed with a transient error, ie: a 4xx greylisting or rate limiting
+// reply, or a dropped connection. Permanent 5xx replies and local failures are never retried. It implements the Dialer interface.
+type Retry struct {
+	dialer Dialer
+	// Retries is the number of additional attempts of a message.
+	Retries int
+	// Backoff is the delay before the first retry, doubled after each attempt.
+	Backoff time.Duration
+	// MaxElapsed bounds the time spent retrying a message, a retry whose backoff would exceed it being skipped. Zero means no bound.
+	MaxElapsed time.Duration
+}
+
+// retryingSender sends messages with a connection of the dialer, redialing it on transient failures.
+type retryingSender struct {
+	retry      *Retry
+	connection gomail.SendCloser
+	messageID  string
+	deadline   time.Time
+}
+
+// isTransientSendError tells if sending may succeed on a new connection, ie: the connection was dropped or the server replied with a 4xx code.
+// Any other failure, ie: an attachment which can't be read or a message which can't be signed while it is written, fails again on a new connection.
+func isTransientSendError(err error) bool {
+	switch err := err.(type) {
+	case *textproto.Error:
+		return err.Code >= 400 && err.Code < 500
+	case net.Error:
+		return true
+	default:
+		return err == io.EOF || err == io.ErrUnexpectedEOF
+	}
+}
+
+// Dial opens a connection of the dialer.
+func (retry *Retry) Dial() (gomail.SendCloser, error) {
+	return retry.DialContext(context.Background())
+}
+
+// DialContext opens a connection of the dialer within the context.
+func (retry *Retry) DialContext(ctx context.Context) (gomail.SendCloser, error) {
+	connection, err := Dial(ctx, retry.dialer)
+	if err != nil {
+		return nil, err
+	}
+
+	return &retryingSender{retry: retry, connection: connection}, nil
+}
+
+// Send sends the message, redialing and retrying with an exponential backoff while it fails with a transient error.
+func (sender *retryingSender) Send(from string, to []string, msg io.WriterTo) error {
+	start := time.Now()
+	backoff := sender.retry.Backoff
+	for attempt := 0; ; attempt++ {
+		if sender.connection == nil {
+			var err error
+			if sender.connection, err = sender.redial(); err != nil {
+				return err
+			}
+			if setter, ok := sender.connection.(DeadlineSetter); ok && !sender.deadline.IsZero() {
+				if err := setter.SetDeadline(sender.deadline); err != nil {
+					return err
+				}
+			}
+		}
+
+		err := sender.connection.Send(from, to, msg)
+		if err == nil {
+			if reporter, ok := sender.connection.(MessageIDReporter); ok {
+				sender.messageID = reporter.ProviderMessageID()
+			}
+			return nil
+		}
+		// A failed connection may be in the middle of a transaction, so it is never reused.
+		sender.connection.Close()
+		sender.connection = nil
+		if attempt >= sender.retry.Retries || !isTransientSendError(err) {
+			return err
+		}
+		if sender.retry.MaxElapsed > 0 && time.Since(start)+backoff > sender.retry.MaxElapsed {
+			return err
+		}
+		if !sender.deadline.IsZero() && time.Now().Add(backoff).After(sender.deadline) {
+			return err
+		}
+
+		log.Printf("Unable to send message (%s), retrying on a new connection in %s", err.Error(), backoff)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// redial opens a new connection of the dialer, bounded by the deadline of the sender if it has one.
+func (sender *retryingSender) redial() (gomail.SendCloser, error) {
+	ctx := context.Background()
+	if !sender.deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, sender.deadline)
+		defer cancel()
+	}
+
+	return Dial(ctx, sender.retry.dialer)
+}
+
+// ProviderMessageID returns the id the provider gave to the last sent message, if the connection reports one.
+func (sender *retryingSender) ProviderMessageID() string {
+	return sender.messageID
+}
+
+// Check checks the connection when it is able to, a connection closed by a failed send being redialed by the next one.
+func (sender *retryingSender) Check() error {
+	if checker, ok := sender.connection.(HealthChecker); ok {
+		return checker.Check()
+	}
+
+	return nil
+}
+
+// SetDeadline bounds the connection, and the ones redialed by the retries, which are skipped when their backoff would exceed the deadline.
+func (sender *retryingSender) SetDeadline(deadline time.Time) error {
+	sender.deadline = deadline
+	if setter, ok := sender.connection.(DeadlineSetter); ok {
+		return setter.SetDeadline(deadline)
+	}
+
+	return nil
+}
+
+// Close closes the connection, unless a failed send already did.
+func (sender *retryingSender) Close() error {
+	if sender.connection == nil {
+		return nil
+	}
+
+	err := sender.connection.Close()
+	sender.connection = nil
+
+	return err
+}
+
+// NewRetry instanciates a Retry of the messages sent through the dialer.
+func NewRetry(dialer Dialer) *Retry {
+	return &Retry{dialer: dialer}
+}
@@ -0,0 +1,162 @@
+package transport
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"gopkg.in/gomail.v2"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/mail"
+	"time"
+)
+
+// sendGridEndpoint is the URL of the SendGrid v3 mail send API.
+const sendGridEndpoint = "https://api.sendgrid.com/v3/mail/send"
+
+// SendGrid handles sending messages through the SendGrid HTTP API. It implements the Dialer interface.
+type SendGrid struct {
+	apiKey     string
+	httpClient *http.Client
+}
+
+// sendGridSender sends messages with the SendGrid API, keeping the id of the last sent message.
+type sendGridSender struct {
+	*SendGrid
+	messageID string
+}
+
+type sendGridAddress struct {
+	Email string `json:"email"`
+	Name  string `json:"name,omitempty"`
+}
+
+type sendGridPersonalization struct {
+	To  []sendGridAddress `json:"to,omitempty"`
+	Cc  []sendGridAddress `json:"cc,omitempty"`
+	Bcc []sendGridAddress `json:"bcc,omitempty"`
+}
+
+type sendGridContent struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type sendGridAttachment struct {
+	Content     string `json:"content"`
+	Type        string `json:"type,omitempty"`
+	Filename    string `json:"filename"`
+	Disposition string `json:"disposition,omitempty"`
+	ContentID   string `json:"content_id,omitempty"`
+}
+
+type sendGridMail struct {
+	Personalizations []sendGridPersonalization `json:"personalizations"`
+	From             sendGridAddress           `json:"from"`
+	ReplyTo          *sendGridAddress          `json:"reply_to,omitempty"`
+	Subject          string                    `json:"subject"`
+	Content          []sendGridContent         `json:"content"`
+	Attachments      []sendGridAttachment      `json:"attachments,omitempty"`
+	Headers          map[string]string         `json:"headers,omitempty"`
+}
+
+// Dial returns a sender using the SendGrid API, as it does not hold any connection.
+func (sendGridConnector *SendGrid) Dial() (gomail.SendCloser, error) {
+	return &sendGridSender{SendGrid: sendGridConnector}, nil
+}
+
+func toSendGridAddresses(addresses []*mail.Address) []sendGridAddress {
+	converted := make([]sendGridAddress, len(addresses))
+	for i, address := range addresses {
+		converted[i] = sendGridAddress{Email: address.Address, Name: address.Name}
+	}
+
+	return converted
+}
+
+// sendGridMailFromMessage converts a raw message to a SendGrid mail, the recipients absent from its headers being sent as Bcc.
+func sendGridMailFromMessage(parsed *parsedMessage, from string, to []string) sendGridMail {
+	personalization := sendGridPersonalization{To: toSendGridAddresses(parsed.addresses("To")), Cc: toSendGridAddresses(parsed.addresses("Cc"))}
+	for _, address := range parsed.blindRecipients(to) {
+		personalization.Bcc = append(personalization.Bcc, sendGridAddress{Email: address})
+	}
+
+	sendGridMessage := sendGridMail{
+		Personalizations: []sendGridPersonalization{personalization},
+		From:             sendGridAddress{Email: from},
+		Subject:          parsed.subject,
+		Headers:          parsed.customHeaders(),
+	}
+	if fromAddresses := parsed.addresses("From"); len(fromAddresses) > 0 {
+		sendGridMessage.From.Name = fromAddresses[0].Name
+	}
+	if replyTo := parsed.addresses("Reply-To"); len(replyTo) > 0 {
+		sendGridMessage.ReplyTo = &sendGridAddress{Email: replyTo[0].Address, Name: replyTo[0].Name}
+	}
+	// SendGrid requires the plain text content to come first.
+	if parsed.text != "" {
+		sendGridMessage.Content = append(sendGridMessage.Content, sendGridContent{Type: "text/plain", Value: parsed.text})
+	}
+	if parsed.html != "" {
+		sendGridMessage.Content = append(sendGridMessage.Content, sendGridContent{Type: "text/html", Value: parsed.html})
+	}
+	for _, file := range parsed.attachments {
+		converted := sendGridAttachment{Content: base64.StdEncoding.EncodeToString(file.content), Type: file.contentType, Filename: file.filename, Disposition: "attachment"}
+		if file.inline {
+			converted.Disposition = "inline"
+			converted.ContentID = file.contentID
+		}
+		sendGridMessage.Attachments = append(sendGridMessage.Attachments, converted)
+	}
+
+	return sendGridMessage
+}
+
+// Send converts the raw message to a SendGrid mail and sends it in a single API call.
+func (sender *sendGridSender) Send(from string, to []string, msg io.WriterTo) error {
+	parsed, err := parseMessage(msg)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(sendGridMailFromMessage(parsed, from, to))
+	if err != nil {
+		return fmt.Errorf("unable to marshal SendGrid mail: %s", err.Error())
+	}
+
+	request, err := http.NewRequest(http.MethodPost, sendGridEndpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("unable to build SendGrid request: %s", err.Error())
+	}
+	request.Header.Set("Authorization", "Bearer "+sender.apiKey)
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := sender.httpClient.Do(request)
+	if err != nil {
+		return fmt.Errorf("unable to send email through SendGrid: %s", err.Error())
+	}
+	defer response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		details, _ := ioutil.ReadAll(io.LimitReader(response.Body, 4096))
+		return fmt.Errorf("unable to send email through SendGrid: %s %s", response.Status, details)
+	}
+	sender.messageID = response.Header.Get("X-Message-Id")
+
+	return nil
+}
+
+// ProviderMessageID returns the id SendGrid gave to the last sent message.
+func (sender *sendGridSender) ProviderMessageID() string {
+	return sender.messageID
+}
+
+// Close does nothing, as there is no connection to close.
+func (sender *sendGridSender) Close() error {
+	return nil
+}
+
+// NewSendGrid instanciates a SendGrid transport authenticated with the API key.
+func NewSendGrid(apiKey string) *SendGrid {
+	return &SendGrid{apiKey: apiKey, httpClient: &http.Client{Timeout: 30 * time.Second}}
+}
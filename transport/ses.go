@@ -0,0 +1,89 @@
+package transport
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ses"
+	"gopkg.in/gomail.v2"
+	"io"
+)
+
+// sesMaxDestinations is the maximum count of recipients of a message accepted by SES.
+const sesMaxDestinations = 50
+
+// SES handles sending raw messages through the AWS SES API. It implements the Dialer interface.
+type SES struct {
+	sesClient *ses.SES
+	// ConfigurationSet is the SES configuration set the messages are sent with, ie: to publish their delivery events. None is used when empty.
+	ConfigurationSet string
+}
+
+// sesSender sends messages with the SES API client, keeping the id of the last sent message.
+type sesSender struct {
+	sesClient        *ses.SES
+	configurationSet string
+	messageID        string
+}
+
+// Dial returns a sender using the SES API client, as it does not hold any connection.
+func (sesConnector *SES) Dial() (gomail.SendCloser, error) {
+	return &sesSender{sesClient: sesConnector.sesClient, configurationSet: sesConnector.ConfigurationSet}, nil
+}
+
+// Send sends the raw message in a single API call, with every To, Cc and Bcc recipient as destination.
+// The Bcc header is never written by gomail, so blind recipients only appear in the envelope.
+func (sender *sesSender) Send(from string, to []string, msg io.WriterTo) error {
+	if len(to) > sesMaxDestinations {
+		return fmt.Errorf("message has %d recipients, SES accepts at most %d", len(to), sesMaxDestinations)
+	}
+
+	var rawMessage bytes.Buffer
+	if _, err := msg.WriteTo(&rawMessage); err != nil {
+		return fmt.Errorf("unable to write raw message: %s", err.Error())
+	}
+
+	input := &ses.SendRawEmailInput{
+		Source:       aws.String(from),
+		Destinations: aws.StringSlice(to),
+		RawMessage:   &ses.RawMessage{Data: rawMessage.Bytes()},
+	}
+	if sender.configurationSet != "" {
+		input.ConfigurationSetName = aws.String(sender.configurationSet)
+	}
+	output, err := sender.sesClient.SendRawEmail(input)
+	if err != nil {
+		return fmt.Errorf("unable to send raw email through SES: %s", err.Error())
+	}
+	sender.messageID = aws.StringValue(output.MessageId)
+
+	return nil
+}
+
+// ProviderMessageID returns the id SES gave to the last sent message.
+func (sender *sesSender) ProviderMessageID() string {
+	return sender.messageID
+}
+
+// Close does nothing, as there is no connection to close.
+func (sender *sesSender) Close() error {
+	return nil
+}
+
+// NewSES instanciates an SES with the AWS Session and AWS SES Client
+func NewSES(region string) (*SES, error) {
+	p := new(SES)
+	sess, err := session.NewSession(
+		&aws.Config{
+			Region: aws.String(region),
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to AWS: %s", err.Error())
+	}
+
+	p.sesClient = ses.New(sess)
+
+	return p, nil
+}
@@ -0,0 +1,176 @@
+package transport
+
+import (
+	"encoding/base64"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ses"
+	"gopkg.in/gomail.v2"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// sesRequest is a SendRawEmail call received by the fake SES endpoint.
+type sesRequest struct {
+	source       string
+	destinations []string
+	raw          string
+}
+
+// newSESServer returns a fake SES endpoint recording the SendRawEmail calls, and an SES transport using it.
+func newSESServer(t *testing.T) (*httptest.Server, *SES, func() []sesRequest) {
+	t.Helper()
+
+	var mu sync.Mutex
+	var requests []sesRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil || r.Form.Get("Action") != "SendRawEmail" {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		raw, _ := base64.StdEncoding.DecodeString(r.Form.Get("RawMessage.Data"))
+		request := sesRequest{source: r.Form.Get("Source"), destinations: formMembers(r.Form, "Destinations"), raw: string(raw)}
+		mu.Lock()
+		requests = append(requests, request)
+		count := len(requests)
+		mu.Unlock()
+
+		w.Header().Set("Content-Type", "text/xml")
+		fmt.Fprintf(w, `<SendRawEmailResponse xmlns="http://ses.amazonaws.com/doc/2010-12-01/"><SendRawEmailResult><MessageId>ses-%d</MessageId></SendRawEmailResult></SendRawEmailResponse>`, count)
+	}))
+
+	sess, err := session.NewSession(&aws.Config{
+		Region:      aws.String("eu-west-1"),
+		Endpoint:    aws.String(server.URL),
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+	})
+	if err != nil {
+		t.Fatalf("unable to instantiate session: %s", err.Error())
+	}
+
+	return server, &SES{sesClient: ses.New(sess)}, func() []sesRequest {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]sesRequest(nil), requests...)
+	}
+}
+
+// formMembers returns the values of a list parameter of the AWS query protocol, ie: Destinations.member.1.
+func formMembers(form url.Values, name string) []string {
+	var members []string
+	for i := 1; ; i++ {
+		value, ok := form[fmt.Sprintf("%s.member.%d", name, i)]
+		if !ok {
+			return members
+		}
+		members = append(members, value[0])
+	}
+}
+
+func TestSESSendsToEveryDestination(t *testing.T) {
+	tests := []struct {
+		name         string
+		to           []string
+		cc           []string
+		bcc          []string
+		destinations []string
+	}{
+		{name: "to only", to: []string{"jane@example.org"}, destinations: []string{"jane@example.org"}},
+		{name: "to and cc", to: []string{"jane@example.org"}, cc: []string{"john@example.org"}, destinations: []string{"jane@example.org", "john@example.org"}},
+		{
+			name:         "to, cc and bcc",
+			to:           []string{"jane@example.org", "jim@example.org"},
+			cc:           []string{"john@example.org"},
+			bcc:          []string{"audit@example.com", "archive@example.com"},
+			destinations: []string{"archive@example.com", "audit@example.com", "jane@example.org", "jim@example.org", "john@example.org"},
+		},
+		{name: "bcc only", bcc: []string{"audit@example.com"}, destinations: []string{"audit@example.com"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server, sesTransport, requests := newSESServer(t)
+			defer server.Close()
+
+			message := gomail.NewMessage()
+			message.SetHeader("From", "sender@example.com")
+			for field, addresses := range map[string][]string{"To": test.to, "Cc": test.cc, "Bcc": test.bcc} {
+				if len(addresses) > 0 {
+					message.SetHeader(field, addresses...)
+				}
+			}
+			message.SetHeader("Subject", "Welcome")
+			message.SetBody("text/plain", "Hello")
+
+			sender, _ := sesTransport.Dial()
+			if err := gomail.Send(sender, message); err != nil {
+				t.Fatalf("unable to send: %s", err.Error())
+			}
+			if id := sender.(MessageIDReporter).ProviderMessageID(); id != "ses-1" {
+				t.Errorf("expected provider id ses-1, got %q", id)
+			}
+
+			sent := requests()
+			if len(sent) != 1 {
+				t.Fatalf("expected a single API call, got %d", len(sent))
+			}
+			destinations := append([]string(nil), sent[0].destinations...)
+			sort.Strings(destinations)
+			if strings.Join(destinations, ",") != strings.Join(test.destinations, ",") {
+				t.Errorf("expected destinations %v, got %v", test.destinations, destinations)
+			}
+			if sent[0].source != "sender@example.com" {
+				t.Errorf("expected source sender@example.com, got %s", sent[0].source)
+			}
+			headers := strings.SplitN(sent[0].raw, "\r\n\r\n", 2)[0]
+			if strings.Contains(strings.ToLower(headers), "bcc:") {
+				t.Errorf("expected no Bcc header, got %q", headers)
+			}
+			for _, address := range test.bcc {
+				if strings.Contains(sent[0].raw, address) {
+					t.Errorf("expected %s to stay out of the raw message, got %q", address, sent[0].raw)
+				}
+			}
+		})
+	}
+}
+
+func TestSESRejectsTooManyDestinations(t *testing.T) {
+	server, sesTransport, requests := newSESServer(t)
+	defer server.Close()
+
+	tests := []struct {
+		recipients int
+		err        bool
+	}{
+		{recipients: sesMaxDestinations},
+		{recipients: sesMaxDestinations + 1, err: true},
+	}
+
+	for _, test := range tests {
+		to := make([]string, test.recipients)
+		for i := range to {
+			to[i] = fmt.Sprintf("user%d@example.org", i)
+		}
+		message := gomail.NewMessage()
+		message.SetHeader("From", "sender@example.com")
+		message.SetHeader("Bcc", to...)
+		message.SetBody("text/plain", "Hello")
+
+		sender, _ := sesTransport.Dial()
+		err := gomail.Send(sender, message)
+		if (err != nil) != test.err {
+			t.Errorf("expected %d recipients to fail: %t, got %v", test.recipients, test.err, err)
+		}
+	}
+	if sent := requests(); len(sent) != 1 {
+		t.Errorf("expected a single API call, got %d", len(sent))
+	}
+}
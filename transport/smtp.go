@@ -0,0 +1,120 @@
+package transport
+
+import (
+	"context"
+	"fmt"
+	"gopkg.in/gomail.v2"
+	"log"
+	"net/textproto"
+	"time"
+)
+
+// SMTP handles sending messages through an SMTP relay. It implements the Dialer interface.
+type SMTP struct {
+	*gomail.Dialer
+	// GreetingRetries is the number of additional dial attempts when the server replies with a transient 4xx code while dialing, ie: a 421 "too busy" greeting.
+	GreetingRetries int
+	// GreetingBackoff is the delay before the first greeting retry, doubled after each attempt.
+	GreetingBackoff time.Duration
+	// Pipelining sends the envelope commands without waiting for each reply, when the server advertises PIPELINING.
+	Pipelining bool
+	// Chunking sends the content with BDAT instead of DATA, when the server advertises CHUNKING.
+	Chunking bool
+	// RequireStartTLS fails the dials to servers which don't support STARTTLS, instead of sending the messages in plain text.
+	RequireStartTLS bool
+	// Deadlines opens the connections with the net/smtp client, whose senders are able to bound their reads and writes by a deadline.
+	Deadlines bool
+	// Credentials gives the username and password used by each dial instead of the dialer ones, when not nil.
+	Credentials CredentialsProvider
+	// Tokens gives the OAuth2 access token each dial authenticates with using XOAUTH2 instead of the password, when not nil.
+	Tokens TokenProvider
+}
+
+// isBusyGreeting tells if the error is a transient 4xx reply, ie: the 421 relays greet with when they are temporarily unable to accept connections,
+// or a 454 temporary authentication failure. Permanent 5xx replies, such as the 535 of rejected credentials, are not retried.
+func isBusyGreeting(err error) bool {
+	protoErr, ok := err.(*textproto.Error)
+
+	return ok && protoErr.Code >= 400 && protoErr.Code < 500
+}
+
+// Dial opens the SMTP connection, retrying with an exponential backoff while the server replies with a transient 4xx code.
+func (smtpTransport *SMTP) Dial() (gomail.SendCloser, error) {
+	return smtpTransport.DialContext(context.Background())
+}
+
+// DialContext opens the SMTP connection as Dial does, the retries being skipped when their backoff would exceed the deadline of the context,
+// and their wait being interrupted when it is done. The last dial error is then returned.
+func (smtpTransport *SMTP) DialContext(ctx context.Context) (gomail.SendCloser, error) {
+	if smtpTransport.Credentials != nil {
+		username, password, err := smtpTransport.Credentials.Credentials()
+		if err != nil {
+			return nil, fmt.Errorf("unable to get SMTP credentials: %s", err.Error())
+		}
+		// The dialer is copied, as concurrent dials may use other credentials after a refresh.
+		dialer := *smtpTransport.Dialer
+		dialer.Username, dialer.Password = username, password
+		withCredentials := *smtpTransport
+		withCredentials.Dialer = &dialer
+		withCredentials.Credentials = nil
+		return withCredentials.DialContext(ctx)
+	}
+
+	if smtpTransport.Tokens != nil {
+		token, err := smtpTransport.Tokens.Token()
+		if err != nil {
+			return nil, fmt.Errorf("unable to get SMTP access token: %s", err.Error())
+		}
+		dialer := *smtpTransport.Dialer
+		dialer.Auth = &xoauth2Auth{username: dialer.Username, token: token}
+		withToken := *smtpTransport
+		withToken.Dialer = &dialer
+		withToken.Tokens = nil
+		return withToken.DialContext(ctx)
+	}
+
+	dial := smtpTransport.Dialer.Dial
+	// The gomail dialer only upgrades the connection when the server supports STARTTLS.
+	if smtpTransport.Pipelining || smtpTransport.Chunking || smtpTransport.Deadlines || smtpTransport.RequireStartTLS {
+		dial = smtpTransport.dialRaw
+	}
+
+	backoff := smtpTransport.GreetingBackoff
+	for attempt := 0; ; attempt++ {
+		sender, err := dial()
+		if err == nil || attempt >= smtpTransport.GreetingRetries || !isBusyGreeting(err) {
+			return sender, err
+		}
+
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(backoff).After(deadline) {
+			return sender, err
+		}
+
+		log.Printf("SMTP server %s is busy (%s), retrying in %s", smtpTransport.Host, err.Error(), backoff)
+		if !wait(ctx, backoff) {
+			return sender, err
+		}
+		backoff *= 2
+	}
+}
+
+// wait waits for the delay, telling if it elapsed before the context was done.
+func wait(ctx context.Context, delay time.Duration) bool {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// NewSMTP instanciates an SMTP transport for the given relay, using the TLS mode of the port.
+func NewSMTP(host string, port Port, username string, password string) *SMTP {
+	dialer := gomail.NewDialer(host, port.Number, username, password)
+	dialer.SSL = port.TLS == TLSImplicit
+
+	return &SMTP{Dialer: dialer, RequireStartTLS: port.TLS == TLSStartTLSRequired}
+}
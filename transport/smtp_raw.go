@@ -0,0 +1,271 @@
+package transport
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"gopkg.in/gomail.v2"
+	"io"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// loginAuth implements the LOGIN authentication mechanism, which some relays offer without PLAIN.
+type loginAuth struct {
+	username string
+	password string
+	host     string
+}
+
+// Start checks the password is sent over TLS, or to localhost, and to the expected host, as smtp.PlainAuth does.
+func (auth *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if !server.TLS && !isLocalhost(server.Name) {
+		return "", nil, fmt.Errorf("LOGIN requires a TLS connection")
+	}
+	if server.Name != auth.host {
+		return "", nil, fmt.Errorf("wrong host name %q, expecting %q", server.Name, auth.host)
+	}
+
+	return "LOGIN", nil, nil
+}
+
+// isLocalhost tells if the server name is the local host, to which credentials can be sent without TLS.
+func isLocalhost(name string) bool {
+	return name == "localhost" || name == "127.0.0.1" || name == "::1"
+}
+
+func (auth *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+	switch string(fromServer) {
+	case "Username:":
+		return []byte(auth.username), nil
+	case "Password:":
+		return []byte(auth.password), nil
+	default:
+		return nil, fmt.Errorf("unexpected LOGIN challenge %q", fromServer)
+	}
+}
+
+// rawSender sends messages with the net/smtp client, using the command pipelining and chunking extensions when the server advertises them.
+type rawSender struct {
+	conn       net.Conn
+	client     *smtp.Client
+	pipelining bool
+	chunking   bool
+	eightBit   bool
+	smtpUTF8   bool
+}
+
+// dialRaw opens the SMTP connection like the gomail dialer, but returns a sender able to use the PIPELINING and CHUNKING extensions.
+func (smtpTransport *SMTP) dialRaw() (gomail.SendCloser, error) {
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(smtpTransport.Host, strconv.Itoa(smtpTransport.Port)), 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := smtpTransport.TLSConfig
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{ServerName: smtpTransport.Host}
+	}
+	if smtpTransport.SSL {
+		conn = tls.Client(conn, tlsConfig)
+	}
+
+	client, err := smtp.NewClient(conn, smtpTransport.Host)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if smtpTransport.LocalName != "" {
+		if err := client.Hello(smtpTransport.LocalName); err != nil {
+			client.Close()
+			return nil, err
+		}
+	}
+	if !smtpTransport.SSL {
+		if ok, _ := client.Extension("STARTTLS"); ok {
+			if err := client.StartTLS(tlsConfig); err != nil {
+				client.Close()
+				return nil, err
+			}
+		} else if smtpTransport.RequireStartTLS {
+			// A server not advertising STARTTLS may be an attacker stripping it, so nothing is sent in plain text.
+			client.Close()
+			return nil, fmt.Errorf("SMTP server %s doesn't support STARTTLS, which is required", smtpTransport.Host)
+		}
+	}
+
+	if smtpTransport.Auth != nil || smtpTransport.Username != "" {
+		if ok, mechanisms := client.Extension("AUTH"); ok {
+			// The mechanism is chosen from those of the server, unless it is given, ie: XOAUTH2.
+			auth := smtpTransport.Auth
+			if auth == nil {
+				if strings.Contains(mechanisms, "CRAM-MD5") {
+					auth = smtp.CRAMMD5Auth(smtpTransport.Username, smtpTransport.Password)
+				} else if strings.Contains(mechanisms, "LOGIN") && !strings.Contains(mechanisms, "PLAIN") {
+					auth = &loginAuth{username: smtpTransport.Username, password: smtpTransport.Password, host: smtpTransport.Host}
+				} else {
+					auth = smtp.PlainAuth("", smtpTransport.Username, smtpTransport.Password, smtpTransport.Host)
+				}
+			}
+			if err := client.Auth(auth); err != nil {
+				client.Close()
+				return nil, err
+			}
+		}
+	}
+
+	sender := &rawSender{conn: conn, client: client}
+	sender.pipelining, _ = client.Extension("PIPELINING")
+	sender.chunking, _ = client.Extension("CHUNKING")
+	sender.eightBit, _ = client.Extension("8BITMIME")
+	sender.smtpUTF8, _ = client.Extension("SMTPUTF8")
+	sender.pipelining = sender.pipelining && smtpTransport.Pipelining
+	sender.chunking = sender.chunking && smtpTransport.Chunking
+
+	return sender, nil
+}
+
+// isInternationalized tells if any of the addresses has non-ASCII characters, which requires the SMTPUTF8 extension.
+func isInternationalized(addresses []string) bool {
+	for _, address := range addresses {
+		for i := 0; i < len(address); i++ {
+			if address[i] >= utf8.RuneSelf {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// envelope sends the MAIL and RCPT commands, all at once before reading their replies when pipelining is available.
+// Internationalized addresses are sent with the SMTPUTF8 parameter, and are rejected without being sent when the server doesn't support it.
+func (sender *rawSender) envelope(from string, to []string) error {
+	if !sender.smtpUTF8 && isInternationalized(append([]string{from}, to...)) {
+		return &textproto.Error{Code: 553, Msg: "5.6.7 The SMTP server does not support SMTPUTF8, required by the internationalized addresses"}
+	}
+	if !sender.pipelining {
+		if err := sender.client.Mail(from); err != nil {
+			return err
+		}
+		for _, address := range to {
+			if err := sender.client.Rcpt(address); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for _, address := range append([]string{from}, to...) {
+		if strings.ContainsAny(address, "\r\n") {
+			return fmt.Errorf("smtp: address %q contains a line break", address)
+		}
+	}
+
+	mailCommand := "MAIL FROM:<%s>"
+	if sender.eightBit {
+		mailCommand += " BODY=8BITMIME"
+	}
+	if sender.smtpUTF8 {
+		mailCommand += " SMTPUTF8"
+	}
+	if err := sender.client.Text.PrintfLine(mailCommand, from); err != nil {
+		return err
+	}
+	for _, address := range to {
+		if err := sender.client.Text.PrintfLine("RCPT TO:<%s>", address); err != nil {
+			return err
+		}
+	}
+
+	// Every reply is read, even after a failure, so the connection stays in sync.
+	_, message, replyErr := sender.client.Text.ReadResponse(250)
+	replyErr = rejection(replyErr, "MAIL FROM rejected: "+message)
+	for _, address := range to {
+		if _, message, err := sender.client.Text.ReadResponse(25); err != nil && replyErr == nil {
+			replyErr = rejection(err, fmt.Sprintf("RCPT TO:<%s> rejected: %s", address, message))
+		}
+	}
+
+	return replyErr
+}
+
+// rejection describes a failed reply with the message, keeping the code of the reply so it can be told transient or permanent.
+func rejection(err error, message string) error {
+	if protoErr, ok := err.(*textproto.Error); ok {
+		return &textproto.Error{Code: protoErr.Code, Msg: message}
+	}
+
+	return err
+}
+
+// data sends the message content, in a single BDAT chunk when chunking is available instead of the dot-stuffed DATA command.
+func (sender *rawSender) data(msg io.WriterTo) error {
+	if !sender.chunking {
+		writer, err := sender.client.Data()
+		if err != nil {
+			return err
+		}
+		if _, err := msg.WriteTo(writer); err != nil {
+			writer.Close()
+			return err
+		}
+		return writer.Close()
+	}
+
+	var content bytes.Buffer
+	if _, err := msg.WriteTo(&content); err != nil {
+		return err
+	}
+	// The DATA command ends the content with a line break, so the chunked content does too.
+	if !bytes.HasSuffix(content.Bytes(), []byte("\r\n")) {
+		content.WriteString("\r\n")
+	}
+	if _, err := fmt.Fprintf(sender.client.Text.W, "BDAT %d LAST\r\n", content.Len()); err != nil {
+		return err
+	}
+	if _, err := content.WriteTo(sender.client.Text.W); err != nil {
+		return err
+	}
+	if err := sender.client.Text.W.Flush(); err != nil {
+		return err
+	}
+	_, _, err := sender.client.Text.ReadResponse(250)
+
+	return err
+}
+
+// Send delivers the message to the recipients, resetting the transaction when it fails.
+func (sender *rawSender) Send(from string, to []string, msg io.WriterTo) error {
+	if err := sender.envelope(from, to); err != nil {
+		_ = sender.client.Reset()
+		return err
+	}
+	if err := sender.data(msg); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Check sends a NOOP command, failing when the server dropped the connection.
+func (sender *rawSender) Check() error {
+	return sender.client.Noop()
+}
+
+// SetDeadline bounds the reads and writes of the connection.
+func (sender *rawSender) SetDeadline(deadline time.Time) error {
+	return sender.conn.SetDeadline(deadline)
+}
+
+// Close ends the SMTP session.
+func (sender *rawSender) Close() error {
+	return sender.client.Quit()
+}
@@ -0,0 +1,679 @@
+package transport
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"fmt"
+	"gopkg.in/gomail.v2"
+	"math/big"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// smtpEnvelope is a message received by the fake SMTP server.
+type smtpEnvelope struct {
+	from       string
+	recipients []string
+	data       string
+}
+
+// smtpServer is a fake SMTP relay, greeting each connection with the next of its greetings, then with a 220.
+type smtpServer struct {
+	listener   net.Listener
+	greetings  []string
+	extensions []string
+	authReply  string
+	rejected   string
+	// tlsConfig enables the STARTTLS extension when set.
+	tlsConfig *tls.Config
+	// login offers the LOGIN authentication mechanism only.
+	login bool
+
+	mu          sync.Mutex
+	connections int
+	commands    []string
+	envelopes   []smtpEnvelope
+	pipelined   bool
+	secured     bool
+	logins      []string
+}
+
+func newSMTPServer(t *testing.T, greetings ...string) *smtpServer {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to listen: %s", err.Error())
+	}
+	server := &smtpServer{listener: listener, greetings: greetings}
+	go server.serve()
+
+	return server
+}
+
+// transport returns an SMTP transport connecting to the server without TLS.
+func (server *smtpServer) transport() *SMTP {
+	addr := server.listener.Addr().(*net.TCPAddr)
+
+	return NewSMTP("127.0.0.1", Port{Number: addr.Port, TLS: TLSStartTLS}, "", "")
+}
+
+func (server *smtpServer) Close() {
+	server.listener.Close()
+}
+
+func (server *smtpServer) Connections() int {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	return server.connections
+}
+
+func (server *smtpServer) Envelopes() []smtpEnvelope {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	return append([]smtpEnvelope(nil), server.envelopes...)
+}
+
+func (server *smtpServer) Commands() []string {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	return append([]string(nil), server.commands...)
+}
+
+// Pipelined tells if a RCPT command was received before the reply to its MAIL command.
+func (server *smtpServer) Pipelined() bool {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	return server.pipelined
+}
+
+// Secured tells if a connection was upgraded with STARTTLS.
+func (server *smtpServer) Secured() bool {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	return server.secured
+}
+
+// Logins returns the username and password received by the LOGIN authentications.
+func (server *smtpServer) Logins() []string {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	return append([]string(nil), server.logins...)
+}
+
+// newTestCertificate returns a self-signed certificate of 127.0.0.1, along with a pool trusting it.
+func newTestCertificate(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("unable to generate key: %s", err.Error())
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("unable to create certificate: %s", err.Error())
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("unable to parse certificate: %s", err.Error())
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(certificate)
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, pool
+}
+
+func (server *smtpServer) serve() {
+	for {
+		conn, err := server.listener.Accept()
+		if err != nil {
+			return
+		}
+		server.mu.Lock()
+		greeting := "220 localhost ESMTP ready"
+		if server.connections < len(server.greetings) {
+			greeting = server.greetings[server.connections]
+		}
+		server.connections++
+		server.mu.Unlock()
+		go server.handle(conn, greeting)
+	}
+}
+
+func (server *smtpServer) handle(conn net.Conn, greeting string) {
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	text := textproto.NewConn(conn)
+	_ = text.PrintfLine("%s", greeting)
+	if !strings.HasPrefix(greeting, "220") {
+		return
+	}
+
+	var envelope smtpEnvelope
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+		server.mu.Lock()
+		server.commands = append(server.commands, line)
+		server.mu.Unlock()
+
+		verb := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch verb {
+		case "EHLO", "HELO":
+			lines := append([]string{"localhost"}, server.extensions...)
+			if server.authReply != "" {
+				lines = append(lines, "AUTH PLAIN")
+			}
+			if server.login {
+				lines = append(lines, "AUTH LOGIN")
+			}
+			if _, secured := conn.(*tls.Conn); server.tlsConfig != nil && !secured {
+				lines = append(lines, "STARTTLS")
+			}
+			for i, extension := range lines {
+				separator := "-"
+				if i == len(lines)-1 {
+					separator = " "
+				}
+				_ = text.PrintfLine("250%s%s", separator, extension)
+			}
+		case "STARTTLS":
+			_ = text.PrintfLine("220 2.0.0 Ready to start TLS")
+			secured := tls.Server(conn, server.tlsConfig)
+			if err := secured.Handshake(); err != nil {
+				return
+			}
+			conn, text = secured, textproto.NewConn(secured)
+			server.mu.Lock()
+			server.secured = true
+			server.mu.Unlock()
+		case "AUTH":
+			if !server.login {
+				_ = text.PrintfLine("%s", server.authReply)
+				continue
+			}
+			var credentials []string
+			for _, challenge := range []string{"Username:", "Password:"} {
+				_ = text.PrintfLine("334 %s", base64.StdEncoding.EncodeToString([]byte(challenge)))
+				line, err := text.ReadLine()
+				if err != nil {
+					return
+				}
+				decoded, _ := base64.StdEncoding.DecodeString(line)
+				credentials = append(credentials, string(decoded))
+			}
+			server.mu.Lock()
+			server.logins = append(server.logins, strings.Join(credentials, ":"))
+			server.mu.Unlock()
+			_ = text.PrintfLine("235 2.7.0 Authentication successful")
+		case "MAIL":
+			envelope = smtpEnvelope{from: addressOf(line)}
+			// A pipelining client sends the next command without waiting for this reply.
+			time.Sleep(20 * time.Millisecond)
+			_ = conn.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+			if _, err := text.R.Peek(1); err == nil {
+				server.mu.Lock()
+				server.pipelined = true
+				server.mu.Unlock()
+			}
+			_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+			_ = text.PrintfLine("250 2.1.0 OK")
+		case "RCPT":
+			if server.rejected != "" && addressOf(line) == server.rejected {
+				_ = text.PrintfLine("550 5.1.1 No such user")
+				continue
+			}
+			envelope.recipients = append(envelope.recipients, addressOf(line))
+			_ = text.PrintfLine("250 2.1.5 OK")
+		case "DATA":
+			_ = text.PrintfLine("354 Go ahead")
+			data, err := text.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			envelope.data = string(data)
+			server.mu.Lock()
+			server.envelopes = append(server.envelopes, envelope)
+			server.mu.Unlock()
+			_ = text.PrintfLine("250 2.0.0 OK queued")
+		case "BDAT":
+			var size int
+			last := strings.HasSuffix(strings.ToUpper(line), " LAST")
+			_, _ = fmt.Sscanf(line[5:], "%d", &size)
+			chunk := make([]byte, size)
+			if _, err := readFull(text.R, chunk); err != nil {
+				return
+			}
+			envelope.data += string(chunk)
+			if last {
+				server.mu.Lock()
+				server.envelopes = append(server.envelopes, envelope)
+				server.mu.Unlock()
+			}
+			_ = text.PrintfLine("250 2.0.0 OK")
+		case "RSET", "NOOP":
+			_ = text.PrintfLine("250 2.0.0 OK")
+		case "QUIT":
+			_ = text.PrintfLine("221 2.0.0 Bye")
+			return
+		default:
+			_ = text.PrintfLine("502 5.5.2 Command not recognized")
+		}
+	}
+}
+
+func addressOf(command string) string {
+	start, end := strings.Index(command, "<"), strings.Index(command, ">")
+	if start < 0 || end < start {
+		return ""
+	}
+
+	return command[start+1 : end]
+}
+
+func readFull(reader *bufio.Reader, buffer []byte) (int, error) {
+	read := 0
+	for read < len(buffer) {
+		n, err := reader.Read(buffer[read:])
+		read += n
+		if err != nil {
+			return read, err
+		}
+	}
+
+	return read, nil
+}
+
+func testMessage() *gomail.Message {
+	message := gomail.NewMessage()
+	message.SetHeader("From", "sender@example.com")
+	message.SetHeader("To", "jane@example.org")
+	message.SetHeader("Subject", "Welcome")
+	message.SetBody("text/plain", "Hello Jane")
+
+	return message
+}
+
+func TestSMTPDialRetriesBusyGreeting(t *testing.T) {
+	tests := []struct {
+		name        string
+		greetings   []string
+		retries     int
+		rawClient   bool
+		connections int
+		code        int
+	}{
+		{name: "accepts at once", retries: 2, connections: 1},
+		{name: "accepts after a 421", greetings: []string{"421 4.3.2 too busy"}, retries: 2, connections: 2},
+		{name: "accepts after two 421", greetings: []string{"421 4.3.2 too busy", "421 4.3.2 too busy"}, retries: 2, connections: 3},
+		{name: "accepts after a 421 with the raw client", greetings: []string{"421 4.3.2 too busy"}, retries: 1, rawClient: true, connections: 2},
+		{name: "gives up after its retries", greetings: []string{"421 4.3.2 too busy", "421 4.3.2 too busy", "421 4.3.2 too busy"}, retries: 2, connections: 3, code: 421},
+		{name: "does not retry without retries", greetings: []string{"421 4.3.2 too busy"}, connections: 1, code: 421},
+		{name: "accepts after another 4xx greeting", greetings: []string{"450 4.7.1 try again later"}, retries: 1, connections: 2},
+		{name: "does not retry a permanent rejection", greetings: []string{"554 5.7.1 no service"}, retries: 2, connections: 1, code: 554},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newSMTPServer(t, test.greetings...)
+			defer server.Close()
+			smtpTransport := server.transport()
+			smtpTransport.GreetingRetries = test.retries
+			smtpTransport.GreetingBackoff = time.Millisecond
+			smtpTransport.Deadlines = test.rawClient
+
+			sender, err := smtpTransport.Dial()
+			if test.code != 0 {
+				protoErr, ok := err.(*textproto.Error)
+				if !ok || protoErr.Code != test.code {
+					t.Fatalf("expected a %d reply, got %v", test.code, err)
+				}
+			} else {
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err.Error())
+				}
+				if err := gomail.Send(sender, testMessage()); err != nil {
+					t.Fatalf("unable to send: %s", err.Error())
+				}
+				sender.Close()
+				if envelopes := server.Envelopes(); len(envelopes) != 1 || envelopes[0].recipients[0] != "jane@example.org" {
+					t.Errorf("expected a message to jane@example.org, got %+v", envelopes)
+				}
+			}
+			if connections := server.Connections(); connections != test.connections {
+				t.Errorf("expected %d connections, got %d", test.connections, connections)
+			}
+		})
+	}
+}
+
+func TestSMTPDialDoesNotRetryAuthFailure(t *testing.T) {
+	server := newSMTPServer(t)
+	defer server.Close()
+	server.authReply = "535 5.7.8 authentication failed"
+	smtpTransport := server.transport()
+	smtpTransport.Username, smtpTransport.Password = "hermes", "wrong"
+	smtpTransport.GreetingRetries = 3
+	smtpTransport.GreetingBackoff = time.Millisecond
+
+	_, err := smtpTransport.Dial()
+	if err == nil || !strings.Contains(err.Error(), "535") {
+		t.Fatalf("expected the authentication failure, got %v", err)
+	}
+	if connections := server.Connections(); connections != 1 {
+		t.Errorf("expected an authentication failure not to be retried, got %d connections", connections)
+	}
+}
+
+func TestSMTPDialContextBoundsRetries(t *testing.T) {
+	tests := []struct {
+		name   string
+		ctx    func() (context.Context, context.CancelFunc)
+		cancel time.Duration
+	}{
+		{name: "skips a retry whose backoff exceeds the deadline", ctx: func() (context.Context, context.CancelFunc) {
+			return context.WithTimeout(context.Background(), time.Minute)
+		}},
+		{name: "stops waiting once the context is done", ctx: func() (context.Context, context.CancelFunc) {
+			return context.WithCancel(context.Background())
+		}, cancel: 50 * time.Millisecond},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newSMTPServer(t, "421 4.3.2 too busy", "421 4.3.2 too busy")
+			defer server.Close()
+			smtpTransport := server.transport()
+			smtpTransport.GreetingRetries = 2
+			smtpTransport.GreetingBackoff = time.Hour
+
+			ctx, cancel := test.ctx()
+			defer cancel()
+			if test.cancel > 0 {
+				time.AfterFunc(test.cancel, cancel)
+			}
+			start := time.Now()
+			_, err := smtpTransport.DialContext(ctx)
+			if protoErr, ok := err.(*textproto.Error); !ok || protoErr.Code != 421 {
+				t.Fatalf("expected the busy greeting, got %v", err)
+			}
+			if elapsed := time.Since(start); elapsed > 5*time.Second {
+				t.Errorf("expected the dial to give up at once, took %s", elapsed)
+			}
+			if connections := server.Connections(); connections != 1 {
+				t.Errorf("expected no retry, got %d connections", connections)
+			}
+		})
+	}
+}
+
+func TestDialUsesContextDialers(t *testing.T) {
+	server := newSMTPServer(t, "421 4.3.2 too busy")
+	defer server.Close()
+	smtpTransport := server.transport()
+	smtpTransport.GreetingRetries = 1
+	smtpTransport.GreetingBackoff = time.Hour
+
+	// The deadline reaches the SMTP transport through the retries and the pool.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	_, err := Dial(ctx, NewPool(NewRetry(smtpTransport)))
+	if protoErr, ok := err.(*textproto.Error); !ok || protoErr.Code != 421 {
+		t.Fatalf("expected the busy greeting, got %v", err)
+	}
+	if connections := server.Connections(); connections != 1 {
+		t.Errorf("expected no retry, got %d connections", connections)
+	}
+}
+
+func TestIsBusyGreeting(t *testing.T) {
+	tests := []struct {
+		err      error
+		expected bool
+	}{
+		{err: &textproto.Error{Code: 421, Msg: "too busy"}, expected: true},
+		{err: &textproto.Error{Code: 450, Msg: "mailbox busy"}, expected: true},
+		{err: &textproto.Error{Code: 454, Msg: "temporary authentication failure"}, expected: true},
+		{err: &textproto.Error{Code: 399, Msg: "unexpected"}},
+		{err: &textproto.Error{Code: 500, Msg: "syntax error"}},
+		{err: &textproto.Error{Code: 535, Msg: "authentication failed"}},
+		{err: fmt.Errorf("421 too busy")},
+		{err: nil},
+	}
+
+	for _, test := range tests {
+		if busy := isBusyGreeting(test.err); busy != test.expected {
+			t.Errorf("expected %v to be busy: %t, got %t", test.err, test.expected, busy)
+		}
+	}
+}
+
+func TestSMTPPipeliningAndChunking(t *testing.T) {
+	tests := []struct {
+		name       string
+		extensions []string
+		enabled    bool
+		pipelined  bool
+		command    string
+	}{
+		{name: "uses the advertised capabilities", extensions: []string{"PIPELINING", "CHUNKING", "8BITMIME"}, enabled: true, pipelined: true, command: "BDAT"},
+		{name: "falls back when not advertised", enabled: true, command: "DATA"},
+		{name: "pipelines without chunking", extensions: []string{"PIPELINING"}, enabled: true, pipelined: true, command: "DATA"},
+		{name: "chunks without pipelining", extensions: []string{"CHUNKING"}, enabled: true, command: "BDAT"},
+		{name: "ignores the capabilities when disabled", extensions: []string{"PIPELINING", "CHUNKING"}, command: "DATA"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newSMTPServer(t)
+			defer server.Close()
+			server.extensions = test.extensions
+			smtpTransport := server.transport()
+			smtpTransport.Pipelining = test.enabled
+			smtpTransport.Chunking = test.enabled
+			smtpTransport.Deadlines = true
+
+			sender, err := smtpTransport.Dial()
+			if err != nil {
+				t.Fatalf("unable to dial: %s", err.Error())
+			}
+			message := testMessage()
+			message.SetHeader("To", "jane@example.org", "john@example.org")
+			if err := gomail.Send(sender, message); err != nil {
+				t.Fatalf("unable to send: %s", err.Error())
+			}
+			sender.Close()
+
+			envelopes := server.Envelopes()
+			if len(envelopes) != 1 || envelopes[0].from != "sender@example.com" || strings.Join(envelopes[0].recipients, ",") != "jane@example.org,john@example.org" {
+				t.Fatalf("expected a message from sender@example.com to jane and john, got %+v", envelopes)
+			}
+			// The fake server reads the DATA content with its line breaks turned into LF.
+			if data := strings.Replace(envelopes[0].data, "\r\n", "\n", -1); !strings.HasSuffix(data, "\n\nHello Jane\n") {
+				t.Errorf("expected the whole content, got %q", envelopes[0].data)
+			}
+			if pipelined := server.Pipelined(); pipelined != test.pipelined {
+				t.Errorf("expected the envelope to be pipelined: %t, got %t", test.pipelined, pipelined)
+			}
+			var command string
+			for _, received := range server.Commands() {
+				if strings.HasPrefix(received, "DATA") || strings.HasPrefix(received, "BDAT") {
+					command = strings.SplitN(received, " ", 2)[0]
+				}
+			}
+			if command != test.command {
+				t.Errorf("expected the content to be sent with %s, got %s", test.command, command)
+			}
+		})
+	}
+}
+
+func TestSMTPPipeliningRejectedRecipient(t *testing.T) {
+	server := newSMTPServer(t)
+	defer server.Close()
+	server.extensions = []string{"PIPELINING", "CHUNKING"}
+	server.rejected = "ghost@example.org"
+	smtpTransport := server.transport()
+	smtpTransport.Pipelining = true
+	smtpTransport.Chunking = true
+
+	sender, err := smtpTransport.Dial()
+	if err != nil {
+		t.Fatalf("unable to dial: %s", err.Error())
+	}
+	defer sender.Close()
+
+	err = sender.Send("sender@example.com", []string{"ghost@example.org", "jane@example.org"}, testMessage())
+	if protoErr, ok := err.(*textproto.Error); !ok || protoErr.Code != 550 || !strings.Contains(protoErr.Msg, "ghost@example.org") {
+		t.Fatalf("expected the 550 rejection of ghost@example.org, got %v", err)
+	}
+
+	// The replies of the rejected envelope were all read, so the connection can send the next message.
+	if err := gomail.Send(sender, testMessage()); err != nil {
+		t.Fatalf("expected the connection to stay in sync, got %s", err.Error())
+	}
+	if envelopes := server.Envelopes(); len(envelopes) != 1 || strings.Join(envelopes[0].recipients, ",") != "jane@example.org" {
+		t.Errorf("expected only the second message to be delivered, got %+v", envelopes)
+	}
+}
+
+func TestSMTPStartTLS(t *testing.T) {
+	certificate, pool := newTestCertificate(t)
+	tests := []struct {
+		name     string
+		offered  bool
+		required bool
+		secured  bool
+		err      string
+	}{
+		{name: "upgrades the connection when offered", offered: true, secured: true},
+		{name: "upgrades the connection when required", offered: true, required: true, secured: true},
+		{name: "sends in plain text when not offered", secured: false},
+		{name: "fails when required and not offered", required: true, err: "SMTP server 127.0.0.1 doesn't support STARTTLS, which is required"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newSMTPServer(t)
+			defer server.Close()
+			if test.offered {
+				server.tlsConfig = &tls.Config{Certificates: []tls.Certificate{certificate}}
+			}
+			smtpTransport := server.transport()
+			smtpTransport.TLSConfig = &tls.Config{ServerName: "127.0.0.1", RootCAs: pool}
+			smtpTransport.RequireStartTLS = test.required
+
+			sender, err := smtpTransport.Dial()
+			if test.err != "" {
+				if err == nil || err.Error() != test.err {
+					t.Fatalf("expected error %q, got %v", test.err, err)
+				}
+				for _, command := range server.Commands() {
+					if strings.HasPrefix(command, "MAIL") {
+						t.Errorf("expected nothing to be sent in plain text, got %q", command)
+					}
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unable to dial: %s", err.Error())
+			}
+			if err := gomail.Send(sender, testMessage()); err != nil {
+				t.Fatalf("unable to send: %s", err.Error())
+			}
+			sender.Close()
+			if secured := server.Secured(); secured != test.secured {
+				t.Errorf("expected the connection to be secured: %t, got %t", test.secured, secured)
+			}
+			if envelopes := server.Envelopes(); len(envelopes) != 1 {
+				t.Errorf("expected a message, got %+v", envelopes)
+			}
+		})
+	}
+}
+
+func TestSMTPLoginAuth(t *testing.T) {
+	certificate, pool := newTestCertificate(t)
+	server := newSMTPServer(t)
+	defer server.Close()
+	server.login = true
+	server.tlsConfig = &tls.Config{Certificates: []tls.Certificate{certificate}}
+	smtpTransport := server.transport()
+	smtpTransport.Username, smtpTransport.Password = "hermes", "secret"
+	smtpTransport.TLSConfig = &tls.Config{ServerName: "127.0.0.1", RootCAs: pool}
+	smtpTransport.Deadlines = true
+
+	sender, err := smtpTransport.Dial()
+	if err != nil {
+		t.Fatalf("unable to dial: %s", err.Error())
+	}
+	sender.Close()
+	if !server.Secured() {
+		t.Errorf("expected the connection to be secured before authenticating")
+	}
+	if logins := server.Logins(); len(logins) != 1 || logins[0] != "hermes:secret" {
+		t.Errorf("expected a LOGIN authentication as hermes, got %v", logins)
+	}
+}
+
+func TestLoginAuthStart(t *testing.T) {
+	tests := []struct {
+		name   string
+		server smtp.ServerInfo
+		err    string
+	}{
+		{name: "accepts a TLS connection", server: smtp.ServerInfo{Name: "smtp.example.com", TLS: true}},
+		{name: "accepts a plain text connection to localhost", server: smtp.ServerInfo{Name: "localhost"}},
+		{name: "rejects a plain text connection", server: smtp.ServerInfo{Name: "smtp.example.com"}, err: "LOGIN requires a TLS connection"},
+		{name: "rejects another host", server: smtp.ServerInfo{Name: "smtp.example.org", TLS: true}, err: `wrong host name "smtp.example.org", expecting "smtp.example.com"`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			host := "smtp.example.com"
+			if test.server.Name == "localhost" {
+				host = "localhost"
+			}
+			auth := &loginAuth{username: "hermes", password: "secret", host: host}
+			mechanism, response, err := auth.Start(&test.server)
+			if test.err != "" {
+				if err == nil || err.Error() != test.err {
+					t.Errorf("expected error %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil || mechanism != "LOGIN" || response != nil {
+				t.Errorf("expected the LOGIN mechanism, got %q, %q, %v", mechanism, response, err)
+			}
+		})
+	}
+}
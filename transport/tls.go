@@ -0,0 +1,69 @@
+package transport
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// tlsVersions are the accepted minimum TLS versions.
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// ParseTLSMode reads a TLS mode, either "starttls", "starttls-required" or "implicit".
+func ParseTLSMode(value string) (TLSMode, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "starttls":
+		return TLSStartTLS, nil
+	case "starttls-required":
+		return TLSStartTLSRequired, nil
+	case "implicit":
+		return TLSImplicit, nil
+	default:
+		return TLSStartTLS, fmt.Errorf("invalid TLS mode %q, expecting starttls, starttls-required or implicit", value)
+	}
+}
+
+// TLSSettings tunes the verification of the SMTP server certificate.
+type TLSSettings struct {
+	// MinVersion is the minimum TLS version, ie: "1.2", the Go default being used when empty.
+	MinVersion string
+	// CABundle is the path of a PEM file of the certificate authorities trusted instead of the system ones, ie: for relays with a private CA.
+	CABundle string
+	// InsecureSkipVerify accepts any server certificate, only meant for internal relays.
+	InsecureSkipVerify bool
+}
+
+// NewTLSConfig returns the TLS configuration of the connections to the host, or nil when the settings keep the defaults.
+func NewTLSConfig(host string, settings TLSSettings) (*tls.Config, error) {
+	if settings == (TLSSettings{}) {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{ServerName: host, InsecureSkipVerify: settings.InsecureSkipVerify}
+	if settings.MinVersion != "" {
+		version, ok := tlsVersions[settings.MinVersion]
+		if !ok {
+			return nil, fmt.Errorf("invalid minimum TLS version %q, expecting 1.0, 1.1, 1.2 or 1.3", settings.MinVersion)
+		}
+		tlsConfig.MinVersion = version
+	}
+	if settings.CABundle != "" {
+		bundle, err := ioutil.ReadFile(settings.CABundle)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read CA bundle: %s", err.Error())
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(bundle) {
+			return nil, fmt.Errorf("CA bundle %q holds no PEM encoded certificate", settings.CABundle)
+		}
+	}
+
+	return tlsConfig, nil
+}
@@ -0,0 +1,69 @@
+package unsubscribe
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// tokenFieldSeparator separates the recipient, list id and timestamp inside the token payload.
+const tokenFieldSeparator = "\n"
+
+// Token holds the signed information identifying an unsubscribe request.
+type Token struct {
+	Recipient string
+	ListID    string
+	IssuedAt  time.Time
+}
+
+func sign(secret []byte, payload string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	_, _ = mac.Write([]byte(payload))
+
+	return mac.Sum(nil)
+}
+
+// GenerateToken returns a URL safe token signing the recipient, the list id and the issue time with an HMAC-SHA256 of the secret.
+func GenerateToken(secret []byte, recipient string, listID string, issuedAt time.Time) string {
+	payload := strings.Join([]string{recipient, listID, strconv.FormatInt(issuedAt.Unix(), 10)}, tokenFieldSeparator)
+
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." + base64.RawURLEncoding.EncodeToString(sign(secret, payload))
+}
+
+// VerifyToken checks the token signature and returns its content. Tokens older than maxAge are rejected, unless maxAge is 0.
+func VerifyToken(secret []byte, token string, maxAge time.Duration) (*Token, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("malformed unsubscribe token")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("malformed unsubscribe token payload: %s", err.Error())
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("malformed unsubscribe token signature: %s", err.Error())
+	}
+	if !hmac.Equal(signature, sign(secret, string(payload))) {
+		return nil, fmt.Errorf("invalid unsubscribe token signature")
+	}
+
+	fields := strings.Split(string(payload), tokenFieldSeparator)
+	if len(fields) != 3 {
+		return nil, fmt.Errorf("malformed unsubscribe token payload")
+	}
+	timestamp, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("malformed unsubscribe token timestamp: %s", err.Error())
+	}
+	issuedAt := time.Unix(timestamp, 0)
+	if maxAge > 0 && time.Since(issuedAt) > maxAge {
+		return nil, fmt.Errorf("unsubscribe token expired")
+	}
+
+	return &Token{Recipient: fields[0], ListID: fields[1], IssuedAt: issuedAt}, nil
+}
@@ -0,0 +1,99 @@
+package unsubscribe
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerifyToken(t *testing.T) {
+	secret := []byte("s3cr3t")
+	issuedAt := time.Now().Add(-time.Hour).Truncate(time.Second)
+	token := GenerateToken(secret, "jane@example.org", "newsletter", issuedAt)
+	parts := strings.Split(token, ".")
+	forged := base64.RawURLEncoding.EncodeToString([]byte("john@example.org\nnewsletter\n"+parts[0])) + "." + parts[1]
+
+	tests := []struct {
+		name   string
+		secret []byte
+		token  string
+		maxAge time.Duration
+		err    string
+	}{
+		{name: "accepts a valid token", secret: secret, token: token},
+		{name: "accepts a token younger than the maximum age", secret: secret, token: token, maxAge: 2 * time.Hour},
+		{name: "rejects a token older than the maximum age", secret: secret, token: token, maxAge: time.Minute, err: "unsubscribe token expired"},
+		{name: "rejects another secret", secret: []byte("other"), token: token, err: "invalid unsubscribe token signature"},
+		{name: "rejects a forged payload", secret: secret, token: forged, err: "invalid unsubscribe token signature"},
+		{name: "rejects a truncated signature", secret: secret, token: token[:len(token)-3], err: "invalid unsubscribe token signature"},
+		{name: "rejects a token without signature", secret: secret, token: parts[0], err: "malformed unsubscribe token"},
+		{name: "rejects a token with several signatures", secret: secret, token: token + "." + parts[1], err: "malformed unsubscribe token"},
+		{name: "rejects an undecodable payload", secret: secret, token: "not base64!." + parts[1], err: "malformed unsubscribe token payload"},
+		{name: "rejects an undecodable signature", secret: secret, token: parts[0] + ".not base64!", err: "malformed unsubscribe token signature"},
+		{name: "rejects an empty token", secret: secret, token: "", err: "malformed unsubscribe token"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			verified, err := VerifyToken(test.secret, test.token, test.maxAge)
+			if test.err != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), test.err) {
+					t.Fatalf("expected error %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+			if verified.Recipient != "jane@example.org" || verified.ListID != "newsletter" || !verified.IssuedAt.Equal(issuedAt) {
+				t.Errorf("expected the token of jane@example.org for newsletter issued at %s, got %+v", issuedAt, verified)
+			}
+		})
+	}
+}
+
+func TestVerifyTokenRejectsMalformedSignedPayloads(t *testing.T) {
+	secret := []byte("s3cr3t")
+	tests := []struct {
+		name    string
+		payload string
+		err     string
+	}{
+		{name: "missing field", payload: "jane@example.org\n1700000000", err: "malformed unsubscribe token payload"},
+		{name: "extra field", payload: "jane@example.org\nnewsletter\nweekly\n1700000000", err: "malformed unsubscribe token payload"},
+		{name: "invalid timestamp", payload: "jane@example.org\nnewsletter\nyesterday", err: "malformed unsubscribe token timestamp"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			token := base64.RawURLEncoding.EncodeToString([]byte(test.payload)) + "." + base64.RawURLEncoding.EncodeToString(sign(secret, test.payload))
+			if _, err := VerifyToken(secret, token, 0); err == nil || !strings.HasPrefix(err.Error(), test.err) {
+				t.Errorf("expected error %q, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestGenerateToken(t *testing.T) {
+	secret := []byte("s3cr3t")
+	issuedAt := time.Unix(1700000000, 0)
+
+	token := GenerateToken(secret, "jane+news@example.org", "list/42", issuedAt)
+	if strings.ContainsAny(token, "+/=") {
+		t.Errorf("expected a URL safe token, got %q", token)
+	}
+	if again := GenerateToken(secret, "jane+news@example.org", "list/42", issuedAt); again != token {
+		t.Errorf("expected the same token for the same content, got %q and %q", token, again)
+	}
+	for _, other := range []string{
+		GenerateToken(secret, "john@example.org", "list/42", issuedAt),
+		GenerateToken(secret, "jane+news@example.org", "list/43", issuedAt),
+		GenerateToken(secret, "jane+news@example.org", "list/42", issuedAt.Add(time.Second)),
+		GenerateToken([]byte("other"), "jane+news@example.org", "list/42", issuedAt),
+	} {
+		if other == token {
+			t.Errorf("expected a different token, got %q", other)
+		}
+	}
+}
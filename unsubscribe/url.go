@@ -0,0 +1,50 @@
+package unsubscribe
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"text/template"
+	"time"
+)
+
+// URLBuilder renders per-recipient unsubscribe URLs from a template such as "https://example.com/unsubscribe?token={{.Token}}".
+type URLBuilder struct {
+	secret      []byte
+	urlTemplate *template.Template
+}
+
+// urlContext is the data available to the unsubscribe URL template. Values are query escaped.
+type urlContext struct {
+	Token     string
+	Recipient string
+	ListID    string
+}
+
+// NewURLBuilder parses the URL template, signing the tokens with the secret.
+func NewURLBuilder(secret string, urlTemplate string) (*URLBuilder, error) {
+	if secret == "" {
+		return nil, fmt.Errorf("unsubscribe tokens require a secret")
+	}
+	tmpl, err := template.New("unsubscribeURL").Parse(urlTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse unsubscribe URL template: %s", err.Error())
+	}
+
+	return &URLBuilder{secret: []byte(secret), urlTemplate: tmpl}, nil
+}
+
+// Build returns the unsubscribe URL of the recipient for the list, with a freshly signed token.
+func (builder *URLBuilder) Build(recipient string, listID string) (string, error) {
+	var buffer bytes.Buffer
+	err := builder.urlTemplate.Execute(&buffer, urlContext{
+		Token:     url.QueryEscape(GenerateToken(builder.secret, recipient, listID, time.Now())),
+		Recipient: url.QueryEscape(recipient),
+		ListID:    url.QueryEscape(listID),
+	})
+	if err != nil {
+		return "", fmt.Errorf("unable to execute unsubscribe URL template: %s", err.Error())
+	}
+
+	return buffer.String(), nil
+}
@@ -0,0 +1,63 @@
+package unsubscribe
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestURLBuilderBuild(t *testing.T) {
+	builder, err := NewURLBuilder("s3cr3t", "https://example.com/unsubscribe?list={{.ListID}}&email={{.Recipient}}&token={{.Token}}")
+	if err != nil {
+		t.Fatalf("unable to instantiate builder: %s", err.Error())
+	}
+
+	built, err := builder.Build("jane+news@example.org", "news & offers")
+	if err != nil {
+		t.Fatalf("unable to build URL: %s", err.Error())
+	}
+	unsubscribeURL, err := url.Parse(built)
+	if err != nil {
+		t.Fatalf("expected a valid URL, got %q", built)
+	}
+	query := unsubscribeURL.Query()
+	if query.Get("email") != "jane+news@example.org" || query.Get("list") != "news & offers" {
+		t.Errorf("expected the recipient and list id to be query escaped, got %q", built)
+	}
+	token, err := VerifyToken([]byte("s3cr3t"), query.Get("token"), time.Minute)
+	if err != nil {
+		t.Fatalf("expected a valid token, got %q: %s", query.Get("token"), err.Error())
+	}
+	if token.Recipient != "jane+news@example.org" || token.ListID != "news & offers" {
+		t.Errorf("expected the token of jane+news@example.org for news & offers, got %+v", token)
+	}
+}
+
+func TestNewURLBuilder(t *testing.T) {
+	tests := []struct {
+		name        string
+		secret      string
+		urlTemplate string
+		err         string
+	}{
+		{name: "requires a secret", urlTemplate: "https://example.com/unsubscribe?token={{.Token}}", err: "unsubscribe tokens require a secret"},
+		{name: "rejects an invalid template", secret: "s3cr3t", urlTemplate: "https://example.com/unsubscribe?token={{.Token", err: "unable to parse unsubscribe URL template"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := NewURLBuilder(test.secret, test.urlTemplate); err == nil || !strings.HasPrefix(err.Error(), test.err) {
+				t.Errorf("expected error %q, got %v", test.err, err)
+			}
+		})
+	}
+
+	builder, err := NewURLBuilder("s3cr3t", "https://example.com/unsubscribe?token={{.Unknown}}")
+	if err != nil {
+		t.Fatalf("unable to instantiate builder: %s", err.Error())
+	}
+	if _, err := builder.Build("jane@example.org", "newsletter"); err == nil || !strings.HasPrefix(err.Error(), "unable to execute unsubscribe URL template") {
+		t.Errorf("expected an unknown field to fail, got %v", err)
+	}
+}
@@ -0,0 +1,98 @@
+package warmup
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// startKey is the key of the item holding the warmup start time, the other items being keyed by day.
+const startKey = "start"
+
+// DynamoDB keeps the warmup progression in a DynamoDB table whose partition key is the "id" string attribute. It implements the Store interface.
+type DynamoDB struct {
+	table          string
+	dynamoDBClient dynamodbiface.DynamoDBAPI
+
+	mu    sync.Mutex
+	start time.Time
+}
+
+// Start returns the recorded start time, recording now if the table has none. It is cached for the lambda lifetime.
+func (dynamoDBStore *DynamoDB) Start(now time.Time) (time.Time, error) {
+	dynamoDBStore.mu.Lock()
+	defer dynamoDBStore.mu.Unlock()
+	if !dynamoDBStore.start.IsZero() {
+		return dynamoDBStore.start, nil
+	}
+
+	_, err := dynamoDBStore.dynamoDBClient.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(dynamoDBStore.table),
+		Item: map[string]*dynamodb.AttributeValue{
+			"id":      {S: aws.String(startKey)},
+			"started": {N: aws.String(strconv.FormatInt(now.Unix(), 10))},
+		},
+		ConditionExpression: aws.String("attribute_not_exists(id)"),
+	})
+	if aerr, ok := err.(awserr.Error); err != nil && (!ok || aerr.Code() != dynamodb.ErrCodeConditionalCheckFailedException) {
+		return time.Time{}, fmt.Errorf("unable to record start in table %q: %s", dynamoDBStore.table, err.Error())
+	}
+
+	item, err := dynamoDBStore.dynamoDBClient.GetItem(&dynamodb.GetItemInput{
+		TableName:      aws.String(dynamoDBStore.table),
+		Key:            map[string]*dynamodb.AttributeValue{"id": {S: aws.String(startKey)}},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return time.Time{}, fmt.Errorf("unable to get start from table %q: %s", dynamoDBStore.table, err.Error())
+	}
+	started, ok := item.Item["started"]
+	if !ok || started.N == nil {
+		return time.Time{}, fmt.Errorf("table %q has no start time", dynamoDBStore.table)
+	}
+	seconds, err := strconv.ParseInt(*started.N, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid start time in table %q: %s", dynamoDBStore.table, err.Error())
+	}
+	dynamoDBStore.start = time.Unix(seconds, 0)
+
+	return dynamoDBStore.start, nil
+}
+
+// Reserve atomically increments the sends counter of the day, unless it already reached the limit.
+func (dynamoDBStore *DynamoDB) Reserve(day int, limit int) (bool, error) {
+	_, err := dynamoDBStore.dynamoDBClient.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName:           aws.String(dynamoDBStore.table),
+		Key:                 map[string]*dynamodb.AttributeValue{"id": {S: aws.String("day#" + strconv.Itoa(day))}},
+		UpdateExpression:    aws.String("ADD sends :one"),
+		ConditionExpression: aws.String("attribute_not_exists(sends) OR sends < :limit"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":one":   {N: aws.String("1")},
+			":limit": {N: aws.String(strconv.Itoa(limit))},
+		},
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("unable to count send in table %q: %s", dynamoDBStore.table, err.Error())
+	}
+
+	return true, nil
+}
+
+// NewDynamoDB instanciates a DynamoDB store using the given table.
+func NewDynamoDB(table string, region string) (*DynamoDB, error) {
+	sess, err := session.NewSession(&aws.Config{Region: aws.String(region)})
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to AWS: %s", err.Error())
+	}
+
+	return &DynamoDB{table: table, dynamoDBClient: dynamodb.New(sess)}, nil
+}
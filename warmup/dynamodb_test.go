@@ -0,0 +1,136 @@
+package warmup
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeDynamoDB keeps the items of a table in memory, evaluating the conditions used by the DynamoDB store.
+type fakeDynamoDB struct {
+	dynamodbiface.DynamoDBAPI
+	mu    sync.Mutex
+	items map[string]map[string]*dynamodb.AttributeValue
+	gets  int
+}
+
+func newFakeDynamoDB() *fakeDynamoDB {
+	return &fakeDynamoDB{items: make(map[string]map[string]*dynamodb.AttributeValue)}
+}
+
+func conditionFailed() error {
+	return awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", nil)
+}
+
+func (fake *fakeDynamoDB) PutItem(input *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	id := *input.Item["id"].S
+	if _, ok := fake.items[id]; ok && aws.StringValue(input.ConditionExpression) == "attribute_not_exists(id)" {
+		return nil, conditionFailed()
+	}
+	fake.items[id] = input.Item
+
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func (fake *fakeDynamoDB) GetItem(input *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	fake.gets++
+
+	return &dynamodb.GetItemOutput{Item: fake.items[*input.Key["id"].S]}, nil
+}
+
+func (fake *fakeDynamoDB) UpdateItem(input *dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	id := *input.Key["id"].S
+	item, ok := fake.items[id]
+	if !ok {
+		item = map[string]*dynamodb.AttributeValue{"id": {S: aws.String(id)}}
+		fake.items[id] = item
+	}
+	sends := 0
+	if item["sends"] != nil {
+		sends, _ = strconv.Atoi(*item["sends"].N)
+	}
+	limit, _ := strconv.Atoi(*input.ExpressionAttributeValues[":limit"].N)
+	if item["sends"] != nil && sends >= limit {
+		return nil, conditionFailed()
+	}
+	item["sends"] = &dynamodb.AttributeValue{N: aws.String(strconv.Itoa(sends + 1))}
+
+	return &dynamodb.UpdateItemOutput{}, nil
+}
+
+func TestDynamoDBStart(t *testing.T) {
+	fake := newFakeDynamoDB()
+	started := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+
+	first := &DynamoDB{table: "warmup", dynamoDBClient: fake}
+	if start, err := first.Start(started); err != nil || !start.Equal(started) {
+		t.Fatalf("expected the start to be recorded as %s, got %s, %v", started, start, err)
+	}
+	// Another lambda reads the start recorded by the first one.
+	second := &DynamoDB{table: "warmup", dynamoDBClient: fake}
+	if start, err := second.Start(started.Add(48 * time.Hour)); err != nil || !start.Equal(started) {
+		t.Errorf("expected the recorded start %s, got %s, %v", started, start, err)
+	}
+	// The start is then cached.
+	gets := fake.gets
+	if _, err := second.Start(started.Add(72 * time.Hour)); err != nil || fake.gets != gets {
+		t.Errorf("expected the start to be cached, got %d reads, %v", fake.gets-gets, err)
+	}
+}
+
+func TestDynamoDBReserve(t *testing.T) {
+	store := &DynamoDB{table: "warmup", dynamoDBClient: newFakeDynamoDB()}
+	tests := []struct {
+		day      int
+		limit    int
+		expected []bool
+	}{
+		{day: 0, limit: 2, expected: []bool{true, true, false, false}},
+		{day: 1, limit: 3, expected: []bool{true, true, true, false}},
+	}
+
+	for _, test := range tests {
+		for i, expected := range test.expected {
+			allowed, err := store.Reserve(test.day, test.limit)
+			if err != nil {
+				t.Fatalf("unable to reserve: %s", err.Error())
+			}
+			if allowed != expected {
+				t.Errorf("expected send %d of day %d with a cap of %d to be allowed: %t, got %t", i+1, test.day, test.limit, expected, allowed)
+			}
+		}
+	}
+}
+
+func TestRampWithDynamoDB(t *testing.T) {
+	started := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+	ramp := NewRamp(Schedule{1, 2}, &DynamoDB{table: "warmup", dynamoDBClient: newFakeDynamoDB()})
+
+	expected := []struct {
+		now     time.Time
+		allowed bool
+	}{
+		{now: started, allowed: true},
+		{now: started.Add(time.Hour), allowed: false},
+		{now: started.Add(24 * time.Hour), allowed: true},
+		{now: started.Add(25 * time.Hour), allowed: true},
+		{now: started.Add(26 * time.Hour), allowed: false},
+		{now: started.Add(48 * time.Hour), allowed: true},
+	}
+	for _, send := range expected {
+		if err := ramp.Reserve(send.now); (err == nil) != send.allowed {
+			t.Errorf("expected a send at %s to be allowed: %t, got %v", send.now, send.allowed, err)
+		}
+	}
+}
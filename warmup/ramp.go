@@ -0,0 +1,65 @@
+package warmup
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Schedule lists the maximum number of sends of each warmup day, the first one being the day the warmup started.
+type Schedule []int
+
+// UnmarshalText decodes a schedule from its JSON representation, so it can be read from an environment variable.
+func (schedule *Schedule) UnmarshalText(text []byte) error {
+	return json.Unmarshal(text, (*[]int)(schedule))
+}
+
+// Store interface should be implemented by any service keeping the warmup progression across invocations (DynamoDB, Redis... etc).
+type Store interface {
+	// Start should return the time the warmup started, recording now as the start if it was not started yet.
+	Start(now time.Time) (time.Time, error)
+	// Reserve should count one more send for the warmup day, or return false without counting it if the day already has limit sends.
+	Reserve(day int, limit int) (bool, error)
+}
+
+// Ramp caps the daily sends according to the warmup schedule. A nil Ramp never caps.
+type Ramp struct {
+	schedule Schedule
+	store    Store
+}
+
+// NewRamp instanciates a Ramp following the schedule, whose progression is kept by the store.
+func NewRamp(schedule Schedule, store Store) *Ramp {
+	return &Ramp{schedule: schedule, store: store}
+}
+
+// Reserve counts a send for the current warmup day, or returns an error if the day cap is reached. Once the schedule is over, sends are not capped anymore.
+func (ramp *Ramp) Reserve(now time.Time) error {
+	if ramp == nil {
+		return nil
+	}
+
+	start, err := ramp.store.Start(now)
+	if err != nil {
+		return fmt.Errorf("unable to get warmup start: %s", err.Error())
+	}
+	day := int(now.Sub(start) / (24 * time.Hour))
+	// A clock running a little behind the one which recorded the start is still on the first day.
+	if day < 0 {
+		day = 0
+	}
+	if day >= len(ramp.schedule) {
+		return nil
+	}
+
+	limit := ramp.schedule[day]
+	allowed, err := ramp.store.Reserve(day, limit)
+	if err != nil {
+		return fmt.Errorf("unable to count warmup sends: %s", err.Error())
+	}
+	if !allowed {
+		return fmt.Errorf("warmup day %d cap of %d sends is reached", day+1, limit)
+	}
+
+	return nil
+}
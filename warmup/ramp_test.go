@@ -0,0 +1,125 @@
+package warmup
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memoryStore keeps the warmup progression in memory.
+type memoryStore struct {
+	mu       sync.Mutex
+	start    time.Time
+	sends    map[int]int
+	startErr error
+}
+
+func (store *memoryStore) Start(now time.Time) (time.Time, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if store.startErr != nil {
+		return time.Time{}, store.startErr
+	}
+	if store.start.IsZero() {
+		store.start = now
+	}
+
+	return store.start, nil
+}
+
+func (store *memoryStore) Reserve(day int, limit int) (bool, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if store.sends == nil {
+		store.sends = make(map[int]int)
+	}
+	if store.sends[day] >= limit {
+		return false, nil
+	}
+	store.sends[day]++
+
+	return true, nil
+}
+
+func TestRampReserve(t *testing.T) {
+	start := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		now     time.Time
+		allowed int
+		err     string
+	}{
+		{name: "caps the first day", now: start, allowed: 2, err: "warmup day 1 cap of 2 sends is reached"},
+		{name: "caps the end of the first day", now: start.Add(24*time.Hour - time.Second), allowed: 2, err: "warmup day 1 cap of 2 sends is reached"},
+		{name: "caps the second day", now: start.Add(24 * time.Hour), allowed: 5, err: "warmup day 2 cap of 5 sends is reached"},
+		{name: "caps the last day", now: start.Add(2*24*time.Hour + time.Hour), allowed: 10, err: "warmup day 3 cap of 10 sends is reached"},
+		{name: "does not cap once the schedule is over", now: start.Add(3 * 24 * time.Hour), allowed: 50},
+		{name: "caps a clock behind the start as the first day", now: start.Add(-time.Minute), allowed: 2, err: "warmup day 1 cap of 2 sends is reached"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ramp := NewRamp(Schedule{2, 5, 10}, &memoryStore{start: start})
+			for i := 0; i < test.allowed; i++ {
+				if err := ramp.Reserve(test.now); err != nil {
+					t.Fatalf("expected send %d to be allowed, got %s", i+1, err.Error())
+				}
+			}
+			err := ramp.Reserve(test.now)
+			if test.err == "" {
+				if err != nil {
+					t.Errorf("expected no cap, got %s", err.Error())
+				}
+				return
+			}
+			if err == nil || err.Error() != test.err {
+				t.Errorf("expected error %q, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestRampReserveCountsEachDayApart(t *testing.T) {
+	now := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+	store := &memoryStore{}
+	ramp := NewRamp(Schedule{1, 1}, store)
+
+	if err := ramp.Reserve(now); err != nil {
+		t.Fatalf("expected the first send to record the start, got %s", err.Error())
+	}
+	if !store.start.Equal(now) {
+		t.Errorf("expected the warmup to start at %s, got %s", now, store.start)
+	}
+	if err := ramp.Reserve(now.Add(time.Hour)); err == nil {
+		t.Errorf("expected the first day to be capped")
+	}
+	if err := ramp.Reserve(now.Add(25 * time.Hour)); err != nil {
+		t.Errorf("expected the second day to have its own cap, got %s", err.Error())
+	}
+}
+
+func TestRampReserveStoreFailure(t *testing.T) {
+	ramp := NewRamp(Schedule{1}, &memoryStore{startErr: errors.New("table is unavailable")})
+	if err := ramp.Reserve(time.Now()); err == nil || !strings.Contains(err.Error(), "unable to get warmup start: table is unavailable") {
+		t.Errorf("expected the store failure, got %v", err)
+	}
+}
+
+func TestNilRamp(t *testing.T) {
+	var ramp *Ramp
+	if err := ramp.Reserve(time.Now()); err != nil {
+		t.Errorf("expected a nil ramp never to cap, got %s", err.Error())
+	}
+}
+
+func TestScheduleUnmarshalText(t *testing.T) {
+	var schedule Schedule
+	if err := schedule.UnmarshalText([]byte("[50, 100, 500]")); err != nil || len(schedule) != 3 || schedule[2] != 500 {
+		t.Errorf("expected the schedule [50 100 500], got %v, %v", schedule, err)
+	}
+	if err := schedule.UnmarshalText([]byte("50,100")); err == nil {
+		t.Errorf("expected an invalid schedule to be rejected")
+	}
+}